@@ -0,0 +1,268 @@
+// Command loadtest drives a running server's realtime endpoint with many
+// WebSocket connections spread across many note rooms. One connection per
+// room sends edits at a fixed rate and every other member measures how long
+// each edit took to arrive, so the report shows broadcast latency and any
+// deliveries lost to slow-consumer drops.
+//
+// It creates its rooms as notes owned by the account behind -token and
+// deletes them afterwards:
+//
+//	QUANTA_TOKEN=... go run ./cmd/loadtest -rooms 200 -clients 5000 -duration 1m
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quanta/pkg/client"
+
+	"github.com/fasthttp/websocket"
+)
+
+func main() {
+	server := flag.String("server", envOr("QUANTA_SERVER", "http://localhost:3000"), "API base URL")
+	token := flag.String("token", os.Getenv("QUANTA_TOKEN"), "JWT of the account that owns the test notes (default $QUANTA_TOKEN)")
+	rooms := flag.Int("rooms", 100, "number of note rooms")
+	clients := flag.Int("clients", 1000, "total WebSocket connections, spread evenly across rooms")
+	rate := flag.Float64("rate", 2, "edits per second sent in each room")
+	duration := flag.Duration("duration", 30*time.Second, "how long to send edits once everyone is connected")
+	dialConcurrency := flag.Int("dial-concurrency", 50, "connections opened at once during ramp-up")
+	keep := flag.Bool("keep", false, "keep the test notes instead of deleting them")
+	flag.Parse()
+
+	if *token == "" {
+		log.Fatal("a token is required; pass -token or set QUANTA_TOKEN")
+	}
+	if *rooms <= 0 || *clients < 2*(*rooms) || *rate <= 0 {
+		log.Fatal("need positive -rooms and -rate, and at least two -clients per room")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	c := client.New(*server, *token)
+	noteIDs, err := createRooms(ctx, c, *rooms)
+	if !*keep {
+		defer deleteRooms(c, noteIDs)
+	}
+	if err != nil {
+		log.Printf("creating rooms: %v", err)
+		return
+	}
+
+	r := &run{start: time.Now()}
+	log.Printf("Opening %d connections across %d rooms", *clients, *rooms)
+	members := r.connect(ctx, c, noteIDs, *clients, *dialConcurrency)
+	log.Printf("Connected %d, failed %d in %s", r.connected.Load(), r.dialFailures.Load(), time.Since(r.start).Round(time.Millisecond))
+
+	sendCtx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+	r.start = time.Now()
+
+	var readers sync.WaitGroup
+	for _, room := range members {
+		for _, conn := range room[1:] {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				r.read(sendCtx, conn)
+			}()
+		}
+	}
+
+	var writers sync.WaitGroup
+	interval := time.Duration(float64(time.Second) / *rate)
+	for _, room := range members {
+		if len(room) < 2 {
+			continue
+		}
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			r.write(sendCtx, room[0], len(room)-1, interval)
+		}()
+	}
+
+	writers.Wait()
+	elapsed := time.Since(r.start)
+	// Give edits still in flight a moment to arrive
+	time.Sleep(time.Second)
+	for _, room := range members {
+		for _, conn := range room {
+			_ = conn.Close()
+		}
+	}
+	readers.Wait()
+
+	r.report(os.Stdout, elapsed)
+}
+
+// run collects the counters and latencies of one load test
+type run struct {
+	start time.Time
+
+	connected    atomic.Int64
+	dialFailures atomic.Int64
+	disconnects  atomic.Int64
+	sent         atomic.Int64
+	expected     atomic.Int64
+	received     atomic.Int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+// connect opens clients connections, assigning them to rooms round-robin,
+// and returns each room's connections
+func (r *run) connect(ctx context.Context, c *client.Client, noteIDs []string, clients, concurrency int) [][]*websocket.Conn {
+	members := make([][]*websocket.Conn, len(noteIDs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for i := range clients {
+		room := i % len(noteIDs)
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			conn, err := c.Dial(ctx, noteIDs[room], 0)
+			if err != nil {
+				if r.dialFailures.Add(1) <= 5 {
+					log.Printf("dial: %v", err)
+				}
+				return
+			}
+			r.connected.Add(1)
+			mu.Lock()
+			members[room] = append(members[room], conn)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return members
+}
+
+// write sends an edit carrying its send time every interval until ctx is done
+func (r *run) write(ctx context.Context, conn *websocket.Conn, audience int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		edit := map[string]string{"type": "edit", "content": strconv.FormatInt(time.Now().UnixNano(), 10)}
+		if err := conn.WriteJSON(edit); err != nil {
+			r.disconnects.Add(1)
+			return
+		}
+		r.sent.Add(1)
+		r.expected.Add(int64(audience))
+	}
+}
+
+// read records the latency of every edit received until the connection is
+// closed. Errors while ctx is still live count as disconnects.
+func (r *run) read(ctx context.Context, conn *websocket.Conn) {
+	var latencies []time.Duration
+	defer func() {
+		r.mu.Lock()
+		r.latencies = append(r.latencies, latencies...)
+		r.mu.Unlock()
+	}()
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil {
+				r.disconnects.Add(1)
+			}
+			return
+		}
+		var msg struct {
+			Type    string `json:"type"`
+			Content string `json:"content"`
+		}
+		if json.Unmarshal(raw, &msg) != nil || msg.Type != "edit" {
+			continue
+		}
+		sentAt, err := strconv.ParseInt(msg.Content, 10, 64)
+		if err != nil {
+			continue
+		}
+		latencies = append(latencies, time.Since(time.Unix(0, sentAt)))
+		r.received.Add(1)
+	}
+}
+
+// report prints the results
+func (r *run) report(w io.Writer, elapsed time.Duration) {
+	slices.Sort(r.latencies)
+	at := func(p float64) time.Duration {
+		if len(r.latencies) == 0 {
+			return 0
+		}
+		return r.latencies[int(p*float64(len(r.latencies)-1))]
+	}
+
+	expected := r.expected.Load()
+	delivered := 100.0
+	if expected > 0 {
+		delivered = 100 * float64(r.received.Load()) / float64(expected)
+	}
+
+	fmt.Fprintf(w, "connections   %d (%d failed to connect, %d dropped)\n", r.connected.Load(), r.dialFailures.Load(), r.disconnects.Load())
+	fmt.Fprintf(w, "edits sent    %d (%.0f/s)\n", r.sent.Load(), float64(r.sent.Load())/elapsed.Seconds())
+	fmt.Fprintf(w, "deliveries    %d of %d (%.2f%%, %.0f/s)\n", r.received.Load(), expected, delivered, float64(r.received.Load())/elapsed.Seconds())
+	fmt.Fprintf(w, "latency       p50 %s  p90 %s  p99 %s  max %s\n",
+		at(0.50).Round(time.Microsecond), at(0.90).Round(time.Microsecond),
+		at(0.99).Round(time.Microsecond), at(1).Round(time.Microsecond))
+}
+
+// createRooms creates the notes whose rooms the test runs in. On failure it
+// returns the notes created so far so they can be cleaned up.
+func createRooms(ctx context.Context, c *client.Client, n int) ([]string, error) {
+	ids := make([]string, 0, n)
+	for i := range n {
+		id, err := c.CreateNote(ctx, fmt.Sprintf("loadtest room %d", i+1), "")
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// deleteRooms removes the test notes
+func deleteRooms(c *client.Client, ids []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for _, id := range ids {
+		if err := c.DeleteNote(ctx, id); err != nil {
+			log.Printf("deleting note %s: %v", id, err)
+		}
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
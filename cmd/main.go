@@ -3,16 +3,54 @@
 package main
 
 import (
+	"context"
 	"log"
-	"os"
+	"net"
+	"time"
 
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/backup"
+	"quanta/internal/calendar"
+	"quanta/internal/changelog"
+	"quanta/internal/config"
 	"quanta/internal/db"
+	"quanta/internal/docs"
+	"quanta/internal/features"
+	"quanta/internal/gitsync"
+	"quanta/internal/grpcapi"
+	"quanta/internal/handlers/account"
+	"quanta/internal/handlers/admin"
+	"quanta/internal/handlers/attachments"
 	"quanta/internal/handlers/auth"
+	"quanta/internal/handlers/health"
+	"quanta/internal/handlers/integrations"
 	"quanta/internal/handlers/notes"
+	"quanta/internal/handlers/workspaces"
+	"quanta/internal/idempotency"
+	"quanta/internal/imports"
+	"quanta/internal/maintenance"
 	"quanta/internal/middleware"
+	"quanta/internal/models"
+	"quanta/internal/notifications"
+	"quanta/internal/oidc"
+	"quanta/internal/printing"
+	"quanta/internal/privacy"
+	"quanta/internal/processors"
+	"quanta/internal/quota"
 	"quanta/internal/realtime"
+	"quanta/internal/recordings"
+	"quanta/internal/reminders"
+	"quanta/internal/retention"
+	"quanta/internal/revisions"
+	"quanta/internal/scanner"
+	"quanta/internal/storage"
+	"quanta/internal/tracing"
+	"quanta/pkg"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/joho/godotenv"
 )
 
@@ -22,30 +60,487 @@ func main() {
 		log.Println("No .env file found, continuing...")
 	}
 
-	db.Connect()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Endpoint:    cfg.TracingEndpoint,
+		ServiceName: cfg.TracingServiceName,
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	conn, err := db.Connect(context.Background(), db.Options{
+		Driver: cfg.DBDriver,
+		DSN:    cfg.DatabaseURL,
+		Pool: db.PoolConfig{
+			MaxOpenConns:    cfg.DBMaxOpenConns,
+			MaxIdleConns:    cfg.DBMaxIdleConns,
+			ConnMaxLifetime: cfg.DBConnMaxLifetime,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+
+	store, err := storage.Open(context.Background(), storage.Options{
+		Driver:     cfg.StorageDriver,
+		LocalDir:   cfg.StorageLocalDir,
+		S3Bucket:   cfg.S3Bucket,
+		S3Endpoint: cfg.S3Endpoint,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	trustedProxies := make([]string, 0, len(cfg.TrustedProxies))
+	for _, prefix := range cfg.TrustedProxies {
+		trustedProxies = append(trustedProxies, prefix.String())
+	}
+	app := fiber.New(fiber.Config{
+		// Leave headroom above the largest upload for multipart framing
+		BodyLimit:    max(attachments.MaxUploadSize, imports.MaxImportSize) + 1<<20,
+		ErrorHandler: apperr.Handler,
+		// Only take the client address from ProxyHeader on requests that
+		// come through a trusted proxy
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          trustedProxies,
+		ProxyHeader:             cfg.ProxyHeader,
+		EnableIPValidation:      true,
+	})
+
+	pkg.SetArgon2Params(pkg.Argon2Params{
+		Version:   cfg.PasswordHashVersion,
+		Time:      uint32(cfg.Argon2Time),
+		MemoryKiB: uint32(cfg.Argon2MemoryKiB),
+		Threads:   uint8(cfg.Argon2Threads),
+	})
+	// Time a hash so operators can tune the parameters to their hardware
+	took, err := pkg.TimeHash()
+	if err != nil {
+		log.Fatalf("Password hashing self-test failed: %v", err)
+	}
+	if warning := pkg.HashCostWarning(took); warning != "" {
+		log.Printf("Warning: %s", warning)
+	} else {
+		log.Printf("Password hashing takes %s", took.Round(time.Millisecond))
+	}
+
+	noteLimits := models.NoteLimits{
+		MaxTitleLength:  cfg.NoteMaxTitleLength,
+		MaxContentBytes: cfg.NoteMaxContentBytes,
+	}
+	quotas := quota.Limits{
+		UserBytes:      int64(cfg.QuotaUserBytes),
+		WorkspaceBytes: int64(cfg.QuotaWorkspaceBytes),
+	}
+
+	auditLog := audit.NewLogger(conn)
+	auditHandler := audit.NewHandler(conn)
+	sessionRecorder := recordings.NewRecorder(conn)
+	// Maintenance mode announces itself through the realtime handler, so
+	// it is created once that exists
+	var maintenanceMode *maintenance.Mode
+	realtimeHandler := realtime.NewHandler(conn, realtime.Options{
+		Heartbeat: realtime.HeartbeatConfig{
+			PingInterval:   cfg.WSPingInterval,
+			MaxMissedPongs: cfg.WSMaxMissedPongs,
+		},
+		QueryTimeout:       cfg.QueryTimeout,
+		Limits:             noteLimits,
+		HistorySize:        cfg.WSHistorySize,
+		MaxUserConnections: cfg.WSMaxUserConnections,
+		MaxRoomConnections: cfg.WSMaxRoomConnections,
+		ResumeGrace:        cfg.WSResumeGrace,
+		CoalesceInterval:   cfg.WSCoalesceInterval,
+		RoomIdleTimeout:    cfg.WSRoomIdleTimeout,
+		DeletedGrace:       cfg.WSDeletedGrace,
+		EventLog: realtime.EventLogConfig{
+			SampleRatio: cfg.WSEventLogSampleRatio,
+			NoteID:      cfg.WSEventLogNote,
+			UserID:      cfg.WSEventLogUser,
+		},
+		Recorder: sessionRecorder,
+		ReadOnly: func() bool { return maintenanceMode.ReadOnly() },
+	})
+	activityHandler := activity.NewHandler(conn)
+	var contentProcessors []processors.Processor
+	for _, name := range cfg.ContentProcessors {
+		if name == "languagetool" {
+			contentProcessors = append(contentProcessors, processors.NewLanguageTool(cfg.LanguageToolURL))
+		}
+	}
+	var pipeline notes.ContentProcessors
+	if len(contentProcessors) > 0 {
+		p := processors.NewPipeline(conn, contentProcessors, processors.Options{Delay: cfg.ContentProcessorDelay})
+		// Run edited notes through the content processors
+		go p.Run(nil)
+		pipeline = p
+	}
+	revisionArchive := revisions.New(conn, store, revisions.Options{})
+	notificationsHandler := notifications.NewHandler(conn)
+	notesHandler := notes.NewHandler(conn, activity.NewRecorder(conn, realtimeHandler), realtimeHandler, pipeline, noteLimits, quotas, revisionArchive, notificationsHandler, db.DialectFor(cfg.DBDriver))
+	accountHandler := account.NewHandler(conn, realtimeHandler, auditLog)
+	adminHandler := admin.NewHandler(conn, realtimeHandler, auditLog)
+	mailer := notifications.NewMailer(cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUsername, cfg.SMTPPassword)
+	sso := auth.SSOConfig{
+		BaseURL:             cfg.AppURL,
+		Enforced:            cfg.OIDCEnforced,
+		JITProvisioning:     cfg.OIDCJITProvisioning,
+		EmailClaim:          cfg.OIDCEmailClaim,
+		NameClaim:           cfg.OIDCNameClaim,
+		GroupsClaim:         cfg.OIDCGroupsClaim,
+		Groups:              cfg.OIDCGroupWorkspaces,
+		AssumeEmailVerified: cfg.OIDCAssumeEmailVerified,
+	}
+	if cfg.OIDCIssuer != "" {
+		sso.Provider = oidc.New(oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+		})
+		if cfg.OIDCEnforced {
+			log.Printf("Password login is disabled, users sign in through %s (OIDC_ENFORCED)", cfg.OIDCIssuer)
+		}
+	}
+	authHandler := auth.NewHandler(conn, &auth.JWTService{}, cfg.JWTKeys, auditLog, mailer, auth.EmailConfig{
+		BaseURL: cfg.AppURL,
+	}, sso)
+	workspacesHandler := workspaces.NewHandler(conn, mailer, workspaces.InviteConfig{
+		BaseURL: cfg.AppURL,
+		TTL:     cfg.InviteTTL,
+	}, auditLog, realtimeHandler)
+	var virusScanner attachments.Scanner
+	if cfg.AttachmentScanner == "clamav" {
+		virusScanner = scanner.NewClamAV(cfg.ClamAVAddr)
+	}
+	attachmentsHandler := attachments.NewHandler(conn, store, quotas, virusScanner, notificationsHandler)
+	rateLimiter := middleware.NewRateLimiter(cfg.RateLimit, cfg.RateLimitWindow)
+	idempotencyKeys := idempotency.New(conn)
+	usageHandler := quota.NewHandler(conn, quotas, rateLimiter)
+	remindersHandler := reminders.NewHandler(conn)
+	calendarHandler := calendar.NewHandler(conn, cfg.JWTKeys)
+	gitSyncHandler := gitsync.NewHandler(conn, auditLog)
+	importsHandler := imports.NewHandler(conn, notesHandler, attachmentsHandler, noteLimits)
+	backupHandler := backup.NewHandler(conn, store, cfg.BackupKey, auditLog)
+	retentionHandler := retention.NewHandler(conn, auditLog)
+	recordingsHandler := recordings.NewHandler(conn, auditLog)
+	changesHandler := changelog.NewHandler(conn)
+	privacyHandler := privacy.NewHandler(conn, store, realtimeHandler, auditLog)
+	var pdfRenderer printing.Renderer
+	if cfg.PDFRenderer == "gotenberg" {
+		pdfRenderer = printing.NewGotenberg(cfg.GotenbergURL)
+	}
+	printingHandler := printing.NewHandler(conn, store, pdfRenderer, cfg.NoteHTMLPolicy)
+	featureFlags := features.New(conn)
+	featuresHandler := features.NewHandler(conn, featureFlags, auditLog)
+	maintenanceMode = maintenance.New(conn, realtimeHandler)
+	maintenanceHandler := maintenance.NewHandler(conn, maintenanceMode, auditLog)
+	integrationsHandler := integrations.NewHandler(conn, auditLog)
+	healthHandler := health.NewHandler(conn, health.Options{
+		Dialect:      db.DialectFor(cfg.DBDriver),
+		Strict:       cfg.HealthStrict,
+		CheckTimeout: cfg.HealthCheckTimeout,
+	})
+	docsHandler, err := docs.NewHandler()
+	if err != nil {
+		log.Fatalf("Failed to build API docs: %v", err)
+	}
+
+	// The background workers below write nothing while maintenance mode
+	// is on
+
+	// Permanently remove soft-deleted accounts once their grace period ends
+	go account.StartPurger(conn, account.DefaultPurgeGracePeriod, time.Hour, maintenanceMode.ReadOnly, nil)
+
+	// Delete data exports once they can no longer be downloaded
+	go privacy.StartPurger(conn, store, time.Hour, maintenanceMode.ReadOnly, nil)
+
+	// Delete PDFs rendered in the background once they expire
+	go printing.StartPurger(conn, store, time.Hour, maintenanceMode.ReadOnly, nil)
+
+	// Forget the responses kept for idempotency keys once retries are over
+	go idempotencyKeys.StartPurger(idempotency.DefaultPurgeInterval, maintenanceMode.ReadOnly, nil)
 
-	app := fiber.New()
+	// Email unread notifications once a day
+	go notifications.StartDigestWorker(conn, mailer, notifications.DefaultDigestInterval, maintenanceMode.ReadOnly, nil)
 
-	authHandler := auth.NewHandler(db.DB, &auth.JWTService{})
-	notesHandler := notes.NewHandler(db.DB)
+	// Email the daily and weekly activity digests users asked for
+	go notifications.StartActivityDigestWorker(conn, mailer, notifications.DefaultActivityDigestPoll, maintenanceMode.ReadOnly, nil)
+
+	// Push the notes of users with Git sync set up when they change
+	go gitsync.StartWorker(conn, gitsync.DefaultInterval, maintenanceMode.ReadOnly, nil)
+
+	// Back up the database to storage and prune old backups
+	go backup.StartScheduler(backupHandler, cfg.BackupInterval, cfg.BackupKeep, maintenanceMode.ReadOnly, nil)
+
+	// Archive, delete and trim notes as workspaces' retention policies say
+	go retention.StartWorker(conn, retention.DefaultInterval, maintenanceMode.ReadOnly, nil)
+
+	// Write the edits of recorded collaboration sessions
+	go sessionRecorder.Run(nil)
+
+	// Move large notes' older revisions to storage and delete unused chunks
+	go revisions.StartCompactor(revisionArchive, revisions.DefaultInterval, maintenanceMode.ReadOnly, nil)
+
+	// Scan uploads for malware and quarantine infected ones
+	go attachmentsHandler.StartScanner(attachments.DefaultScanInterval, maintenanceMode.ReadOnly, nil)
+
+	// Delete attachment blobs no attachment refers to any more
+	go attachmentsHandler.StartCollector(attachments.DefaultCollectInterval, maintenanceMode.ReadOnly, nil)
+
+	// Fire due note reminders
+	go reminders.StartScheduler(conn, notificationsHandler, mailer, reminders.DefaultPollInterval, maintenanceMode.ReadOnly, nil)
+
+	// Save idle rooms' documents, free their buffers and close zombie connections
+	go realtimeHandler.StartJanitor(realtime.DefaultJanitorInterval, nil)
+
+	// Pick up feature flags changed on other instances
+	if err := featureFlags.Reload(context.Background()); err != nil {
+		log.Printf("Error loading feature flags: %v", err)
+	}
+	go featureFlags.StartReloader(features.DefaultRefresh, nil)
+
+	// Follow maintenance mode turned on or off on other instances
+	if err := maintenanceMode.Reload(context.Background()); err != nil {
+		log.Printf("Error loading maintenance mode: %v", err)
+	}
+	go maintenanceMode.StartReloader(maintenance.DefaultRefresh, nil)
+
+	// Pick up ranges added to the ip_denylist table without a restart
+	denylist := middleware.NewIPDenylist(conn, cfg.IPDenylist)
+	if err := denylist.Reload(context.Background()); err != nil {
+		log.Printf("Error loading IP denylist: %v", err)
+	}
+	go denylist.StartReloader(cfg.IPDenylistRefresh, nil)
+
+	app.Use(requestid.New())
+	app.Use(middleware.Recover())
+	app.Use(denylist.Handler())
+	app.Use(middleware.Tracing())
+	app.Use(middleware.SecurityHeaders(middleware.SecurityConfig{
+		HSTSMaxAge:            cfg.HSTSMaxAge,
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+	}))
+	app.Use(middleware.CORS(middleware.CORSConfig{
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           cfg.CORSMaxAge,
+	}))
+	// Administrators can still log in and end maintenance while writes are
+	// refused
+	app.Use(maintenanceMode.Handler("/login", "/admin/maintenance"))
+	if cfg.DebugHTTPTrace {
+		log.Println("Logging redacted HTTP requests and responses (DEBUG_HTTP_TRACE)")
+		app.Use(middleware.HTTPTrace(middleware.HTTPTraceConfig{
+			SampleRatio: cfg.DebugHTTPTraceSampleRatio,
+			UserID:      cfg.DebugHTTPTraceUser,
+		}))
+	}
+	app.Use(middleware.Timeout(cfg.QueryTimeout))
+
+	app.Get("/healthz", healthHandler.Liveness)
+	app.Get("/readyz", healthHandler.Readiness)
+	app.Get("/startupz", healthHandler.Startup)
+	app.Get("/openapi.json", docsHandler.Spec)
+	app.Get("/docs", docsHandler.UI)
+	app.Get("/maintenance", maintenanceHandler.GetMode)
+
+	requireAuth := middleware.Protected(conn, cfg.JWTKeys)
+	// Every authenticated route counts against the user's rate limit, so
+	// rateLimit follows the middleware that authenticates them
+	rateLimit := rateLimiter.Handler()
+	// Routes that create things replay their response to retries sent
+	// with the same Idempotency-Key
+	idempotent := idempotencyKeys.Handler()
 
 	app.Post("/signup", authHandler.SignUp)
 	app.Post("/login", authHandler.Login)
+	app.Get("/.well-known/jwks.json", authHandler.JWKS)
+	app.Get("/auth/oidc/login", authHandler.SSOLogin)
+	app.Get("/auth/oidc/callback", authHandler.SSOCallback)
+
+	// Calendar apps authenticate with the token in the feed's URL
+	app.Get("/me/calendar.ics", calendarHandler.GetCalendar)
+
+	me := app.Group("/me", requireAuth, rateLimit)
+	me.Get("/", accountHandler.GetProfile)
+	me.Patch("/", accountHandler.UpdateProfile)
+	me.Delete("/", accountHandler.DeleteAccount)
+	me.Post("/erasure", privacyHandler.Erase)
+	me.Post("/data-export", privacyHandler.CreateExport)
+	me.Get("/data-export/:id", privacyHandler.GetExport)
+	me.Get("/data-export/:id/download", privacyHandler.DownloadExport)
+	me.Get("/preferences", accountHandler.GetPreferences)
+	me.Get("/features", featuresHandler.GetMyFeatures)
+	me.Patch("/preferences", accountHandler.UpdatePreferences)
+	me.Get("/git-sync", gitSyncHandler.GetGitSync)
+	me.Put("/git-sync", gitSyncHandler.UpdateGitSync)
+	me.Delete("/git-sync", gitSyncHandler.DeleteGitSync)
+	me.Post("/password", authHandler.ChangePassword)
+	me.Post("/email", authHandler.ChangeEmail)
+	me.Post("/email/verify", authHandler.VerifyEmail)
+	me.Get("/sessions", authHandler.ListSessions)
+	me.Delete("/sessions/:id", authHandler.DeleteSession)
+	me.Get("/security-events", auditHandler.GetMyEvents)
+	me.Get("/activity", activityHandler.GetMyActivity)
+	me.Get("/invitations", workspacesHandler.MyInvitations)
+	me.Get("/usage", usageHandler.GetUsage)
+	me.Get("/limits", usageHandler.GetLimits)
+	me.Get("/stats", notesHandler.GetStats)
+	me.Get("/calendar", calendarHandler.GetFeed)
+	me.Get("/api-keys", integrationsHandler.ListAPIKeys)
+	me.Post("/api-keys", integrationsHandler.CreateAPIKey)
+	me.Delete("/api-keys/:id", integrationsHandler.DeleteAPIKey)
 
-	note := app.Group("/notes", middleware.Protected())
+	app.Get("/integrations/triggers", integrationsHandler.ListTriggers)
+
+	// Integrations can call the /notes routes with a scoped API key
+	note := app.Group("/notes", middleware.ProtectedOrAPIKey(conn, cfg.JWTKeys, middleware.NotesScope), rateLimit)
 	note.Get("/", notesHandler.GetNotes)
-	note.Post("/", notesHandler.CreateNote)
+	note.Post("/", idempotent, notesHandler.CreateNote)
+	note.Get("/recent", notesHandler.GetRecent)
+	note.Get("/favorites", notesHandler.GetFavorites)
+	note.Get("/duplicates", notesHandler.GetDuplicates)
+	note.Get("/changes", changesHandler.GetChanges)
+	note.Post("/merge", notesHandler.MergeNotes)
+	note.Get("/:id", notesHandler.GetNote)
 	note.Put("/:id", notesHandler.UpdateNote)
+	note.Patch("/:id", notesHandler.PatchNote)
 	note.Delete("/:id", notesHandler.DeleteNote)
+	note.Patch("/:id/metadata", notesHandler.PatchMetadata)
+	note.Post("/:id/pin", notesHandler.PinNote)
+	note.Post("/:id/unpin", notesHandler.UnpinNote)
+	note.Post("/:id/archive", notesHandler.ArchiveNote)
+	note.Post("/:id/unarchive", notesHandler.UnarchiveNote)
+	note.Post("/:id/favorite", notesHandler.ToggleFavorite)
+	note.Post("/:id/lock", notesHandler.LockNote)
+	note.Post("/:id/unlock", notesHandler.UnlockNote)
+	note.Get("/:id/receipts", notesHandler.GetReceipts)
+	note.Post("/:id/receipts", notesHandler.MarkRead)
+	note.Get("/:id/diff", notesHandler.GetDiff)
+	note.Get("/:id/state", notesHandler.GetState)
+	note.Post("/:id/updates", notesHandler.ApplyUpdates)
+	note.Get("/:id/suggestions", notesHandler.GetSuggestions)
+	note.Get("/:id/collaborators", notesHandler.GetCollaborators)
+	note.Put("/:id/collaborators/:userId", notesHandler.SetRole)
+	note.Get("/:id/keys", notesHandler.GetKeys)
+	note.Put("/:id/keys/:userId", notesHandler.ShareKey)
+	note.Get("/:id/presence", realtimeHandler.GetPresence)
+	note.Get("/:id/activity", activityHandler.GetNoteActivity)
+	note.Get("/:id/sessions", recordingsHandler.ListSessions)
+	note.Get("/:id/sessions/:sessionID/replay", recordingsHandler.ReplaySession)
+	note.Post("/:id/attachments", attachmentsHandler.UploadAttachment)
+	note.Get("/:id/pdf", printingHandler.GetPDF)
+	note.Get("/:id/pdf/:pdfId", printingHandler.GetPDFStatus)
+	note.Get("/:id/pdf/:pdfId/download", printingHandler.DownloadPDF)
+	note.Post("/:id/reminders", remindersHandler.CreateReminder)
+	app.Post("/sync", requireAuth, rateLimit, notesHandler.Sync)
+
+	workspace := app.Group("/workspaces", requireAuth, rateLimit)
+	workspace.Get("/", workspacesHandler.ListWorkspaces)
+	workspace.Post("/", workspacesHandler.CreateWorkspace)
+	workspace.Get("/:id", workspacesHandler.GetWorkspace)
+	workspace.Delete("/:id", workspacesHandler.DeleteWorkspace)
+	workspace.Get("/:id/notes", notesHandler.GetWorkspaceNotes)
+	workspace.Post("/:id/notes", idempotent, notesHandler.CreateWorkspaceNote)
+	workspace.Get("/:id/search", notesHandler.SearchWorkspace)
+	workspace.Patch("/:id/members/:userId", workspacesHandler.UpdateMember)
+	workspace.Delete("/:id/members/:userId", workspacesHandler.RemoveMember)
+	workspace.Get("/:id/invites", workspacesHandler.ListInvitations)
+	workspace.Post("/:id/invites", workspacesHandler.CreateInvitation)
+	workspace.Delete("/:id/invites/:invitationId", workspacesHandler.RevokeInvitation)
+	workspace.Get("/:id/retention", retentionHandler.GetPolicy)
+	workspace.Put("/:id/retention", retentionHandler.UpdatePolicy)
+	workspace.Post("/:id/retention/preview", retentionHandler.PreviewPolicy)
+	workspace.Get("/:id/recording", recordingsHandler.GetSettings)
+	workspace.Put("/:id/recording", recordingsHandler.UpdateSettings)
+
+	invitation := app.Group("/invitations", requireAuth, rateLimit)
+	invitation.Post("/:id/accept", workspacesHandler.AcceptInvitation)
+	invitation.Post("/:id/decline", workspacesHandler.DeclineInvitation)
+
+	// Invitation links can be previewed before logging in or signing up
+	app.Get("/invites/:token", workspacesHandler.GetInvite)
+	app.Post("/invites/:token/accept", requireAuth, rateLimit, workspacesHandler.AcceptInvite)
 
-	// WebSocket routes with authentication
-	ws := app.Group("/ws", middleware.Protected())
-	ws.Get("/notes/:id", realtime.HandleWebSocket)
+	attachment := app.Group("/attachments", requireAuth, rateLimit)
+	attachment.Get("/:id", attachmentsHandler.GetAttachment)
+	attachment.Delete("/:id", attachmentsHandler.DeleteAttachment)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
+	task := app.Group("/tasks", requireAuth, rateLimit)
+	task.Get("/", notesHandler.GetTasks)
+	task.Patch("/:id", notesHandler.UpdateTask)
+
+	imp := app.Group("/imports", requireAuth, rateLimit)
+	imp.Post("/", idempotent, importsHandler.CreateImport)
+	imp.Get("/:id", importsHandler.GetImport)
+
+	reminder := app.Group("/reminders", requireAuth, rateLimit)
+	reminder.Get("/", remindersHandler.ListReminders)
+	reminder.Delete("/:id", remindersHandler.DeleteReminder)
+
+	notification := app.Group("/notifications", requireAuth, rateLimit)
+	notification.Get("/", notificationsHandler.ListNotifications)
+	notification.Post("/read", notificationsHandler.MarkAllRead)
+	notification.Post("/:id/read", notificationsHandler.MarkRead)
+
+	adminGroup := app.Group("/admin", middleware.IPAllowlist(cfg.AdminAllowedIPs), requireAuth, rateLimit, middleware.RequireRole(models.RoleAdmin))
+	adminGroup.Get("/stats", adminHandler.GetStats)
+	adminGroup.Put("/maintenance", maintenanceHandler.UpdateMode)
+	adminGroup.Get("/features", featuresHandler.ListFlags)
+	adminGroup.Put("/features/:name", featuresHandler.UpdateFlag)
+	adminGroup.Delete("/features/:name", featuresHandler.DeleteFlag)
+	adminGroup.Put("/features/:name/overrides/:scope/:target", featuresHandler.UpdateOverride)
+	adminGroup.Delete("/features/:name/overrides/:scope/:target", featuresHandler.DeleteOverride)
+	adminGroup.Get("/users", adminHandler.ListUsers)
+	adminGroup.Get("/users/:id", adminHandler.GetUser)
+	adminGroup.Delete("/users/:id", adminHandler.DeleteUser)
+	adminGroup.Post("/users/:id/lock", adminHandler.LockUser)
+	adminGroup.Post("/users/:id/unlock", adminHandler.UnlockUser)
+	adminGroup.Post("/users/:id/password-reset", adminHandler.ResetPassword)
+	adminGroup.Get("/security-events", auditHandler.ListEvents)
+	adminGroup.Get("/backups", backupHandler.ListBackups)
+	adminGroup.Post("/backups", backupHandler.CreateBackup)
+	adminGroup.Post("/restores", backupHandler.RestoreBackup)
+	adminGroup.Get("/restores/:id", backupHandler.GetRestore)
+
+	// WebSocket routes. Browsers can't send an Authorization header on the
+	// upgrade request, so clients exchange their JWT for a one-time ticket first.
+	tickets := middleware.NewTicketStore(30 * time.Second)
+	ws := app.Group("/ws")
+	ws.Post("/ticket", requireAuth, rateLimit, tickets.IssueTicket)
+	// Origins are checked before auth so a cross-site page can't spend a ticket
+	wsOrigin := middleware.WebSocketOrigin(cfg.WSAllowedOrigins)
+	wsAuth := middleware.WebSocketAuth(conn, tickets, cfg.JWTKeys)
+	ws.Get("/notes/:id", wsOrigin, wsAuth, realtimeHandler.HandleWebSocket)
+	ws.Get("/notifications", wsOrigin, wsAuth, notificationsHandler.HandleWebSocket)
+
+	// The gRPC API serves the same notes and auth services for internal
+	// services and CLIs
+	grpcServer := grpcapi.NewServer(conn, notesHandler, authHandler, grpcapi.Options{
+		Keys:         cfg.JWTKeys,
+		QueryTimeout: cfg.QueryTimeout,
+		ReadOnly:     maintenanceMode.ReadOnly,
+	})
+	lis, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC: %v", err)
 	}
+	go func() {
+		log.Fatal(grpcServer.Serve(lis))
+	}()
 
-	log.Fatal(app.Listen(":" + port))
+	err = app.Listen(":" + cfg.Port)
+	// Flush the spans still buffered before exiting
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Printf("Error flushing traces: %v", err)
+	}
+	log.Fatal(err)
 }
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"quanta/pkg/client"
+
+	"golang.org/x/term"
+)
+
+// stdin is shared by every prompt so buffered input isn't lost between them
+var stdin = bufio.NewReader(os.Stdin)
+
+func runLogin(ctx context.Context, args []string) error {
+	fs := newFlagSet("login", "")
+	server := fs.String("server", "", "API base URL (default $QUANTA_SERVER or "+defaultServer+")")
+	email := fs.String("email", "", "account email")
+	_ = fs.Parse(args)
+
+	s, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *server != "":
+		s.Server = strings.TrimRight(*server, "/")
+	case s.Server == "":
+		s.Server = serverFromEnv()
+	}
+	if *email == "" {
+		*email = s.Email
+	}
+	if *email == "" {
+		if *email, err = prompt("Email: "); err != nil {
+			return err
+		}
+	}
+	password, err := promptPassword("Password: ")
+	if err != nil {
+		return err
+	}
+
+	token, err := client.New(s.Server, "").Login(ctx, *email, password)
+	if err != nil {
+		return err
+	}
+	s.Email = *email
+	where, err := storeToken(&s, token)
+	if err != nil {
+		return fmt.Errorf("storing token: %w", err)
+	}
+	fmt.Printf("Logged in to %s as %s; token stored in %s\n", s.Server, s.Email, where)
+	return nil
+}
+
+func runLogout(_ context.Context, args []string) error {
+	_ = newFlagSet("logout", "").Parse(args)
+
+	s, err := loadSettings()
+	if err != nil {
+		return err
+	}
+	return clearToken(&s)
+}
+
+func runList(ctx context.Context, args []string) error {
+	fs := newFlagSet("list", "")
+	archived := fs.Bool("archived", false, "list archived notes instead of active ones")
+	asJSON := fs.Bool("json", false, "print the notes as JSON")
+	_ = fs.Parse(args)
+
+	c, err := authenticated()
+	if err != nil {
+		return err
+	}
+	notes, err := c.ListNotes(ctx, client.ListOptions{Archived: *archived})
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(notes)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTITLE\tUPDATED")
+	for _, n := range notes {
+		title := n.Title
+		if n.Pinned {
+			title = "* " + title
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", n.ID, title, n.UpdatedAt.Local().Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+func runCreate(ctx context.Context, args []string) error {
+	fs := newFlagSet("create", "")
+	title := fs.String("title", "", "note title (required)")
+	content := fs.String("content", "", "note content; read from stdin when it isn't a terminal")
+	_ = fs.Parse(args)
+
+	if *title == "" {
+		fs.Usage()
+		return errors.New("-title is required")
+	}
+	if *content == "" && !term.IsTerminal(int(os.Stdin.Fd())) {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		*content = string(b)
+	}
+
+	c, err := authenticated()
+	if err != nil {
+		return err
+	}
+	id, err := c.CreateNote(ctx, *title, *content)
+	if err != nil {
+		return err
+	}
+	fmt.Println(id)
+	return nil
+}
+
+func runEdit(ctx context.Context, args []string) error {
+	fs := newFlagSet("edit", "NOTE_ID")
+	title := fs.String("title", "", "new title (default keeps the current one)")
+	force := fs.Bool("force", false, "save even if the note changed while it was being edited")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("a note ID is required")
+	}
+	id := fs.Arg(0)
+
+	c, err := authenticated()
+	if err != nil {
+		return err
+	}
+	note, err := c.GetNote(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	content, err := editInEditor(note.Content)
+	if err != nil {
+		return err
+	}
+	if *title == "" {
+		*title = note.Title
+	}
+	if content == note.Content && *title == note.Title {
+		fmt.Println("No changes")
+		return nil
+	}
+
+	// The API has no conditional updates, so check for concurrent edits
+	// before overwriting
+	if !*force {
+		current, err := c.GetNote(ctx, id)
+		if err != nil {
+			return err
+		}
+		if current.Version != note.Version {
+			return errors.New("the note was changed while you were editing; rerun with -force to overwrite it")
+		}
+	}
+	if err := c.UpdateNote(ctx, id, *title, content); err != nil {
+		return err
+	}
+	fmt.Println("Saved")
+	return nil
+}
+
+// editInEditor opens content in $VISUAL or $EDITOR and returns the result
+func editInEditor(content string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	f, err := os.CreateTemp("", "quanta-*.md")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if _, err := f.WriteString(content); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	// EDITOR may carry arguments, as in "code --wait"
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %s: %w", editor, err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	return string(b), err
+}
+
+func runExport(ctx context.Context, args []string) error {
+	fs := newFlagSet("export", "")
+	dir := fs.String("dir", "notes-export", "directory Markdown files are written to")
+	format := fs.String("format", "md", "md for one Markdown file per note, json for a JSON array on stdout")
+	_ = fs.Parse(args)
+	if *format != "md" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	c, err := authenticated()
+	if err != nil {
+		return err
+	}
+	notes, err := c.ListNotes(ctx, client.ListOptions{})
+	if err != nil {
+		return err
+	}
+	archived, err := c.ListNotes(ctx, client.ListOptions{Archived: true})
+	if err != nil {
+		return err
+	}
+	notes = append(notes, archived...)
+
+	if *format == "json" {
+		return printJSON(notes)
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	for _, n := range notes {
+		path := filepath.Join(*dir, fileName(n))
+		body := "# " + n.Title + "\n\n" + n.Content
+		if !strings.HasSuffix(body, "\n") {
+			body += "\n"
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Exported %d notes to %s\n", len(notes), *dir)
+	return nil
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// fileName returns a readable, unique file name for a note
+func fileName(n client.Note) string {
+	slug := strings.Trim(unsafeChars.ReplaceAllString(strings.ToLower(n.Title), "-"), "-")
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "-")
+	}
+	id := n.ID
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	if slug == "" {
+		return id + ".md"
+	}
+	return slug + "-" + id + ".md"
+}
+
+func runShare(ctx context.Context, args []string) error {
+	fs := newFlagSet("share", "WORKSPACE_ID")
+	email := fs.String("email", "", "mail the link to this address; only it can accept the invitation")
+	role := fs.String("role", "member", "role the invitee joins with: member or admin")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("a workspace ID is required")
+	}
+
+	c, err := authenticated()
+	if err != nil {
+		return err
+	}
+	inv, err := c.Invite(ctx, fs.Arg(0), *email, *role)
+	if err != nil {
+		return err
+	}
+	fmt.Println(inv.URL)
+	if inv.Emailed {
+		fmt.Fprintln(os.Stderr, "Emailed to", *email)
+	}
+	fmt.Fprintln(os.Stderr, "Expires", inv.ExpiresAt.Local().Format(time.DateTime))
+	return nil
+}
+
+func runWatch(ctx context.Context, args []string) error {
+	fs := newFlagSet("watch", "NOTE_ID")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("a note ID is required")
+	}
+
+	c, err := authenticated()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Watching; press Ctrl-C to stop")
+	return c.Watch(ctx, fs.Arg(0), func(ev client.Event) error {
+		now := time.Now().Format(time.TimeOnly)
+		switch ev.Type {
+		case "edit":
+			fmt.Printf("%s  %s edited (rev %d, %d bytes)\n", now, who(ev), ev.Rev, len(ev.Content))
+		case "presence":
+			fmt.Printf("%s  %s %s\n", now, who(ev), ev.Action)
+		case "typing", "cursor", "welcome", "history", "presence:list":
+		default:
+			fmt.Printf("%s  %s\n", now, ev.Raw)
+		}
+		return nil
+	})
+}
+
+// who names the user behind a realtime event
+func who(ev client.Event) string {
+	if ev.DisplayName != "" {
+		return ev.DisplayName
+	}
+	return ev.UserID
+}
+
+// pollInterval is how often backup and restore progress is checked
+const pollInterval = 2 * time.Second
+
+func runBackups(ctx context.Context, args []string) error {
+	fs := newFlagSet("backups", "")
+	asJSON := fs.Bool("json", false, "print the backups as JSON")
+	_ = fs.Parse(args)
+
+	c, err := authenticated()
+	if err != nil {
+		return err
+	}
+	backups, err := c.ListBackups(ctx)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(backups)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tCREATED\tROWS\tSIZE\tSTORAGE KEY")
+	for _, b := range backups {
+		status := b.Status
+		if b.Encrypted {
+			status += " (encrypted)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\n", b.ID, status, b.CreatedAt.Local().Format(time.DateTime), b.Rows, b.Size, b.StorageKey)
+	}
+	return w.Flush()
+}
+
+func runBackup(ctx context.Context, args []string) error {
+	fs := newFlagSet("backup", "")
+	wait := fs.Bool("wait", false, "wait for the backup to finish")
+	_ = fs.Parse(args)
+
+	c, err := authenticated()
+	if err != nil {
+		return err
+	}
+	b, err := c.CreateBackup(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println(b.ID)
+	if !*wait {
+		return nil
+	}
+
+	for b.Status == "running" {
+		if err := sleep(ctx, pollInterval); err != nil {
+			return err
+		}
+		backups, err := c.ListBackups(ctx)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(backups, func(other client.Backup) bool { return other.ID == b.ID })
+		if i < 0 {
+			return errors.New("the backup is no longer listed")
+		}
+		b = backups[i]
+	}
+	if b.Error != nil {
+		return errors.New(*b.Error)
+	}
+	fmt.Fprintf(os.Stderr, "Backed up %d rows to %s\n", b.Rows, b.StorageKey)
+	return nil
+}
+
+func runRestore(ctx context.Context, args []string) error {
+	fs := newFlagSet("restore", "[BACKUP_ID]")
+	key := fs.String("key", "", "restore the archive with this storage key instead of a listed backup")
+	yes := fs.Bool("yes", false, "restore without asking for confirmation")
+	_ = fs.Parse(args)
+	if (fs.NArg() == 1) == (*key != "") || fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("a backup ID or -key is required")
+	}
+
+	c, err := authenticated()
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		answer, err := prompt("Rows of the backup missing from the server will be put back. Continue? [y/N] ")
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+			return errors.New("restore cancelled")
+		}
+	}
+
+	var r client.Restore
+	if *key != "" {
+		r, err = c.RestoreArchive(ctx, *key)
+	} else {
+		r, err = c.RestoreBackup(ctx, fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	for r.Status == "running" {
+		if err := sleep(ctx, pollInterval); err != nil {
+			return err
+		}
+		if r, err = c.GetRestore(ctx, r.ID); err != nil {
+			return err
+		}
+	}
+	if r.Error != nil {
+		return fmt.Errorf("%s (%d rows restored before it failed)", *r.Error, r.Restored)
+	}
+	fmt.Printf("Restored %d rows; %d were already there\n", r.Restored, r.Skipped)
+	return nil
+}
+
+// sleep waits for d, returning early with ctx's error if it is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// prompt reads a line from stdin
+func prompt(label string) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// promptPassword reads a password without echoing it when stdin is a terminal
+func promptPassword(label string) (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return prompt(label)
+	}
+	fmt.Fprint(os.Stderr, label)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(b), err
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
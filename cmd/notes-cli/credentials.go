@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keyringService names the tokens the CLI keeps in the OS keychain
+const keyringService = "quanta-notes-cli"
+
+// settings is the CLI's config file. Token is only written there when no OS
+// keychain is available.
+type settings struct {
+	Server string `json:"server"`
+	Email  string `json:"email,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// configPath returns where settings are stored, honoring QUANTA_CONFIG
+func configPath() (string, error) {
+	if path := os.Getenv("QUANTA_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "quanta", "config.json"), nil
+}
+
+// loadSettings reads the config file, returning empty settings if there is none
+func loadSettings() (settings, error) {
+	var s settings
+	path, err := configPath()
+	if err != nil {
+		return s, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// save writes the config file, readable by the user only
+func (s settings) save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o600)
+}
+
+// storeToken keeps the token for server in the OS keychain, falling back to
+// the config file when there is none, as on headless machines
+func storeToken(s *settings, token string) (where string, err error) {
+	if err := keyring.Set(keyringService, s.Server, token); err == nil {
+		s.Token = ""
+		return "the OS keychain", s.save()
+	}
+	s.Token = token
+	path, _ := configPath()
+	return path, s.save()
+}
+
+// loadToken returns the stored token for the configured server. QUANTA_TOKEN
+// overrides it, which suits scripts using an API key.
+func loadToken(s settings) (string, error) {
+	if token := os.Getenv("QUANTA_TOKEN"); token != "" {
+		return token, nil
+	}
+	if s.Token != "" {
+		return s.Token, nil
+	}
+	token, err := keyring.Get(keyringService, s.Server)
+	if err != nil {
+		return "", errors.New("not logged in; run notes-cli login first")
+	}
+	return token, nil
+}
+
+// clearToken forgets the token for the configured server
+func clearToken(s *settings) error {
+	// The token may be in the config file instead, or not stored at all
+	_ = keyring.Delete(keyringService, s.Server)
+	s.Token = ""
+	return s.save()
+}
@@ -0,0 +1,133 @@
+// Command notes-cli manages quanta notes from the terminal. It talks to the
+// REST API through the client SDK and keeps the login token in the OS
+// keychain, or in its config file where there is no keychain.
+//
+// Usage:
+//
+//	notes-cli login [-server URL] [-email EMAIL]
+//	notes-cli logout
+//	notes-cli list [-archived] [-json]
+//	notes-cli create -title TITLE [-content TEXT]
+//	notes-cli edit [-title TITLE] NOTE_ID
+//	notes-cli export [-dir DIR] [-format md|json]
+//	notes-cli share [-email EMAIL] [-role ROLE] WORKSPACE_ID
+//	notes-cli watch NOTE_ID
+//	notes-cli backups [-json]
+//	notes-cli backup [-wait]
+//	notes-cli restore [-yes] BACKUP_ID | -key STORAGE_KEY
+//
+// The backup commands need an administrator's login. After losing the
+// database, start the server on an empty one, register an administrator
+// under an email the backup doesn't have and restore the latest archive in
+// storage by its key to bring everything else back.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"quanta/pkg/client"
+)
+
+// defaultServer is used until login is given -server
+const defaultServer = "http://localhost:3000"
+
+// command is a notes-cli subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"login", "log in and store the token", runLogin},
+	{"logout", "forget the stored token", runLogout},
+	{"list", "list your notes", runList},
+	{"create", "create a note", runCreate},
+	{"edit", "edit a note in $EDITOR", runEdit},
+	{"export", "export every note as Markdown or JSON", runExport},
+	{"share", "create an invitation link to a workspace", runShare},
+	{"watch", "print a note's realtime changes as they happen", runWatch},
+	{"backups", "list the server's backups (administrators)", runBackups},
+	{"backup", "back up the server now (administrators)", runBackup},
+	{"restore", "restore a backup's missing rows (administrators)", runRestore},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	name, args := flag.Arg(0), flag.Args()[1:]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(ctx, args); err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "notes-cli "+name+":", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "notes-cli: unknown command %q\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: notes-cli <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run notes-cli <command> -h for a command's flags.")
+}
+
+// newFlagSet returns the flag set for a subcommand
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: notes-cli %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// authenticated returns an SDK client for the configured server using the
+// stored token
+func authenticated() (*client.Client, error) {
+	s, err := loadSettings()
+	if err != nil {
+		return nil, err
+	}
+	if s.Server == "" {
+		s.Server = serverFromEnv()
+	}
+	token, err := loadToken(s)
+	if err != nil {
+		return nil, err
+	}
+	return client.New(s.Server, token), nil
+}
+
+// serverFromEnv returns QUANTA_SERVER or the default server
+func serverFromEnv() string {
+	if server := os.Getenv("QUANTA_SERVER"); server != "" {
+		return strings.TrimRight(server, "/")
+	}
+	return defaultServer
+}
@@ -0,0 +1,66 @@
+// Package access decides which notes a user may open. The REST API, the
+// realtime socket and background jobs all ask it, so they agree.
+package access
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"quanta/internal/apperr"
+	"quanta/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Notes restricts a notes query to the user's private notes and the notes
+// of every workspace they belong to. It takes the user ID twice. Being
+// added as a collaborator on a workspace note grants nothing without
+// membership of its workspace.
+const Notes = "((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))"
+
+// NotesOf is Notes with the user ID read from user, such as a column of
+// an outer query, rather than passed as a parameter
+func NotesOf(user string) string {
+	return strings.ReplaceAll(Notes, "?", user)
+}
+
+// Querier is the database access CanOpen needs
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// CanOpen reports whether the user may open the note. Notes that don't
+// exist can't be opened.
+func CanOpen(ctx context.Context, q Querier, noteID, userID string) (bool, error) {
+	var allowed bool
+	err := q.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND "+Notes+")",
+		noteID, userID, userID,
+	).Scan(&allowed)
+	return allowed, err
+}
+
+// ErrReadOnly answers a change to a note the user may only read
+var ErrReadOnly = apperr.New(fiber.StatusForbidden, "You have read-only access to this note")
+
+// RequireEditor returns ErrReadOnly if the user was given a role that
+// cannot change the note. Whether they can open it at all is left to the
+// caller.
+func RequireEditor(ctx context.Context, q Querier, noteID, userID string) error {
+	var role string
+	err := q.QueryRowContext(ctx, "SELECT role FROM note_collaborators WHERE note_id = ? AND user_id = ?", noteID, userID).
+		Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking note role: %w", err)
+	}
+	if !models.CanEditNote(role) {
+		return ErrReadOnly
+	}
+	return nil
+}
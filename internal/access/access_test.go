@@ -0,0 +1,75 @@
+package access
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotesOf(t *testing.T) {
+	assert.Equal(t,
+		"((workspace_id IS NULL AND user_id = r.user_id) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = r.user_id))",
+		NotesOf("r.user_id"),
+	)
+}
+
+func TestCanOpen(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed bool
+	}{
+		{"Allowed", true},
+		{"Refused", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer func() { _ = db.Close() }()
+
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND "+Notes+")")).
+				WithArgs("note-1", "user-1", "user-1").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.allowed))
+
+			allowed, err := CanOpen(context.Background(), db, "note-1", "user-1")
+			require.NoError(t, err)
+			assert.Equal(t, tt.allowed, allowed)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRequireEditor(t *testing.T) {
+	tests := []struct {
+		name string
+		role string
+		want error
+	}{
+		{"No Role", "", nil},
+		{"Editor", "editor", nil},
+		{"Commenter", "commenter", ErrReadOnly},
+		{"Viewer", "viewer", ErrReadOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer func() { _ = db.Close() }()
+
+			rows := sqlmock.NewRows([]string{"role"})
+			if tt.role != "" {
+				rows.AddRow(tt.role)
+			}
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT role FROM note_collaborators WHERE note_id = ? AND user_id = ?")).
+				WithArgs("note-1", "user-1").
+				WillReturnRows(rows)
+
+			assert.Equal(t, tt.want, RequireEditor(context.Background(), db, "note-1", "user-1"))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
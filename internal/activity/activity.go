@@ -0,0 +1,218 @@
+// Package activity records the lifecycle events of notes (created, edited,
+// renamed, ...) and serves them as per-note and per-user activity feeds
+package activity
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"quanta/internal/access"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/realtime"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Action identifies what happened to a note
+type Action string
+
+const (
+	// ActionCreated is recorded when a note is created
+	ActionCreated Action = "created"
+	// ActionEdited is recorded when a note's content changes
+	ActionEdited Action = "edited"
+	// ActionRenamed is recorded when a note's title changes
+	ActionRenamed Action = "renamed"
+	// ActionShared is recorded when a collaborator is added to a note
+	ActionShared Action = "shared"
+	// ActionDeleted is recorded when a note is deleted
+	ActionDeleted Action = "deleted"
+)
+
+const (
+	// DefaultLimit is how many activities a feed returns by default
+	DefaultLimit = 50
+	// MaxLimit is the most activities a feed returns in one page
+	MaxLimit = 200
+)
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Publisher pushes a message to everyone connected to a note's room
+type Publisher interface {
+	Publish(ctx context.Context, noteID string, message any)
+}
+
+// Activity is a single recorded event on a note
+type Activity struct {
+	ID        string            `json:"id"`
+	NoteID    string            `json:"note_id"`
+	ActorID   string            `json:"actor_id"`
+	Action    Action            `json:"action"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Message is the realtime frame collaborators receive for a new activity
+type Message struct {
+	Type     string   `json:"type"`
+	V        int      `json:"v"`
+	Activity Activity `json:"activity"`
+}
+
+// Recorder persists activities and announces them to the note's room
+type Recorder struct {
+	db        DBInterface
+	publisher Publisher
+}
+
+// NewRecorder creates a Recorder. publisher may be nil to skip realtime delivery.
+func NewRecorder(db DBInterface, publisher Publisher) *Recorder {
+	return &Recorder{db: db, publisher: publisher}
+}
+
+// Record stores an activity and emits it into the note's realtime room.
+// Failures are logged rather than returned: the activity feed is a
+// secondary record and must never fail the operation it describes.
+func (r *Recorder) Record(ctx context.Context, noteID, actorID string, action Action, details map[string]string) {
+	a := Activity{
+		ID:        uuid.New().String(),
+		NoteID:    noteID,
+		ActorID:   actorID,
+		Action:    action,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+
+	var detailsJSON sql.NullString
+	if len(details) > 0 {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			log.Println("Error encoding activity details:", err)
+			return
+		}
+		detailsJSON = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO activities (id, note_id, actor_id, action, details) VALUES (?, ?, ?, ?, ?)",
+		a.ID, a.NoteID, a.ActorID, string(a.Action), detailsJSON,
+	)
+	if err != nil {
+		log.Println("Error recording activity:", err)
+		return
+	}
+
+	if r.publisher != nil {
+		r.publisher.Publish(ctx, noteID, Message{Type: "activity", V: realtime.ProtocolVersion, Activity: a})
+	}
+}
+
+// Handler serves the activity feed endpoints
+type Handler struct {
+	db DBInterface
+}
+
+// NewHandler creates a new Handler with the provided database interface
+func NewHandler(db DBInterface) *Handler {
+	return &Handler{db: db}
+}
+
+// GetNoteActivity lists the activity on a note the user can access, newest first
+func (h *Handler) GetNoteActivity(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid limit")
+	}
+
+	allowed, err := access.CanOpen(c.UserContext(), h.db, noteID, userID)
+	if err != nil {
+		return fmt.Errorf("checking note access: %w", err)
+	}
+	if !allowed {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+
+	return h.list(c, "SELECT id, note_id, actor_id, action, details, created_at FROM activities WHERE note_id = ? ORDER BY created_at DESC LIMIT ?", noteID, limit)
+}
+
+// GetMyActivity lists the activities performed by the authenticated user, newest first
+func (h *Handler) GetMyActivity(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	limit, err := parseLimit(c)
+	if err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid limit")
+	}
+
+	return h.list(c, "SELECT id, note_id, actor_id, action, details, created_at FROM activities WHERE actor_id = ? ORDER BY created_at DESC LIMIT ?", userID, limit)
+}
+
+// list runs an activity query and writes the results as JSON
+func (h *Handler) list(c *fiber.Ctx, query string, args ...any) error {
+	rows, err := h.db.QueryContext(c.UserContext(), query, args...)
+	if err != nil {
+		return fmt.Errorf("fetching activity: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	activities := []Activity{}
+	for rows.Next() {
+		var a Activity
+		var details sql.NullString
+		if err := rows.Scan(&a.ID, &a.NoteID, &a.ActorID, &a.Action, &details, &a.CreatedAt); err != nil {
+			return fmt.Errorf("scanning activity: %w", err)
+		}
+		if details.Valid {
+			if err := json.Unmarshal([]byte(details.String), &a.Details); err != nil {
+				log.Println("Error decoding activity details:", err)
+			}
+		}
+		activities = append(activities, a)
+	}
+
+	return c.JSON(activities)
+}
+
+// parseLimit reads the optional ?limit= query parameter
+func parseLimit(c *fiber.Ctx) (int, error) {
+	raw := c.Query("limit")
+	if raw == "" {
+		return DefaultLimit, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 {
+		return 0, errors.New("invalid limit")
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	return limit, nil
+}
@@ -0,0 +1,192 @@
+package activity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var activityColumns = []string{"id", "note_id", "actor_id", "action", "details", "created_at"}
+
+// fakePublisher captures published messages
+type fakePublisher struct {
+	published map[string][]any
+}
+
+func (f *fakePublisher) Publish(_ context.Context, noteID string, message any) {
+	if f.published == nil {
+		f.published = make(map[string][]any)
+	}
+	f.published[noteID] = append(f.published[noteID], message)
+}
+
+func TestRecorder_Record(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	publisher := &fakePublisher{}
+	recorder := NewRecorder(db, publisher)
+	insert := regexp.QuoteMeta("INSERT INTO activities (id, note_id, actor_id, action, details) VALUES (?, ?, ?, ?, ?)")
+
+	mockDB.ExpectExec(insert).
+		WithArgs(sqlmock.AnyArg(), "note1", "user123", "renamed", `{"from":"Old","to":"New"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	recorder.Record(context.Background(), "note1", "user123", ActionRenamed, map[string]string{"from": "Old", "to": "New"})
+
+	if assert.Len(t, publisher.published["note1"], 1) {
+		msg := publisher.published["note1"][0].(Message)
+		assert.Equal(t, "activity", msg.Type)
+		assert.Equal(t, ActionRenamed, msg.Activity.Action)
+		assert.Equal(t, "user123", msg.Activity.ActorID)
+	}
+
+	// A failed insert is not published
+	mockDB.ExpectExec(insert).
+		WithArgs(sqlmock.AnyArg(), "note2", "user123", "created", nil).
+		WillReturnError(errors.New("database error"))
+	recorder.Record(context.Background(), "note2", "user123", ActionCreated, nil)
+	assert.Empty(t, publisher.published["note2"])
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetNoteActivity(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	handler := NewHandler(db)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/notes/:id/activity", handler.GetNoteActivity)
+
+	accessQuery := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)))")
+	listQuery := regexp.QuoteMeta("SELECT id, note_id, actor_id, action, details, created_at FROM activities WHERE note_id = ? ORDER BY created_at DESC LIMIT ?")
+	now := time.Now()
+
+	testCases := []struct {
+		name           string
+		query          string
+		allowed        bool
+		expectList     bool
+		expectedLimit  int
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			name:           "Success",
+			allowed:        true,
+			expectList:     true,
+			expectedLimit:  DefaultLimit,
+			expectedStatus: fiber.StatusOK,
+			expectedCount:  2,
+		},
+		{
+			name:           "Limit Is Capped",
+			query:          "?limit=1000",
+			allowed:        true,
+			expectList:     true,
+			expectedLimit:  MaxLimit,
+			expectedStatus: fiber.StatusOK,
+			expectedCount:  2,
+		},
+		{
+			name:           "Invalid Limit",
+			query:          "?limit=abc",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "No Access",
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.name != "Invalid Limit" {
+				mockDB.ExpectQuery(accessQuery).WithArgs("note1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(tc.allowed))
+			}
+			if tc.expectList {
+				mockDB.ExpectQuery(listQuery).WithArgs("note1", tc.expectedLimit).
+					WillReturnRows(sqlmock.NewRows(activityColumns).
+						AddRow("a2", "note1", "user123", "renamed", `{"from":"Old","to":"New"}`, now).
+						AddRow("a1", "note1", "user123", "created", nil, now))
+			}
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/notes/note1/activity"+tc.query, nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var activities []Activity
+				if err := json.NewDecoder(resp.Body).Decode(&activities); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Len(t, activities, tc.expectedCount)
+				assert.Equal(t, map[string]string{"from": "Old", "to": "New"}, activities[0].Details)
+				assert.Nil(t, activities[1].Details)
+			}
+		})
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetMyActivity(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	handler := NewHandler(db)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/me/activity", handler.GetMyActivity)
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, note_id, actor_id, action, details, created_at FROM activities WHERE actor_id = ? ORDER BY created_at DESC LIMIT ?")).
+		WithArgs("user123", 10).
+		WillReturnRows(sqlmock.NewRows(activityColumns).AddRow("a1", "note1", "user123", "deleted", nil, time.Now()))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/me/activity?limit=10", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var activities []Activity
+	if err := json.NewDecoder(resp.Body).Decode(&activities); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, activities, 1) {
+		assert.Equal(t, ActionDeleted, activities[0].Action)
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+// Package apperr defines the error handlers return to reject a request and
+// the Fiber error handler that renders every error in one JSON envelope,
+// translated into the language the client asks for
+package apperr
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error is an error the client is allowed to see. Any other error returned
+// from a handler is logged and answered with a generic 500.
+type Error struct {
+	Code    int
+	Message string
+	// Fields holds per-field problems for validation failures
+	Fields map[string]string
+	// RetryAfter is how many seconds the client should wait before trying
+	// again, if it matters
+	RetryAfter int
+
+	// format and args made Message, kept so the message can be translated
+	// before the args are filled in
+	format string
+	args   []any
+}
+
+// New creates an Error with an HTTP status code and a client-facing message
+func New(code int, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Newf creates an Error whose message is formatted from format and args.
+// Translations of format fill in the same args.
+func Newf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...), format: format, args: args}
+}
+
+// Invalid creates a 422 Error reporting what is wrong with each field
+func Invalid(fields map[string]string) *Error {
+	return &Error{Code: fiber.StatusUnprocessableEntity, Message: "Validation failed", Fields: fields}
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Response is the JSON body of every error response. Code is the HTTP
+// status; ErrorCode names the error for clients that show their own
+// messages, and Message describes it in the request's language.
+type Response struct {
+	Code      int               `json:"code"`
+	ErrorCode string            `json:"error_code"`
+	Message   string            `json:"message"`
+	RequestID string            `json:"request_id"`
+	Errors    map[string]string `json:"errors,omitempty"`
+	// RetryAfter repeats the Retry-After header in seconds, for clients
+	// that only read the body
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// Status returns the HTTP status err will be answered with
+func Status(err error) int {
+	var appErr *Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &appErr):
+		return appErr.Code
+	case errors.As(err, &fiberErr):
+		return fiberErr.Code
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
+
+// Handler is the application's fiber.ErrorHandler. It renders Error and
+// fiber.Error values as they are and hides everything else behind a 500,
+// logging the cause with the request ID so the two can be matched up.
+func Handler(c *fiber.Ctx, err error) error {
+	resp := ResponseFor(c, err)
+	return c.Status(resp.Code).JSON(resp)
+}
+
+// ResponseFor builds the response to err in the language the request's
+// Accept-Language header asks for, and marks the response with it. Field
+// problems are not translated.
+func ResponseFor(c *fiber.Ctx, err error) Response {
+	resp := Response{
+		Code:      Status(err),
+		RequestID: c.GetRespHeader(fiber.HeaderXRequestID),
+	}
+
+	format, args := "Internal server error", []any(nil)
+	var appErr *Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &appErr):
+		format = appErr.Message
+		if appErr.format != "" {
+			format, args = appErr.format, appErr.args
+		}
+		resp.Errors = appErr.Fields
+		resp.RetryAfter = appErr.RetryAfter
+	case errors.As(err, &fiberErr):
+		format = fiberErr.Message
+	default:
+		log.Printf("request %s: %v", resp.RequestID, err)
+	}
+
+	lang := Language(c)
+	resp.ErrorCode, resp.Message = translate(lang, resp.Code, format, args)
+	c.Set(fiber.HeaderContentLanguage, lang)
+	c.Vary(fiber.HeaderAcceptLanguage)
+	return resp
+}
@@ -0,0 +1,78 @@
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected Response
+	}{
+		{
+			name:     "App Error",
+			err:      New(fiber.StatusNotFound, "Note not found"),
+			expected: Response{Code: fiber.StatusNotFound, ErrorCode: "not_found", Message: "Note not found"},
+		},
+		{
+			name:     "Wrapped App Error",
+			err:      fmt.Errorf("loading note: %w", New(fiber.StatusForbidden, "Forbidden")),
+			expected: Response{Code: fiber.StatusForbidden, ErrorCode: "forbidden", Message: "Forbidden"},
+		},
+		{
+			name: "Validation Error",
+			err:  Invalid(map[string]string{"title": "required"}),
+			expected: Response{
+				Code:      fiber.StatusUnprocessableEntity,
+				ErrorCode: "validation_failed",
+				Message:   "Validation failed",
+				Errors:    map[string]string{"title": "required"},
+			},
+		},
+		{
+			name:     "Retry After",
+			err:      &Error{Code: fiber.StatusLocked, Message: "Locked", RetryAfter: 60},
+			expected: Response{Code: fiber.StatusLocked, ErrorCode: "locked", Message: "Locked", RetryAfter: 60},
+		},
+		{
+			name:     "Fiber Error",
+			err:      fiber.ErrRequestEntityTooLarge,
+			expected: Response{Code: fiber.StatusRequestEntityTooLarge, ErrorCode: "request_entity_too_large", Message: "Request Entity Too Large"},
+		},
+		{
+			name:     "Internal Error",
+			err:      errors.New("connection refused"),
+			expected: Response{Code: fiber.StatusInternalServerError, ErrorCode: "internal_error", Message: "Internal server error"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: Handler})
+			app.Use(requestid.New())
+			app.Get("/", func(_ *fiber.Ctx) error { return tc.err })
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected.Code, resp.StatusCode)
+
+			var body Response
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.NotEmpty(t, body.RequestID)
+			assert.Equal(t, resp.Header.Get(fiber.HeaderXRequestID), body.RequestID)
+
+			body.RequestID = ""
+			assert.Equal(t, tc.expected, body)
+		})
+	}
+}
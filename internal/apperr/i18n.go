@@ -0,0 +1,98 @@
+package apperr
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/text/language"
+)
+
+// Languages lists the languages error messages are translated into. The
+// first is the language handlers write messages in, used when the client
+// asks for none of the others.
+var Languages = []string{"en", "es", "fr", "de"}
+
+// messages/<language>.json map each error code to its message in that
+// language. en.json is the source: handlers write the English messages,
+// which are looked up there to find their codes.
+//
+//go:embed messages/*.json
+var messageFiles embed.FS
+
+var (
+	catalogs = loadCatalogs()
+	codes    = codesOf(catalogs[Languages[0]])
+	matcher  = language.NewMatcher(tags(Languages))
+)
+
+// loadCatalogs reads the message catalog of every language
+func loadCatalogs() map[string]map[string]string {
+	all := make(map[string]map[string]string, len(Languages))
+	for _, lang := range Languages {
+		data, err := messageFiles.ReadFile("messages/" + lang + ".json")
+		if err != nil {
+			panic(fmt.Sprintf("apperr: reading %s messages: %v", lang, err))
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("apperr: parsing %s messages: %v", lang, err))
+		}
+		all[lang] = catalog
+	}
+	return all
+}
+
+// codesOf maps each message of a catalog back to its code
+func codesOf(catalog map[string]string) map[string]string {
+	codes := make(map[string]string, len(catalog))
+	for code, msg := range catalog {
+		codes[msg] = code
+	}
+	return codes
+}
+
+func tags(langs []string) []language.Tag {
+	t := make([]language.Tag, len(langs))
+	for i, lang := range langs {
+		t[i] = language.MustParse(lang)
+	}
+	return t
+}
+
+// Language picks the language to answer a request in from its
+// Accept-Language header
+func Language(c *fiber.Ctx) string {
+	_, i := language.MatchStrings(matcher, c.Get(fiber.HeaderAcceptLanguage))
+	return Languages[i]
+}
+
+// translate returns the code of an English message, written from format
+// and args, and the message in lang. Messages missing from the catalog
+// keep their English text and take a code from their HTTP status.
+func translate(lang string, status int, format string, args []any) (string, string) {
+	code, ok := codes[format]
+	if !ok {
+		code = statusCode(status)
+	} else if msg, ok := catalogs[lang][code]; ok {
+		format = msg
+	}
+	if len(args) == 0 {
+		return code, format
+	}
+	return code, fmt.Sprintf(format, args...)
+}
+
+// statusCode turns an HTTP status into an error code, such as not_found
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	}), "_")
+}
@@ -0,0 +1,164 @@
+package apperr
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_Language(t *testing.T) {
+	testCases := []struct {
+		name           string
+		acceptLanguage string
+		err            error
+		language       string
+		expected       Response
+	}{
+		{
+			name:     "Default",
+			err:      New(fiber.StatusNotFound, "Note not found or unauthorized"),
+			language: "en",
+			expected: Response{Code: fiber.StatusNotFound, ErrorCode: "note_not_found", Message: "Note not found or unauthorized"},
+		},
+		{
+			name:           "Regional Variant",
+			acceptLanguage: "es-MX,es;q=0.9,en;q=0.8",
+			err:            New(fiber.StatusNotFound, "Note not found or unauthorized"),
+			language:       "es",
+			expected:       Response{Code: fiber.StatusNotFound, ErrorCode: "note_not_found", Message: "Nota no encontrada o sin autorización"},
+		},
+		{
+			name:           "Preferred By Quality",
+			acceptLanguage: "fr;q=0.5,de;q=0.9",
+			err:            Newf(fiber.StatusNotFound, "Revision %d not found", 3),
+			language:       "de",
+			expected:       Response{Code: fiber.StatusNotFound, ErrorCode: "revision_not_found", Message: "Revision 3 nicht gefunden"},
+		},
+		{
+			name:           "Unsupported Language",
+			acceptLanguage: "ja",
+			err:            New(fiber.StatusNotFound, "Note not found or unauthorized"),
+			language:       "en",
+			expected:       Response{Code: fiber.StatusNotFound, ErrorCode: "note_not_found", Message: "Note not found or unauthorized"},
+		},
+		{
+			name:           "Validation Error",
+			acceptLanguage: "fr",
+			err:            Invalid(map[string]string{"title": "required"}),
+			language:       "fr",
+			expected: Response{
+				Code:      fiber.StatusUnprocessableEntity,
+				ErrorCode: "validation_failed",
+				Message:   "La validation a échoué",
+				Errors:    map[string]string{"title": "required"},
+			},
+		},
+		{
+			name:           "Internal Error",
+			acceptLanguage: "fr",
+			err:            assert.AnError,
+			language:       "fr",
+			expected:       Response{Code: fiber.StatusInternalServerError, ErrorCode: "internal_error", Message: "Erreur interne du serveur"},
+		},
+		{
+			name:           "Uncatalogued Message",
+			acceptLanguage: "de",
+			err:            New(fiber.StatusTooManyRequests, "Slow down, 100% of your quota is used"),
+			language:       "de",
+			expected:       Response{Code: fiber.StatusTooManyRequests, ErrorCode: "too_many_requests", Message: "Slow down, 100% of your quota is used"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: Handler})
+			app.Get("/", func(_ *fiber.Ctx) error { return tc.err })
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.acceptLanguage != "" {
+				req.Header.Set(fiber.HeaderAcceptLanguage, tc.acceptLanguage)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.language, resp.Header.Get(fiber.HeaderContentLanguage))
+			assert.Contains(t, resp.Header.Get(fiber.HeaderVary), fiber.HeaderAcceptLanguage)
+
+			var body Response
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, tc.expected, body)
+		})
+	}
+}
+
+var verbs = regexp.MustCompile(`%[a-z]`)
+
+// TestCatalogs checks that every language has every message, with the same
+// verbs in the same order as the English
+func TestCatalogs(t *testing.T) {
+	english := catalogs[Languages[0]]
+	assert.Len(t, codes, len(english), "English messages must be unique")
+
+	for _, lang := range Languages[1:] {
+		catalog := catalogs[lang]
+		for code, msg := range english {
+			translated, ok := catalog[code]
+			if assert.True(t, ok, "%s is missing %s", lang, code) {
+				assert.Equal(t, verbs.FindAllString(msg, -1), verbs.FindAllString(translated, -1), "%s %s", lang, code)
+			}
+		}
+		for code := range catalog {
+			assert.Contains(t, english, code, "%s has unknown code %s", lang, code)
+		}
+	}
+}
+
+// TestCatalogs_CoverMessages checks that the message of every Error the
+// application creates is in the English catalog
+func TestCatalogs_CoverMessages(t *testing.T) {
+	root := filepath.Join("..", "..")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || !slices.Contains([]string{"New", "Newf"}, sel.Sel.Name) {
+				return true
+			}
+			if pkg, ok := sel.X.(*ast.Ident); !ok || pkg.Name != "apperr" {
+				return true
+			}
+			lit, ok := call.Args[1].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			msg, _ := strconv.Unquote(lit.Value)
+			assert.Contains(t, codes, msg, "%s: message is not in messages/en.json", fset.Position(lit.Pos()))
+			return true
+		})
+		return nil
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,255 @@
+// Package audit keeps an append-only log of security events, such as
+// logins, password changes and permission grants, along with the client
+// each came from, and serves it to users and administrators
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/auth"
+	"quanta/internal/paging"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Event identifies what happened
+type Event string
+
+const (
+	// EventSignup is logged when an account is created
+	EventSignup Event = "signup"
+	// EventLogin is logged when a user logs in
+	EventLogin Event = "login"
+	// EventLoginFailed is logged when a login is refused. Failures for an
+	// unknown email have no user and carry the email in their details.
+	EventLoginFailed Event = "login_failed"
+	// EventAccountLocked is logged when failed logins or an administrator
+	// lock an account
+	EventAccountLocked Event = "account_locked"
+	// EventAccountUnlocked is logged when an administrator lifts a lock
+	EventAccountUnlocked Event = "account_unlocked"
+	// EventPasswordChanged is logged when a user changes their password
+	EventPasswordChanged Event = "password_changed"
+	// EventEmailChangeRequested is logged when a user asks to change their
+	// email and a verification link is sent to the new address
+	EventEmailChangeRequested Event = "email_change_requested"
+	// EventEmailChanged is logged when a user verifies their new email
+	EventEmailChanged Event = "email_changed"
+	// EventPasswordReset is logged when an administrator resets a password
+	EventPasswordReset Event = "password_reset"
+	// EventSessionRevoked is logged when a user ends one of their sessions
+	EventSessionRevoked Event = "session_revoked"
+	// EventAccountDeleted is logged when an account is deleted
+	EventAccountDeleted Event = "account_deleted"
+	// EventAPIKeyCreated is logged when a user issues an API key
+	EventAPIKeyCreated Event = "api_key_created"
+	// EventAPIKeyRevoked is logged when a user revokes an API key
+	EventAPIKeyRevoked Event = "api_key_revoked"
+	// EventRoleChanged is logged when a user's role in a workspace changes
+	EventRoleChanged Event = "role_changed"
+	// EventMemberJoined is logged when a user accepts an invitation to a
+	// workspace
+	EventMemberJoined Event = "member_joined"
+	// EventMemberRemoved is logged when a user leaves or is removed from a
+	// workspace
+	EventMemberRemoved Event = "member_removed"
+	// EventGitSyncConfigured is logged when a user sets the repository
+	// their notes are pushed to
+	EventGitSyncConfigured Event = "git_sync_configured"
+	// EventGitSyncRemoved is logged when a user stops syncing their notes
+	// to a repository
+	EventGitSyncRemoved Event = "git_sync_removed"
+	// EventBackupRestored is logged when an administrator restores a
+	// backup
+	EventBackupRestored Event = "backup_restored"
+	// EventRetentionUpdated is logged when a workspace's retention policy
+	// changes
+	EventRetentionUpdated Event = "retention_updated"
+	// EventAccountErased is logged when a user erases their account. It
+	// names neither the user nor where the request came from.
+	EventAccountErased Event = "account_erased"
+	// EventFeatureFlagUpdated is logged when an administrator changes,
+	// deletes or overrides a feature flag
+	EventFeatureFlagUpdated Event = "feature_flag_updated"
+	// EventMaintenanceUpdated is logged when an administrator turns
+	// maintenance mode on or off
+	EventMaintenanceUpdated Event = "maintenance_updated"
+	// EventRecordingUpdated is logged when a workspace starts or stops
+	// recording collaboration sessions
+	EventRecordingUpdated Event = "recording_updated"
+	// EventIdentityLinked is logged when single sign-on links an identity
+	// provider's account to an existing user by their email
+	EventIdentityLinked Event = "identity_linked"
+)
+
+const (
+	// DefaultLimit is how many events a listing returns by default
+	DefaultLimit = 50
+	// MaxLimit is the most events a listing returns in one page
+	MaxLimit = 200
+	// maxUserAgent is the width of the audit_log.user_agent column
+	maxUserAgent = 512
+)
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Entry is a single security event. UserID is the account it concerns and
+// ActorID who caused it, which differs when an administrator or workspace
+// owner acts on someone else's account. Either may be empty.
+type Entry struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id,omitempty"`
+	ActorID   string            `json:"actor_id,omitempty"`
+	Event     Event             `json:"event"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	Details   map[string]string `json:"details,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// FromRequest returns an entry for an event concerning userID, caused by the
+// authenticated user of c and carrying the client the request came from
+func FromRequest(c *fiber.Ctx, event Event, userID string) Entry {
+	actorID, _ := auth.UserIDFromCtx(c)
+	return Entry{
+		UserID:    userID,
+		ActorID:   actorID,
+		Event:     event,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+}
+
+// Logger appends entries to the audit log
+type Logger struct {
+	db DBInterface
+}
+
+// NewLogger creates a Logger with the provided database interface
+func NewLogger(db DBInterface) *Logger {
+	return &Logger{db: db}
+}
+
+// Log appends an entry to the audit log. Failures are logged rather than
+// returned so that auditing never fails the operation it describes.
+func (l *Logger) Log(ctx context.Context, e Entry) {
+	var details sql.NullString
+	if len(e.Details) > 0 {
+		encoded, err := json.Marshal(e.Details)
+		if err != nil {
+			log.Printf("Error encoding audit details for %s: %v", e.Event, err)
+			return
+		}
+		details = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	_, err := l.db.ExecContext(ctx,
+		"INSERT INTO audit_log (id, user_id, actor_id, event, ip, user_agent, details) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		uuid.New().String(), nullable(e.UserID), nullable(e.ActorID), string(e.Event), e.IP, pkg.Truncate(e.UserAgent, maxUserAgent), details,
+	)
+	if err != nil {
+		log.Printf("Error recording audit event %s for user %s: %v", e.Event, e.UserID, err)
+	}
+}
+
+// Handler serves the audit log
+type Handler struct {
+	db DBInterface
+}
+
+// NewHandler creates a new Handler with the provided database interface
+func NewHandler(db DBInterface) *Handler {
+	return &Handler{db: db}
+}
+
+const entryColumns = "SELECT id, user_id, actor_id, event, ip, user_agent, details, created_at FROM audit_log"
+
+// GetMyEvents lists the security events concerning the authenticated user,
+// newest first, paged with ?limit= and ?offset=
+func (h *Handler) GetMyEvents(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	limit, offset, err := paging.Parse(c, DefaultLimit, MaxLimit)
+	if err != nil {
+		return err
+	}
+
+	return h.list(c, entryColumns+" WHERE user_id = ? ORDER BY created_at DESC, id LIMIT ? OFFSET ?", userID, limit, offset)
+}
+
+// ListEvents lists security events across all users for administrators,
+// newest first. ?user_id= and ?event= narrow the list, and ?limit= and
+// ?offset= page through it.
+func (h *Handler) ListEvents(c *fiber.Ctx) error {
+	limit, offset, err := paging.Parse(c, DefaultLimit, MaxLimit)
+	if err != nil {
+		return err
+	}
+
+	query := entryColumns + " WHERE 1 = 1"
+	var args []any
+	if userID := c.Query("user_id"); userID != "" {
+		query += " AND user_id = ?"
+		args = append(args, userID)
+	}
+	if event := c.Query("event"); event != "" {
+		query += " AND event = ?"
+		args = append(args, event)
+	}
+	query += " ORDER BY created_at DESC, id LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	return h.list(c, query, args...)
+}
+
+// list runs an audit log query and writes the results as JSON
+func (h *Handler) list(c *fiber.Ctx, query string, args ...any) error {
+	rows, err := h.db.QueryContext(c.UserContext(), query, args...)
+	if err != nil {
+		return fmt.Errorf("fetching audit log: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var userID, actorID, details sql.NullString
+		if err := rows.Scan(&e.ID, &userID, &actorID, &e.Event, &e.IP, &e.UserAgent, &details, &e.CreatedAt); err != nil {
+			return fmt.Errorf("scanning audit entry: %w", err)
+		}
+		e.UserID, e.ActorID = userID.String, actorID.String
+		if details.Valid {
+			if err := json.Unmarshal([]byte(details.String), &e.Details); err != nil {
+				log.Println("Error decoding audit details:", err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching audit log: %w", err)
+	}
+
+	return c.JSON(entries)
+}
+
+// nullable stores an empty ID as NULL
+func nullable(id string) sql.NullString {
+	return sql.NullString{String: id, Valid: id != ""}
+}
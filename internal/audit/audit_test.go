@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	insertQuery = regexp.QuoteMeta("INSERT INTO audit_log (id, user_id, actor_id, event, ip, user_agent, details) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	entryRows   = []string{"id", "user_id", "actor_id", "event", "ip", "user_agent", "details", "created_at"}
+)
+
+func TestLog(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	logger := NewLogger(db)
+
+	mockDB.ExpectExec(insertQuery).
+		WithArgs(sqlmock.AnyArg(), "user123", "user123", "login_failed", "10.0.0.1", "curl/8.0", `{"reason":"wrong_password"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	logger.Log(context.Background(), Entry{
+		UserID:    "user123",
+		ActorID:   "user123",
+		Event:     EventLoginFailed,
+		IP:        "10.0.0.1",
+		UserAgent: "curl/8.0",
+		Details:   map[string]string{"reason": "wrong_password"},
+	})
+
+	// Unknown users are stored as NULL, and a failed insert is only logged
+	mockDB.ExpectExec(insertQuery).
+		WithArgs(sqlmock.AnyArg(), nil, nil, "login_failed", "", strings.Repeat("a", maxUserAgent), nil).
+		WillReturnError(errors.New("database error"))
+	logger.Log(context.Background(), Entry{Event: EventLoginFailed, UserAgent: strings.Repeat("a", maxUserAgent+10)})
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+// newTestApp mounts the audit routes with userID authenticated
+func newTestApp(t *testing.T, userID string) (*fiber.App, sqlmock.Sqlmock) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	handler := NewHandler(db)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", userID)
+		return c.Next()
+	})
+	app.Get("/me/security-events", handler.GetMyEvents)
+	app.Get("/admin/security-events", handler.ListEvents)
+
+	return app, mockDB
+}
+
+func TestGetMyEvents(t *testing.T) {
+	app, mockDB := newTestApp(t, "user123")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, actor_id, event, ip, user_agent, details, created_at FROM audit_log WHERE user_id = ? ORDER BY created_at DESC, id LIMIT ? OFFSET ?")).
+		WithArgs("user123", 10, 20).
+		WillReturnRows(sqlmock.NewRows(entryRows).
+			AddRow("e2", "user123", "admin1", "account_locked", "10.0.0.2", "", `{"until":"2026-01-01T00:00:00Z"}`, now).
+			AddRow("e1", "user123", "user123", "login", "10.0.0.1", "curl/8.0", nil, now))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/me/security-events?limit=10&offset=20", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var entries []Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "admin1", entries[0].ActorID)
+		assert.Equal(t, map[string]string{"until": "2026-01-01T00:00:00Z"}, entries[0].Details)
+		assert.Equal(t, EventLogin, entries[1].Event)
+		assert.Nil(t, entries[1].Details)
+	}
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/me/security-events?limit=0", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestListEvents(t *testing.T) {
+	testCases := []struct {
+		name  string
+		query string
+		sql   string
+		args  []driver.Value
+	}{
+		{
+			name:  "All",
+			query: "",
+			sql:   "SELECT id, user_id, actor_id, event, ip, user_agent, details, created_at FROM audit_log WHERE 1 = 1 ORDER BY created_at DESC, id LIMIT ? OFFSET ?",
+			args:  []driver.Value{DefaultLimit, 0},
+		},
+		{
+			name:  "Filtered",
+			query: "?user_id=user456&event=login_failed&limit=500",
+			sql:   "SELECT id, user_id, actor_id, event, ip, user_agent, details, created_at FROM audit_log WHERE 1 = 1 AND user_id = ? AND event = ? ORDER BY created_at DESC, id LIMIT ? OFFSET ?",
+			args:  []driver.Value{"user456", "login_failed", MaxLimit, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, mockDB := newTestApp(t, "admin1")
+			mockDB.ExpectQuery(regexp.QuoteMeta(tc.sql)).
+				WithArgs(tc.args...).
+				WillReturnRows(sqlmock.NewRows(entryRows).AddRow("e1", nil, nil, "login_failed", "10.0.0.1", "", `{"email":"x@example.com"}`, time.Now()))
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/admin/security-events"+tc.query, nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			var entries []Entry
+			if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			if assert.Len(t, entries, 1) {
+				assert.Empty(t, entries[0].UserID)
+			}
+
+			if err := mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
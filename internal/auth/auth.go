@@ -0,0 +1,37 @@
+// Package auth reads the identity the authentication middleware attached to
+// a request, without trusting its type
+package auth
+
+import (
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// UserIDKey is the Locals key the authentication middleware stores the
+// user ID under
+const UserIDKey = "user-id"
+
+// errNoUser answers requests whose user ID is missing or not a string,
+// such as tokens signed by the legacy handler with numeric IDs
+var errNoUser = apperr.New(fiber.StatusUnauthorized, "Invalid token claims")
+
+// UserIDFromCtx returns the authenticated user's ID, or an error to answer
+// the request with when there is none
+func UserIDFromCtx(c *fiber.Ctx) (string, error) {
+	return userID(c.Locals(UserIDKey))
+}
+
+// UserIDFromConn is UserIDFromCtx for an upgraded WebSocket connection
+func UserIDFromConn(c *websocket.Conn) (string, error) {
+	return userID(c.Locals(UserIDKey))
+}
+
+func userID(v any) (string, error) {
+	id, ok := v.(string)
+	if !ok || id == "" {
+		return "", errNoUser
+	}
+	return id, nil
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserIDFromCtx(t *testing.T) {
+	testCases := []struct {
+		name           string
+		local          any
+		expectedStatus int
+	}{
+		{name: "String ID", local: "user123", expectedStatus: fiber.StatusOK},
+		{name: "Numeric ID", local: float64(42), expectedStatus: fiber.StatusUnauthorized},
+		{name: "Empty ID", local: "", expectedStatus: fiber.StatusUnauthorized},
+		{name: "Missing", expectedStatus: fiber.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+			app.Get("/", func(c *fiber.Ctx) error {
+				if tc.local != nil {
+					c.Locals(UserIDKey, tc.local)
+				}
+				userID, err := UserIDFromCtx(c)
+				if err != nil {
+					return err
+				}
+				return c.SendString(userID)
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+}
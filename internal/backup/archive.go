@@ -0,0 +1,264 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"quanta/internal/db"
+)
+
+// FormatVersion is written at the start of each archive and checked when
+// restoring one
+const FormatVersion = 1
+
+// encryptedMagic starts encrypted archives, followed by the GCM nonce
+var encryptedMagic = []byte("QBAK1")
+
+// table is a table an archive holds. Timestamp columns are listed apart so
+// they can be written as RFC 3339 and read back as times on every database.
+type table struct {
+	name    string
+	columns []string
+	times   map[string]bool
+}
+
+// tables are backed up in an order that restores parents before the rows
+// that reference them
+var tables = []table{
+	{
+		name: "users",
+		columns: []string{"id", "email", "password", "password_version", "display_name", "avatar_url", "timezone",
+			"public_key", "role", "token_version", "failed_logins", "locked_until", "created_at", "deleted_at"},
+		times: map[string]bool{"locked_until": true, "created_at": true, "deleted_at": true},
+	},
+	{name: "workspaces", columns: []string{"id", "name", "created_at"}, times: map[string]bool{"created_at": true}},
+	{
+		name:    "workspace_members",
+		columns: []string{"workspace_id", "user_id", "role", "created_at"},
+		times:   map[string]bool{"created_at": true},
+	},
+	{
+		name: "notes",
+		columns: []string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "size",
+			"word_count", "char_count", "encrypted", "metadata", "color", "icon", "created_at", "updated_at"},
+		times: map[string]bool{"created_at": true, "updated_at": true},
+	},
+	{
+		name:    "note_keys",
+		columns: []string{"note_id", "user_id", "wrapped_key", "created_at"},
+		times:   map[string]bool{"created_at": true},
+	},
+	{
+		name:    "note_revisions",
+		columns: []string{"note_id", "version", "user_id", "title", "content", "created_at"},
+		times:   map[string]bool{"created_at": true},
+	},
+	{
+		name:    "note_revision_chunks",
+		columns: []string{"note_id", "first_version", "last_version", "storage_key", "size", "created_at"},
+		times:   map[string]bool{"created_at": true},
+	},
+}
+
+// header is the first line of an archive
+type header struct {
+	Format    int       `json:"format"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// record is a row of an archive
+type record struct {
+	Table string         `json:"table"`
+	Row   map[string]any `json:"row"`
+}
+
+// Dump writes every row of the backed up tables to w as gzipped JSON
+// lines, returning the number of rows written
+func Dump(ctx context.Context, conn DBInterface, w io.Writer, now time.Time) (int, error) {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	if err := enc.Encode(header{Format: FormatVersion, CreatedAt: now.UTC()}); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, t := range tables {
+		n, err := dumpTable(ctx, conn, enc, t)
+		if err != nil {
+			return total, fmt.Errorf("dumping %s: %w", t.name, err)
+		}
+		total += n
+	}
+	return total, gz.Close()
+}
+
+func dumpTable(ctx context.Context, conn DBInterface, enc *json.Encoder, t table) (int, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT "+strings.Join(t.columns, ", ")+" FROM "+t.name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	n := 0
+	values := make([]any, len(t.columns))
+	dest := make([]any, len(t.columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		row := make(map[string]any, len(t.columns))
+		for i, col := range t.columns {
+			// MySQL returns text as bytes
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[col] = values[i]
+		}
+		if err := enc.Encode(record{Table: t.name, Row: row}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// Load inserts the rows of an archive written by Dump that are missing
+// from the database, returning how many it inserted and how many it
+// skipped. Rows that already exist, by key or unique column, are left as
+// they are, so loading is safe to repeat and brings back deleted data
+// without undoing later changes.
+func Load(ctx context.Context, conn DBInterface, r io.Reader) (loaded, skipped int, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading archive: %w", err)
+	}
+	dec := json.NewDecoder(bufio.NewReader(gz))
+	dec.UseNumber()
+
+	var h header
+	if err := dec.Decode(&h); err != nil {
+		return 0, 0, fmt.Errorf("reading archive header: %w", err)
+	}
+	if h.Format != FormatVersion {
+		return 0, 0, fmt.Errorf("archive format %d is not supported", h.Format)
+	}
+
+	byName := make(map[string]table, len(tables))
+	for _, t := range tables {
+		byName[t.name] = t
+	}
+	for {
+		var rec record
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return loaded, skipped, fmt.Errorf("reading archive: %w", err)
+		}
+		t, ok := byName[rec.Table]
+		if !ok {
+			return loaded, skipped, fmt.Errorf("archive has unknown table %q", rec.Table)
+		}
+
+		args := make([]any, len(t.columns))
+		for i, col := range t.columns {
+			args[i], err = t.value(col, rec.Row[col])
+			if err != nil {
+				return loaded, skipped, fmt.Errorf("restoring %s: %w", t.name, err)
+			}
+		}
+		_, err := conn.ExecContext(ctx,
+			"INSERT INTO "+t.name+" ("+strings.Join(t.columns, ", ")+") VALUES (?"+strings.Repeat(", ?", len(t.columns)-1)+")",
+			args...,
+		)
+		if db.IsDuplicate(err) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return loaded, skipped, fmt.Errorf("restoring %s: %w", t.name, err)
+		}
+		loaded++
+	}
+	return loaded, skipped, nil
+}
+
+// value converts a column's value read from an archive to one the
+// database driver accepts
+func (t table) value(col string, v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		// Times a driver returned as text aren't RFC 3339 and go back as
+		// they came
+		if ts, err := time.Parse(time.RFC3339Nano, v); err == nil && t.times[col] {
+			return ts, nil
+		}
+		return v, nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	default:
+		return v, nil
+	}
+}
+
+// seal encrypts an archive with AES-GCM under key
+func seal(key, archive []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(bytes.Clone(encryptedMagic), nonce...)
+	return gcm.Seal(out, nonce, archive, encryptedMagic), nil
+}
+
+// open decrypts an archive sealed with key. Archives that were not
+// encrypted are returned as they are.
+func open(key, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+	if len(key) == 0 {
+		return nil, errors.New("archive is encrypted but no backup key is configured")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("archive is truncated")
+	}
+	archive, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], encryptedMagic)
+	if err != nil {
+		return nil, errors.New("archive could not be decrypted with the configured backup key")
+	}
+	return archive, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
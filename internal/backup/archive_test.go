@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expectDump expects the queries Dump makes, returning one user and one
+// note and nothing from the other tables
+func expectDump(mockDB sqlmock.Sqlmock, created time.Time) {
+	for _, t := range tables {
+		rows := sqlmock.NewRows(t.columns)
+		switch t.name {
+		case "users":
+			rows.AddRow("user1", []byte("alice@example.com"), "hash", 2, "Alice", nil, "UTC",
+				nil, "admin", 0, 0, nil, created, nil)
+		case "notes":
+			rows.AddRow("note1", "user1", nil, "Plans", "Ship it", true, false, 3, 7,
+				2, 7, false, []byte(`{"project":"alpha"}`), "blue", nil, created, created)
+		}
+		mockDB.ExpectQuery(regexp.QuoteMeta("SELECT " + strings.Join(t.columns, ", ") + " FROM " + t.name)).WillReturnRows(rows)
+	}
+}
+
+func TestDumpAndLoad(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	expectDump(mockDB, created)
+	var archive bytes.Buffer
+	rows, err := Dump(context.Background(), db, &archive, created)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rows)
+
+	// The user is still there; the note was deleted
+	mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO users (id, email, password, password_version, display_name, avatar_url, timezone, public_key, role, token_version, failed_logins, locked_until, created_at, deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")).
+		WithArgs("user1", "alice@example.com", "hash", int64(2), "Alice", nil, "UTC", nil, "admin", int64(0), int64(0), nil, created, nil).
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO notes (id, user_id")).
+		WithArgs("note1", "user1", nil, "Plans", "Ship it", true, false, int64(3), int64(7), int64(2), int64(7), false, `{"project":"alpha"}`, "blue", nil, created, created).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	loaded, skipped, err := Load(context.Background(), db, &archive)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded)
+	assert.Equal(t, 1, skipped)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestLoadRejectsOtherFormats(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+
+	_, _, err = Load(context.Background(), db, strings.NewReader("not gzip"))
+	assert.ErrorContains(t, err, "reading archive")
+}
+
+func TestSealAndOpen(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	archive := []byte("archive")
+
+	sealed, err := seal(key, archive)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "archive")
+
+	opened, err := open(key, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, archive, opened)
+
+	_, err = open(bytes.Repeat([]byte{8}, KeySize), sealed)
+	assert.EqualError(t, err, "archive could not be decrypted with the configured backup key")
+	_, err = open(nil, sealed)
+	assert.EqualError(t, err, "archive is encrypted but no backup key is configured")
+
+	// Archives taken before a key was configured still open
+	opened, err = open(key, archive)
+	require.NoError(t, err)
+	assert.Equal(t, archive, opened)
+}
@@ -0,0 +1,451 @@
+// Package backup dumps users, workspaces, notes and their revisions to
+// compressed, optionally encrypted archives in blob storage, on a schedule
+// or on demand, and restores them. Every route is expected to sit behind
+// RequireRole(RoleAdmin).
+package backup
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/storage"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultInterval is how often backups are taken unless configured
+	DefaultInterval = 24 * time.Hour
+	// DefaultKeep is how many successful backups are kept unless
+	// configured; older ones are deleted
+	DefaultKeep = 7
+	// ListLimit is the most backups ListBackups returns
+	ListLimit = 100
+	// KeySize is the length in bytes of the AES-256 key backups are
+	// encrypted with
+	KeySize = 32
+)
+
+// archiveKey matches the storage keys Start gives archives
+var archiveKey = regexp.MustCompile(`^backups/[0-9]{8}T[0-9]{6}Z-[0-9a-f]{8}\.jsonl\.gz(\.enc)?$`)
+
+// Status is how far along a backup or restore is
+type Status string
+
+const (
+	// Running backups are still being written, and running restores
+	// still loading
+	Running Status = "running"
+	// Done backups can be restored
+	Done Status = "done"
+	// Failed backups and restores stopped early; Error says why
+	Failed Status = "failed"
+)
+
+// Backup is an archive of the database and where it is stored
+type Backup struct {
+	ID         string     `json:"id"`
+	Status     Status     `json:"status"`
+	StorageKey string     `json:"storage_key"`
+	Size       int64      `json:"size"`
+	Rows       int        `json:"rows"`
+	Encrypted  bool       `json:"encrypted"`
+	Error      *string    `json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// Restore is a run of RestoreBackup and how many rows it put back.
+// BackupID is unset when the archive was given by storage key.
+type Restore struct {
+	ID         string     `json:"id"`
+	BackupID   *string    `json:"backup_id"`
+	StorageKey string     `json:"storage_key"`
+	Status     Status     `json:"status"`
+	Restored   int        `json:"restored"`
+	Skipped    int        `json:"skipped"`
+	Error      *string    `json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// RestorePayload is the request body for RestoreBackup, naming the archive
+// to restore by BackupID or StorageKey
+type RestorePayload struct {
+	BackupID   string `json:"backup_id"`
+	StorageKey string `json:"storage_key"`
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Handler takes and restores backups and serves them to administrators
+type Handler struct {
+	db    DBInterface
+	store storage.Storage
+	// key encrypts archives when set
+	key   []byte
+	audit AuditLogger
+	// async runs on-demand backups and restores in the background
+	async func(func())
+}
+
+// NewHandler creates a new Handler that writes archives to store,
+// encrypting them with key unless it is empty
+func NewHandler(db DBInterface, store storage.Storage, key []byte, auditLog AuditLogger) *Handler {
+	return &Handler{
+		db:    db,
+		store: store,
+		key:   key,
+		audit: auditLog,
+		async: func(run func()) { go run() },
+	}
+}
+
+// Start records a new backup as running, to be written by Run
+func (h *Handler) Start(ctx context.Context, now time.Time) (Backup, error) {
+	now = now.UTC().Truncate(time.Second)
+	id := uuid.New().String()
+	b := Backup{
+		ID:         id,
+		Status:     Running,
+		StorageKey: "backups/" + now.Format("20060102T150405Z") + "-" + id[:8] + ".jsonl.gz",
+		Encrypted:  len(h.key) > 0,
+		CreatedAt:  now,
+	}
+	if b.Encrypted {
+		b.StorageKey += ".enc"
+	}
+	_, err := h.db.ExecContext(ctx,
+		"INSERT INTO backups (id, status, storage_key, encrypted, created_at) VALUES (?, ?, ?, ?, ?)",
+		b.ID, string(b.Status), b.StorageKey, b.Encrypted, b.CreatedAt,
+	)
+	if err != nil {
+		return b, fmt.Errorf("recording backup: %w", err)
+	}
+	return b, nil
+}
+
+// Run dumps the database to b's archive and records how it went
+func (h *Handler) Run(ctx context.Context, b Backup) error {
+	err := h.write(ctx, &b)
+	status, reason := Done, sql.NullString{}
+	if err != nil {
+		status, reason = Failed, sql.NullString{String: err.Error(), Valid: true}
+	}
+	_, updateErr := h.db.ExecContext(ctx,
+		"UPDATE backups SET status = ?, size = ?, row_count = ?, error = ?, finished_at = ? WHERE id = ?",
+		string(status), b.Size, b.Rows, reason, time.Now().UTC(), b.ID,
+	)
+	if updateErr != nil {
+		return fmt.Errorf("recording backup: %w", updateErr)
+	}
+	return err
+}
+
+func (h *Handler) write(ctx context.Context, b *Backup) error {
+	var buf bytes.Buffer
+	rows, err := Dump(ctx, h.db, &buf, b.CreatedAt)
+	if err != nil {
+		return err
+	}
+	archive := buf.Bytes()
+	if b.Encrypted {
+		if archive, err = seal(h.key, archive); err != nil {
+			return fmt.Errorf("encrypting backup: %w", err)
+		}
+	}
+	if err := h.store.Put(ctx, b.StorageKey, bytes.NewReader(archive), int64(len(archive)), "application/octet-stream"); err != nil {
+		return fmt.Errorf("storing backup: %w", err)
+	}
+	b.Rows, b.Size = rows, int64(len(archive))
+	return nil
+}
+
+// Prune deletes all but the newest keep successful backups, and failed
+// ones older than the oldest kept, returning how many were deleted
+func (h *Handler) Prune(ctx context.Context, keep int) (int, error) {
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT id, status, storage_key FROM backups WHERE status <> ? ORDER BY created_at DESC, id",
+		string(Running),
+	)
+	if err != nil {
+		return 0, err
+	}
+	type stale struct{ id, key string }
+	var old []stale
+	kept := 0
+	for rows.Next() {
+		var id, status, key string
+		if err := rows.Scan(&id, &status, &key); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		if kept < keep {
+			if Status(status) == Done {
+				kept++
+			}
+			continue
+		}
+		old = append(old, stale{id, key})
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	for _, b := range old {
+		if err := h.store.Delete(ctx, b.key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return 0, fmt.Errorf("deleting backup %s: %w", b.id, err)
+		}
+		if _, err := h.db.ExecContext(ctx, "DELETE FROM backups WHERE id = ?", b.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(old), nil
+}
+
+// ListBackups returns the most recent backups, newest first
+func (h *Handler) ListBackups(c *fiber.Ctx) error {
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT id, status, storage_key, size, row_count, encrypted, error, created_at, finished_at FROM backups ORDER BY created_at DESC, id LIMIT ?",
+		ListLimit,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching backups: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	backups := []Backup{}
+	for rows.Next() {
+		b, err := scanBackup(rows)
+		if err != nil {
+			return fmt.Errorf("scanning backups: %w", err)
+		}
+		backups = append(backups, b)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching backups: %w", err)
+	}
+
+	return c.JSON(backups)
+}
+
+// CreateBackup starts a backup straight away, responding with the backup
+// to poll in ListBackups
+func (h *Handler) CreateBackup(c *fiber.Ctx) error {
+	b, err := h.Start(c.UserContext(), time.Now())
+	if err != nil {
+		return err
+	}
+	h.async(func() {
+		if err := h.Run(context.Background(), b); err != nil {
+			log.Printf("Error taking backup %s: %v", b.ID, err)
+		}
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(b)
+}
+
+// RestoreBackup starts putting back the rows of an archive that are
+// missing from the database, responding with the restore to poll in
+// GetRestore. Existing rows are left alone. The archive is given by backup
+// ID or, after losing the database and the backups table with it, by its
+// key in storage.
+func (h *Handler) RestoreBackup(c *fiber.Ctx) error {
+	var payload RestorePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	payload.StorageKey = strings.TrimSpace(payload.StorageKey)
+	switch {
+	case (payload.BackupID == "") == (payload.StorageKey == ""):
+		return apperr.Invalid(map[string]string{"backup_id": "give either backup_id or storage_key"})
+	case payload.StorageKey != "" && !archiveKey.MatchString(payload.StorageKey):
+		return apperr.Invalid(map[string]string{"storage_key": "must be the key of a backup archive, such as backups/20260101T000000Z-1a2b3c4d.jsonl.gz"})
+	}
+
+	ctx := c.UserContext()
+	r := Restore{
+		ID:         uuid.New().String(),
+		StorageKey: payload.StorageKey,
+		Status:     Running,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	if payload.BackupID != "" {
+		b, err := scanBackup(h.db.QueryRowContext(ctx,
+			"SELECT id, status, storage_key, size, row_count, encrypted, error, created_at, finished_at FROM backups WHERE id = ?",
+			payload.BackupID,
+		))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperr.New(fiber.StatusNotFound, "Backup not found")
+			}
+			return fmt.Errorf("fetching backup: %w", err)
+		}
+		if b.Status != Done {
+			return apperr.New(fiber.StatusConflict, "Only finished backups can be restored")
+		}
+		r.BackupID, r.StorageKey = &b.ID, b.StorageKey
+	}
+
+	_, err := h.db.ExecContext(ctx,
+		"INSERT INTO backup_restores (id, backup_id, storage_key, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		r.ID, r.BackupID, r.StorageKey, string(r.Status), r.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("recording restore: %w", err)
+	}
+
+	entry := audit.FromRequest(c, audit.EventBackupRestored, "")
+	entry.Details = map[string]string{"restore_id": r.ID, "storage_key": r.StorageKey}
+	h.audit.Log(ctx, entry)
+
+	// Restores outlast the request timeout, so they run in the background
+	h.async(func() {
+		if err := h.restore(context.Background(), r); err != nil {
+			log.Printf("Error restoring %s: %v", r.StorageKey, err)
+		}
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(r)
+}
+
+// GetRestore returns how a restore started by RestoreBackup is going
+func (h *Handler) GetRestore(c *fiber.Ctx) error {
+	var r Restore
+	var backupID, reason sql.NullString
+	var finishedAt sql.NullTime
+	err := h.db.QueryRowContext(c.UserContext(),
+		"SELECT id, backup_id, storage_key, status, restored, skipped, error, created_at, finished_at FROM backup_restores WHERE id = ?",
+		c.Params("id"),
+	).Scan(&r.ID, &backupID, &r.StorageKey, &r.Status, &r.Restored, &r.Skipped, &reason, &r.CreatedAt, &finishedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Restore not found")
+		}
+		return fmt.Errorf("fetching restore: %w", err)
+	}
+	if backupID.Valid {
+		r.BackupID = &backupID.String
+	}
+	if reason.Valid {
+		r.Error = &reason.String
+	}
+	if finishedAt.Valid {
+		r.FinishedAt = &finishedAt.Time
+	}
+	return c.JSON(r)
+}
+
+// restore loads r's archive and records how it went
+func (h *Handler) restore(ctx context.Context, r Restore) error {
+	err := h.load(ctx, &r)
+	status, reason := Done, sql.NullString{}
+	if err != nil {
+		status, reason = Failed, sql.NullString{String: err.Error(), Valid: true}
+	}
+	_, updateErr := h.db.ExecContext(ctx,
+		"UPDATE backup_restores SET status = ?, restored = ?, skipped = ?, error = ?, finished_at = ? WHERE id = ?",
+		string(status), r.Restored, r.Skipped, reason, time.Now().UTC(), r.ID,
+	)
+	if updateErr != nil {
+		return fmt.Errorf("recording restore: %w", updateErr)
+	}
+	return err
+}
+
+func (h *Handler) load(ctx context.Context, r *Restore) error {
+	rc, err := h.store.Get(ctx, r.StorageKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return errors.New("backup archive is missing from storage")
+		}
+		return fmt.Errorf("reading backup: %w", err)
+	}
+	data, err := io.ReadAll(rc)
+	if closeErr := rc.Close(); closeErr != nil {
+		log.Println("Error closing backup:", closeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("reading backup: %w", err)
+	}
+
+	archive, err := open(h.key, data)
+	if err != nil {
+		return err
+	}
+	r.Restored, r.Skipped, err = Load(ctx, h.db, bytes.NewReader(archive))
+	return err
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanBackup(s scanner) (Backup, error) {
+	var b Backup
+	var reason sql.NullString
+	var finishedAt sql.NullTime
+	err := s.Scan(&b.ID, &b.Status, &b.StorageKey, &b.Size, &b.Rows, &b.Encrypted, &reason, &b.CreatedAt, &finishedAt)
+	if reason.Valid {
+		b.Error = &reason.String
+	}
+	if finishedAt.Valid {
+		b.FinishedAt = &finishedAt.Time
+	}
+	return b, err
+}
+
+// StartScheduler takes a backup every interval, then prunes all but the
+// newest keep, until stop is closed
+// No backup is taken while paused reports true.
+func StartScheduler(h *Handler, interval time.Duration, keep int, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			b, err := h.Start(ctx, now)
+			if err == nil {
+				err = h.Run(ctx, b)
+			}
+			if err != nil {
+				cancel()
+				log.Println("Error taking scheduled backup:", err)
+				continue
+			}
+			if _, err := h.Prune(ctx, keep); err != nil {
+				log.Println("Error pruning backups:", err)
+			}
+			cancel()
+		case <-stop:
+			return
+		}
+	}
+}
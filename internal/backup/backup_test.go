@@ -0,0 +1,244 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	backupQuery = regexp.QuoteMeta("SELECT id, status, storage_key, size, row_count, encrypted, error, created_at, finished_at FROM backups WHERE id = ?")
+	backupCols  = []string{"id", "status", "storage_key", "size", "row_count", "encrypted", "error", "created_at", "finished_at"}
+)
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+type testHelper struct {
+	mockDB  sqlmock.Sqlmock
+	store   *storage.Local
+	app     *fiber.App
+	audit   *fakeAudit
+	handler *Handler
+}
+
+func newTestHelper(t *testing.T, key []byte) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	auditLog := &fakeAudit{}
+	handler := NewHandler(db, store, key, auditLog)
+	handler.async = func(run func()) { run() }
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Get("/admin/backups", handler.ListBackups)
+	app.Post("/admin/backups", handler.CreateBackup)
+	app.Post("/admin/restores", handler.RestoreBackup)
+	app.Get("/admin/restores/:id", handler.GetRestore)
+
+	return &testHelper{mockDB: mockDB, store: store, app: app, audit: auditLog, handler: handler}
+}
+
+func TestCreateBackup(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, KeySize)
+	h := newTestHelper(t, key)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO backups (id, status, storage_key, encrypted, created_at) VALUES (?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "running", sqlmock.AnyArg(), true, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectDump(h.mockDB, created)
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE backups SET status = ?, size = ?, row_count = ?, error = ?, finished_at = ? WHERE id = ?")).
+		WithArgs("done", sqlmock.AnyArg(), 2, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := h.app.Test(httptest.NewRequest("POST", "/admin/backups", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+	var b Backup
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&b))
+	assert.Regexp(t, archiveKey, b.StorageKey)
+	assert.True(t, strings.HasSuffix(b.StorageKey, ".enc"))
+
+	rc, err := h.store.Get(context.Background(), b.StorageKey)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.True(t, bytes.HasPrefix(data, encryptedMagic))
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestRestoreBackup(t *testing.T) {
+	key := "backups/20260301T120000Z-1a2b3c4d.jsonl.gz"
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		body           string
+		status         string
+		expectedStatus int
+		expectedErrors map[string]string
+	}{
+		{name: "by backup", body: `{"backup_id":"backup1"}`, status: "done", expectedStatus: fiber.StatusAccepted},
+		{name: "by storage key", body: `{"storage_key":"` + key + `"}`, expectedStatus: fiber.StatusAccepted},
+		{name: "unknown backup", body: `{"backup_id":"backup1"}`, expectedStatus: fiber.StatusNotFound},
+		{name: "running backup", body: `{"backup_id":"backup1"}`, status: "running", expectedStatus: fiber.StatusConflict},
+		{
+			name:           "neither",
+			body:           `{}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{"backup_id": "give either backup_id or storage_key"},
+		},
+		{
+			name:           "not an archive",
+			body:           `{"storage_key":"attachments/secret.pdf"}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{"storage_key": "must be the key of a backup archive, such as backups/20260101T000000Z-1a2b3c4d.jsonl.gz"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t, nil)
+			if strings.Contains(tc.body, "backup_id") {
+				rows := sqlmock.NewRows(backupCols)
+				if tc.status != "" {
+					rows.AddRow("backup1", tc.status, key, 100, 2, false, nil, created, created)
+				}
+				h.mockDB.ExpectQuery(backupQuery).WithArgs("backup1").WillReturnRows(rows)
+			}
+			if tc.expectedStatus == fiber.StatusAccepted {
+				var archive bytes.Buffer
+				dumpDB, dumpMock, err := sqlmock.New()
+				require.NoError(t, err)
+				expectDump(dumpMock, created)
+				_, err = Dump(context.Background(), dumpDB, &archive, created)
+				require.NoError(t, err)
+				require.NoError(t, h.store.Put(context.Background(), key, &archive, int64(archive.Len()), "application/octet-stream"))
+
+				h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO backup_restores (id, backup_id, storage_key, status, created_at) VALUES (?, ?, ?, ?, ?)")).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), key, "running", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO users")).WillReturnResult(sqlmock.NewResult(0, 1))
+				h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO notes")).WillReturnResult(sqlmock.NewResult(0, 1))
+				h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE backup_restores SET status = ?, restored = ?, skipped = ?, error = ?, finished_at = ? WHERE id = ?")).
+					WithArgs("done", 2, 0, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			req := httptest.NewRequest("POST", "/admin/restores", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := h.app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedErrors != nil {
+				var body apperr.Response
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tc.expectedErrors, body.Errors)
+			}
+			if tc.expectedStatus == fiber.StatusAccepted {
+				var r Restore
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+				assert.Equal(t, key, r.StorageKey)
+				if assert.Len(t, h.audit.entries, 1) {
+					assert.Equal(t, audit.EventBackupRestored, h.audit.entries[0].Event)
+				}
+			} else {
+				assert.Empty(t, h.audit.entries)
+			}
+			assert.NoError(t, h.mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRestoreMissingArchive(t *testing.T) {
+	h := newTestHelper(t, nil)
+	key := "backups/20260301T120000Z-1a2b3c4d.jsonl.gz"
+
+	h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO backup_restores")).WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE backup_restores SET status = ?, restored = ?, skipped = ?, error = ?, finished_at = ? WHERE id = ?")).
+		WithArgs("failed", 0, 0, "backup archive is missing from storage", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	req := httptest.NewRequest("POST", "/admin/restores", strings.NewReader(`{"storage_key":"`+key+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestPrune(t *testing.T) {
+	h := newTestHelper(t, nil)
+	for _, key := range []string{"backups/b", "backups/d"} {
+		require.NoError(t, h.store.Put(context.Background(), key, strings.NewReader("x"), 1, "application/octet-stream"))
+	}
+
+	// Newest first: two successful backups are kept, along with the failed
+	// one between them
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, status, storage_key FROM backups WHERE status <> ? ORDER BY created_at DESC, id")).
+		WithArgs("running").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "storage_key"}).
+			AddRow("a", "done", "backups/a").
+			AddRow("f", "failed", "backups/f").
+			AddRow("c", "done", "backups/c").
+			AddRow("b", "failed", "backups/b").
+			AddRow("d", "done", "backups/d"))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM backups WHERE id = ?")).WithArgs("b").WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM backups WHERE id = ?")).WithArgs("d").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	pruned, err := h.handler.Prune(context.Background(), 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, pruned)
+	_, err = h.store.Get(context.Background(), "backups/d")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestGetRestore(t *testing.T) {
+	h := newTestHelper(t, nil)
+	query := regexp.QuoteMeta("SELECT id, backup_id, storage_key, status, restored, skipped, error, created_at, finished_at FROM backup_restores WHERE id = ?")
+	cols := []string{"id", "backup_id", "storage_key", "status", "restored", "skipped", "error", "created_at", "finished_at"}
+
+	h.mockDB.ExpectQuery(query).WithArgs("restore1").
+		WillReturnRows(sqlmock.NewRows(cols).AddRow("restore1", nil, "backups/a", "done", 10, 2, nil, time.Now(), time.Now()))
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/admin/restores/restore1", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var r Restore
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+	assert.Equal(t, 10, r.Restored)
+	assert.Nil(t, r.BackupID)
+
+	h.mockDB.ExpectQuery(query).WithArgs("missing").WillReturnRows(sqlmock.NewRows(cols))
+	resp, err = h.app.Test(httptest.NewRequest("GET", "/admin/restores/missing", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
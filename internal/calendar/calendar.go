@@ -0,0 +1,227 @@
+// Package calendar serves a user's reminders and task due dates as an
+// iCalendar feed that calendar apps can subscribe to
+package calendar
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/checklist"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MaxFeedEvents is the most reminders, and separately the most tasks, a
+// feed lists
+const MaxFeedEvents = 500
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Feed is the response to GetFeed
+type Feed struct {
+	// URL is the feed to subscribe to. Anyone with it can read the feed
+	// until the user's tokens are revoked, e.g. by changing password.
+	URL string `json:"url"`
+}
+
+// Handler handles HTTP requests related to the calendar feed
+type Handler struct {
+	db   DBInterface
+	keys *pkg.JWTKeys
+}
+
+// NewHandler creates a new Handler that signs feed tokens with keys
+func NewHandler(db DBInterface, keys *pkg.JWTKeys) *Handler {
+	return &Handler{db: db, keys: keys}
+}
+
+// errInvalidToken answers feed requests whose token is missing, forged or
+// revoked
+var errInvalidToken = apperr.New(fiber.StatusUnauthorized, "Invalid calendar feed token")
+
+// feedToken signs a token for userID's feed with the current key. It
+// carries the user's token version, so revoking their tokens revokes it
+// too, and no user-id claim, so it is never accepted as an access token.
+func (h *Handler) feedToken(userID string, tokenVersion int) (string, error) {
+	key := h.keys.Current()
+	token := jwt.NewWithClaims(key.Method(), jwt.MapClaims{
+		"calendar-user": userID,
+		"token-version": tokenVersion,
+	})
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.SigningKey())
+}
+
+// tokenVersion returns a user's current token version
+func (h *Handler) tokenVersion(ctx context.Context, userID string) (int, error) {
+	var version int
+	err := h.db.QueryRowContext(ctx, "SELECT token_version FROM users WHERE id = ? AND deleted_at IS NULL", userID).Scan(&version)
+	return version, err
+}
+
+// GetFeed returns the URL of the user's calendar feed
+func (h *Handler) GetFeed(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := h.tokenVersion(c.UserContext(), userID)
+	if err != nil {
+		return fmt.Errorf("fetching token version: %w", err)
+	}
+	token, err := h.feedToken(userID, version)
+	if err != nil {
+		return fmt.Errorf("signing feed token: %w", err)
+	}
+
+	return c.JSON(Feed{URL: c.BaseURL() + "/me/calendar.ics?token=" + token})
+}
+
+// authenticate returns the user a feed token was issued to
+func (h *Handler) authenticate(ctx context.Context, tokenString string) (string, error) {
+	token, err := h.keys.ParseJWT(tokenString)
+	if err != nil || !token.Valid {
+		return "", errInvalidToken
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", errInvalidToken
+	}
+	userID, _ := claims["calendar-user"].(string)
+	if userID == "" {
+		return "", errInvalidToken
+	}
+
+	version, err := h.tokenVersion(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errInvalidToken
+		}
+		return "", fmt.Errorf("fetching token version: %w", err)
+	}
+	if claimed, _ := claims["token-version"].(float64); int(claimed) != version {
+		return "", errInvalidToken
+	}
+	return userID, nil
+}
+
+// GetCalendar serves the feed of the user named by the token query
+// parameter: an event at each of their reminders, repeating with it, and
+// an all-day event on the due date of each open task in the notes they
+// can access. Calendar apps can't send an Authorization header, so the
+// signed token is the only credential.
+func (h *Handler) GetCalendar(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	userID, err := h.authenticate(ctx, c.Query("token"))
+	if err != nil {
+		return err
+	}
+
+	w := &writer{}
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//Quanta//Notes//EN")
+	w.line("CALSCALE", "GREGORIAN")
+	w.line("METHOD", "PUBLISH")
+	w.line("X-WR-CALNAME", "Quanta")
+	stamp := dateTime(time.Now())
+	if err := h.writeReminders(ctx, w, userID, stamp); err != nil {
+		return err
+	}
+	if err := h.writeTasks(ctx, w, userID, stamp); err != nil {
+		return err
+	}
+	w.line("END", "VCALENDAR")
+
+	c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	return c.SendString(w.String())
+}
+
+// writeReminders writes an event for each of the user's reminders
+func (h *Handler) writeReminders(ctx context.Context, w *writer, userID, stamp string) error {
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT r.id, r.due_at, r.recurrence, n.title FROM reminders r JOIN notes n ON n.id = r.note_id WHERE r.user_id = ? ORDER BY r.due_at LIMIT ?",
+		userID, MaxFeedEvents,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching reminders: %w", err)
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var id, recurrence, title string
+		var due time.Time
+		if err := rows.Scan(&id, &due, &recurrence, &title); err != nil {
+			return fmt.Errorf("scanning reminder: %w", err)
+		}
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", "reminder-"+id+"@quanta")
+		w.line("DTSTAMP", stamp)
+		w.line("DTSTART", dateTime(due))
+		if recurrence != "" {
+			w.line("RRULE", "FREQ="+strings.ToUpper(recurrence))
+		}
+		w.line("SUMMARY", text("Reminder: "+title))
+		w.line("END", "VEVENT")
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating reminders: %w", err)
+	}
+	return nil
+}
+
+// writeTasks writes an all-day event for each open task with a due date
+func (h *Handler) writeTasks(ctx context.Context, w *writer, userID, stamp string) error {
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT t.id, t.text, t.due_date, n.title FROM tasks t JOIN notes n ON n.id = t.note_id WHERE t.done = ? AND t.due_date IS NOT NULL AND "+
+			"((n.workspace_id IS NULL AND n.user_id = ?) OR n.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)) ORDER BY t.due_date LIMIT ?",
+		false, userID, userID, MaxFeedEvents,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching tasks: %w", err)
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var id, task, due, title string
+		if err := rows.Scan(&id, &task, &due, &title); err != nil {
+			return fmt.Errorf("scanning task: %w", err)
+		}
+		day, err := time.Parse(checklist.DateLayout, due)
+		if err != nil {
+			continue
+		}
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", "task-"+id+"@quanta")
+		w.line("DTSTAMP", stamp)
+		w.line("DTSTART;VALUE=DATE", day.Format("20060102"))
+		w.line("SUMMARY", text(task))
+		w.line("DESCRIPTION", text("Task in "+title))
+		w.line("END", "VEVENT")
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating tasks: %w", err)
+	}
+	return nil
+}
+
+func closeRows(rows *sql.Rows) {
+	if err := rows.Close(); err != nil {
+		log.Println("Error closing rows:", err)
+	}
+}
@@ -0,0 +1,158 @@
+package calendar
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/pkg"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	versionQuery   = regexp.QuoteMeta("SELECT token_version FROM users WHERE id = ? AND deleted_at IS NULL")
+	remindersQuery = regexp.QuoteMeta("SELECT r.id, r.due_at, r.recurrence, n.title FROM reminders r JOIN notes n ON n.id = r.note_id WHERE r.user_id = ? ORDER BY r.due_at LIMIT ?")
+	tasksQuery     = regexp.QuoteMeta("SELECT t.id, t.text, t.due_date, n.title FROM tasks t JOIN notes n ON n.id = t.note_id WHERE t.done = ? AND t.due_date IS NOT NULL AND ")
+)
+
+type testHelper struct {
+	mockDB  sqlmock.Sqlmock
+	app     *fiber.App
+	handler *Handler
+}
+
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	handler := NewHandler(db, pkg.SingleJWTKey("secret"))
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Get("/me/calendar.ics", handler.GetCalendar)
+	app.Get("/me/calendar", func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	}, handler.GetFeed)
+
+	return &testHelper{mockDB: mockDB, app: app, handler: handler}
+}
+
+// feedToken fetches the user's feed URL and returns its token
+func (h *testHelper) feedToken(t *testing.T, version int) string {
+	h.mockDB.ExpectQuery(versionQuery).WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(version))
+
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/me/calendar", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var feed Feed
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&feed))
+
+	u, err := url.Parse(feed.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "/me/calendar.ics", u.Path)
+	return u.Query().Get("token")
+}
+
+func TestGetCalendar(t *testing.T) {
+	h := newTestHelper(t)
+	token := h.feedToken(t, 2)
+
+	h.mockDB.ExpectQuery(versionQuery).WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(2))
+	h.mockDB.ExpectQuery(remindersQuery).WithArgs("user123", MaxFeedEvents).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "due_at", "recurrence", "title"}).
+			AddRow("r1", time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC), "weekly", "Standup, notes"))
+	h.mockDB.ExpectQuery(tasksQuery).WithArgs(false, "user123", "user123", MaxFeedEvents).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "text", "due_date", "title"}).
+			AddRow("t1", "Send invoice due:2026-03-02", "2026-03-02", "Admin"))
+
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/me/calendar.ics?token="+token, nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/calendar; charset=utf-8", resp.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	feed := string(body)
+	assert.True(t, strings.HasPrefix(feed, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.True(t, strings.HasSuffix(feed, "END:VCALENDAR\r\n"))
+	assert.Contains(t, feed, "UID:reminder-r1@quanta\r\n")
+	assert.Contains(t, feed, "DTSTART:20260301T093000Z\r\nRRULE:FREQ=WEEKLY\r\nSUMMARY:Reminder: Standup\\, notes\r\n")
+	assert.Contains(t, feed, "UID:task-t1@quanta\r\n")
+	assert.Contains(t, feed, "DTSTART;VALUE=DATE:20260302\r\nSUMMARY:Send invoice due:2026-03-02\r\nDESCRIPTION:Task in Admin\r\n")
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestGetCalendar_RejectsBadTokens(t *testing.T) {
+	h := newTestHelper(t)
+	token := h.feedToken(t, 2)
+
+	// An access token is not a feed token
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user-id": "user123", "token-version": 2}).
+		SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		token string
+		setup func()
+	}{
+		{name: "Missing"},
+		{name: "Forged", token: token[:len(token)-2] + "xx"},
+		{name: "Access Token", token: access},
+		{
+			name:  "Revoked",
+			token: token,
+			setup: func() {
+				h.mockDB.ExpectQuery(versionQuery).WithArgs("user123").
+					WillReturnRows(sqlmock.NewRows([]string{"token_version"}).AddRow(3))
+			},
+		},
+		{
+			name:  "Deleted User",
+			token: token,
+			setup: func() {
+				h.mockDB.ExpectQuery(versionQuery).WithArgs("user123").
+					WillReturnRows(sqlmock.NewRows([]string{"token_version"}))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.setup != nil {
+				tc.setup()
+			}
+			resp, err := h.app.Test(httptest.NewRequest("GET", "/me/calendar.ics?token="+tc.token, nil))
+			require.NoError(t, err)
+			assert.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+			assert.NoError(t, h.mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestWriterFoldsLongLines(t *testing.T) {
+	w := &writer{}
+	w.line("SUMMARY", strings.Repeat("é", 60))
+
+	lines := strings.Split(strings.TrimSuffix(w.String(), "\r\n"), "\r\n")
+	assert.Len(t, lines, 2)
+	for _, l := range lines {
+		assert.LessOrEqual(t, len(l), maxLineOctets)
+	}
+	assert.True(t, strings.HasPrefix(lines[1], " "))
+	assert.Equal(t, "SUMMARY:"+strings.Repeat("é", 60), lines[0]+lines[1][1:])
+}
@@ -0,0 +1,47 @@
+package calendar
+
+import (
+	"strings"
+	"time"
+)
+
+// maxLineOctets is the longest content line RFC 5545 allows before it must
+// be folded, excluding the CRLF
+const maxLineOctets = 75
+
+// writer builds an iCalendar document, folding and terminating its lines
+type writer struct {
+	b strings.Builder
+}
+
+// line writes a content line, folding it onto continuation lines that
+// start with a space. Lines are only split between UTF-8 sequences.
+func (w *writer) line(name, value string) {
+	s := name + ":" + value
+	// A continuation line's leading space counts towards its length
+	for limit := maxLineOctets; len(s) > limit; limit = maxLineOctets - 1 {
+		cut := limit
+		for s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.b.WriteString(s[:cut])
+		w.b.WriteString("\r\n ")
+		s = s[cut:]
+	}
+	w.b.WriteString(s)
+	w.b.WriteString("\r\n")
+}
+
+func (w *writer) String() string {
+	return w.b.String()
+}
+
+// text escapes a TEXT value
+func text(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// dateTime formats t as a UTC DATE-TIME value
+func dateTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
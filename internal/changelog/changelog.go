@@ -0,0 +1,159 @@
+// Package changelog keeps an append-only log of every note created,
+// updated or deleted, with the version it was left at, and serves it to
+// clients as a feed of changes since their last sync
+package changelog
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Op is what happened to a note
+type Op string
+
+// Changes that are logged
+const (
+	Created Op = "created"
+	Updated Op = "updated"
+	Deleted Op = "deleted"
+)
+
+const (
+	// DefaultLimit is how many changes a page returns by default
+	DefaultLimit = 500
+	// MaxLimit is the most changes a page returns
+	MaxLimit = 1000
+)
+
+// Execer runs a statement, on the database or inside a transaction
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Change is one entry of the changelog. Version is the note's version
+// after it was created or updated, or when it was deleted.
+type Change struct {
+	Cursor    string    `json:"cursor"`
+	NoteID    string    `json:"note_id"`
+	Op        Op        `json:"op"`
+	Version   int64     `json:"version"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Page is a page of changes, oldest first. Cursor is the point to ask for
+// the next page from, or since as given when there were no changes; more
+// are waiting when HasMore is true.
+type Page struct {
+	Changes []Change `json:"changes"`
+	Cursor  string   `json:"cursor"`
+	HasMore bool     `json:"has_more"`
+}
+
+// Record logs the notes matching where, a condition on the notes table, as
+// changed by op at their current version. Creates and updates are logged
+// after the write and deletes before it, with the same condition and in
+// the same transaction so a delete that fails isn't logged.
+func Record(ctx context.Context, ex Execer, op Op, where string, args ...any) error {
+	_, err := ex.ExecContext(ctx,
+		"INSERT INTO note_changes (note_id, user_id, workspace_id, op, version) SELECT id, user_id, workspace_id, ?, version FROM notes WHERE "+where,
+		append([]any{op}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("logging note change: %w", err)
+	}
+	return nil
+}
+
+// Handler handles HTTP requests for the changelog
+type Handler struct {
+	db DBInterface
+}
+
+// NewHandler creates a new Handler with the provided database interface
+func NewHandler(db DBInterface) *Handler {
+	return &Handler{db: db}
+}
+
+// GetChanges lists changes to the user's private notes and the notes of
+// their workspaces after ?since, which is the cursor of an earlier page or
+// an RFC 3339 time, or from the start of the log without it. A note can
+// appear more than once; clients keep the last change of each.
+func (h *Handler) GetChanges(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	since := c.Query("since")
+	after := "id > ?"
+	var from any = int64(0)
+	if since != "" {
+		if id, err := strconv.ParseInt(since, 10, 64); err == nil && id >= 0 {
+			from = id
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			after, from = "changed_at > ?", t.UTC()
+		} else {
+			return apperr.New(fiber.StatusBadRequest, "since must be a cursor or an RFC 3339 timestamp")
+		}
+	}
+
+	limit := DefaultLimit
+	if raw := c.Query("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return apperr.New(fiber.StatusBadRequest, "Invalid limit")
+		}
+		limit = min(limit, MaxLimit)
+	}
+
+	// One extra row tells whether there is another page
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT id, note_id, op, version, changed_at FROM note_changes WHERE "+after+" AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)) ORDER BY id LIMIT ?",
+		from, userID, userID, limit+1,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching note changes: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	page := Page{Changes: []Change{}, Cursor: since}
+	for rows.Next() {
+		var ch Change
+		var id int64
+		if err := rows.Scan(&id, &ch.NoteID, &ch.Op, &ch.Version, &ch.ChangedAt); err != nil {
+			return fmt.Errorf("scanning note change: %w", err)
+		}
+		if len(page.Changes) == limit {
+			page.HasMore = true
+			break
+		}
+		ch.Cursor = strconv.FormatInt(id, 10)
+		page.Changes = append(page.Changes, ch)
+		page.Cursor = ch.Cursor
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching note changes: %w", err)
+	}
+	if page.Cursor == "" {
+		page.Cursor = "0"
+	}
+	return c.JSON(page)
+}
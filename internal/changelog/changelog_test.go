@@ -0,0 +1,121 @@
+package changelog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+
+	mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO note_changes (note_id, user_id, workspace_id, op, version) SELECT id, user_id, workspace_id, ?, version FROM notes WHERE id = ?")).
+		WithArgs("updated", "note1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, Record(context.Background(), db, Updated, "id = ?", "note1"))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestGetChanges(t *testing.T) {
+	changedAt := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	columns := []string{"id", "note_id", "op", "version", "changed_at"}
+	visible := " AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)) ORDER BY id LIMIT ?"
+	byID := regexp.QuoteMeta("SELECT id, note_id, op, version, changed_at FROM note_changes WHERE id > ?" + visible)
+	byTime := regexp.QuoteMeta("SELECT id, note_id, op, version, changed_at FROM note_changes WHERE changed_at > ?" + visible)
+
+	testCases := []struct {
+		name           string
+		query          string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedPage   *Page
+	}{
+		{
+			name:  "From Cursor",
+			query: "?since=41&limit=2",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(byID).WithArgs(int64(41), "user123", "user123", 3).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(42, "note1", "created", 1, changedAt).
+						AddRow(43, "note1", "updated", 2, changedAt).
+						AddRow(44, "note2", "deleted", 7, changedAt))
+			},
+			expectedStatus: fiber.StatusOK,
+			expectedPage: &Page{
+				Changes: []Change{
+					{Cursor: "42", NoteID: "note1", Op: Created, Version: 1, ChangedAt: changedAt},
+					{Cursor: "43", NoteID: "note1", Op: Updated, Version: 2, ChangedAt: changedAt},
+				},
+				Cursor:  "43",
+				HasMore: true,
+			},
+		},
+		{
+			name:  "From Time",
+			query: "?since=2026-03-01T00:00:00Z",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(byTime).WithArgs(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), "user123", "user123", DefaultLimit+1).
+					WillReturnRows(sqlmock.NewRows(columns))
+			},
+			expectedStatus: fiber.StatusOK,
+			expectedPage:   &Page{Changes: []Change{}, Cursor: "2026-03-01T00:00:00Z"},
+		},
+		{
+			name:  "From Start",
+			query: "",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(byID).WithArgs(int64(0), "user123", "user123", DefaultLimit+1).
+					WillReturnRows(sqlmock.NewRows(columns))
+			},
+			expectedStatus: fiber.StatusOK,
+			expectedPage:   &Page{Changes: []Change{}, Cursor: "0"},
+		},
+		{
+			name:           "Invalid Since",
+			query:          "?since=yesterday",
+			setupMock:      func(sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Limit",
+			query:          "?limit=0",
+			setupMock:      func(sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mockDB, err := sqlmock.New()
+			require.NoError(t, err)
+			app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+			app.Use(func(c *fiber.Ctx) error {
+				c.Locals("user-id", "user123")
+				return c.Next()
+			})
+			app.Get("/notes/changes", NewHandler(db).GetChanges)
+			tc.setupMock(mockDB)
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/notes/changes"+tc.query, nil))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedPage != nil {
+				var page Page
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+				assert.Equal(t, *tc.expectedPage, page)
+			}
+			assert.NoError(t, mockDB.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Package checklist finds the task items in a note's content, the
+// Markdown "- [ ]" and "- [x]" list items, and checks them off
+package checklist
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DateLayout is how due dates are written, in task text and in Item.Due
+const DateLayout = "2006-01-02"
+
+// Item is a task item. Line counts from 1.
+type Item struct {
+	Line int
+	Text string
+	Done bool
+	// Due is the date from a due:YYYY-MM-DD tag in the text, or empty
+	Due string
+}
+
+// item matches a list item of any bullet or number that starts with a
+// checkbox. The groups are everything up to the box's mark, the mark and
+// the text.
+var item = regexp.MustCompile(`^(\s*(?:[-*+]|\d{1,9}[.)])\s+\[)([ xX])\]\s+(.*?)\s*$`)
+
+// due matches a due date tag
+var due = regexp.MustCompile(`(?:^|\s)due:(\d{4}-\d{2}-\d{2})\b`)
+
+// Parse returns the task items of content in order. Lines may end in
+// "\n" or "\r\n".
+func Parse(content string) []Item {
+	var items []Item
+	for i, line := range strings.Split(content, "\n") {
+		if it, ok := parseLine(line); ok {
+			it.Line = i + 1
+			items = append(items, it)
+		}
+	}
+	return items
+}
+
+// Has reports whether content has any task items
+func Has(content string) bool {
+	return len(Parse(content)) > 0
+}
+
+func parseLine(line string) (Item, bool) {
+	m := item.FindStringSubmatch(line)
+	if m == nil || m[3] == "" {
+		return Item{}, false
+	}
+	it := Item{Text: m[3], Done: m[2] != " "}
+	if d := due.FindStringSubmatch(it.Text); d != nil {
+		if _, err := time.Parse(DateLayout, d[1]); err == nil {
+			it.Due = d[1]
+		}
+	}
+	return it, true
+}
+
+// Toggle checks or unchecks the task item on a line of content, returning
+// the new content. It reports false if the line holds no task item with
+// that text.
+func Toggle(content string, line int, text string, done bool) (string, bool) {
+	lines := strings.Split(content, "\n")
+	if line < 1 || line > len(lines) {
+		return content, false
+	}
+	it, ok := parseLine(lines[line-1])
+	if !ok || it.Text != text {
+		return content, false
+	}
+
+	mark := " "
+	if done {
+		mark = "x"
+	}
+	m := item.FindStringSubmatchIndex(lines[line-1])
+	lines[line-1] = lines[line-1][:m[4]] + mark + lines[line-1][m[5]:]
+	return strings.Join(lines, "\n"), true
+}
@@ -0,0 +1,43 @@
+package checklist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	content := "# Launch\r\n" +
+		"- [ ] Write the announcement due:2026-03-02\r\n" +
+		"  * [X] Book the venue\n" +
+		"1. [ ] Order cake due:2026-02-30\n" +
+		"- [ ]\n" +
+		"- [] not a task\n" +
+		"[ ] nor this"
+
+	assert.Equal(t, []Item{
+		{Line: 2, Text: "Write the announcement due:2026-03-02", Due: "2026-03-02"},
+		{Line: 3, Text: "Book the venue", Done: true},
+		{Line: 4, Text: "Order cake due:2026-02-30"},
+	}, Parse(content))
+	assert.False(t, Has("just text\n- a list item"))
+}
+
+func TestToggle(t *testing.T) {
+	content := "Todo\n- [ ] Eggs\n  - [x] Milk\n"
+
+	got, ok := Toggle(content, 2, "Eggs", true)
+	assert.True(t, ok)
+	assert.Equal(t, "Todo\n- [x] Eggs\n  - [x] Milk\n", got)
+
+	got, ok = Toggle(content, 3, "Milk", false)
+	assert.True(t, ok)
+	assert.Equal(t, "Todo\n- [ ] Eggs\n  - [ ] Milk\n", got)
+
+	_, ok = Toggle(content, 2, "Bread", true)
+	assert.False(t, ok, "the line holds another task")
+	_, ok = Toggle(content, 1, "Todo", true)
+	assert.False(t, ok, "the line holds no task")
+	_, ok = Toggle(content, 9, "Eggs", true)
+	assert.False(t, ok, "there is no such line")
+}
@@ -0,0 +1,703 @@
+// Package config loads and validates the application settings from the
+// environment once at startup
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"quanta/internal/backup"
+	"quanta/internal/middleware"
+	"quanta/internal/models"
+	"quanta/internal/oidc"
+	"quanta/internal/processors"
+	"quanta/internal/quota"
+	"quanta/internal/realtime"
+	"quanta/internal/sanitize"
+	"quanta/pkg"
+)
+
+// Config holds every setting the server reads from the environment
+type Config struct {
+	Port string
+	// GRPCPort serves the gRPC API alongside the HTTP one on Port
+	GRPCPort string
+
+	// DBDriver is mysql, postgres, sqlite for an embedded database file, or
+	// memory for a throwaway in-memory SQLite database
+	DBDriver string
+	// DatabaseURL is the DSN, or the database file path for sqlite
+	DatabaseURL string
+
+	// JWTKeys sign and verify tokens. HMAC keys come from JWT_KEYS, a list
+	// of <key id>:<secret> pairs, or JWT_SECRET alone as the key "default";
+	// RSA and Ed25519 keys from JWT_PRIVATE_KEYS, a list of <key id>:<PEM
+	// file> pairs. Tokens are signed with JWT_SIGNING_KEY_ID, by default
+	// the first key listed.
+	JWTKeys *pkg.JWTKeys
+
+	// PasswordHashVersion identifies the Argon2 parameters below and is
+	// stored with each password hash. Raise it when changing them so
+	// existing hashes are upgraded as their users log in.
+	PasswordHashVersion int
+	Argon2Time          int
+	Argon2MemoryKiB     int
+	Argon2Threads       int
+
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+
+	// QueryTimeout bounds how long a request's database work may take
+	QueryTimeout time.Duration
+
+	WSPingInterval   time.Duration
+	WSMaxMissedPongs int
+	// WSHistorySize is how many recent edits each room keeps for replay
+	WSHistorySize int
+	// WSMaxUserConnections caps the note connections one user may have
+	// open, WSMaxRoomConnections those one note may have
+	WSMaxUserConnections int
+	WSMaxRoomConnections int
+	// WSResumeGrace is how long a dropped note connection can be resumed
+	WSResumeGrace time.Duration
+	// WSCoalesceInterval is how long cursor and typing updates are held to
+	// be sent to a room together
+	WSCoalesceInterval time.Duration
+	// WSRoomIdleTimeout is how long a room may go without messages before
+	// its document is saved and its buffers dropped
+	WSRoomIdleTimeout time.Duration
+	// WSDeletedGrace is how long a deleted note's room stays open,
+	// read-only, before it is closed
+	WSDeletedGrace time.Duration
+	// WSEventLogSampleRatio is the fraction of note connections whose
+	// joins, leaves and edits are logged, limited to one note or user by
+	// WSEventLogNote and WSEventLogUser. Failures are always logged.
+	WSEventLogSampleRatio float64
+	WSEventLogNote        string
+	WSEventLogUser        string
+
+	// NoteMaxTitleLength is in characters, NoteMaxContentBytes in bytes
+	NoteMaxTitleLength  int
+	NoteMaxContentBytes int
+	// NoteHTMLPolicy is how HTML in note content is sanitized when a note
+	// is rendered: off, basic or strict. Off prints it escaped.
+	NoteHTMLPolicy sanitize.Policy
+
+	// ContentProcessors lists the services edited notes are run through
+	// for suggestions: languagetool checks spelling and grammar with the
+	// server at LanguageToolURL. Empty turns processing off.
+	ContentProcessors []string
+	LanguageToolURL   string
+	// ContentProcessorDelay is how long a note must go unedited before it
+	// is processed
+	ContentProcessorDelay time.Duration
+
+	// QuotaUserBytes caps the storage of each user's private notes and
+	// QuotaWorkspaceBytes that of each workspace, attachments included
+	QuotaUserBytes      int
+	QuotaWorkspaceBytes int
+
+	// RateLimit is how many requests each authenticated user may make per
+	// RateLimitWindow
+	RateLimit       int
+	RateLimitWindow time.Duration
+
+	StorageDriver   string
+	StorageLocalDir string
+	S3Bucket        string
+	S3Endpoint      string
+
+	// AttachmentScanner names the malware scanner uploads go through:
+	// clamav streams them to the clamd at ClamAVAddr, a host and port or
+	// the path of a Unix socket. Empty leaves uploads unscanned.
+	AttachmentScanner string
+	ClamAVAddr        string
+
+	// PDFRenderer names what renders notes exported as PDF: gotenberg uses
+	// the Gotenberg server at GotenbergURL. Empty turns PDF export off.
+	PDFRenderer  string
+	GotenbergURL string
+
+	// BackupInterval is how often the database is backed up to storage and
+	// BackupKeep how many successful backups are kept. BackupKey, from
+	// BACKUP_ENCRYPTION_KEY as 32 base64 encoded bytes, encrypts them when
+	// set.
+	BackupInterval time.Duration
+	BackupKeep     int
+	BackupKey      []byte
+
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// AppURL is where the web app is served, used to build links in emails
+	AppURL string
+	// InviteTTL is how long workspace invitation links stay valid
+	InviteTTL time.Duration
+
+	// OIDCIssuer turns on single sign-on through the OpenID Connect
+	// provider with that issuer URL, such as an Okta, Azure AD or Keycloak
+	// tenant. OIDCRedirectURL, this server's /auth/oidc/callback, must be
+	// registered with the provider for OIDCClientID.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	// OIDCEmailClaim, OIDCNameClaim and OIDCGroupsClaim name the ID token
+	// claims read for a user's email, display name and groups
+	OIDCEmailClaim  string
+	OIDCNameClaim   string
+	OIDCGroupsClaim string
+	// OIDCGroupWorkspaces, from OIDC_GROUP_WORKSPACES as a list of
+	// <group>=<workspace id>[:<role>], makes members of each group join
+	// the workspace when they sign in, as members unless the role is admin
+	OIDCGroupWorkspaces []oidc.GroupMapping
+	// OIDCAssumeEmailVerified takes the email of an ID token that has no
+	// email_verified claim as verified, for providers such as Azure AD that
+	// leave it out. Off by default: a provider letting users pick any
+	// address could otherwise sign them in to someone else's account.
+	OIDCAssumeEmailVerified bool
+	// OIDCJITProvisioning creates the account of whoever signs in without
+	// one, and OIDCEnforced turns password login and signup off
+	OIDCJITProvisioning bool
+	OIDCEnforced        bool
+
+	// CORSAllowedOrigins lists the browser origins allowed to call the API.
+	// Empty disables cross-origin access.
+	CORSAllowedOrigins   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+	// WSAllowedOrigins lists the browser origins allowed to open WebSocket
+	// connections besides the server's own. Defaults to CORSAllowedOrigins.
+	WSAllowedOrigins []string
+
+	// HSTSMaxAge enables Strict-Transport-Security when non-zero
+	HSTSMaxAge            time.Duration
+	ContentSecurityPolicy string
+
+	// AdminAllowedIPs restricts the /admin routes to these ranges. Empty
+	// allows any address.
+	AdminAllowedIPs []netip.Prefix
+	// IPDenylist refuses every request from these ranges, along with those
+	// in the ip_denylist table, which is reloaded every IPDenylistRefresh
+	IPDenylist        []netip.Prefix
+	IPDenylistRefresh time.Duration
+	// TrustedProxies lists the reverse proxies whose ProxyHeader is
+	// believed for the client address that IP filtering, rate limiting and
+	// audit logs use. Requests from anywhere else are taken at their
+	// connection's address, so with none configured the header is ignored.
+	// The proxies must overwrite the header rather than append to it, since
+	// its first address is the one used.
+	TrustedProxies []netip.Prefix
+	ProxyHeader    string
+
+	// HealthStrict fails the readiness probe when any check fails, not
+	// only the database ping
+	HealthStrict       bool
+	HealthCheckTimeout time.Duration
+
+	// TracingEndpoint is the OTLP/gRPC collector spans are exported to,
+	// such as http://localhost:4317. Empty disables exporting.
+	TracingEndpoint    string
+	TracingServiceName string
+	// TracingSampleRatio is the fraction of new traces recorded, from 0 to 1
+	TracingSampleRatio float64
+
+	// DebugHTTPTrace logs requests and responses with credentials redacted,
+	// either DebugHTTPTraceSampleRatio of them or, when DebugHTTPTraceUser
+	// is set, that share of the requests of that user alone
+	DebugHTTPTrace            bool
+	DebugHTTPTraceSampleRatio float64
+	DebugHTTPTraceUser        string
+}
+
+// Error reports every invalid or missing setting found by Load
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load reads the configuration from the environment, applying defaults and
+// checking required settings. All problems are reported together so a
+// misconfigured deployment can be fixed in one pass.
+func Load() (*Config, error) {
+	l := &loader{}
+
+	cfg := &Config{
+		Port:     l.string("PORT", "3000"),
+		GRPCPort: l.string("GRPC_PORT", "9090"),
+
+		DBDriver:    l.string("DB_DRIVER", "mysql"),
+		DatabaseURL: l.string("DATABASE_URL", ""),
+		JWTKeys:     l.jwtKeys(),
+
+		PasswordHashVersion: l.int("PASSWORD_HASH_VERSION", pkg.DefaultArgon2Params.Version),
+		Argon2Time:          l.int("ARGON2_TIME", int(pkg.DefaultArgon2Params.Time)),
+		Argon2MemoryKiB:     l.int("ARGON2_MEMORY_KIB", int(pkg.DefaultArgon2Params.MemoryKiB)),
+		Argon2Threads:       l.int("ARGON2_THREADS", int(pkg.DefaultArgon2Params.Threads)),
+
+		DBMaxOpenConns:    l.int("DB_MAX_OPEN_CONNS", 25),
+		DBMaxIdleConns:    l.int("DB_MAX_IDLE_CONNS", 25),
+		DBConnMaxLifetime: l.duration("DB_CONN_MAX_LIFETIME", 5*time.Minute),
+
+		QueryTimeout: l.duration("QUERY_TIMEOUT", 5*time.Second),
+
+		WSPingInterval:   l.duration("WS_PING_INTERVAL", 30*time.Second),
+		WSMaxMissedPongs: l.int("WS_MAX_MISSED_PONGS", 2),
+		WSHistorySize:    l.int("WS_HISTORY_SIZE", 100),
+
+		WSMaxUserConnections: l.int("WS_MAX_CONNECTIONS_PER_USER", realtime.DefaultMaxUserConnections),
+		WSMaxRoomConnections: l.int("WS_MAX_CONNECTIONS_PER_ROOM", realtime.DefaultMaxRoomConnections),
+		WSResumeGrace:        l.duration("WS_RESUME_GRACE", realtime.DefaultResumeGrace),
+		WSCoalesceInterval:   l.duration("WS_COALESCE_INTERVAL", realtime.DefaultCoalesceInterval),
+		WSRoomIdleTimeout:    l.duration("WS_ROOM_IDLE_TIMEOUT", realtime.DefaultRoomIdleTimeout),
+		WSDeletedGrace:       l.duration("WS_DELETED_GRACE", realtime.DefaultDeletedGrace),
+
+		WSEventLogSampleRatio: l.ratio("WS_EVENT_LOG_SAMPLE_RATIO", 1),
+		WSEventLogNote:        l.string("WS_EVENT_LOG_NOTE", ""),
+		WSEventLogUser:        l.string("WS_EVENT_LOG_USER", ""),
+
+		NoteMaxTitleLength:  l.int("NOTE_MAX_TITLE_LENGTH", models.DefaultNoteLimits.MaxTitleLength),
+		NoteMaxContentBytes: l.int("NOTE_MAX_CONTENT_BYTES", models.DefaultNoteLimits.MaxContentBytes),
+		NoteHTMLPolicy:      sanitize.Policy(l.string("NOTE_HTML_POLICY", string(sanitize.Basic))),
+
+		ContentProcessors:     l.list("CONTENT_PROCESSORS"),
+		LanguageToolURL:       l.string("LANGUAGETOOL_URL", ""),
+		ContentProcessorDelay: l.duration("CONTENT_PROCESSOR_DELAY", processors.DefaultDelay),
+
+		QuotaUserBytes:      l.int("QUOTA_USER_BYTES", quota.DefaultUserBytes),
+		QuotaWorkspaceBytes: l.int("QUOTA_WORKSPACE_BYTES", quota.DefaultWorkspaceBytes),
+
+		RateLimit:       l.int("RATE_LIMIT", middleware.DefaultRateLimit),
+		RateLimitWindow: l.duration("RATE_LIMIT_WINDOW", middleware.DefaultRateLimitWindow),
+
+		StorageDriver:   l.string("STORAGE_DRIVER", "local"),
+		StorageLocalDir: l.string("STORAGE_LOCAL_DIR", "./data/uploads"),
+		S3Bucket:        l.string("S3_BUCKET", ""),
+		S3Endpoint:      l.string("S3_ENDPOINT", ""),
+
+		AttachmentScanner: l.string("ATTACHMENT_SCANNER", ""),
+		ClamAVAddr:        l.string("CLAMAV_ADDR", "localhost:3310"),
+
+		PDFRenderer:  l.string("PDF_RENDERER", ""),
+		GotenbergURL: l.string("GOTENBERG_URL", ""),
+
+		BackupInterval: l.duration("BACKUP_INTERVAL", backup.DefaultInterval),
+		BackupKeep:     l.int("BACKUP_KEEP", backup.DefaultKeep),
+		BackupKey:      l.backupKey(),
+
+		SMTPAddr:     l.string("SMTP_ADDR", ""),
+		SMTPFrom:     l.string("SMTP_FROM", ""),
+		SMTPUsername: l.string("SMTP_USERNAME", ""),
+		SMTPPassword: l.string("SMTP_PASSWORD", ""),
+
+		AppURL:    l.string("APP_URL", "http://localhost:5173"),
+		InviteTTL: l.duration("INVITE_TTL", 7*24*time.Hour),
+
+		OIDCIssuer:              l.string("OIDC_ISSUER", ""),
+		OIDCClientID:            l.string("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:        l.string("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:         l.string("OIDC_REDIRECT_URL", ""),
+		OIDCScopes:              l.list("OIDC_SCOPES"),
+		OIDCEmailClaim:          l.string("OIDC_EMAIL_CLAIM", "email"),
+		OIDCNameClaim:           l.string("OIDC_NAME_CLAIM", "name"),
+		OIDCGroupsClaim:         l.string("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCGroupWorkspaces:     l.groupMappings("OIDC_GROUP_WORKSPACES"),
+		OIDCAssumeEmailVerified: l.bool("OIDC_ASSUME_EMAIL_VERIFIED", false),
+		OIDCJITProvisioning:     l.bool("OIDC_JIT_PROVISIONING", true),
+		OIDCEnforced:            l.bool("OIDC_ENFORCED", false),
+
+		CORSAllowedOrigins:   l.list("CORS_ALLOWED_ORIGINS"),
+		CORSAllowCredentials: l.bool("CORS_ALLOW_CREDENTIALS", false),
+		CORSMaxAge:           l.duration("CORS_MAX_AGE", 10*time.Minute),
+		WSAllowedOrigins:     l.list("WS_ALLOWED_ORIGINS"),
+
+		HSTSMaxAge:            l.optionalDuration("HSTS_MAX_AGE"),
+		ContentSecurityPolicy: l.string("CONTENT_SECURITY_POLICY", ""),
+
+		AdminAllowedIPs:   l.prefixes("ADMIN_ALLOWED_IPS"),
+		IPDenylist:        l.prefixes("IP_DENYLIST"),
+		IPDenylistRefresh: l.duration("IP_DENYLIST_REFRESH", middleware.DefaultDenylistRefresh),
+		TrustedProxies:    l.prefixes("TRUSTED_PROXIES"),
+		ProxyHeader:       l.string("PROXY_HEADER", "X-Forwarded-For"),
+
+		HealthStrict:       l.bool("HEALTH_STRICT", false),
+		HealthCheckTimeout: l.duration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+
+		TracingEndpoint:    l.string("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		TracingServiceName: l.string("OTEL_SERVICE_NAME", "quanta"),
+		TracingSampleRatio: l.ratio("TRACING_SAMPLE_RATIO", 1),
+
+		DebugHTTPTrace:            l.bool("DEBUG_HTTP_TRACE", false),
+		DebugHTTPTraceSampleRatio: l.ratio("DEBUG_HTTP_TRACE_SAMPLE_RATIO", 1),
+		DebugHTTPTraceUser:        l.string("DEBUG_HTTP_TRACE_USER", ""),
+	}
+
+	switch cfg.DBDriver {
+	case "mysql", "postgres":
+		if cfg.DatabaseURL == "" {
+			l.problem("DATABASE_URL is required")
+		}
+	case "sqlite":
+		if cfg.DatabaseURL == "" {
+			cfg.DatabaseURL = "./data/quanta.db"
+		}
+	case "memory":
+	default:
+		l.problem("DB_DRIVER must be mysql, postgres, sqlite or memory, got %q", cfg.DBDriver)
+	}
+	if cfg.DBMaxIdleConns > cfg.DBMaxOpenConns {
+		l.problem("DB_MAX_IDLE_CONNS (%d) cannot exceed DB_MAX_OPEN_CONNS (%d)", cfg.DBMaxIdleConns, cfg.DBMaxOpenConns)
+	}
+	if cfg.PasswordHashVersion <= pkg.PasswordVersionBcrypt {
+		l.problem("PASSWORD_HASH_VERSION must be greater than %d, which marks bcrypt hashes", pkg.PasswordVersionBcrypt)
+	}
+	if cfg.Argon2Threads > 255 {
+		l.problem("ARGON2_THREADS cannot exceed 255")
+	}
+	if cfg.Argon2MemoryKiB < 8*cfg.Argon2Threads {
+		l.problem("ARGON2_MEMORY_KIB must be at least 8 per thread")
+	}
+	if cfg.NoteMaxTitleLength > models.MaxTitleColumn {
+		l.problem("NOTE_MAX_TITLE_LENGTH cannot exceed %d, the width of the title column", models.MaxTitleColumn)
+	}
+	if cfg.NoteMaxContentBytes > models.MaxContentColumn {
+		l.problem("NOTE_MAX_CONTENT_BYTES cannot exceed %d, the capacity of the content column", models.MaxContentColumn)
+	}
+	if !cfg.NoteHTMLPolicy.Valid() {
+		l.problem("NOTE_HTML_POLICY must be off, basic or strict, got %q", cfg.NoteHTMLPolicy)
+	}
+	for _, name := range cfg.ContentProcessors {
+		switch name {
+		case "languagetool":
+			if u, err := url.Parse(cfg.LanguageToolURL); err != nil || u.Scheme == "" || u.Host == "" {
+				l.problem("LANGUAGETOOL_URL must be an absolute URL such as https://api.languagetool.org when CONTENT_PROCESSORS lists languagetool, got %q", cfg.LanguageToolURL)
+			}
+		default:
+			l.problem("CONTENT_PROCESSORS entries must be languagetool, got %q", name)
+		}
+	}
+	if n, err := strconv.Atoi(cfg.Port); err != nil || n <= 0 || n > 65535 {
+		l.problem("PORT must be a port number, got %q", cfg.Port)
+	}
+	if n, err := strconv.Atoi(cfg.GRPCPort); err != nil || n <= 0 || n > 65535 {
+		l.problem("GRPC_PORT must be a port number, got %q", cfg.GRPCPort)
+	} else if cfg.GRPCPort == cfg.Port {
+		l.problem("GRPC_PORT must differ from PORT")
+	}
+	switch cfg.StorageDriver {
+	case "local":
+	case "s3":
+		if cfg.S3Bucket == "" {
+			l.problem("S3_BUCKET is required when STORAGE_DRIVER is s3")
+		}
+	default:
+		l.problem("STORAGE_DRIVER must be local or s3, got %q", cfg.StorageDriver)
+	}
+	switch cfg.AttachmentScanner {
+	case "":
+	case "clamav":
+		if _, _, err := net.SplitHostPort(cfg.ClamAVAddr); err != nil && !strings.HasPrefix(cfg.ClamAVAddr, "/") {
+			l.problem("CLAMAV_ADDR must be a host and port such as localhost:3310 or a socket path, got %q", cfg.ClamAVAddr)
+		}
+	default:
+		l.problem("ATTACHMENT_SCANNER must be clamav or empty, got %q", cfg.AttachmentScanner)
+	}
+	switch cfg.PDFRenderer {
+	case "":
+	case "gotenberg":
+		if u, err := url.Parse(cfg.GotenbergURL); err != nil || u.Scheme == "" || u.Host == "" {
+			l.problem("GOTENBERG_URL must be an absolute URL such as http://localhost:3000 when PDF_RENDERER is gotenberg, got %q", cfg.GotenbergURL)
+		}
+	default:
+		l.problem("PDF_RENDERER must be gotenberg or empty, got %q", cfg.PDFRenderer)
+	}
+	if cfg.SMTPAddr != "" && cfg.SMTPFrom == "" {
+		l.problem("SMTP_FROM is required when SMTP_ADDR is set")
+	}
+
+	if u, err := url.Parse(cfg.AppURL); err != nil || u.Scheme == "" || u.Host == "" {
+		l.problem("APP_URL must be an absolute URL such as https://notes.example.com, got %q", cfg.AppURL)
+	}
+
+	if cfg.OIDCIssuer != "" {
+		if u, err := url.Parse(cfg.OIDCIssuer); err != nil || u.Scheme == "" || u.Host == "" {
+			l.problem("OIDC_ISSUER must be an absolute URL such as https://example.okta.com, got %q", cfg.OIDCIssuer)
+		}
+		if cfg.OIDCClientID == "" {
+			l.problem("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+		}
+		if u, err := url.Parse(cfg.OIDCRedirectURL); err != nil || u.Scheme == "" || u.Host == "" {
+			l.problem("OIDC_REDIRECT_URL must be an absolute URL such as https://api.example.com/auth/oidc/callback when OIDC_ISSUER is set, got %q", cfg.OIDCRedirectURL)
+		}
+		if cfg.OIDCScopes == nil {
+			cfg.OIDCScopes = []string{"openid", "email", "profile"}
+		}
+	} else if cfg.OIDCEnforced {
+		l.problem("OIDC_ENFORCED requires OIDC_ISSUER, or nobody could log in")
+	}
+
+	if cfg.TracingEndpoint != "" {
+		if u, err := url.Parse(cfg.TracingEndpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			l.problem("OTEL_EXPORTER_OTLP_ENDPOINT must be an absolute URL such as http://localhost:4317, got %q", cfg.TracingEndpoint)
+		}
+	}
+
+	for _, origin := range cfg.CORSAllowedOrigins {
+		if origin == "*" {
+			if cfg.CORSAllowCredentials {
+				l.problem("CORS_ALLOWED_ORIGINS cannot be * when CORS_ALLOW_CREDENTIALS is true")
+			}
+			continue
+		}
+		if !validOrigin(origin) {
+			l.problem("CORS_ALLOWED_ORIGINS entries must look like https://example.com, got %q", origin)
+		}
+	}
+	if cfg.WSAllowedOrigins == nil {
+		cfg.WSAllowedOrigins = cfg.CORSAllowedOrigins
+	}
+	for _, origin := range cfg.WSAllowedOrigins {
+		if origin != "*" && !validOrigin(origin) {
+			l.problem("WS_ALLOWED_ORIGINS entries must look like https://example.com, got %q", origin)
+		}
+	}
+
+	if len(l.problems) > 0 {
+		return nil, &Error{Problems: l.problems}
+	}
+	return cfg, nil
+}
+
+// loader reads typed values from the environment and collects problems
+type loader struct {
+	problems []string
+}
+
+func (l *loader) problem(format string, args ...any) {
+	l.problems = append(l.problems, fmt.Sprintf(format, args...))
+}
+
+func (l *loader) string(key, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func (l *loader) required(key string) string {
+	v := l.string(key, "")
+	if v == "" {
+		l.problem("%s is required", key)
+	}
+	return v
+}
+
+func (l *loader) duration(key string, fallback time.Duration) time.Duration {
+	v := l.string(key, "")
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		l.problem("%s must be a positive duration such as 30s, got %q", key, v)
+		return fallback
+	}
+	return d
+}
+
+// optionalDuration reads a duration where zero, the default, means disabled
+func (l *loader) optionalDuration(key string) time.Duration {
+	v := l.string(key, "")
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		l.problem("%s must be a duration such as 8760h, got %q", key, v)
+		return 0
+	}
+	return d
+}
+
+func (l *loader) bool(key string, fallback bool) bool {
+	v := l.string(key, "")
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.problem("%s must be true or false, got %q", key, v)
+		return fallback
+	}
+	return b
+}
+
+// ratio reads a fraction between 0 and 1
+func (l *loader) ratio(key string, fallback float64) float64 {
+	v := l.string(key, "")
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		l.problem("%s must be a number from 0 to 1, got %q", key, v)
+		return fallback
+	}
+	return f
+}
+
+// list reads a comma-separated list, dropping empty entries
+func (l *loader) list(key string) []string {
+	var items []string
+	for _, item := range strings.Split(l.string(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// prefixes reads a comma-separated list of CIDR ranges or single addresses
+func (l *loader) prefixes(key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, item := range l.list(key) {
+		prefix, err := middleware.ParseIPPrefix(item)
+		if err != nil {
+			l.problem("%s entries must be CIDR ranges such as 10.0.0.0/8 or IP addresses, got %q", key, item)
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// jwtKeys reads the signing keys from JWT_KEYS and JWT_PRIVATE_KEYS, or
+// JWT_SECRET when JWT_KEYS is unset. Problems never quote a secret.
+func (l *loader) jwtKeys() *pkg.JWTKeys {
+	secret := l.string("JWT_SECRET", "")
+	pairs := l.list("JWT_KEYS")
+	files := l.list("JWT_PRIVATE_KEYS")
+	if secret != "" && len(pairs) > 0 {
+		l.problem("JWT_SECRET cannot be set alongside JWT_KEYS; list it there as a key instead")
+		return nil
+	}
+	if secret == "" && len(pairs) == 0 && len(files) == 0 {
+		l.problem("JWT_KEYS, JWT_PRIVATE_KEYS or JWT_SECRET is required")
+		return nil
+	}
+
+	var keys []pkg.JWTKey
+	if secret != "" {
+		keys = append(keys, pkg.JWTKey{ID: "default", Secret: []byte(secret)})
+	}
+	for i, pair := range pairs {
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok {
+			l.problem("JWT_KEYS entry %d must look like <key id>:<secret>", i+1)
+			return nil
+		}
+		keys = append(keys, pkg.JWTKey{ID: strings.TrimSpace(id), Secret: []byte(secret)})
+	}
+	for _, entry := range files {
+		id, path, ok := strings.Cut(entry, ":")
+		if !ok {
+			l.problem("JWT_PRIVATE_KEYS entries must look like <key id>:<PEM file>, got %q", entry)
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			l.problem("JWT_PRIVATE_KEYS: reading key %q: %v", id, err)
+			return nil
+		}
+		private, err := pkg.ParsePrivateKeyPEM(data)
+		if err != nil {
+			l.problem("JWT_PRIVATE_KEYS: key %q: %v", id, err)
+			return nil
+		}
+		keys = append(keys, pkg.JWTKey{ID: id, Private: private})
+	}
+
+	jwtKeys, err := pkg.NewJWTKeys(l.string("JWT_SIGNING_KEY_ID", keys[0].ID), keys...)
+	if err != nil {
+		l.problem("JWT keys: %v", err)
+		return nil
+	}
+	return jwtKeys
+}
+
+// groupMappings reads a comma-separated list of
+// <group>=<workspace id>[:<role>] entries. The group is everything before
+// the last =, so it may contain one itself.
+func (l *loader) groupMappings(key string) []oidc.GroupMapping {
+	var mappings []oidc.GroupMapping
+	for _, item := range l.list(key) {
+		i := strings.LastIndex(item, "=")
+		if i <= 0 {
+			l.problem("%s entries must look like <group>=<workspace id>[:<role>], got %q", key, item)
+			continue
+		}
+		workspaceID, role, _ := strings.Cut(item[i+1:], ":")
+		m := oidc.GroupMapping{Group: strings.TrimSpace(item[:i]), WorkspaceID: strings.TrimSpace(workspaceID), Role: strings.TrimSpace(role)}
+		if m.Role == "" {
+			m.Role = "member"
+		}
+		if m.WorkspaceID == "" {
+			l.problem("%s entries must look like <group>=<workspace id>[:<role>], got %q", key, item)
+			continue
+		}
+		if m.Role != "member" && m.Role != "admin" {
+			l.problem("%s roles must be member or admin, got %q", key, m.Role)
+			continue
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings
+}
+
+// backupKey reads BACKUP_ENCRYPTION_KEY. Problems never quote the key.
+func (l *loader) backupKey() []byte {
+	v := l.string("BACKUP_ENCRYPTION_KEY", "")
+	if v == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(key) != backup.KeySize {
+		l.problem("BACKUP_ENCRYPTION_KEY must be %d bytes encoded as base64, such as the output of openssl rand -base64 %d", backup.KeySize, backup.KeySize)
+		return nil
+	}
+	return key
+}
+
+func (l *loader) int(key string, fallback int) int {
+	v := l.string(key, "")
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		l.problem("%s must be a positive integer, got %q", key, v)
+		return fallback
+	}
+	return n
+}
+
+// validOrigin reports whether origin is a bare scheme and host, as
+// browsers send in the Origin header
+func validOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme != "" && u.Host != "" && u.Path == ""
+}
@@ -0,0 +1,361 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quanta/internal/oidc"
+	"quanta/internal/sanitize"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setRequired(t *testing.T) {
+	t.Setenv("DATABASE_URL", "user:pass@tcp(localhost:3306)/quanta")
+	t.Setenv("JWT_SECRET", "secret")
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	setRequired(t)
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "3000", cfg.Port)
+	assert.Equal(t, "9090", cfg.GRPCPort)
+	assert.Equal(t, "mysql", cfg.DBDriver)
+	assert.Equal(t, 5*time.Second, cfg.QueryTimeout)
+	assert.Equal(t, "default", cfg.JWTKeys.Current().ID)
+	assert.Equal(t, 2, cfg.PasswordHashVersion)
+	assert.Equal(t, 19456, cfg.Argon2MemoryKiB)
+	assert.Equal(t, 25, cfg.DBMaxOpenConns)
+	assert.Equal(t, 5*time.Minute, cfg.DBConnMaxLifetime)
+	assert.Equal(t, 30*time.Second, cfg.WSPingInterval)
+	assert.Equal(t, 2, cfg.WSMaxMissedPongs)
+	assert.Equal(t, 100, cfg.WSHistorySize)
+	assert.Equal(t, 5, cfg.WSMaxUserConnections)
+	assert.Equal(t, 100, cfg.WSMaxRoomConnections)
+	assert.Equal(t, 15*time.Second, cfg.WSResumeGrace)
+	assert.Equal(t, 75*time.Millisecond, cfg.WSCoalesceInterval)
+	assert.Equal(t, 10*time.Minute, cfg.WSRoomIdleTimeout)
+	assert.Equal(t, 30*time.Second, cfg.WSDeletedGrace)
+	assert.Equal(t, "local", cfg.StorageDriver)
+	assert.Equal(t, "./data/uploads", cfg.StorageLocalDir)
+	assert.Empty(t, cfg.AttachmentScanner)
+	assert.Empty(t, cfg.PDFRenderer)
+	assert.Equal(t, 24*time.Hour, cfg.BackupInterval)
+	assert.Equal(t, 7, cfg.BackupKeep)
+	assert.Nil(t, cfg.BackupKey)
+	assert.Equal(t, 255, cfg.NoteMaxTitleLength)
+	assert.Equal(t, 1<<20, cfg.NoteMaxContentBytes)
+	assert.Equal(t, sanitize.Basic, cfg.NoteHTMLPolicy)
+	assert.Empty(t, cfg.ContentProcessors)
+	assert.Equal(t, 10*time.Second, cfg.ContentProcessorDelay)
+	assert.Equal(t, 100<<20, cfg.QuotaUserBytes)
+	assert.Equal(t, 1<<30, cfg.QuotaWorkspaceBytes)
+	assert.Equal(t, 600, cfg.RateLimit)
+	assert.Equal(t, time.Minute, cfg.RateLimitWindow)
+	assert.Equal(t, "http://localhost:5173", cfg.AppURL)
+	assert.Equal(t, 7*24*time.Hour, cfg.InviteTTL)
+	assert.Empty(t, cfg.OIDCIssuer)
+	assert.True(t, cfg.OIDCJITProvisioning)
+	assert.False(t, cfg.OIDCEnforced)
+	assert.False(t, cfg.OIDCAssumeEmailVerified)
+	assert.False(t, cfg.HealthStrict)
+	assert.Equal(t, 2*time.Second, cfg.HealthCheckTimeout)
+	assert.Empty(t, cfg.TracingEndpoint)
+	assert.Equal(t, "quanta", cfg.TracingServiceName)
+	assert.Equal(t, 1.0, cfg.TracingSampleRatio)
+	assert.Equal(t, 1.0, cfg.WSEventLogSampleRatio)
+	assert.Empty(t, cfg.AdminAllowedIPs)
+	assert.Equal(t, time.Minute, cfg.IPDenylistRefresh)
+	assert.Empty(t, cfg.TrustedProxies)
+	assert.Equal(t, "X-Forwarded-For", cfg.ProxyHeader)
+	assert.False(t, cfg.DebugHTTPTrace)
+}
+
+func TestLoad_Overrides(t *testing.T) {
+	setRequired(t)
+	t.Setenv("PORT", "8080")
+	t.Setenv("GRPC_PORT", "50051")
+	t.Setenv("WS_PING_INTERVAL", "10s")
+	t.Setenv("WS_MAX_MISSED_PONGS", "4")
+	t.Setenv("STORAGE_DRIVER", "s3")
+	t.Setenv("S3_BUCKET", "uploads")
+	t.Setenv("ATTACHMENT_SCANNER", "clamav")
+	t.Setenv("CLAMAV_ADDR", "/run/clamav/clamd.ctl")
+	t.Setenv("PDF_RENDERER", "gotenberg")
+	t.Setenv("GOTENBERG_URL", "http://gotenberg:3000")
+	t.Setenv("BACKUP_INTERVAL", "6h")
+	t.Setenv("RATE_LIMIT", "120")
+	t.Setenv("RATE_LIMIT_WINDOW", "10s")
+	t.Setenv("BACKUP_KEEP", "28")
+	t.Setenv("BACKUP_ENCRYPTION_KEY", "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("HSTS_MAX_AGE", "8760h")
+	t.Setenv("PASSWORD_HASH_VERSION", "3")
+	t.Setenv("ARGON2_TIME", "4")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4317")
+	t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+	t.Setenv("HEALTH_STRICT", "true")
+	t.Setenv("ADMIN_ALLOWED_IPS", "10.0.0.0/8, 192.168.1.7, 2001:db8::/32")
+	t.Setenv("IP_DENYLIST", "203.0.113.0/24")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.2")
+	t.Setenv("PROXY_HEADER", "X-Real-IP")
+	t.Setenv("DEBUG_HTTP_TRACE", "true")
+	t.Setenv("DEBUG_HTTP_TRACE_SAMPLE_RATIO", "0.1")
+	t.Setenv("DEBUG_HTTP_TRACE_USER", "user123")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "8080", cfg.Port)
+	assert.Equal(t, "50051", cfg.GRPCPort)
+	assert.Equal(t, 10*time.Second, cfg.WSPingInterval)
+	assert.Equal(t, 4, cfg.WSMaxMissedPongs)
+	assert.Equal(t, "uploads", cfg.S3Bucket)
+	assert.Equal(t, "clamav", cfg.AttachmentScanner)
+	assert.Equal(t, "/run/clamav/clamd.ctl", cfg.ClamAVAddr)
+	assert.Equal(t, "gotenberg", cfg.PDFRenderer)
+	assert.Equal(t, "http://gotenberg:3000", cfg.GotenbergURL)
+	assert.Equal(t, 6*time.Hour, cfg.BackupInterval)
+	assert.Equal(t, 120, cfg.RateLimit)
+	assert.Equal(t, 10*time.Second, cfg.RateLimitWindow)
+	assert.Equal(t, 28, cfg.BackupKeep)
+	assert.Len(t, cfg.BackupKey, 32)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.CORSAllowedOrigins)
+	assert.True(t, cfg.CORSAllowCredentials)
+	assert.Equal(t, cfg.CORSAllowedOrigins, cfg.WSAllowedOrigins)
+	assert.Equal(t, 8760*time.Hour, cfg.HSTSMaxAge)
+	assert.Equal(t, 3, cfg.PasswordHashVersion)
+	assert.Equal(t, 4, cfg.Argon2Time)
+	assert.Equal(t, "http://collector:4317", cfg.TracingEndpoint)
+	assert.Equal(t, 0.25, cfg.TracingSampleRatio)
+	assert.True(t, cfg.HealthStrict)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, cfg.AdminAllowedIPs)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}, cfg.IPDenylist)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32")}, cfg.TrustedProxies)
+	assert.Equal(t, "X-Real-IP", cfg.ProxyHeader)
+	assert.True(t, cfg.DebugHTTPTrace)
+	assert.Equal(t, 0.1, cfg.DebugHTTPTraceSampleRatio)
+	assert.Equal(t, "user123", cfg.DebugHTTPTraceUser)
+}
+
+func TestLoad_JWTKeys(t *testing.T) {
+	setRequired(t)
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_KEYS", "2026-01:old-secret, 2026-06:new:secret")
+	t.Setenv("JWT_SIGNING_KEY_ID", "2026-06")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "2026-06", cfg.JWTKeys.Current().ID)
+	assert.Equal(t, []byte("new:secret"), cfg.JWTKeys.Current().Secret)
+}
+
+func TestLoad_JWTPrivateKeys(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("error encoding key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("error writing key: %v", err)
+	}
+
+	setRequired(t)
+	t.Setenv("JWT_PRIVATE_KEYS", "ed-2026:"+path)
+	t.Setenv("JWT_SIGNING_KEY_ID", "ed-2026")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "EdDSA", cfg.JWTKeys.Current().Method().Alg())
+	assert.Len(t, cfg.JWTKeys.JWKS().Keys, 1)
+}
+
+func TestLoad_JWTKeysProblems(t *testing.T) {
+	testCases := []struct {
+		name, secret, keys, signingKey string
+		expected                       string
+	}{
+		{
+			name:     "Both Set",
+			secret:   "secret",
+			keys:     "a:one",
+			expected: "JWT_SECRET cannot be set alongside JWT_KEYS; list it there as a key instead",
+		},
+		{
+			name:     "Malformed Entry",
+			keys:     "a:one,two",
+			expected: "JWT_KEYS entry 2 must look like <key id>:<secret>",
+		},
+		{
+			name:       "Unknown Signing Key",
+			keys:       "a:one,b:two",
+			signingKey: "c",
+			expected:   `JWT keys: signing key "c" is not among the keys`,
+		},
+		{
+			name:     "Duplicate ID",
+			keys:     "a:one,a:two",
+			expected: `JWT keys: key "a" is listed twice`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			setRequired(t)
+			t.Setenv("JWT_SECRET", tc.secret)
+			t.Setenv("JWT_KEYS", tc.keys)
+			t.Setenv("JWT_SIGNING_KEY_ID", tc.signingKey)
+
+			_, err := Load()
+			var cfgErr *Error
+			if assert.True(t, errors.As(err, &cfgErr)) {
+				assert.Equal(t, []string{tc.expected}, cfgErr.Problems)
+			}
+		})
+	}
+}
+
+func TestLoad_OIDC(t *testing.T) {
+	setRequired(t)
+	t.Setenv("OIDC_ISSUER", "https://example.okta.com")
+	t.Setenv("OIDC_CLIENT_ID", "quanta")
+	t.Setenv("OIDC_CLIENT_SECRET", "s3cret")
+	t.Setenv("OIDC_REDIRECT_URL", "https://api.example.com/auth/oidc/callback")
+	t.Setenv("OIDC_GROUPS_CLAIM", "roles")
+	t.Setenv("OIDC_GROUP_WORKSPACES", "eng=ws1, cn=ops=ws2:admin")
+	t.Setenv("OIDC_ENFORCED", "true")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"openid", "email", "profile"}, cfg.OIDCScopes)
+	assert.Equal(t, "email", cfg.OIDCEmailClaim)
+	assert.Equal(t, "roles", cfg.OIDCGroupsClaim)
+	assert.Equal(t, []oidc.GroupMapping{
+		{Group: "eng", WorkspaceID: "ws1", Role: "member"},
+		{Group: "cn=ops", WorkspaceID: "ws2", Role: "admin"},
+	}, cfg.OIDCGroupWorkspaces)
+	assert.True(t, cfg.OIDCEnforced)
+}
+
+func TestLoad_OIDCProblems(t *testing.T) {
+	setRequired(t)
+	t.Setenv("OIDC_ISSUER", "example.okta.com")
+	t.Setenv("OIDC_GROUP_WORKSPACES", "eng,ops=ws2:owner")
+
+	_, err := Load()
+	var cfgErr *Error
+	if assert.True(t, errors.As(err, &cfgErr)) {
+		assert.ElementsMatch(t, []string{
+			`OIDC_ISSUER must be an absolute URL such as https://example.okta.com, got "example.okta.com"`,
+			"OIDC_CLIENT_ID is required when OIDC_ISSUER is set",
+			`OIDC_REDIRECT_URL must be an absolute URL such as https://api.example.com/auth/oidc/callback when OIDC_ISSUER is set, got ""`,
+			`OIDC_GROUP_WORKSPACES entries must look like <group>=<workspace id>[:<role>], got "eng"`,
+			`OIDC_GROUP_WORKSPACES roles must be member or admin, got "owner"`,
+		}, cfgErr.Problems)
+	}
+
+	t.Setenv("OIDC_ISSUER", "")
+	t.Setenv("OIDC_GROUP_WORKSPACES", "")
+	t.Setenv("OIDC_ENFORCED", "true")
+	_, err = Load()
+	if assert.True(t, errors.As(err, &cfgErr)) {
+		assert.Equal(t, []string{"OIDC_ENFORCED requires OIDC_ISSUER, or nobody could log in"}, cfgErr.Problems)
+	}
+}
+
+func TestLoad_MemoryDriverNeedsNoURL(t *testing.T) {
+	t.Setenv("DB_DRIVER", "memory")
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("JWT_SECRET", "secret")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "memory", cfg.DBDriver)
+}
+
+func TestLoad_SQLiteDefaultsToDataDir(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("JWT_SECRET", "secret")
+
+	cfg, err := Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "./data/quanta.db", cfg.DatabaseURL)
+}
+
+func TestLoad_ReportsAllProblems(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("PORT", "http")
+	t.Setenv("GRPC_PORT", "0")
+	t.Setenv("WS_PING_INTERVAL", "soon")
+	t.Setenv("WS_MAX_MISSED_PONGS", "-1")
+	t.Setenv("STORAGE_DRIVER", "s3")
+	t.Setenv("S3_BUCKET", "")
+	t.Setenv("ATTACHMENT_SCANNER", "clamav")
+	t.Setenv("CLAMAV_ADDR", "clamd")
+	t.Setenv("PDF_RENDERER", "wkhtmltopdf")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*,example.com")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "yes please")
+	t.Setenv("WS_ALLOWED_ORIGINS", "https://notes.example.com/app")
+	t.Setenv("NOTE_MAX_TITLE_LENGTH", "300")
+	t.Setenv("NOTE_HTML_POLICY", "lenient")
+	t.Setenv("CONTENT_PROCESSORS", "languagetool, spellbot")
+	t.Setenv("APP_URL", "notes.example.com")
+	t.Setenv("PASSWORD_HASH_VERSION", "1")
+	t.Setenv("ARGON2_THREADS", "300")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "collector:4317")
+	t.Setenv("TRACING_SAMPLE_RATIO", "2")
+	t.Setenv("IP_DENYLIST", "10.0.0.0/33")
+	t.Setenv("BACKUP_ENCRYPTION_KEY", "c2hvcnQ=")
+
+	cfg, err := Load()
+	assert.Nil(t, cfg)
+
+	var cfgErr *Error
+	if assert.True(t, errors.As(err, &cfgErr)) {
+		assert.ElementsMatch(t, []string{
+			"DATABASE_URL is required",
+			"JWT_KEYS, JWT_PRIVATE_KEYS or JWT_SECRET is required",
+			`WS_PING_INTERVAL must be a positive duration such as 30s, got "soon"`,
+			`WS_MAX_MISSED_PONGS must be a positive integer, got "-1"`,
+			`PORT must be a port number, got "http"`,
+			`GRPC_PORT must be a port number, got "0"`,
+			"S3_BUCKET is required when STORAGE_DRIVER is s3",
+			`CLAMAV_ADDR must be a host and port such as localhost:3310 or a socket path, got "clamd"`,
+			`PDF_RENDERER must be gotenberg or empty, got "wkhtmltopdf"`,
+			`CORS_ALLOW_CREDENTIALS must be true or false, got "yes please"`,
+			`CORS_ALLOWED_ORIGINS entries must look like https://example.com, got "example.com"`,
+			`WS_ALLOWED_ORIGINS entries must look like https://example.com, got "https://notes.example.com/app"`,
+			"NOTE_MAX_TITLE_LENGTH cannot exceed 255, the width of the title column",
+			`NOTE_HTML_POLICY must be off, basic or strict, got "lenient"`,
+			`LANGUAGETOOL_URL must be an absolute URL such as https://api.languagetool.org when CONTENT_PROCESSORS lists languagetool, got ""`,
+			`CONTENT_PROCESSORS entries must be languagetool, got "spellbot"`,
+			"BACKUP_ENCRYPTION_KEY must be 32 bytes encoded as base64, such as the output of openssl rand -base64 32",
+			`APP_URL must be an absolute URL such as https://notes.example.com, got "notes.example.com"`,
+			"PASSWORD_HASH_VERSION must be greater than 1, which marks bcrypt hashes",
+			"ARGON2_THREADS cannot exceed 255",
+			`OTEL_EXPORTER_OTLP_ENDPOINT must be an absolute URL such as http://localhost:4317, got "collector:4317"`,
+			`TRACING_SAMPLE_RATIO must be a number from 0 to 1, got "2"`,
+			`IP_DENYLIST entries must be CIDR ranges such as 10.0.0.0/8 or IP addresses, got "10.0.0.0/33"`,
+		}, cfgErr.Problems)
+	}
+}
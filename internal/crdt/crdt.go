@@ -0,0 +1,282 @@
+// Package crdt implements the replicated growable array (RGA) notes are
+// merged with when clients edit them offline. Every character inserted
+// into a document gets an ID made of the inserting client's name and a
+// Lamport clock, and is placed after the character it was typed after;
+// deleted characters are kept as tombstones. Replicas that have applied
+// the same operations, in any causal order, hold the same text.
+package crdt
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// MaxClientLength is the longest client name an ID may carry
+const MaxClientLength = 64
+
+var (
+	// ErrInvalidOp is returned for operations that are malformed or break
+	// the clock rules
+	ErrInvalidOp = errors.New("invalid operation")
+	// ErrMissingDependency is returned for operations that refer to a
+	// character the document hasn't seen
+	ErrMissingDependency = errors.New("operation depends on changes not applied yet")
+)
+
+// ID identifies a character, or a deletion, by the client that made it and
+// that client's Lamport clock when it did. A client's clock is greater than
+// every clock it has seen, so IDs order each edit after everything its
+// author knew about.
+type ID struct {
+	Client string `json:"client"`
+	Clock  uint64 `json:"clock"`
+}
+
+// after reports whether a orders after b: by clock, then by client
+func (a ID) after(b ID) bool {
+	if a.Clock != b.Clock {
+		return a.Clock > b.Clock
+	}
+	return a.Client > b.Client
+}
+
+func (a ID) String() string {
+	return fmt.Sprintf("%s:%d", a.Client, a.Clock)
+}
+
+// Op is one operation on a document: either an insert of Text after the
+// character Origin, or at the start when Origin is nil, or the deletion of
+// the character Delete. The characters of an insert take consecutive
+// clocks starting from ID's.
+type Op struct {
+	ID     ID     `json:"id"`
+	Origin *ID    `json:"origin,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Delete *ID    `json:"delete,omitempty"`
+}
+
+// last is the clock of the last character the operation takes
+func (op Op) last() uint64 {
+	if op.Delete != nil {
+		return op.ID.Clock
+	}
+	return op.ID.Clock + uint64(utf8.RuneCountInString(op.Text)) - 1
+}
+
+// StateVector holds the last clock of each client a document has applied
+// operations from. A client's operations must reach a document in the
+// order it made them, so the vector names everything the document has
+// seen; one with a clock at or below its client's entry is a duplicate.
+type StateVector map[string]uint64
+
+// item is a character of the document, or its tombstone
+type item struct {
+	id      ID
+	r       rune
+	deleted bool
+}
+
+// Doc is a replica of a document. The zero value is not usable; create one
+// with New or Load.
+type Doc struct {
+	items  []item
+	known  map[ID]bool
+	ops    []Op
+	vector StateVector
+	clock  uint64
+}
+
+// New creates an empty document
+func New() *Doc {
+	return &Doc{known: make(map[ID]bool), vector: StateVector{}}
+}
+
+// Load recreates a document from the operations returned by its Ops
+func Load(ops []Op) (*Doc, error) {
+	d := New()
+	if _, err := d.Apply(ops); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Text returns the document's visible text
+func (d *Doc) Text() string {
+	buf := make([]byte, 0, len(d.items))
+	for _, it := range d.items {
+		if !it.deleted {
+			buf = utf8.AppendRune(buf, it.r)
+		}
+	}
+	return string(buf)
+}
+
+// Vector returns a copy of the document's state vector
+func (d *Doc) Vector() StateVector {
+	v := make(StateVector, len(d.vector))
+	for client, clock := range d.vector {
+		v[client] = clock
+	}
+	return v
+}
+
+// Ops returns every operation the document has applied, in the order it
+// applied them
+func (d *Doc) Ops() []Op {
+	return d.ops
+}
+
+// Since returns the operations a replica at state vector since is missing,
+// in an order it can apply them in
+func (d *Doc) Since(since StateVector) []Op {
+	ops := []Op{}
+	for _, op := range d.ops {
+		if op.ID.Clock > since[op.ID.Client] {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// Apply applies operations in order, skipping those the document has
+// already seen, and returns the ones it applied. It stops at the first
+// operation it can't apply, keeping those before it, and returns an error
+// wrapping ErrInvalidOp or ErrMissingDependency.
+func (d *Doc) Apply(ops []Op) ([]Op, error) {
+	var applied []Op
+	for i, op := range ops {
+		if op.ID.Clock != 0 && op.ID.Clock <= d.vector[op.ID.Client] {
+			continue
+		}
+		if err := d.apply(op); err != nil {
+			return applied, fmt.Errorf("op %d (%s): %w", i, op.ID, err)
+		}
+		applied = append(applied, op)
+	}
+	return applied, nil
+}
+
+// apply applies an operation the document hasn't seen
+func (d *Doc) apply(op Op) error {
+	if op.ID.Client == "" || len(op.ID.Client) > MaxClientLength || op.ID.Clock == 0 {
+		return fmt.Errorf("%w: id needs a client of up to %d bytes and a clock above 0", ErrInvalidOp, MaxClientLength)
+	}
+	if (op.Delete == nil) == (op.Text == "") {
+		return fmt.Errorf("%w: an op either inserts text or deletes a character", ErrInvalidOp)
+	}
+	if op.Delete != nil && op.Origin != nil {
+		return fmt.Errorf("%w: a delete has no origin", ErrInvalidOp)
+	}
+	if !utf8.ValidString(op.Text) {
+		return fmt.Errorf("%w: text is not valid UTF-8", ErrInvalidOp)
+	}
+
+	// The character an op refers to must have been seen, and so come
+	// before it in its author's clock
+	ref := op.Origin
+	if op.Delete != nil {
+		ref = op.Delete
+	}
+	if ref != nil {
+		if !d.known[*ref] {
+			return fmt.Errorf("%w: %s", ErrMissingDependency, *ref)
+		}
+		if ref.Clock >= op.ID.Clock {
+			return fmt.Errorf("%w: clock must be above that of %s", ErrInvalidOp, *ref)
+		}
+	}
+
+	if op.Delete != nil {
+		for i := range d.items {
+			if d.items[i].id == *op.Delete {
+				d.items[i].deleted = true
+				break
+			}
+		}
+	} else {
+		d.insert(op)
+	}
+
+	d.ops = append(d.ops, op)
+	last := op.last()
+	d.vector[op.ID.Client] = last
+	d.clock = max(d.clock, last)
+	return nil
+}
+
+// insert places an insert's characters. They go after their origin, past
+// any characters inserted there concurrently with a later ID and
+// everything inserted after those; under Lamport clocks that is every
+// following character with a later ID than the first one inserted.
+func (d *Doc) insert(op Op) {
+	pos := 0
+	if op.Origin != nil {
+		for i, it := range d.items {
+			if it.id == *op.Origin {
+				pos = i + 1
+				break
+			}
+		}
+	}
+	for pos < len(d.items) && d.items[pos].id.after(op.ID) {
+		pos++
+	}
+
+	run := make([]item, 0, utf8.RuneCountInString(op.Text))
+	clock := op.ID.Clock
+	for _, r := range op.Text {
+		id := ID{Client: op.ID.Client, Clock: clock}
+		run = append(run, item{id: id, r: r})
+		d.known[id] = true
+		clock++
+	}
+	d.items = append(d.items[:pos], append(run, d.items[pos:]...)...)
+}
+
+// SetText makes the operations, as client, that change the document's text
+// to text, applies them and returns them. Only the changed middle of the
+// text is replaced, so edits made concurrently elsewhere still merge.
+func (d *Doc) SetText(client, text string) []Op {
+	var visible []int
+	for i, it := range d.items {
+		if !it.deleted {
+			visible = append(visible, i)
+		}
+	}
+	want := []rune(text)
+
+	prefix := 0
+	for prefix < len(visible) && prefix < len(want) && d.items[visible[prefix]].r == want[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(visible)-prefix && suffix < len(want)-prefix &&
+		d.items[visible[len(visible)-1-suffix]].r == want[len(want)-1-suffix] {
+		suffix++
+	}
+
+	var ops []Op
+	for _, i := range visible[prefix : len(visible)-suffix] {
+		target := d.items[i].id
+		ops = append(ops, Op{ID: ID{Client: client, Clock: d.clock + 1}, Delete: &target})
+		d.mustApply(ops[len(ops)-1])
+	}
+	if inserted := want[prefix : len(want)-suffix]; len(inserted) > 0 {
+		op := Op{ID: ID{Client: client, Clock: d.clock + 1}, Text: string(inserted)}
+		if prefix > 0 {
+			origin := d.items[visible[prefix-1]].id
+			op.Origin = &origin
+		}
+		ops = append(ops, op)
+		d.mustApply(op)
+	}
+	return ops
+}
+
+// mustApply applies an operation the document made itself
+func (d *Doc) mustApply(op Op) {
+	if err := d.apply(op); err != nil {
+		panic(fmt.Sprintf("crdt: applying own op: %v", err))
+	}
+}
@@ -0,0 +1,102 @@
+package crdt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replica starts a document from another's operations
+func replica(t *testing.T, d *Doc) *Doc {
+	t.Helper()
+	r, err := Load(d.Ops())
+	require.NoError(t, err)
+	return r
+}
+
+func TestSetText(t *testing.T) {
+	d := New()
+	ops := d.SetText("a", "hello world")
+	require.Len(t, ops, 1)
+	assert.Equal(t, Op{ID: ID{Client: "a", Clock: 1}, Text: "hello world"}, ops[0])
+
+	// Only the changed middle is replaced
+	ops = d.SetText("a", "hello, wörld")
+	assert.Equal(t, "hello, wörld", d.Text())
+	assert.Equal(t, []Op{
+		{ID: ID{Client: "a", Clock: 12}, Delete: &ID{Client: "a", Clock: 6}},
+		{ID: ID{Client: "a", Clock: 13}, Delete: &ID{Client: "a", Clock: 7}},
+		{ID: ID{Client: "a", Clock: 14}, Delete: &ID{Client: "a", Clock: 8}},
+		{ID: ID{Client: "a", Clock: 15}, Origin: &ID{Client: "a", Clock: 5}, Text: ", wö"},
+	}, ops)
+	assert.Equal(t, StateVector{"a": 18}, d.Vector())
+
+	assert.Empty(t, d.SetText("a", "hello, wörld"))
+	d.SetText("a", "")
+	assert.Empty(t, d.Text())
+}
+
+func TestConcurrentEditsConverge(t *testing.T) {
+	base := New()
+	base.SetText("server", "The cat sat")
+
+	alice, bob := replica(t, base), replica(t, base)
+	fromAlice := alice.SetText("alice", "The black cat sat")
+	fromBob := bob.SetText("bob", "The cat sat down")
+	// Both type at the same place too
+	fromAlice = append(fromAlice, alice.SetText("alice", "Yes. The black cat sat")...)
+	fromBob = append(fromBob, bob.SetText("bob", "No. The cat sat down")...)
+
+	_, err := alice.Apply(fromBob)
+	require.NoError(t, err)
+	_, err = bob.Apply(fromAlice)
+	require.NoError(t, err)
+
+	assert.Equal(t, alice.Text(), bob.Text())
+	assert.Contains(t, alice.Text(), "black cat sat down")
+	assert.Equal(t, alice.Vector(), bob.Vector())
+
+	// Operations already applied are skipped
+	applied, err := alice.Apply(fromAlice)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestSince(t *testing.T) {
+	d := New()
+	d.SetText("a", "one")
+	seen := d.Vector()
+	later := d.SetText("b", "one two")
+
+	assert.Equal(t, later, d.Since(seen))
+	assert.Equal(t, d.Ops(), d.Since(nil))
+	assert.Empty(t, d.Since(d.Vector()))
+}
+
+func TestApplyErrors(t *testing.T) {
+	d := New()
+	d.SetText("a", "abc")
+
+	testCases := []struct {
+		name     string
+		op       Op
+		expected error
+	}{
+		{name: "no client", op: Op{ID: ID{Clock: 9}, Text: "x"}, expected: ErrInvalidOp},
+		{name: "empty", op: Op{ID: ID{Client: "b", Clock: 9}}, expected: ErrInvalidOp},
+		{name: "insert and delete", op: Op{ID: ID{Client: "b", Clock: 9}, Text: "x", Delete: &ID{Client: "a", Clock: 1}}, expected: ErrInvalidOp},
+		{name: "clock behind origin", op: Op{ID: ID{Client: "b", Clock: 2}, Origin: &ID{Client: "a", Clock: 3}, Text: "x"}, expected: ErrInvalidOp},
+		{name: "unknown origin", op: Op{ID: ID{Client: "b", Clock: 9}, Origin: &ID{Client: "c", Clock: 4}, Text: "x"}, expected: ErrMissingDependency},
+		{name: "unknown delete", op: Op{ID: ID{Client: "b", Clock: 9}, Delete: &ID{Client: "a", Clock: 4}}, expected: ErrMissingDependency},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			applied, err := d.Apply([]Op{tc.op})
+			assert.ErrorIs(t, err, tc.expected)
+			assert.Empty(t, applied)
+			assert.Equal(t, "abc", d.Text())
+		})
+	}
+}
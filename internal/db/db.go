@@ -3,31 +3,94 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
-	"os"
+	"time"
 
-	// Import MySQL driver for database connection.
-	// This blank import is needed to register the MySQL driver.
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
-// DB is the global database connection instance used throughout the application
-var DB *sql.DB
+// Supported values for Options.Driver
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	// DriverSQLite runs against an embedded SQLite file, creating the schema
+	// on startup, so the server needs no external database
+	DriverSQLite = "sqlite"
+	// DriverMemory runs against a throwaway in-memory SQLite database with
+	// the schema already applied, for local development and tests
+	DriverMemory = "memory"
+)
+
+// PoolConfig tunes the connection pool
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Options selects the database to connect to
+type Options struct {
+	Driver string
+	DSN    string
+	Pool   PoolConfig
+}
+
+// Connect opens a connection pool to the configured database and verifies
+// it with a ping. Queries are written with MySQL-style ? placeholders on
+// every driver, and each one is traced as a span of its context.
+func Connect(ctx context.Context, opts Options) (*sql.DB, error) {
+	switch opts.Driver {
+	case DriverMemory:
+		return OpenMemory(ctx)
+	case DriverSQLite:
+		return OpenSQLite(ctx, opts.DSN, opts.Pool)
+	}
 
-// Connect establishes a connection to the MySQL database using environment
-// variables and initializes the global DB instance
-func Connect() {
-	dsn := os.Getenv("DATABASE_URL")
-	db, err := sql.Open("mysql", dsn)
+	driverName, dsn, err := driverFor(opts)
 	if err != nil {
-		log.Fatalf("Failed to open DB: %v", err)
+		return nil, err
 	}
 
-	if err := db.Ping(); err != nil {
-		log.Fatalf("Failed to connect to DB: %v", err)
+	db, err := open(driverName, dsn, DialectFor(opts.Driver))
+	if err != nil {
+		return nil, fmt.Errorf("open DB: %w", err)
+	}
+
+	db.SetMaxOpenConns(opts.Pool.MaxOpenConns)
+	db.SetMaxIdleConns(opts.Pool.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.Pool.ConnMaxLifetime)
+
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("connect to DB: %w", err)
 	}
 
-	DB = db
-	log.Println("Connected to MySQL database 🎉")
+	log.Printf("Connected to %s database 🎉", opts.Driver)
+	return db, nil
+}
+
+// driverFor maps a configured driver to the registered database/sql driver
+// name and the DSN to open it with
+func driverFor(opts Options) (string, string, error) {
+	switch opts.Driver {
+	case DriverMySQL:
+		// TIMESTAMP columns are scanned into time.Time, which the MySQL
+		// driver only does with parseTime enabled. Handlers treat zero
+		// affected rows as "not found", so RowsAffected must count matched
+		// rows like Postgres and SQLite do, not only changed ones.
+		cfg, err := mysql.ParseDSN(opts.DSN)
+		if err != nil {
+			return "", "", fmt.Errorf("parse MySQL DSN: %w", err)
+		}
+		cfg.ParseTime = true
+		cfg.ClientFoundRows = true
+		return "mysql", cfg.FormatDSN(), nil
+	case DriverPostgres:
+		return postgresDriverName, opts.DSN, nil
+	default:
+		return "", "", fmt.Errorf("unknown database driver %q", opts.Driver)
+	}
 }
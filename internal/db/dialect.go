@@ -0,0 +1,224 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	//go:embed migrations.sql
+	mysqlSchema string
+	//go:embed migrations_postgres.sql
+	postgresSchema string
+	//go:embed migrations_sqlite.sql
+	sqliteSchema string
+)
+
+// Dialect captures the SQL differences between the supported databases
+type Dialect string
+
+// Supported dialects
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// DialectFor returns the dialect spoken by a configured driver
+func DialectFor(driver string) Dialect {
+	switch driver {
+	case DriverPostgres:
+		return Postgres
+	case DriverSQLite, DriverMemory:
+		return SQLite
+	default:
+		return MySQL
+	}
+}
+
+// Rebind rewrites ? placeholders into the dialect's placeholder style.
+// Question marks inside quoted strings and identifiers are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(ch)
+	}
+	return b.String()
+}
+
+// Upsert returns the clause that turns an INSERT into an upsert: on a
+// conflict over the conflict columns, the update columns are overwritten
+// with the inserted values
+func (d Dialect) Upsert(conflict, update []string) string {
+	sets := make([]string, len(update))
+	if d == MySQL {
+		for i, col := range update {
+			sets[i] = fmt.Sprintf("%s = VALUES(%s)", col, col)
+		}
+		return "ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	}
+
+	for i, col := range update {
+		sets[i] = fmt.Sprintf("%s = excluded.%s", col, col)
+	}
+	return fmt.Sprintf("ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(conflict, ", "), strings.Join(sets, ", "))
+}
+
+// JSONText returns an expression for the text of a top-level key of the
+// JSON object in column, NULL when the key is missing
+func (d Dialect) JSONText(column, key string) string {
+	switch d {
+	case Postgres:
+		return fmt.Sprintf("(%s::jsonb ->> '%s')", column, key)
+	case SQLite:
+		return fmt.Sprintf("json_extract(%s, '$.%s')", column, key)
+	default:
+		return fmt.Sprintf("JSON_UNQUOTE(JSON_EXTRACT(%s, '$.%s'))", column, key)
+	}
+}
+
+// JSONHolds returns a condition that a top-level key of the JSON object in
+// column is the string bound to its placeholder, or a list holding it
+func (d Dialect) JSONHolds(column, key string) string {
+	switch d {
+	case Postgres:
+		return fmt.Sprintf("(%s::jsonb -> '%s') @> to_jsonb(CAST(? AS TEXT))", column, key)
+	case SQLite:
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(%s, '$.%s') WHERE value = ?)", column, key)
+	default:
+		return fmt.Sprintf("JSON_CONTAINS(JSON_EXTRACT(%s, '$.%s'), JSON_QUOTE(?))", column, key)
+	}
+}
+
+// EscapeLike escapes the LIKE wildcards in s using ! as the escape
+// character, which needs no quoting on any of the supported databases.
+// Queries must say ESCAPE '!' after the pattern.
+func EscapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+// Schema returns the dialect's CREATE TABLE statements
+func (d Dialect) Schema() string {
+	switch d {
+	case Postgres:
+		return postgresSchema
+	case SQLite:
+		return sqliteSchema
+	default:
+		return mysqlSchema
+	}
+}
+
+// Tables returns the names of the tables the dialect's schema creates
+func (d Dialect) Tables() []string {
+	var tables []string
+	for _, stmt := range statements(d.Schema()) {
+		if m := createTable.FindStringSubmatch(stmt); m != nil {
+			tables = append(tables, m[1])
+		}
+	}
+	return tables
+}
+
+var createTable = regexp.MustCompile(`(?i)^CREATE TABLE (?:IF NOT EXISTS )?(\w+)`)
+
+// Queryer is the part of *sql.DB that MissingTables needs
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// MissingTables returns the schema's tables that don't exist in the
+// database, which means it predates a schema change that hasn't been
+// applied yet
+func MissingTables(ctx context.Context, db Queryer, dialect Dialect) ([]string, error) {
+	var query string
+	switch dialect {
+	case Postgres:
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()"
+	case SQLite:
+		query = "SELECT name FROM sqlite_master WHERE type = 'table'"
+	default:
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()"
+	}
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	existing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("listing tables: %w", err)
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("listing tables: %w", err)
+	}
+
+	var missing []string
+	for _, table := range dialect.Tables() {
+		if !existing[strings.ToLower(table)] {
+			missing = append(missing, table)
+		}
+	}
+	return missing, nil
+}
+
+// statements splits a schema file into individual statements, dropping
+// comment lines
+func statements(schema string) []string {
+	var lines []string
+	for _, line := range strings.Split(schema, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	var stmts []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// ApplySchema runs the dialect's CREATE TABLE IF NOT EXISTS statements
+func ApplySchema(ctx context.Context, db *sql.DB, dialect Dialect) error {
+	for _, stmt := range statements(dialect.Schema()) {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("apply schema: %w", err)
+		}
+	}
+	return nil
+}
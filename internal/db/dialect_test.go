@@ -0,0 +1,73 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialect_Rebind(t *testing.T) {
+	testCases := []struct {
+		name     string
+		dialect  Dialect
+		query    string
+		expected string
+	}{
+		{
+			name:     "MySQL Unchanged",
+			dialect:  MySQL,
+			query:    "SELECT id FROM notes WHERE id = ? AND user_id = ?",
+			expected: "SELECT id FROM notes WHERE id = ? AND user_id = ?",
+		},
+		{
+			name:     "Postgres Numbered",
+			dialect:  Postgres,
+			query:    "SELECT id FROM notes WHERE id = ? AND user_id = ?",
+			expected: "SELECT id FROM notes WHERE id = $1 AND user_id = $2",
+		},
+		{
+			name:     "Postgres Skips Quoted",
+			dialect:  Postgres,
+			query:    "SELECT '?' AS q, \"a?\" FROM notes WHERE id = ?",
+			expected: "SELECT '?' AS q, \"a?\" FROM notes WHERE id = $1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.dialect.Rebind(tc.query))
+		})
+	}
+}
+
+func TestDialect_Upsert(t *testing.T) {
+	conflict := []string{"note_id", "user_id"}
+	update := []string{"created_at"}
+
+	assert.Equal(t, "ON DUPLICATE KEY UPDATE created_at = VALUES(created_at)", MySQL.Upsert(conflict, update))
+	assert.Equal(t, "ON CONFLICT (note_id, user_id) DO UPDATE SET created_at = excluded.created_at", Postgres.Upsert(conflict, update))
+	assert.Equal(t, Postgres.Upsert(conflict, update), SQLite.Upsert(conflict, update))
+}
+
+func TestDialect_JSON(t *testing.T) {
+	assert.Equal(t, "JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.notebook'))", MySQL.JSONText("metadata", "notebook"))
+	assert.Equal(t, "(metadata::jsonb ->> 'notebook')", Postgres.JSONText("metadata", "notebook"))
+	assert.Equal(t, "json_extract(metadata, '$.notebook')", SQLite.JSONText("metadata", "notebook"))
+
+	assert.Equal(t, "JSON_CONTAINS(JSON_EXTRACT(metadata, '$.tags'), JSON_QUOTE(?))", MySQL.JSONHolds("metadata", "tags"))
+	assert.Equal(t, "(metadata::jsonb -> 'tags') @> to_jsonb(CAST($1 AS TEXT))", Postgres.Rebind(Postgres.JSONHolds("metadata", "tags")))
+	assert.Equal(t, "EXISTS (SELECT 1 FROM json_each(metadata, '$.tags') WHERE value = ?)", SQLite.JSONHolds("metadata", "tags"))
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, "plain", EscapeLike("plain"))
+	assert.Equal(t, "100!% !_done!!", EscapeLike("100% _done!"))
+}
+
+func TestSchemasDefineTheSameTables(t *testing.T) {
+	tables := MySQL.Tables()
+	assert.Contains(t, tables, "users")
+	assert.Contains(t, tables, "sessions")
+	assert.Equal(t, tables, Postgres.Tables())
+	assert.Equal(t, tables, SQLite.Tables())
+}
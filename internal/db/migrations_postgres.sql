@@ -0,0 +1,586 @@
+-- Postgres schema. Keep in sync with migrations.sql (MySQL) and
+-- migrations_sqlite.sql.
+
+-- users table. password_version 1 is bcrypt; later versions are argon2id
+-- parameter sets, see pkg.Argon2Params. public_key is the key others wrap
+-- encrypted notes' keys with when sharing them with the user.
+CREATE TABLE IF NOT EXISTS users (
+    id CHAR(36) PRIMARY KEY,
+    email VARCHAR(255) UNIQUE NOT NULL,
+    password TEXT NOT NULL,
+    password_version INT NOT NULL DEFAULT 1,
+    display_name VARCHAR(100),
+    avatar_url VARCHAR(2048),
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    public_key TEXT,
+    role VARCHAR(16) NOT NULL DEFAULT 'user',
+    token_version INT NOT NULL DEFAULT 0,
+    failed_logins INT NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP NULL
+);
+
+-- workspaces table. Members share every note in the workspace.
+CREATE TABLE IF NOT EXISTS workspaces (
+    id CHAR(36) PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- workspace members table. role is owner, admin or member.
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id CHAR(36) NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (workspace_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_workspace_members_user ON workspace_members (user_id);
+
+-- workspace invitations table. Each invitation is redeemed with a token
+-- sent by email or shared as a link; only the token's hash is stored.
+-- Invitations without an email can be accepted by anyone holding the link.
+CREATE TABLE IF NOT EXISTS workspace_invitations (
+    id CHAR(36) PRIMARY KEY,
+    workspace_id CHAR(36) NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    email VARCHAR(255) NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    role VARCHAR(16) NOT NULL,
+    invited_by CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (workspace_id, email)
+);
+CREATE INDEX IF NOT EXISTS idx_workspace_invitations_email ON workspace_invitations (email);
+
+-- notes table. Notes with a workspace_id belong to that workspace; the
+-- rest are private to user_id. size is the bytes of title and content
+-- counted against the owner's storage quota. word_count and char_count
+-- are the content's statistics, kept up to date on every write. Encrypted
+-- notes hold ciphertext the server cannot read, so their statistics stay 0. updated_at
+-- is set explicitly by the application.
+-- metadata is a JSON object of the client's own fields, NULL when empty.
+-- color and icon label the note in lists, NULL when unset.
+CREATE TABLE IF NOT EXISTS notes (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    workspace_id CHAR(36) NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    title VARCHAR(255) NOT NULL,
+    content TEXT,
+    pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    version INTEGER NOT NULL DEFAULT 1,
+    size INTEGER NOT NULL DEFAULT 0,
+    word_count INTEGER NOT NULL DEFAULT 0,
+    char_count INTEGER NOT NULL DEFAULT 0,
+    encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    metadata TEXT NULL,
+    color VARCHAR(16) NULL,
+    icon VARCHAR(16) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_notes_workspace ON notes (workspace_id);
+
+-- note collaborators table. role is viewer, commenter or editor and
+-- overrides the editor access workspace members otherwise have.
+CREATE TABLE IF NOT EXISTS note_collaborators (
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(16) NOT NULL DEFAULT 'editor',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (note_id, user_id)
+);
+
+-- note views table. Each user keeps one row per note they opened, holding
+-- the time of the latest view, for the recently viewed list.
+CREATE TABLE IF NOT EXISTS note_views (
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    viewed_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, note_id)
+);
+
+-- note favorites table
+CREATE TABLE IF NOT EXISTS note_favorites (
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, note_id)
+);
+
+-- note keys table. Each collaborator on an encrypted note holds its key
+-- wrapped with their public key; the server never sees it unwrapped.
+CREATE TABLE IF NOT EXISTS note_keys (
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    wrapped_key TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (note_id, user_id)
+);
+
+-- attachments table. status is where the file is in virus scanning:
+-- unscanned, pending, clean or quarantined.
+CREATE TABLE IF NOT EXISTS attachments (
+    id CHAR(36) PRIMARY KEY,
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key VARCHAR(512) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'unscanned',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_attachments_user ON attachments (user_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_storage_key ON attachments (storage_key);
+CREATE INDEX IF NOT EXISTS idx_attachments_status ON attachments (status);
+
+-- activities table. note_id has no foreign key so the history of a
+-- deleted note stays visible in its actors' feeds.
+CREATE TABLE IF NOT EXISTS activities (
+    id CHAR(36) PRIMARY KEY,
+    note_id CHAR(36) NOT NULL,
+    actor_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    action VARCHAR(32) NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_activities_note ON activities (note_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_activities_actor ON activities (actor_id, created_at);
+
+-- notifications table. emailed_at marks notifications already included in
+-- an email digest.
+CREATE TABLE IF NOT EXISTS notifications (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    payload JSONB,
+    read_at TIMESTAMP NULL,
+    emailed_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications (user_id, created_at);
+
+-- reminders table. recurrence is empty for one-off reminders, which are
+-- removed once they fire; recurring ones move due_at to the next occurrence.
+CREATE TABLE IF NOT EXISTS reminders (
+    id CHAR(36) PRIMARY KEY,
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    due_at TIMESTAMP NOT NULL,
+    recurrence VARCHAR(16) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_reminders_due ON reminders (due_at);
+CREATE INDEX IF NOT EXISTS idx_reminders_user ON reminders (user_id, due_at);
+
+-- api_keys table. Integrations authenticate with a key instead of a JWT;
+-- only its SHA-256 hash is stored. scopes is a comma-separated list.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    scopes VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys (user_id);
+
+-- sessions table. Every issued token carries its session's id as the jti
+-- claim; deleting the row logs that token out.
+CREATE TABLE IF NOT EXISTS sessions (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device VARCHAR(100) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions (user_id);
+
+-- audit log table. Security events such as logins, password changes and
+-- permission grants, with the client they came from. Rows are only ever
+-- inserted, and have no foreign keys so they outlive the accounts they
+-- mention.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NULL,
+    actor_id CHAR(36) NULL,
+    event VARCHAR(64) NOT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    details TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log (user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created ON audit_log (created_at);
+
+-- ip denylist table. Address ranges refused on every request, on top of
+-- IP_DENYLIST, and reloaded periodically so they apply without a restart.
+-- cidr is a range such as 203.0.113.0/24 or a single address.
+CREATE TABLE IF NOT EXISTS ip_denylist (
+    cidr VARCHAR(49) PRIMARY KEY,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- email changes table. A requested change of a user's email, waiting for
+-- the link mailed to the new address to be opened. The old address stays
+-- in use until then. Only the SHA-256 hash of the link's token is stored.
+CREATE TABLE IF NOT EXISTS email_changes (
+    user_id CHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- note locks table. A note being edited by a single user; everyone else
+-- can only read it until the lock is released or expires_at passes.
+CREATE TABLE IF NOT EXISTS note_locks (
+    note_id CHAR(36) PRIMARY KEY REFERENCES notes(id) ON DELETE CASCADE,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- note receipts table. The latest version of a note each user has read,
+-- so collaborators can see who has caught up with its latest changes.
+CREATE TABLE IF NOT EXISTS note_receipts (
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    read_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (note_id, user_id)
+);
+
+-- note revisions table. A copy of a note's title and content at each
+-- version, written by whoever produced it, for diffing between versions.
+CREATE TABLE IF NOT EXISTS note_revisions (
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    user_id CHAR(36) REFERENCES users(id) ON DELETE SET NULL,
+    title VARCHAR(255) NOT NULL,
+    content TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (note_id, version)
+);
+
+-- note suggestions table. What the content processors proposed for a
+-- note's content at version. position and length are in UTF-16 code units
+-- and replacements is a JSON array of strings.
+CREATE TABLE IF NOT EXISTS note_suggestions (
+    id CHAR(36) PRIMARY KEY,
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    processor VARCHAR(64) NOT NULL,
+    rule VARCHAR(128) NOT NULL,
+    message TEXT NOT NULL,
+    position INT NOT NULL,
+    length INT NOT NULL,
+    replacements TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_note_suggestions_note ON note_suggestions (note_id, position);
+
+-- tasks table. The task items ("- [ ]" and "- [x]" lines) of each note,
+-- kept in step with its content whenever it is saved. line counts from 1
+-- and due_date is a YYYY-MM-DD date, so dates compare as strings.
+CREATE TABLE IF NOT EXISTS tasks (
+    id CHAR(36) PRIMARY KEY,
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    line INT NOT NULL,
+    text TEXT NOT NULL,
+    done BOOLEAN NOT NULL DEFAULT FALSE,
+    due_date CHAR(10) NULL
+);
+CREATE INDEX IF NOT EXISTS idx_tasks_note ON tasks (note_id, line);
+
+-- user_preferences table. Users without a row have the defaults in
+-- models.DefaultPreferences. digest is off, daily or weekly and
+-- digest_sent_at is when the last activity digest was emailed.
+CREATE TABLE IF NOT EXISTS user_preferences (
+    user_id CHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    digest VARCHAR(16) NOT NULL DEFAULT 'off',
+    digest_sent_at TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_user_preferences_digest ON user_preferences (digest);
+
+-- imports table. Progress of importing an Evernote or Notion export;
+-- status is running, done or failed, with error saying why it failed.
+CREATE TABLE IF NOT EXISTS imports (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    format VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    total INT NOT NULL DEFAULT 0,
+    imported INT NOT NULL DEFAULT 0,
+    skipped INT NOT NULL DEFAULT 0,
+    attachments INT NOT NULL DEFAULT 0,
+    error TEXT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL
+);
+CREATE INDEX IF NOT EXISTS idx_imports_user ON imports (user_id);
+
+-- git_syncs table. Where a user's notes are pushed as Markdown. private_key
+-- is the deploy key that authenticates the pushes, known_hosts pins the
+-- remote's host key after the first push and fingerprint sums up the notes
+-- last pushed, so unchanged notes aren't pushed again.
+CREATE TABLE IF NOT EXISTS git_syncs (
+    user_id CHAR(36) PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    remote_url VARCHAR(512) NOT NULL,
+    branch VARCHAR(255) NOT NULL,
+    private_key TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    known_hosts TEXT NULL,
+    fingerprint VARCHAR(64) NULL,
+    last_synced_at TIMESTAMP NULL,
+    last_error TEXT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- backups table. Archives of the database in blob storage, newest kept and
+-- the rest pruned; status is running, done or failed, with error saying why
+-- it failed.
+CREATE TABLE IF NOT EXISTS backups (
+    id CHAR(36) PRIMARY KEY,
+    status VARCHAR(16) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    row_count INT NOT NULL DEFAULT 0,
+    encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    error TEXT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL
+);
+CREATE INDEX IF NOT EXISTS idx_backups_created ON backups (created_at);
+
+-- backup_restores table. Progress of restoring a backup archive; restored
+-- counts the rows put back and skipped those that were already there.
+-- backup_id is NULL when the archive was given by storage key, and is kept
+-- after the backup is pruned.
+CREATE TABLE IF NOT EXISTS backup_restores (
+    id CHAR(36) PRIMARY KEY,
+    backup_id CHAR(36) NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    restored INT NOT NULL DEFAULT 0,
+    skipped INT NOT NULL DEFAULT 0,
+    error TEXT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL
+);
+
+-- workspace_retention table. A workspace's retention rules, each NULL when
+-- off: archive notes unedited for archive_after_days, delete archived notes
+-- unedited for purge_archived_after_days and keep max_revisions revisions
+-- of each note.
+CREATE TABLE IF NOT EXISTS workspace_retention (
+    workspace_id CHAR(36) PRIMARY KEY REFERENCES workspaces(id) ON DELETE CASCADE,
+    archive_after_days INT NULL,
+    purge_archived_after_days INT NULL,
+    max_revisions INT NULL,
+    last_enforced_at TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- data_exports table. Archives of everything kept about a user, built on
+-- request; status is running, done or failed. Done archives are deleted
+-- from storage_key after expires_at.
+CREATE TABLE IF NOT EXISTS data_exports (
+    id CHAR(36) PRIMARY KEY,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    records INT NOT NULL DEFAULT 0,
+    size BIGINT NOT NULL DEFAULT 0,
+    error TEXT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL,
+    expires_at TIMESTAMP NULL
+);
+CREATE INDEX IF NOT EXISTS idx_data_exports_user ON data_exports (user_id);
+CREATE INDEX IF NOT EXISTS idx_data_exports_expires ON data_exports (expires_at);
+
+-- feature_flags table. Switches for gradually rolling out features: on for
+-- everyone when enabled, otherwise for rollout percent of users.
+CREATE TABLE IF NOT EXISTS feature_flags (
+    name VARCHAR(64) PRIMARY KEY,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    rollout INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- feature_flag_overrides table. Turns a flag on or off for one user or
+-- workspace regardless of its rollout; scope is user or workspace.
+CREATE TABLE IF NOT EXISTS feature_flag_overrides (
+    flag VARCHAR(64) NOT NULL REFERENCES feature_flags(name) ON DELETE CASCADE,
+    scope VARCHAR(16) NOT NULL,
+    target_id CHAR(36) NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (flag, scope, target_id)
+);
+
+-- maintenance table. A single row, id 1, saying whether the server is in
+-- maintenance mode, during which it refuses writes.
+CREATE TABLE IF NOT EXISTS maintenance (
+    id INT PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    message VARCHAR(255) NOT NULL DEFAULT '',
+    ends_at TIMESTAMP NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- note revision chunks table. Runs of a note's older revisions archived to
+-- blob storage at storage_key, whose note_revisions rows keep a NULL
+-- content. There is no foreign key to notes, so the compactor can still
+-- find and delete the blobs of chunks whose note was deleted.
+CREATE TABLE IF NOT EXISTS note_revision_chunks (
+    note_id CHAR(36) NOT NULL,
+    first_version INT NOT NULL,
+    last_version INT NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (note_id, first_version)
+);
+
+-- workspace_recording table. Workspaces with enabled set record the edits
+-- made over the realtime socket so their collaboration sessions can be
+-- replayed.
+CREATE TABLE IF NOT EXISTS workspace_recording (
+    workspace_id CHAR(36) PRIMARY KEY REFERENCES workspaces(id) ON DELETE CASCADE,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- note_sessions table. Recorded collaboration sessions, each a note's room
+-- from its first connection opening to its last closing. ended_at stays
+-- NULL while the room is open, or if the server stopped before it closed.
+CREATE TABLE IF NOT EXISTS note_sessions (
+    id CHAR(36) PRIMARY KEY,
+    note_id CHAR(36) NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP NULL
+);
+CREATE INDEX IF NOT EXISTS idx_note_sessions_note ON note_sessions (note_id, started_at);
+
+-- note_session_ops table. The edits of a recorded session in order:
+-- offset_ms is when each was made, in milliseconds since the session
+-- started, and edit the EditMessage broadcast for it.
+CREATE TABLE IF NOT EXISTS note_session_ops (
+    session_id CHAR(36) NOT NULL REFERENCES note_sessions(id) ON DELETE CASCADE,
+    seq INT NOT NULL,
+    offset_ms BIGINT NOT NULL,
+    user_id CHAR(36) NULL REFERENCES users(id) ON DELETE SET NULL,
+    revision BIGINT NOT NULL,
+    edit TEXT NOT NULL,
+    PRIMARY KEY (session_id, seq)
+);
+
+-- note_crdt table. Each note's CRDT state for offline merging: every
+-- operation applied to it in order, as JSON, with the note version its
+-- text matches. revision counts the writes of the state.
+CREATE TABLE IF NOT EXISTS note_crdt (
+    note_id CHAR(36) PRIMARY KEY REFERENCES notes(id) ON DELETE CASCADE,
+    state TEXT NOT NULL,
+    version BIGINT NOT NULL,
+    revision BIGINT NOT NULL DEFAULT 1,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- note_changes table. Append-only log of notes created, updated and
+-- deleted, with the version each was left at, which clients sync from.
+-- Entries outlive their notes; user_id is the note's author.
+CREATE TABLE IF NOT EXISTS note_changes (
+    id BIGSERIAL PRIMARY KEY,
+    note_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NULL REFERENCES users(id) ON DELETE SET NULL,
+    workspace_id CHAR(36) NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+    op VARCHAR(16) NOT NULL,
+    version BIGINT NOT NULL,
+    changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_note_changes_user ON note_changes (user_id, id);
+CREATE INDEX IF NOT EXISTS idx_note_changes_workspace ON note_changes (workspace_id, id);
+CREATE INDEX IF NOT EXISTS idx_note_changes_changed ON note_changes (changed_at);
+
+-- attachment_blobs table. Attachments with the same content share one
+-- stored blob, found by the SHA-256 hash of the content and referred to
+-- by the attachments whose storage_key is its. used_at is when an upload
+-- last took it; unreferenced blobs are collected some time after that.
+CREATE TABLE IF NOT EXISTS attachment_blobs (
+    hash CHAR(64) PRIMARY KEY,
+    storage_key VARCHAR(512) NOT NULL UNIQUE,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_attachment_blobs_used ON attachment_blobs (used_at);
+
+-- note_pdfs table. PDFs of large notes rendered in the background; status
+-- is running, done or failed. note_id has no foreign key so the file of a
+-- deleted note is still removed from storage_key after expires_at.
+CREATE TABLE IF NOT EXISTS note_pdfs (
+    id CHAR(36) PRIMARY KEY,
+    note_id CHAR(36) NOT NULL,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    error TEXT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL,
+    expires_at TIMESTAMP NULL
+);
+CREATE INDEX IF NOT EXISTS idx_note_pdfs_user ON note_pdfs (user_id, note_id);
+CREATE INDEX IF NOT EXISTS idx_note_pdfs_expires ON note_pdfs (expires_at);
+
+-- idempotency_keys table. The response to a request sent with an
+-- Idempotency-Key header, replayed when the request is retried until
+-- expires_at. status is NULL while the first request is still running,
+-- and expires_at is then the end of its lease on the key.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status INTEGER NULL,
+    content_type VARCHAR(255) NULL,
+    response TEXT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, idempotency_key)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys (expires_at);
+
+-- user_identities table. Links an account to the subject an OpenID Connect
+-- provider knows it by, so single sign-on finds the account even after
+-- the email address changes on either side.
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id CHAR(36) NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (issuer, subject)
+);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user ON user_identities (user_id);
+
+-- oidc_logins table. A single sign-on in progress, from the redirect to the
+-- identity provider until it sends the user back with the state whose hash
+-- is stored here, or expires_at passes.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state_hash CHAR(64) PRIMARY KEY,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    redirect_to VARCHAR(2048) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_oidc_logins_expires ON oidc_logins (expires_at);
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+
+	// Register the pure-Go SQLite driver
+	_ "modernc.org/sqlite"
+)
+
+// sqliteParams enforces foreign keys and stores times in a format that
+// compares correctly against CURRENT_TIMESTAMP
+const sqliteParams = "_pragma=foreign_keys(1)&_time_format=sqlite"
+
+// OpenSQLite opens the SQLite database file at path, creating it and its
+// schema if needed. WAL mode and a busy timeout let concurrent requests
+// read while another writes.
+func OpenSQLite(ctx context.Context, path string, pool PoolConfig) (*sql.DB, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create database directory: %w", err)
+		}
+	}
+
+	dsn := fmt.Sprintf("file:%s?%s&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)", path, sqliteParams)
+	db, err := open("sqlite", dsn, SQLite)
+	if err != nil {
+		return nil, fmt.Errorf("open DB: %w", err)
+	}
+
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
+	if err := ApplySchema(ctx, db, SQLite); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	log.Printf("Opened SQLite database at %s 🎉", path)
+	return db, nil
+}
+
+// OpenMemory opens a fresh in-memory SQLite database and creates the schema.
+// Each call gets its own database, which lives until the pool is closed.
+func OpenMemory(ctx context.Context) (*sql.DB, error) {
+	// A named shared-cache database lets every pooled connection see the
+	// same data; keeping one connection around keeps the database alive
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared&%s", uuid.New().String(), sqliteParams)
+	db, err := open("sqlite", dsn, SQLite)
+	if err != nil {
+		return nil, fmt.Errorf("open DB: %w", err)
+	}
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	if err := ApplySchema(ctx, db, SQLite); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
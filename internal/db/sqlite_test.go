@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenMemory(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory(ctx)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	_, err = db.ExecContext(ctx, "INSERT INTO users (id, email, password) VALUES (?, ?, ?)", "user1", "a@example.com", "hash")
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO notes (id, user_id, title, content) VALUES (?, ?, ?, ?)", "note1", "user1", "Title", "Body")
+	require.NoError(t, err)
+
+	var createdAt time.Time
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT created_at FROM notes WHERE id = ?", "note1").Scan(&createdAt))
+	assert.WithinDuration(t, time.Now(), createdAt, time.Minute)
+
+	var exists bool
+	require.NoError(t, db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND user_id = ?) OR EXISTS(SELECT 1 FROM note_collaborators WHERE note_id = ? AND user_id = ?)",
+		"note1", "user1", "note1", "user1",
+	).Scan(&exists))
+	assert.True(t, exists)
+
+	// Foreign keys are enforced, so deleting the user removes their notes
+	_, err = db.ExecContext(ctx, "DELETE FROM users WHERE id = ?", "user1")
+	require.NoError(t, err)
+	var notes int
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM notes").Scan(&notes))
+	assert.Zero(t, notes)
+
+	// Every call gets its own database
+	other, err := OpenMemory(ctx)
+	require.NoError(t, err)
+	defer func() { _ = other.Close() }()
+	_, err = other.ExecContext(ctx, "INSERT INTO users (id, email, password) VALUES (?, ?, ?)", "user1", "a@example.com", "hash")
+	assert.NoError(t, err)
+}
+
+func TestSQLiteJSON(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory(ctx)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	_, err = db.ExecContext(ctx, "INSERT INTO users (id, email, password) VALUES (?, ?, ?)", "user1", "a@example.com", "hash")
+	require.NoError(t, err)
+	for id, metadata := range map[string]any{
+		"list":   `{"notebook":"Handbook","tags":["hr","process"]}`,
+		"single": `{"notebook":"Runbooks","tags":"hr"}`,
+		"none":   nil,
+	} {
+		_, err = db.ExecContext(ctx, "INSERT INTO notes (id, user_id, title, content, metadata) VALUES (?, ?, ?, ?, ?)", id, "user1", "Title", "Body", metadata)
+		require.NoError(t, err)
+	}
+
+	matching := func(where string, arg any) []string {
+		rows, err := db.QueryContext(ctx, "SELECT id FROM notes WHERE "+where+" ORDER BY id", arg)
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+		ids := []string{}
+		for rows.Next() {
+			var id string
+			require.NoError(t, rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		require.NoError(t, rows.Err())
+		return ids
+	}
+	assert.Equal(t, []string{"list"}, matching(SQLite.JSONText("metadata", "notebook")+" = ?", "Handbook"))
+	assert.Equal(t, []string{"list", "single"}, matching(SQLite.JSONHolds("metadata", "tags"), "hr"))
+	assert.Equal(t, []string{"list"}, matching(SQLite.JSONHolds("metadata", "tags"), "process"))
+}
+
+func TestOpenSQLite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nested", "quanta.db")
+	pool := PoolConfig{MaxOpenConns: 4, MaxIdleConns: 4}
+
+	db, err := OpenSQLite(ctx, path, pool)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO users (id, email, password) VALUES (?, ?, ?)", "user1", "a@example.com", "hash")
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	// Reopening keeps the data and tolerates the existing schema
+	db, err = OpenSQLite(ctx, path, pool)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	var email string
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?", "user1").Scan(&email))
+	assert.Equal(t, "a@example.com", email)
+
+	// Times written by the application compare correctly with CURRENT_TIMESTAMP
+	_, err = db.ExecContext(ctx, "UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?", "user1")
+	require.NoError(t, err)
+	var purgeable int
+	require.NoError(t, db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM users WHERE deleted_at < ?", time.Now().UTC().Add(time.Hour),
+	).Scan(&purgeable))
+	assert.Equal(t, 1, purgeable)
+}
+
+func TestMissingTables(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory(ctx)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	missing, err := MissingTables(ctx, db, SQLite)
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+
+	_, err = db.ExecContext(ctx, "DROP TABLE sessions")
+	require.NoError(t, err)
+	missing, err = MissingTables(ctx, db, SQLite)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sessions"}, missing)
+}
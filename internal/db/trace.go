@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("quanta/internal/db")
+
+// open opens a pool on a registered driver with every statement traced as
+// a child span of the context it runs under
+func open(driverName, dsn string, dialect Dialect) (*sql.DB, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	base := db.Driver()
+	_ = db.Close()
+
+	return sql.OpenDB(&tracedConnector{
+		dsn:    dsn,
+		driver: tracedDriver{Driver: base, system: dialect.systemName()},
+	}), nil
+}
+
+// systemName returns the semantic convention name of the dialect's database
+func (d Dialect) systemName() attribute.KeyValue {
+	switch d {
+	case Postgres:
+		return semconv.DBSystemNamePostgreSQL
+	case SQLite:
+		return semconv.DBSystemNameSQLite
+	default:
+		return semconv.DBSystemNameMySQL
+	}
+}
+
+// record adds a finished statement to the trace. Spans are created after
+// the fact so that statements the driver skips, leaving database/sql to
+// prepare them instead, aren't reported twice.
+func record(ctx context.Context, system attribute.KeyValue, query string, start time.Time, err error) {
+	operation, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+	operation = strings.ToUpper(operation)
+
+	_, span := tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(system, semconv.DBOperationName(operation), semconv.DBQueryText(query)),
+	)
+	if err != nil && !errors.Is(err, driver.ErrBadConn) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type tracedConnector struct {
+	dsn    string
+	driver tracedDriver
+}
+
+func (c *tracedConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *tracedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type tracedDriver struct {
+	driver.Driver
+	system attribute.KeyValue
+}
+
+func (d tracedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, system: d.system}, nil
+}
+
+// tracedConn forwards to the driver's connection, recording a span for each
+// statement. Optional interfaces the driver lacks fall back to what
+// database/sql would do without them.
+type tracedConn struct {
+	driver.Conn
+	system attribute.KeyValue
+}
+
+func (c *tracedConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, err := c.Conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	preparer, ok := c.Conn.(driver.ConnPrepareContext)
+	if !ok {
+		return c.Prepare(query)
+	}
+	stmt, err := preparer.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if !errors.Is(err, driver.ErrSkip) {
+		record(ctx, c.system, query, start, err)
+	}
+	return result, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if !errors.Is(err, driver.ErrSkip) {
+		record(ctx, c.system, query, start, err)
+	}
+	return rows, err
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	if opts.ReadOnly || opts.Isolation != 0 {
+		return nil, errors.New("db: driver does not support transaction options")
+	}
+	return c.Conn.Begin() //nolint:staticcheck // fallback for drivers without BeginTx
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *tracedConn) CheckNamedValue(v *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+// tracedStmt records a span each time a prepared statement runs
+type tracedStmt struct {
+	driver.Stmt
+	conn  *tracedConn
+	query string
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var result driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else if values, convErr := namedValues(args); convErr != nil {
+		return nil, convErr
+	} else {
+		result, err = s.Stmt.Exec(values) //nolint:staticcheck // fallback for drivers without ExecContext
+	}
+	record(ctx, s.conn.system, s.query, start, err)
+	return result, err
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else if values, convErr := namedValues(args); convErr != nil {
+		return nil, convErr
+	} else {
+		rows, err = s.Stmt.Query(values) //nolint:staticcheck // fallback for drivers without QueryContext
+	}
+	record(ctx, s.conn.system, s.query, start, err)
+	return rows, err
+}
+
+// CheckNamedValue prefers the statement's checker over the connection's,
+// as database/sql does
+func (s *tracedStmt) CheckNamedValue(v *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(v)
+	}
+	return s.conn.CheckNamedValue(v)
+}
+
+// namedValues converts arguments for drivers that predate named ones
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("db: driver does not support named arguments")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
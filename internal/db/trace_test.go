@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+func TestTracedStatements(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db, err := OpenMemory(context.Background())
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	_, err = db.ExecContext(ctx, "INSERT INTO users (id, email, password) VALUES (?, ?, ?)", "user1", "a@example.com", "hash")
+	require.NoError(t, err)
+	var email string
+	require.NoError(t, db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?", "user1").Scan(&email))
+	_, err = db.ExecContext(ctx, "INSERT INTO missing (id) VALUES (?)", "x")
+	require.Error(t, err)
+
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", "user1")
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	parent.End()
+
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() == parent.SpanContext().SpanID() {
+			spans = append(spans, span)
+		}
+	}
+	if !assert.Len(t, spans, 4) {
+		return
+	}
+
+	assert.Equal(t, "INSERT", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), semconv.DBSystemNameSQLite)
+	assert.Contains(t, spans[0].Attributes(), semconv.DBQueryText("INSERT INTO users (id, email, password) VALUES (?, ?, ?)"))
+	assert.Equal(t, "SELECT", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+	assert.Equal(t, "DELETE", spans[3].Name())
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Duplicate key error codes for each supported database
+const (
+	mysqlDuplicateEntry     = 1062
+	postgresUniqueViolation = "23505"
+	sqliteConstraintUnique  = 2067
+	sqliteConstraintPrimary = 1555
+)
+
+// TxBeginner is the part of *sql.DB that starts transactions. Handlers'
+// database interfaces include it for flows that write several statements.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// InTx runs fn in a transaction, committing if it succeeds and rolling
+// back if it returns an error or panics. Errors from fn are returned as
+// they are, so handlers can still answer with them.
+func InTx(ctx context.Context, conn TxBeginner, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			log.Println("Error rolling back transaction:", err)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// IsDuplicate reports whether err is a unique or primary key violation,
+// meaning a concurrent request inserted the same row first
+func IsDuplicate(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == mysqlDuplicateEntry
+	}
+
+	// The pgx and SQLite errors are matched by their methods so the
+	// drivers' packages aren't needed here
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState() == postgresUniqueViolation
+	}
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code()
+		return code == sqliteConstraintUnique || code == sqliteConstraintPrimary
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInTx(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenMemory(ctx)
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+
+	insert := func(tx *sql.Tx, id, email string) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO users (id, email, password) VALUES (?, ?, ?)", id, email, "hash")
+		return err
+	}
+	count := func() int {
+		var n int
+		require.NoError(t, db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&n))
+		return n
+	}
+
+	require.NoError(t, InTx(ctx, db, func(tx *sql.Tx) error {
+		return insert(tx, "user1", "a@example.com")
+	}))
+	assert.Equal(t, 1, count())
+
+	// A failed statement rolls back the ones before it
+	err = InTx(ctx, db, func(tx *sql.Tx) error {
+		if err := insert(tx, "user2", "b@example.com"); err != nil {
+			return err
+		}
+		return insert(tx, "user3", "a@example.com")
+	})
+	assert.True(t, IsDuplicate(err))
+	assert.Equal(t, 1, count())
+
+	errFailed := errors.New("failed")
+	err = InTx(ctx, db, func(tx *sql.Tx) error {
+		if err := insert(tx, "user2", "b@example.com"); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	assert.ErrorIs(t, err, errFailed)
+	assert.Equal(t, 1, count())
+}
+
+func TestIsDuplicate(t *testing.T) {
+	assert.True(t, IsDuplicate(&mysql.MySQLError{Number: 1062}))
+	assert.False(t, IsDuplicate(&mysql.MySQLError{Number: 1213}))
+	assert.False(t, IsDuplicate(sql.ErrNoRows))
+	assert.False(t, IsDuplicate(nil))
+}
@@ -0,0 +1,107 @@
+package docs
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaOf(t *testing.T) {
+	type example struct {
+		ID       string            `json:"id"`
+		Secret   string            `json:"-"`
+		Name     *string           `json:"name"`
+		Tags     []string          `json:"tags,omitempty"`
+		Meta     map[string]string `json:"meta,omitempty"`
+		Count    int               `json:"count"`
+		Created  time.Time         `json:"created_at"`
+		internal bool
+	}
+
+	s := schemaOf(example{})
+	assert.Equal(t, "object", s.Type)
+	assert.NotContains(t, s.Properties, "-")
+	assert.NotContains(t, s.Properties, "Secret")
+	assert.NotContains(t, s.Properties, "internal")
+	assert.True(t, s.Properties["name"].Nullable)
+	assert.Equal(t, "array", s.Properties["tags"].Type)
+	assert.Equal(t, "string", s.Properties["meta"].AdditionalProperties.Type)
+	assert.Equal(t, "integer", s.Properties["count"].Type)
+	assert.Equal(t, "date-time", s.Properties["created_at"].Format)
+	assert.ElementsMatch(t, []string{"id", "count", "created_at"}, s.Required)
+}
+
+func TestSchemaOf_Embedded(t *testing.T) {
+	type Base struct {
+		ID string `json:"id"`
+	}
+	type example struct {
+		Base
+		Seen time.Time `json:"seen_at"`
+	}
+
+	s := schemaOf(example{})
+	assert.NotContains(t, s.Properties, "Base")
+	assert.Contains(t, s.Properties, "id")
+	assert.ElementsMatch(t, []string{"id", "seen_at"}, s.Required)
+}
+
+func TestBuild_ReferencesResolve(t *testing.T) {
+	doc := Build()
+	raw, err := json.Marshal(doc)
+	assert.NoError(t, err)
+
+	// Every $ref must point at a registered component schema
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/schemas/")
+				assert.Contains(t, doc.Components.Schemas, name, "dangling reference %s", ref)
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	var generic any
+	assert.NoError(t, json.Unmarshal(raw, &generic))
+	walk(generic)
+
+	assert.Contains(t, doc.Paths, "/notes/{id}")
+	assert.Contains(t, doc.Paths, "/ws/notes/{id}")
+	assert.Contains(t, doc.Components.Schemas["User"].Properties, "email")
+	assert.NotContains(t, doc.Components.Schemas["User"].Properties, "password")
+}
+
+func TestHandler(t *testing.T) {
+	h, err := NewHandler()
+	assert.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/openapi.json", h.Spec)
+	app.Get("/docs", h.UI)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var doc Document
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/docs", nil))
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "/openapi.json")
+}
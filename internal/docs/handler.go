@@ -0,0 +1,56 @@
+package docs
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// swaggerUI renders the spec with Swagger UI loaded from a CDN
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Quanta API docs</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = () => { window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" }) }
+  </script>
+</body>
+</html>`
+
+// uiPolicy lets the Swagger UI page load its assets from the CDN
+const uiPolicy = "default-src 'none'; script-src 'unsafe-inline' https://unpkg.com; " +
+	"style-src https://unpkg.com; img-src 'self' data:; connect-src 'self'"
+
+// Handler serves the OpenAPI document and its UI
+type Handler struct {
+	spec []byte
+}
+
+// NewHandler builds the OpenAPI document once so every request serves the
+// same bytes
+func NewHandler() (*Handler, error) {
+	spec, err := json.Marshal(Build())
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{spec: spec}, nil
+}
+
+// Spec serves the OpenAPI document
+func (h *Handler) Spec(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(h.spec)
+}
+
+// UI serves Swagger UI pointed at the OpenAPI document
+func (h *Handler) UI(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderContentSecurityPolicy, uiPolicy)
+	return c.SendString(swaggerUI)
+}
@@ -0,0 +1,1968 @@
+package docs
+
+import (
+	"fmt"
+	"strings"
+
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/backup"
+	"quanta/internal/calendar"
+	"quanta/internal/changelog"
+	"quanta/internal/features"
+	"quanta/internal/gitsync"
+	"quanta/internal/handlers/account"
+	"quanta/internal/handlers/admin"
+	"quanta/internal/handlers/attachments"
+	"quanta/internal/handlers/auth"
+	"quanta/internal/handlers/health"
+	"quanta/internal/handlers/integrations"
+	"quanta/internal/handlers/notes"
+	"quanta/internal/handlers/workspaces"
+	"quanta/internal/imports"
+	"quanta/internal/maintenance"
+	"quanta/internal/models"
+	"quanta/internal/notifications"
+	"quanta/internal/printing"
+	"quanta/internal/privacy"
+	"quanta/internal/quota"
+	"quanta/internal/realtime"
+	"quanta/internal/recordings"
+	"quanta/internal/reminders"
+	"quanta/internal/retention"
+	"quanta/pkg"
+)
+
+// bearer marks an operation as requiring the Authorization header
+var bearer = []map[string][]string{{"bearerAuth": {}}}
+
+// bearerOrAPIKey marks an operation integrations may also call with an API key
+var bearerOrAPIKey = []map[string][]string{{"bearerAuth": {}}, {"apiKeyAuth": {}}}
+
+// Build returns the OpenAPI document for the API. Add an entry here
+// whenever a route is added in cmd/main.go.
+func Build() Document {
+	b := &builder{
+		doc: Document{
+			OpenAPI: "3.0.3",
+			Info: Info{
+				Title:   "Quanta collaborative notes API",
+				Version: "1.0.0",
+				Description: "Authenticate with POST /signup or POST /login and send the returned token as " +
+					"`Authorization: Bearer <token>`. Realtime collaboration happens over the /ws routes. " +
+					"Integrations can call the /notes routes with an API key from POST /me/api-keys instead, sent as " +
+					"X-API-Key or as the bearer token; reads need the notes:read scope and writes notes:write. " +
+					"Every error response is an Error object whose request_id matches the X-Request-ID header. Its message " +
+					"is in the language Accept-Language asks for (en, es, fr or de, falling back to en) and its error_code " +
+					"names the error, such as note_not_found, for clients that show messages of their own. Per-field " +
+					"problems under errors are not translated. During maintenance, see GET /maintenance, every write " +
+					"answers 503 with a Retry-After header while reads keep working. Authenticated requests count against " +
+					"a per-user rate limit, API key requests included: every response to one carries X-RateLimit-Limit, " +
+					"X-RateLimit-Remaining and X-RateLimit-Reset, the Unix time the window ends, and once the limit is " +
+					"spent requests answer 429 with a Retry-After header. GET /me/limits reports it along with storage quotas.",
+			},
+			Paths: map[string]PathItem{},
+			Components: Components{
+				Schemas: map[string]*Schema{},
+				SecuritySchemes: map[string]SecurityScheme{
+					"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+					"apiKeyAuth": {Type: "apiKey", In: "header", Name: "X-API-Key"},
+				},
+			},
+		},
+	}
+
+	token := b.schema("Token", struct {
+		Token string `json:"token"`
+	}{})
+	apiError := b.schema("Error", apperr.Response{})
+	noteID := pathParam("id", "Note ID")
+
+	b.add("post", "/signup", &Operation{
+		Summary: "Create an account",
+		Description: "Pass the token from a workspace invitation link as invite_token to join that workspace " +
+			"in the same step; its ID is returned as workspace_id. No account is created if the invitation " +
+			"cannot be accepted.",
+		Tags:        []string{"auth"},
+		RequestBody: jsonBody(b.schema("Registration", auth.Registration{})),
+		Responses: responses(
+			jsonResponse("200", "Account created, or the existing account when the password matches", b.schema("SignUpResult", struct {
+				Token       string `json:"token"`
+				WorkspaceID string `json:"workspace_id,omitempty"`
+			}{})),
+			jsonResponse("403", "The invitation was sent to a different email address", apiError),
+			jsonResponse("404", "Unknown invitation", apiError),
+			jsonResponse("403", "Password signup is disabled because single sign-on is enforced", apiError),
+			jsonResponse("409", "Email already in use with a different password", apiError),
+			jsonResponse("410", "The invitation has expired", apiError),
+			jsonResponse("422", "Invalid email or password too short", apiError),
+		),
+	})
+	b.add("post", "/login", &Operation{
+		Summary:     "Log in",
+		Tags:        []string{"auth"},
+		RequestBody: jsonBody(b.schema("Credentials", auth.Credentials{})),
+		Responses: responses(
+			jsonResponse("200", "Logged in", token),
+			jsonResponse("401", "Invalid credentials", apiError),
+			jsonResponse("403", "Password login is disabled because single sign-on is enforced", apiError),
+			jsonResponse("422", "Email or password missing", apiError),
+			jsonResponse("423", "Account locked after repeated failures; see Retry-After or retry_after", apiError),
+		),
+	})
+	b.add("get", "/auth/oidc/login", &Operation{
+		Summary: "Sign in with single sign-on",
+		Description: "Redirects the browser to the configured OpenID Connect identity provider, such as Okta, " +
+			"Azure AD or Keycloak. Once the user signs in there, GET /auth/oidc/callback sends them on to the web " +
+			"app at redirect_to with a token in the URL fragment, as #token=<token>.",
+		Tags: []string{"auth"},
+		Parameters: []Parameter{
+			{Name: "redirect_to", In: "query", Description: "Path in the web app to land on afterwards, / by default", Schema: &Schema{Type: "string"}},
+		},
+		Responses: responses(
+			empty("302", "Redirect to the identity provider"),
+			jsonResponse("400", "redirect_to is not a path in the web app", apiError),
+			jsonResponse("501", "Single sign-on is not enabled on this server", apiError),
+		),
+	})
+	b.add("get", "/auth/oidc/callback", &Operation{
+		Summary: "Complete single sign-on",
+		Description: "Where the identity provider sends the browser back. The first sign-in of an identity links " +
+			"it to the account with the same verified email or, when just-in-time provisioning is on, creates " +
+			"one. Members of the provider groups mapped to workspaces join them.",
+		Tags: []string{"auth"},
+		Parameters: []Parameter{
+			{Name: "code", In: "query", Description: "Authorization code from the identity provider", Schema: &Schema{Type: "string"}},
+			{Name: "state", In: "query", Required: true, Description: "State from GET /auth/oidc/login", Schema: &Schema{Type: "string"}},
+		},
+		Responses: responses(
+			empty("302", "Redirect to the web app with the token in the URL fragment"),
+			jsonResponse("400", "Unknown or expired sign-in, or one started in another browser", apiError),
+			jsonResponse("401", "The identity provider refused the sign-in or sent an invalid token", apiError),
+			jsonResponse("403", "No verified email, or no account and provisioning is off", apiError),
+			jsonResponse("409", "The email belongs to a deleted account", apiError),
+			jsonResponse("501", "Single sign-on is not enabled on this server", apiError),
+		),
+	})
+	b.add("get", "/.well-known/jwks.json", &Operation{
+		Summary: "Token verification keys",
+		Description: "The public keys of the RSA (RS256) and Ed25519 (EdDSA) keys tokens are signed with, as a " +
+			"JSON Web Key Set. Match a token's kid header to a key to verify it without the HMAC secret. " +
+			"Empty when only HMAC keys are configured.",
+		Tags:      []string{"auth"},
+		Responses: responses(jsonResponse("200", "Key set", b.schema("JWKS", pkg.JWKS{}))),
+	})
+
+	user := b.schema("User", models.User{})
+	b.add("get", "/me", &Operation{
+		Summary:   "Get your profile",
+		Tags:      []string{"account"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Profile", user)),
+	})
+	b.add("patch", "/me", &Operation{
+		Summary:     "Update your profile",
+		Description: "Only the fields present are changed. An empty avatar_url clears the avatar, and an empty public_key withdraws the key others use to share encrypted notes with you.",
+		Tags:        []string{"account"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("ProfileUpdate", account.ProfileUpdate{})),
+		Responses: responses(
+			jsonResponse("200", "Updated profile", user),
+			jsonResponse("400", "Invalid field", apiError),
+		),
+	})
+	b.add("delete", "/me", &Operation{
+		Summary: "Delete your account",
+		Description: "Soft-deletes the account, revokes every token and closes realtime sessions. The email is released " +
+			"straight away so it can sign up again; the rest of the data is purged after a grace period. As with " +
+			"POST /me/erasure, workspaces you own pass to another member, and notes you wrote in workspaces stay there, " +
+			"owned by the workspace's owner.",
+		Tags:        []string{"account"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("DeleteRequest", account.DeleteRequest{})),
+		Responses: responses(
+			empty("204", "Account deleted"),
+			jsonResponse("401", "Password is incorrect", apiError),
+		),
+	})
+	b.add("post", "/me/erasure", &Operation{
+		Summary: "Erase your account",
+		Description: "Permanently removes the account at once, with no grace period. Notes you wrote in workspaces " +
+			"pass to each workspace's owner, and workspaces you owned to an admin or the longest-standing member; " +
+			"those with no other members are deleted. Revisions you wrote lose their author and security events " +
+			"lose your ID and addresses. Everything else, including your uploads, is deleted.",
+		Tags:        []string{"account"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("ErasureRequest", privacy.ErasureRequest{})),
+		Responses: responses(
+			jsonResponse("200", "Account erased", b.schema("Erasure", privacy.Erasure{})),
+			jsonResponse("401", "Password is incorrect", apiError),
+		),
+	})
+	dataExport := b.schema("DataExport", privacy.Export{})
+	b.add("post", "/me/data-export", &Operation{
+		Summary: "Export your data",
+		Description: "Builds a ZIP of everything kept about you in the background: your profile, notes, revisions, " +
+			"tasks, reminders, uploads, activity, sessions and security events, as JSON files with the uploaded " +
+			"files alongside. Poll the export until it is done, then fetch its download_url.",
+		Tags:     []string{"account"},
+		Security: bearer,
+		Responses: responses(
+			jsonResponse("202", "Export started", dataExport),
+			jsonResponse("409", "An export is already running", apiError),
+		),
+	})
+	b.add("get", "/me/data-export/{id}", &Operation{
+		Summary: "Get a data export's progress",
+		Description: "status is running until the archive is built, then done with download_url and expires_at set, " +
+			"or failed with error set.",
+		Tags:       []string{"account"},
+		Security:   bearer,
+		Parameters: []Parameter{pathParam("id", "Export ID")},
+		Responses: responses(
+			jsonResponse("200", "The export", dataExport),
+			jsonResponse("404", "Export not found", apiError),
+		),
+	})
+	b.add("get", "/me/data-export/{id}/download", &Operation{
+		Summary:     "Download a data export",
+		Description: "The archive can be downloaded until expires_at, a week after it was built.",
+		Tags:        []string{"account"},
+		Security:    bearer,
+		Parameters:  []Parameter{pathParam("id", "Export ID")},
+		Responses: responses(
+			binaryResponse("200", "ZIP archive"),
+			jsonResponse("404", "Export not found", apiError),
+			jsonResponse("409", "Export is not ready", apiError),
+			jsonResponse("410", "Export has expired", apiError),
+		),
+	})
+	b.add("get", "/me/features", &Operation{
+		Summary:     "Your feature flags",
+		Description: "Whether each feature flag is on for you, as an object of flag names to booleans.",
+		Tags:        []string{"account"},
+		Security:    bearer,
+		Parameters: []Parameter{
+			{Name: "workspace_id", In: "query", Description: "Apply the overrides of a workspace you belong to", Schema: &Schema{Type: "string"}},
+		},
+		Responses: responses(
+			jsonResponse("200", "Flag states", &Schema{Type: "object", AdditionalProperties: &Schema{Type: "boolean"}}),
+			jsonResponse("404", "Workspace not found", apiError),
+		),
+	})
+	preferences := b.schema("Preferences", models.Preferences{})
+	b.add("get", "/me/preferences", &Operation{
+		Summary:   "Get your preferences",
+		Tags:      []string{"account"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Preferences", preferences)),
+	})
+	b.add("patch", "/me/preferences", &Operation{
+		Summary: "Update your preferences",
+		Description: "Only the fields present are changed. digest is how often you are emailed a summary of the notes " +
+			"your collaborators edited and shared with you: off, daily or weekly.",
+		Tags:        []string{"account"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("PreferencesUpdate", account.PreferencesUpdate{})),
+		Responses: responses(
+			jsonResponse("200", "Updated preferences", preferences),
+			jsonResponse("400", "No fields to update", apiError),
+			jsonResponse("422", "digest is not off, daily or weekly", apiError),
+		),
+	})
+	gitSync := b.schema("GitSync", gitsync.Settings{})
+	b.add("get", "/me/git-sync", &Operation{
+		Summary:  "Get your Git sync",
+		Tags:     []string{"account"},
+		Security: bearer,
+		Responses: responses(
+			jsonResponse("200", "Git sync settings and the outcome of the last sync", gitSync),
+			jsonResponse("404", "Git sync is not set up", apiError),
+		),
+	})
+	b.add("put", "/me/git-sync", &Operation{
+		Summary: "Push your notes to a Git repository",
+		Description: "Your private notes, except encrypted ones, are committed as Markdown files to the notes/ folder of " +
+			"branch (main by default) and pushed over SSH, checking for changes every 15 minutes. Add public_key to the " +
+			"repository as a deploy key with write access. Sync is one way: edits made in the repository are overwritten.",
+		Tags:        []string{"account"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("GitSyncPayload", gitsync.SettingsPayload{})),
+		Responses: responses(
+			jsonResponse("200", "Git sync settings", gitSync),
+			jsonResponse("422", "remote_url is not an SSH remote or branch is not a branch name", apiError),
+		),
+	})
+	b.add("delete", "/me/git-sync", &Operation{
+		Summary:  "Stop pushing your notes to Git",
+		Tags:     []string{"account"},
+		Security: bearer,
+		Responses: responses(
+			empty("204", "Git sync removed and its deploy key discarded"),
+			jsonResponse("404", "Git sync is not set up", apiError),
+		),
+	})
+	b.add("post", "/me/password", &Operation{
+		Summary:     "Change your password",
+		Description: "Revokes every previously issued token, ends your other sessions and returns a new token for this one.",
+		Tags:        []string{"auth"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("PasswordChange", auth.PasswordChange{})),
+		Responses: responses(
+			jsonResponse("200", "Password changed", token),
+			jsonResponse("401", "Current password is incorrect", apiError),
+			jsonResponse("409", "Password was changed concurrently", apiError),
+			jsonResponse("422", "New password too short", apiError),
+		),
+	})
+	b.add("post", "/me/email", &Operation{
+		Summary: "Change your email",
+		Description: "Mails a verification link to the new address. Your current email keeps working until the " +
+			"link's token is passed to POST /me/email/verify; a second request replaces the first.",
+		Tags:        []string{"auth"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("EmailChange", auth.EmailChange{})),
+		Responses: responses(
+			jsonResponse("202", "Verification link sent", b.schema("PendingEmailChange", auth.PendingEmailChange{})),
+			jsonResponse("401", "Password is incorrect", apiError),
+			jsonResponse("409", "Email already in use", apiError),
+			jsonResponse("422", "Invalid email, or already your email", apiError),
+		),
+	})
+	b.add("post", "/me/email/verify", &Operation{
+		Summary: "Confirm a new email",
+		Description: "Switches the account to the new address. Like a password change, it revokes every " +
+			"previously issued token, ends your other sessions and returns a new token for this one.",
+		Tags:        []string{"auth"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("EmailVerification", auth.EmailVerification{})),
+		Responses: responses(
+			jsonResponse("200", "Email changed", token),
+			jsonResponse("404", "Verification link is invalid", apiError),
+			jsonResponse("409", "Email already in use", apiError),
+			jsonResponse("410", "Verification link has expired", apiError),
+		),
+	})
+	b.add("get", "/me/sessions", &Operation{
+		Summary: "List your sessions",
+		Description: "Every login whose token hasn't expired or been ended, most recently used first. " +
+			"current marks the session of the token making the request.",
+		Tags:      []string{"auth"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Sessions", arrayOf(b.schema("ActiveSession", auth.ActiveSession{})))),
+	})
+	b.add("delete", "/me/sessions/{id}", &Operation{
+		Summary:     "End a session",
+		Description: "Logs the session's device out: its token is refused from the next request on.",
+		Tags:        []string{"auth"},
+		Security:    bearer,
+		Parameters:  []Parameter{pathParam("id", "Session ID")},
+		Responses: responses(
+			empty("204", "Session ended"),
+			jsonResponse("404", "Session not found", apiError),
+		),
+	})
+
+	auditEntries := arrayOf(b.schema("AuditEntry", audit.Entry{}))
+	offsetParam := Parameter{Name: "offset", In: "query", Description: "Number of events to skip", Schema: &Schema{Type: "integer"}}
+	b.add("get", "/me/security-events", &Operation{
+		Summary: "List security events on your account",
+		Description: "Logins, failed logins, lockouts, password changes and resets, ended sessions, API keys and " +
+			"workspace role changes, with the IP address and user agent they came from. actor_id differs from " +
+			"user_id when an administrator or workspace owner acted on your account.",
+		Tags:       []string{"auth"},
+		Security:   bearer,
+		Parameters: []Parameter{limitParam(), offsetParam},
+		Responses: responses(
+			jsonResponse("200", "Events, newest first", auditEntries),
+			jsonResponse("400", "Invalid limit or offset", apiError),
+		),
+	})
+
+	activityList := arrayOf(b.schema("Activity", activity.Activity{}))
+	b.add("get", "/me/activity", &Operation{
+		Summary:    "List your recent activity",
+		Tags:       []string{"activity"},
+		Security:   bearer,
+		Parameters: []Parameter{limitParam()},
+		Responses:  responses(jsonResponse("200", "Activities, newest first", activityList)),
+	})
+	b.add("get", "/me/usage", &Operation{
+		Summary: "Show your storage usage",
+		Description: "Bytes stored in your private notes and their attachments, and in each workspace you belong to, " +
+			"against the configured quotas. A limit_bytes of 0 means unlimited.",
+		Tags:      []string{"account"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Storage usage", b.schema("StorageUsage", quota.Report{}))),
+	})
+	b.add("get", "/me/limits", &Operation{
+		Summary: "Show your rate limit and quotas",
+		Description: "What is left of your request rate limit in the current window, this request included, with when " +
+			"it resets as reset_at, and your storage usage as GET /me/usage reports it. Limits are counted per server, so " +
+			"behind a load balancer the headers of each response are the closer guide.",
+		Tags:      []string{"account"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Limits and usage", b.schema("Limits", quota.LimitsReport{}))),
+	})
+	b.add("get", "/me/stats", &Operation{
+		Summary: "Summarize your notes",
+		Description: "Counts your private notes and the notes of your workspaces, how many were edited in the last " +
+			"seven days and their words, and lists the most edited notes.",
+		Tags:      []string{"notes"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Note statistics", b.schema("NoteDashboard", notes.Dashboard{}))),
+	})
+	b.add("get", "/me/calendar", &Operation{
+		Summary: "Get your calendar feed URL",
+		Description: "The URL of an iCalendar feed of your reminders and the due dates of your open tasks, to " +
+			"subscribe to from a calendar app. It holds a signed token, so keep it private; changing your password or " +
+			"email address revokes it.",
+		Tags:      []string{"account"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "The feed URL", b.schema("CalendarFeed", calendar.Feed{}))),
+	})
+	b.add("get", "/me/calendar.ics", &Operation{
+		Summary: "Read your calendar feed",
+		Description: "An iCalendar feed with an event at each of your reminders, repeating with it, and an all-day " +
+			"event on the due date of each open task. Calendar apps cannot send an Authorization header, so it is " +
+			"authenticated by the token from GET /me/calendar instead.",
+		Tags: []string{"account"},
+		Parameters: []Parameter{{
+			Name: "token", In: "query", Required: true, Description: "Feed token, as in the URL from GET /me/calendar",
+			Schema: &Schema{Type: "string"},
+		}},
+		Responses: responses(
+			statusResponse{"200", Response{
+				Description: "The feed",
+				Content:     map[string]MediaType{"text/calendar": {Schema: &Schema{Type: "string"}}},
+			}},
+			jsonResponse("401", "The token is missing, invalid or revoked", apiError),
+		),
+	})
+
+	apiKey := b.schema("APIKey", integrations.APIKey{})
+	b.add("get", "/me/api-keys", &Operation{
+		Summary:   "List your API keys",
+		Tags:      []string{"integrations"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "API keys, newest first, without the keys themselves", arrayOf(apiKey))),
+	})
+	b.add("post", "/me/api-keys", &Operation{
+		Summary: "Create an API key for an integration",
+		Description: "Scopes are notes:read and notes:write. The key is only returned in this response, so " +
+			"store it right away.",
+		Tags:        []string{"integrations"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("APIKeyPayload", integrations.APIKeyPayload{})),
+		Responses: responses(
+			jsonResponse("201", "API key created", b.schema("IssuedAPIKey", integrations.IssuedAPIKey{})),
+			jsonResponse("409", "Too many API keys", apiError),
+			jsonResponse("422", "Missing name or unknown scope", apiError),
+		),
+	})
+	b.add("delete", "/me/api-keys/{id}", &Operation{
+		Summary:    "Revoke an API key",
+		Tags:       []string{"integrations"},
+		Security:   bearer,
+		Parameters: []Parameter{pathParam("id", "API key ID")},
+		Responses: responses(
+			empty("204", "API key revoked"),
+			jsonResponse("404", "API key not found", apiError),
+		),
+	})
+	b.add("get", "/integrations/triggers", &Operation{
+		Summary: "List polling triggers",
+		Description: "Static catalog of endpoints automation platforms such as Zapier and IFTTT can poll, with " +
+			"the API key scope each needs and a sample item.",
+		Tags:      []string{"integrations"},
+		Responses: responses(jsonResponse("200", "Triggers", arrayOf(b.schema("Trigger", integrations.Trigger{})))),
+	})
+
+	note := b.schema("Note", notes.Note{})
+	notePayload := b.schema("NotePayload", notes.NotePayload{})
+	createPayload := b.schema("CreatePayload", notes.CreatePayload{})
+	b.add("get", "/notes", &Operation{
+		Summary:     "List your private notes",
+		Description: "Pinned notes come first, then the most recently updated. Workspace notes are listed under GET /workspaces/{id}/notes.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters: []Parameter{{
+			Name: "state", In: "query", Description: "Which notes to list (default active)",
+			Schema: &Schema{Type: "string", Enum: []string{"active", "archived"}},
+		}, colorParam(), updatedSinceParam(), metaFilterParam()},
+		Responses: responses(
+			jsonResponse("200", "Notes, with ETag and Last-Modified headers", arrayOf(note)),
+			empty("304", "Unchanged since If-None-Match or If-Modified-Since"),
+			jsonResponse("400", "Unknown state or color, or malformed updated_since", apiError),
+		),
+	})
+	b.add("post", "/notes", &Operation{
+		Summary: "Create a note",
+		Description: "Content is stored as written; HTML in it is sanitized where the server renders it, such as PDF " +
+			"export. Set encrypted to create an end-to-end encrypted note: title and content are ciphertext and wrapped_key " +
+			"is the note key wrapped with your public key. The server stores both as they are. metadata is an optional " +
+			"JSON object of your own fields, stored as it is; see PATCH /notes/{id}/metadata.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{idempotencyKeyParam()},
+		RequestBody: jsonBody(createPayload),
+		Responses: responses(
+			jsonResponse("201", "Note created", b.schema("Created", struct {
+				ID string `json:"id"`
+			}{})),
+			jsonResponse("402", "Storage quota exceeded", apiError),
+			jsonResponse("409", "A request with the same Idempotency-Key is still in progress", apiError),
+			jsonResponse("413", "Content or metadata exceeds its size limit", apiError),
+			jsonResponse("422", "Invalid title or metadata, or Idempotency-Key already used for a different request", apiError),
+		),
+	})
+	b.add("get", "/notes/recent", &Operation{
+		Summary:     "List recently viewed notes",
+		Description: "Up to 20 notes you recently opened with GET /notes/{id} and can still access.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Responses: responses(
+			jsonResponse("200", "The latest views, newest first", arrayOf(b.schema("ViewedNote", notes.ViewedNote{}))),
+		),
+	})
+	b.add("get", "/notes/favorites", &Operation{
+		Summary:   "List your favorite notes",
+		Tags:      []string{"notes"},
+		Security:  bearerOrAPIKey,
+		Responses: responses(jsonResponse("200", "Notes, most recently favorited first", arrayOf(b.schema("FavoriteNote", notes.FavoriteNote{})))),
+	})
+	b.add("get", "/notes/duplicates", &Operation{
+		Summary: "Find duplicate notes",
+		Description: "Groups your private notes whose titles and content share many of the same runs of words, for " +
+			"tidying up after importing from several apps. Compares your 2000 most recently updated notes; encrypted " +
+			"notes are left out.",
+		Tags:     []string{"notes"},
+		Security: bearerOrAPIKey,
+		Parameters: []Parameter{{
+			Name: "threshold", In: "query", Description: "How alike two notes must be, from 0 to 1 (default 0.5)",
+			Schema: &Schema{Type: "number"},
+		}},
+		Responses: responses(
+			jsonResponse("200", "Groups of likely duplicates, most recently updated first", arrayOf(b.schema("DuplicateGroup", notes.DuplicateGroup{}))),
+			jsonResponse("400", "threshold is not a number greater than 0 and at most 1", apiError),
+		),
+	})
+	b.add("get", "/notes/changes", &Operation{
+		Summary: "List note changes since a point",
+		Description: "Every create, update and delete of your private notes and your workspaces' notes after since, " +
+			"oldest first, with the version each note was left at, for syncing incrementally. Pass the cursor of the " +
+			"previous page as since to continue, or a time to start from; without since the log is listed from the " +
+			"start. A note changed several times appears once per change. Pinning and archiving aren't changes.",
+		Tags:     []string{"notes"},
+		Security: bearerOrAPIKey,
+		Parameters: []Parameter{{
+			Name: "since", In: "query", Description: "Cursor of an earlier page, or an RFC 3339 time",
+			Schema: &Schema{Type: "string"},
+		}, {
+			Name: "limit", In: "query", Description: "Maximum number of changes (default 500, max 1000)",
+			Schema: &Schema{Type: "integer"},
+		}},
+		Responses: responses(
+			jsonResponse("200", "A page of changes", b.schema("ChangesPage", changelog.Page{})),
+			jsonResponse("400", "since is neither a cursor nor a time, or limit is invalid", apiError),
+		),
+	})
+	b.add("post", "/notes/merge", &Operation{
+		Summary: "Merge notes",
+		Description: "Combines your private notes into the first one listed, whose content becomes each note's content in " +
+			"turn, separated by a blank line. The other notes are deleted, but their titles and content are kept as " +
+			"revisions of the merged note, one version each, so GET /notes/{id}/diff can show them.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		RequestBody: jsonBody(b.schema("MergeNotesPayload", notes.MergeNotesPayload{})),
+		Responses: responses(
+			jsonResponse("200", "The merged note", b.schema("MergeNotesResult", notes.MergeNotesResult{})),
+			jsonResponse("400", "A note is encrypted", apiError),
+			jsonResponse("402", "Storage quota exceeded", apiError),
+			jsonResponse("404", "A note is not one of your private notes", apiError),
+			jsonResponse("409", "The notes changed while merging", apiError),
+			jsonResponse("413", "Merged content exceeds the configured size limit", apiError),
+			jsonResponse("422", "Fewer than 2 or more than 20 notes, repeated notes, or a title that is too long", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}", &Operation{
+		Summary:     "Get a note",
+		Description: "The view is recorded for GET /notes/recent.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID},
+		Responses: responses(
+			jsonResponse("200", "Note, with ETag and Last-Modified headers", note),
+			empty("304", "Unchanged since If-None-Match or If-Modified-Since"),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+		),
+	})
+	b.add("put", "/notes/{id}", &Operation{
+		Summary: "Update a note",
+		Description: "With mode=merge the body is a MergePayload carrying the version and content the edit started from. " +
+			"Changes made since then are combined line by line with the new content; when both touched the same lines " +
+			"nothing is stored and the response lists the conflicts, with the merged content between conflict markers.",
+		Tags:     []string{"notes"},
+		Security: bearerOrAPIKey,
+		Parameters: []Parameter{noteID, {
+			Name: "mode", In: "query", Description: "Replace the note, or merge with changes made since base_version (default overwrite)",
+			Schema: &Schema{Type: "string", Enum: []string{"overwrite", "merge"}},
+		}},
+		RequestBody: jsonBody(&Schema{OneOf: []*Schema{notePayload, b.schema("MergePayload", notes.MergePayload{})}}),
+		Responses: responses(
+			empty("204", "Note updated"),
+			jsonResponse("200", "Merge stored", b.schema("MergeResult", notes.MergeResult{})),
+			jsonResponse("400", "Unknown mode, or merging an encrypted note", apiError),
+			jsonResponse("409", "Merge conflicts with changes since the base version", b.schema("MergeConflict", notes.MergeConflict{})),
+			jsonResponse("402", "Storage quota exceeded", apiError),
+			jsonResponse("403", "You have read-only access to this note", apiError),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+			jsonResponse("413", "Content exceeds the configured size limit", apiError),
+			jsonResponse("422", "Invalid title, or base_version missing in merge mode", apiError),
+			jsonResponse("423", "Note is locked by another user", apiError),
+		),
+	})
+	b.add("patch", "/notes/{id}", &Operation{
+		Summary: "Label a note with a color and icon",
+		Description: "color is one of " + strings.Join(models.NoteColors, ", ") + " and icon one of " +
+			strings.Join(models.NoteIcons, " ") + ". Fields left out are kept and \"\" clears them. Like pinning, this " +
+			"doesn't change the note's version or updated_at. Everyone in the note's room receives an AppearanceMessage.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID},
+		RequestBody: jsonBody(b.schema("AppearancePayload", notes.AppearancePayload{})),
+		Responses: responses(
+			jsonResponse("200", "The note's color and icon", b.schema("Appearance", notes.Appearance{})),
+			jsonResponse("400", "No fields to update", apiError),
+			jsonResponse("403", "You have read-only access to this note", apiError),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+			jsonResponse("422", "color or icon is not allowed", apiError),
+		),
+	})
+	b.add("delete", "/notes/{id}", &Operation{
+		Summary: "Delete a note",
+		Description: "Anyone with the note open receives a NoteDeletedMessage and their connections turn read-only " +
+			"until the room is closed WS_DELETED_GRACE (default 30s) later.",
+		Tags:       []string{"notes"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID},
+		Responses: responses(
+			empty("204", "Note deleted"),
+			jsonResponse("403", "You have read-only access to this note", apiError),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+		),
+	})
+	b.add("patch", "/notes/{id}/metadata", &Operation{
+		Summary: "Change a note's metadata",
+		Description: fmt.Sprintf("The body is a JSON merge patch (RFC 7396) applied to the note's metadata: keys set to "+
+			"null are removed, objects are merged and other values replace what was there. The result may be at most "+
+			"%d bytes and nest %d levels deep. The note's updated_at moves but its version does not, and metadata "+
+			"isn't kept in revisions.", models.MaxMetadataBytes, models.MaxMetadataDepth),
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID},
+		RequestBody: jsonBody(&Schema{Type: "object"}),
+		Responses: responses(
+			jsonResponse("200", "The note's metadata", &Schema{Type: "object"}),
+			jsonResponse("400", "The body is not a JSON object", apiError),
+			jsonResponse("403", "You have read-only access to this note", apiError),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+			jsonResponse("413", "Metadata exceeds its size limit", apiError),
+			jsonResponse("422", "Metadata nests too deeply or has an empty key", apiError),
+		),
+	})
+	for _, state := range []struct{ action, summary string }{
+		{"pin", "Pin a note to the top of your list"},
+		{"unpin", "Unpin a note"},
+		{"archive", "Archive a note"},
+		{"unarchive", "Restore an archived note"},
+	} {
+		b.add("post", "/notes/{id}/"+state.action, &Operation{
+			Summary:    state.summary,
+			Tags:       []string{"notes"},
+			Security:   bearerOrAPIKey,
+			Parameters: []Parameter{noteID},
+			Responses: responses(
+				empty("204", "Note updated"),
+				jsonResponse("403", "You have read-only access to this note", apiError),
+				jsonResponse("404", "Note not found or unauthorized", apiError),
+			),
+		})
+	}
+	b.add("post", "/notes/{id}/lock", &Operation{
+		Summary: "Lock a note for exclusive editing",
+		Description: "Until the lock is released or expires, updates from everyone else are refused and their " +
+			"realtime connections are read-only. Locking a note you already hold renews the lock. ttl_seconds " +
+			"defaults to 600 and may be at most 3600.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID},
+		RequestBody: jsonBody(b.schema("LockPayload", notes.LockPayload{})),
+		Responses: responses(
+			jsonResponse("200", "Note locked", b.schema("NoteLock", notes.NoteLock{})),
+			jsonResponse("403", "You have read-only access to this note", apiError),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+			jsonResponse("422", "ttl_seconds out of range", apiError),
+			jsonResponse("423", "Note is locked by another user", apiError),
+		),
+	})
+	b.add("post", "/notes/{id}/unlock", &Operation{
+		Summary:     "Release a note's lock",
+		Description: "The lock's holder and the note's author can release it. Unlocking a note nobody holds succeeds.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID},
+		Responses: responses(
+			empty("204", "Note unlocked"),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+			jsonResponse("423", "Note is locked by another user", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/collaborators", &Operation{
+		Summary: "List a note's collaborators",
+		Description: "Everyone with access to the note and their role: owner for its author, else viewer, commenter " +
+			"or editor. Workspace members are editors unless the owner gave them another role.",
+		Tags:       []string{"notes"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID},
+		Responses: responses(
+			jsonResponse("200", "Collaborators, ordered by user ID", arrayOf(b.schema("Collaborator", notes.Collaborator{}))),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+		),
+	})
+	b.add("put", "/notes/{id}/collaborators/{userId}", &Operation{
+		Summary: "Change a collaborator's role",
+		Description: "Only the note's owner can change roles. Viewers and commenters can read the note but not update, " +
+			"delete, pin, archive or lock it, and their realtime connections are read-only. Collaborators in the " +
+			"note's room receive a RoleMessage, and a collaborator given their first role on the note gets a " +
+			"share_invite notification.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID, pathParam("userId", "Collaborator's user ID")},
+		RequestBody: jsonBody(b.schema("NoteRolePayload", notes.RolePayload{})),
+		Responses: responses(
+			empty("204", "Role changed"),
+			jsonResponse("400", "The owner's role cannot be changed", apiError),
+			jsonResponse("403", "Only the note's owner can change roles", apiError),
+			jsonResponse("404", "Note not found, or the user cannot access it", apiError),
+			jsonResponse("422", "role is not viewer, commenter or editor", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/receipts", &Operation{
+		Summary: "List read receipts",
+		Description: "How far everyone with access to the note has read it. up_to_date is true for those who have " +
+			"read its current version; version is 0 for those who have never opened it.",
+		Tags:       []string{"notes"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID},
+		Responses: responses(
+			jsonResponse("200", "Read receipts", b.schema("NoteReceipts", notes.NoteReceipts{})),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+		),
+	})
+	b.add("post", "/notes/{id}/receipts", &Operation{
+		Summary: "Mark a note as read",
+		Description: "Records that you have read the note up to version, by default its current one. Opening the note " +
+			"with GET /notes/{id} records this already. Receipts never move backwards; collaborators in the note's " +
+			"room receive a ReceiptMessage when yours advances.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID},
+		RequestBody: jsonBody(b.schema("ReceiptPayload", notes.ReceiptPayload{})),
+		Responses: responses(
+			empty("204", "Receipt recorded"),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+			jsonResponse("422", "version out of range", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/diff", &Operation{
+		Summary: "Show what changed between versions",
+		Description: "Diffs the note's content between two of its versions, as a unified diff and as hunks of lines " +
+			"with a word by word diff of each. Versions written before revisions were kept cannot be diffed.",
+		Tags:     []string{"notes"},
+		Security: bearerOrAPIKey,
+		Parameters: []Parameter{noteID, {
+			Name: "from", In: "query", Description: "Version to diff from (default the one before to)",
+			Schema: &Schema{Type: "integer"},
+		}, {
+			Name: "to", In: "query", Description: "Version to diff to (default the current version)",
+			Schema: &Schema{Type: "integer"},
+		}},
+		Responses: responses(
+			jsonResponse("200", "The diff", b.schema("NoteDiff", notes.NoteDiff{})),
+			jsonResponse("400", "Note is encrypted", apiError),
+			jsonResponse("404", "Note or revision not found", apiError),
+			jsonResponse("422", "from or to is not a version of the note", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/state", &Operation{
+		Summary: "Get a note's CRDT state",
+		Description: "The note's content as a replicated growable array, for clients that edit offline and merge " +
+			"without the realtime socket. Each character has an ID of the client that typed it and that client's " +
+			"Lamport clock, and is placed after the character it was typed after. ops are the operations a client at " +
+			"the state vector since is missing, or all of them without it; changes made some other way appear as " +
+			"operations of the client \"server\".",
+		Tags:     []string{"notes"},
+		Security: bearerOrAPIKey,
+		Parameters: []Parameter{noteID, {
+			Name: "since", In: "query", Description: "State vector of the client, as a JSON object of clocks by client",
+			Schema: &Schema{Type: "string"},
+		}},
+		Responses: responses(
+			jsonResponse("200", "The state", b.schema("NoteState", notes.NoteState{})),
+			jsonResponse("400", "since is not a state vector, or the note is encrypted", apiError),
+			jsonResponse("404", "Note not found", apiError),
+		),
+	})
+	b.add("post", "/notes/{id}/updates", &Operation{
+		Summary: "Merge offline CRDT operations into a note",
+		Description: "Applies up to 1000 operations a client made offline, in order, and answers with the note's " +
+			"state and the operations the client's vector is missing. Operations the server already has are skipped, " +
+			"so a batch can be resent; every client that applies the rest ends up with the same content. A client's " +
+			"operations must be sent in the order it made them.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID},
+		RequestBody: jsonBody(b.schema("UpdatesPayload", notes.UpdatesPayload{})),
+		Responses: responses(
+			jsonResponse("200", "The merged state", b.schema("NoteState", notes.NoteState{})),
+			jsonResponse("400", "No operations, too many, or the note is encrypted", apiError),
+			jsonResponse("403", "You have read-only access to this note", apiError),
+			jsonResponse("404", "Note not found", apiError),
+			jsonResponse("409", "An operation refers to a character the server doesn't have, or the note kept changing", apiError),
+			jsonResponse("422", "An operation is malformed", apiError),
+			jsonResponse("423", "Note is locked by another user", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/suggestions", &Operation{
+		Summary: "List suggested changes to a note",
+		Description: "What the server's content processors, such as a LanguageTool spelling and grammar checker, " +
+			"suggest changing in the note. Notes are processed in the background a few seconds after they are last " +
+			"edited, so the suggestions may be for an earlier version; offset and length are in UTF-16 code units of " +
+			"that version's content. Encrypted notes have none.",
+		Tags:       []string{"notes"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID},
+		Responses: responses(
+			jsonResponse("200", "The suggestions, in order of position", b.schema("NoteSuggestions", notes.NoteSuggestions{})),
+			jsonResponse("404", "Note not found", apiError),
+		),
+	})
+	b.add("post", "/notes/{id}/favorite", &Operation{
+		Summary:    "Favorite or unfavorite a note",
+		Tags:       []string{"notes"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID},
+		Responses: responses(
+			jsonResponse("200", "Whether the note is now a favorite", b.schema("FavoriteResult", struct {
+				Favorited bool `json:"favorited"`
+			}{})),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/keys", &Operation{
+		Summary: "List who can open an encrypted note",
+		Description: "Lists everyone with access to the note with their public keys and whether they hold the note key, " +
+			"along with your own wrapped copy of it.",
+		Tags:       []string{"notes"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID},
+		Responses: responses(
+			jsonResponse("200", "Collaborators and your wrapped key", b.schema("NoteKeys", notes.NoteKeys{})),
+			jsonResponse("400", "Note is not encrypted", apiError),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+		),
+	})
+	b.add("put", "/notes/{id}/keys/{userId}", &Operation{
+		Summary:     "Share an encrypted note's key",
+		Description: "Stores the note key wrapped with the user's public key. Sharing again replaces their copy.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID, pathParam("userId", "User to share the key with")},
+		RequestBody: jsonBody(b.schema("KeyPayload", notes.KeyPayload{})),
+		Responses: responses(
+			empty("204", "Key shared"),
+			jsonResponse("400", "Note is not encrypted", apiError),
+			jsonResponse("403", "You do not hold the note key", apiError),
+			jsonResponse("404", "Note not found, or the user cannot access it", apiError),
+			jsonResponse("409", "The user has not published a public key", apiError),
+			jsonResponse("422", "Missing wrapped_key", apiError),
+		),
+	})
+	b.add("post", "/sync", &Operation{
+		Summary: "Sync offline changes",
+		Description: "Applies a batch of note changes made offline, in order and in one transaction. Updates and " +
+			"deletes whose base_version no longer matches the note are skipped and reported as conflicts with the " +
+			"server's copy; invalid changes and those that would exceed your storage quota are rejected. Every other " +
+			"change is applied.",
+		Tags:        []string{"notes"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("SyncRequest", notes.SyncRequest{})),
+		Responses: responses(
+			jsonResponse("200", "One result per change, in request order", b.schema("SyncResponse", notes.SyncResponse{})),
+			jsonResponse("400", "Empty or oversized batch", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/presence", &Operation{
+		Summary:    "List users connected to a note",
+		Tags:       []string{"realtime"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID},
+		Responses: responses(
+			jsonResponse("200", "Connected users", b.schema("Presence", struct {
+				NoteID string                 `json:"note_id"`
+				Users  []realtime.Participant `json:"users"`
+			}{})),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/activity", &Operation{
+		Summary:    "List a note's activity",
+		Tags:       []string{"activity"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID, limitParam()},
+		Responses: responses(
+			jsonResponse("200", "Activities, newest first", activityList),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/sessions", &Operation{
+		Summary: "List a note's recorded sessions",
+		Description: "In workspaces that record sessions, each time a note's room opens, from its first connection " +
+			"to its last leaving, is a session whose edits are kept for replay. The 100 newest are listed, newest first.",
+		Tags:       []string{"realtime"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID},
+		Responses: responses(
+			jsonResponse("200", "Recorded sessions", &Schema{Type: "array", Items: b.schema("RecordedSession", recordings.Session{})}),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/sessions/{sessionID}/replay", &Operation{
+		Summary: "Replay a recorded session",
+		Description: "Streams the session's edits in order as newline-delimited JSON, one ReplayedEdit per line. " +
+			"offset_ms is when each edit was made, in milliseconds since the session started, and edit the " +
+			"EditMessage collaborators received for it. Edits the server couldn't keep up with recording are missing, " +
+			"which shows as a gap in revision.",
+		Tags:       []string{"realtime"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID, pathParam("sessionID", "Session ID")},
+		Responses: responses(
+			statusResponse{"200", Response{
+				Description: "The session's edits",
+				Content:     map[string]MediaType{"application/x-ndjson": {Schema: b.schema("ReplayedEdit", recordings.ReplayedEdit{})}},
+			}},
+			jsonResponse("404", "Note or session not found", apiError),
+		),
+	})
+
+	attachment := b.schema("Attachment", attachments.Attachment{})
+	b.add("post", "/notes/{id}/attachments", &Operation{
+		Summary:    "Upload an attachment",
+		Tags:       []string{"attachments"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID},
+		RequestBody: &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{"multipart/form-data": {Schema: &Schema{
+				Type:       "object",
+				Properties: map[string]*Schema{"file": {Type: "string", Format: "binary"}},
+				Required:   []string{"file"},
+			}}},
+		},
+		Responses: responses(
+			jsonResponse("201", "Attachment stored", attachment),
+			jsonResponse("402", "Storage quota exceeded", apiError),
+			jsonResponse("403", "Read-only access to the note", apiError),
+			jsonResponse("413", "File too large", apiError),
+			jsonResponse("415", "Unsupported file type", apiError),
+		),
+	})
+	b.add("get", "/attachments/{id}", &Operation{
+		Summary: "Download an attachment",
+		Description: "When the server scans uploads for malware, an attachment can be downloaded once it has been found clean; " +
+			"its status says where it is.",
+		Tags:       []string{"attachments"},
+		Security:   bearer,
+		Parameters: []Parameter{pathParam("id", "Attachment ID")},
+		Responses: responses(
+			binaryResponse("200", "File contents"),
+			jsonResponse("403", "Attachment quarantined as malware", apiError),
+			jsonResponse("404", "Attachment not found", apiError),
+			jsonResponse("409", "Attachment still being scanned for viruses", apiError),
+		),
+	})
+	b.add("delete", "/attachments/{id}", &Operation{
+		Summary:    "Delete an attachment",
+		Tags:       []string{"attachments"},
+		Security:   bearer,
+		Parameters: []Parameter{pathParam("id", "Attachment ID")},
+		Responses: responses(
+			empty("204", "Attachment deleted"),
+			jsonResponse("403", "Read-only access to the note", apiError),
+			jsonResponse("404", "Attachment not found", apiError),
+		),
+	})
+
+	notePDF := b.schema("NotePDF", printing.PDF{})
+	pdfFile := statusResponse{"200", Response{
+		Description: "The PDF",
+		Content:     map[string]MediaType{"application/pdf": {Schema: &Schema{Type: "string", Format: "binary"}}},
+	}}
+	pdfID := pathParam("pdfId", "PDF ID")
+	b.add("get", "/notes/{id}/pdf", &Operation{
+		Summary: "Export a note as PDF",
+		Description: "Renders the note's Markdown with its title, author, dates and version, and an appendix listing " +
+			"its attachments. Paragraphs of HTML are sanitized under the server's NOTE_HTML_POLICY, and any other " +
+			"markup is printed as written. Notes over 64 KiB, or any with async=true, are rendered in the background: the response " +
+			"is a 202 with a status_url to poll until the PDF is done, then fetch its download_url. Encrypted notes " +
+			"cannot be printed by the server.",
+		Tags:     []string{"notes"},
+		Security: bearerOrAPIKey,
+		Parameters: []Parameter{noteID, {
+			Name: "async", In: "query", Description: "Render in the background whatever the note's size",
+			Schema: &Schema{Type: "boolean"},
+		}},
+		Responses: responses(
+			pdfFile,
+			jsonResponse("202", "Rendering in the background", notePDF),
+			jsonResponse("400", "Note is encrypted", apiError),
+			jsonResponse("404", "Note not found", apiError),
+			jsonResponse("501", "PDF export is not enabled on this server", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/pdf/{pdfId}", &Operation{
+		Summary: "Get a PDF's progress",
+		Description: "status is running until the PDF is rendered, then done with download_url and expires_at set, " +
+			"or failed with error set.",
+		Tags:       []string{"notes"},
+		Security:   bearerOrAPIKey,
+		Parameters: []Parameter{noteID, pdfID},
+		Responses: responses(
+			jsonResponse("200", "The PDF", notePDF),
+			jsonResponse("404", "PDF not found", apiError),
+		),
+	})
+	b.add("get", "/notes/{id}/pdf/{pdfId}/download", &Operation{
+		Summary:     "Download a PDF",
+		Description: "The PDF can be downloaded until expires_at, a day after it was rendered.",
+		Tags:        []string{"notes"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID, pdfID},
+		Responses: responses(
+			pdfFile,
+			jsonResponse("404", "Note or PDF not found", apiError),
+			jsonResponse("409", "PDF is not ready", apiError),
+			jsonResponse("410", "PDF has expired", apiError),
+		),
+	})
+
+	task := b.schema("Task", notes.Task{})
+	b.add("get", "/tasks", &Operation{
+		Summary: "List task items across your notes",
+		Description: "The \"- [ ]\" and \"- [x]\" items of every note you can access, kept in step with each note " +
+			"as it is saved. due is the date of a due:YYYY-MM-DD tag in the item's text. Encrypted notes have none.",
+		Tags:     []string{"tasks"},
+		Security: bearer,
+		Parameters: []Parameter{{
+			Name: "done", In: "query", Description: "Only list done (true) or open (false) tasks",
+			Schema: &Schema{Type: "boolean"},
+		}, {
+			Name: "due", In: "query", Description: "Only list tasks due on or before this date (YYYY-MM-DD)",
+			Schema: &Schema{Type: "string", Format: "date"},
+		}},
+		Responses: responses(
+			jsonResponse("200", "Up to 500 tasks, soonest due first, then by note and line", arrayOf(task)),
+			jsonResponse("400", "done or due is malformed", apiError),
+		),
+	})
+	b.add("patch", "/tasks/{id}", &Operation{
+		Summary: "Check or uncheck a task item",
+		Description: "Rewrites the item's line of the note, making a new version, and sends the task and the note's " +
+			"new content to everyone in the note's room as a task message.",
+		Tags:        []string{"tasks"},
+		Security:    bearer,
+		Parameters:  []Parameter{pathParam("id", "Task ID")},
+		RequestBody: jsonBody(b.schema("TaskPayload", notes.TaskPayload{})),
+		Responses: responses(
+			jsonResponse("200", "The task", task),
+			jsonResponse("403", "You have read-only access to this note", apiError),
+			jsonResponse("404", "Task not found or unauthorized", apiError),
+			jsonResponse("409", "The note changed since the task was read", apiError),
+			jsonResponse("422", "done is missing", apiError),
+			jsonResponse("423", "Note is locked by another user", apiError),
+		),
+	})
+
+	importJob := b.schema("Import", imports.Import{})
+	b.add("post", "/imports", &Operation{
+		Summary: "Import an Evernote or Notion export",
+		Description: "Creates a private note for each note of an Evernote .enex file or each page of a Notion " +
+			"\"Markdown & CSV\" ZIP export, with its attached files. Notebooks and tags are added as lines at the end " +
+			"of each note and to its metadata as notebook and tags. The import runs in the background; poll it for progress. " +
+			"A Notion export may hold up to 10000 files unpacking to 500 MB; one that turns out larger fails, keeping " +
+			"the notes created so far.",
+		Tags:       []string{"imports"},
+		Security:   bearer,
+		Parameters: []Parameter{idempotencyKeyParam()},
+		RequestBody: &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{"multipart/form-data": {Schema: &Schema{
+				Type: "object",
+				Properties: map[string]*Schema{
+					"file":   {Type: "string", Format: "binary"},
+					"format": {Type: "string", Enum: []string{"enex", "notion"}},
+				},
+				Required: []string{"file", "format"},
+			}}},
+		},
+		Responses: responses(
+			jsonResponse("202", "Import started", importJob),
+			jsonResponse("400", "Missing or empty file", apiError),
+			jsonResponse("409", "A request with the same Idempotency-Key is still in progress", apiError),
+			jsonResponse("413", "File too large", apiError),
+			jsonResponse("422", "format is not enex or notion, or Idempotency-Key already used for a different request", apiError),
+		),
+	})
+	b.add("get", "/imports/{id}", &Operation{
+		Summary: "Get an import's progress",
+		Description: "status is running until every note has been tried, then done, or failed with error set if the " +
+			"export could not be read. Notes that could not be created, e.g. for exceeding your quota, are skipped.",
+		Tags:       []string{"imports"},
+		Security:   bearer,
+		Parameters: []Parameter{pathParam("id", "Import ID")},
+		Responses: responses(
+			jsonResponse("200", "The import", importJob),
+			jsonResponse("404", "Import not found", apiError),
+		),
+	})
+
+	reminder := b.schema("Reminder", reminders.Reminder{})
+	b.add("post", "/notes/{id}/reminders", &Operation{
+		Summary: "Set a reminder on a note",
+		Description: "At due_at you get a reminder notification and email. Recurring reminders then move to their " +
+			"next occurrence; recurrence is daily, weekly, monthly, yearly or empty for a one-off reminder.",
+		Tags:        []string{"reminders"},
+		Security:    bearerOrAPIKey,
+		Parameters:  []Parameter{noteID},
+		RequestBody: jsonBody(b.schema("ReminderPayload", reminders.ReminderPayload{})),
+		Responses: responses(
+			jsonResponse("201", "Reminder scheduled", reminder),
+			jsonResponse("404", "Note not found or unauthorized", apiError),
+			jsonResponse("422", "due_at is missing or past, or recurrence is unknown", apiError),
+		),
+	})
+	b.add("get", "/reminders", &Operation{
+		Summary:   "List your upcoming reminders",
+		Tags:      []string{"reminders"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Reminders, soonest first", arrayOf(reminder))),
+	})
+	b.add("delete", "/reminders/{id}", &Operation{
+		Summary:    "Cancel a reminder",
+		Tags:       []string{"reminders"},
+		Security:   bearer,
+		Parameters: []Parameter{pathParam("id", "Reminder ID")},
+		Responses: responses(
+			empty("204", "Reminder cancelled"),
+			jsonResponse("404", "Reminder not found", apiError),
+		),
+	})
+
+	b.add("get", "/notifications", &Operation{
+		Summary:  "List your notifications",
+		Tags:     []string{"notifications"},
+		Security: bearer,
+		Parameters: []Parameter{{
+			Name: "unread", In: "query", Description: "Only list unread notifications",
+			Schema: &Schema{Type: "boolean"},
+		}},
+		Responses: responses(jsonResponse("200", "Notifications, newest first", arrayOf(b.schema("Notification", notifications.Notification{})))),
+	})
+	b.add("post", "/notifications/read", &Operation{
+		Summary:   "Mark all notifications read",
+		Tags:      []string{"notifications"},
+		Security:  bearer,
+		Responses: responses(empty("204", "Marked read")),
+	})
+	b.add("post", "/notifications/{id}/read", &Operation{
+		Summary:    "Mark a notification read",
+		Tags:       []string{"notifications"},
+		Security:   bearer,
+		Parameters: []Parameter{pathParam("id", "Notification ID")},
+		Responses: responses(
+			empty("204", "Marked read"),
+			jsonResponse("404", "Notification not found", apiError),
+		),
+	})
+
+	b.addWorkspaces(apiError, note, createPayload)
+	b.addAdmin(apiError)
+	b.addWebSocket()
+
+	b.add("get", "/maintenance", &Operation{
+		Summary: "Maintenance mode",
+		Description: "While enabled, requests other than GET, HEAD and OPTIONS and new WebSocket connections are " +
+			"refused with 503 and a Retry-After header, and clients should switch to read-only. Note rooms are sent a " +
+			"maintenance message with the same fields whenever it starts, changes or ends.",
+		Tags:      []string{"health"},
+		Responses: responses(jsonResponse("200", "Maintenance state", b.schema("Maintenance", maintenance.State{}))),
+	})
+	b.add("get", "/healthz", &Operation{
+		Summary:   "Liveness probe",
+		Tags:      []string{"health"},
+		Responses: responses(empty("200", "Process is up")),
+	})
+	report := b.schema("HealthReport", health.Report{})
+	b.add("get", "/readyz", &Operation{
+		Summary: "Readiness probe",
+		Description: "Pings the database and checks that every table in the schema exists. " +
+			"A failed schema check reports the server degraded but ready, unless HEALTH_STRICT is set.",
+		Tags: []string{"health"},
+		Responses: responses(
+			jsonResponse("200", "Ready, possibly degraded", report),
+			jsonResponse("503", "A required check failed", report),
+		),
+	})
+	b.add("get", "/startupz", &Operation{
+		Summary:     "Startup probe",
+		Description: "Runs the readiness checks until they first pass, then always succeeds.",
+		Tags:        []string{"health"},
+		Responses: responses(
+			jsonResponse("200", "Started", report),
+			jsonResponse("503", "Still starting", report),
+		),
+	})
+
+	return b.doc
+}
+
+// addWorkspaces documents the /workspaces routes and the invitee's side of
+// invitations
+func (b *builder) addWorkspaces(apiError, note, createPayload *Schema) {
+	workspace := b.schema("Workspace", workspaces.Workspace{})
+	invitation := b.schema("Invitation", workspaces.Invitation{})
+	b.schema("Joined", struct {
+		WorkspaceID string `json:"workspace_id"`
+	}{})
+	created := b.ref("Created")
+	workspaceID := pathParam("id", "Workspace ID")
+	forbidden := jsonResponse("403", "Your role in the workspace does not allow this", apiError)
+	notFound := jsonResponse("404", "Workspace not found or you are not a member", apiError)
+
+	b.add("get", "/workspaces", &Operation{
+		Summary:   "List your workspaces",
+		Tags:      []string{"workspaces"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Workspaces with your role in each, by name", arrayOf(workspace))),
+	})
+	b.add("post", "/workspaces", &Operation{
+		Summary:     "Create a workspace",
+		Description: "You become its owner.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("WorkspacePayload", workspaces.WorkspacePayload{})),
+		Responses: responses(
+			jsonResponse("201", "Workspace created", created),
+			jsonResponse("422", "Invalid name", apiError),
+		),
+	})
+	b.add("get", "/workspaces/{id}", &Operation{
+		Summary:    "Get a workspace and its members",
+		Tags:       []string{"workspaces"},
+		Security:   bearer,
+		Parameters: []Parameter{workspaceID},
+		Responses:  responses(jsonResponse("200", "Workspace", workspace), notFound),
+	})
+	b.add("delete", "/workspaces/{id}", &Operation{
+		Summary:     "Delete a workspace",
+		Description: "Owner only. Deletes the workspace's notes, members and invitations with it.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{workspaceID},
+		Responses:   responses(empty("204", "Workspace deleted"), forbidden, notFound),
+	})
+	b.add("get", "/workspaces/{id}/notes", &Operation{
+		Summary:     "List a workspace's notes",
+		Description: "Every member can read and edit workspace notes through the /notes/{id} routes.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters: []Parameter{workspaceID, {
+			Name: "state", In: "query", Description: "Which notes to list (default active)",
+			Schema: &Schema{Type: "string", Enum: []string{"active", "archived"}},
+		}, colorParam(), updatedSinceParam(), metaFilterParam()},
+		Responses: responses(
+			jsonResponse("200", "Notes, with ETag and Last-Modified headers", arrayOf(note)),
+			empty("304", "Unchanged since If-None-Match or If-Modified-Since"),
+			jsonResponse("400", "Unknown state or color, or malformed updated_since", apiError),
+			notFound,
+		),
+	})
+	b.add("post", "/workspaces/{id}/notes", &Operation{
+		Summary:     "Create a note in a workspace",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{workspaceID, idempotencyKeyParam()},
+		RequestBody: jsonBody(createPayload),
+		Responses: responses(
+			jsonResponse("201", "Note created", created),
+			jsonResponse("402", "Workspace storage quota exceeded", apiError),
+			notFound,
+			jsonResponse("409", "A request with the same Idempotency-Key is still in progress", apiError),
+			jsonResponse("413", "Content exceeds the configured size limit", apiError),
+			jsonResponse("422", "Invalid title, or Idempotency-Key already used for a different request", apiError),
+		),
+	})
+
+	b.add("get", "/workspaces/{id}/search", &Operation{
+		Summary: "Search a workspace's notes",
+		Description: "Owners and admins only. Matches q case-insensitively against the title and content of the " +
+			"workspace's active notes, most recently updated first. Results are trimmed to the notes you can open; " +
+			"encrypted notes, which the server cannot read, are never returned. Facets count every match by author " +
+			"and by the notebook and tags keys of the notes' metadata, after author, notebook and tag have narrowed " +
+			"the search.",
+		Tags:     []string{"workspaces"},
+		Security: bearer,
+		Parameters: []Parameter{
+			workspaceID,
+			{Name: "q", In: "query", Required: true, Description: "Text to search for", Schema: &Schema{Type: "string"}},
+			{Name: "author", In: "query", Description: "Only notes written by this user ID", Schema: &Schema{Type: "string"}},
+			{Name: "notebook", In: "query", Description: "Only notes in this notebook", Schema: &Schema{Type: "string"}},
+			{Name: "tag", In: "query", Description: "Only notes with this tag", Schema: &Schema{Type: "string"}},
+			{Name: "limit", In: "query", Description: fmt.Sprintf("Page size (default %d, max %d)", notes.DefaultSearchLimit, notes.MaxSearchLimit), Schema: &Schema{Type: "integer"}},
+			{Name: "offset", In: "query", Description: "Number of matches to skip", Schema: &Schema{Type: "integer"}},
+		},
+		Responses: responses(
+			jsonResponse("200", "A page of matching notes, the total and facets", b.schema("SearchResult", notes.SearchResult{})),
+			jsonResponse("400", "Missing q, or invalid limit or offset", apiError),
+			forbidden, notFound,
+		),
+	})
+
+	memberID := pathParam("userId", "User ID of the member")
+	b.add("patch", "/workspaces/{id}/members/{userId}", &Operation{
+		Summary:     "Change a member's role",
+		Description: "Owner only. Members can be made admins and admins made members.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{workspaceID, memberID},
+		RequestBody: jsonBody(b.schema("RolePayload", workspaces.RolePayload{})),
+		Responses: responses(
+			empty("204", "Role changed"),
+			jsonResponse("400", "The owner's role cannot be changed", apiError),
+			forbidden,
+			jsonResponse("404", "Workspace or member not found", apiError),
+			jsonResponse("422", "role is not admin or member", apiError),
+		),
+	})
+	b.add("delete", "/workspaces/{id}/members/{userId}", &Operation{
+		Summary: "Remove a member or leave a workspace",
+		Description: "Pass your own user ID to leave. The owner can remove anyone and admins can remove " +
+			"members; the owner cannot leave.",
+		Tags:       []string{"workspaces"},
+		Security:   bearer,
+		Parameters: []Parameter{workspaceID, memberID},
+		Responses: responses(
+			empty("204", "Member removed"),
+			jsonResponse("400", "The owner cannot leave", apiError),
+			forbidden,
+			jsonResponse("404", "Workspace or member not found", apiError),
+		),
+	})
+
+	b.add("get", "/workspaces/{id}/invites", &Operation{
+		Summary:    "List pending invitations",
+		Tags:       []string{"workspaces"},
+		Security:   bearer,
+		Parameters: []Parameter{workspaceID},
+		Responses:  responses(jsonResponse("200", "Unexpired invitations, newest first", arrayOf(invitation)), forbidden, notFound),
+	})
+	b.add("post", "/workspaces/{id}/invites", &Operation{
+		Summary: "Invite someone",
+		Description: "Owners and admins only. Creates an invitation link that expires after INVITE_TTL. With an " +
+			"email the link is mailed to that address and only an account with it can accept; without one the " +
+			"link is returned for sharing and can be used once.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{workspaceID},
+		RequestBody: jsonBody(b.schema("InvitationPayload", workspaces.InvitationPayload{})),
+		Responses: responses(
+			jsonResponse("201", "Invitation created; the token is not shown again", b.schema("IssuedInvitation", workspaces.IssuedInvitation{})),
+			forbidden, notFound,
+			jsonResponse("409", "Already a member or already invited", apiError),
+			jsonResponse("422", "Invalid email or role", apiError),
+		),
+	})
+	b.add("delete", "/workspaces/{id}/invites/{invitationId}", &Operation{
+		Summary:    "Revoke an invitation",
+		Tags:       []string{"workspaces"},
+		Security:   bearer,
+		Parameters: []Parameter{workspaceID, pathParam("invitationId", "Invitation ID")},
+		Responses: responses(
+			empty("204", "Invitation revoked"),
+			forbidden,
+			jsonResponse("404", "Workspace or invitation not found", apiError),
+		),
+	})
+
+	policy := b.schema("RetentionPolicy", retention.Policy{})
+	b.add("get", "/workspaces/{id}/retention", &Operation{
+		Summary:     "Get the retention policy",
+		Description: "Rules that are off are null. Workspaces without a policy have every rule off.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{workspaceID},
+		Responses:   responses(jsonResponse("200", "Retention policy", policy), notFound),
+	})
+	b.add("put", "/workspaces/{id}/retention", &Operation{
+		Summary: "Set the retention policy",
+		Description: "Owner or admin only. Every hour, notes that are neither pinned nor edited for archive_after_days " +
+			"are archived, archived notes not edited for purge_archived_after_days are deleted for good, and only " +
+			"the newest max_revisions revisions of each note are kept. Archiving doesn't count as an edit. Null " +
+			"turns a rule off.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{workspaceID},
+		RequestBody: jsonBody(policy),
+		Responses: responses(
+			jsonResponse("200", "Updated retention policy", policy),
+			jsonResponse("400", "Invalid request payload", apiError),
+			forbidden, notFound,
+			jsonResponse("422", "A rule is out of range", apiError),
+		),
+	})
+	b.add("post", "/workspaces/{id}/retention/preview", &Operation{
+		Summary: "Preview a retention policy",
+		Description: "Owner or admin only. Shows what the policy in the body, or the saved one when the body is " +
+			"empty, would archive, delete and trim if it were enforced now, listing up to 50 of the least recently " +
+			"edited notes for each rule. Nothing is changed.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{workspaceID},
+		RequestBody: &RequestBody{Content: map[string]MediaType{"application/json": {Schema: policy}}},
+		Responses: responses(
+			jsonResponse("200", "What the policy would change", b.schema("RetentionPreview", retention.Preview{})),
+			jsonResponse("400", "Invalid request payload", apiError),
+			forbidden, notFound,
+			jsonResponse("422", "A rule is out of range", apiError),
+		),
+	})
+
+	recording := b.schema("RecordingSettings", recordings.Settings{})
+	b.add("get", "/workspaces/{id}/recording", &Operation{
+		Summary:     "Get whether sessions are recorded",
+		Description: "Workspaces record no sessions until an owner or admin turns recording on.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{workspaceID},
+		Responses:   responses(jsonResponse("200", "Recording settings", recording), notFound),
+	})
+	b.add("put", "/workspaces/{id}/recording", &Operation{
+		Summary: "Turn session recording on or off",
+		Description: "Owner or admin only. While on, every edit made over the realtime socket to the workspace's " +
+			"notes is kept with when it was made, so GET /notes/{id}/sessions/{sessionID}/replay can play it back. " +
+			"Rooms already open keep going as they started.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{workspaceID},
+		RequestBody: jsonBody(recording),
+		Responses: responses(
+			jsonResponse("200", "Updated recording settings", recording),
+			jsonResponse("400", "Invalid request payload", apiError),
+			forbidden, notFound,
+			jsonResponse("422", "enabled is missing", apiError),
+		),
+	})
+
+	inviteToken := pathParam("token", "Token from the invitation link")
+	b.add("get", "/invites/{token}", &Operation{
+		Summary:     "Preview an invitation link",
+		Description: "Needs no authentication, so the web app can show the invitation before the user logs in or signs up.",
+		Tags:        []string{"workspaces"},
+		Parameters:  []Parameter{inviteToken},
+		Responses: responses(
+			jsonResponse("200", "The workspace and the address the invitation was sent to, if any", b.schema("InvitePreview", workspaces.InvitePreview{})),
+			jsonResponse("404", "Unknown invitation", apiError),
+			jsonResponse("410", "The invitation has expired", apiError),
+		),
+	})
+	b.add("post", "/invites/{token}/accept", &Operation{
+		Summary:     "Accept an invitation link",
+		Description: "New users accept by passing the token to POST /signup instead.",
+		Tags:        []string{"workspaces"},
+		Security:    bearer,
+		Parameters:  []Parameter{inviteToken},
+		Responses: responses(
+			jsonResponse("200", "You joined the workspace", b.ref("Joined")),
+			jsonResponse("403", "The invitation was sent to a different email address", apiError),
+			jsonResponse("404", "Unknown invitation", apiError),
+			jsonResponse("409", "You are already a member", apiError),
+			jsonResponse("410", "The invitation has expired", apiError),
+		),
+	})
+
+	invitationID := pathParam("id", "Invitation ID")
+	invitationNotFound := jsonResponse("404", "Invitation not found or not addressed to you", apiError)
+	b.add("get", "/me/invitations", &Operation{
+		Summary:   "List unexpired invitations addressed to your email",
+		Tags:      []string{"workspaces"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Invitations, newest first", arrayOf(invitation))),
+	})
+	b.add("post", "/invitations/{id}/accept", &Operation{
+		Summary:    "Accept an invitation",
+		Tags:       []string{"workspaces"},
+		Security:   bearer,
+		Parameters: []Parameter{invitationID},
+		Responses: responses(
+			jsonResponse("200", "You joined the workspace", b.ref("Joined")),
+			invitationNotFound,
+			jsonResponse("409", "You are already a member", apiError),
+			jsonResponse("410", "The invitation has expired", apiError),
+		),
+	})
+	b.add("post", "/invitations/{id}/decline", &Operation{
+		Summary:    "Decline an invitation",
+		Tags:       []string{"workspaces"},
+		Security:   bearer,
+		Parameters: []Parameter{invitationID},
+		Responses:  responses(empty("204", "Invitation declined"), invitationNotFound),
+	})
+}
+
+// addAdmin documents the /admin routes, which require the admin role
+func (b *builder) addAdmin(apiError *Schema) {
+	adminUser := b.schema("AdminUser", admin.User{})
+	userID := pathParam("id", "User ID")
+	forbidden := jsonResponse("403", "Caller is not an administrator", apiError)
+	notFound := jsonResponse("404", "User not found", apiError)
+
+	b.add("get", "/admin/stats", &Operation{
+		Summary: "Server statistics",
+		Description: "Totals of users, notes and workspaces, the realtime server's open rooms, connections and " +
+			"messages received in the last minute, and signups on each of the last days, for building a dashboard " +
+			"without a metrics system.",
+		Tags:     []string{"admin"},
+		Security: bearer,
+		Parameters: []Parameter{
+			{Name: "days", In: "query", Description: "Days of signups to include (default 30, max 365)", Schema: &Schema{Type: "integer"}},
+		},
+		Responses: responses(
+			jsonResponse("200", "Statistics", b.schema("AdminStats", admin.Stats{})),
+			jsonResponse("400", "Invalid days", apiError),
+			forbidden,
+		),
+	})
+	b.add("put", "/admin/maintenance", &Operation{
+		Summary: "Turn maintenance mode on or off",
+		Description: "Applies at once on the instance serving the request and within seconds on the others. " +
+			"ends_at, if known, sets the Retry-After clients are given; without it they are told to retry after a minute. " +
+			"This route and POST /login keep working during maintenance.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("MaintenanceUpdate", maintenance.Update{})),
+		Responses: responses(
+			jsonResponse("200", "The new maintenance state", b.schema("Maintenance", maintenance.State{})),
+			jsonResponse("422", "Message too long or ends_at in the past", apiError),
+			forbidden,
+		),
+	})
+	flag := b.schema("FeatureFlag", features.Flag{})
+	flagName := pathParam("name", "Flag name, e.g. notes.similar")
+	b.add("get", "/admin/features", &Operation{
+		Summary:   "List feature flags",
+		Tags:      []string{"admin"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Flags by name, with their overrides", arrayOf(flag)), forbidden),
+	})
+	b.add("put", "/admin/features/{name}", &Operation{
+		Summary: "Create or update a feature flag",
+		Description: "enabled turns the flag on for everyone. Otherwise it is on for rollout percent of users, " +
+			"chosen by a hash of the flag and user so raising the percentage only adds users. Overrides win over both.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		Parameters:  []Parameter{flagName},
+		RequestBody: jsonBody(b.schema("FeatureFlagUpdate", features.FlagUpdate{})),
+		Responses: responses(
+			jsonResponse("200", "The flag", flag),
+			jsonResponse("422", "Invalid name, description or rollout", apiError),
+			forbidden,
+		),
+	})
+	b.add("delete", "/admin/features/{name}", &Operation{
+		Summary:    "Delete a feature flag",
+		Tags:       []string{"admin"},
+		Security:   bearer,
+		Parameters: []Parameter{flagName},
+		Responses:  responses(empty("204", "Flag deleted; it now reads as off"), forbidden, notFound),
+	})
+	overrideParams := []Parameter{
+		flagName,
+		{Name: "scope", In: "path", Required: true, Description: "user or workspace", Schema: &Schema{Type: "string", Enum: []string{"user", "workspace"}}},
+		pathParam("target", "User or workspace ID"),
+	}
+	b.add("put", "/admin/features/{name}/overrides/{scope}/{target}", &Operation{
+		Summary: "Override a feature flag for a user or workspace",
+		Description: "A user's override wins over their workspace's, which wins over the flag's rollout. " +
+			"Workspace overrides apply where the client names the workspace.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		Parameters:  overrideParams,
+		RequestBody: jsonBody(b.schema("FeatureFlagOverrideUpdate", features.OverrideUpdate{})),
+		Responses: responses(
+			jsonResponse("200", "The flag", flag),
+			jsonResponse("404", "Flag, user or workspace not found", apiError),
+			jsonResponse("422", "Invalid scope", apiError),
+			forbidden,
+		),
+	})
+	b.add("delete", "/admin/features/{name}/overrides/{scope}/{target}", &Operation{
+		Summary:    "Remove a feature flag override",
+		Tags:       []string{"admin"},
+		Security:   bearer,
+		Parameters: overrideParams,
+		Responses:  responses(jsonResponse("200", "The flag", flag), forbidden, notFound),
+	})
+	b.add("get", "/admin/users", &Operation{
+		Summary:  "List or search users",
+		Tags:     []string{"admin"},
+		Security: bearer,
+		Parameters: []Parameter{
+			{Name: "q", In: "query", Description: "Case-insensitive match on email or display name", Schema: &Schema{Type: "string"}},
+			{Name: "limit", In: "query", Description: "Page size (default 50, max 200)", Schema: &Schema{Type: "integer"}},
+			{Name: "offset", In: "query", Description: "Number of users to skip", Schema: &Schema{Type: "integer"}},
+		},
+		Responses: responses(
+			jsonResponse("200", "Users, newest first, with note counts", arrayOf(adminUser)),
+			jsonResponse("400", "Invalid limit or offset", apiError),
+			forbidden,
+		),
+	})
+	b.add("get", "/admin/users/{id}", &Operation{
+		Summary:    "Get a user",
+		Tags:       []string{"admin"},
+		Security:   bearer,
+		Parameters: []Parameter{userID},
+		Responses:  responses(jsonResponse("200", "User", adminUser), forbidden, notFound),
+	})
+	b.add("delete", "/admin/users/{id}", &Operation{
+		Summary:     "Delete a user",
+		Description: "Soft-deletes the account like DELETE /me and closes its realtime sessions.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		Parameters:  []Parameter{userID},
+		Responses: responses(
+			empty("204", "User deleted"),
+			jsonResponse("400", "Cannot delete your own account here", apiError),
+			forbidden, notFound,
+		),
+	})
+	b.add("post", "/admin/users/{id}/lock", &Operation{
+		Summary:     "Lock a user out",
+		Description: "Revokes the user's tokens and refuses logins with 423 until the given time.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		Parameters:  []Parameter{userID},
+		RequestBody: jsonBody(b.schema("LockRequest", admin.LockRequest{})),
+		Responses: responses(
+			empty("204", "User locked"),
+			jsonResponse("400", "Cannot lock your own account", apiError),
+			forbidden, notFound,
+			jsonResponse("422", "until is not in the next 5 years", apiError),
+		),
+	})
+	b.add("post", "/admin/users/{id}/unlock", &Operation{
+		Summary:     "Unlock a user",
+		Description: "Lifts an administrator lock or a lock from failed logins.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		Parameters:  []Parameter{userID},
+		Responses:   responses(empty("204", "User unlocked"), forbidden, notFound),
+	})
+	b.add("post", "/admin/users/{id}/password-reset", &Operation{
+		Summary:     "Force a password reset",
+		Description: "Replaces the password with a temporary one, revokes the user's tokens and clears any lock.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		Parameters:  []Parameter{userID},
+		Responses: responses(
+			jsonResponse("200", "The temporary password, shown once", b.schema("PasswordReset", admin.PasswordReset{})),
+			forbidden, notFound,
+		),
+	})
+	b.add("get", "/admin/security-events", &Operation{
+		Summary:     "Search the audit log",
+		Description: "Security events across all users. Failed logins for unknown emails have no user_id.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		Parameters: []Parameter{
+			{Name: "user_id", In: "query", Description: "Only events concerning this user", Schema: &Schema{Type: "string"}},
+			{Name: "event", In: "query", Description: "Only events of this kind, such as login_failed", Schema: &Schema{Type: "string"}},
+			{Name: "limit", In: "query", Description: "Page size (default 50, max 200)", Schema: &Schema{Type: "integer"}},
+			{Name: "offset", In: "query", Description: "Number of events to skip", Schema: &Schema{Type: "integer"}},
+		},
+		Responses: responses(
+			jsonResponse("200", "Events, newest first", arrayOf(b.ref("AuditEntry"))),
+			jsonResponse("400", "Invalid limit or offset", apiError),
+			forbidden,
+		),
+	})
+
+	backupSchema := b.schema("Backup", backup.Backup{})
+	restore := b.schema("Restore", backup.Restore{})
+	b.add("get", "/admin/backups", &Operation{
+		Summary: "List backups",
+		Description: "The 100 most recent archives of users, workspaces, notes and revisions, taken on a schedule " +
+			"or on demand and kept in the configured storage. Older successful backups are pruned.",
+		Tags:      []string{"admin"},
+		Security:  bearer,
+		Responses: responses(jsonResponse("200", "Backups, newest first", arrayOf(backupSchema)), forbidden),
+	})
+	b.add("post", "/admin/backups", &Operation{
+		Summary:     "Take a backup",
+		Description: "The backup is written in the background; list backups to see when its status is done.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		Responses:   responses(jsonResponse("202", "Backup started", backupSchema), forbidden),
+	})
+	b.add("post", "/admin/restores", &Operation{
+		Summary: "Restore a backup",
+		Description: "Puts back the rows of a backup archive that are missing from the database, such as deleted " +
+			"notes or, after losing the database, everything. Rows that still exist are left as they are. Give the " +
+			"archive by backup_id, or by storage_key when the database holding the list of backups was lost too. " +
+			"The restore runs in the background; poll it for progress.",
+		Tags:        []string{"admin"},
+		Security:    bearer,
+		RequestBody: jsonBody(b.schema("RestorePayload", backup.RestorePayload{})),
+		Responses: responses(
+			jsonResponse("202", "Restore started", restore),
+			jsonResponse("400", "Invalid request payload", apiError),
+			forbidden,
+			jsonResponse("404", "Backup not found", apiError),
+			jsonResponse("409", "Backup is not finished", apiError),
+			jsonResponse("422", "Neither or both of backup_id and storage_key given, or storage_key is not an archive", apiError),
+		),
+	})
+	b.add("get", "/admin/restores/{id}", &Operation{
+		Summary: "Get a restore's progress",
+		Description: "status is running until the archive has been loaded, then done, or failed with error set " +
+			"if it could not be read or a row could not be written.",
+		Tags:       []string{"admin"},
+		Security:   bearer,
+		Parameters: []Parameter{pathParam("id", "Restore ID")},
+		Responses: responses(
+			jsonResponse("200", "Restore", restore),
+			forbidden,
+			jsonResponse("404", "Restore not found", apiError),
+		),
+	})
+}
+
+// addWebSocket documents the ticket exchange and the upgrade routes. The
+// frames exchanged after the upgrade are listed as component schemas.
+func (b *builder) addWebSocket() {
+	b.schema("IncomingMessage", realtime.IncomingMessage{})
+	b.schema("PresenceMessage", realtime.PresenceMessage{})
+	b.schema("PresenceListMessage", realtime.PresenceListMessage{})
+	b.schema("CursorMessage", realtime.CursorMessage{})
+	b.schema("TypingMessage", realtime.TypingMessage{})
+	b.schema("ActivityMessage", activity.Message{})
+	b.schema("NotificationMessage", notifications.Message{})
+	b.schema("WelcomeMessage", realtime.WelcomeMessage{})
+	b.schema("EditMessage", realtime.EditMessage{})
+	b.schema("HistoryMessage", realtime.HistoryMessage{})
+	b.schema("LockMessage", realtime.LockMessage{})
+	b.schema("SessionMessage", realtime.SessionMessage{})
+	b.schema("EditAckMessage", realtime.EditAckMessage{})
+	b.schema("BatchMessage", realtime.BatchMessage{})
+	b.schema("ReceiptMessage", notes.ReceiptMessage{})
+	b.schema("AppearanceMessage", notes.AppearanceMessage{})
+	b.schema("RoleMessage", realtime.RoleMessage{})
+	b.schema("NoteDeletedMessage", realtime.NoteDeletedMessage{})
+	b.schema("RealtimeError", realtime.ErrorMessage{})
+
+	b.add("post", "/ws/ticket", &Operation{
+		Summary:     "Issue a WebSocket ticket",
+		Description: "Browsers cannot set headers on WebSocket requests, so exchange the JWT for a short-lived, single-use ticket and pass it as ?ticket= when connecting.",
+		Tags:        []string{"realtime"},
+		Security:    bearer,
+		Responses: responses(jsonResponse("201", "Ticket issued", b.schema("Ticket", struct {
+			Ticket    string `json:"ticket"`
+			ExpiresIn int    `json:"expires_in"`
+		}{}))),
+	})
+
+	wsAuth := []Parameter{
+		{Name: "ticket", In: "query", Description: "Ticket from POST /ws/ticket", Schema: &Schema{Type: "string"}},
+		{Name: "token", In: "query", Description: "JWT, for clients that cannot use tickets", Schema: &Schema{Type: "string"}},
+	}
+	upgrade := responses(
+		empty("101", "Switching protocols"),
+		jsonResponse("401", "Missing, invalid or expired ticket or token", b.ref("Error")),
+		jsonResponse("403", "Browser origin not allowed; only the server's own origin and WS_ALLOWED_ORIGINS (default CORS_ALLOWED_ORIGINS) may connect", b.ref("Error")),
+		jsonResponse("426", "Not a WebSocket upgrade request", b.ref("Error")),
+	)
+
+	b.add("get", "/ws/notes/{id}", &Operation{
+		Summary: "Join a note's collaboration room",
+		Description: "Clients send IncomingMessage frames (edit, cursor, typing). The server sends the roster " +
+			"(PresenceListMessage), the note's LockMessage and a HistoryMessage replaying recent edits on join, then PresenceMessage, " +
+			"CursorMessage, TypingMessage, ActivityMessage, ReceiptMessage, AppearanceMessage and EditMessage frames from other collaborators, and a LockMessage " +
+			"whenever the note is locked or unlocked, and a RoleMessage when a collaborator's role changes. Cursor and typing " +
+			"updates are collected for WS_COALESCE_INTERVAL (default 75ms) and sent together as a BatchMessage holding them " +
+			"oldest first, keeping only each connection's latest cursor; a single update is sent on its own. Viewers and " +
+			"commenters have read-only connections whose edits are answered with a RealtimeError whose code is " +
+			"read_only. While someone else holds the lock, edits are answered with a RealtimeError whose code is note_locked, " +
+			"and during maintenance with one whose code is maintenance. " +
+			"When the note is deleted, the room receives a NoteDeletedMessage " +
+			"(note:deleted), edits are answered with a RealtimeError whose code is note_deleted, new joins are refused and, " +
+			"close_in seconds later (WS_DELETED_GRACE, default 30s), every connection is closed with code 4004 (note_deleted). " +
+			"Instead of the full content, an edit may carry ops ({\"at\":n,\"insert\":\"text\"} or {\"at\":n,\"delete\":count}, " +
+			"offsets in code points, applied in order) made against the revision given as base. The server applies them to its " +
+			"copy of the note, broadcasts the ops and answers the sender with an EditAckMessage carrying the new revision; ops " +
+			"out of bounds, based on an older revision or sent before the server knows the note's content are answered with " +
+			"a RealtimeError whose code is invalid_op, stale_revision or document_unknown. " +
+			"Reconnecting clients pass the last edit revision they applied as ?since= to receive only what they missed. " +
+			"Every join is also sent a SessionMessage with a resume_token; reconnecting within resume_grace seconds " +
+			"(WS_RESUME_GRACE, default 15s) with ?resume=<token> keeps the user's presence, so the room sees no leave or join. " +
+			"Refused and ended connections get a final RealtimeError frame, then a close code clients can act on: 4001 when " +
+			"not signed in or signed out (unauthorized), 4004 when the note doesn't exist or the user cannot access it " +
+			"(note_not_found), 4008 for a malformed request (bad_request), and 1013 when the server cannot take the " +
+			"connection now and a retry may succeed (internal_error, too_many_connections, room_full). " +
+			"A user may have WS_MAX_CONNECTIONS_PER_USER note connections open (default 5) and a note WS_MAX_CONNECTIONS_PER_ROOM " +
+			"(default 100); a connection over either limit is sent a RealtimeError whose code is too_many_connections or room_full, " +
+			"then closed with code 1013. " +
+			"A room that has gone WS_ROOM_IDLE_TIMEOUT (default 10m) without messages has its document saved to the note, " +
+			"unless the note was saved since, and its edit history dropped, so delta edits are answered with document_unknown " +
+			"until an edit carries the full content; connections silent as long, pongs included, are closed. " +
+			"Frames are JSON text by default; clients that ask for the quanta.msgpack subprotocol in Sec-WebSocket-Protocol " +
+			"exchange the same messages MessagePack-encoded in binary frames instead (quanta.json selects JSON explicitly). " +
+			"Every frame carries the protocol version in v. Clients may open with {\"type\":\"hello\",\"versions\":[...]} " +
+			"and receive a WelcomeMessage with the agreed version; frames with an unsupported v are answered with a " +
+			"RealtimeError whose code is unsupported_version.",
+		Tags: []string{"realtime"},
+		Parameters: append([]Parameter{
+			pathParam("id", "Note ID"),
+			{Name: "since", In: "query", Description: "Last edit revision the client applied", Schema: &Schema{Type: "integer"}},
+			{Name: "resume", In: "query", Description: "resume_token from the SessionMessage of the connection that dropped", Schema: &Schema{Type: "string"}},
+		}, wsAuth...),
+		Responses: upgrade,
+	})
+	b.add("get", "/ws/notifications", &Operation{
+		Summary:     "Receive notifications live",
+		Description: "Push-only channel of NotificationMessage frames for the authenticated user.",
+		Tags:        []string{"notifications"},
+		Parameters:  wsAuth,
+		Responses:   upgrade,
+	})
+}
+
+// builder accumulates paths and component schemas
+type builder struct {
+	doc Document
+}
+
+func (b *builder) add(method, path string, op *Operation) {
+	item, ok := b.doc.Paths[path]
+	if !ok {
+		item = PathItem{}
+		b.doc.Paths[path] = item
+	}
+	item[method] = op
+}
+
+// schema registers v's schema as a named component and returns a reference
+func (b *builder) schema(name string, v any) *Schema {
+	b.doc.Components.Schemas[name] = schemaOf(v)
+	return b.ref(name)
+}
+
+func (b *builder) ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func jsonBody(s *Schema) *RequestBody {
+	return &RequestBody{Required: true, Content: map[string]MediaType{"application/json": {Schema: s}}}
+}
+
+func pathParam(name, description string) Parameter {
+	return Parameter{Name: name, In: "path", Required: true, Description: description, Schema: &Schema{Type: "string"}}
+}
+
+func limitParam() Parameter {
+	return Parameter{
+		Name: "limit", In: "query", Description: "Maximum number of entries (default 50, max 200)",
+		Schema: &Schema{Type: "integer"},
+	}
+}
+
+func updatedSinceParam() Parameter {
+	return Parameter{
+		Name: "updated_since", In: "query",
+		Description: "Only list notes updated after this RFC 3339 time, newest first",
+		Schema:      &Schema{Type: "string", Format: "date-time"},
+	}
+}
+
+func colorParam() Parameter {
+	return Parameter{
+		Name: "color", In: "query", Description: "Only list notes with this color label",
+		Schema: &Schema{Type: "string", Enum: models.NoteColors},
+	}
+}
+
+func metaFilterParam() Parameter {
+	return Parameter{
+		Name: "meta.{key}", In: "query",
+		Description: "Only list notes whose metadata has this value at key, a dotted path such as meta.project=alpha. " +
+			"Arrays match when any item does. Repeat with other keys to require them all.",
+		Schema: &Schema{Type: "string"},
+	}
+}
+
+func idempotencyKeyParam() Parameter {
+	return Parameter{
+		Name: "Idempotency-Key", In: "header",
+		Description: "Any unique string up to 255 characters. A retry with the same key within 24 hours gets the first " +
+			"response back, with Idempotent-Replayed: true, instead of running again. A first request that never " +
+			"answers holds the key for 5 minutes at most.",
+		Schema: &Schema{Type: "string"},
+	}
+}
+
+func arrayOf(s *Schema) *Schema {
+	return &Schema{Type: "array", Items: s}
+}
+
+// statusResponse pairs a status code with its response
+type statusResponse struct {
+	code     string
+	response Response
+}
+
+func jsonResponse(code, description string, s *Schema) statusResponse {
+	return statusResponse{code, Response{
+		Description: description,
+		Content:     map[string]MediaType{"application/json": {Schema: s}},
+	}}
+}
+
+func empty(code, description string) statusResponse {
+	return statusResponse{code, Response{Description: description}}
+}
+
+func binaryResponse(code, description string) statusResponse {
+	return statusResponse{code, Response{
+		Description: description,
+		Content:     map[string]MediaType{"application/octet-stream": {Schema: &Schema{Type: "string", Format: "binary"}}},
+	}}
+}
+
+func responses(rs ...statusResponse) map[string]Response {
+	out := make(map[string]Response, len(rs))
+	for _, r := range rs {
+		out[r.code] = r.response
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+// Package features provides feature flags for rolling features out
+// gradually. A flag is on for everyone, for a percentage of users, or for
+// particular users and workspaces through overrides. Flags live in the
+// database and are evaluated from a copy in memory, reloaded periodically
+// so changes made on other instances are picked up.
+package features
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultRefresh is how often flags are reloaded from the database
+const DefaultRefresh = 30 * time.Second
+
+// Scope is what an override applies to
+type Scope string
+
+const (
+	// ScopeUser overrides a flag for one user
+	ScopeUser Scope = "user"
+	// ScopeWorkspace overrides a flag for everyone working in a workspace
+	ScopeWorkspace Scope = "workspace"
+)
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// state is a flag as evaluated
+type state struct {
+	enabled    bool
+	rollout    int
+	users      map[string]bool
+	workspaces map[string]bool
+}
+
+// Flags evaluates feature flags from the copy last loaded by Reload
+type Flags struct {
+	db    DBInterface
+	flags atomic.Pointer[map[string]*state]
+}
+
+// New creates a set of flags, all off until Reload or StartReloader runs
+func New(db DBInterface) *Flags {
+	f := &Flags{db: db}
+	f.flags.Store(&map[string]*state{})
+	return f
+}
+
+// Reload replaces the flags with those in the database. On error the
+// previous flags stay in force.
+func (f *Flags) Reload(ctx context.Context) error {
+	flags := map[string]*state{}
+	rows, err := f.db.QueryContext(ctx, "SELECT name, enabled, rollout FROM feature_flags")
+	if err != nil {
+		return fmt.Errorf("fetching feature flags: %w", err)
+	}
+	for rows.Next() {
+		var name string
+		s := &state{users: map[string]bool{}, workspaces: map[string]bool{}}
+		if err := rows.Scan(&name, &s.enabled, &s.rollout); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("scanning feature flags: %w", err)
+		}
+		flags[name] = s
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("fetching feature flags: %w", err)
+	}
+
+	rows, err = f.db.QueryContext(ctx, "SELECT flag, scope, target_id, enabled FROM feature_flag_overrides")
+	if err != nil {
+		return fmt.Errorf("fetching feature flag overrides: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var name, targetID string
+		var scope Scope
+		var enabled bool
+		if err := rows.Scan(&name, &scope, &targetID, &enabled); err != nil {
+			return fmt.Errorf("scanning feature flag overrides: %w", err)
+		}
+		s, ok := flags[name]
+		if !ok {
+			continue
+		}
+		switch scope {
+		case ScopeUser:
+			s.users[targetID] = enabled
+		case ScopeWorkspace:
+			s.workspaces[targetID] = enabled
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching feature flag overrides: %w", err)
+	}
+
+	f.flags.Store(&flags)
+	return nil
+}
+
+// StartReloader reloads the flags every interval until stop is closed.
+// It blocks, so run it in its own goroutine.
+func (f *Flags) StartReloader(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := f.Reload(ctx); err != nil {
+				log.Println("Error reloading feature flags:", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Enabled reports whether a flag is on for a user working in a workspace;
+// either may be empty. A user's override wins over their workspace's,
+// which wins over the flag's rollout. Unknown flags are off.
+func (f *Flags) Enabled(name, userID, workspaceID string) bool {
+	s, ok := (*f.flags.Load())[name]
+	if !ok {
+		return false
+	}
+	if on, ok := s.users[userID]; ok && userID != "" {
+		return on
+	}
+	if on, ok := s.workspaces[workspaceID]; ok && workspaceID != "" {
+		return on
+	}
+	if s.enabled {
+		return true
+	}
+	return userID != "" && bucket(name, userID) < s.rollout
+}
+
+// Names returns every flag's name with whether it is on for the user
+// working in the workspace
+func (f *Flags) Names(userID, workspaceID string) map[string]bool {
+	flags := *f.flags.Load()
+	on := make(map[string]bool, len(flags))
+	for name := range flags {
+		on[name] = f.Enabled(name, userID, workspaceID)
+	}
+	return on
+}
+
+// bucket places a user in one of 100 buckets for a flag. Each flag places
+// users independently, so the same users aren't always the first to get
+// every feature, and raising the rollout only adds users.
+func bucket(name, userID string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name + ":" + userID))
+	return int(h.Sum32() % 100)
+}
+
+// Require returns a middleware that answers 404 unless the flag is on for
+// the authenticated user, as if the route didn't exist. It must run after
+// Protected. Routes that also depend on a workspace call Enabled instead.
+func (f *Flags) Require(name string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, _ := auth.UserIDFromCtx(c)
+		if !f.Enabled(name, userID, "") {
+			return apperr.New(fiber.StatusNotFound, "Feature not available")
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,129 @@
+package features
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	flagsQuery     = regexp.QuoteMeta("SELECT name, enabled, rollout FROM feature_flags")
+	overridesQuery = regexp.QuoteMeta("SELECT flag, scope, target_id, enabled FROM feature_flag_overrides")
+)
+
+// loaded returns flags loaded from the given rows
+func loaded(t *testing.T, flags, overrides *sqlmock.Rows) *Flags {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	mockDB.ExpectQuery(flagsQuery).WillReturnRows(flags)
+	mockDB.ExpectQuery(overridesQuery).WillReturnRows(overrides)
+
+	f := New(db)
+	require.NoError(t, f.Reload(context.Background()))
+	require.NoError(t, mockDB.ExpectationsWereMet())
+	return f
+}
+
+func TestEnabled(t *testing.T) {
+	f := loaded(t,
+		sqlmock.NewRows([]string{"name", "enabled", "rollout"}).
+			AddRow("everyone", true, 0).
+			AddRow("beta", false, 0),
+		sqlmock.NewRows([]string{"flag", "scope", "target_id", "enabled"}).
+			AddRow("beta", "workspace", "ws1", true).
+			AddRow("beta", "user", "u2", false).
+			AddRow("everyone", "user", "u3", false).
+			AddRow("gone", "user", "u1", true),
+	)
+
+	testCases := []struct {
+		name, flag, userID, workspaceID string
+		expected                        bool
+	}{
+		{name: "enabled for everyone", flag: "everyone", userID: "u1", expected: true},
+		{name: "without a user", flag: "everyone", expected: true},
+		{name: "user override off", flag: "everyone", userID: "u3", expected: false},
+		{name: "off", flag: "beta", userID: "u1", expected: false},
+		{name: "workspace override", flag: "beta", userID: "u1", workspaceID: "ws1", expected: true},
+		{name: "user override wins over workspace", flag: "beta", userID: "u2", workspaceID: "ws1", expected: false},
+		{name: "other workspace", flag: "beta", userID: "u1", workspaceID: "ws2", expected: false},
+		{name: "unknown flag", flag: "gone", userID: "u1", expected: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, f.Enabled(tc.flag, tc.userID, tc.workspaceID))
+		})
+	}
+
+	assert.Equal(t, map[string]bool{"everyone": true, "beta": true}, f.Names("u1", "ws1"))
+}
+
+func TestEnabled_Rollout(t *testing.T) {
+	f := loaded(t,
+		sqlmock.NewRows([]string{"name", "enabled", "rollout"}).AddRow("half", false, 50).AddRow("none", false, 0),
+		sqlmock.NewRows([]string{"flag", "scope", "target_id", "enabled"}),
+	)
+
+	on := 0
+	for i := range 1000 {
+		userID := fmt.Sprintf("user-%d", i)
+		if f.Enabled("half", userID, "") {
+			on++
+			// A user keeps the feature once they have it
+			assert.True(t, f.Enabled("half", userID, ""))
+		}
+		assert.False(t, f.Enabled("none", userID, ""))
+	}
+	assert.InDelta(t, 500, on, 75)
+	// Rollouts need a user to place
+	assert.False(t, f.Enabled("half", "", ""))
+}
+
+func TestReload_KeepsFlagsOnError(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	f := New(db)
+
+	mockDB.ExpectQuery(flagsQuery).WillReturnRows(sqlmock.NewRows([]string{"name", "enabled", "rollout"}).AddRow("beta", true, 0))
+	mockDB.ExpectQuery(overridesQuery).WillReturnRows(sqlmock.NewRows([]string{"flag", "scope", "target_id", "enabled"}))
+	require.NoError(t, f.Reload(context.Background()))
+
+	mockDB.ExpectQuery(flagsQuery).WillReturnError(errors.New("connection refused"))
+	assert.Error(t, f.Reload(context.Background()))
+	assert.True(t, f.Enabled("beta", "u1", ""))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestRequire(t *testing.T) {
+	f := loaded(t,
+		sqlmock.NewRows([]string{"name", "enabled", "rollout"}).AddRow("beta", false, 0),
+		sqlmock.NewRows([]string{"flag", "scope", "target_id", "enabled"}).AddRow("beta", "user", "tester", true),
+	)
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", c.Get("X-User"))
+		return c.Next()
+	})
+	app.Get("/beta", f.Require("beta"), func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	for user, status := range map[string]int{"tester": fiber.StatusOK, "someone": fiber.StatusNotFound} {
+		req := httptest.NewRequest("GET", "/beta", nil)
+		req.Header.Set("X-User", user)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode, user)
+	}
+}
@@ -0,0 +1,364 @@
+package features
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxDescriptionLength is the longest description a flag can have
+const MaxDescriptionLength = 255
+
+// validName matches flag names: lowercase words joined by dots, dashes or
+// underscores, such as "notes.similar"
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// Flag is a feature flag as administrators see it
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Enabled turns the flag on for everyone
+	Enabled bool `json:"enabled"`
+	// Rollout is the percentage of users the flag is on for when it isn't
+	// enabled for everyone
+	Rollout   int        `json:"rollout"`
+	Overrides []Override `json:"overrides"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Override turns a flag on or off for one user or workspace
+type Override struct {
+	Scope    Scope  `json:"scope"`
+	TargetID string `json:"target_id"`
+	Enabled  bool   `json:"enabled"`
+}
+
+// FlagUpdate is the request body for UpdateFlag
+type FlagUpdate struct {
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	Rollout     int    `json:"rollout"`
+}
+
+// OverrideUpdate is the request body for UpdateOverride
+type OverrideUpdate struct {
+	Enabled bool `json:"enabled"`
+}
+
+// HandlerDB defines the methods for database operations the handlers need
+type HandlerDB interface {
+	DBInterface
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Handler handles HTTP requests related to feature flags
+type Handler struct {
+	db    HandlerDB
+	flags *Flags
+	audit AuditLogger
+}
+
+// NewHandler creates a new Handler that reloads flags after every change
+// and records changes in the audit log
+func NewHandler(db HandlerDB, flags *Flags, auditLog AuditLogger) *Handler {
+	return &Handler{db: db, flags: flags, audit: auditLog}
+}
+
+// GetMyFeatures returns whether each flag is on for the authenticated
+// user, within the workspace given by ?workspace_id= if any
+func (h *Handler) GetMyFeatures(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	workspaceID := c.Query("workspace_id")
+	if workspaceID != "" {
+		var one int
+		err := h.db.QueryRowContext(c.UserContext(),
+			"SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user_id = ?", workspaceID, userID,
+		).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Workspace not found")
+		}
+		if err != nil {
+			return fmt.Errorf("checking workspace membership: %w", err)
+		}
+	}
+
+	return c.JSON(h.flags.Names(userID, workspaceID))
+}
+
+// ListFlags returns every flag with its overrides, by name
+func (h *Handler) ListFlags(c *fiber.Ctx) error {
+	flags, err := h.list(c.UserContext(), "")
+	if err != nil {
+		return err
+	}
+	return c.JSON(flags)
+}
+
+// UpdateFlag creates or replaces a flag
+func (h *Handler) UpdateFlag(c *fiber.Ctx) error {
+	name := c.Params("name")
+	var payload FlagUpdate
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	fields := map[string]string{}
+	if !validName.MatchString(name) {
+		fields["name"] = "must be up to 64 lowercase letters, digits, dots, dashes or underscores"
+	}
+	if utf8.RuneCountInString(payload.Description) > MaxDescriptionLength {
+		fields["description"] = fmt.Sprintf("must be at most %d characters", MaxDescriptionLength)
+	}
+	if payload.Rollout < 0 || payload.Rollout > 100 {
+		fields["rollout"] = "must be a percentage from 0 to 100"
+	}
+	if len(fields) > 0 {
+		return apperr.Invalid(fields)
+	}
+
+	ctx := c.UserContext()
+	res, err := h.db.ExecContext(ctx,
+		"UPDATE feature_flags SET description = ?, enabled = ?, rollout = ?, updated_at = ? WHERE name = ?",
+		payload.Description, payload.Enabled, payload.Rollout, time.Now().UTC(), name,
+	)
+	if err != nil {
+		return fmt.Errorf("updating feature flag: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("updating feature flag: %w", err)
+	} else if n == 0 {
+		_, err = h.db.ExecContext(ctx,
+			"INSERT INTO feature_flags (name, description, enabled, rollout) VALUES (?, ?, ?, ?)",
+			name, payload.Description, payload.Enabled, payload.Rollout,
+		)
+		if err != nil && !db.IsDuplicate(err) {
+			return fmt.Errorf("creating feature flag: %w", err)
+		}
+	}
+
+	h.log(c, name, map[string]string{
+		"enabled": strconv.FormatBool(payload.Enabled),
+		"rollout": strconv.Itoa(payload.Rollout),
+	})
+	return h.respond(c, name)
+}
+
+// DeleteFlag removes a flag and its overrides. Code asking for it then
+// finds it off.
+func (h *Handler) DeleteFlag(c *fiber.Ctx) error {
+	name := c.Params("name")
+	ctx := c.UserContext()
+	res, err := h.db.ExecContext(ctx, "DELETE FROM feature_flags WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("deleting feature flag: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("deleting feature flag: %w", err)
+	} else if n == 0 {
+		return apperr.New(fiber.StatusNotFound, "Feature flag not found")
+	}
+
+	h.log(c, name, map[string]string{"deleted": "true"})
+	h.reload(ctx)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// UpdateOverride turns a flag on or off for the user or workspace in the
+// path, whatever the flag's rollout
+func (h *Handler) UpdateOverride(c *fiber.Ctx) error {
+	name, scope, targetID := c.Params("name"), Scope(c.Params("scope")), c.Params("target")
+	var payload OverrideUpdate
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if scope != ScopeUser && scope != ScopeWorkspace {
+		return apperr.Invalid(map[string]string{"scope": "must be user or workspace"})
+	}
+
+	ctx := c.UserContext()
+	if err := h.requireFlag(ctx, name); err != nil {
+		return err
+	}
+	if err := h.requireTarget(ctx, scope, targetID); err != nil {
+		return err
+	}
+
+	res, err := h.db.ExecContext(ctx,
+		"UPDATE feature_flag_overrides SET enabled = ? WHERE flag = ? AND scope = ? AND target_id = ?",
+		payload.Enabled, name, string(scope), targetID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating feature flag override: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("updating feature flag override: %w", err)
+	} else if n == 0 {
+		_, err = h.db.ExecContext(ctx,
+			"INSERT INTO feature_flag_overrides (flag, scope, target_id, enabled) VALUES (?, ?, ?, ?)",
+			name, string(scope), targetID, payload.Enabled,
+		)
+		if err != nil && !db.IsDuplicate(err) {
+			return fmt.Errorf("creating feature flag override: %w", err)
+		}
+	}
+
+	h.log(c, name, map[string]string{
+		"scope":     string(scope),
+		"target_id": targetID,
+		"enabled":   strconv.FormatBool(payload.Enabled),
+	})
+	return h.respond(c, name)
+}
+
+// DeleteOverride removes an override, so the flag's rollout decides again
+func (h *Handler) DeleteOverride(c *fiber.Ctx) error {
+	name, scope, targetID := c.Params("name"), c.Params("scope"), c.Params("target")
+	ctx := c.UserContext()
+	res, err := h.db.ExecContext(ctx,
+		"DELETE FROM feature_flag_overrides WHERE flag = ? AND scope = ? AND target_id = ?", name, scope, targetID,
+	)
+	if err != nil {
+		return fmt.Errorf("deleting feature flag override: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("deleting feature flag override: %w", err)
+	} else if n == 0 {
+		return apperr.New(fiber.StatusNotFound, "Feature flag override not found")
+	}
+
+	h.log(c, name, map[string]string{"scope": scope, "target_id": targetID, "override": "removed"})
+	return h.respond(c, name)
+}
+
+// requireFlag checks a flag exists
+func (h *Handler) requireFlag(ctx context.Context, name string) error {
+	var one int
+	err := h.db.QueryRowContext(ctx, "SELECT 1 FROM feature_flags WHERE name = ?", name).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.New(fiber.StatusNotFound, "Feature flag not found")
+	}
+	if err != nil {
+		return fmt.Errorf("fetching feature flag: %w", err)
+	}
+	return nil
+}
+
+// requireTarget checks the user or workspace an override is for exists
+func (h *Handler) requireTarget(ctx context.Context, scope Scope, targetID string) error {
+	query, notFound := "SELECT 1 FROM users WHERE id = ?", "User not found"
+	if scope == ScopeWorkspace {
+		query, notFound = "SELECT 1 FROM workspaces WHERE id = ?", "Workspace not found"
+	}
+	var one int
+	err := h.db.QueryRowContext(ctx, query, targetID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.New(fiber.StatusNotFound, notFound)
+	}
+	if err != nil {
+		return fmt.Errorf("fetching override target: %w", err)
+	}
+	return nil
+}
+
+// respond reloads the flags so a change applies straight away on this
+// instance, then returns the named flag
+func (h *Handler) respond(c *fiber.Ctx, name string) error {
+	ctx := c.UserContext()
+	h.reload(ctx)
+	flags, err := h.list(ctx, name)
+	if err != nil {
+		return err
+	}
+	if len(flags) == 0 {
+		return apperr.New(fiber.StatusNotFound, "Feature flag not found")
+	}
+	return c.JSON(flags[0])
+}
+
+// reload refreshes the flags, leaving other instances to pick the change
+// up on their next reload if it fails
+func (h *Handler) reload(ctx context.Context) {
+	if err := h.flags.Reload(ctx); err != nil {
+		log.Println("Error reloading feature flags:", err)
+	}
+}
+
+// log records a change to a flag in the audit log
+func (h *Handler) log(c *fiber.Ctx, name string, details map[string]string) {
+	userID, _ := auth.UserIDFromCtx(c)
+	entry := audit.FromRequest(c, audit.EventFeatureFlagUpdated, userID)
+	details["flag"] = name
+	entry.Details = details
+	h.audit.Log(c.UserContext(), entry)
+}
+
+// list fetches the flags with their overrides, or just the named one
+func (h *Handler) list(ctx context.Context, name string) ([]Flag, error) {
+	query, args := "SELECT name, description, enabled, rollout, updated_at FROM feature_flags", []any{}
+	if name != "" {
+		query, args = query+" WHERE name = ?", append(args, name)
+	}
+	rows, err := h.db.QueryContext(ctx, query+" ORDER BY name", args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing feature flags: %w", err)
+	}
+	flags := []Flag{}
+	index := map[string]int{}
+	for rows.Next() {
+		f := Flag{Overrides: []Override{}}
+		if err := rows.Scan(&f.Name, &f.Description, &f.Enabled, &f.Rollout, &f.UpdatedAt); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("scanning feature flags: %w", err)
+		}
+		index[f.Name] = len(flags)
+		flags = append(flags, f)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("listing feature flags: %w", err)
+	}
+	if len(flags) == 0 {
+		return flags, nil
+	}
+
+	query = "SELECT flag, scope, target_id, enabled FROM feature_flag_overrides"
+	if name != "" {
+		query += " WHERE flag = ?"
+	}
+	rows, err = h.db.QueryContext(ctx, query+" ORDER BY flag, scope, target_id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("listing feature flag overrides: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var flag string
+		var o Override
+		if err := rows.Scan(&flag, &o.Scope, &o.TargetID, &o.Enabled); err != nil {
+			return nil, fmt.Errorf("scanning feature flag overrides: %w", err)
+		}
+		if i, ok := index[flag]; ok {
+			flags[i].Overrides = append(flags[i].Overrides, o)
+		}
+	}
+	return flags, rows.Err()
+}
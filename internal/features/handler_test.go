@@ -0,0 +1,236 @@
+package features
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	flagCols     = []string{"name", "description", "enabled", "rollout", "updated_at"}
+	overrideCols = []string{"flag", "scope", "target_id", "enabled"}
+)
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+type testHelper struct {
+	mockDB sqlmock.Sqlmock
+	app    *fiber.App
+	flags  *Flags
+	audit  *fakeAudit
+}
+
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	flags := New(db)
+	auditLog := &fakeAudit{}
+	handler := NewHandler(db, flags, auditLog)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "admin1")
+		return c.Next()
+	})
+	app.Get("/me/features", handler.GetMyFeatures)
+	app.Get("/admin/features", handler.ListFlags)
+	app.Put("/admin/features/:name", handler.UpdateFlag)
+	app.Delete("/admin/features/:name", handler.DeleteFlag)
+	app.Put("/admin/features/:name/overrides/:scope/:target", handler.UpdateOverride)
+	app.Delete("/admin/features/:name/overrides/:scope/:target", handler.DeleteOverride)
+
+	return &testHelper{mockDB: mockDB, app: app, flags: flags, audit: auditLog}
+}
+
+// expectReload expects the flags to be reloaded with beta in the given state
+func (h *testHelper) expectReload(enabled bool, rollout int) {
+	h.mockDB.ExpectQuery(flagsQuery).WillReturnRows(sqlmock.NewRows([]string{"name", "enabled", "rollout"}).AddRow("beta", enabled, rollout))
+	h.mockDB.ExpectQuery(overridesQuery).WillReturnRows(sqlmock.NewRows(overrideCols))
+}
+
+func TestUpdateFlag(t *testing.T) {
+	update := regexp.QuoteMeta("UPDATE feature_flags SET description = ?, enabled = ?, rollout = ?, updated_at = ? WHERE name = ?")
+	insert := regexp.QuoteMeta("INSERT INTO feature_flags (name, description, enabled, rollout) VALUES (?, ?, ?, ?)")
+	updated := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name           string
+		flag           string
+		body           string
+		existing       bool
+		expectedStatus int
+		expectedErrors map[string]string
+	}{
+		{name: "new flag", flag: "beta", body: `{"description":"New editor","rollout":25}`, expectedStatus: fiber.StatusOK},
+		{name: "existing flag", flag: "beta", body: `{"description":"New editor","rollout":25}`, existing: true, expectedStatus: fiber.StatusOK},
+		{
+			name:           "invalid",
+			flag:           "Beta Editor",
+			body:           `{"rollout":150}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{
+				"name":    "must be up to 64 lowercase letters, digits, dots, dashes or underscores",
+				"rollout": "must be a percentage from 0 to 100",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			if tc.expectedStatus == fiber.StatusOK {
+				rows := int64(0)
+				if tc.existing {
+					rows = 1
+				}
+				h.mockDB.ExpectExec(update).
+					WithArgs("New editor", false, 25, sqlmock.AnyArg(), "beta").
+					WillReturnResult(sqlmock.NewResult(0, rows))
+				if !tc.existing {
+					h.mockDB.ExpectExec(insert).WithArgs("beta", "New editor", false, 25).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				h.expectReload(false, 25)
+				h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT name, description, enabled, rollout, updated_at FROM feature_flags WHERE name = ? ORDER BY name")).
+					WithArgs("beta").
+					WillReturnRows(sqlmock.NewRows(flagCols).AddRow("beta", "New editor", false, 25, updated))
+				h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT flag, scope, target_id, enabled FROM feature_flag_overrides WHERE flag = ? ORDER BY flag, scope, target_id")).
+					WithArgs("beta").
+					WillReturnRows(sqlmock.NewRows(overrideCols))
+			}
+
+			req := httptest.NewRequest("PUT", "/admin/features/"+strings.ReplaceAll(tc.flag, " ", "%20"), strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := h.app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedErrors != nil {
+				var body apperr.Response
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tc.expectedErrors, body.Errors)
+				assert.Empty(t, h.audit.entries)
+			} else {
+				var flag Flag
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&flag))
+				assert.Equal(t, Flag{Name: "beta", Description: "New editor", Rollout: 25, Overrides: []Override{}, UpdatedAt: updated}, flag)
+				if assert.Len(t, h.audit.entries, 1) {
+					assert.Equal(t, audit.EventFeatureFlagUpdated, h.audit.entries[0].Event)
+					assert.Equal(t, "beta", h.audit.entries[0].Details["flag"])
+				}
+			}
+			assert.NoError(t, h.mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateOverride(t *testing.T) {
+	h := newTestHelper(t)
+	flagExists := regexp.QuoteMeta("SELECT 1 FROM feature_flags WHERE name = ?")
+
+	// The workspace must exist
+	h.mockDB.ExpectQuery(flagExists).WithArgs("beta").WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM workspaces WHERE id = ?")).WithArgs("ws9").WillReturnRows(sqlmock.NewRows([]string{"one"}))
+	req := httptest.NewRequest("PUT", "/admin/features/beta/overrides/workspace/ws9", strings.NewReader(`{"enabled":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	// Scopes other than user and workspace are refused
+	req = httptest.NewRequest("PUT", "/admin/features/beta/overrides/team/t1", strings.NewReader(`{"enabled":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = h.app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+
+	h.mockDB.ExpectQuery(flagExists).WithArgs("beta").WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM users WHERE id = ?")).WithArgs("u1").WillReturnRows(sqlmock.NewRows([]string{"one"}).AddRow(1))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE feature_flag_overrides SET enabled = ? WHERE flag = ? AND scope = ? AND target_id = ?")).
+		WithArgs(true, "beta", "user", "u1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO feature_flag_overrides (flag, scope, target_id, enabled) VALUES (?, ?, ?, ?)")).
+		WithArgs("beta", "user", "u1", true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectQuery(flagsQuery).WillReturnRows(sqlmock.NewRows([]string{"name", "enabled", "rollout"}).AddRow("beta", false, 0))
+	h.mockDB.ExpectQuery(overridesQuery).WillReturnRows(sqlmock.NewRows(overrideCols).AddRow("beta", "user", "u1", true))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT name, description, enabled, rollout, updated_at FROM feature_flags WHERE name = ?")).
+		WithArgs("beta").
+		WillReturnRows(sqlmock.NewRows(flagCols).AddRow("beta", "", false, 0, time.Now()))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT flag, scope, target_id, enabled FROM feature_flag_overrides WHERE flag = ?")).
+		WithArgs("beta").
+		WillReturnRows(sqlmock.NewRows(overrideCols).AddRow("beta", "user", "u1", true))
+
+	req = httptest.NewRequest("PUT", "/admin/features/beta/overrides/user/u1", strings.NewReader(`{"enabled":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = h.app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var flag Flag
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&flag))
+	assert.Equal(t, []Override{{Scope: ScopeUser, TargetID: "u1", Enabled: true}}, flag.Overrides)
+	// The change applies without waiting for the next reload
+	assert.True(t, h.flags.Enabled("beta", "u1", ""))
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestDeleteFlag(t *testing.T) {
+	h := newTestHelper(t)
+	deleteFlag := regexp.QuoteMeta("DELETE FROM feature_flags WHERE name = ?")
+
+	h.mockDB.ExpectExec(deleteFlag).WithArgs("beta").WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectQuery(flagsQuery).WillReturnRows(sqlmock.NewRows([]string{"name", "enabled", "rollout"}))
+	h.mockDB.ExpectQuery(overridesQuery).WillReturnRows(sqlmock.NewRows(overrideCols))
+	resp, err := h.app.Test(httptest.NewRequest("DELETE", "/admin/features/beta", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.Len(t, h.audit.entries, 1)
+
+	h.mockDB.ExpectExec(deleteFlag).WithArgs("beta").WillReturnResult(sqlmock.NewResult(0, 0))
+	resp, err = h.app.Test(httptest.NewRequest("DELETE", "/admin/features/beta", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestGetMyFeatures(t *testing.T) {
+	h := newTestHelper(t)
+	h.expectReload(false, 0)
+	require.NoError(t, h.flags.Reload(context.Background()))
+
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/me/features", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var flags map[string]bool
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&flags))
+	assert.Equal(t, map[string]bool{"beta": false}, flags)
+
+	// Only the user's own workspaces can be asked about
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user_id = ?")).
+		WithArgs("ws9", "admin1").
+		WillReturnRows(sqlmock.NewRows([]string{"one"}))
+	resp, err = h.app.Test(httptest.NewRequest("GET", "/me/features?workspace_id=ws9", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
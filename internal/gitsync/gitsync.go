@@ -0,0 +1,226 @@
+// Package gitsync pushes a user's notes as Markdown files to a Git
+// repository of theirs, giving them version history and an off-site copy.
+// Sync is one way: the notes/ folder of the repository is rewritten to
+// match the notes and edits made there are overwritten.
+package gitsync
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultBranch is the branch notes are pushed to when none is given
+const DefaultBranch = "main"
+
+var (
+	// sshRemote matches ssh://[user@]host[:port]/path and the scp-like
+	// user@host:path form. Deploy keys only work over SSH.
+	sshRemote = regexp.MustCompile(`^(ssh://([A-Za-z0-9._-]+@)?[A-Za-z0-9.-]+(:[0-9]+)?/[^\s]+|[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^\s/-][^\s]*)$`)
+	// branchName allows the usual branch names while keeping out
+	// anything git could read as an option or revision expression
+	branchName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._/-]*$`)
+)
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Settings is a user's Git sync. PublicKey is the deploy key to grant
+// write access to the repository; the private half never leaves the
+// server.
+type Settings struct {
+	RemoteURL    string     `json:"remote_url"`
+	Branch       string     `json:"branch"`
+	PublicKey    string     `json:"public_key"`
+	LastSyncedAt *time.Time `json:"last_synced_at"`
+	LastError    *string    `json:"last_error"`
+}
+
+// SettingsPayload is the request body for UpdateGitSync
+type SettingsPayload struct {
+	RemoteURL string `json:"remote_url"`
+	Branch    string `json:"branch"`
+}
+
+// Handler handles HTTP requests related to Git sync
+type Handler struct {
+	db    DBInterface
+	audit AuditLogger
+}
+
+// NewHandler creates a new Handler with the provided database interface
+// and the audit log that records where notes are sent
+func NewHandler(db DBInterface, auditLog AuditLogger) *Handler {
+	return &Handler{db: db, audit: auditLog}
+}
+
+// GetGitSync returns the user's Git sync settings and how the last sync went
+func (h *Handler) GetGitSync(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	settings, err := h.settings(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(settings)
+}
+
+// UpdateGitSync sets the repository and branch the user's notes are pushed
+// to. The first time, a deploy key is generated for the user to add to the
+// repository. Changing the repository forgets its host key and pushes all
+// notes again on the next sync.
+func (h *Handler) UpdateGitSync(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload SettingsPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	payload.RemoteURL = strings.TrimSpace(payload.RemoteURL)
+	payload.Branch = strings.TrimSpace(payload.Branch)
+	if payload.Branch == "" {
+		payload.Branch = DefaultBranch
+	}
+	fields := map[string]string{}
+	if !sshRemote.MatchString(payload.RemoteURL) || len(payload.RemoteURL) > 512 {
+		fields["remote_url"] = "must be an SSH remote such as git@github.com:me/notes.git"
+	}
+	if !branchName.MatchString(payload.Branch) || strings.Contains(payload.Branch, "..") ||
+		strings.HasSuffix(payload.Branch, ".lock") || len(payload.Branch) > 255 {
+		fields["branch"] = "must be a branch name such as main"
+	}
+	if len(fields) > 0 {
+		return apperr.Invalid(fields)
+	}
+
+	ctx := c.UserContext()
+	res, err := h.db.ExecContext(ctx,
+		"UPDATE git_syncs SET remote_url = ?, branch = ?, known_hosts = NULL, fingerprint = NULL, last_error = NULL WHERE user_id = ?",
+		payload.RemoteURL, payload.Branch, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating git sync: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("updating git sync: %w", err)
+	} else if n == 0 {
+		privateKey, publicKey, err := newDeployKey()
+		if err != nil {
+			return err
+		}
+		_, err = h.db.ExecContext(ctx,
+			"INSERT INTO git_syncs (user_id, remote_url, branch, private_key, public_key) VALUES (?, ?, ?, ?, ?)",
+			userID, payload.RemoteURL, payload.Branch, privateKey, publicKey,
+		)
+		// MySQL reports no rows affected when nothing changed
+		if err != nil && !db.IsDuplicate(err) {
+			return fmt.Errorf("creating git sync: %w", err)
+		}
+	}
+
+	entry := audit.FromRequest(c, audit.EventGitSyncConfigured, userID)
+	entry.Details = map[string]string{"remote_url": payload.RemoteURL, "branch": payload.Branch}
+	h.audit.Log(ctx, entry)
+
+	settings, err := h.settings(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(settings)
+}
+
+// DeleteGitSync stops syncing the user's notes and discards the deploy key.
+// The repository is left as it is.
+func (h *Handler) DeleteGitSync(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	res, err := h.db.ExecContext(c.UserContext(), "DELETE FROM git_syncs WHERE user_id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("deleting git sync: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("deleting git sync: %w", err)
+	} else if n == 0 {
+		return apperr.New(fiber.StatusNotFound, "Git sync is not set up")
+	}
+
+	h.audit.Log(c.UserContext(), audit.FromRequest(c, audit.EventGitSyncRemoved, userID))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// settings fetches the user's Git sync
+func (h *Handler) settings(ctx context.Context, userID string) (Settings, error) {
+	var s Settings
+	var syncedAt sql.NullTime
+	var lastError sql.NullString
+	err := h.db.QueryRowContext(ctx,
+		"SELECT remote_url, branch, public_key, last_synced_at, last_error FROM git_syncs WHERE user_id = ?",
+		userID,
+	).Scan(&s.RemoteURL, &s.Branch, &s.PublicKey, &syncedAt, &lastError)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return s, apperr.New(fiber.StatusNotFound, "Git sync is not set up")
+		}
+		return s, fmt.Errorf("fetching git sync: %w", err)
+	}
+	if syncedAt.Valid {
+		s.LastSyncedAt = &syncedAt.Time
+	}
+	if lastError.Valid {
+		s.LastError = &lastError.String
+	}
+	return s, nil
+}
+
+// newDeployKey generates an Ed25519 key pair, returning the private key in
+// OpenSSH PEM form and the public key in authorized_keys form
+func newDeployKey() (string, string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("generating deploy key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "quanta git sync")
+	if err != nil {
+		return "", "", fmt.Errorf("encoding deploy key: %w", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return "", "", fmt.Errorf("encoding deploy key: %w", err)
+	}
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " quanta-git-sync"
+	return string(pem.EncodeToMemory(block)), publicKey, nil
+}
@@ -0,0 +1,193 @@
+package gitsync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+var settingsQuery = regexp.QuoteMeta("SELECT remote_url, branch, public_key, last_synced_at, last_error FROM git_syncs WHERE user_id = ?")
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+type testHelper struct {
+	mockDB sqlmock.Sqlmock
+	app    *fiber.App
+	audit  *fakeAudit
+}
+
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	auditLog := &fakeAudit{}
+	handler := NewHandler(db, auditLog)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/me/git-sync", handler.GetGitSync)
+	app.Put("/me/git-sync", handler.UpdateGitSync)
+	app.Delete("/me/git-sync", handler.DeleteGitSync)
+
+	return &testHelper{mockDB: mockDB, app: app, audit: auditLog}
+}
+
+func TestUpdateGitSync(t *testing.T) {
+	update := regexp.QuoteMeta("UPDATE git_syncs SET remote_url = ?, branch = ?, known_hosts = NULL, fingerprint = NULL, last_error = NULL WHERE user_id = ?")
+	insert := regexp.QuoteMeta("INSERT INTO git_syncs (user_id, remote_url, branch, private_key, public_key) VALUES (?, ?, ?, ?, ?)")
+
+	testCases := []struct {
+		name           string
+		body           string
+		existing       bool
+		expectedStatus int
+		expectedErrors map[string]string
+	}{
+		{name: "first time", body: `{"remote_url":"git@github.com:me/notes.git"}`, expectedStatus: fiber.StatusOK},
+		{name: "existing", body: `{"remote_url":"ssh://git@example.com:2222/me/notes.git","branch":"backup/notes"}`, existing: true, expectedStatus: fiber.StatusOK},
+		{
+			name:           "https remote",
+			body:           `{"remote_url":"https://github.com/me/notes.git"}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{"remote_url": "must be an SSH remote such as git@github.com:me/notes.git"},
+		},
+		{
+			name:           "option smuggling",
+			body:           `{"remote_url":"git@host:-oProxyCommand=x","branch":"-f"}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{
+				"remote_url": "must be an SSH remote such as git@github.com:me/notes.git",
+				"branch":     "must be a branch name such as main",
+			},
+		},
+		{
+			name:           "revision branch",
+			body:           `{"remote_url":"git@github.com:me/notes.git","branch":"main..other"}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{"branch": "must be a branch name such as main"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			var payload SettingsPayload
+			require.NoError(t, json.Unmarshal([]byte(tc.body), &payload))
+			branch := payload.Branch
+			if branch == "" {
+				branch = DefaultBranch
+			}
+
+			if tc.expectedStatus == fiber.StatusOK {
+				rows := int64(0)
+				if tc.existing {
+					rows = 1
+				}
+				h.mockDB.ExpectExec(update).WithArgs(payload.RemoteURL, branch, "user123").WillReturnResult(sqlmock.NewResult(0, rows))
+				if !tc.existing {
+					h.mockDB.ExpectExec(insert).
+						WithArgs("user123", payload.RemoteURL, branch, sqlmock.AnyArg(), sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				h.mockDB.ExpectQuery(settingsQuery).WithArgs("user123").
+					WillReturnRows(sqlmock.NewRows([]string{"remote_url", "branch", "public_key", "last_synced_at", "last_error"}).
+						AddRow(payload.RemoteURL, branch, "ssh-ed25519 AAAA quanta-git-sync", nil, nil))
+			}
+
+			req := httptest.NewRequest("PUT", "/me/git-sync", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := h.app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedErrors != nil {
+				var body apperr.Response
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tc.expectedErrors, body.Errors)
+				assert.Empty(t, h.audit.entries)
+			} else {
+				var settings Settings
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&settings))
+				assert.Equal(t, Settings{RemoteURL: payload.RemoteURL, Branch: branch, PublicKey: "ssh-ed25519 AAAA quanta-git-sync"}, settings)
+				if assert.Len(t, h.audit.entries, 1) {
+					assert.Equal(t, audit.EventGitSyncConfigured, h.audit.entries[0].Event)
+					assert.Equal(t, payload.RemoteURL, h.audit.entries[0].Details["remote_url"])
+				}
+			}
+			assert.NoError(t, h.mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestNewDeployKey(t *testing.T) {
+	privateKey, publicKey, err := newDeployKey()
+	require.NoError(t, err)
+
+	signer, err := ssh.ParsePrivateKey([]byte(privateKey))
+	require.NoError(t, err)
+	parsed, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	require.NoError(t, err)
+	assert.Equal(t, "quanta-git-sync", comment)
+	assert.Equal(t, signer.PublicKey().Marshal(), parsed.Marshal())
+}
+
+func TestGetAndDeleteGitSync(t *testing.T) {
+	h := newTestHelper(t)
+	deleteQuery := regexp.QuoteMeta("DELETE FROM git_syncs WHERE user_id = ?")
+
+	h.mockDB.ExpectQuery(settingsQuery).WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"remote_url", "branch", "public_key", "last_synced_at", "last_error"}).
+			AddRow("git@github.com:me/notes.git", "main", "ssh-ed25519 AAAA", nil, "git push: Permission denied (publickey)."))
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/me/git-sync", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var settings Settings
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&settings))
+	if assert.NotNil(t, settings.LastError) {
+		assert.Equal(t, "git push: Permission denied (publickey).", *settings.LastError)
+	}
+
+	h.mockDB.ExpectExec(deleteQuery).WithArgs("user123").WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err = h.app.Test(httptest.NewRequest("DELETE", "/me/git-sync", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	if assert.Len(t, h.audit.entries, 1) {
+		assert.Equal(t, audit.EventGitSyncRemoved, h.audit.entries[0].Event)
+	}
+
+	h.mockDB.ExpectQuery(settingsQuery).WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"remote_url", "branch", "public_key", "last_synced_at", "last_error"}))
+	resp, err = h.app.Test(httptest.NewRequest("GET", "/me/git-sync", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	h.mockDB.ExpectExec(deleteQuery).WithArgs("user123").WillReturnResult(sqlmock.NewResult(0, 0))
+	resp, err = h.app.Test(httptest.NewRequest("DELETE", "/me/git-sync", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,372 @@
+package gitsync
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultInterval is how often notes are checked for changes to push.
+// Edits made between checks are pushed together as one commit.
+const DefaultInterval = 15 * time.Minute
+
+// maxError is how much of a failed sync's git output is kept
+const maxError = 1000
+
+// target is a user's Git sync as the worker needs it
+type target struct {
+	userID      string
+	email       string
+	remoteURL   string
+	branch      string
+	privateKey  string
+	knownHosts  sql.NullString
+	fingerprint sql.NullString
+}
+
+// exportedNote is a note written to the repository
+type exportedNote struct {
+	id        string
+	title     string
+	content   string
+	createdAt time.Time
+}
+
+// SyncAll pushes the notes of every user whose private notes changed since
+// their last sync. Encrypted notes are never pushed. A failed push is
+// recorded for the user to see and retried next time. It returns the
+// number of users whose notes were synced.
+func SyncAll(ctx context.Context, db DBInterface, now time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT g.user_id, u.email, g.remote_url, g.branch, g.private_key, g.known_hosts, g.fingerprint FROM git_syncs g "+
+			"JOIN users u ON u.id = g.user_id WHERE u.deleted_at IS NULL ORDER BY g.user_id",
+	)
+	if err != nil {
+		return 0, err
+	}
+	var targets []target
+	for rows.Next() {
+		var t target
+		if err := rows.Scan(&t.userID, &t.email, &t.remoteURL, &t.branch, &t.privateKey, &t.knownHosts, &t.fingerprint); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		targets = append(targets, t)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	synced := 0
+	for _, t := range targets {
+		fp, err := fingerprint(ctx, db, t.userID)
+		if err != nil {
+			return synced, err
+		}
+		if t.fingerprint.Valid && t.fingerprint.String == fp {
+			continue
+		}
+		notes, err := exportedNotes(ctx, db, t.userID)
+		if err != nil {
+			return synced, err
+		}
+
+		knownHosts, err := push(ctx, t, notes)
+		if err != nil {
+			log.Printf("Error syncing notes of %s to git: %v", t.userID, err)
+			reason := err.Error()
+			if len(reason) > maxError {
+				reason = strings.ToValidUTF8(reason[:maxError], "")
+			}
+			if _, err := db.ExecContext(ctx, "UPDATE git_syncs SET last_error = ? WHERE user_id = ?", reason, t.userID); err != nil {
+				return synced, err
+			}
+			continue
+		}
+
+		// Leave the sync to run again if it was pointed elsewhere meanwhile
+		_, err = db.ExecContext(ctx,
+			"UPDATE git_syncs SET fingerprint = ?, known_hosts = ?, last_synced_at = ?, last_error = NULL WHERE user_id = ? AND remote_url = ? AND branch = ?",
+			fp, knownHosts, now.UTC(), t.userID, t.remoteURL, t.branch,
+		)
+		if err != nil {
+			return synced, err
+		}
+		synced++
+	}
+
+	return synced, nil
+}
+
+// fingerprint sums up the state of the user's synced notes: any note
+// created, edited or deleted changes it
+func fingerprint(ctx context.Context, db DBInterface, userID string) (string, error) {
+	var count int
+	var latest sql.NullString
+	err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*), MAX(updated_at) FROM notes WHERE user_id = ? AND workspace_id IS NULL AND encrypted = ?",
+		userID, false,
+	).Scan(&count, &latest)
+	if err != nil {
+		return "", fmt.Errorf("fingerprinting notes: %w", err)
+	}
+	return fmt.Sprintf("%d:%s", count, latest.String), nil
+}
+
+// exportedNotes fetches the user's private notes that aren't encrypted,
+// oldest first
+func exportedNotes(ctx context.Context, db DBInterface, userID string) ([]exportedNote, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, title, content, created_at FROM notes WHERE user_id = ? AND workspace_id IS NULL AND encrypted = ? ORDER BY created_at, id",
+		userID, false,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching notes: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var notes []exportedNote
+	for rows.Next() {
+		var n exportedNote
+		var content sql.NullString
+		if err := rows.Scan(&n.id, &n.title, &content, &n.createdAt); err != nil {
+			return nil, fmt.Errorf("scanning notes: %w", err)
+		}
+		n.content = content.String
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// push writes notes to the notes/ folder of the target's branch, on top of
+// what is there, and pushes a commit if anything changed. It works in a
+// shallow clone made for the purpose, so nothing is kept on disk between
+// syncs but the remote's host key, which is returned to pin it next time.
+func push(ctx context.Context, t target, notes []exportedNote) (string, error) {
+	dir, err := os.MkdirTemp("", "quanta-git-sync-")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	keyFile := filepath.Join(dir, "deploy_key")
+	hostsFile := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(keyFile, []byte(t.privateKey), 0o600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(hostsFile, []byte(t.knownHosts.String), 0o600); err != nil {
+		return "", err
+	}
+
+	repo := filepath.Join(dir, "repo")
+	g := gitCmd{
+		dir: repo,
+		env: append(os.Environ(),
+			"HOME="+dir,
+			"XDG_CONFIG_HOME="+dir,
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_TERMINAL_PROMPT=0",
+			"GIT_SSH_COMMAND=ssh -i '"+keyFile+"' -o IdentitiesOnly=yes -o BatchMode=yes "+
+				"-o StrictHostKeyChecking=accept-new -o UserKnownHostsFile='"+hostsFile+"'",
+			"GIT_AUTHOR_NAME="+t.email,
+			"GIT_AUTHOR_EMAIL="+t.email,
+			"GIT_COMMITTER_NAME="+t.email,
+			"GIT_COMMITTER_EMAIL="+t.email,
+		),
+	}
+	if err := os.Mkdir(repo, 0o700); err != nil {
+		return "", err
+	}
+	if _, err := g.run(ctx, "init", "-q"); err != nil {
+		return "", err
+	}
+	if _, err := g.run(ctx, "remote", "add", "origin", t.remoteURL); err != nil {
+		return "", err
+	}
+	_, err = g.run(ctx, "fetch", "-q", "--depth=1", "origin", t.branch)
+	fetched := err == nil
+	if err != nil && !strings.Contains(err.Error(), "couldn't find remote ref") {
+		return "", err
+	}
+	if _, err := g.run(ctx, "symbolic-ref", "HEAD", "refs/heads/"+t.branch); err != nil {
+		return "", err
+	}
+	if fetched {
+		if _, err := g.run(ctx, "reset", "-q", "--hard", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
+	}
+
+	if err := writeNotes(filepath.Join(repo, "notes"), notes); err != nil {
+		return "", err
+	}
+	if _, err := g.run(ctx, "add", "-A", "notes"); err != nil {
+		return "", err
+	}
+	status, err := g.run(ctx, "status", "--porcelain", "--", "notes")
+	if err != nil {
+		return "", err
+	}
+	if len(status) > 0 {
+		if _, err := g.run(ctx, "commit", "-q", "-m", commitMessage(status)); err != nil {
+			return "", err
+		}
+		if _, err := g.run(ctx, "push", "-q", "origin", "HEAD:refs/heads/"+t.branch); err != nil {
+			return "", err
+		}
+	}
+
+	knownHosts, err := os.ReadFile(hostsFile)
+	if err != nil {
+		return "", err
+	}
+	return string(knownHosts), nil
+}
+
+// gitCmd runs git in a repository
+type gitCmd struct {
+	dir string
+	env []string
+}
+
+// run runs git with args, returning its output or an error carrying what
+// it printed to stderr
+func (g gitCmd) run(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = g.dir
+	cmd.Env = g.env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// commitMessage describes the changes listed by git status --porcelain
+func commitMessage(status []byte) string {
+	var added, changed, removed int
+	for _, line := range strings.Split(strings.TrimSpace(string(status)), "\n") {
+		switch line[0] {
+		case 'A':
+			added++
+		case 'D':
+			removed++
+		default:
+			changed++
+		}
+	}
+	var parts []string
+	if added > 0 {
+		parts = append(parts, fmt.Sprintf("%d added", added))
+	}
+	if changed > 0 {
+		parts = append(parts, fmt.Sprintf("%d changed", changed))
+	}
+	if removed > 0 {
+		parts = append(parts, fmt.Sprintf("%d removed", removed))
+	}
+	return "Sync notes: " + strings.Join(parts, ", ")
+}
+
+// writeNotes replaces the contents of dir with a Markdown file per note
+func writeNotes(dir string, notes []exportedNote) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	for i, name := range fileNames(notes) {
+		n := notes[i]
+		body := fmt.Sprintf("---\nid: %s\ncreated: %s\n---\n\n# %s\n\n%s\n",
+			n.id, n.createdAt.UTC().Format(time.RFC3339), n.title, strings.TrimRight(n.content, "\n"))
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileNames names each note's file after its title. Notes whose title is
+// taken, ignoring case, by an older note get the start of their ID added.
+func fileNames(notes []exportedNote) []string {
+	names := make([]string, len(notes))
+	taken := map[string]bool{}
+	for i, n := range notes {
+		base := fileSafe(n.title)
+		name := base + ".md"
+		if taken[strings.ToLower(name)] {
+			name = base + "-" + n.id[:min(8, len(n.id))] + ".md"
+		}
+		taken[strings.ToLower(name)] = true
+		names[i] = name
+	}
+	return names
+}
+
+// fileSafe turns a title into a file name that works on every platform
+func fileSafe(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '-'
+		}
+		return r
+	}, title)
+	if utf8.RuneCountInString(name) > 100 {
+		name = string([]rune(name)[:100])
+	}
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return "Untitled"
+	}
+	return name
+}
+
+// StartWorker syncs notes that changed every interval until stop is
+// closed. Each run must finish within the interval. It does nothing when
+// git isn't installed. Runs are skipped while paused reports true.
+func StartWorker(db DBInterface, interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	if _, err := exec.LookPath("git"); err != nil {
+		log.Println("Git sync is off: git is not installed")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			synced, err := SyncAll(ctx, db, now)
+			cancel()
+			if err != nil {
+				log.Println("Error syncing notes to git:", err)
+				continue
+			}
+			if synced > 0 {
+				log.Printf("Synced the notes of %d users to git", synced)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package gitsync
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bareRepo creates an empty repository to push to, skipping the test when
+// git isn't installed
+func bareRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := filepath.Join(t.TempDir(), "notes.git")
+	require.NoError(t, exec.Command("git", "init", "-q", "--bare", dir).Run())
+	return dir
+}
+
+// gitOutput runs git against the repository at dir
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	out, err := exec.Command("git", append([]string{"--git-dir", dir}, args...)...).Output()
+	require.NoError(t, err)
+	return strings.TrimSpace(string(out))
+}
+
+func TestPush(t *testing.T) {
+	remote := bareRepo(t)
+	tgt := target{userID: "user123", email: "alice@example.com", remoteURL: remote, branch: "main"}
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	notes := []exportedNote{
+		{id: "11111111-aaaa", title: "Plans", content: "Ship it", createdAt: created},
+		{id: "22222222-bbbb", title: "plans", content: "Other plans", createdAt: created},
+		{id: "33333333-cccc", title: "a/b: c?", content: "", createdAt: created},
+	}
+
+	_, err := push(context.Background(), tgt, notes)
+	require.NoError(t, err)
+	assert.Equal(t, "notes/Plans.md\nnotes/a-b- c-.md\nnotes/plans-22222222.md", gitOutput(t, remote, "ls-tree", "-r", "--name-only", "main"))
+	assert.Equal(t, "---\nid: 11111111-aaaa\ncreated: 2026-03-01T12:00:00Z\n---\n\n# Plans\n\nShip it",
+		gitOutput(t, remote, "show", "main:notes/Plans.md"))
+	assert.Equal(t, "alice@example.com Sync notes: 3 added", gitOutput(t, remote, "log", "--format=%ae %s", "main"))
+
+	// Unchanged notes make no commit
+	_, err = push(context.Background(), tgt, notes)
+	require.NoError(t, err)
+	assert.Equal(t, "1", gitOutput(t, remote, "rev-list", "--count", "main"))
+
+	notes[0].content = "Shipped"
+	_, err = push(context.Background(), tgt, notes[:1])
+	require.NoError(t, err)
+	assert.Equal(t, "Sync notes: 1 changed, 2 removed", gitOutput(t, remote, "log", "-1", "--format=%s", "main"))
+	assert.Equal(t, "notes/Plans.md", gitOutput(t, remote, "ls-tree", "-r", "--name-only", "main"))
+}
+
+func TestPushFailure(t *testing.T) {
+	bareRepo(t)
+	tgt := target{userID: "user123", email: "alice@example.com", remoteURL: filepath.Join(t.TempDir(), "missing.git"), branch: "main"}
+
+	_, err := push(context.Background(), tgt, nil)
+	assert.ErrorContains(t, err, "git fetch")
+}
+
+func TestSyncAll(t *testing.T) {
+	remote := bareRepo(t)
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	fingerprintQuery := regexp.QuoteMeta("SELECT COUNT(*), MAX(updated_at) FROM notes WHERE user_id = ? AND workspace_id IS NULL AND encrypted = ?")
+
+	// alice's notes haven't changed since her last sync, bob's have
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT g.user_id, u.email, g.remote_url, g.branch, g.private_key, g.known_hosts, g.fingerprint FROM git_syncs g JOIN users u ON u.id = g.user_id WHERE u.deleted_at IS NULL ORDER BY g.user_id")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "remote_url", "branch", "private_key", "known_hosts", "fingerprint"}).
+			AddRow("alice", "alice@example.com", "git@example.com:alice/notes.git", "main", "key", "known", "2:2026-03-01").
+			AddRow("bob", "bob@example.com", remote, "main", "key", nil, nil))
+	mockDB.ExpectQuery(fingerprintQuery).WithArgs("alice", false).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(2, "2026-03-01"))
+	mockDB.ExpectQuery(fingerprintQuery).WithArgs("bob", false).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(1, "2026-03-02"))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, title, content, created_at FROM notes WHERE user_id = ? AND workspace_id IS NULL AND encrypted = ? ORDER BY created_at, id")).
+		WithArgs("bob", false).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "created_at"}).AddRow("n1", "Diary", nil, now))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE git_syncs SET fingerprint = ?, known_hosts = ?, last_synced_at = ?, last_error = NULL WHERE user_id = ? AND remote_url = ? AND branch = ?")).
+		WithArgs("1:2026-03-02", "", now, "bob", remote, "main").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	synced, err := SyncAll(context.Background(), db, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, synced)
+	assert.Equal(t, "notes/Diary.md", gitOutput(t, remote, "ls-tree", "-r", "--name-only", "main"))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestSyncAllRecordsFailure(t *testing.T) {
+	bareRepo(t)
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT g.user_id")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "remote_url", "branch", "private_key", "known_hosts", "fingerprint"}).
+			AddRow("bob", "bob@example.com", filepath.Join(t.TempDir(), "missing.git"), "main", "key", nil, nil))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*), MAX(updated_at) FROM notes")).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(0, nil))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, title, content, created_at FROM notes")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "created_at"}))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE git_syncs SET last_error = ? WHERE user_id = ?")).
+		WithArgs(sqlmock.AnyArg(), "bob").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	synced, err := SyncAll(context.Background(), db, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 0, synced)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
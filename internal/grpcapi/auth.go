@@ -0,0 +1,61 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+
+	quantav1 "quanta/api/proto/quanta/v1"
+	"quanta/internal/handlers/auth"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// authServer implements AuthService on top of the REST auth handler
+type authServer struct {
+	quantav1.UnimplementedAuthServiceServer
+	handler *auth.Handler
+}
+
+// SignUp creates an account or logs into an existing one
+func (s *authServer) SignUp(ctx context.Context, req *quantav1.SignUpRequest) (*quantav1.Session, error) {
+	session, err := s.handler.Register(ctx, auth.Registration{
+		Email:       req.GetEmail(),
+		Password:    req.GetPassword(),
+		InviteToken: req.GetInviteToken(),
+		Device:      req.GetDevice(),
+	}, clientOf(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &quantav1.Session{Token: session.Token, WorkspaceId: session.WorkspaceID}, nil
+}
+
+// Login exchanges credentials for a token
+func (s *authServer) Login(ctx context.Context, req *quantav1.LoginRequest) (*quantav1.Session, error) {
+	session, err := s.handler.Authenticate(ctx, auth.Credentials{
+		Email:    req.GetEmail(),
+		Password: req.GetPassword(),
+		Device:   req.GetDevice(),
+	}, clientOf(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &quantav1.Session{Token: session.Token}, nil
+}
+
+// clientOf describes the caller for the session list, from the peer address
+// and the "user-agent" metadata gRPC clients send
+func clientOf(ctx context.Context) auth.Client {
+	var client auth.Client
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		client.IP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(client.IP); err == nil {
+			client.IP = host
+		}
+	}
+	if values := metadata.ValueFromIncomingContext(ctx, "user-agent"); len(values) > 0 {
+		client.UserAgent = values[0]
+	}
+	return client
+}
@@ -0,0 +1,89 @@
+package grpcapi
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/handlers/auth"
+
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// codeFor maps the HTTP status of an apperr.Error to a gRPC code
+var codeFor = map[int]codes.Code{
+	fiber.StatusBadRequest:            codes.InvalidArgument,
+	fiber.StatusUnauthorized:          codes.Unauthenticated,
+	fiber.StatusPaymentRequired:       codes.ResourceExhausted,
+	fiber.StatusForbidden:             codes.PermissionDenied,
+	fiber.StatusNotFound:              codes.NotFound,
+	fiber.StatusConflict:              codes.AlreadyExists,
+	fiber.StatusGone:                  codes.NotFound,
+	fiber.StatusPreconditionFailed:    codes.FailedPrecondition,
+	fiber.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	fiber.StatusUnprocessableEntity:   codes.InvalidArgument,
+	fiber.StatusLocked:                codes.ResourceExhausted,
+	fiber.StatusTooManyRequests:       codes.ResourceExhausted,
+	fiber.StatusServiceUnavailable:    codes.Unavailable,
+	fiber.StatusGatewayTimeout:        codes.DeadlineExceeded,
+}
+
+// toStatus converts a handler error to a gRPC status error. Errors clients
+// may see keep their message, validation failures list the bad fields and
+// anything else is logged and hidden behind Internal, as apperr.Handler does
+// for REST.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var locked *auth.LockedError
+	if errors.As(err, &locked) {
+		st := status.New(codes.ResourceExhausted, locked.Error())
+		retry := &errdetails.RetryInfo{RetryDelay: durationpb.New(time.Until(locked.Until).Round(time.Second))}
+		if withDetails, err := st.WithDetails(retry); err == nil {
+			st = withDetails
+		}
+		return st.Err()
+	}
+
+	var appErr *apperr.Error
+	if !errors.As(err, &appErr) {
+		log.Printf("grpc: %v", err)
+		return status.Error(codes.Internal, "Internal server error")
+	}
+
+	code, ok := codeFor[appErr.Code]
+	if !ok {
+		code = codes.Unknown
+	}
+	st := status.New(code, appErr.Message)
+	if len(appErr.Fields) > 0 {
+		fields := make([]string, 0, len(appErr.Fields))
+		for field := range appErr.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		details := &errdetails.BadRequest{}
+		for _, field := range fields {
+			details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       field,
+				Description: appErr.Fields[field],
+			})
+		}
+		if withDetails, err := st.WithDetails(details); err == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
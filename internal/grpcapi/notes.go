@@ -0,0 +1,95 @@
+package grpcapi
+
+import (
+	"context"
+
+	quantav1 "quanta/api/proto/quanta/v1"
+	"quanta/internal/handlers/notes"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// notesServer implements NotesService on top of the REST notes handler
+type notesServer struct {
+	quantav1.UnimplementedNotesServiceServer
+	handler *notes.Handler
+}
+
+// ListNotes returns the caller's private notes
+func (s *notesServer) ListNotes(ctx context.Context, req *quantav1.ListNotesRequest) (*quantav1.ListNotesResponse, error) {
+	opts := notes.ListOptions{Archived: req.GetArchived()}
+	if req.GetUpdatedSince() != nil {
+		opts.UpdatedSince = req.GetUpdatedSince().AsTime()
+	}
+
+	list, err := s.handler.List(ctx, userID(ctx), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &quantav1.ListNotesResponse{Notes: make([]*quantav1.Note, 0, len(list))}
+	for _, n := range list {
+		resp.Notes = append(resp.Notes, toProto(n))
+	}
+	return resp, nil
+}
+
+// GetNote returns a note the caller can access
+func (s *notesServer) GetNote(ctx context.Context, req *quantav1.GetNoteRequest) (*quantav1.Note, error) {
+	n, err := s.handler.Get(ctx, userID(ctx), req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toProto(n), nil
+}
+
+// CreateNote creates a private note and returns it
+func (s *notesServer) CreateNote(ctx context.Context, req *quantav1.CreateNoteRequest) (*quantav1.Note, error) {
+	id, err := s.handler.Create(ctx, userID(ctx), nil, notes.NotePayload{
+		Title:   req.GetTitle(),
+		Content: req.GetContent(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetNote(ctx, &quantav1.GetNoteRequest{Id: id})
+}
+
+// UpdateNote replaces a note's title and content and returns the result
+func (s *notesServer) UpdateNote(ctx context.Context, req *quantav1.UpdateNoteRequest) (*quantav1.Note, error) {
+	err := s.handler.Update(ctx, userID(ctx), req.GetId(), notes.NotePayload{
+		Title:   req.GetTitle(),
+		Content: req.GetContent(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetNote(ctx, &quantav1.GetNoteRequest{Id: req.GetId()})
+}
+
+// DeleteNote deletes a note the caller can access
+func (s *notesServer) DeleteNote(ctx context.Context, req *quantav1.DeleteNoteRequest) (*quantav1.DeleteNoteResponse, error) {
+	if err := s.handler.Delete(ctx, userID(ctx), req.GetId()); err != nil {
+		return nil, err
+	}
+	return &quantav1.DeleteNoteResponse{}, nil
+}
+
+// toProto converts a note to its protobuf message
+func toProto(n notes.Note) *quantav1.Note {
+	msg := &quantav1.Note{
+		Id:        n.ID,
+		UserId:    n.UserID,
+		Title:     n.Title,
+		Content:   n.Content,
+		Pinned:    n.Pinned,
+		Archived:  n.Archived,
+		Version:   n.Version,
+		CreatedAt: timestamppb.New(n.CreatedAt),
+		UpdatedAt: timestamppb.New(n.UpdatedAt),
+	}
+	if n.WorkspaceID != nil {
+		msg.WorkspaceId = *n.WorkspaceID
+	}
+	return msg
+}
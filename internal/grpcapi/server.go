@@ -0,0 +1,121 @@
+// Package grpcapi serves the notes and auth services over gRPC, next to the
+// REST API. Both call the same handler methods, so they share validation,
+// access checks and storage.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	quantav1 "quanta/api/proto/quanta/v1"
+	"quanta/internal/apperr"
+	"quanta/internal/handlers/auth"
+	"quanta/internal/handlers/notes"
+	"quanta/internal/middleware"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+)
+
+// Options configures the gRPC server
+type Options struct {
+	// Keys verify the bearer tokens AuthService issues
+	Keys *pkg.JWTKeys
+	// QueryTimeout bounds how long each call's database work may take
+	QueryTimeout time.Duration
+	// ReadOnly reports whether maintenance mode is on, during which calls
+	// that write are refused. Nil means never.
+	ReadOnly func() bool
+}
+
+// writeMethods are the calls refused during maintenance. Login is not
+// among them so administrators can still sign in, as with the REST /login.
+var writeMethods = map[string]bool{
+	quantav1.NotesService_CreateNote_FullMethodName: true,
+	quantav1.NotesService_UpdateNote_FullMethodName: true,
+	quantav1.NotesService_DeleteNote_FullMethodName: true,
+	quantav1.AuthService_SignUp_FullMethodName:      true,
+}
+
+// userKey is the context key the authenticated user's ID is stored under
+type userKey struct{}
+
+// NewServer returns a gRPC server with the notes and auth services, the
+// standard health service and server reflection registered
+func NewServer(db middleware.DBInterface, notesHandler *notes.Handler, authHandler *auth.Handler, opts Options) *grpc.Server {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(interceptor(db, opts)))
+
+	quantav1.RegisterAuthServiceServer(srv, &authServer{handler: authHandler})
+	quantav1.RegisterNotesServiceServer(srv, &notesServer{handler: notesHandler})
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(quantav1.AuthService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(quantav1.NotesService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, healthServer)
+
+	reflection.Register(srv)
+	return srv
+}
+
+// interceptor refuses writes during maintenance, applies the query
+// timeout, authenticates calls to the notes service and turns handler
+// errors into gRPC statuses
+func interceptor(db middleware.DBInterface, opts Options) grpc.UnaryServerInterceptor {
+	protected := "/" + quantav1.NotesService_ServiceDesc.ServiceName + "/"
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if opts.ReadOnly != nil && opts.ReadOnly() && writeMethods[info.FullMethod] {
+			return nil, toStatus(apperr.New(fiber.StatusServiceUnavailable, "Down for maintenance, try again later"))
+		}
+
+		if opts.QueryTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.QueryTimeout)
+			defer cancel()
+		}
+
+		if strings.HasPrefix(info.FullMethod, protected) {
+			userID, err := authenticate(ctx, db, opts.Keys)
+			if err != nil {
+				return nil, toStatus(err)
+			}
+			ctx = context.WithValue(ctx, userKey{}, userID)
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && apperr.Status(err) == fiber.StatusInternalServerError {
+			err = apperr.New(fiber.StatusGatewayTimeout, "Request timed out")
+		}
+		return resp, toStatus(err)
+	}
+}
+
+// authenticate checks the bearer token in the call's "authorization"
+// metadata the same way the Protected middleware checks the header
+func authenticate(ctx context.Context, db middleware.DBInterface, keys *pkg.JWTKeys) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return "", apperr.New(fiber.StatusUnauthorized, "Missing or invalid authorization metadata")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+	if token == "" {
+		return "", apperr.New(fiber.StatusUnauthorized, "Missing token")
+	}
+
+	userID, _, err := middleware.Authenticate(ctx, db, keys, token)
+	return userID, err
+}
+
+// userID returns the ID of the user the call was authenticated as
+func userID(ctx context.Context) string {
+	id, _ := ctx.Value(userKey{}).(string)
+	return id
+}
@@ -0,0 +1,204 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	quantav1 "quanta/api/proto/quanta/v1"
+	"quanta/internal/activity"
+	"quanta/internal/audit"
+	"quanta/internal/db"
+	"quanta/internal/handlers/auth"
+	"quanta/internal/handlers/notes"
+	"quanta/internal/models"
+	"quanta/internal/notifications"
+	"quanta/internal/quota"
+	"quanta/pkg"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testSecret = "test-secret"
+
+// discardAudit drops audit entries
+type discardAudit struct{}
+
+func (discardAudit) Log(context.Context, audit.Entry) {}
+
+// testHelper contains common test setup and utilities
+type testHelper struct {
+	t      *testing.T
+	mockDB sqlmock.Sqlmock
+	conn   *grpc.ClientConn
+}
+
+// newTestHelper starts the gRPC server on an in-memory listener and dials it
+func newTestHelper(t *testing.T) *testHelper {
+	return newTestHelperWith(t, nil)
+}
+
+// newTestHelperWith is newTestHelper with readOnly as the maintenance check
+func newTestHelperWith(t *testing.T, readOnly func() bool) *testHelper {
+	stub, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	notesHandler := notes.NewHandler(stub, activity.NewRecorder(stub, nil), nil, nil, models.NoteLimits{MaxTitleLength: 20, MaxContentBytes: 32}, quota.Limits{}, nil, nil, db.MySQL)
+	authHandler := auth.NewHandler(stub, &auth.JWTService{}, pkg.SingleJWTKey(testSecret), discardAudit{}, notifications.LogMailer{}, auth.EmailConfig{}, auth.SSOConfig{})
+	srv := NewServer(stub, notesHandler, authHandler, Options{Keys: pkg.SingleJWTKey(testSecret), QueryTimeout: time.Second, ReadOnly: readOnly})
+
+	lis := bufconn.Listen(1 << 20)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("error dialing server: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return &testHelper{t: t, mockDB: mockDB, conn: conn}
+}
+
+// authorized returns a context carrying a valid token for user123 and
+// expects the token version lookup it triggers
+func (h *testHelper) authorized() context.Context {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user-id": "user123",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		h.t.Fatalf("error signing token: %v", err)
+	}
+
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT token_version, role FROM users WHERE id = ? AND deleted_at IS NULL")).
+		WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"token_version", "role"}).AddRow(0, "user"))
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestNotes_RequiresToken(t *testing.T) {
+	helper := newTestHelper(t)
+	client := quantav1.NewNotesServiceClient(helper.conn)
+
+	_, err := client.ListNotes(context.Background(), &quantav1.ListNotesRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGetNote(t *testing.T) {
+	helper := newTestHelper(t)
+	client := quantav1.NewNotesServiceClient(helper.conn)
+	query := regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE id = ?")
+	columns := []string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	ctx := helper.authorized()
+	helper.mockDB.ExpectQuery(query).WithArgs("note1", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("note1", "user123", nil, "Groceries", "Milk", true, false, 2, now, now, 0, 0, false, nil, nil, nil))
+	note, err := client.GetNote(ctx, &quantav1.GetNoteRequest{Id: "note1"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Groceries", note.GetTitle())
+		assert.Empty(t, note.GetWorkspaceId())
+		assert.True(t, note.GetPinned())
+		assert.Equal(t, int64(2), note.GetVersion())
+		assert.Equal(t, now, note.GetUpdatedAt().AsTime())
+	}
+
+	ctx = helper.authorized()
+	helper.mockDB.ExpectQuery(query).WithArgs("missing", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows(columns))
+	_, err = client.GetNote(ctx, &quantav1.GetNoteRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateNote_Invalid(t *testing.T) {
+	helper := newTestHelper(t)
+	client := quantav1.NewNotesServiceClient(helper.conn)
+
+	_, err := client.CreateNote(helper.authorized(), &quantav1.CreateNoteRequest{Content: "Milk"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	if assert.Len(t, st.Details(), 1) {
+		details := st.Details()[0].(*errdetails.BadRequest)
+		assert.Equal(t, "title", details.GetFieldViolations()[0].GetField())
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestMaintenance_RefusesWrites(t *testing.T) {
+	helper := newTestHelperWith(t, func() bool { return true })
+	client := quantav1.NewNotesServiceClient(helper.conn)
+
+	_, err := client.CreateNote(context.Background(), &quantav1.CreateNoteRequest{Title: "Groceries"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	ctx := helper.authorized()
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, workspace_id, title")).WithArgs("missing", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	_, err = client.GetNote(ctx, &quantav1.GetNoteRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLogin_Locked(t *testing.T) {
+	helper := newTestHelper(t)
+	client := quantav1.NewAuthServiceClient(helper.conn)
+
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, password, password_version, token_version, failed_logins, locked_until FROM users WHERE email = ? AND deleted_at IS NULL")).
+		WithArgs("test@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "password", "password_version", "token_version", "failed_logins", "locked_until"}).
+			AddRow("user123", "hash", 2, 0, 5, time.Now().Add(time.Minute)))
+
+	_, err := client.Login(context.Background(), &quantav1.LoginRequest{Email: "test@example.com", Password: "password"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	if assert.Len(t, st.Details(), 1) {
+		retry := st.Details()[0].(*errdetails.RetryInfo)
+		assert.Positive(t, retry.GetRetryDelay().AsDuration())
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	helper := newTestHelper(t)
+	client := healthpb.NewHealthClient(helper.conn)
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: quantav1.NotesService_ServiceDesc.ServiceName})
+	if assert.NoError(t, err) {
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+	}
+}
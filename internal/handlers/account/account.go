@@ -0,0 +1,162 @@
+// Package account provides handlers for the authenticated user's own
+// account, such as reading and updating their profile
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxDisplayNameLength is the longest display name a user can choose
+const MaxDisplayNameLength = 100
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// SessionCloser disconnects a user's live realtime connections
+type SessionCloser interface {
+	DisconnectUser(userID string)
+}
+
+// ProfileUpdate is the request body for UpdateProfile. Omitted fields are
+// left unchanged.
+type ProfileUpdate struct {
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	Timezone    *string `json:"timezone"`
+	PublicKey   *string `json:"public_key"`
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Handler handles HTTP requests related to the current user's account
+type Handler struct {
+	db       DBInterface
+	sessions SessionCloser
+	audit    AuditLogger
+}
+
+// NewHandler creates a new Handler with the provided database interface,
+// the realtime sessions to close when an account is deleted and the audit
+// log that records the deletion
+func NewHandler(db DBInterface, sessions SessionCloser, auditLog AuditLogger) *Handler {
+	return &Handler{db: db, sessions: sessions, audit: auditLog}
+}
+
+// GetProfile returns the profile of the authenticated user
+func (h *Handler) GetProfile(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	err = h.db.QueryRowContext(c.UserContext(),
+		"SELECT id, email, COALESCE(display_name, ''), COALESCE(avatar_url, ''), timezone, COALESCE(public_key, ''), role, created_at FROM users WHERE id = ?",
+		userID,
+	).Scan(&user.ID, &user.Email, &user.DisplayName, &user.AvatarURL, &user.Timezone, &user.PublicKey, &user.Role, &user.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "User not found")
+		}
+		return fmt.Errorf("fetching profile: %w", err)
+	}
+
+	return c.JSON(user)
+}
+
+// UpdateProfile updates any of the display name, avatar URL, timezone and
+// public key of the authenticated user and returns the updated profile.
+// Fields left out of the payload are unchanged.
+func (h *Handler) UpdateProfile(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload ProfileUpdate
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+
+	var sets []string
+	var args []any
+
+	if payload.DisplayName != nil {
+		name := strings.TrimSpace(*payload.DisplayName)
+		if name == "" {
+			return apperr.New(fiber.StatusBadRequest, "Display name cannot be empty")
+		}
+		if utf8.RuneCountInString(name) > MaxDisplayNameLength {
+			return apperr.New(fiber.StatusBadRequest, "Display name is too long")
+		}
+		sets = append(sets, "display_name = ?")
+		args = append(args, name)
+	}
+
+	if payload.AvatarURL != nil {
+		avatar := strings.TrimSpace(*payload.AvatarURL)
+		if avatar != "" {
+			u, err := url.Parse(avatar)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return apperr.New(fiber.StatusBadRequest, "Avatar URL must be an http(s) URL")
+			}
+		}
+		sets = append(sets, "avatar_url = ?")
+		args = append(args, sql.NullString{String: avatar, Valid: avatar != ""})
+	}
+
+	if payload.Timezone != nil {
+		tz := strings.TrimSpace(*payload.Timezone)
+		if _, err := time.LoadLocation(tz); err != nil || tz == "" {
+			return apperr.New(fiber.StatusBadRequest, "Unknown timezone")
+		}
+		sets = append(sets, "timezone = ?")
+		args = append(args, tz)
+	}
+
+	// The key is opaque to the server; clients use it to wrap the keys of
+	// encrypted notes shared with this user. An empty key withdraws it.
+	if payload.PublicKey != nil {
+		key := strings.TrimSpace(*payload.PublicKey)
+		if len(key) > models.MaxPublicKeyLength {
+			return apperr.New(fiber.StatusBadRequest, "Public key is too long")
+		}
+		sets = append(sets, "public_key = ?")
+		args = append(args, sql.NullString{String: key, Valid: key != ""})
+	}
+
+	if len(sets) == 0 {
+		return apperr.New(fiber.StatusBadRequest, "No fields to update")
+	}
+
+	args = append(args, userID)
+	_, err = h.db.ExecContext(c.UserContext(), "UPDATE users SET "+strings.Join(sets, ", ")+" WHERE id = ?", args...)
+	if err != nil {
+		return fmt.Errorf("updating profile: %w", err)
+	}
+
+	// MySQL reports zero affected rows when nothing changed, so respond
+	// with the stored profile rather than relying on RowsAffected
+	return h.GetProfile(c)
+}
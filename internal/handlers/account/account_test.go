@@ -0,0 +1,248 @@
+package account
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const profileQuery = "SELECT id, email, COALESCE(display_name, ''), COALESCE(avatar_url, ''), timezone, COALESCE(public_key, ''), role, created_at FROM users WHERE id = ?"
+
+var profileColumns = []string{"id", "email", "display_name", "avatar_url", "timezone", "public_key", "role", "created_at"}
+
+// testHelper contains common test setup and utilities
+type testHelper struct {
+	t       *testing.T
+	db      *sql.DB
+	mockDB  sqlmock.Sqlmock
+	app     *fiber.App
+	handler *Handler
+}
+
+// newTestHelper creates a new test helper with common setup
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	handler := NewHandler(db, &fakeSessions{}, &fakeAudit{})
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+
+	// Mock user ID in context
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+
+	return &testHelper{
+		t:       t,
+		db:      db,
+		mockDB:  mockDB,
+		app:     app,
+		handler: handler,
+	}
+}
+
+func TestGetProfile(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.app.Get("/me", helper.handler.GetProfile)
+
+	now := time.Now()
+	testCases := []struct {
+		name           string
+		mockRows       *sqlmock.Rows
+		mockError      error
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name: "Success",
+			mockRows: sqlmock.NewRows(profileColumns).
+				AddRow("user123", "test@example.com", "Tester", "https://example.com/a.png", "Europe/Berlin", "", "user", now),
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "User Not Found",
+			mockRows:       sqlmock.NewRows(profileColumns),
+			expectedStatus: fiber.StatusNotFound,
+			expectedError:  "User not found",
+		},
+		{
+			name:           "Database Error",
+			mockError:      errors.New("database error"),
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectation := helper.mockDB.ExpectQuery(regexp.QuoteMeta(profileQuery)).WithArgs("user123")
+			if tc.mockError != nil {
+				expectation.WillReturnError(tc.mockError)
+			} else {
+				expectation.WillReturnRows(tc.mockRows)
+			}
+
+			resp, err := helper.app.Test(httptest.NewRequest("GET", "/me", nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			var response map[string]any
+			if tc.expectedStatus != fiber.StatusInternalServerError {
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+			}
+			if tc.expectedError != "" {
+				assert.Equal(t, tc.expectedError, response["message"])
+			} else if tc.expectedStatus == fiber.StatusOK {
+				assert.Equal(t, "Tester", response["display_name"])
+				assert.Equal(t, "Europe/Berlin", response["timezone"])
+				assert.NotContains(t, response, "password")
+			}
+		})
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.app.Patch("/me", helper.handler.UpdateProfile)
+
+	testCases := []struct {
+		name           string
+		payload        map[string]any
+		expectedQuery  string
+		expectedArgs   []any
+		mockError      error
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Update All Fields",
+			payload:        map[string]any{"display_name": "  Tester ", "avatar_url": "https://example.com/a.png", "timezone": "Europe/Berlin"},
+			expectedQuery:  "UPDATE users SET display_name = ?, avatar_url = ?, timezone = ? WHERE id = ?",
+			expectedArgs:   []any{"Tester", "https://example.com/a.png", "Europe/Berlin", "user123"},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Clear Avatar",
+			payload:        map[string]any{"avatar_url": ""},
+			expectedQuery:  "UPDATE users SET avatar_url = ? WHERE id = ?",
+			expectedArgs:   []any{nil, "user123"},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Publish Public Key",
+			payload:        map[string]any{"public_key": " key-material\n"},
+			expectedQuery:  "UPDATE users SET public_key = ? WHERE id = ?",
+			expectedArgs:   []any{"key-material", "user123"},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Public Key Too Long",
+			payload:        map[string]any{"public_key": strings.Repeat("k", models.MaxPublicKeyLength+1)},
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "Public key is too long",
+		},
+		{
+			name:           "Empty Display Name",
+			payload:        map[string]any{"display_name": "   "},
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "Display name cannot be empty",
+		},
+		{
+			name:           "Invalid Avatar URL",
+			payload:        map[string]any{"avatar_url": "javascript:alert(1)"},
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "Avatar URL must be an http(s) URL",
+		},
+		{
+			name:           "Unknown Timezone",
+			payload:        map[string]any{"timezone": "Mars/Olympus"},
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "Unknown timezone",
+		},
+		{
+			name:           "No Fields",
+			payload:        map[string]any{},
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "No fields to update",
+		},
+		{
+			name:           "Database Error",
+			payload:        map[string]any{"timezone": "UTC"},
+			expectedQuery:  "UPDATE users SET timezone = ? WHERE id = ?",
+			expectedArgs:   []any{"UTC", "user123"},
+			mockError:      errors.New("database error"),
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.expectedQuery != "" {
+				args := make([]driver.Value, len(tc.expectedArgs))
+				for i, a := range tc.expectedArgs {
+					args[i] = a
+				}
+				expectation := helper.mockDB.ExpectExec(regexp.QuoteMeta(tc.expectedQuery)).WithArgs(args...)
+				if tc.mockError != nil {
+					expectation.WillReturnError(tc.mockError)
+				} else {
+					expectation.WillReturnResult(sqlmock.NewResult(0, 1))
+					helper.mockDB.ExpectQuery(regexp.QuoteMeta(profileQuery)).WithArgs("user123").
+						WillReturnRows(sqlmock.NewRows(profileColumns).
+							AddRow("user123", "test@example.com", "Tester", "", "UTC", "", "user", time.Now()))
+				}
+			}
+
+			jsonPayload, err := json.Marshal(tc.payload)
+			if err != nil {
+				t.Fatalf("error marshaling payload: %v", err)
+			}
+
+			req := httptest.NewRequest("PATCH", "/me", bytes.NewBuffer(jsonPayload))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+		})
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/privacy"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultPurgeGracePeriod is how long a soft-deleted account is kept before
+// it is permanently removed
+const DefaultPurgeGracePeriod = 30 * 24 * time.Hour
+
+// DeleteRequest is the request body for DeleteAccount
+type DeleteRequest struct {
+	Password string `json:"password"`
+}
+
+// DeleteAccount soft-deletes the authenticated user after confirming their
+// password. Their private notes and collaborations are removed and what
+// they share is handed over in a single transaction, all of their tokens
+// are revoked, and their open WebSocket connections are closed. The user
+// row itself is purged after the grace period.
+func (h *Handler) DeleteAccount(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload DeleteRequest
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+
+	var hashedPw string
+	err = h.db.QueryRowContext(c.UserContext(), "SELECT password FROM users WHERE id = ? AND deleted_at IS NULL", userID).Scan(&hashedPw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "User not found")
+		}
+		return fmt.Errorf("fetching user for deletion: %w", err)
+	}
+
+	if err := pkg.CheckPasswordHash(payload.Password, hashedPw); err != nil {
+		return apperr.New(fiber.StatusUnauthorized, "Password is incorrect")
+	}
+
+	if err := SoftDelete(c.UserContext(), h.db, userID); err != nil {
+		return fmt.Errorf("deleting account: %w", err)
+	}
+
+	h.sessions.DisconnectUser(userID)
+	h.audit.Log(c.UserContext(), audit.FromRequest(c, audit.EventAccountDeleted, userID))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// SoftDelete marks the user deleted, revokes their tokens and removes the
+// data they own in a single transaction. What they share is handed over
+// as an erasure does: workspaces they own pass to another member, and the
+// notes they wrote in workspaces stay there, owned by the workspace's
+// owner. The account's email is replaced with deletedEmail so the address
+// can sign up again before the account is purged. The caller is
+// responsible for closing the user's realtime sessions.
+func SoftDelete(ctx context.Context, conn DBInterface, userID string) error {
+	return db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET deleted_at = CURRENT_TIMESTAMP, token_version = token_version + 1, email = ? WHERE id = ?",
+			deletedEmail(userID), userID,
+		); err != nil {
+			return err
+		}
+		statements := []string{
+			"DELETE FROM note_collaborators WHERE user_id = ?",
+			"DELETE FROM note_keys WHERE user_id = ?",
+		}
+		for _, stmt := range statements {
+			if _, err := tx.ExecContext(ctx, stmt, userID); err != nil {
+				return err
+			}
+		}
+		// Files of workspaces deleted with their last member are left to
+		// the attachments collector, like those of the notes deleted below
+		if _, _, err := privacy.HandOver(ctx, tx, userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM notes WHERE user_id = ? AND workspace_id IS NULL", userID)
+		return err
+	})
+}
+
+// deletedEmail is the unique placeholder a soft-deleted account's email is
+// replaced with. The .invalid domain can never receive mail.
+func deletedEmail(userID string) string {
+	return "deleted-" + userID + "@deleted.invalid"
+}
+
+// PurgeDeletedUsers permanently removes accounts that were soft-deleted more
+// than gracePeriod ago and returns how many were removed
+func PurgeDeletedUsers(ctx context.Context, db DBInterface, gracePeriod time.Duration) (int64, error) {
+	result, err := db.ExecContext(ctx,
+		"DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ?",
+		time.Now().Add(-gracePeriod),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// StartPurger runs PurgeDeletedUsers on every interval until stop is closed.
+// Each run must finish within the interval.
+// Nothing is purged while paused reports true.
+func StartPurger(db DBInterface, gracePeriod, interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			purged, err := PurgeDeletedUsers(ctx, db, gracePeriod)
+			cancel()
+			if err != nil {
+				log.Println("Error purging deleted accounts:", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d deleted accounts", purged)
+			}
+		}
+	}
+}
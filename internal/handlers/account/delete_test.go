@@ -0,0 +1,186 @@
+package account
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSessions records which users were disconnected
+type fakeSessions struct {
+	disconnected []string
+}
+
+func (f *fakeSessions) DisconnectUser(userID string) {
+	f.disconnected = append(f.disconnected, userID)
+}
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	// Use a valid bcrypt hash for 'password123'
+	validHash := "$2a$10$E6HhdnHa3eB0JwE2ZyieJuxCAYpWDYe403HM/LKSPi3FVetNZDk4i"
+	selectQuery := regexp.QuoteMeta("SELECT password FROM users WHERE id = ? AND deleted_at IS NULL")
+
+	testCases := []struct {
+		name             string
+		password         string
+		mockRows         *sqlmock.Rows
+		txError          error
+		expectTx         bool
+		expectedStatus   int
+		expectedError    string
+		expectDisconnect bool
+	}{
+		{
+			name:             "Success",
+			password:         "password123",
+			mockRows:         sqlmock.NewRows([]string{"password"}).AddRow(validHash),
+			expectTx:         true,
+			expectedStatus:   fiber.StatusNoContent,
+			expectDisconnect: true,
+		},
+		{
+			name:           "Wrong Password",
+			password:       "wrongpassword",
+			mockRows:       sqlmock.NewRows([]string{"password"}).AddRow(validHash),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Password is incorrect",
+		},
+		{
+			name:           "User Not Found",
+			password:       "password123",
+			mockRows:       sqlmock.NewRows([]string{"password"}),
+			expectedStatus: fiber.StatusNotFound,
+			expectedError:  "User not found",
+		},
+		{
+			name:           "Transaction Rolled Back",
+			password:       "password123",
+			mockRows:       sqlmock.NewRows([]string{"password"}).AddRow(validHash),
+			expectTx:       true,
+			txError:        errors.New("database error"),
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			sessions := &fakeSessions{}
+			helper.handler.sessions = sessions
+			auditLog := &fakeAudit{}
+			helper.handler.audit = auditLog
+			helper.app.Delete("/me", helper.handler.DeleteAccount)
+
+			helper.mockDB.ExpectQuery(selectQuery).WithArgs("user123").WillReturnRows(tc.mockRows)
+			if tc.expectTx {
+				helper.mockDB.ExpectBegin()
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE users SET deleted_at = CURRENT_TIMESTAMP, token_version = token_version + 1, email = ? WHERE id = ?")).
+					WithArgs("deleted-user123@deleted.invalid", "user123").WillReturnResult(sqlmock.NewResult(0, 1))
+				if tc.txError != nil {
+					helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM note_collaborators WHERE user_id = ?")).
+						WithArgs("user123").WillReturnError(tc.txError)
+					helper.mockDB.ExpectRollback()
+				} else {
+					helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM note_collaborators WHERE user_id = ?")).
+						WithArgs("user123").WillReturnResult(sqlmock.NewResult(0, 2))
+					helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM note_keys WHERE user_id = ?")).
+						WithArgs("user123").WillReturnResult(sqlmock.NewResult(0, 1))
+					// Their workspace passes to its admin and their notes there
+					// stay with it
+					helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT workspace_id FROM workspace_members WHERE user_id = ? AND role = ?")).
+						WithArgs("user123", "owner").WillReturnRows(sqlmock.NewRows([]string{"workspace_id"}).AddRow("ws1"))
+					helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM workspace_members WHERE workspace_id = ? AND user_id <> ?")).
+						WithArgs("ws1", "user123").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("user456"))
+					helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?")).
+						WithArgs("owner", "ws1", "user456").WillReturnResult(sqlmock.NewResult(0, 1))
+					helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM workspace_members WHERE user_id = ?")).
+						WithArgs("user123").WillReturnResult(sqlmock.NewResult(0, 2))
+					helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE notes SET user_id = (SELECT m.user_id FROM workspace_members m WHERE m.workspace_id = notes.workspace_id AND m.role = ?")).
+						WithArgs("owner", "user123").WillReturnResult(sqlmock.NewResult(0, 3))
+					helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE attachments SET user_id = (SELECT n.user_id FROM notes n WHERE n.id = attachments.note_id) WHERE user_id = ?")).
+						WithArgs("user123").WillReturnResult(sqlmock.NewResult(0, 1))
+					helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM notes WHERE user_id = ? AND workspace_id IS NULL")).
+						WithArgs("user123").WillReturnResult(sqlmock.NewResult(0, 5))
+					helper.mockDB.ExpectCommit()
+				}
+			}
+
+			jsonPayload, err := json.Marshal(map[string]string{"password": tc.password})
+			if err != nil {
+				t.Fatalf("error marshaling payload: %v", err)
+			}
+
+			req := httptest.NewRequest("DELETE", "/me", bytes.NewBuffer(jsonPayload))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+
+			if tc.expectDisconnect {
+				assert.Equal(t, []string{"user123"}, sessions.disconnected)
+				if assert.Len(t, auditLog.entries, 1) {
+					assert.Equal(t, audit.EventAccountDeleted, auditLog.entries[0].Event)
+					assert.Equal(t, "user123", auditLog.entries[0].ActorID)
+				}
+			} else {
+				assert.Empty(t, sessions.disconnected)
+				assert.Empty(t, auditLog.entries)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ?")).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	purged, err := PurgeDeletedUsers(context.Background(), db, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PreferencesUpdate is the request body for UpdatePreferences. Omitted
+// fields are left unchanged.
+type PreferencesUpdate struct {
+	// Digest is off, daily or weekly
+	Digest *models.DigestFrequency `json:"digest"`
+}
+
+// GetPreferences returns the authenticated user's settings
+func (h *Handler) GetPreferences(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	prefs := models.DefaultPreferences
+	err = h.db.QueryRowContext(c.UserContext(), "SELECT digest FROM user_preferences WHERE user_id = ?", userID).
+		Scan(&prefs.Digest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("fetching preferences: %w", err)
+	}
+
+	return c.JSON(prefs)
+}
+
+// UpdatePreferences changes any of the authenticated user's settings and
+// returns them all
+func (h *Handler) UpdatePreferences(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload PreferencesUpdate
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.Digest == nil {
+		return apperr.New(fiber.StatusBadRequest, "No fields to update")
+	}
+	if !payload.Digest.Valid() {
+		return apperr.Invalid(map[string]string{"digest": "must be off, daily or weekly"})
+	}
+	ctx := c.UserContext()
+
+	result, err := h.db.ExecContext(ctx, "UPDATE user_preferences SET digest = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?", *payload.Digest, userID)
+	if err != nil {
+		return fmt.Errorf("updating preferences: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		_, err := h.db.ExecContext(ctx, "INSERT INTO user_preferences (user_id, digest) VALUES (?, ?)", userID, *payload.Digest)
+		// MySQL reports zero affected rows when nothing changed, in which
+		// case the row is already there
+		if err != nil && !db.IsDuplicate(err) {
+			return fmt.Errorf("storing preferences: %w", err)
+		}
+	}
+
+	return h.GetPreferences(c)
+}
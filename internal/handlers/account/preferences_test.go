@@ -0,0 +1,101 @@
+package account
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"quanta/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreferences(t *testing.T) {
+	prefsQuery := regexp.QuoteMeta("SELECT digest FROM user_preferences WHERE user_id = ?")
+	update := regexp.QuoteMeta("UPDATE user_preferences SET digest = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?")
+	insert := regexp.QuoteMeta("INSERT INTO user_preferences (user_id, digest) VALUES (?, ?)")
+
+	testCases := []struct {
+		name           string
+		method         string
+		payload        string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expected       models.Preferences
+	}{
+		{
+			name:   "Defaults",
+			method: "GET",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(prefsQuery).WithArgs("user123").WillReturnRows(sqlmock.NewRows([]string{"digest"}))
+			},
+			expectedStatus: fiber.StatusOK,
+			expected:       models.DefaultPreferences,
+		},
+		{
+			name:    "First Change",
+			method:  "PATCH",
+			payload: `{"digest":"weekly"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(update).WithArgs("weekly", "user123").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insert).WithArgs("user123", "weekly").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(prefsQuery).WithArgs("user123").WillReturnRows(sqlmock.NewRows([]string{"digest"}).AddRow("weekly"))
+			},
+			expectedStatus: fiber.StatusOK,
+			expected:       models.Preferences{Digest: models.DigestWeekly},
+		},
+		{
+			name:    "Unchanged",
+			method:  "PATCH",
+			payload: `{"digest":"daily"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(update).WithArgs("daily", "user123").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insert).WithArgs("user123", "daily").WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+				mock.ExpectQuery(prefsQuery).WithArgs("user123").WillReturnRows(sqlmock.NewRows([]string{"digest"}).AddRow("daily"))
+			},
+			expectedStatus: fiber.StatusOK,
+			expected:       models.Preferences{Digest: models.DigestDaily},
+		},
+		{
+			name:           "Unknown Frequency",
+			method:         "PATCH",
+			payload:        `{"digest":"hourly"}`,
+			setupMock:      func(mock sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "No Fields",
+			method:         "PATCH",
+			payload:        `{}`,
+			setupMock:      func(mock sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.app.Get("/me/preferences", helper.handler.GetPreferences)
+			helper.app.Patch("/me/preferences", helper.handler.UpdatePreferences)
+			tc.setupMock(helper.mockDB)
+
+			req := httptest.NewRequest(tc.method, "/me/preferences", bytes.NewBufferString(tc.payload))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var prefs models.Preferences
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&prefs))
+				assert.Equal(t, tc.expected, prefs)
+			}
+			assert.NoError(t, helper.mockDB.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,316 @@
+// Package admin provides the administrator endpoints for managing user
+// accounts and watching the server's load. Every route is expected to sit
+// behind RequireRole(RoleAdmin).
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/handlers/account"
+	authhandler "quanta/internal/handlers/auth"
+	"quanta/internal/paging"
+	"quanta/internal/realtime"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// DefaultLimit is how many users ListUsers returns when ?limit= is omitted
+	DefaultLimit = 50
+	// MaxLimit caps ?limit= on ListUsers
+	MaxLimit = 200
+	// MaxLockDuration is how far ahead LockUser may set a lock. It keeps the
+	// expiry inside the range of a MySQL TIMESTAMP.
+	MaxLockDuration = 5 * 365 * 24 * time.Hour
+)
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Realtime is the realtime server, whose connections are closed when an
+// account is locked or deleted and whose load GetStats reports
+type Realtime interface {
+	DisconnectUser(userID string)
+	ServerStats() realtime.ServerStats
+}
+
+// User is an account as administrators see it
+type User struct {
+	ID           string     `json:"id"`
+	Email        string     `json:"email"`
+	DisplayName  string     `json:"display_name"`
+	Role         string     `json:"role"`
+	NoteCount    int        `json:"note_count"`
+	FailedLogins int        `json:"failed_logins"`
+	LockedUntil  *time.Time `json:"locked_until"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DeletedAt    *time.Time `json:"deleted_at"`
+}
+
+// LockRequest is the request body for LockUser
+type LockRequest struct {
+	Until time.Time `json:"until"`
+}
+
+// PasswordReset is the response body for ResetPassword
+type PasswordReset struct {
+	TemporaryPassword string `json:"temporary_password"`
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Handler handles the administrator endpoints
+type Handler struct {
+	db       DBInterface
+	sessions Realtime
+	audit    AuditLogger
+}
+
+// NewHandler creates a new Handler with the provided database interface,
+// the realtime server and the audit log that records every change an
+// administrator makes
+func NewHandler(db DBInterface, sessions Realtime, auditLog AuditLogger) *Handler {
+	return &Handler{db: db, sessions: sessions, audit: auditLog}
+}
+
+const userColumns = "SELECT u.id, u.email, COALESCE(u.display_name, ''), u.role, " +
+	"(SELECT COUNT(*) FROM notes n WHERE n.user_id = u.id), u.failed_logins, u.locked_until, u.created_at, u.deleted_at FROM users u"
+
+// ListUsers lists accounts, newest first, with their note counts. ?q=
+// filters by a case-insensitive substring of the email or display name,
+// and ?limit= and ?offset= page through the results. Soft-deleted accounts
+// are included until they are purged.
+func (h *Handler) ListUsers(c *fiber.Ctx) error {
+	limit, offset, err := paging.Parse(c, DefaultLimit, MaxLimit)
+	if err != nil {
+		return err
+	}
+
+	query := userColumns
+	var args []any
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := "%" + db.EscapeLike(strings.ToLower(q)) + "%"
+		query += " WHERE LOWER(u.email) LIKE ? ESCAPE '!' OR LOWER(COALESCE(u.display_name, '')) LIKE ? ESCAPE '!'"
+		args = append(args, pattern, pattern)
+	}
+	query += " ORDER BY u.created_at DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := h.db.QueryContext(c.UserContext(), query, args...)
+	if err != nil {
+		return fmt.Errorf("listing users: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return fmt.Errorf("scanning user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("listing users: %w", err)
+	}
+
+	return c.JSON(users)
+}
+
+// GetUser returns a single account with its note count
+func (h *Handler) GetUser(c *fiber.Ctx) error {
+	u, err := scanUser(h.db.QueryRowContext(c.UserContext(), userColumns+" WHERE u.id = ?", c.Params("id")))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "User not found")
+		}
+		return fmt.Errorf("fetching user: %w", err)
+	}
+	return c.JSON(u)
+}
+
+// LockUser locks an account until the given time. The user's tokens are
+// revoked and their realtime sessions closed, and logging in is refused
+// with 423 Locked until the lock expires or UnlockUser lifts it.
+func (h *Handler) LockUser(c *fiber.Ctx) error {
+	adminID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	userID := c.Params("id")
+
+	var payload LockRequest
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if !payload.Until.After(time.Now()) {
+		return apperr.Invalid(map[string]string{"until": "must be in the future"})
+	}
+	if payload.Until.After(time.Now().Add(MaxLockDuration)) {
+		return apperr.Invalid(map[string]string{"until": "must be within 5 years"})
+	}
+	if userID == adminID {
+		return apperr.New(fiber.StatusBadRequest, "You cannot lock your own account")
+	}
+
+	result, err := h.db.ExecContext(c.UserContext(),
+		"UPDATE users SET locked_until = ?, token_version = token_version + 1 WHERE id = ? AND deleted_at IS NULL",
+		payload.Until, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("locking user: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return apperr.New(fiber.StatusNotFound, "User not found")
+	}
+
+	h.sessions.DisconnectUser(userID)
+	entry := audit.FromRequest(c, audit.EventAccountLocked, userID)
+	entry.Details = map[string]string{"until": payload.Until.UTC().Format(time.RFC3339)}
+	h.audit.Log(c.UserContext(), entry)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// UnlockUser lifts an administrator lock or a lock from failed logins
+func (h *Handler) UnlockUser(c *fiber.Ctx) error {
+	userID := c.Params("id")
+
+	if err := h.requireUser(c.UserContext(), userID); err != nil {
+		return err
+	}
+	if err := authhandler.UnlockAccount(c.UserContext(), h.db, userID); err != nil {
+		return fmt.Errorf("unlocking user: %w", err)
+	}
+	h.audit.Log(c.UserContext(), audit.FromRequest(c, audit.EventAccountUnlocked, userID))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteUser soft-deletes an account the same way DELETE /me does, without
+// asking for the user's password
+func (h *Handler) DeleteUser(c *fiber.Ctx) error {
+	adminID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	userID := c.Params("id")
+
+	if userID == adminID {
+		return apperr.New(fiber.StatusBadRequest, "Use DELETE /me to delete your own account")
+	}
+	if err := h.requireUser(c.UserContext(), userID); err != nil {
+		return err
+	}
+
+	if err := account.SoftDelete(c.UserContext(), h.db, userID); err != nil {
+		return fmt.Errorf("deleting user: %w", err)
+	}
+
+	h.sessions.DisconnectUser(userID)
+	h.audit.Log(c.UserContext(), audit.FromRequest(c, audit.EventAccountDeleted, userID))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ResetPassword replaces an account's password with a random temporary one,
+// revokes the user's tokens and sessions and clears any lock. The temporary password is
+// returned once for the administrator to pass on; the user should change
+// it with POST /me/password.
+func (h *Handler) ResetPassword(c *fiber.Ctx) error {
+	userID := c.Params("id")
+
+	temporary, err := temporaryPassword()
+	if err != nil {
+		return fmt.Errorf("generating password: %w", err)
+	}
+	hashed, err := pkg.HashPassword(temporary)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+
+	result, err := h.db.ExecContext(c.UserContext(),
+		"UPDATE users SET password = ?, password_version = ?, token_version = token_version + 1, failed_logins = 0, locked_until = NULL WHERE id = ? AND deleted_at IS NULL",
+		hashed, pkg.PasswordVersion(), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("resetting password: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return apperr.New(fiber.StatusNotFound, "User not found")
+	}
+	if _, err := h.db.ExecContext(c.UserContext(), "DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("ending sessions: %w", err)
+	}
+
+	h.sessions.DisconnectUser(userID)
+	h.audit.Log(c.UserContext(), audit.FromRequest(c, audit.EventPasswordReset, userID))
+
+	return c.JSON(PasswordReset{TemporaryPassword: temporary})
+}
+
+// requireUser returns a 404 error unless userID is an active account
+func (h *Handler) requireUser(ctx context.Context, userID string) error {
+	var exists bool
+	err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)", userID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("checking user: %w", err)
+	}
+	if !exists {
+		return apperr.New(fiber.StatusNotFound, "User not found")
+	}
+	return nil
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanUser reads a row selected with userColumns
+func scanUser(row scanner) (User, error) {
+	var u User
+	var lockedUntil, deletedAt sql.NullTime
+	err := row.Scan(&u.ID, &u.Email, &u.DisplayName, &u.Role, &u.NoteCount, &u.FailedLogins, &lockedUntil, &u.CreatedAt, &deletedAt)
+	if lockedUntil.Valid {
+		u.LockedUntil = &lockedUntil.Time
+	}
+	if deletedAt.Valid {
+		u.DeletedAt = &deletedAt.Time
+	}
+	return u, err
+}
+
+// temporaryPassword returns a random 16 character password
+func temporaryPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,378 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/realtime"
+	"quanta/pkg"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var userRowColumns = []string{"id", "email", "display_name", "role", "note_count", "failed_logins", "locked_until", "created_at", "deleted_at"}
+
+// fakeSessions records which users were disconnected and reports stats
+type fakeSessions struct {
+	disconnected []string
+	stats        realtime.ServerStats
+}
+
+func (f *fakeSessions) DisconnectUser(userID string) {
+	f.disconnected = append(f.disconnected, userID)
+}
+
+func (f *fakeSessions) ServerStats() realtime.ServerStats {
+	return f.stats
+}
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+// testHelper contains common test setup and utilities
+type testHelper struct {
+	mockDB   sqlmock.Sqlmock
+	app      *fiber.App
+	handler  *Handler
+	sessions *fakeSessions
+	audit    *fakeAudit
+}
+
+// newTestHelper creates a handler backed by sqlmock, with admin1 as the
+// authenticated administrator
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	sessions := &fakeSessions{}
+	auditLog := &fakeAudit{}
+	handler := NewHandler(db, sessions, auditLog)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "admin1")
+		return c.Next()
+	})
+	app.Get("/admin/stats", handler.GetStats)
+	app.Get("/admin/users", handler.ListUsers)
+	app.Get("/admin/users/:id", handler.GetUser)
+	app.Delete("/admin/users/:id", handler.DeleteUser)
+	app.Post("/admin/users/:id/lock", handler.LockUser)
+	app.Post("/admin/users/:id/unlock", handler.UnlockUser)
+	app.Post("/admin/users/:id/password-reset", handler.ResetPassword)
+
+	return &testHelper{mockDB: mockDB, app: app, handler: handler, sessions: sessions, audit: auditLog}
+}
+
+// do performs a request with an optional JSON body
+func (h *testHelper) do(t *testing.T, method, path string, body any) *apperr.Response {
+	var reader *bytes.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+
+	var response apperr.Response
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+	response.Code = resp.StatusCode
+	return &response
+}
+
+func TestListUsers(t *testing.T) {
+	now := time.Now()
+	baseQuery := "SELECT u.id, u.email, COALESCE(u.display_name, ''), u.role, (SELECT COUNT(*) FROM notes n WHERE n.user_id = u.id), u.failed_logins, u.locked_until, u.created_at, u.deleted_at FROM users u"
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectQuery    string
+		args           []driver.Value
+		mockError      error
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "All Users",
+			expectQuery:    baseQuery + " ORDER BY u.created_at DESC LIMIT ? OFFSET ?",
+			args:           []driver.Value{DefaultLimit, 0},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Search With Wildcards",
+			query:          "?q=Al_ice&limit=500&offset=10",
+			expectQuery:    baseQuery + " WHERE LOWER(u.email) LIKE ? ESCAPE '!' OR LOWER(COALESCE(u.display_name, '')) LIKE ? ESCAPE '!' ORDER BY u.created_at DESC LIMIT ? OFFSET ?",
+			args:           []driver.Value{"%al!_ice%", "%al!_ice%", MaxLimit, 10},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Database Error",
+			expectQuery:    baseQuery + " ORDER BY u.created_at DESC LIMIT ? OFFSET ?",
+			args:           []driver.Value{DefaultLimit, 0},
+			mockError:      errors.New("database error"),
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+		{
+			name:           "Invalid Offset",
+			query:          "?offset=-1",
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "Invalid offset",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			if tc.expectQuery != "" {
+				expect := helper.mockDB.ExpectQuery(regexp.QuoteMeta(tc.expectQuery)).WithArgs(tc.args...)
+				if tc.mockError != nil {
+					expect.WillReturnError(tc.mockError)
+				} else {
+					expect.WillReturnRows(sqlmock.NewRows(userRowColumns).
+						AddRow("user1", "alice@example.com", "Alice", "user", 3, 0, nil, now, nil).
+						AddRow("user2", "bob@example.com", "", "admin", 0, 5, now.Add(time.Hour), now, now))
+				}
+			}
+
+			req := httptest.NewRequest("GET", "/admin/users"+tc.query, nil)
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var users []User
+				if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				if assert.Len(t, users, 2) {
+					assert.Equal(t, 3, users[0].NoteCount)
+					assert.Nil(t, users[0].LockedUntil)
+					assert.NotNil(t, users[1].LockedUntil)
+					assert.NotNil(t, users[1].DeletedAt)
+				}
+			} else if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetUser(t *testing.T) {
+	helper := newTestHelper(t)
+	query := regexp.QuoteMeta("FROM users u WHERE u.id = ?")
+
+	helper.mockDB.ExpectQuery(query).WithArgs("user1").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow("user1", "alice@example.com", "Alice", "user", 3, 0, nil, time.Now(), nil))
+	assert.Equal(t, fiber.StatusOK, helper.do(t, "GET", "/admin/users/user1", nil).Code)
+
+	helper.mockDB.ExpectQuery(query).WithArgs("missing").WillReturnRows(sqlmock.NewRows(userRowColumns))
+	response := helper.do(t, "GET", "/admin/users/missing", nil)
+	assert.Equal(t, fiber.StatusNotFound, response.Code)
+	assert.Equal(t, "User not found", response.Message)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLockUser(t *testing.T) {
+	until := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	lockQuery := regexp.QuoteMeta("UPDATE users SET locked_until = ?, token_version = token_version + 1 WHERE id = ? AND deleted_at IS NULL")
+
+	testCases := []struct {
+		name             string
+		path             string
+		until            time.Time
+		rowsAffected     int64
+		expectExec       bool
+		expectedStatus   int
+		fieldErrors      map[string]string
+		expectDisconnect bool
+	}{
+		{
+			name:             "Success",
+			path:             "/admin/users/user1/lock",
+			until:            until,
+			rowsAffected:     1,
+			expectExec:       true,
+			expectedStatus:   fiber.StatusNoContent,
+			expectDisconnect: true,
+		},
+		{
+			name:           "User Not Found",
+			path:           "/admin/users/missing/lock",
+			until:          until,
+			expectExec:     true,
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "In The Past",
+			path:           "/admin/users/user1/lock",
+			until:          time.Now().Add(-time.Hour),
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"until": "must be in the future"},
+		},
+		{
+			name:           "Too Far Ahead",
+			path:           "/admin/users/user1/lock",
+			until:          time.Now().Add(MaxLockDuration + time.Hour),
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"until": "must be within 5 years"},
+		},
+		{
+			name:           "Own Account",
+			path:           "/admin/users/admin1/lock",
+			until:          until,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			if tc.expectExec {
+				helper.mockDB.ExpectExec(lockQuery).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, tc.rowsAffected))
+			}
+
+			response := helper.do(t, "POST", tc.path, LockRequest{Until: tc.until})
+			assert.Equal(t, tc.expectedStatus, response.Code)
+			assert.Equal(t, tc.fieldErrors, response.Errors)
+			assert.Equal(t, tc.expectDisconnect, len(helper.sessions.disconnected) == 1)
+			assert.Equal(t, tc.expectDisconnect, len(helper.audit.entries) == 1)
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnlockUser(t *testing.T) {
+	helper := newTestHelper(t)
+	existsQuery := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)")
+
+	helper.mockDB.ExpectQuery(existsQuery).WithArgs("user1").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?")).
+		WithArgs("user1").WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.Equal(t, fiber.StatusNoContent, helper.do(t, "POST", "/admin/users/user1/unlock", nil).Code)
+	if assert.Len(t, helper.audit.entries, 1) {
+		entry := helper.audit.entries[0]
+		assert.Equal(t, audit.EventAccountUnlocked, entry.Event)
+		assert.Equal(t, "user1", entry.UserID)
+		assert.Equal(t, "admin1", entry.ActorID)
+	}
+
+	helper.mockDB.ExpectQuery(existsQuery).WithArgs("missing").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	assert.Equal(t, fiber.StatusNotFound, helper.do(t, "POST", "/admin/users/missing/unlock", nil).Code)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	helper := newTestHelper(t)
+	existsQuery := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND deleted_at IS NULL)")
+
+	assert.Equal(t, fiber.StatusBadRequest, helper.do(t, "DELETE", "/admin/users/admin1", nil).Code)
+
+	helper.mockDB.ExpectQuery(existsQuery).WithArgs("user1").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	helper.mockDB.ExpectBegin()
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE users SET deleted_at")).WithArgs("deleted-user1@deleted.invalid", "user1").WillReturnResult(sqlmock.NewResult(0, 1))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM note_collaborators")).WithArgs("user1").WillReturnResult(sqlmock.NewResult(0, 0))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM note_keys")).WithArgs("user1").WillReturnResult(sqlmock.NewResult(0, 0))
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT workspace_id FROM workspace_members")).WithArgs("user1", "owner").WillReturnRows(sqlmock.NewRows([]string{"workspace_id"}))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM workspace_members")).WithArgs("user1").WillReturnResult(sqlmock.NewResult(0, 1))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE notes SET user_id")).WithArgs("owner", "user1").WillReturnResult(sqlmock.NewResult(0, 1))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE attachments SET user_id")).WithArgs("user1").WillReturnResult(sqlmock.NewResult(0, 0))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM notes")).WithArgs("user1").WillReturnResult(sqlmock.NewResult(0, 2))
+	helper.mockDB.ExpectCommit()
+	assert.Equal(t, fiber.StatusNoContent, helper.do(t, "DELETE", "/admin/users/user1", nil).Code)
+	assert.Equal(t, []string{"user1"}, helper.sessions.disconnected)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	helper := newTestHelper(t)
+	resetQuery := regexp.QuoteMeta("UPDATE users SET password = ?, password_version = ?, token_version = token_version + 1, failed_logins = 0, locked_until = NULL WHERE id = ? AND deleted_at IS NULL")
+
+	var stored string
+	helper.mockDB.ExpectExec(resetQuery).WithArgs(captureArg{&stored}, pkg.PasswordVersion(), "user1").WillReturnResult(sqlmock.NewResult(0, 1))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM sessions WHERE user_id = ?")).WithArgs("user1").WillReturnResult(sqlmock.NewResult(0, 2))
+
+	req := httptest.NewRequest("POST", "/admin/users/user1/password-reset", nil)
+	resp, err := helper.app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var reset PasswordReset
+	if err := json.NewDecoder(resp.Body).Decode(&reset); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.Len(t, reset.TemporaryPassword, 16)
+	assert.NoError(t, pkg.CheckPasswordHash(reset.TemporaryPassword, stored))
+	assert.Equal(t, []string{"user1"}, helper.sessions.disconnected)
+	if assert.Len(t, helper.audit.entries, 1) {
+		assert.Equal(t, audit.EventPasswordReset, helper.audit.entries[0].Event)
+	}
+
+	helper.mockDB.ExpectExec(resetQuery).WithArgs(sqlmock.AnyArg(), pkg.PasswordVersion(), "missing").WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, fiber.StatusNotFound, helper.do(t, "POST", "/admin/users/missing/password-reset", nil).Code)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+// captureArg is a sqlmock argument matcher that stores the value it sees
+type captureArg struct {
+	into *string
+}
+
+func (a captureArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*a.into = s
+	return ok
+}
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/realtime"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// DefaultStatsDays is how many days of signups GetStats reports when
+	// ?days= is omitted
+	DefaultStatsDays = 30
+	// MaxStatsDays caps ?days= on GetStats
+	MaxStatsDays = 365
+)
+
+// Stats is an overview of the server for a dashboard
+type Stats struct {
+	// Users counts accounts that aren't deleted
+	Users int `json:"users"`
+	// DeletedUsers counts soft-deleted accounts awaiting their purge
+	DeletedUsers int `json:"deleted_users"`
+	Notes        int `json:"notes"`
+	Workspaces   int `json:"workspaces"`
+	// Realtime is the current load on the realtime server
+	Realtime realtime.ServerStats `json:"realtime"`
+	// Signups counts new accounts on each UTC day, oldest first and
+	// ending today, including days without any
+	Signups []DailyCount `json:"signups"`
+}
+
+// DailyCount is a count for one UTC day, given as YYYY-MM-DD
+type DailyCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+// GetStats returns aggregate counts for the server, so it can be watched
+// without a metrics system. ?days= sets how many days of signups to
+// include.
+func (h *Handler) GetStats(c *fiber.Ctx) error {
+	days := DefaultStatsDays
+	if raw := c.Query("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return apperr.New(fiber.StatusBadRequest, "Invalid days")
+		}
+		days = min(n, MaxStatsDays)
+	}
+
+	ctx := c.UserContext()
+	var stats Stats
+	err := h.db.QueryRowContext(ctx,
+		"SELECT (SELECT COUNT(*) FROM users WHERE deleted_at IS NULL), (SELECT COUNT(*) FROM users WHERE deleted_at IS NOT NULL), (SELECT COUNT(*) FROM notes), (SELECT COUNT(*) FROM workspaces)",
+	).Scan(&stats.Users, &stats.DeletedUsers, &stats.Notes, &stats.Workspaces)
+	if err != nil {
+		return fmt.Errorf("counting totals: %w", err)
+	}
+
+	// Days are bucketed here rather than in SQL, whose date functions
+	// differ between the supported databases
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, 1-days)
+	rows, err := h.db.QueryContext(ctx, "SELECT created_at FROM users WHERE created_at >= ?", since)
+	if err != nil {
+		return fmt.Errorf("counting signups: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	stats.Signups = make([]DailyCount, days)
+	for i := range stats.Signups {
+		stats.Signups[i].Date = since.AddDate(0, 0, i).Format(time.DateOnly)
+	}
+	for rows.Next() {
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt); err != nil {
+			return fmt.Errorf("counting signups: %w", err)
+		}
+		if i := int(createdAt.UTC().Sub(since) / (24 * time.Hour)); i >= 0 && i < days {
+			stats.Signups[i].Count++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("counting signups: %w", err)
+	}
+
+	stats.Realtime = h.sessions.ServerStats()
+	return c.JSON(stats)
+}
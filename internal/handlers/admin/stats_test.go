@@ -0,0 +1,61 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/realtime"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStats(t *testing.T) {
+	h := newTestHelper(t)
+	h.sessions.stats = realtime.ServerStats{Rooms: 2, Connections: 5, MessagesPerMinute: 120}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT (SELECT COUNT(*) FROM users WHERE deleted_at IS NULL), (SELECT COUNT(*) FROM users WHERE deleted_at IS NOT NULL), (SELECT COUNT(*) FROM notes), (SELECT COUNT(*) FROM workspaces)")).
+		WillReturnRows(sqlmock.NewRows([]string{"users", "deleted_users", "notes", "workspaces"}).AddRow(42, 1, 300, 7))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT created_at FROM users WHERE created_at >= ?")).
+		WithArgs(today.AddDate(0, 0, -2)).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).
+			AddRow(today.AddDate(0, 0, -2).Add(time.Hour)).
+			AddRow(today.Add(3 * time.Hour)).
+			AddRow(today.Add(5 * time.Hour)))
+
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/admin/stats?days=3", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var stats Stats
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	assert.Equal(t, Stats{
+		Users:        42,
+		DeletedUsers: 1,
+		Notes:        300,
+		Workspaces:   7,
+		Realtime:     realtime.ServerStats{Rooms: 2, Connections: 5, MessagesPerMinute: 120},
+		Signups: []DailyCount{
+			{Date: today.AddDate(0, 0, -2).Format(time.DateOnly), Count: 1},
+			{Date: today.AddDate(0, 0, -1).Format(time.DateOnly), Count: 0},
+			{Date: today.Format(time.DateOnly), Count: 2},
+		},
+	}, stats)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestGetStats_InvalidDays(t *testing.T) {
+	h := newTestHelper(t)
+
+	for _, days := range []string{"0", "-3", "week"} {
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/admin/stats?days="+days, nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode, days)
+	}
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,328 @@
+// Package attachments provides handlers for uploading, downloading and
+// deleting files attached to notes
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"quanta/internal/access"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/quota"
+	"quanta/internal/storage"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// MaxUploadSize is the largest single attachment accepted, in bytes
+const MaxUploadSize = 10 << 20
+
+// allowedContentTypes lists the MIME types that may be uploaded, detected
+// from the file contents rather than trusted from the client
+var allowedContentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Attachment represents a file attached to a note. Status is where it is
+// in virus scanning: unscanned, pending, clean or quarantined.
+type Attachment struct {
+	ID          string    `json:"id"`
+	NoteID      string    `json:"note_id"`
+	UserID      string    `json:"user_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Handler handles HTTP requests related to note attachments
+type Handler struct {
+	db       DBInterface
+	storage  storage.Storage
+	quota    quota.Limits
+	scanner  Scanner
+	notifier Notifier
+	// scan wakes StartScanner when an upload is waiting to be scanned
+	scan chan struct{}
+}
+
+// NewHandler creates a new Handler backed by the given database and
+// storage that keeps uploads within quotas. Uploads are scanned for
+// malware by scanner, with uploaders told through notifier when theirs
+// are quarantined; a nil scanner leaves them unscanned.
+func NewHandler(db DBInterface, store storage.Storage, quotas quota.Limits, scanner Scanner, notifier Notifier) *Handler {
+	return &Handler{
+		db:       db,
+		storage:  store,
+		quota:    quotas,
+		scanner:  scanner,
+		notifier: notifier,
+		scan:     make(chan struct{}, 1),
+	}
+}
+
+// UploadAttachment stores a multipart file upload against a note. Users
+// given a role that cannot change the note may not attach files to it.
+func (h *Handler) UploadAttachment(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+
+	allowed, err := access.CanOpen(c.UserContext(), h.db, noteID, userID)
+	if err != nil {
+		return fmt.Errorf("checking note access: %w", err)
+	}
+	if !allowed {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+	if err := access.RequireEditor(c.UserContext(), h.db, noteID, userID); err != nil {
+		return err
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Missing file")
+	}
+	if fileHeader.Size == 0 {
+		return apperr.New(fiber.StatusBadRequest, "File is empty")
+	}
+	if fileHeader.Size > MaxUploadSize {
+		return apperr.New(fiber.StatusRequestEntityTooLarge, "File exceeds the maximum upload size")
+	}
+
+	// Attachments count against whoever the note is charged to, which is
+	// not necessarily the collaborator uploading them
+	var ownerID string
+	var workspaceID sql.NullString
+	if err := h.db.QueryRowContext(c.UserContext(), "SELECT user_id, workspace_id FROM notes WHERE id = ?", noteID).Scan(&ownerID, &workspaceID); err != nil {
+		return fmt.Errorf("fetching note owner: %w", err)
+	}
+	var chargeTo *string
+	if workspaceID.Valid {
+		chargeTo = &workspaceID.String
+	}
+	if err := h.quota.Check(c.UserContext(), h.db, ownerID, chargeTo, fileHeader.Size); err != nil {
+		return err
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return fmt.Errorf("opening uploaded file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Println("Error closing uploaded file:", err)
+		}
+	}()
+
+	// Sniff the content type from the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("reading uploaded file: %w", err)
+	}
+	head = head[:n]
+
+	contentType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	if !allowedContentTypes[contentType] {
+		return apperr.New(fiber.StatusUnsupportedMediaType, "File type not allowed")
+	}
+
+	data, err := io.ReadAll(io.MultiReader(bytes.NewReader(head), file))
+	if err != nil {
+		return fmt.Errorf("reading uploaded file: %w", err)
+	}
+
+	a, err := h.save(c.UserContext(), noteID, userID, filepath.Base(fileHeader.Filename), contentType, data)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(a)
+}
+
+// Attach stores data as an attachment of a private note of the user's,
+// as UploadAttachment would, for files that arrive some other way than an
+// upload, such as inside an imported export
+func (h *Handler) Attach(ctx context.Context, userID, noteID, filename string, data []byte) (Attachment, error) {
+	size := int64(len(data))
+	if size == 0 {
+		return Attachment{}, apperr.New(fiber.StatusBadRequest, "File is empty")
+	}
+	if size > MaxUploadSize {
+		return Attachment{}, apperr.New(fiber.StatusRequestEntityTooLarge, "File exceeds the maximum upload size")
+	}
+	if err := h.quota.Check(ctx, h.db, userID, nil, size); err != nil {
+		return Attachment{}, err
+	}
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if !allowedContentTypes[contentType] {
+		return Attachment{}, apperr.New(fiber.StatusUnsupportedMediaType, "File type not allowed")
+	}
+
+	return h.save(ctx, noteID, userID, filepath.Base(filename), contentType, data)
+}
+
+// save records an attachment, storing its contents unless a blob already
+// holds them. Each attachment counts its full size against quotas even
+// when its blob is shared.
+func (h *Handler) save(ctx context.Context, noteID, userID, filename, contentType string, data []byte) (Attachment, error) {
+	key, err := h.storeBlob(ctx, data, contentType)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	id := uuid.New().String()
+	size := int64(len(data))
+	status := StatusUnscanned
+	if h.scanner != nil {
+		status = StatusPending
+	}
+	// A blob nothing refers to after a failure here is left to CollectBlobs
+	_, err = h.db.ExecContext(ctx,
+		"INSERT INTO attachments (id, note_id, user_id, filename, content_type, size, storage_key, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		id, noteID, userID, filename, contentType, size, key, status,
+	)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("saving attachment metadata: %w", err)
+	}
+	if status == StatusPending {
+		select {
+		case h.scan <- struct{}{}:
+		default:
+		}
+	}
+
+	return Attachment{
+		ID:          id,
+		NoteID:      noteID,
+		UserID:      userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		Status:      status,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// lookup fetches an attachment the user is allowed to see along with its storage key
+func (h *Handler) lookup(ctx context.Context, attachmentID, userID string) (*Attachment, string, error) {
+	var a Attachment
+	var key string
+	err := h.db.QueryRowContext(ctx,
+		"SELECT a.id, a.note_id, a.user_id, a.filename, a.content_type, a.size, a.status, a.created_at, a.storage_key "+
+			"FROM attachments a WHERE a.id = ? AND a.note_id IN (SELECT id FROM notes WHERE "+access.Notes+")",
+		attachmentID, userID, userID,
+	).Scan(&a.ID, &a.NoteID, &a.UserID, &a.Filename, &a.ContentType, &a.Size, &a.Status, &a.CreatedAt, &key)
+	if err != nil {
+		return nil, "", err
+	}
+	return &a, key, nil
+}
+
+// GetAttachment streams an attachment's contents, once it has been found
+// clean when uploads are scanned
+func (h *Handler) GetAttachment(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	a, key, err := h.lookup(c.UserContext(), c.Params("id"), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Attachment not found")
+		}
+		return fmt.Errorf("fetching attachment: %w", err)
+	}
+	switch a.Status {
+	case StatusPending:
+		return apperr.New(fiber.StatusConflict, "Attachment is still being scanned for viruses")
+	case StatusQuarantined:
+		return apperr.New(fiber.StatusForbidden, "Attachment was quarantined because it may contain malware")
+	}
+
+	body, err := h.storage.Get(c.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return apperr.New(fiber.StatusNotFound, "Attachment not found")
+		}
+		return fmt.Errorf("reading attachment: %w", err)
+	}
+
+	c.Set(fiber.HeaderContentType, a.ContentType)
+	c.Set(fiber.HeaderContentDisposition, "attachment; filename="+strconv.Quote(a.Filename))
+	c.Set("X-Content-Type-Options", "nosniff")
+	// SendStream closes the body once it has been written
+	return c.SendStream(body, int(a.Size))
+}
+
+// DeleteAttachment removes an attachment. Only the uploader or the note's
+// owner may delete it, while they can still open the note and have not
+// been given a role that cannot change it.
+func (h *Handler) DeleteAttachment(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	attachmentID := c.Params("id")
+
+	var noteID, key string
+	var shared bool
+	err = h.db.QueryRowContext(c.UserContext(),
+		"SELECT a.note_id, a.storage_key, EXISTS(SELECT 1 FROM attachment_blobs b WHERE b.storage_key = a.storage_key) FROM attachments a JOIN notes n ON n.id = a.note_id "+
+			"WHERE a.id = ? AND (a.user_id = ? OR n.user_id = ?) AND a.note_id IN (SELECT id FROM notes WHERE "+access.Notes+")",
+		attachmentID, userID, userID, userID, userID,
+	).Scan(&noteID, &key, &shared)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Attachment not found or unauthorized")
+		}
+		return fmt.Errorf("fetching attachment: %w", err)
+	}
+	if err := access.RequireEditor(c.UserContext(), h.db, noteID, userID); err != nil {
+		return err
+	}
+
+	if _, err := h.db.ExecContext(c.UserContext(), "DELETE FROM attachments WHERE id = ?", attachmentID); err != nil {
+		return fmt.Errorf("deleting attachment: %w", err)
+	}
+
+	// Blobs other attachments may share are left to CollectBlobs. The
+	// metadata row is the source of truth; a failed blob delete only
+	// leaves an orphan behind.
+	if !shared {
+		if err := h.storage.Delete(c.Context(), key); err != nil {
+			log.Println("Error deleting attachment blob:", err)
+		}
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
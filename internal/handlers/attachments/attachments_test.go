@@ -0,0 +1,368 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/quota"
+	"quanta/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var roleQuery = regexp.QuoteMeta("SELECT role FROM note_collaborators WHERE note_id = ? AND user_id = ?")
+
+var accessQuery = regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)))")
+
+// memoryStorage is an in-memory storage.Storage for tests
+type memoryStorage struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{blobs: make(map[string][]byte)}
+}
+
+func (m *memoryStorage) Put(_ context.Context, key string, r io.Reader, _ int64, _ string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = data
+	return nil
+}
+
+func (m *memoryStorage) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.blobs[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryStorage) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+// testHelper contains common test setup and utilities
+type testHelper struct {
+	t       *testing.T
+	db      *sql.DB
+	mockDB  sqlmock.Sqlmock
+	app     *fiber.App
+	handler *Handler
+	storage *memoryStorage
+}
+
+// newTestHelper creates a new test helper with common setup
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	store := newMemoryStorage()
+	handler := NewHandler(db, store, quota.Limits{}, nil, nil)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+
+	// Mock user ID in context
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+
+	return &testHelper{
+		t:       t,
+		db:      db,
+		mockDB:  mockDB,
+		app:     app,
+		handler: handler,
+		storage: store,
+	}
+}
+
+// multipartBody builds a multipart form with a single "file" field
+func multipartBody(t *testing.T, filename string, content []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("error creating form file: %v", err)
+	}
+	if _, err := part.Write(content); err != nil {
+		t.Fatalf("error writing form file: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("error closing multipart writer: %v", err)
+	}
+	return body, writer.FormDataContentType()
+}
+
+func TestUploadAttachment(t *testing.T) {
+	pngHeader := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	testCases := []struct {
+		name           string
+		allowed        bool
+		role           string
+		content        []byte
+		used           int64
+		quota          int64
+		expectInsert   bool
+		sharedBlob     bool
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Success",
+			allowed:        true,
+			content:        pngHeader,
+			quota:          1 << 20,
+			expectInsert:   true,
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Same Content",
+			allowed:        true,
+			content:        pngHeader,
+			quota:          1 << 20,
+			expectInsert:   true,
+			sharedBlob:     true,
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "No Access",
+			content:        pngHeader,
+			expectedStatus: fiber.StatusNotFound,
+			expectedError:  "Note not found or unauthorized",
+		},
+		{
+			name:           "Viewer",
+			allowed:        true,
+			role:           "viewer",
+			content:        pngHeader,
+			expectedStatus: fiber.StatusForbidden,
+			expectedError:  "You have read-only access to this note",
+		},
+		{
+			name:           "Disallowed Type",
+			allowed:        true,
+			content:        []byte("<html><script>alert(1)</script></html>"),
+			quota:          1 << 20,
+			expectedStatus: fiber.StatusUnsupportedMediaType,
+			expectedError:  "File type not allowed",
+		},
+		{
+			name:           "Quota Exceeded",
+			allowed:        true,
+			content:        pngHeader,
+			used:           95,
+			quota:          100,
+			expectedStatus: fiber.StatusPaymentRequired,
+			expectedError:  "Storage quota exceeded",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.handler.quota = quota.Limits{UserBytes: tc.quota}
+			helper.app.Post("/notes/:id/attachments", helper.handler.UploadAttachment)
+
+			helper.mockDB.ExpectQuery(accessQuery).WithArgs("note1", "user123", "user123").
+				WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(tc.allowed))
+			if tc.allowed {
+				roles := sqlmock.NewRows([]string{"role"})
+				if tc.role != "" {
+					roles.AddRow(tc.role)
+				}
+				helper.mockDB.ExpectQuery(roleQuery).WithArgs("note1", "user123").WillReturnRows(roles)
+			}
+			if tc.allowed && tc.role != "viewer" {
+				helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT user_id, workspace_id FROM notes WHERE id = ?")).
+					WithArgs("note1").
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "workspace_id"}).AddRow("user123", nil))
+				helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT (SELECT COALESCE(SUM(size), 0) FROM notes WHERE user_id = ? AND workspace_id IS NULL)")).
+					WithArgs("user123", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"notes", "attachments"}).AddRow(0, tc.used))
+			}
+			var key driver.Value = sqlmock.AnyArg()
+			if tc.expectInsert {
+				hash := fmt.Sprintf("%x", sha256.Sum256(tc.content))
+				reuse := helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE attachment_blobs SET used_at = CURRENT_TIMESTAMP WHERE hash = ?")).WithArgs(hash)
+				if tc.sharedBlob {
+					helper.storage.blobs["blobs/b1"] = tc.content
+					reuse.WillReturnResult(sqlmock.NewResult(0, 1))
+					helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT storage_key FROM attachment_blobs WHERE hash = ?")).WithArgs(hash).
+						WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("blobs/b1"))
+					key = "blobs/b1"
+				} else {
+					reuse.WillReturnResult(sqlmock.NewResult(0, 0))
+					helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO attachment_blobs (hash, storage_key, size) VALUES (?, ?, ?)")).
+						WithArgs(hash, sqlmock.AnyArg(), len(tc.content)).
+						WillReturnResult(sqlmock.NewResult(1, 1))
+				}
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO attachments (id, note_id, user_id, filename, content_type, size, storage_key, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")).
+					WithArgs(sqlmock.AnyArg(), "note1", "user123", "image.png", "image/png", int64(len(tc.content)), key, StatusUnscanned).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			body, contentType := multipartBody(t, "image.png", tc.content)
+			req := httptest.NewRequest("POST", "/notes/note1/attachments", body)
+			req.Header.Set("Content-Type", contentType)
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+				assert.Empty(t, helper.storage.blobs)
+			} else {
+				var response Attachment
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, "image/png", response.ContentType)
+				// The content is stored once whether or not it was new
+				assert.Len(t, helper.storage.blobs, 1)
+				for _, data := range helper.storage.blobs {
+					assert.Equal(t, tc.content, data)
+				}
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetAttachment(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.app.Get("/attachments/:id", helper.handler.GetAttachment)
+	helper.storage.blobs["attachments/note1/att1"] = []byte("hello")
+
+	query := regexp.QuoteMeta("SELECT a.id, a.note_id, a.user_id, a.filename, a.content_type, a.size, a.status, a.created_at, a.storage_key")
+	columns := []string{"id", "note_id", "user_id", "filename", "content_type", "size", "status", "created_at", "storage_key"}
+
+	helper.mockDB.ExpectQuery(query).WithArgs("att1", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("att1", "note1", "user123", "hello.txt", "text/plain", 5, StatusClean, time.Now(), "attachments/note1/att1"))
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/attachments/att1", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	content, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "hello", string(content))
+
+	// Files waiting to be scanned or found infected aren't served
+	for status, code := range map[string]int{StatusPending: fiber.StatusConflict, StatusQuarantined: fiber.StatusForbidden} {
+		helper.mockDB.ExpectQuery(query).WithArgs("att1", "user123", "user123").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("att1", "note1", "user123", "hello.txt", "text/plain", 5, status, time.Now(), "attachments/note1/att1"))
+		resp, err = helper.app.Test(httptest.NewRequest("GET", "/attachments/att1", nil))
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		assert.Equal(t, code, resp.StatusCode, status)
+	}
+
+	helper.mockDB.ExpectQuery(query).WithArgs("missing", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows(columns))
+	resp, err = helper.app.Test(httptest.NewRequest("GET", "/attachments/missing", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeleteAttachment(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.app.Delete("/attachments/:id", helper.handler.DeleteAttachment)
+	helper.storage.blobs["attachments/note1/att1"] = []byte("hello")
+	helper.storage.blobs["blobs/b1"] = []byte("shared")
+
+	query := regexp.QuoteMeta("SELECT a.note_id, a.storage_key, EXISTS(SELECT 1 FROM attachment_blobs b WHERE b.storage_key = a.storage_key) FROM attachments a JOIN notes n ON n.id = a.note_id")
+	columns := []string{"note_id", "storage_key", "shared"}
+
+	helper.mockDB.ExpectQuery(query).WithArgs("att1", "user123", "user123", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("note1", "attachments/note1/att1", false))
+	helper.mockDB.ExpectQuery(roleQuery).WithArgs("note1", "user123").WillReturnRows(sqlmock.NewRows([]string{"role"}))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM attachments WHERE id = ?")).WithArgs("att1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err := helper.app.Test(httptest.NewRequest("DELETE", "/attachments/att1", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.NotContains(t, helper.storage.blobs, "attachments/note1/att1")
+
+	// A shared blob is left for the collector
+	helper.mockDB.ExpectQuery(query).WithArgs("att3", "user123", "user123", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("note1", "blobs/b1", true))
+	helper.mockDB.ExpectQuery(roleQuery).WithArgs("note1", "user123").WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("editor"))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM attachments WHERE id = ?")).WithArgs("att3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err = helper.app.Test(httptest.NewRequest("DELETE", "/attachments/att3", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	assert.Contains(t, helper.storage.blobs, "blobs/b1")
+
+	helper.mockDB.ExpectQuery(query).WithArgs("att2", "user123", "user123", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows(columns))
+	resp, err = helper.app.Test(httptest.NewRequest("DELETE", "/attachments/att2", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	// Uploaders demoted to viewer can no longer remove their files
+	helper.mockDB.ExpectQuery(query).WithArgs("att4", "user123", "user123", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("note1", "attachments/note1/att4", false))
+	helper.mockDB.ExpectQuery(roleQuery).WithArgs("note1", "user123").WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("viewer"))
+	resp, err = helper.app.Test(httptest.NewRequest("DELETE", "/attachments/att4", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/db"
+	"quanta/internal/storage"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultCollectInterval is how often unused blobs are collected
+	DefaultCollectInterval = time.Hour
+	// BlobGrace is how long a blob is kept after an upload last took it,
+	// so collection can't delete it before that upload is recorded
+	BlobGrace = time.Hour
+)
+
+// storeBlob returns the storage key of the blob holding data, writing a
+// new one when no attachment has had the same content. Blobs are keyed by
+// a fresh ID rather than their hash, so a blob being collected is never
+// confused with one stored again for the same content.
+func (h *Handler) storeBlob(ctx context.Context, data []byte, contentType string) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	key, err := h.reuseBlob(ctx, hash)
+	if err != nil || key != "" {
+		return key, err
+	}
+
+	key = "blobs/" + uuid.New().String()
+	if err := h.storage.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType); err != nil {
+		return "", fmt.Errorf("storing attachment: %w", err)
+	}
+	_, err = h.db.ExecContext(ctx, "INSERT INTO attachment_blobs (hash, storage_key, size) VALUES (?, ?, ?)", hash, key, len(data))
+	if err == nil {
+		return key, nil
+	}
+
+	// Nothing refers to this blob yet, so it can go whatever happened
+	if err := h.storage.Delete(ctx, key); err != nil {
+		log.Println("Error cleaning up orphaned attachment:", err)
+	}
+	if !db.IsDuplicate(err) {
+		return "", fmt.Errorf("saving attachment blob: %w", err)
+	}
+	// Another upload of the same content stored it first
+	key, err = h.reuseBlob(ctx, hash)
+	if err != nil {
+		return "", err
+	}
+	if key == "" {
+		return "", errors.New("saving attachment blob: stored blob was collected")
+	}
+	return key, nil
+}
+
+// reuseBlob returns the storage key of the blob with hash, or "" when
+// there is none, and marks it used so collection leaves it alone
+func (h *Handler) reuseBlob(ctx context.Context, hash string) (string, error) {
+	result, err := h.db.ExecContext(ctx, "UPDATE attachment_blobs SET used_at = CURRENT_TIMESTAMP WHERE hash = ?", hash)
+	if err != nil {
+		return "", fmt.Errorf("fetching attachment blob: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return "", nil
+	}
+
+	var key string
+	if err := h.db.QueryRowContext(ctx, "SELECT storage_key FROM attachment_blobs WHERE hash = ?", hash).Scan(&key); err != nil {
+		return "", fmt.Errorf("fetching attachment blob: %w", err)
+	}
+	return key, nil
+}
+
+// unreferenced matches the blobs no attachment refers to that no upload
+// has taken since the cutoff
+const unreferenced = "used_at < ? AND NOT EXISTS (SELECT 1 FROM attachments a WHERE a.storage_key = attachment_blobs.storage_key)"
+
+// CollectBlobs deletes the blobs no attachment refers to any more, after
+// their attachments were deleted or went with their note, user or
+// workspace, and returns how many it deleted
+func (h *Handler) CollectBlobs(ctx context.Context, now time.Time) (int, error) {
+	cutoff := now.Add(-BlobGrace).UTC()
+	rows, err := h.db.QueryContext(ctx, "SELECT hash, storage_key FROM attachment_blobs WHERE "+unreferenced, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("finding unused attachment blobs: %w", err)
+	}
+	type blob struct {
+		hash string
+		key  string
+	}
+	var blobs []blob
+	for rows.Next() {
+		var b blob
+		if err := rows.Scan(&b.hash, &b.key); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("scanning unused attachment blobs: %w", err)
+		}
+		blobs = append(blobs, b)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("finding unused attachment blobs: %w", err)
+	}
+
+	collected := 0
+	for _, b := range blobs {
+		// The row goes first and only while still unused, so an upload
+		// that took the blob meanwhile keeps it
+		result, err := h.db.ExecContext(ctx, "DELETE FROM attachment_blobs WHERE hash = ? AND "+unreferenced, b.hash, cutoff)
+		if err != nil {
+			return collected, fmt.Errorf("deleting attachment blob: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		if err := h.storage.Delete(ctx, b.key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error deleting attachment blob %s: %v", b.key, err)
+		}
+		collected++
+	}
+	return collected, nil
+}
+
+// StartCollector collects unused blobs on every interval until stop is
+// closed Nothing is collected while paused reports true.
+func (h *Handler) StartCollector(interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			n, err := h.CollectBlobs(ctx, now)
+			cancel()
+			if err != nil {
+				log.Println("Error collecting attachment blobs:", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Deleted %d unused attachment blobs", n)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
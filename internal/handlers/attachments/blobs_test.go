@@ -0,0 +1,36 @@
+package attachments
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectBlobs(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.storage.blobs["blobs/b1"] = []byte("unused")
+	helper.storage.blobs["blobs/b2"] = []byte("taken again")
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	cutoff := now.Add(-BlobGrace)
+	unused := "used_at < ? AND NOT EXISTS (SELECT 1 FROM attachments a WHERE a.storage_key = attachment_blobs.storage_key)"
+
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT hash, storage_key FROM attachment_blobs WHERE " + unused)).WithArgs(cutoff).
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "storage_key"}).AddRow("h1", "blobs/b1").AddRow("h2", "blobs/b2"))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM attachment_blobs WHERE hash = ? AND "+unused)).WithArgs("h1", cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// An upload took the second blob after it was listed
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM attachment_blobs WHERE hash = ? AND "+unused)).WithArgs("h2", cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	n, err := helper.handler.CollectBlobs(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NotContains(t, helper.storage.blobs, "blobs/b1")
+	assert.Contains(t, helper.storage.blobs, "blobs/b2")
+	assert.NoError(t, helper.mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,184 @@
+package attachments
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"quanta/internal/notifications"
+	"quanta/internal/scanner"
+)
+
+// Statuses of an attachment's virus scan
+const (
+	// StatusUnscanned attachments were stored while no scanner was set up
+	StatusUnscanned = "unscanned"
+	// StatusPending attachments are waiting to be scanned and can't be
+	// downloaded yet
+	StatusPending = "pending"
+	// StatusClean attachments were scanned and nothing was found
+	StatusClean = "clean"
+	// StatusQuarantined attachments were found to contain malware and
+	// can't be downloaded
+	StatusQuarantined = "quarantined"
+)
+
+const (
+	// DefaultScanInterval is how often pending uploads are looked for,
+	// besides when one is made
+	DefaultScanInterval = time.Minute
+	// MaxScanBatch is the most files scanned in one run. The rest wait for
+	// the next run.
+	MaxScanBatch = 100
+)
+
+// Scanner checks a file for malware. It is implemented by *scanner.ClamAV.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (scanner.Verdict, error)
+}
+
+// Notifier delivers in-app notifications. It is implemented by
+// *notifications.Handler.
+type Notifier interface {
+	Notify(ctx context.Context, userID string, kind notifications.Kind, payload map[string]string) error
+}
+
+// ScanResult counts what a scan run did
+type ScanResult struct {
+	// Scanned is how many files were scanned and Quarantined how many
+	// attachments were quarantined because of them
+	Scanned     int
+	Quarantined int
+}
+
+// ScanPending scans the files of pending attachments. Attachments sharing
+// a blob are settled by one scan. A file that can't be read is logged and
+// left pending; when the scanner fails the run stops, so nothing is passed
+// unscanned.
+func (h *Handler) ScanPending(ctx context.Context) (ScanResult, error) {
+	var res ScanResult
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT storage_key FROM attachments WHERE status = ? GROUP BY storage_key ORDER BY MIN(created_at) LIMIT ?",
+		StatusPending, MaxScanBatch,
+	)
+	if err != nil {
+		return res, fmt.Errorf("finding attachments to scan: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			_ = rows.Close()
+			return res, fmt.Errorf("scanning attachments to scan: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return res, fmt.Errorf("finding attachments to scan: %w", err)
+	}
+
+	for _, key := range keys {
+		body, err := h.storage.Get(ctx, key)
+		if err != nil {
+			log.Printf("Error reading attachment %s to scan: %v", key, err)
+			continue
+		}
+		verdict, err := h.scanner.Scan(ctx, body)
+		_ = body.Close()
+		if err != nil {
+			return res, fmt.Errorf("scanning attachment %s: %w", key, err)
+		}
+		res.Scanned++
+
+		if !verdict.Infected {
+			if _, err := h.db.ExecContext(ctx, "UPDATE attachments SET status = ? WHERE storage_key = ? AND status = ?", StatusClean, key, StatusPending); err != nil {
+				return res, fmt.Errorf("marking attachment clean: %w", err)
+			}
+			continue
+		}
+		n, err := h.quarantine(ctx, key, verdict.Signature)
+		res.Quarantined += n
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// quarantine marks the pending attachments stored at key quarantined and
+// tells their uploaders. Those uploaded while it runs stay pending and are
+// quarantined by the next run.
+func (h *Handler) quarantine(ctx context.Context, key, signature string) (int, error) {
+	rows, err := h.db.QueryContext(ctx, "SELECT id, note_id, user_id, filename FROM attachments WHERE storage_key = ? AND status = ?", key, StatusPending)
+	if err != nil {
+		return 0, fmt.Errorf("finding infected attachments: %w", err)
+	}
+	var infected []Attachment
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.ID, &a.NoteID, &a.UserID, &a.Filename); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("scanning infected attachments: %w", err)
+		}
+		infected = append(infected, a)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("finding infected attachments: %w", err)
+	}
+
+	quarantined := 0
+	for _, a := range infected {
+		if _, err := h.db.ExecContext(ctx, "UPDATE attachments SET status = ? WHERE id = ?", StatusQuarantined, a.ID); err != nil {
+			return quarantined, fmt.Errorf("quarantining attachment: %w", err)
+		}
+		quarantined++
+		log.Printf("Quarantined attachment %s of note %s: %s", a.ID, a.NoteID, signature)
+
+		err := h.notifier.Notify(ctx, a.UserID, notifications.KindAttachmentQuarantined, map[string]string{
+			"attachment_id": a.ID,
+			"note_id":       a.NoteID,
+			"filename":      a.Filename,
+			"signature":     signature,
+		})
+		if err != nil {
+			log.Printf("Error notifying %s of quarantined attachment %s: %v", a.UserID, a.ID, err)
+		}
+	}
+	return quarantined, nil
+}
+
+// StartScanner scans pending uploads as they are made and on every
+// interval until stop is closed. It does nothing without a scanner.
+// Uploads wait unscanned while paused reports true.
+func (h *Handler) StartScanner(interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	if h.scanner == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.scan:
+		case <-stop:
+			return
+		}
+		if paused() {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		res, err := h.ScanPending(ctx)
+		cancel()
+		if err != nil {
+			log.Println("Error scanning attachments:", err)
+		}
+		if res.Quarantined > 0 {
+			log.Printf("Scanned %d files and quarantined %d attachments", res.Scanned, res.Quarantined)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package attachments
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"quanta/internal/notifications"
+	"quanta/internal/scanner"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeScanner finds files containing EICAR infected, or fails with err
+type fakeScanner struct {
+	err error
+}
+
+func (s fakeScanner) Scan(_ context.Context, r io.Reader) (scanner.Verdict, error) {
+	if s.err != nil {
+		return scanner.Verdict{}, s.err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return scanner.Verdict{}, err
+	}
+	if strings.Contains(string(data), "EICAR") {
+		return scanner.Verdict{Infected: true, Signature: "Eicar-Signature"}, nil
+	}
+	return scanner.Verdict{}, nil
+}
+
+// recordingNotifier keeps the notifications sent through it
+type recordingNotifier struct {
+	sent []map[string]string
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, userID string, kind notifications.Kind, payload map[string]string) error {
+	n.sent = append(n.sent, map[string]string{"user": userID, "kind": string(kind), "attachment": payload["attachment_id"], "signature": payload["signature"]})
+	return nil
+}
+
+func TestScanPending(t *testing.T) {
+	pendingQuery := regexp.QuoteMeta("SELECT storage_key FROM attachments WHERE status = ? GROUP BY storage_key ORDER BY MIN(created_at) LIMIT ?")
+
+	t.Run("Clean And Infected", func(t *testing.T) {
+		helper := newTestHelper(t)
+		notifier := &recordingNotifier{}
+		helper.handler.scanner = fakeScanner{}
+		helper.handler.notifier = notifier
+		helper.storage.blobs["blobs/clean"] = []byte("hello")
+		helper.storage.blobs["blobs/bad"] = []byte("X5O!P%@AP EICAR")
+		mock := helper.mockDB
+
+		mock.ExpectQuery(pendingQuery).WithArgs(StatusPending, MaxScanBatch).
+			WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("blobs/clean").AddRow("blobs/bad"))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE attachments SET status = ? WHERE storage_key = ? AND status = ?")).
+			WithArgs(StatusClean, "blobs/clean", StatusPending).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, note_id, user_id, filename FROM attachments WHERE storage_key = ? AND status = ?")).
+			WithArgs("blobs/bad", StatusPending).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "note_id", "user_id", "filename"}).
+				AddRow("att1", "note1", "user1", "invoice.pdf").
+				AddRow("att2", "note2", "user2", "invoice.pdf"))
+		for _, id := range []string{"att1", "att2"} {
+			mock.ExpectExec(regexp.QuoteMeta("UPDATE attachments SET status = ? WHERE id = ?")).
+				WithArgs(StatusQuarantined, id).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+
+		res, err := helper.handler.ScanPending(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, ScanResult{Scanned: 2, Quarantined: 2}, res)
+		assert.Equal(t, []map[string]string{
+			{"user": "user1", "kind": "attachment_quarantined", "attachment": "att1", "signature": "Eicar-Signature"},
+			{"user": "user2", "kind": "attachment_quarantined", "attachment": "att2", "signature": "Eicar-Signature"},
+		}, notifier.sent)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Scanner Down", func(t *testing.T) {
+		helper := newTestHelper(t)
+		helper.handler.scanner = fakeScanner{err: errors.New("connection refused")}
+		helper.storage.blobs["blobs/a"] = []byte("hello")
+
+		// Nothing is marked, so the files stay pending for the next run
+		helper.mockDB.ExpectQuery(pendingQuery).WithArgs(StatusPending, MaxScanBatch).
+			WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("blobs/a"))
+
+		_, err := helper.handler.ScanPending(context.Background())
+		assert.ErrorContains(t, err, "connection refused")
+		assert.NoError(t, helper.mockDB.ExpectationsWereMet())
+	})
+}
@@ -1,16 +1,24 @@
 // Package auth contains the handlers for the authentication endpoints
-// TODO: Implement idempotent signup by verifying existing password and returning token if valid
 package auth
 
 import (
+	"cmp"
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
-	"net/mail"
-	"os"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/handlers/workspaces"
+	"quanta/internal/validate"
 	"quanta/pkg"
 
 	"github.com/gofiber/fiber/v2"
@@ -20,20 +28,81 @@ import (
 
 // DBInterface defines the methods for database operations
 type DBInterface interface {
-	Exec(query string, args ...any) (sql.Result, error)
-	QueryRow(query string, args ...any) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Mailer sends plain-text emails
+type Mailer interface {
+	Send(to, subject, body string) error
 }
 
 // Handler is a struct that contains the database and JWT interfaces
 type Handler struct {
-	db  DBInterface
-	jwt JWTInterface
+	db     DBInterface
+	jwt    JWTInterface
+	keys   *pkg.JWTKeys
+	audit  AuditLogger
+	mailer Mailer
+	emails EmailConfig
+	sso    SSOConfig
 }
 
 // JWTInterface defines the methods for JWT operations
 type JWTInterface interface {
 	NewWithClaims(method jwt.SigningMethod, claims jwt.Claims) *jwt.Token
-	SignedString(token *jwt.Token, key []byte) (string, error)
+	SignedString(token *jwt.Token, key any) (string, error)
+}
+
+// Credentials is the request body for Login. Device optionally names the
+// client in the session list.
+type Credentials struct {
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
+	Device   string `json:"device,omitempty" validate:"max=100"`
+}
+
+// Registration is the request body for SignUp. InviteToken, from a
+// workspace invitation link, joins the new account to that workspace.
+type Registration struct {
+	Email       string `json:"email" validate:"required,email,max=255"`
+	Password    string `json:"password" validate:"required,min=8"`
+	InviteToken string `json:"invite_token,omitempty"`
+	Device      string `json:"device,omitempty" validate:"max=100"`
+}
+
+// PasswordChange is the request body for ChangePassword
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8"`
+}
+
+// Session is the response to a successful SignUp or Login
+type Session struct {
+	Token string `json:"token"`
+	// WorkspaceID is the workspace a signup with an invite_token joined
+	WorkspaceID string `json:"workspace_id,omitempty"`
+}
+
+// errEmailInUse answers a signup for an existing account that isn't a
+// retry by its owner
+var errEmailInUse = apperr.New(fiber.StatusConflict, "Email already in use")
+
+// LockedError is returned by Authenticate, and by Register for an existing
+// account, while the account is locked after too many failed logins
+type LockedError struct {
+	Until time.Time
+}
+
+func (e *LockedError) Error() string {
+	return "Account is temporarily locked after too many failed logins"
 }
 
 // JWTService is a struct that contains the JWT interface
@@ -45,132 +114,402 @@ func (j *JWTService) NewWithClaims(method jwt.SigningMethod, claims jwt.Claims)
 }
 
 // SignedString signs a JWT token with a given key
-func (j *JWTService) SignedString(token *jwt.Token, key []byte) (string, error) {
+func (j *JWTService) SignedString(token *jwt.Token, key any) (string, error) {
 	return token.SignedString(key)
 }
 
-// NewHandler creates a new Handler
-func NewHandler(db DBInterface, jwt JWTInterface) *Handler {
+// NewHandler creates a new Handler that signs tokens with the current key
+// of keys, records logins and credential changes with auditLog, mails
+// email change links with mailer and signs users in through sso's provider
+func NewHandler(db DBInterface, jwt JWTInterface, keys *pkg.JWTKeys, auditLog AuditLogger, mailer Mailer, emails EmailConfig, sso SSOConfig) *Handler {
+	if emails.TTL <= 0 {
+		emails.TTL = DefaultEmailChangeTTL
+	}
+	emails.BaseURL = strings.TrimSuffix(emails.BaseURL, "/")
+	sso.BaseURL = strings.TrimSuffix(sso.BaseURL, "/")
+	sso.EmailClaim = cmp.Or(sso.EmailClaim, "email")
+	sso.NameClaim = cmp.Or(sso.NameClaim, "name")
+	sso.GroupsClaim = cmp.Or(sso.GroupsClaim, "groups")
 	return &Handler{
-		db:  db,
-		jwt: jwt,
+		db:     db,
+		jwt:    jwt,
+		keys:   keys,
+		audit:  auditLog,
+		mailer: mailer,
+		emails: emails,
+		sso:    sso,
+	}
+}
+
+// logEvent records a security event the user caused from client. userID
+// is empty when the event concerns no known account.
+func (h *Handler) logEvent(ctx context.Context, event audit.Event, userID string, client Client, details map[string]string) {
+	h.audit.Log(ctx, audit.Entry{
+		UserID:    userID,
+		ActorID:   userID,
+		Event:     event,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Details:   details,
+	})
+}
+
+// issueToken signs a JWT for the user's session with the current key,
+// named in the kid header. The token version must match the user's current
+// token_version, and the session must still exist, for the Protected
+// middleware to accept it.
+func (h *Handler) issueToken(userID string, tokenVersion int, sessionID string) (string, error) {
+	claims := jwt.MapClaims{
+		"user-id":       userID,
+		"token-version": tokenVersion,
+		"jti":           sessionID,
+		"exp":           time.Now().Add(TokenTTL).Unix(),
 	}
+	key := h.keys.Current()
+	token := h.jwt.NewWithClaims(key.Method(), claims)
+	token.Header["kid"] = key.ID
+	return h.jwt.SignedString(token, key.SigningKey())
+}
+
+// JWKS serves the public keys tokens may be signed with as a JSON Web Key
+// Set, so other services can verify tokens without the HMAC secret. Only
+// RSA and Ed25519 keys are listed.
+func (h *Handler) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(h.keys.JWKS())
 }
 
 // SignUp handles user registration by creating a new user account
-// and returning a JWT token for authenticated access.
+// and returning a JWT token for authenticated access. Signup is idempotent:
+// repeating it with the password of an existing account returns a token for
+// that account instead of a conflict. Signing up with an invite_token also
+// joins the invited workspace, whose ID is returned alongside the token.
 func (h *Handler) SignUp(c *fiber.Ctx) error {
-	var payload struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var payload Registration
 	if err := c.BodyParser(&payload); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid Input"})
+		return apperr.New(fiber.StatusBadRequest, "Invalid input")
 	}
 
-	payload.Email = strings.TrimSpace(payload.Email)
-	payload.Password = strings.TrimSpace(payload.Password)
-
-	// Validate email format
-	_, err := mail.ParseAddress(payload.Email)
+	session, err := h.Register(c.UserContext(), payload, clientOf(c))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid email format"})
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			return accountLocked(c, locked.Until)
+		}
+		return err
 	}
+	return c.JSON(session)
+}
 
-	if len(payload.Password) < 8 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Password must be at least 8 characters long"})
+// Register validates payload and creates the account, or logs into an
+// existing one as SignUp describes, starting a session for client. It is
+// refused when single sign-on is enforced. The email is lowercased, as
+// Login and email changes do, so addresses differing only in case are the
+// same account.
+func (h *Handler) Register(ctx context.Context, payload Registration, client Client) (Session, error) {
+	if h.sso.Enforced {
+		return Session{}, errPasswordLoginDisabled
+	}
+	payload.Email = strings.ToLower(strings.TrimSpace(payload.Email))
+	payload.Password = strings.TrimSpace(payload.Password)
+	payload.InviteToken = strings.TrimSpace(payload.InviteToken)
+	if errs := validate.Struct(&payload); errs != nil {
+		return Session{}, apperr.Invalid(errs)
 	}
 
-	// Check for duplicate email
-	var existingUserID string
-	err = h.db.QueryRow("SELECT id FROM users WHERE email = ?", payload.Email).Scan(&existingUserID)
-	if err == nil {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email already in use"})
-	} else if !errors.Is(err, sql.ErrNoRows) {
-		// Some other DB error
-		log.Println("Error checking for duplicate email:", err)
-		return c.SendStatus(fiber.StatusInternalServerError)
+	session, found, err := h.resumeSignup(ctx, payload, client)
+	if found || err != nil {
+		return session, err
 	}
 
 	hashedPw, err := pkg.HashPassword(payload.Password)
 	if err != nil {
-		log.Println("Error hashing password", err)
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return Session{}, fmt.Errorf("hashing password: %w", err)
 	}
 
 	userID := uuid.New().String()
-	_, err = h.db.Exec(
-		"INSERT INTO users (id, email, password) VALUES (?, ?, ?)",
-		userID, payload.Email, hashedPw,
-	)
+	workspaceID, err := h.createUser(ctx, userID, payload.Email, hashedPw, payload.InviteToken)
+	if db.IsDuplicate(err) {
+		// A concurrent signup for the same email got there first, so this
+		// one is treated as a retry of it
+		session, found, err := h.resumeSignup(ctx, payload, client)
+		if !found && err == nil {
+			err = errEmailInUse
+		}
+		return session, err
+	}
 	if err != nil {
-		log.Println("Error inserting user:", err)
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return Session{}, fmt.Errorf("inserting user: %w", err)
 	}
 
-	secret := os.Getenv("JWT_SECRET")
-	claims := jwt.MapClaims{
-		"user-id": userID,
-		"exp":     time.Now().Add(time.Hour * 72).Unix(),
+	h.logEvent(ctx, audit.EventSignup, userID, client, nil)
+
+	signedToken, err := h.startSession(ctx, userID, 0, payload.Device, client)
+	if err != nil {
+		return Session{}, err
 	}
-	token := h.jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := h.jwt.SignedString(token, []byte(secret))
+
+	return Session{Token: signedToken, WorkspaceID: workspaceID}, nil
+}
+
+// resumeSignup handles a signup for an email that already has an account.
+// found is false when there is no such account and the signup can go
+// ahead; otherwise a retried signup with the right password logs the user
+// in and any other is a conflict. Wrong passwords count toward the same
+// lockout as Login, and a locked account is refused before its password
+// is checked.
+func (h *Handler) resumeSignup(ctx context.Context, payload Registration, client Client) (session Session, found bool, err error) {
+	var existingUserID, existingHash string
+	var tokenVersion, failedLogins int
+	var lockedUntil sql.NullTime
+	var deleted bool
+	err = h.db.QueryRowContext(ctx,
+		"SELECT id, password, token_version, failed_logins, locked_until, deleted_at IS NOT NULL FROM users WHERE email = ?",
+		payload.Email,
+	).Scan(&existingUserID, &existingHash, &tokenVersion, &failedLogins, &lockedUntil, &deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, false, nil
+	} else if err != nil {
+		return Session{}, false, fmt.Errorf("checking for duplicate email: %w", err)
+	}
+
+	if deleted {
+		return Session{}, true, errEmailInUse
+	}
+	if lockedUntil.Valid && time.Now().Before(lockedUntil.Time) {
+		h.logEvent(ctx, audit.EventLoginFailed, existingUserID, client, map[string]string{"reason": "locked"})
+		return Session{}, true, &LockedError{Until: lockedUntil.Time}
+	}
+	if pkg.CheckPasswordHash(payload.Password, existingHash) != nil {
+		h.logEvent(ctx, audit.EventLoginFailed, existingUserID, client, map[string]string{"reason": "wrong_password"})
+		until, err := h.recordFailedLogin(ctx, existingUserID, failedLogins+1, client)
+		if err != nil {
+			return Session{}, true, fmt.Errorf("recording failed login: %w", err)
+		}
+		if !until.IsZero() {
+			return Session{}, true, &LockedError{Until: until}
+		}
+		return Session{}, true, errEmailInUse
+	}
+	if failedLogins > 0 || lockedUntil.Valid {
+		if err := resetFailedLogins(ctx, h.db, existingUserID); err != nil {
+			return Session{}, true, fmt.Errorf("resetting failed logins: %w", err)
+		}
+	}
+
+	signedToken, err := h.startSession(ctx, existingUserID, tokenVersion, payload.Device, client)
 	if err != nil {
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return Session{}, true, err
 	}
+	h.logEvent(ctx, audit.EventLogin, existingUserID, client, nil)
+	return Session{Token: signedToken}, true, nil
+}
 
-	return c.JSON(fiber.Map{
-		"token": signedToken,
+// createUser inserts the account and, given an invite token, accepts the
+// invitation in the same transaction, so a bad or expired invitation leaves
+// no account behind. The unique index on email rejects a concurrent signup
+// for the same address, which db.IsDuplicate recognizes.
+func (h *Handler) createUser(ctx context.Context, userID, email, hashedPw, inviteToken string) (workspaceID string, err error) {
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO users (id, email, password, password_version) VALUES (?, ?, ?, ?)",
+			userID, email, hashedPw, pkg.PasswordVersion(),
+		); err != nil {
+			return err
+		}
+		if inviteToken == "" {
+			return nil
+		}
+
+		workspaceID, err = workspaces.Redeem(ctx, tx, inviteToken, userID, email)
+		return err
 	})
+	return workspaceID, err
 }
 
 // Login handles user authentication and returns a JWT token upon successful login.
+// Repeated wrong passwords lock the account for a growing window, during which
+// Login answers 423 Locked with a Retry-After hint.
 func (h *Handler) Login(c *fiber.Ctx) error {
-	var payload struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var payload Credentials
 	if err := c.BodyParser(&payload); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid input"})
+		return apperr.New(fiber.StatusBadRequest, "Invalid input")
 	}
 
-	payload.Email = strings.TrimSpace(payload.Email)
-	payload.Password = strings.TrimSpace(payload.Password)
-	payload.Email = strings.ToLower(payload.Email)
+	session, err := h.Authenticate(c.UserContext(), payload, clientOf(c))
+	if err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			return accountLocked(c, locked.Until)
+		}
+		return err
+	}
+	return c.JSON(session)
+}
 
-	if payload.Email == "" || payload.Password == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Email and password cannot be empty"})
+// Authenticate checks payload against the stored password and starts a
+// session for client, counting failures toward a lockout as Login
+// describes. It is refused when single sign-on is enforced.
+func (h *Handler) Authenticate(ctx context.Context, payload Credentials, client Client) (Session, error) {
+	if h.sso.Enforced {
+		return Session{}, errPasswordLoginDisabled
+	}
+	payload.Email = strings.ToLower(strings.TrimSpace(payload.Email))
+	payload.Password = strings.TrimSpace(payload.Password)
+	if errs := validate.Struct(&payload); errs != nil {
+		return Session{}, apperr.Invalid(errs)
 	}
 
 	var userID string
 	var hashedPw string
+	var passwordVersion, tokenVersion, failedLogins int
+	var lockedUntil sql.NullTime
 
-	err := h.db.QueryRow(
-		"SELECT id, password FROM users WHERE email = ?",
+	err := h.db.QueryRowContext(ctx,
+		"SELECT id, password, password_version, token_version, failed_logins, locked_until FROM users WHERE email = ? AND deleted_at IS NULL",
 		payload.Email,
-	).Scan(&userID, &hashedPw)
+	).Scan(&userID, &hashedPw, &passwordVersion, &tokenVersion, &failedLogins, &lockedUntil)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+			h.logEvent(ctx, audit.EventLoginFailed, "", client, map[string]string{"email": payload.Email, "reason": "unknown_email"})
+			return Session{}, apperr.New(fiber.StatusUnauthorized, "Invalid credentials")
 		}
-		log.Println("DB error during login:", err)
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return Session{}, fmt.Errorf("looking up user: %w", err)
+	}
+
+	if lockedUntil.Valid && time.Now().Before(lockedUntil.Time) {
+		h.logEvent(ctx, audit.EventLoginFailed, userID, client, map[string]string{"reason": "locked"})
+		return Session{}, &LockedError{Until: lockedUntil.Time}
 	}
 
 	if err := pkg.CheckPasswordHash(payload.Password, hashedPw); err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+		h.logEvent(ctx, audit.EventLoginFailed, userID, client, map[string]string{"reason": "wrong_password"})
+		until, err := h.recordFailedLogin(ctx, userID, failedLogins+1, client)
+		if err != nil {
+			return Session{}, fmt.Errorf("recording failed login: %w", err)
+		}
+		if !until.IsZero() {
+			return Session{}, &LockedError{Until: until}
+		}
+		return Session{}, apperr.New(fiber.StatusUnauthorized, "Invalid credentials")
 	}
 
-	secret := os.Getenv("JWT_SECRET")
-	claims := jwt.MapClaims{
-		"user-id": userID,
-		"exp":     time.Now().Add(time.Hour * 72).Unix(),
+	if failedLogins > 0 || lockedUntil.Valid {
+		if err := resetFailedLogins(ctx, h.db, userID); err != nil {
+			return Session{}, fmt.Errorf("resetting failed logins: %w", err)
+		}
+	}
+
+	if pkg.NeedsRehash(hashedPw, passwordVersion) {
+		h.rehashPassword(ctx, userID, hashedPw, payload.Password)
+	}
+
+	signedToken, err := h.startSession(ctx, userID, tokenVersion, payload.Device, client)
+	if err != nil {
+		return Session{}, err
+	}
+	h.logEvent(ctx, audit.EventLogin, userID, client, nil)
+
+	return Session{Token: signedToken}, nil
+}
+
+// rehashPassword upgrades a verified password's hash to the current
+// parameters. Only the hash that was checked is replaced, so a concurrent
+// password change wins. Failures are logged: the old hash keeps working.
+func (h *Handler) rehashPassword(ctx context.Context, userID, oldHash, password string) {
+	newHash, err := pkg.HashPassword(password)
+	if err != nil {
+		log.Printf("Error rehashing password for user %s: %v", userID, err)
+		return
+	}
+	if _, err := h.db.ExecContext(ctx,
+		"UPDATE users SET password = ?, password_version = ? WHERE id = ? AND password = ?",
+		newHash, pkg.PasswordVersion(), userID, oldHash,
+	); err != nil {
+		log.Printf("Error rehashing password for user %s: %v", userID, err)
+	}
+}
+
+// accountLocked returns the 423 error for a locked account, saying when to
+// try again in a Retry-After header and the body's retry_after
+func accountLocked(c *fiber.Ctx, until time.Time) error {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	err := apperr.New(fiber.StatusLocked, "Account is temporarily locked after too many failed logins")
+	err.RetryAfter = retryAfter
+	return err
+}
+
+// ChangePassword replaces the authenticated user's password after verifying
+// the current one. It bumps the user's token version so every previously
+// issued token stops working, ends the user's other sessions, and returns a
+// fresh token for this client's session.
+func (h *Handler) ChangePassword(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload PasswordChange
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid input")
+	}
+	if errs := validate.Struct(&payload); errs != nil {
+		return apperr.Invalid(errs)
+	}
+
+	var hashedPw string
+	var tokenVersion int
+	err = h.db.QueryRowContext(c.UserContext(),
+		"SELECT password, token_version FROM users WHERE id = ? AND deleted_at IS NULL",
+		userID,
+	).Scan(&hashedPw, &tokenVersion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "User not found")
+		}
+		return fmt.Errorf("looking up user: %w", err)
+	}
+
+	if err := pkg.CheckPasswordHash(payload.CurrentPassword, hashedPw); err != nil {
+		return apperr.New(fiber.StatusUnauthorized, "Current password is incorrect")
 	}
-	token := h.jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := h.jwt.SignedString(token, []byte(secret))
+
+	newHash, err := pkg.HashPassword(payload.NewPassword)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+
+	// The version check in the WHERE clause guards against two concurrent
+	// password changes both succeeding
+	result, err := h.db.ExecContext(c.UserContext(),
+		"UPDATE users SET password = ?, password_version = ?, token_version = token_version + 1 WHERE id = ? AND token_version = ?",
+		newHash, pkg.PasswordVersion(), userID, tokenVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("updating password: %w", err)
+	}
+
+	affectedRows, _ := result.RowsAffected()
+	if affectedRows == 0 {
+		return apperr.New(fiber.StatusConflict, "Password was changed concurrently")
+	}
+
+	// This client keeps its session; the others' tokens were just revoked
+	sessionID, _ := c.Locals("session-id").(string)
+	if sessionID == "" {
+		if sessionID, err = h.createSession(c.UserContext(), userID, "", clientOf(c)); err != nil {
+			return err
+		}
+	}
+	if _, err := h.db.ExecContext(c.UserContext(), "DELETE FROM sessions WHERE user_id = ? AND id <> ?", userID, sessionID); err != nil {
+		return fmt.Errorf("ending other sessions: %w", err)
+	}
+	h.logEvent(c.UserContext(), audit.EventPasswordChanged, userID, clientOf(c), nil)
+
+	signedToken, err := h.issueToken(userID, tokenVersion+1, sessionID)
 	if err != nil {
-		log.Println("JWT signing error:", err)
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return fmt.Errorf("signing token: %w", err)
 	}
 
 	return c.JSON(fiber.Map{
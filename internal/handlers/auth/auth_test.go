@@ -2,19 +2,60 @@ package auth
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"net/http"
 	"net/http/httptest"
-	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/pkg"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 )
 
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+// fakeMailer records sent emails
+type fakeMailer struct {
+	sent []string
+	err  error
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	m.sent = append(m.sent, to+": "+body)
+	return m.err
+}
+
+// events lists the events logged, in order
+func (f *fakeAudit) events() []audit.Event {
+	var events []audit.Event
+	for _, e := range f.entries {
+		events = append(events, e.Event)
+	}
+	return events
+}
+
 // testHelper contains common test setup and utilities
 type testHelper struct {
 	t       *testing.T
@@ -22,22 +63,22 @@ type testHelper struct {
 	mockDB  sqlmock.Sqlmock
 	app     *fiber.App
 	handler *Handler
+	audit   *fakeAudit
+	mailer  *fakeMailer
 }
 
 // newTestHelper creates a new test helper with common setup
 func newTestHelper(t *testing.T) *testHelper {
-	if err := os.Setenv("JWT_SECRET", "test-secret"); err != nil {
-		t.Fatalf("failed to set environment variable: %v", err)
-	}
-
 	db, mockDB, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("error opening stub database: %v", err)
 	}
 
 	jwtService := &JWTService{}
-	handler := NewHandler(db, jwtService)
-	app := fiber.New()
+	auditLog := &fakeAudit{}
+	mailer := &fakeMailer{}
+	handler := NewHandler(db, jwtService, pkg.SingleJWTKey("test-secret"), auditLog, mailer, EmailConfig{BaseURL: "https://notes.example.com/"}, SSOConfig{})
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
 
 	return &testHelper{
 		t:       t,
@@ -45,14 +86,13 @@ func newTestHelper(t *testing.T) *testHelper {
 		mockDB:  mockDB,
 		app:     app,
 		handler: handler,
+		audit:   auditLog,
+		mailer:  mailer,
 	}
 }
 
 // cleanup performs cleanup after tests
 func (h *testHelper) cleanup() {
-	if err := os.Unsetenv("JWT_SECRET"); err != nil {
-		h.t.Logf("failed to unset environment variable: %v", err)
-	}
 	// NOTE: Don't close the database connection here as sqlmock
 	// automatically closes it after expectations are met
 }
@@ -60,24 +100,52 @@ func (h *testHelper) cleanup() {
 // setupRoute sets up a route for testing
 func (h *testHelper) setupRoute(method, path string, handler fiber.Handler) {
 	switch method {
+	case "GET":
+		h.app.Get(path, handler)
 	case "POST":
 		h.app.Post(path, handler)
 	}
 }
 
+// expectSession expects a new session to be recorded for userID
+func (h *testHelper) expectSession(userID any) {
+	h.mockDB.ExpectExec(pruneSessions).WithArgs(userID, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	h.mockDB.ExpectExec(insertSession).
+		WithArgs(sqlmock.AnyArg(), userID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// withUser mocks an authenticated user ID in the request context
+func (h *testHelper) withUser(userID string) {
+	h.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", userID)
+		return c.Next()
+	})
+}
+
 func TestSignUp(t *testing.T) {
 	helper := newTestHelper(t)
 	defer helper.cleanup()
 
 	helper.setupRoute("POST", "/signup", helper.handler.SignUp)
 
+	// Use a valid bcrypt hash for 'password123'
+	validHash := "$2a$10$E6HhdnHa3eB0JwE2ZyieJuxCAYpWDYe403HM/LKSPi3FVetNZDk4i"
+	existingColumns := []string{"id", "password", "token_version", "failed_logins", "locked_until", "deleted"}
+
 	testCases := []struct {
 		name           string
 		payload        map[string]string
+		storedEmail    string
 		mockRows       *sqlmock.Rows
 		mockError      error
+		expectInsert   bool
+		insertError    error
+		retryRows      *sqlmock.Rows
+		failures       int
 		expectedStatus int
 		expectedError  string
+		fieldErrors    map[string]string
 	}{
 		{
 			name: "Success",
@@ -85,16 +153,93 @@ func TestSignUp(t *testing.T) {
 				"email":    "test@example.com",
 				"password": "password123",
 			},
-			mockRows:       sqlmock.NewRows([]string{"id"}),
+			mockRows:       sqlmock.NewRows(existingColumns),
+			expectInsert:   true,
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name: "Mixed Case Email",
+			payload: map[string]string{
+				"email":    " Test@Example.COM ",
+				"password": "password123",
+			},
+			storedEmail:    "test@example.com",
+			mockRows:       sqlmock.NewRows(existingColumns),
+			expectInsert:   true,
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name: "Locked Account With Matching Password",
+			payload: map[string]string{
+				"email":    "existing@example.com",
+				"password": "password123",
+			},
+			mockRows:       sqlmock.NewRows(existingColumns).AddRow("existing-user-id", validHash, 0, 5, time.Now().Add(time.Minute), false),
+			expectedStatus: fiber.StatusLocked,
+		},
+		{
+			name: "Wrong Password Locks Account",
+			payload: map[string]string{
+				"email":    "existing@example.com",
+				"password": "wrongpassword",
+			},
+			mockRows:       sqlmock.NewRows(existingColumns).AddRow("existing-user-id", validHash, 0, MaxFailedLogins-1, nil, false),
+			failures:       MaxFailedLogins,
+			expectedStatus: fiber.StatusLocked,
+		},
+		{
+			name: "Existing Account With Matching Password",
+			payload: map[string]string{
+				"email":    "existing@example.com",
+				"password": "password123",
+			},
+			mockRows:       sqlmock.NewRows(existingColumns).AddRow("existing-user-id", validHash, 3, 0, nil, false),
 			expectedStatus: fiber.StatusOK,
 		},
 		{
 			name: "Duplicate Email",
+			payload: map[string]string{
+				"email":    "existing@example.com",
+				"password": "wrongpassword",
+			},
+			mockRows:       sqlmock.NewRows(existingColumns).AddRow("existing-user-id", validHash, 0, 0, nil, false),
+			failures:       1,
+			expectedStatus: fiber.StatusConflict,
+			expectedError:  "Email already in use",
+		},
+		{
+			name: "Deleted Account With Matching Password",
 			payload: map[string]string{
 				"email":    "existing@example.com",
 				"password": "password123",
 			},
-			mockRows:       sqlmock.NewRows([]string{"id"}).AddRow("existing-user-id"),
+			mockRows:       sqlmock.NewRows(existingColumns).AddRow("existing-user-id", validHash, 1, 0, nil, true),
+			expectedStatus: fiber.StatusConflict,
+			expectedError:  "Email already in use",
+		},
+		{
+			name: "Concurrent Signup With Matching Password",
+			payload: map[string]string{
+				"email":    "test@example.com",
+				"password": "password123",
+			},
+			mockRows:       sqlmock.NewRows(existingColumns),
+			expectInsert:   true,
+			insertError:    &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+			retryRows:      sqlmock.NewRows(existingColumns).AddRow("other-user-id", validHash, 0, 0, nil, false),
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name: "Concurrent Signup With Other Password",
+			payload: map[string]string{
+				"email":    "test@example.com",
+				"password": "wrongpassword",
+			},
+			mockRows:       sqlmock.NewRows(existingColumns),
+			expectInsert:   true,
+			insertError:    &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+			retryRows:      sqlmock.NewRows(existingColumns).AddRow("other-user-id", validHash, 0, 0, nil, false),
+			failures:       1,
 			expectedStatus: fiber.StatusConflict,
 			expectedError:  "Email already in use",
 		},
@@ -113,8 +258,8 @@ func TestSignUp(t *testing.T) {
 				"email":    "invalid-email",
 				"password": "password123",
 			},
-			expectedStatus: fiber.StatusBadRequest,
-			expectedError:  "Invalid email format",
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"email": "invalid format"},
 		},
 		{
 			name: "Short Password",
@@ -122,8 +267,8 @@ func TestSignUp(t *testing.T) {
 				"email":    "test@example.com",
 				"password": "short",
 			},
-			expectedStatus: fiber.StatusBadRequest,
-			expectedError:  "Password must be at least 8 characters long",
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"password": "must be at least 8 characters"},
 		},
 	}
 
@@ -132,20 +277,40 @@ func TestSignUp(t *testing.T) {
 			// Skip database expectations for cases that should fail at validation
 			skipDbSetup := tc.name == "Invalid Email" || tc.name == "Short Password"
 
+			email := tc.payload["email"]
+			if tc.storedEmail != "" {
+				email = tc.storedEmail
+			}
+			query := regexp.QuoteMeta("SELECT id, password, token_version, failed_logins, locked_until, deleted_at IS NOT NULL FROM users WHERE email = ?")
 			if !skipDbSetup {
 				// Setup mock expectations
-				query := regexp.QuoteMeta("SELECT id FROM users WHERE email = ?")
 				if tc.mockError != nil {
-					helper.mockDB.ExpectQuery(query).WithArgs(tc.payload["email"]).WillReturnError(tc.mockError)
+					helper.mockDB.ExpectQuery(query).WithArgs(email).WillReturnError(tc.mockError)
 				} else {
-					helper.mockDB.ExpectQuery(query).WithArgs(tc.payload["email"]).WillReturnRows(tc.mockRows)
+					helper.mockDB.ExpectQuery(query).WithArgs(email).WillReturnRows(tc.mockRows)
 				}
 			}
 
-			if !skipDbSetup && tc.expectedStatus == fiber.StatusOK {
-				helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO users (id, email, password) VALUES (?, ?, ?)")).
-					WithArgs(sqlmock.AnyArg(), tc.payload["email"], sqlmock.AnyArg()).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+			if tc.expectInsert {
+				helper.mockDB.ExpectBegin()
+				insert := helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO users (id, email, password, password_version) VALUES (?, ?, ?, ?)")).
+					WithArgs(sqlmock.AnyArg(), email, sqlmock.AnyArg(), pkg.PasswordVersion())
+				if tc.insertError != nil {
+					insert.WillReturnError(tc.insertError)
+					helper.mockDB.ExpectRollback()
+					helper.mockDB.ExpectQuery(query).WithArgs(email).WillReturnRows(tc.retryRows)
+				} else {
+					insert.WillReturnResult(sqlmock.NewResult(1, 1))
+					helper.mockDB.ExpectCommit()
+				}
+			}
+			if tc.failures > 0 {
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE users SET failed_logins = ?, locked_until = ? WHERE id = ?")).
+					WithArgs(tc.failures, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			if tc.expectedStatus == fiber.StatusOK {
+				helper.expectSession(sqlmock.AnyArg())
 			}
 
 			jsonPayload, err := json.Marshal(tc.payload)
@@ -163,13 +328,20 @@ func TestSignUp(t *testing.T) {
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
 
-			if tc.expectedError != "" {
-				var response map[string]string
+			if tc.fieldErrors != nil {
+				var response apperr.Response
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				if err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.fieldErrors, response.Errors)
+			} else if tc.expectedError != "" {
+				var response apperr.Response
 				err = json.NewDecoder(resp.Body).Decode(&response)
 				if err != nil {
 					t.Fatalf("error decoding response: %v", err)
 				}
-				assert.Equal(t, tc.expectedError, response["error"])
+				assert.Equal(t, tc.expectedError, response.Message)
 			} else if tc.expectedStatus == fiber.StatusOK {
 				var response map[string]string
 				err = json.NewDecoder(resp.Body).Decode(&response)
@@ -186,6 +358,92 @@ func TestSignUp(t *testing.T) {
 	}
 }
 
+func TestSignUp_WithInvite(t *testing.T) {
+	existingQuery := regexp.QuoteMeta("SELECT id, password, token_version, failed_logins, locked_until, deleted_at IS NOT NULL FROM users WHERE email = ?")
+	insertQuery := regexp.QuoteMeta("INSERT INTO users (id, email, password, password_version) VALUES (?, ?, ?, ?)")
+	inviteQuery := regexp.QuoteMeta("SELECT id, workspace_id, email, role, expires_at FROM workspace_invitations WHERE token_hash = ?")
+	inviteColumns := []string{"id", "workspace_id", "email", "role", "expires_at"}
+
+	testCases := []struct {
+		name           string
+		inviteRows     *sqlmock.Rows
+		expectJoin     bool
+		expectedStatus int
+	}{
+		{
+			name:           "Joins Workspace",
+			inviteRows:     sqlmock.NewRows(inviteColumns).AddRow("inv1", "ws1", "new@example.com", "member", time.Now().Add(time.Hour)),
+			expectJoin:     true,
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Expired Invite Creates No Account",
+			inviteRows:     sqlmock.NewRows(inviteColumns).AddRow("inv1", "ws1", nil, "member", time.Now().Add(-time.Hour)),
+			expectedStatus: fiber.StatusGone,
+		},
+		{
+			name:           "Invite For Another Email",
+			inviteRows:     sqlmock.NewRows(inviteColumns).AddRow("inv1", "ws1", "other@example.com", "member", time.Now().Add(time.Hour)),
+			expectedStatus: fiber.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("POST", "/signup", helper.handler.SignUp)
+
+			helper.mockDB.ExpectQuery(existingQuery).WithArgs("new@example.com").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			helper.mockDB.ExpectBegin()
+			helper.mockDB.ExpectExec(insertQuery).WithArgs(sqlmock.AnyArg(), "new@example.com", sqlmock.AnyArg(), pkg.PasswordVersion()).WillReturnResult(sqlmock.NewResult(1, 1))
+			helper.mockDB.ExpectQuery(inviteQuery).WithArgs(sqlmock.AnyArg()).WillReturnRows(tc.inviteRows)
+			if tc.expectJoin {
+				helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user_id = ?)")).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)")).
+					WithArgs("ws1", sqlmock.AnyArg(), "member").WillReturnResult(sqlmock.NewResult(1, 1))
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM workspace_invitations WHERE id = ?")).WithArgs("inv1").WillReturnResult(sqlmock.NewResult(0, 1))
+				helper.mockDB.ExpectCommit()
+				helper.expectSession(sqlmock.AnyArg())
+			} else {
+				helper.mockDB.ExpectRollback()
+			}
+
+			body, _ := json.Marshal(Registration{Email: "new@example.com", Password: "password123", InviteToken: "secret"})
+			req := httptest.NewRequest("POST", "/signup", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectJoin {
+				var response map[string]string
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.NotEmpty(t, response["token"])
+				assert.Equal(t, "ws1", response["workspace_id"])
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+var (
+	pruneSessions     = regexp.QuoteMeta("DELETE FROM sessions WHERE user_id = ? AND created_at < ?")
+	insertSession     = regexp.QuoteMeta("INSERT INTO sessions (id, user_id, device, ip, user_agent, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	loginColumns      = []string{"id", "password", "password_version", "token_version", "failed_logins", "locked_until"}
+	loginQuery        = regexp.QuoteMeta("SELECT id, password, password_version, token_version, failed_logins, locked_until FROM users WHERE email = ? AND deleted_at IS NULL")
+	rehashUpdate      = regexp.QuoteMeta("UPDATE users SET password = ?, password_version = ? WHERE id = ? AND password = ?")
+	failedLoginUpdate = regexp.QuoteMeta("UPDATE users SET failed_logins = ?, locked_until = ? WHERE id = ?")
+	resetLoginUpdate  = regexp.QuoteMeta("UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?")
+)
+
 func TestLogin(t *testing.T) {
 	helper := newTestHelper(t)
 	defer helper.cleanup()
@@ -194,14 +452,21 @@ func TestLogin(t *testing.T) {
 
 	// Use a valid bcrypt hash for 'password123'
 	validHash := "$2a$10$E6HhdnHa3eB0JwE2ZyieJuxCAYpWDYe403HM/LKSPi3FVetNZDk4i"
+	currentHash, err := pkg.HashPassword("password123")
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
 
 	testCases := []struct {
 		name           string
 		payload        map[string]string
 		mockRows       *sqlmock.Rows
 		mockError      error
+		expectRehash   bool
 		expectedStatus int
 		expectedError  string
+		fieldErrors    map[string]string
+		expectedEvents []audit.Event
 	}{
 		{
 			name: "Success",
@@ -209,8 +474,41 @@ func TestLogin(t *testing.T) {
 				"email":    "test@example.com",
 				"password": "password123",
 			},
-			mockRows:       sqlmock.NewRows([]string{"id", "password"}).AddRow("user123", validHash),
+			mockRows:       sqlmock.NewRows(loginColumns).AddRow("user123", currentHash, pkg.PasswordVersion(), 0, 0, nil),
 			expectedStatus: fiber.StatusOK,
+			expectedEvents: []audit.Event{audit.EventLogin},
+		},
+		{
+			name: "Success With Surrounding Spaces",
+			payload: map[string]string{
+				"email":    "  Test@Example.com ",
+				"password": " password123\n",
+			},
+			mockRows:       sqlmock.NewRows(loginColumns).AddRow("user123", currentHash, pkg.PasswordVersion(), 0, 0, nil),
+			expectedStatus: fiber.StatusOK,
+			expectedEvents: []audit.Event{audit.EventLogin},
+		},
+		{
+			name: "Success Upgrades Bcrypt Hash",
+			payload: map[string]string{
+				"email":    "test@example.com",
+				"password": "password123",
+			},
+			mockRows:       sqlmock.NewRows(loginColumns).AddRow("user123", validHash, pkg.PasswordVersionBcrypt, 0, 0, nil),
+			expectRehash:   true,
+			expectedStatus: fiber.StatusOK,
+			expectedEvents: []audit.Event{audit.EventLogin},
+		},
+		{
+			name: "Success Upgrades Old Parameters",
+			payload: map[string]string{
+				"email":    "test@example.com",
+				"password": "password123",
+			},
+			mockRows:       sqlmock.NewRows(loginColumns).AddRow("user123", currentHash, pkg.PasswordVersion()-1, 0, 0, nil),
+			expectRehash:   true,
+			expectedStatus: fiber.StatusOK,
+			expectedEvents: []audit.Event{audit.EventLogin},
 		},
 		{
 			name: "Invalid Credentials",
@@ -218,9 +516,10 @@ func TestLogin(t *testing.T) {
 				"email":    "test@example.com",
 				"password": "wrongpassword",
 			},
-			mockRows:       sqlmock.NewRows([]string{"id", "password"}).AddRow("user123", validHash),
+			mockRows:       sqlmock.NewRows(loginColumns).AddRow("user123", validHash, pkg.PasswordVersionBcrypt, 0, 0, nil),
 			expectedStatus: fiber.StatusUnauthorized,
 			expectedError:  "Invalid credentials",
+			expectedEvents: []audit.Event{audit.EventLoginFailed},
 		},
 		{
 			name: "User Not Found",
@@ -228,9 +527,10 @@ func TestLogin(t *testing.T) {
 				"email":    "nonexistent@example.com",
 				"password": "password123",
 			},
-			mockRows:       sqlmock.NewRows([]string{"id", "password"}),
+			mockRows:       sqlmock.NewRows(loginColumns),
 			expectedStatus: fiber.StatusUnauthorized,
 			expectedError:  "Invalid credentials",
+			expectedEvents: []audit.Event{audit.EventLoginFailed},
 		},
 		{
 			name: "Database Error",
@@ -247,22 +547,35 @@ func TestLogin(t *testing.T) {
 				"email":    "",
 				"password": "",
 			},
-			expectedStatus: fiber.StatusBadRequest,
-			expectedError:  "Email and password cannot be empty",
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"email": "required", "password": "required"},
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
+			helper.audit.entries = nil
 			// Skip database expectations for cases that should fail at validation
 			skipDbSetup := tc.name == "Empty Credentials"
 
 			if !skipDbSetup {
-				query := regexp.QuoteMeta("SELECT id, password FROM users WHERE email = ?")
 				if tc.mockError != nil {
-					helper.mockDB.ExpectQuery(query).WithArgs(tc.payload["email"]).WillReturnError(tc.mockError)
+					helper.mockDB.ExpectQuery(loginQuery).WithArgs(strings.ToLower(strings.TrimSpace(tc.payload["email"]))).WillReturnError(tc.mockError)
 				} else {
-					helper.mockDB.ExpectQuery(query).WithArgs(tc.payload["email"]).WillReturnRows(tc.mockRows)
+					helper.mockDB.ExpectQuery(loginQuery).WithArgs(strings.ToLower(strings.TrimSpace(tc.payload["email"]))).WillReturnRows(tc.mockRows)
+				}
+				if tc.name == "Invalid Credentials" {
+					helper.mockDB.ExpectExec(failedLoginUpdate).
+						WithArgs(1, nil, "user123").
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				if tc.expectRehash {
+					helper.mockDB.ExpectExec(rehashUpdate).
+						WithArgs(sqlmock.AnyArg(), pkg.PasswordVersion(), "user123", sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				if tc.expectedStatus == fiber.StatusOK {
+					helper.expectSession("user123")
 				}
 			}
 
@@ -281,13 +594,236 @@ func TestLogin(t *testing.T) {
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
 
-			if tc.expectedError != "" {
+			if tc.fieldErrors != nil {
+				var response apperr.Response
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				if err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.fieldErrors, response.Errors)
+			} else if tc.expectedError != "" {
+				var response apperr.Response
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				if err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			} else if tc.expectedStatus == fiber.StatusOK {
 				var response map[string]string
 				err = json.NewDecoder(resp.Body).Decode(&response)
 				if err != nil {
 					t.Fatalf("error decoding response: %v", err)
 				}
-				assert.Equal(t, tc.expectedError, response["error"])
+				assert.NotEmpty(t, response["token"])
+
+				token, _, err := jwt.NewParser().ParseUnverified(response["token"], jwt.MapClaims{})
+				if assert.NoError(t, err) {
+					assert.Equal(t, "default", token.Header["kid"])
+					assert.Equal(t, "HS256", token.Header["alg"])
+				}
+			}
+
+			assert.Equal(t, tc.expectedEvents, helper.audit.events())
+		})
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("POST", "/login", helper.handler.Login)
+
+	// Use a valid bcrypt hash for 'password123'
+	validHash := "$2a$10$E6HhdnHa3eB0JwE2ZyieJuxCAYpWDYe403HM/LKSPi3FVetNZDk4i"
+
+	login := func(password string) *http.Response {
+		payload, _ := json.Marshal(map[string]string{"email": "test@example.com", "password": password})
+		req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(payload))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := helper.app.Test(req)
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("Failure That Reaches The Limit Locks", func(t *testing.T) {
+		helper.mockDB.ExpectQuery(loginQuery).
+			WillReturnRows(sqlmock.NewRows(loginColumns).AddRow("user123", validHash, pkg.PasswordVersionBcrypt, 0, MaxFailedLogins-1, nil))
+		helper.mockDB.ExpectExec(failedLoginUpdate).
+			WithArgs(MaxFailedLogins, sqlmock.AnyArg(), "user123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		resp := login("wrongpassword")
+		assert.Equal(t, fiber.StatusLocked, resp.StatusCode)
+		assert.Equal(t, "60", resp.Header.Get("Retry-After"))
+		var body apperr.Response
+		if assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body)) {
+			assert.Equal(t, 60, body.RetryAfter)
+		}
+		assert.Equal(t, []audit.Event{audit.EventLoginFailed, audit.EventAccountLocked}, helper.audit.events())
+	})
+
+	t.Run("Locked Account Rejects Correct Password", func(t *testing.T) {
+		helper.audit.entries = nil
+		helper.mockDB.ExpectQuery(loginQuery).
+			WillReturnRows(sqlmock.NewRows(loginColumns).AddRow("user123", validHash, pkg.PasswordVersionBcrypt, 0, MaxFailedLogins, time.Now().Add(time.Minute)))
+
+		resp := login("password123")
+		assert.Equal(t, fiber.StatusLocked, resp.StatusCode)
+
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		assert.NoError(t, err)
+		assert.InDelta(t, 60, retryAfter, 1)
+		if assert.Len(t, helper.audit.entries, 1) {
+			assert.Equal(t, map[string]string{"reason": "locked"}, helper.audit.entries[0].Details)
+		}
+	})
+
+	t.Run("Success After Lock Expires Resets Count", func(t *testing.T) {
+		helper.mockDB.ExpectQuery(loginQuery).
+			WillReturnRows(sqlmock.NewRows(loginColumns).AddRow("user123", validHash, pkg.PasswordVersionBcrypt, 0, MaxFailedLogins, time.Now().Add(-time.Second)))
+		helper.mockDB.ExpectExec(resetLoginUpdate).
+			WithArgs("user123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		helper.mockDB.ExpectExec(rehashUpdate).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		helper.expectSession("user123")
+
+		resp := login("password123")
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	})
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLockoutWindow(t *testing.T) {
+	assert.Zero(t, lockoutWindow(MaxFailedLogins-1))
+	assert.Equal(t, BaseLockout, lockoutWindow(MaxFailedLogins))
+	assert.Equal(t, 2*BaseLockout, lockoutWindow(MaxFailedLogins+1))
+	assert.Equal(t, 8*BaseLockout, lockoutWindow(MaxFailedLogins+3))
+	assert.Equal(t, MaxLockout, lockoutWindow(MaxFailedLogins+50))
+}
+
+func TestChangePassword(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.withUser("user123")
+	helper.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("session-id", "session1")
+		return c.Next()
+	})
+	helper.setupRoute("POST", "/me/password", helper.handler.ChangePassword)
+
+	// Use a valid bcrypt hash for 'password123'
+	validHash := "$2a$10$E6HhdnHa3eB0JwE2ZyieJuxCAYpWDYe403HM/LKSPi3FVetNZDk4i"
+	selectQuery := regexp.QuoteMeta("SELECT password, token_version FROM users WHERE id = ? AND deleted_at IS NULL")
+	updateQuery := regexp.QuoteMeta("UPDATE users SET password = ?, password_version = ?, token_version = token_version + 1 WHERE id = ? AND token_version = ?")
+
+	testCases := []struct {
+		name           string
+		payload        map[string]string
+		mockRows       *sqlmock.Rows
+		mockError      error
+		expectUpdate   bool
+		rowsAffected   int64
+		expectedStatus int
+		expectedError  string
+		fieldErrors    map[string]string
+	}{
+		{
+			name:           "Success",
+			payload:        map[string]string{"current_password": "password123", "new_password": "newpassword456"},
+			mockRows:       sqlmock.NewRows([]string{"password", "token_version"}).AddRow(validHash, 3),
+			expectUpdate:   true,
+			rowsAffected:   1,
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Wrong Current Password",
+			payload:        map[string]string{"current_password": "wrongpassword", "new_password": "newpassword456"},
+			mockRows:       sqlmock.NewRows([]string{"password", "token_version"}).AddRow(validHash, 3),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Current password is incorrect",
+		},
+		{
+			name:           "Short New Password",
+			payload:        map[string]string{"current_password": "password123", "new_password": "short"},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"new_password": "must be at least 8 characters"},
+		},
+		{
+			name:           "Concurrent Change",
+			payload:        map[string]string{"current_password": "password123", "new_password": "newpassword456"},
+			mockRows:       sqlmock.NewRows([]string{"password", "token_version"}).AddRow(validHash, 3),
+			expectUpdate:   true,
+			rowsAffected:   0,
+			expectedStatus: fiber.StatusConflict,
+			expectedError:  "Password was changed concurrently",
+		},
+		{
+			name:           "Database Error",
+			payload:        map[string]string{"current_password": "password123", "new_password": "newpassword456"},
+			mockError:      errors.New("database error"),
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.mockRows != nil {
+				helper.mockDB.ExpectQuery(selectQuery).WithArgs("user123").WillReturnRows(tc.mockRows)
+			} else if tc.mockError != nil {
+				helper.mockDB.ExpectQuery(selectQuery).WithArgs("user123").WillReturnError(tc.mockError)
+			}
+			if tc.expectUpdate {
+				helper.mockDB.ExpectExec(updateQuery).
+					WithArgs(sqlmock.AnyArg(), pkg.PasswordVersion(), "user123", 3).
+					WillReturnResult(sqlmock.NewResult(0, tc.rowsAffected))
+			}
+			if tc.expectedStatus == fiber.StatusOK {
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM sessions WHERE user_id = ? AND id <> ?")).
+					WithArgs("user123", "session1").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			}
+
+			jsonPayload, err := json.Marshal(tc.payload)
+			if err != nil {
+				t.Fatalf("error marshaling payload: %v", err)
+			}
+
+			req := httptest.NewRequest("POST", "/me/password", bytes.NewBuffer(jsonPayload))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.fieldErrors != nil {
+				var response apperr.Response
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				if err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.fieldErrors, response.Errors)
+			} else if tc.expectedError != "" {
+				var response apperr.Response
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				if err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
 			} else if tc.expectedStatus == fiber.StatusOK {
 				var response map[string]string
 				err = json.NewDecoder(resp.Body).Decode(&response)
@@ -295,6 +831,11 @@ func TestLogin(t *testing.T) {
 					t.Fatalf("error decoding response: %v", err)
 				}
 				assert.NotEmpty(t, response["token"])
+
+				claims := jwt.MapClaims{}
+				if _, _, err := jwt.NewParser().ParseUnverified(response["token"], claims); assert.NoError(t, err) {
+					assert.Equal(t, "session1", claims["jti"])
+				}
 			}
 		})
 	}
@@ -303,3 +844,317 @@ func TestLogin(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %v", err)
 	}
 }
+
+func TestListSessions(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.withUser("user123")
+	helper.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("session-id", "session2")
+		return c.Next()
+	})
+	helper.app.Get("/me/sessions", helper.handler.ListSessions)
+
+	now := time.Now()
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, device, ip, user_agent, created_at, last_seen_at FROM sessions WHERE user_id = ? AND created_at >= ? ORDER BY last_seen_at DESC")).
+		WithArgs("user123", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "device", "ip", "user_agent", "created_at", "last_seen_at"}).
+			AddRow("session1", "Work laptop", "10.0.0.1", "Firefox", now.Add(-time.Hour), now).
+			AddRow("session2", "", "10.0.0.2", "curl", now.Add(-2*time.Hour), now.Add(-time.Minute)))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/me/sessions", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var sessions []ActiveSession
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, sessions, 2) {
+		assert.Equal(t, "Work laptop", sessions[0].Device)
+		assert.False(t, sessions[0].Current)
+		assert.True(t, sessions[1].Current)
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.withUser("user123")
+	helper.app.Delete("/me/sessions/:id", helper.handler.DeleteSession)
+	query := regexp.QuoteMeta("DELETE FROM sessions WHERE id = ? AND user_id = ?")
+
+	helper.mockDB.ExpectExec(query).WithArgs("session1", "user123").WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err := helper.app.Test(httptest.NewRequest("DELETE", "/me/sessions/session1", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+
+	helper.mockDB.ExpectExec(query).WithArgs("other", "user123").WillReturnResult(sqlmock.NewResult(0, 0))
+	resp, err = helper.app.Test(httptest.NewRequest("DELETE", "/me/sessions/other", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	// Use a valid bcrypt hash for 'password123'
+	validHash := "$2a$10$E6HhdnHa3eB0JwE2ZyieJuxCAYpWDYe403HM/LKSPi3FVetNZDk4i"
+	selectQuery := regexp.QuoteMeta("SELECT password, email FROM users WHERE id = ? AND deleted_at IS NULL")
+	takenQuery := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = ?)")
+
+	testCases := []struct {
+		name           string
+		payload        map[string]string
+		taken          bool
+		expectTaken    bool
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Success",
+			payload:        map[string]string{"email": "New@Example.com", "password": "password123"},
+			expectTaken:    true,
+			expectedStatus: fiber.StatusAccepted,
+		},
+		{
+			name:           "Wrong Password",
+			payload:        map[string]string{"email": "new@example.com", "password": "wrongpassword"},
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Password is incorrect",
+		},
+		{
+			name:           "Same Email",
+			payload:        map[string]string{"email": "Old@example.com", "password": "password123"},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Email In Use",
+			payload:        map[string]string{"email": "new@example.com", "password": "password123"},
+			taken:          true,
+			expectTaken:    true,
+			expectedStatus: fiber.StatusConflict,
+			expectedError:  "Email already in use",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.withUser("user123")
+			helper.setupRoute("POST", "/me/email", helper.handler.ChangeEmail)
+
+			helper.mockDB.ExpectQuery(selectQuery).WithArgs("user123").
+				WillReturnRows(sqlmock.NewRows([]string{"password", "email"}).AddRow(validHash, "old@example.com"))
+			if tc.expectTaken {
+				helper.mockDB.ExpectQuery(takenQuery).WithArgs("new@example.com").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.taken))
+			}
+			if tc.expectedStatus == fiber.StatusAccepted {
+				helper.mockDB.ExpectBegin()
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM email_changes WHERE user_id = ?")).
+					WithArgs("user123").WillReturnResult(sqlmock.NewResult(0, 0))
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO email_changes (user_id, email, token_hash, expires_at) VALUES (?, ?, ?, ?)")).
+					WithArgs("user123", "new@example.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				helper.mockDB.ExpectCommit()
+			}
+
+			body, _ := json.Marshal(tc.payload)
+			req := httptest.NewRequest("POST", "/me/email", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+			if tc.expectedStatus == fiber.StatusAccepted {
+				var pending PendingEmailChange
+				if err := json.NewDecoder(resp.Body).Decode(&pending); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, "new@example.com", pending.Email)
+				if assert.Len(t, helper.mailer.sent, 1) {
+					assert.Contains(t, helper.mailer.sent[0], "new@example.com: ")
+					assert.Contains(t, helper.mailer.sent[0], "https://notes.example.com/verify-email/")
+				}
+				assert.Equal(t, []audit.Event{audit.EventEmailChangeRequested}, helper.audit.events())
+			} else {
+				assert.Empty(t, helper.mailer.sent)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	changeQuery := regexp.QuoteMeta("SELECT email, expires_at FROM email_changes WHERE user_id = ? AND token_hash = ?")
+	userQuery := regexp.QuoteMeta("SELECT email, token_version FROM users WHERE id = ? AND deleted_at IS NULL")
+	updateQuery := regexp.QuoteMeta("UPDATE users SET email = ?, token_version = token_version + 1 WHERE id = ?")
+
+	testCases := []struct {
+		name           string
+		changeRows     *sqlmock.Rows
+		updateErr      error
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Success",
+			changeRows:     sqlmock.NewRows([]string{"email", "expires_at"}).AddRow("new@example.com", time.Now().Add(time.Hour)),
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Unknown Token",
+			changeRows:     sqlmock.NewRows([]string{"email", "expires_at"}),
+			expectedStatus: fiber.StatusNotFound,
+			expectedError:  "Verification link is invalid",
+		},
+		{
+			name:           "Expired",
+			changeRows:     sqlmock.NewRows([]string{"email", "expires_at"}).AddRow("new@example.com", time.Now().Add(-time.Hour)),
+			expectedStatus: fiber.StatusGone,
+			expectedError:  "Verification link has expired",
+		},
+		{
+			name:           "Taken Since Requested",
+			changeRows:     sqlmock.NewRows([]string{"email", "expires_at"}).AddRow("new@example.com", time.Now().Add(time.Hour)),
+			updateErr:      &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+			expectedStatus: fiber.StatusConflict,
+			expectedError:  "Email already in use",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.withUser("user123")
+			helper.app.Use(func(c *fiber.Ctx) error {
+				c.Locals("session-id", "session1")
+				return c.Next()
+			})
+			helper.setupRoute("POST", "/me/email/verify", helper.handler.VerifyEmail)
+
+			helper.mockDB.ExpectBegin()
+			helper.mockDB.ExpectQuery(changeQuery).WithArgs("user123", hashToken("tok")).WillReturnRows(tc.changeRows)
+			switch tc.expectedStatus {
+			case fiber.StatusOK, fiber.StatusConflict:
+				helper.mockDB.ExpectQuery(userQuery).WithArgs("user123").
+					WillReturnRows(sqlmock.NewRows([]string{"email", "token_version"}).AddRow("old@example.com", 2))
+				if tc.updateErr != nil {
+					helper.mockDB.ExpectExec(updateQuery).WithArgs("new@example.com", "user123").WillReturnError(tc.updateErr)
+					helper.mockDB.ExpectRollback()
+					break
+				}
+				helper.mockDB.ExpectExec(updateQuery).WithArgs("new@example.com", "user123").WillReturnResult(sqlmock.NewResult(0, 1))
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM email_changes WHERE user_id = ?")).
+					WithArgs("user123").WillReturnResult(sqlmock.NewResult(0, 1))
+				helper.mockDB.ExpectCommit()
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM sessions WHERE user_id = ? AND id <> ?")).
+					WithArgs("user123", "session1").WillReturnResult(sqlmock.NewResult(0, 1))
+			default:
+				helper.mockDB.ExpectRollback()
+			}
+
+			req := httptest.NewRequest("POST", "/me/email/verify", bytes.NewBufferString(`{"token":"tok"}`))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+				assert.Empty(t, helper.mailer.sent)
+			} else {
+				var response map[string]string
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				claims := jwt.MapClaims{}
+				if _, _, err := jwt.NewParser().ParseUnverified(response["token"], claims); assert.NoError(t, err) {
+					assert.Equal(t, "session1", claims["jti"])
+					assert.EqualValues(t, 3, claims["token-version"])
+				}
+				// The old address hears about the change
+				if assert.Len(t, helper.mailer.sent, 1) {
+					assert.Contains(t, helper.mailer.sent[0], "old@example.com: ")
+				}
+				assert.Equal(t, []audit.Event{audit.EventEmailChanged}, helper.audit.events())
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	keys, err := pkg.NewJWTKeys("ed",
+		pkg.JWTKey{ID: "hmac", Secret: []byte("test-secret")},
+		pkg.JWTKey{ID: "ed", Private: edKey},
+	)
+	if err != nil {
+		t.Fatalf("error creating keys: %v", err)
+	}
+	handler := NewHandler(nil, &JWTService{}, keys, &fakeAudit{}, &fakeMailer{}, EmailConfig{}, SSOConfig{})
+
+	signed, err := handler.issueToken("user123", 0, "session1")
+	if err != nil {
+		t.Fatalf("error issuing token: %v", err)
+	}
+	token, err := keys.ParseJWT(signed)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ed", token.Header["kid"])
+		assert.Equal(t, "EdDSA", token.Header["alg"])
+	}
+
+	app := fiber.New()
+	app.Get("/.well-known/jwks.json", handler.JWKS)
+	resp, err := app.Test(httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "public, max-age=300", resp.Header.Get("Cache-Control"))
+
+	var jwks pkg.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, jwks.Keys, 1) {
+		assert.Equal(t, "ed", jwks.Keys[0].Kid)
+		assert.Equal(t, "Ed25519", jwks.Keys[0].Crv)
+	}
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/validate"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultEmailChangeTTL is how long an email verification link works
+const DefaultEmailChangeTTL = 24 * time.Hour
+
+// EmailConfig controls the links that verify a new email address
+type EmailConfig struct {
+	// BaseURL is where the web app is served. Links point to
+	// BaseURL/verify-email/<token>.
+	BaseURL string
+	// TTL is how long a link can be used. Zero means DefaultEmailChangeTTL.
+	TTL time.Duration
+}
+
+// EmailChange is the request body for ChangeEmail
+type EmailChange struct {
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required"`
+}
+
+// EmailVerification is the request body for VerifyEmail
+type EmailVerification struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// PendingEmailChange is the response to ChangeEmail. The account keeps its
+// current email until the link sent to Email is opened.
+type PendingEmailChange struct {
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ChangeEmail starts changing the authenticated user's email after
+// confirming their password. A verification link is mailed to the new
+// address; the current address keeps working for login until VerifyEmail
+// is called with the link's token. A second request replaces the first.
+func (h *Handler) ChangeEmail(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload EmailChange
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid input")
+	}
+	if errs := validate.Struct(&payload); errs != nil {
+		return apperr.Invalid(errs)
+	}
+	email := strings.ToLower(strings.TrimSpace(payload.Email))
+
+	ctx := c.UserContext()
+	var hashedPw, current string
+	err = h.db.QueryRowContext(ctx,
+		"SELECT password, email FROM users WHERE id = ? AND deleted_at IS NULL",
+		userID,
+	).Scan(&hashedPw, &current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "User not found")
+		}
+		return fmt.Errorf("looking up user: %w", err)
+	}
+
+	if err := pkg.CheckPasswordHash(payload.Password, hashedPw); err != nil {
+		return apperr.New(fiber.StatusUnauthorized, "Password is incorrect")
+	}
+	if strings.EqualFold(email, current) {
+		return apperr.Invalid(map[string]string{"email": "is already your email"})
+	}
+
+	var taken bool
+	if err := h.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = ?)", email,
+	).Scan(&taken); err != nil {
+		return fmt.Errorf("checking email: %w", err)
+	}
+	if taken {
+		return errEmailInUse
+	}
+
+	token, hash, err := newToken()
+	if err != nil {
+		return fmt.Errorf("generating verification token: %w", err)
+	}
+	pending := PendingEmailChange{
+		Email:     email,
+		ExpiresAt: time.Now().Add(h.emails.TTL).UTC().Truncate(time.Second),
+	}
+
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM email_changes WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO email_changes (user_id, email, token_hash, expires_at) VALUES (?, ?, ?, ?)",
+			userID, email, hash, pending.ExpiresAt,
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("recording email change: %w", err)
+	}
+
+	// Without the email the change can't be completed, so unlike an
+	// invitation a delivery failure fails the request
+	body := fmt.Sprintf("Open this link to confirm %s as the email for your account:\n\n%s/verify-email/%s\n\n"+
+		"The link expires on %s. If you didn't ask for this change you can ignore this email.\n",
+		email, h.emails.BaseURL, token, pending.ExpiresAt.Format("January 2, 2006 at 15:04 MST"))
+	if err := h.mailer.Send(email, "Confirm your new email address", body); err != nil {
+		return fmt.Errorf("emailing verification link: %w", err)
+	}
+
+	h.logEvent(ctx, audit.EventEmailChangeRequested, userID, clientOf(c), map[string]string{"email": email})
+
+	return c.Status(fiber.StatusAccepted).JSON(pending)
+}
+
+// VerifyEmail completes an email change with the token from the link
+// ChangeEmail sent. Like a password change it bumps the user's token
+// version, ends their other sessions and returns a fresh token for this
+// client's session. The previous address is told about the change.
+func (h *Handler) VerifyEmail(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload EmailVerification
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid input")
+	}
+	if errs := validate.Struct(&payload); errs != nil {
+		return apperr.Invalid(errs)
+	}
+
+	ctx := c.UserContext()
+	var oldEmail, newEmail string
+	var tokenVersion int
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		var expiresAt time.Time
+		err := tx.QueryRowContext(ctx,
+			"SELECT email, expires_at FROM email_changes WHERE user_id = ? AND token_hash = ?",
+			userID, hashToken(payload.Token),
+		).Scan(&newEmail, &expiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Verification link is invalid")
+		}
+		if err != nil {
+			return err
+		}
+		if time.Now().After(expiresAt) {
+			return apperr.New(fiber.StatusGone, "Verification link has expired")
+		}
+
+		err = tx.QueryRowContext(ctx,
+			"SELECT email, token_version FROM users WHERE id = ? AND deleted_at IS NULL",
+			userID,
+		).Scan(&oldEmail, &tokenVersion)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "User not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		// The unique index on email rejects an address someone signed up
+		// with after the change was requested
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET email = ?, token_version = token_version + 1 WHERE id = ?",
+			newEmail, userID,
+		); err != nil {
+			if db.IsDuplicate(err) {
+				return errEmailInUse
+			}
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM email_changes WHERE user_id = ?", userID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("changing email: %w", err)
+	}
+
+	// This client keeps its session; the others' tokens were just revoked
+	sessionID, _ := c.Locals("session-id").(string)
+	if sessionID == "" {
+		if sessionID, err = h.createSession(ctx, userID, "", clientOf(c)); err != nil {
+			return err
+		}
+	}
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ? AND id <> ?", userID, sessionID); err != nil {
+		return fmt.Errorf("ending other sessions: %w", err)
+	}
+	h.logEvent(ctx, audit.EventEmailChanged, userID, clientOf(c), map[string]string{"old_email": oldEmail, "new_email": newEmail})
+	h.notifyEmailChanged(oldEmail, newEmail)
+
+	signedToken, err := h.issueToken(userID, tokenVersion+1, sessionID)
+	if err != nil {
+		return fmt.Errorf("signing token: %w", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"token": signedToken,
+	})
+}
+
+// notifyEmailChanged tells the previous address that the account moved, so
+// an unexpected change doesn't go unnoticed. A failure is only logged: the
+// change has already been made.
+func (h *Handler) notifyEmailChanged(oldEmail, newEmail string) {
+	body := fmt.Sprintf("The email for your account was changed to %s. You'll no longer receive "+
+		"email at this address.\n\nIf you didn't make this change, contact support right away.\n", newEmail)
+	if err := h.mailer.Send(oldEmail, "Your email address was changed", body); err != nil {
+		log.Printf("Error emailing %s about an email change: %v", oldEmail, err)
+	}
+}
+
+// newToken returns a random URL-safe token and the hash to store
+func newToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex SHA-256 of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
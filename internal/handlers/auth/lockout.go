@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"quanta/internal/audit"
+)
+
+const (
+	// MaxFailedLogins is how many consecutive wrong passwords lock an account
+	MaxFailedLogins = 5
+	// BaseLockout is how long the first lock lasts. Every further failure
+	// doubles it, up to MaxLockout.
+	BaseLockout = time.Minute
+	// MaxLockout caps the lock window
+	MaxLockout = 24 * time.Hour
+)
+
+// lockoutWindow returns how long an account stays locked after the given
+// number of consecutive failures, or zero if it should not be locked
+func lockoutWindow(failures int) time.Duration {
+	if failures < MaxFailedLogins {
+		return 0
+	}
+	window := BaseLockout
+	for i := MaxFailedLogins; i < failures; i++ {
+		window *= 2
+		if window >= MaxLockout {
+			return MaxLockout
+		}
+	}
+	return window
+}
+
+// recordFailedLogin stores the new failure count and locks the account once
+// it reaches MaxFailedLogins. It returns the lock expiry, if any.
+func (h *Handler) recordFailedLogin(ctx context.Context, userID string, failures int, client Client) (time.Time, error) {
+	var lockedUntil *time.Time
+	if window := lockoutWindow(failures); window > 0 {
+		until := time.Now().Add(window)
+		lockedUntil = &until
+	}
+
+	if _, err := h.db.ExecContext(ctx,
+		"UPDATE users SET failed_logins = ?, locked_until = ? WHERE id = ?",
+		failures, lockedUntil, userID,
+	); err != nil {
+		return time.Time{}, err
+	}
+
+	if lockedUntil == nil {
+		return time.Time{}, nil
+	}
+	h.logEvent(ctx, audit.EventAccountLocked, userID, client, map[string]string{
+		"failures": strconv.Itoa(failures),
+		"until":    lockedUntil.UTC().Format(time.RFC3339),
+	})
+	return *lockedUntil, nil
+}
+
+// UnlockAccount clears a user's failed login count and any active lock. It
+// is meant for administrators, who record it in the audit log; a successful
+// login resets the count itself.
+func UnlockAccount(ctx context.Context, db DBInterface, userID string) error {
+	return resetFailedLogins(ctx, db, userID)
+}
+
+func resetFailedLogins(ctx context.Context, db DBInterface, userID string) error {
+	_, err := db.ExecContext(ctx, "UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?", userID)
+	return err
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// TokenTTL is how long an issued token, and so its session, stays valid
+const TokenTTL = 72 * time.Hour
+
+// Client describes where a login came from, for the session list
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// clientOf returns the client making a request
+func clientOf(c *fiber.Ctx) Client {
+	return Client{IP: c.IP(), UserAgent: c.Get(fiber.HeaderUserAgent)}
+}
+
+// ActiveSession is a login listed by ListSessions. Current marks the
+// session of the token the list was requested with.
+type ActiveSession struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// createSession records a login and returns the session ID to issue the
+// token under. The user's expired sessions are pruned on the way.
+func (h *Handler) createSession(ctx context.Context, userID, device string, client Client) (string, error) {
+	now := time.Now().UTC()
+	if _, err := h.db.ExecContext(ctx,
+		"DELETE FROM sessions WHERE user_id = ? AND created_at < ?",
+		userID, now.Add(-TokenTTL),
+	); err != nil {
+		return "", fmt.Errorf("pruning sessions: %w", err)
+	}
+
+	sessionID := uuid.New().String()
+	if _, err := h.db.ExecContext(ctx,
+		"INSERT INTO sessions (id, user_id, device, ip, user_agent, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		sessionID, userID, device, client.IP, pkg.Truncate(client.UserAgent, 512), now, now,
+	); err != nil {
+		return "", fmt.Errorf("creating session: %w", err)
+	}
+	return sessionID, nil
+}
+
+// startSession creates a session and issues its token
+func (h *Handler) startSession(ctx context.Context, userID string, tokenVersion int, device string, client Client) (string, error) {
+	sessionID, err := h.createSession(ctx, userID, device, client)
+	if err != nil {
+		return "", err
+	}
+	signedToken, err := h.issueToken(userID, tokenVersion, sessionID)
+	if err != nil {
+		return "", fmt.Errorf("signing token: %w", err)
+	}
+	return signedToken, nil
+}
+
+// ListSessions returns the user's logins that haven't expired or been
+// ended, most recently used first
+func (h *Handler) ListSessions(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	current, _ := c.Locals("session-id").(string)
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT id, device, ip, user_agent, created_at, last_seen_at FROM sessions WHERE user_id = ? AND created_at >= ? ORDER BY last_seen_at DESC",
+		userID, time.Now().UTC().Add(-TokenTTL),
+	)
+	if err != nil {
+		return fmt.Errorf("fetching sessions: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	sessions := []ActiveSession{}
+	for rows.Next() {
+		var s ActiveSession
+		if err := rows.Scan(&s.ID, &s.Device, &s.IP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt); err != nil {
+			return fmt.Errorf("scanning session: %w", err)
+		}
+		s.Current = s.ID == current
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching sessions: %w", err)
+	}
+
+	return c.JSON(sessions)
+}
+
+// DeleteSession ends one of the user's sessions. Its token is refused from
+// the next request on, so this logs another device out, or this one when
+// the current session is given.
+func (h *Handler) DeleteSession(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.db.ExecContext(c.UserContext(), "DELETE FROM sessions WHERE id = ? AND user_id = ?", c.Params("id"), userID)
+	if err != nil {
+		return fmt.Errorf("deleting session: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return apperr.New(fiber.StatusNotFound, "Session not found")
+	}
+	h.logEvent(c.UserContext(), audit.EventSessionRevoked, userID, clientOf(c), map[string]string{"session_id": c.Params("id")})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
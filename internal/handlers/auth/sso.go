@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/db"
+	"quanta/internal/oidc"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// SSOLoginTTL is how long a user has to sign in at the identity provider
+// before the login has to be started over
+const SSOLoginTTL = 10 * time.Minute
+
+// ssoStateCookie holds the state of the single sign-on the browser
+// started, so a callback can't be replayed into another browser
+const ssoStateCookie = "quanta_oidc_state"
+
+// SSOProvider is the OpenID Connect identity provider users sign in with.
+// *oidc.Provider implements it.
+type SSOProvider interface {
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	Exchange(ctx context.Context, code, verifier, nonce string) (oidc.Identity, error)
+}
+
+// SSOConfig controls single sign-on. The zero value turns it off.
+type SSOConfig struct {
+	// Provider is the identity provider, or nil when single sign-on is off
+	Provider SSOProvider
+	// BaseURL is where the web app is served. Users land on BaseURL plus
+	// the path they asked for after signing in.
+	BaseURL string
+	// Enforced turns password login and signup off, so single sign-on is
+	// the only way in
+	Enforced bool
+	// JITProvisioning creates an account the first time someone signs in
+	// whose email matches none. Otherwise they are turned away.
+	JITProvisioning bool
+	// EmailClaim, NameClaim and GroupsClaim name the ID token claims read
+	// for the email address, display name and groups. They default to
+	// email, name and groups.
+	EmailClaim  string
+	NameClaim   string
+	GroupsClaim string
+	// AssumeEmailVerified takes the email of an ID token with no
+	// email_verified claim as verified. Only turn it on for a provider that
+	// never issues addresses its users don't own, since the email is what
+	// links an identity to an existing account.
+	AssumeEmailVerified bool
+	// Groups lists the workspaces members of each provider group join when
+	// they sign in
+	Groups []oidc.GroupMapping
+}
+
+var (
+	errSSODisabled           = apperr.New(fiber.StatusNotImplemented, "Single sign-on is not enabled")
+	errPasswordLoginDisabled = apperr.New(fiber.StatusForbidden, "Password login is disabled, sign in with single sign-on")
+	errSSOInvalid            = apperr.New(fiber.StatusBadRequest, "Single sign-on request is invalid or has expired")
+	errSSOFailed             = apperr.New(fiber.StatusUnauthorized, "Single sign-on failed")
+	errSSONoEmail            = apperr.New(fiber.StatusForbidden, "The identity provider did not share a verified email address")
+	errSSONoAccount          = apperr.New(fiber.StatusForbidden, "No account is linked to this identity")
+)
+
+// ssoLogin is a single sign-on waiting for the user to come back from the
+// identity provider
+type ssoLogin struct {
+	nonce      string
+	verifier   string
+	redirectTo string
+}
+
+// SSOLogin sends the browser to the identity provider to sign in.
+// ?redirect_to=, a path in the web app, is where SSOCallback sends it
+// afterwards.
+func (h *Handler) SSOLogin(c *fiber.Ctx) error {
+	if h.sso.Provider == nil {
+		return errSSODisabled
+	}
+	redirectTo := c.Query("redirect_to", "/")
+	if !strings.HasPrefix(redirectTo, "/") || strings.HasPrefix(redirectTo, "//") || strings.ContainsAny(redirectTo, "#\\") ||
+		strings.ContainsFunc(redirectTo, unicode.IsControl) || len(redirectTo) > 2048 {
+		return apperr.New(fiber.StatusBadRequest, "redirect_to must be a path in the web app")
+	}
+
+	state, stateHash, err := newToken()
+	if err != nil {
+		return fmt.Errorf("generating state: %w", err)
+	}
+	nonce, _, err := newToken()
+	if err != nil {
+		return fmt.Errorf("generating nonce: %w", err)
+	}
+	verifier, _, err := newToken()
+	if err != nil {
+		return fmt.Errorf("generating code verifier: %w", err)
+	}
+
+	ctx := c.UserContext()
+	now := time.Now().UTC()
+	if _, err := h.db.ExecContext(ctx, "DELETE FROM oidc_logins WHERE expires_at < ?", now); err != nil {
+		return fmt.Errorf("pruning single sign-ons: %w", err)
+	}
+	if _, err := h.db.ExecContext(ctx,
+		"INSERT INTO oidc_logins (state_hash, nonce, code_verifier, redirect_to, expires_at) VALUES (?, ?, ?, ?, ?)",
+		stateHash, nonce, verifier, redirectTo, now.Add(SSOLoginTTL),
+	); err != nil {
+		return fmt.Errorf("recording single sign-on: %w", err)
+	}
+
+	authURL, err := h.sso.Provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return fmt.Errorf("building authorization URL: %w", err)
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(SSOLoginTTL.Seconds()),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// SSOCallback completes a single sign-on when the identity provider sends
+// the browser back. The identity is matched to an account as ssoUser
+// describes and the browser is sent on to the web app with the new
+// session's token in the URL fragment, which never reaches a server.
+func (h *Handler) SSOCallback(c *fiber.Ctx) error {
+	if h.sso.Provider == nil {
+		return errSSODisabled
+	}
+	ctx := c.UserContext()
+	client := clientOf(c)
+
+	state := c.Query("state")
+	cookie := c.Cookies(ssoStateCookie)
+	c.Cookie(&fiber.Cookie{Name: ssoStateCookie, Path: "/auth/oidc", MaxAge: -1, HTTPOnly: true})
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		return errSSOInvalid
+	}
+	login, err := h.takeSSOLogin(ctx, state)
+	if err != nil {
+		return err
+	}
+
+	if reason := c.Query("error"); reason != "" {
+		h.logEvent(ctx, audit.EventLoginFailed, "", client, map[string]string{"method": "oidc", "reason": reason})
+		return errSSOFailed
+	}
+	identity, err := h.sso.Provider.Exchange(ctx, c.Query("code"), login.verifier, login.nonce)
+	if err != nil {
+		log.Printf("Error completing single sign-on: %v", err)
+		h.logEvent(ctx, audit.EventLoginFailed, "", client, map[string]string{"method": "oidc", "reason": "invalid_token"})
+		return errSSOFailed
+	}
+
+	user, err := h.ssoUser(ctx, identity)
+	if err != nil {
+		if errors.Is(err, errSSONoEmail) || errors.Is(err, errSSONoAccount) {
+			h.logEvent(ctx, audit.EventLoginFailed, "", client, map[string]string{"method": "oidc", "reason": "no_account", "subject": identity.Subject})
+		}
+		return err
+	}
+	method := map[string]string{"method": "oidc"}
+	switch {
+	case user.created:
+		h.logEvent(ctx, audit.EventSignup, user.id, client, method)
+	case user.linked:
+		h.logEvent(ctx, audit.EventIdentityLinked, user.id, client, map[string]string{"issuer": identity.Issuer, "subject": identity.Subject})
+	}
+	for _, workspaceID := range user.joined {
+		h.logEvent(ctx, audit.EventMemberJoined, user.id, client, map[string]string{"workspace_id": workspaceID, "method": "oidc"})
+	}
+
+	signedToken, err := h.startSession(ctx, user.id, user.tokenVersion, "", client)
+	if err != nil {
+		return err
+	}
+	h.logEvent(ctx, audit.EventLogin, user.id, client, method)
+
+	return c.Redirect(h.sso.BaseURL+login.redirectTo+"#token="+url.QueryEscape(signedToken), fiber.StatusFound)
+}
+
+// takeSSOLogin looks up the single sign-on started with state and deletes
+// it, so each state is used once
+func (h *Handler) takeSSOLogin(ctx context.Context, state string) (ssoLogin, error) {
+	stateHash := hashToken(state)
+	var login ssoLogin
+	var expiresAt time.Time
+	err := h.db.QueryRowContext(ctx,
+		"SELECT nonce, code_verifier, redirect_to, expires_at FROM oidc_logins WHERE state_hash = ?",
+		stateHash,
+	).Scan(&login.nonce, &login.verifier, &login.redirectTo, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ssoLogin{}, errSSOInvalid
+	} else if err != nil {
+		return ssoLogin{}, fmt.Errorf("fetching single sign-on: %w", err)
+	}
+
+	// Two callbacks racing with the same state can both read the row but
+	// only one deletes it
+	result, err := h.db.ExecContext(ctx, "DELETE FROM oidc_logins WHERE state_hash = ?", stateHash)
+	if err != nil {
+		return ssoLogin{}, fmt.Errorf("deleting single sign-on: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 || !expiresAt.After(time.Now()) {
+		return ssoLogin{}, errSSOInvalid
+	}
+	return login, nil
+}
+
+// ssoAccount is the account a single sign-on logs into
+type ssoAccount struct {
+	id           string
+	tokenVersion int
+	// created is set when the account was provisioned by this sign-in and
+	// linked when the identity was linked to an existing account
+	created bool
+	linked  bool
+	// joined lists the workspaces the user joined through their groups
+	joined []string
+}
+
+// ssoUser finds the account identity signs in as. An identity seen before
+// keeps its account; the first time, it is linked to the account with the
+// same email, as long as the provider verified it, or one is provisioned
+// when JITProvisioning is on. The user then joins the workspaces mapped
+// from their groups they aren't a member of yet.
+func (h *Handler) ssoUser(ctx context.Context, identity oidc.Identity) (ssoAccount, error) {
+	var user ssoAccount
+	err := db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		var deleted bool
+		err := tx.QueryRowContext(ctx,
+			"SELECT users.id, users.token_version, users.deleted_at IS NOT NULL FROM user_identities JOIN users ON users.id = user_identities.user_id WHERE user_identities.issuer = ? AND user_identities.subject = ?",
+			identity.Issuer, identity.Subject,
+		).Scan(&user.id, &user.tokenVersion, &deleted)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			if err := h.linkIdentity(ctx, tx, identity, &user); err != nil {
+				return err
+			}
+		case err != nil:
+			return fmt.Errorf("looking up identity: %w", err)
+		case deleted:
+			return errSSONoAccount
+		}
+
+		user.joined, err = h.joinMappedWorkspaces(ctx, tx, user.id, identity.Strings(h.sso.GroupsClaim))
+		return err
+	})
+	if db.IsDuplicate(err) {
+		// The email belongs to a deleted account, or a concurrent sign-in
+		// linked the identity first
+		return ssoAccount{}, errEmailInUse
+	}
+	return user, err
+}
+
+// linkIdentity links identity to the account with its email, or to a new
+// account when JITProvisioning allows
+func (h *Handler) linkIdentity(ctx context.Context, tx *sql.Tx, identity oidc.Identity, user *ssoAccount) error {
+	email := strings.ToLower(strings.TrimSpace(identity.String(h.sso.EmailClaim)))
+	_, claimed := identity.Claims["email_verified"]
+	verified := identity.EmailVerified() || (!claimed && h.sso.AssumeEmailVerified)
+	if email == "" || !verified {
+		return errSSONoEmail
+	}
+
+	err := tx.QueryRowContext(ctx,
+		"SELECT id, token_version FROM users WHERE email = ? AND deleted_at IS NULL",
+		email,
+	).Scan(&user.id, &user.tokenVersion)
+	switch {
+	case err == nil:
+		user.linked = true
+	case !errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("looking up user: %w", err)
+	case !h.sso.JITProvisioning:
+		return errSSONoAccount
+	default:
+		if err := h.provisionUser(ctx, tx, identity, email, user); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)",
+		identity.Issuer, identity.Subject, user.id,
+	); err != nil {
+		return fmt.Errorf("linking identity: %w", err)
+	}
+	return nil
+}
+
+// provisionUser creates the account of someone signing in for the first
+// time. Its password is random and never told to anyone, so the account
+// can only be used through single sign-on until the user sets one.
+func (h *Handler) provisionUser(ctx context.Context, tx *sql.Tx, identity oidc.Identity, email string, user *ssoAccount) error {
+	password, _, err := newToken()
+	if err != nil {
+		return fmt.Errorf("generating password: %w", err)
+	}
+	hashedPw, err := pkg.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+
+	var displayName sql.NullString
+	if name := strings.TrimSpace(identity.String(h.sso.NameClaim)); name != "" {
+		displayName = sql.NullString{String: pkg.Truncate(name, 100), Valid: true}
+	}
+
+	*user = ssoAccount{id: uuid.New().String(), created: true}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO users (id, email, password, password_version, display_name) VALUES (?, ?, ?, ?, ?)",
+		user.id, email, hashedPw, pkg.PasswordVersion(), displayName,
+	); err != nil {
+		return fmt.Errorf("inserting user: %w", err)
+	}
+	return nil
+}
+
+// joinMappedWorkspaces adds the user to the workspaces mapped from groups,
+// returning those they joined. Existing memberships are left alone, so
+// roles changed in the app stick, and leaving a group doesn't remove
+// anyone: that is up to the workspace's owner.
+func (h *Handler) joinMappedWorkspaces(ctx context.Context, tx *sql.Tx, userID string, groups []string) ([]string, error) {
+	var joined []string
+	for _, m := range h.sso.Groups {
+		if !slices.Contains(groups, m.Group) || slices.Contains(joined, m.WorkspaceID) {
+			continue
+		}
+		result, err := tx.ExecContext(ctx,
+			"INSERT INTO workspace_members (workspace_id, user_id, role) SELECT id, ?, ? FROM workspaces WHERE id = ? AND NOT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user_id = ?)",
+			userID, m.Role, m.WorkspaceID, m.WorkspaceID, userID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("adding member: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			joined = append(joined, m.WorkspaceID)
+		}
+	}
+	return joined, nil
+}
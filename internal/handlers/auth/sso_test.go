@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/audit"
+	"quanta/internal/oidc"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSSO is an identity provider that signs everyone in as identity
+type fakeSSO struct {
+	identity oidc.Identity
+	err      error
+}
+
+func (f *fakeSSO) AuthCodeURL(_ context.Context, state, _, _ string) (string, error) {
+	return "https://idp.example.com/authorize?state=" + state, nil
+}
+
+func (f *fakeSSO) Exchange(_ context.Context, code, verifier, nonce string) (oidc.Identity, error) {
+	if f.err != nil {
+		return oidc.Identity{}, f.err
+	}
+	if code != "the-code" || verifier != "the-verifier" || nonce != "the-nonce" {
+		return oidc.Identity{}, errors.New("unexpected exchange")
+	}
+	return f.identity, nil
+}
+
+// withSSO turns single sign-on on for the helper's handler
+func (h *testHelper) withSSO(provider SSOProvider, jit bool) {
+	h.handler.sso = SSOConfig{
+		Provider:        provider,
+		BaseURL:         "https://notes.example.com",
+		JITProvisioning: jit,
+		EmailClaim:      "email",
+		NameClaim:       "name",
+		GroupsClaim:     "groups",
+		Groups:          []oidc.GroupMapping{{Group: "eng", WorkspaceID: "ws1", Role: "member"}},
+	}
+}
+
+func TestSSOLogin(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.setupRoute("GET", "/auth/oidc/login", helper.handler.SSOLogin)
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/auth/oidc/login", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNotImplemented, resp.StatusCode)
+
+	helper.withSSO(&fakeSSO{}, true)
+	resp, err = helper.app.Test(httptest.NewRequest("GET", "/auth/oidc/login?redirect_to=//evil.example.com", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM oidc_logins WHERE expires_at < ?")).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO oidc_logins (state_hash, nonce, code_verifier, redirect_to, expires_at) VALUES (?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "/notes/note1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	resp, err = helper.app.Test(httptest.NewRequest("GET", "/auth/oidc/login?redirect_to=/notes/note1", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("error parsing location: %v", err)
+	}
+	assert.Equal(t, "idp.example.com", location.Host)
+	state := location.Query().Get("state")
+	assert.NotEmpty(t, state)
+	cookie := resp.Header.Get("Set-Cookie")
+	assert.Contains(t, cookie, ssoStateCookie+"="+state)
+	assert.Contains(t, cookie, "HttpOnly")
+	assert.Contains(t, cookie, "path=/auth/oidc")
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSSOCallback(t *testing.T) {
+	loginQuery := regexp.QuoteMeta("SELECT nonce, code_verifier, redirect_to, expires_at FROM oidc_logins WHERE state_hash = ?")
+	deleteLogin := regexp.QuoteMeta("DELETE FROM oidc_logins WHERE state_hash = ?")
+	identityQuery := regexp.QuoteMeta("SELECT users.id, users.token_version, users.deleted_at IS NOT NULL FROM user_identities JOIN users ON users.id = user_identities.user_id WHERE user_identities.issuer = ? AND user_identities.subject = ?")
+	emailQuery := regexp.QuoteMeta("SELECT id, token_version FROM users WHERE email = ? AND deleted_at IS NULL")
+	insertUser := regexp.QuoteMeta("INSERT INTO users (id, email, password, password_version, display_name) VALUES (?, ?, ?, ?, ?)")
+	insertIdentity := regexp.QuoteMeta("INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)")
+	joinWorkspace := regexp.QuoteMeta("INSERT INTO workspace_members (workspace_id, user_id, role) SELECT id, ?, ? FROM workspaces WHERE id = ? AND NOT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user_id = ?)")
+	issuer := "https://idp.example.com"
+	identity := oidc.Identity{Issuer: issuer, Subject: "00u1", Claims: map[string]any{
+		"email":          "Ada@Example.com",
+		"email_verified": true,
+		"name":           "Ada Lovelace",
+		"groups":         []any{"eng"},
+	}}
+	unverified := oidc.Identity{Issuer: issuer, Subject: "00u1", Claims: map[string]any{"email": "ada@example.com"}}
+	identityColumns := []string{"id", "token_version", "deleted"}
+
+	testCases := []struct {
+		name           string
+		query          string
+		cookie         string
+		expiresIn      time.Duration
+		identity       oidc.Identity
+		exchangeErr    error
+		jit            bool
+		assumeVerified bool
+		setup          func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedUser   any
+		expectedEvents []audit.Event
+	}{
+		{
+			name:     "Known Identity",
+			identity: identity,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(identityQuery).WithArgs(issuer, "00u1").
+					WillReturnRows(sqlmock.NewRows(identityColumns).AddRow("user1", 2, false))
+				mock.ExpectExec(joinWorkspace).WithArgs("user1", "member", "ws1", "ws1", "user1").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusFound,
+			expectedUser:   "user1",
+			expectedEvents: []audit.Event{audit.EventLogin},
+		},
+		{
+			name:     "Linked By Email",
+			identity: identity,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(identityQuery).WithArgs(issuer, "00u1").WillReturnRows(sqlmock.NewRows(identityColumns))
+				mock.ExpectQuery(emailQuery).WithArgs("ada@example.com").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "token_version"}).AddRow("user1", 0))
+				mock.ExpectExec(insertIdentity).WithArgs(issuer, "00u1", "user1").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(joinWorkspace).WithArgs("user1", "member", "ws1", "ws1", "user1").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusFound,
+			expectedUser:   "user1",
+			expectedEvents: []audit.Event{audit.EventIdentityLinked, audit.EventMemberJoined, audit.EventLogin},
+		},
+		{
+			name:     "Provisioned Just In Time",
+			identity: identity,
+			jit:      true,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(identityQuery).WithArgs(issuer, "00u1").WillReturnRows(sqlmock.NewRows(identityColumns))
+				mock.ExpectQuery(emailQuery).WithArgs("ada@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "token_version"}))
+				mock.ExpectExec(insertUser).
+					WithArgs(sqlmock.AnyArg(), "ada@example.com", sqlmock.AnyArg(), sqlmock.AnyArg(), "Ada Lovelace").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(insertIdentity).WithArgs(issuer, "00u1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(joinWorkspace).WithArgs(sqlmock.AnyArg(), "member", "ws1", "ws1", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusFound,
+			expectedUser:   sqlmock.AnyArg(),
+			expectedEvents: []audit.Event{audit.EventSignup, audit.EventMemberJoined, audit.EventLogin},
+		},
+		{
+			name:     "No Account Without Provisioning",
+			identity: identity,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(identityQuery).WithArgs(issuer, "00u1").WillReturnRows(sqlmock.NewRows(identityColumns))
+				mock.ExpectQuery(emailQuery).WithArgs("ada@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "token_version"}))
+				mock.ExpectRollback()
+			},
+			expectedStatus: fiber.StatusForbidden,
+			expectedEvents: []audit.Event{audit.EventLoginFailed},
+		},
+		{
+			name: "Unverified Email",
+			identity: oidc.Identity{Issuer: issuer, Subject: "00u1", Claims: map[string]any{
+				"email": "ada@example.com", "email_verified": false,
+			}},
+			jit: true,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(identityQuery).WithArgs(issuer, "00u1").WillReturnRows(sqlmock.NewRows(identityColumns))
+				mock.ExpectRollback()
+			},
+			expectedStatus: fiber.StatusForbidden,
+			expectedEvents: []audit.Event{audit.EventLoginFailed},
+		},
+		{
+			name:     "Email Verification Missing",
+			identity: unverified,
+			jit:      true,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(identityQuery).WithArgs(issuer, "00u1").WillReturnRows(sqlmock.NewRows(identityColumns))
+				mock.ExpectRollback()
+			},
+			expectedStatus: fiber.StatusForbidden,
+			expectedEvents: []audit.Event{audit.EventLoginFailed},
+		},
+		{
+			name:           "Email Verification Assumed",
+			identity:       unverified,
+			assumeVerified: true,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(identityQuery).WithArgs(issuer, "00u1").WillReturnRows(sqlmock.NewRows(identityColumns))
+				mock.ExpectQuery(emailQuery).WithArgs("ada@example.com").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "token_version"}).AddRow("user1", 0))
+				mock.ExpectExec(insertIdentity).WithArgs(issuer, "00u1", "user1").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusFound,
+			expectedUser:   "user1",
+			expectedEvents: []audit.Event{audit.EventIdentityLinked, audit.EventLogin},
+		},
+		{
+			name:     "Deleted Account",
+			identity: identity,
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(identityQuery).WithArgs(issuer, "00u1").
+					WillReturnRows(sqlmock.NewRows(identityColumns).AddRow("user1", 0, true))
+				mock.ExpectRollback()
+			},
+			expectedStatus: fiber.StatusForbidden,
+			expectedEvents: []audit.Event{audit.EventLoginFailed},
+		},
+		{
+			name:           "Provider Refused",
+			query:          "?state=the-state&error=access_denied",
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedEvents: []audit.Event{audit.EventLoginFailed},
+		},
+		{
+			name:           "Invalid Token",
+			exchangeErr:    errors.New("verifying ID token: token is expired"),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedEvents: []audit.Event{audit.EventLoginFailed},
+		},
+		{
+			name:           "Expired",
+			expiresIn:      -time.Minute,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Started In Another Browser",
+			cookie:         "other-state",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("GET", "/auth/oidc/callback", helper.handler.SSOCallback)
+			helper.withSSO(&fakeSSO{identity: tc.identity, err: tc.exchangeErr}, tc.jit)
+			helper.handler.sso.AssumeEmailVerified = tc.assumeVerified
+
+			query := cmp.Or(tc.query, "?state=the-state&code=the-code")
+			cookie := cmp.Or(tc.cookie, "the-state")
+			if cookie == "the-state" {
+				expiresIn := tc.expiresIn
+				if expiresIn == 0 {
+					expiresIn = SSOLoginTTL
+				}
+				helper.mockDB.ExpectQuery(loginQuery).WithArgs(hashToken("the-state")).
+					WillReturnRows(sqlmock.NewRows([]string{"nonce", "code_verifier", "redirect_to", "expires_at"}).
+						AddRow("the-nonce", "the-verifier", "/notes/note1", time.Now().Add(expiresIn)))
+				helper.mockDB.ExpectExec(deleteLogin).WithArgs(hashToken("the-state")).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			if tc.setup != nil {
+				tc.setup(helper.mockDB)
+			}
+			if tc.expectedUser != nil {
+				helper.expectSession(tc.expectedUser)
+			}
+
+			req := httptest.NewRequest("GET", "/auth/oidc/callback"+query, nil)
+			req.Header.Set("Cookie", ssoStateCookie+"="+cookie)
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusFound {
+				location := resp.Header.Get("Location")
+				assert.True(t, strings.HasPrefix(location, "https://notes.example.com/notes/note1#token="), location)
+			}
+			assert.Contains(t, resp.Header.Get("Set-Cookie"), ssoStateCookie+"=;")
+			assert.Equal(t, tc.expectedEvents, helper.audit.events())
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestPasswordLoginDisabled(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.setupRoute("POST", "/login", helper.handler.Login)
+	helper.setupRoute("POST", "/signup", helper.handler.SignUp)
+	helper.withSSO(&fakeSSO{}, true)
+	helper.handler.sso.Enforced = true
+
+	for _, path := range []string{"/login", "/signup"} {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"email":"ada@example.com","password":"password123"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := helper.app.Test(req)
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode, path)
+	}
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+// Package health contains the liveness, readiness and startup probe handlers
+package health
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"quanta/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultCheckTimeout bounds each dependency check when no timeout is set
+const DefaultCheckTimeout = 2 * time.Second
+
+// Probe and check states
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+	StatusStarting    = "starting"
+	StatusFail        = "fail"
+	StatusSkipped     = "skipped"
+)
+
+// DBInterface defines the database methods the probes need
+type DBInterface interface {
+	PingContext(ctx context.Context) error
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	Stats() sql.DBStats
+}
+
+// Check is a dependency the readiness probe verifies. Checks run in order,
+// and once one has made the server unavailable the rest are skipped.
+type Check struct {
+	Name string
+	// Critical checks always fail readiness. The others only mark the
+	// server degraded, unless the handler is strict.
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// Options configures a Handler. Zero values fall back to the defaults.
+type Options struct {
+	// Dialect is used to check that the schema is up to date
+	Dialect db.Dialect
+	// Strict fails readiness when any check fails, not only critical ones
+	Strict       bool
+	CheckTimeout time.Duration
+	// Checks are verified after the built-in database and schema checks
+	Checks []Check
+}
+
+// CheckResult is the outcome of one check. Failure reasons are logged
+// rather than returned, as the probes are served without authentication.
+type CheckResult struct {
+	Status     string `json:"status"`
+	Critical   bool   `json:"critical"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// PoolStats describes the database connection pool
+type PoolStats struct {
+	OpenConnections int   `json:"open_connections"`
+	InUse           int   `json:"in_use"`
+	Idle            int   `json:"idle"`
+	WaitCount       int64 `json:"wait_count"`
+}
+
+// Report is the body of the readiness and startup probes
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+	Pool   PoolStats              `json:"pool"`
+}
+
+// Handler serves the health endpoints
+type Handler struct {
+	db      DBInterface
+	checks  []Check
+	strict  bool
+	timeout time.Duration
+
+	// schemaCurrent is set once every table exists. Tables are never
+	// dropped, so the schema isn't listed again after that.
+	schemaCurrent atomic.Bool
+	// started is set the first time the server is ready
+	started atomic.Bool
+}
+
+// NewHandler creates a new Handler
+func NewHandler(conn DBInterface, opts Options) *Handler {
+	h := &Handler{db: conn, strict: opts.Strict, timeout: opts.CheckTimeout}
+	if h.timeout <= 0 {
+		h.timeout = DefaultCheckTimeout
+	}
+
+	h.checks = []Check{
+		{Name: "database", Critical: true, Run: func(ctx context.Context) error {
+			return h.db.PingContext(ctx)
+		}},
+		{Name: "schema", Run: func(ctx context.Context) error {
+			return h.checkSchema(ctx, opts.Dialect)
+		}},
+	}
+	h.checks = append(h.checks, opts.Checks...)
+	return h
+}
+
+// Liveness reports that the process is up. It never touches dependencies so
+// a database outage doesn't get the server restarted.
+func (h *Handler) Liveness(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": StatusOK})
+}
+
+// Readiness reports whether the server can take traffic, running every
+// check and including the database pool statistics. A failed critical
+// check, or any failed check when strict, answers 503 so the instance is
+// taken out of rotation; other failures report it degraded but ready.
+func (h *Handler) Readiness(c *fiber.Ctx) error {
+	report := h.run(c.UserContext())
+	if report.Status == StatusUnavailable {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(report)
+	}
+	h.started.Store(true)
+	return c.JSON(report)
+}
+
+// Startup reports whether the server has finished starting, which is the
+// first time it is ready. After that it always succeeds, leaving later
+// outages to the readiness probe rather than getting the server restarted.
+func (h *Handler) Startup(c *fiber.Ctx) error {
+	if h.started.Load() {
+		return c.JSON(fiber.Map{"status": StatusOK})
+	}
+
+	report := h.run(c.UserContext())
+	if report.Status == StatusUnavailable {
+		report.Status = StatusStarting
+		return c.Status(fiber.StatusServiceUnavailable).JSON(report)
+	}
+	h.started.Store(true)
+	return c.JSON(report)
+}
+
+// run runs the checks and summarizes them
+func (h *Handler) run(ctx context.Context) Report {
+	stats := h.db.Stats()
+	report := Report{
+		Status: StatusOK,
+		Checks: make(map[string]CheckResult, len(h.checks)),
+		Pool: PoolStats{
+			OpenConnections: stats.OpenConnections,
+			InUse:           stats.InUse,
+			Idle:            stats.Idle,
+			WaitCount:       stats.WaitCount,
+		},
+	}
+
+	for _, check := range h.checks {
+		result := CheckResult{Status: StatusOK, Critical: check.Critical}
+		if report.Status == StatusUnavailable {
+			result.Status = StatusSkipped
+			report.Checks[check.Name] = result
+			continue
+		}
+
+		start := time.Now()
+		checkCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		err := check.Run(checkCtx)
+		cancel()
+		result.DurationMS = time.Since(start).Milliseconds()
+
+		if err != nil {
+			log.Printf("Health check %s failed: %v", check.Name, err)
+			result.Status = StatusFail
+			if check.Critical || h.strict {
+				report.Status = StatusUnavailable
+			} else {
+				report.Status = StatusDegraded
+			}
+		}
+		report.Checks[check.Name] = result
+	}
+	return report
+}
+
+// checkSchema fails when tables in the schema are missing from the
+// database, meaning a schema change hasn't been applied
+func (h *Handler) checkSchema(ctx context.Context, dialect db.Dialect) error {
+	if h.schemaCurrent.Load() {
+		return nil
+	}
+
+	missing, err := db.MissingTables(ctx, h.db, dialect)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
+	h.schemaCurrent.Store(true)
+	return nil
+}
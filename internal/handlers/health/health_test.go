@@ -0,0 +1,191 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"quanta/internal/db"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var tablesQuery = regexp.QuoteMeta("SELECT name FROM sqlite_master WHERE type = 'table'")
+
+// allTables returns rows listing every table in the schema, less skip
+func allTables(skip string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"name"})
+	for _, table := range db.SQLite.Tables() {
+		if table != skip {
+			rows.AddRow(table)
+		}
+	}
+	return rows
+}
+
+func TestLiveness(t *testing.T) {
+	app := fiber.New()
+	app.Get("/healthz", NewHandler(nil, Options{}).Liveness)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/healthz", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+}
+
+func TestReadiness(t *testing.T) {
+	testCases := []struct {
+		name           string
+		strict         bool
+		pingErr        error
+		missingTable   string
+		extraErr       error
+		expectedStatus int
+		expectedState  string
+		expectedChecks map[string]string
+	}{
+		{
+			name:           "All Checks Pass",
+			expectedStatus: fiber.StatusOK,
+			expectedState:  StatusOK,
+			expectedChecks: map[string]string{"database": StatusOK, "schema": StatusOK, "cache": StatusOK},
+		},
+		{
+			name:           "Database Unreachable",
+			pingErr:        errors.New("connection refused"),
+			expectedStatus: fiber.StatusServiceUnavailable,
+			expectedState:  StatusUnavailable,
+			expectedChecks: map[string]string{"database": StatusFail, "schema": StatusSkipped, "cache": StatusSkipped},
+		},
+		{
+			name:           "Schema Behind",
+			missingTable:   "sessions",
+			expectedStatus: fiber.StatusOK,
+			expectedState:  StatusDegraded,
+			expectedChecks: map[string]string{"database": StatusOK, "schema": StatusFail, "cache": StatusOK},
+		},
+		{
+			name:           "Schema Behind When Strict",
+			strict:         true,
+			missingTable:   "sessions",
+			expectedStatus: fiber.StatusServiceUnavailable,
+			expectedState:  StatusUnavailable,
+			expectedChecks: map[string]string{"database": StatusOK, "schema": StatusFail, "cache": StatusSkipped},
+		},
+		{
+			name:           "Extra Check Fails",
+			extraErr:       errors.New("cache down"),
+			expectedStatus: fiber.StatusOK,
+			expectedState:  StatusDegraded,
+			expectedChecks: map[string]string{"database": StatusOK, "schema": StatusOK, "cache": StatusFail},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, mockDB, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatalf("error opening stub database: %v", err)
+			}
+			mockDB.ExpectPing().WillReturnError(tc.pingErr)
+			if tc.pingErr == nil {
+				mockDB.ExpectQuery(tablesQuery).WillReturnRows(allTables(tc.missingTable))
+			}
+
+			handler := NewHandler(conn, Options{
+				Dialect: db.SQLite,
+				Strict:  tc.strict,
+				Checks: []Check{{Name: "cache", Run: func(context.Context) error {
+					return tc.extraErr
+				}}},
+			})
+			app := fiber.New()
+			app.Get("/readyz", handler.Readiness)
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/readyz", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			var report Report
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+			assert.Equal(t, tc.expectedState, report.Status)
+			checks := map[string]string{}
+			for name, result := range report.Checks {
+				checks[name] = result.Status
+			}
+			assert.Equal(t, tc.expectedChecks, checks)
+			assert.True(t, report.Checks["database"].Critical)
+
+			if err := mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestReadiness_SchemaCheckedUntilCurrent(t *testing.T) {
+	conn, mockDB, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	app := fiber.New()
+	app.Get("/readyz", NewHandler(conn, Options{Dialect: db.SQLite}).Readiness)
+
+	// The schema is listed until it is found complete, then no more
+	mockDB.ExpectPing()
+	mockDB.ExpectQuery(tablesQuery).WillReturnRows(allTables("sessions"))
+	mockDB.ExpectPing()
+	mockDB.ExpectQuery(tablesQuery).WillReturnRows(allTables(""))
+	mockDB.ExpectPing()
+
+	for range 3 {
+		_, err := app.Test(httptest.NewRequest("GET", "/readyz", nil))
+		assert.NoError(t, err)
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStartup(t *testing.T) {
+	conn, mockDB, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	app := fiber.New()
+	app.Get("/startupz", NewHandler(conn, Options{Dialect: db.SQLite}).Startup)
+
+	startup := func() (int, string) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/startupz", nil))
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		var report Report
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return resp.StatusCode, report.Status
+	}
+
+	mockDB.ExpectPing().WillReturnError(errors.New("connection refused"))
+	code, status := startup()
+	assert.Equal(t, fiber.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusStarting, status)
+
+	mockDB.ExpectPing()
+	mockDB.ExpectQuery(tablesQuery).WillReturnRows(allTables(""))
+	code, status = startup()
+	assert.Equal(t, fiber.StatusOK, code)
+	assert.Equal(t, StatusOK, status)
+
+	// Once started, later outages are left to the readiness probe
+	code, _ = startup()
+	assert.Equal(t, fiber.StatusOK, code)
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Package integrations provides handlers for the API keys automation
+// platforms such as Zapier and IFTTT authenticate with, and for the catalog
+// of triggers they can poll
+package integrations
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/models"
+	"quanta/internal/validate"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// MaxKeysPerUser is how many API keys a user can hold at once
+const MaxKeysPerUser = 20
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// APIKey describes an API key without the key itself
+type APIKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IssuedAPIKey is the response to CreateAPIKey. Key is only ever shown here.
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyPayload is the request body for CreateAPIKey
+type APIKeyPayload struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes"`
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Handler handles HTTP requests related to integrations
+type Handler struct {
+	db    DBInterface
+	audit AuditLogger
+}
+
+// NewHandler creates a new Handler with the provided database interface
+// and the audit log that records issued and revoked keys
+func NewHandler(db DBInterface, auditLog AuditLogger) *Handler {
+	return &Handler{db: db, audit: auditLog}
+}
+
+// CreateAPIKey issues an API key with the requested scopes for one
+// integration. Only a hash of the key is stored, so it can't be shown again.
+func (h *Handler) CreateAPIKey(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload APIKeyPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	errs := validate.Struct(&payload)
+	if len(payload.Scopes) == 0 {
+		errs = addError(errs, "scopes", "required")
+	}
+	for _, scope := range payload.Scopes {
+		if !slices.Contains(models.Scopes, scope) {
+			errs = addError(errs, "scopes", "must be among "+strings.Join(models.Scopes, ", "))
+		}
+	}
+	if errs != nil {
+		return apperr.Invalid(errs)
+	}
+	slices.Sort(payload.Scopes)
+	payload.Scopes = slices.Compact(payload.Scopes)
+
+	var count int
+	if err := h.db.QueryRowContext(c.UserContext(), "SELECT COUNT(*) FROM api_keys WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return fmt.Errorf("counting API keys: %w", err)
+	}
+	if count >= MaxKeysPerUser {
+		return apperr.Newf(fiber.StatusConflict, "You can have at most %d API keys", MaxKeysPerUser)
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return fmt.Errorf("generating API key: %w", err)
+	}
+	issued := IssuedAPIKey{
+		APIKey: APIKey{
+			ID:        uuid.New().String(),
+			Name:      payload.Name,
+			Scopes:    payload.Scopes,
+			CreatedAt: time.Now(),
+		},
+		Key: key,
+	}
+	_, err = h.db.ExecContext(c.UserContext(),
+		"INSERT INTO api_keys (id, user_id, name, key_hash, scopes) VALUES (?, ?, ?, ?, ?)",
+		issued.ID, userID, issued.Name, hashKey(key), strings.Join(issued.Scopes, ","),
+	)
+	if err != nil {
+		return fmt.Errorf("creating API key: %w", err)
+	}
+
+	entry := audit.FromRequest(c, audit.EventAPIKeyCreated, userID)
+	entry.Details = map[string]string{"key_id": issued.ID, "scopes": strings.Join(issued.Scopes, ",")}
+	h.audit.Log(c.UserContext(), entry)
+
+	return c.Status(fiber.StatusCreated).JSON(issued)
+}
+
+// ListAPIKeys returns the user's API keys, newest first
+func (h *Handler) ListAPIKeys(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT id, name, scopes, created_at FROM api_keys WHERE user_id = ? ORDER BY created_at DESC", userID)
+	if err != nil {
+		return fmt.Errorf("fetching API keys: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var scopes string
+		if err := rows.Scan(&k.ID, &k.Name, &scopes, &k.CreatedAt); err != nil {
+			return fmt.Errorf("scanning API key: %w", err)
+		}
+		k.Scopes = strings.Split(scopes, ",")
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching API keys: %w", err)
+	}
+
+	return c.JSON(keys)
+}
+
+// DeleteAPIKey revokes one of the user's API keys
+func (h *Handler) DeleteAPIKey(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.db.ExecContext(c.UserContext(), "DELETE FROM api_keys WHERE id = ? AND user_id = ?", c.Params("id"), userID)
+	if err != nil {
+		return fmt.Errorf("deleting API key: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return apperr.New(fiber.StatusNotFound, "API key not found")
+	}
+
+	entry := audit.FromRequest(c, audit.EventAPIKeyRevoked, userID)
+	entry.Details = map[string]string{"key_id": c.Params("id")}
+	h.audit.Log(c.UserContext(), entry)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// addError records a field problem, keeping the first one per field
+func addError(errs validate.Errors, field, msg string) validate.Errors {
+	if errs == nil {
+		errs = validate.Errors{}
+	}
+	if _, ok := errs[field]; !ok {
+		errs[field] = msg
+	}
+	return errs
+}
+
+// hashKey returns the hex SHA-256 of an API key, as stored in api_keys
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newKey returns a random API key
+func newKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return models.APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
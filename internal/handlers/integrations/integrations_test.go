@@ -0,0 +1,214 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+// testHelper contains common test setup and utilities
+type testHelper struct {
+	t      *testing.T
+	mockDB sqlmock.Sqlmock
+	app    *fiber.App
+	audit  *fakeAudit
+}
+
+// newTestHelper creates a new test helper with the integration routes mounted
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	auditLog := &fakeAudit{}
+	handler := NewHandler(db, auditLog)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/me/api-keys", handler.ListAPIKeys)
+	app.Post("/me/api-keys", handler.CreateAPIKey)
+	app.Delete("/me/api-keys/:id", handler.DeleteAPIKey)
+	app.Get("/integrations/triggers", handler.ListTriggers)
+
+	return &testHelper{t: t, mockDB: mockDB, app: app, audit: auditLog}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	countQuery := regexp.QuoteMeta("SELECT COUNT(*) FROM api_keys WHERE user_id = ?")
+
+	testCases := []struct {
+		name           string
+		body           string
+		checkCount     bool
+		count          int
+		expectInsert   bool
+		expectedStatus int
+		expectedErrors map[string]string
+	}{
+		{
+			name:           "Success",
+			body:           `{"name":"Zapier","scopes":["notes:write","notes:read","notes:read"]}`,
+			checkCount:     true,
+			expectInsert:   true,
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Unknown Scope",
+			body:           `{"name":"Zapier","scopes":["admin"]}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{"scopes": "must be among notes:read, notes:write"},
+		},
+		{
+			name:           "Missing Fields",
+			body:           `{}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{"name": "required", "scopes": "required"},
+		},
+		{
+			name:           "Too Many Keys",
+			body:           `{"name":"IFTTT","scopes":["notes:read"]}`,
+			checkCount:     true,
+			count:          MaxKeysPerUser,
+			expectedStatus: fiber.StatusConflict,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			if tc.checkCount {
+				helper.mockDB.ExpectQuery(countQuery).WithArgs("user123").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tc.count))
+			}
+			if tc.expectInsert {
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO api_keys (id, user_id, name, key_hash, scopes) VALUES (?, ?, ?, ?, ?)")).
+					WithArgs(sqlmock.AnyArg(), "user123", "Zapier", sqlmock.AnyArg(), "notes:read,notes:write").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			req := httptest.NewRequest("POST", "/me/api-keys", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusCreated {
+				var issued IssuedAPIKey
+				if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.True(t, strings.HasPrefix(issued.Key, "qk_"))
+				assert.Equal(t, []string{"notes:read", "notes:write"}, issued.Scopes)
+			} else if tc.expectedErrors != nil {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedErrors, response.Errors)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	helper := newTestHelper(t)
+
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, name, scopes, created_at FROM api_keys WHERE user_id = ? ORDER BY created_at DESC")).
+		WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "scopes", "created_at"}).
+			AddRow("key1", "Zapier", "notes:read,notes:write", time.Now()))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/me/api-keys", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var keys []map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, []any{"notes:read", "notes:write"}, keys[0]["scopes"])
+		assert.NotContains(t, keys[0], "key")
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeleteAPIKey(t *testing.T) {
+	helper := newTestHelper(t)
+	query := regexp.QuoteMeta("DELETE FROM api_keys WHERE id = ? AND user_id = ?")
+
+	helper.mockDB.ExpectExec(query).WithArgs("key1", "user123").WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err := helper.app.Test(httptest.NewRequest("DELETE", "/me/api-keys/key1", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+	if assert.Len(t, helper.audit.entries, 1) {
+		assert.Equal(t, audit.EventAPIKeyRevoked, helper.audit.entries[0].Event)
+		assert.Equal(t, map[string]string{"key_id": "key1"}, helper.audit.entries[0].Details)
+	}
+
+	helper.mockDB.ExpectExec(query).WithArgs("other", "user123").WillReturnResult(sqlmock.NewResult(0, 0))
+	resp, err = helper.app.Test(httptest.NewRequest("DELETE", "/me/api-keys/other", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestListTriggers(t *testing.T) {
+	helper := newTestHelper(t)
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/integrations/triggers", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var triggers []Trigger
+	if err := json.NewDecoder(resp.Body).Decode(&triggers); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.NotEmpty(t, triggers) {
+		assert.Equal(t, "note_updated", triggers[0].Key)
+		assert.Equal(t, "notes:read", triggers[0].Scope)
+	}
+}
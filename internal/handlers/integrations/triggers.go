@@ -0,0 +1,54 @@
+package integrations
+
+import (
+	"quanta/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Trigger describes an endpoint automation platforms can poll for new
+// items. Each poll passes the time of the previous one as updated_since and
+// the response lists matching items newest first.
+type Trigger struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	// Scope is the API key scope the endpoint needs
+	Scope string `json:"scope"`
+	// DedupeFields together identify an item that has already triggered
+	DedupeFields []string       `json:"dedupe_fields"`
+	Sample       map[string]any `json:"sample"`
+}
+
+// Triggers is the trigger catalog
+var Triggers = []Trigger{
+	{
+		Key:          "note_updated",
+		Name:         "New or updated note",
+		Description:  "Fires when one of your private notes is created or edited.",
+		Method:       fiber.MethodGet,
+		Path:         "/notes?updated_since={{since}}",
+		Scope:        models.ScopeNotesRead,
+		DedupeFields: []string{"id", "version"},
+		Sample: map[string]any{
+			"id":           "0b5e1c7a-3f4d-4a8e-9d1b-2c6f8e0a4b7d",
+			"user_id":      "5f0c2a9e-8b7d-4c1e-a3f6-9d2b4e7c1a08",
+			"workspace_id": nil,
+			"title":        "Meeting notes",
+			"content":      "Agreed to ship on Friday.",
+			"pinned":       false,
+			"archived":     false,
+			"version":      3,
+			"created_at":   "2026-01-05T09:00:00Z",
+			"updated_at":   "2026-01-05T09:30:00Z",
+		},
+	},
+}
+
+// ListTriggers serves the trigger catalog. It needs no authentication, so
+// platforms can read it while an integration is being set up.
+func (h *Handler) ListTriggers(c *fiber.Ctx) error {
+	return c.JSON(Triggers)
+}
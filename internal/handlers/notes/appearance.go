@@ -0,0 +1,144 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/models"
+	"quanta/internal/realtime"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Appearance is how clients show a note in lists: a color label from
+// models.NoteColors and an icon from models.NoteIcons. Either is null when
+// not set.
+type Appearance struct {
+	Color *string `json:"color"`
+	Icon  *string `json:"icon"`
+}
+
+// AppearancePayload is the request body for PatchNote. Fields left out
+// are kept as they are and "" clears them.
+type AppearancePayload struct {
+	Color *string `json:"color"`
+	Icon  *string `json:"icon"`
+}
+
+// AppearanceMessage is the realtime frame collaborators receive when a
+// note's color or icon changes
+type AppearanceMessage struct {
+	Type string `json:"type"`
+	V    int    `json:"v"`
+	Appearance
+}
+
+// validColor reports whether color is in the palette
+func validColor(color string) bool {
+	return slices.Contains(models.NoteColors, color)
+}
+
+// appearanceSets returns the SET clauses and arguments that apply payload,
+// rejecting colors and icons outside the allowed sets
+func appearanceSets(payload AppearancePayload) ([]string, []any, error) {
+	var sets []string
+	var args []any
+	if payload.Color != nil {
+		color := strings.TrimSpace(*payload.Color)
+		if color != "" && !validColor(color) {
+			return nil, nil, apperr.Invalid(map[string]string{"color": "must be one of " + strings.Join(models.NoteColors, ", ")})
+		}
+		sets = append(sets, "color = ?")
+		args = append(args, sql.NullString{String: color, Valid: color != ""})
+	}
+	if payload.Icon != nil {
+		icon := strings.TrimSpace(*payload.Icon)
+		if icon != "" && !slices.Contains(models.NoteIcons, icon) {
+			return nil, nil, apperr.Invalid(map[string]string{"icon": "must be one of " + strings.Join(models.NoteIcons, " ")})
+		}
+		sets = append(sets, "icon = ?")
+		args = append(args, sql.NullString{String: icon, Valid: icon != ""})
+	}
+	if len(sets) == 0 {
+		return nil, nil, apperr.New(fiber.StatusBadRequest, "No fields to update")
+	}
+	return sets, args, nil
+}
+
+// PatchNote changes a note's color label and icon and sends the result to
+// everyone in its room. Like pinning, this is not an edit, so the note's
+// version and updated_at stay as they were.
+func (h *Handler) PatchNote(c *fiber.Ctx) error {
+	var payload AppearancePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	appearance, err := h.SetAppearance(c.UserContext(), userID, c.Params("id"), payload)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(appearance)
+}
+
+// SetAppearance applies payload to a note the user can edit and returns
+// its appearance afterwards
+func (h *Handler) SetAppearance(ctx context.Context, userID, noteID string, payload AppearancePayload) (Appearance, error) {
+	sets, args, err := appearanceSets(payload)
+	if err != nil {
+		return Appearance{}, err
+	}
+	if err := h.requireEditor(ctx, noteID, userID); err != nil {
+		return Appearance{}, err
+	}
+
+	result, err := h.db.ExecContext(ctx,
+		"UPDATE notes SET "+strings.Join(sets, ", ")+", updated_at = updated_at WHERE id = ? AND "+accessible,
+		append(args, noteID, userID, userID)...,
+	)
+	if err != nil {
+		return Appearance{}, fmt.Errorf("updating note appearance: %w", err)
+	}
+	if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+		return Appearance{}, apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+
+	// Read both back, as the payload may have set only one
+	var color, icon sql.NullString
+	err = h.db.QueryRowContext(ctx, "SELECT color, icon FROM notes WHERE id = ?", noteID).Scan(&color, &icon)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Appearance{}, apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+	if err != nil {
+		return Appearance{}, fmt.Errorf("fetching note appearance: %w", err)
+	}
+	appearance := Appearance{Color: nullable(color), Icon: nullable(icon)}
+
+	if h.rooms != nil {
+		h.rooms.Publish(ctx, noteID, AppearanceMessage{
+			Type:       "appearance",
+			V:          realtime.ProtocolVersion,
+			Appearance: appearance,
+		})
+	}
+	return appearance, nil
+}
+
+// nullable returns a pointer to a NULL-able column's value, or nil
+func nullable(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
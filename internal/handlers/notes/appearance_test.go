@@ -0,0 +1,171 @@
+package notes
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchNote(t *testing.T) {
+	accessibleQuery := " WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))"
+	selectQuery := regexp.QuoteMeta("SELECT color, icon FROM notes WHERE id = ?")
+	blue, star := "blue", "⭐"
+
+	testCases := []struct {
+		name           string
+		body           string
+		role           string
+		set            string
+		args           []any
+		rowsAffected   int64
+		stored         []driver.Value
+		expectedStatus int
+		expected       *Appearance
+	}{
+		{
+			name:           "Color And Icon",
+			body:           `{"color":"blue","icon":"⭐"}`,
+			set:            "color = ?, icon = ?",
+			args:           []any{sql.NullString{String: "blue", Valid: true}, sql.NullString{String: "⭐", Valid: true}},
+			rowsAffected:   1,
+			stored:         []driver.Value{"blue", "⭐"},
+			expectedStatus: fiber.StatusOK,
+			expected:       &Appearance{Color: &blue, Icon: &star},
+		},
+		{
+			name:           "Clear Color Keeps Icon",
+			body:           `{"color":""}`,
+			set:            "color = ?",
+			args:           []any{sql.NullString{}},
+			rowsAffected:   1,
+			stored:         []driver.Value{nil, "⭐"},
+			expectedStatus: fiber.StatusOK,
+			expected:       &Appearance{Icon: &star},
+		},
+		{
+			name:           "Color Outside The Palette",
+			body:           `{"color":"chartreuse"}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Icon Outside The Set",
+			body:           `{"icon":"<svg>"}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Nothing To Update",
+			body:           `{}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Viewer",
+			body:           `{"color":"red"}`,
+			role:           "viewer",
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name:           "Note Not Found",
+			body:           `{"color":"red"}`,
+			set:            "color = ?",
+			args:           []any{sql.NullString{String: "red", Valid: true}},
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("PATCH", "/notes/:id", helper.handler.PatchNote)
+
+			if tc.set != "" || tc.role != "" {
+				helper.expectRole("note1", tc.role)
+			}
+			if tc.set != "" {
+				args := make([]driver.Value, 0, len(tc.args)+3)
+				for _, arg := range tc.args {
+					args = append(args, arg)
+				}
+				args = append(args, "note1", "user123", "user123")
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE notes SET " + tc.set + ", updated_at = updated_at" + accessibleQuery)).
+					WithArgs(args...).
+					WillReturnResult(sqlmock.NewResult(0, tc.rowsAffected))
+			}
+			if tc.stored != nil {
+				helper.mockDB.ExpectQuery(selectQuery).WithArgs("note1").
+					WillReturnRows(sqlmock.NewRows([]string{"color", "icon"}).AddRow(tc.stored...))
+			}
+
+			req := httptest.NewRequest("PATCH", "/notes/note1", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expected != nil {
+				var got Appearance
+				if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, *tc.expected, got)
+				if assert.Len(t, helper.rooms.published, 1) {
+					msg := helper.rooms.published[0].(AppearanceMessage)
+					assert.Equal(t, "appearance", msg.Type)
+					assert.Equal(t, *tc.expected, msg.Appearance)
+				}
+			} else {
+				assert.Empty(t, helper.rooms.published)
+			}
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetNotes_Color(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/notes", helper.handler.GetNotes)
+
+	now := time.Now()
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE user_id = ? AND workspace_id IS NULL AND archived = ? AND color = ? ORDER BY pinned DESC, updated_at DESC")).
+		WithArgs("user123", false, "green").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}).
+			AddRow("note1", "user123", nil, "Garden", "", false, false, 1, now, now, 0, 0, false, nil, "green", "🌱"))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes?color=green", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var notes []Note
+	if err := json.NewDecoder(resp.Body).Decode(&notes); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, notes, 1) {
+		assert.Equal(t, "green", *notes[0].Color)
+		assert.Equal(t, "🌱", *notes[0].Icon)
+	}
+
+	resp, err = helper.app.Test(httptest.NewRequest("GET", "/notes?color=chartreuse", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
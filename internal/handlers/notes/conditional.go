@@ -0,0 +1,88 @@
+package notes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// etagFor returns a strong ETag covering the listed notes' IDs, versions,
+// pinned/archived flags, color, icon and metadata, so it changes whenever
+// any of them is edited, pinned, archived, relabeled or removed from the
+// list
+func etagFor(notes ...Note) string {
+	h := sha256.New()
+	for _, n := range notes {
+		h.Write([]byte(n.ID))
+		h.Write([]byte{':'})
+		h.Write(strconv.AppendInt(nil, n.Version, 10))
+		h.Write(strconv.AppendBool(nil, n.Pinned))
+		h.Write(strconv.AppendBool(nil, n.Archived))
+		if n.Color != nil {
+			h.Write([]byte("color:" + *n.Color))
+		}
+		if n.Icon != nil {
+			h.Write([]byte("icon:" + *n.Icon))
+		}
+		if len(n.Metadata) > 0 {
+			// Maps marshal with sorted keys, so equal metadata hashes the same
+			metadata, _ := json.Marshal(n.Metadata)
+			h.Write(metadata)
+		}
+		h.Write([]byte{';'})
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// lastModified returns the latest updated_at among notes
+func lastModified(notes ...Note) time.Time {
+	var latest time.Time
+	for _, n := range notes {
+		if n.UpdatedAt.After(latest) {
+			latest = n.UpdatedAt
+		}
+	}
+	return latest
+}
+
+// sendConditional sets the validators for body and answers 304 Not Modified
+// if the request's If-None-Match or If-Modified-Since shows the client
+// already has it. If-None-Match wins when both are sent, as RFC 9110 asks.
+func sendConditional(c *fiber.Ctx, etag string, modified time.Time, body any) error {
+	c.Set(fiber.HeaderETag, etag)
+	if !modified.IsZero() {
+		c.Set(fiber.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(c, etag, modified) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	return c.JSON(body)
+}
+
+// notModified evaluates the request's conditional headers against the
+// current validators
+func notModified(c *fiber.Ctx, etag string, modified time.Time) bool {
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		for _, candidate := range strings.Split(noneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	// HTTP dates have second precision
+	return !modified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,203 @@
+package notes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/maintenance"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetNote(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/notes/:id", helper.handler.GetNote)
+
+	updated := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	stored := Note{ID: "note1", UserID: "user123", Title: "Title", Content: "Body", Version: 4, CreatedAt: updated, UpdatedAt: updated}
+	etag := etagFor(stored)
+
+	testCases := []struct {
+		name           string
+		headers        map[string]string
+		noRows         bool
+		mockError      error
+		expectedStatus int
+	}{
+		{
+			name:           "Success",
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Matching ETag",
+			headers:        map[string]string{"If-None-Match": etag},
+			expectedStatus: fiber.StatusNotModified,
+		},
+		{
+			name:           "Weak Matching ETag In List",
+			headers:        map[string]string{"If-None-Match": `"stale", W/` + etag},
+			expectedStatus: fiber.StatusNotModified,
+		},
+		{
+			name:           "Stale ETag",
+			headers:        map[string]string{"If-None-Match": `"stale"`},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "ETag Wins Over Date",
+			headers:        map[string]string{"If-None-Match": `"stale"`, "If-Modified-Since": updated.Add(time.Hour).Format(http.TimeFormat)},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Not Modified Since",
+			headers:        map[string]string{"If-Modified-Since": updated.Format(http.TimeFormat)},
+			expectedStatus: fiber.StatusNotModified,
+		},
+		{
+			name:           "Modified Since",
+			headers:        map[string]string{"If-Modified-Since": updated.Add(-time.Second).Format(http.TimeFormat)},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Not Found",
+			noRows:         true,
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Database Error",
+			mockError:      errors.New("database error"),
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query := regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+			rows := sqlmock.NewRows([]string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"})
+			if !tc.noRows {
+				rows.AddRow(stored.ID, stored.UserID, nil, stored.Title, stored.Content, false, false, stored.Version, updated, updated, 0, 0, false, nil, nil, nil)
+			}
+			expect := helper.mockDB.ExpectQuery(query).WithArgs("note1", "user123", "user123")
+			if tc.mockError != nil {
+				expect.WillReturnError(tc.mockError)
+			} else {
+				expect.WillReturnRows(rows)
+			}
+			if !tc.noRows && tc.mockError == nil {
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE note_views SET viewed_at = CURRENT_TIMESTAMP WHERE user_id = ? AND note_id = ?")).
+					WithArgs("user123", "note1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE note_receipts SET version = ?, read_at = ? WHERE note_id = ? AND user_id = ? AND version < ?")).
+					WithArgs(stored.Version, sqlmock.AnyArg(), "note1", "user123", stored.Version).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			req := httptest.NewRequest("GET", "/notes/note1", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus == fiber.StatusOK || tc.expectedStatus == fiber.StatusNotModified {
+				assert.Equal(t, etag, resp.Header.Get("ETag"))
+				assert.Equal(t, updated.Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+			}
+		})
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetNote_Maintenance(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	mode := maintenance.New(helper.db, nil)
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT enabled, message, ends_at FROM maintenance WHERE id = 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"enabled", "message", "ends_at"}).AddRow(true, "", nil))
+	if err := mode.Reload(context.Background()); err != nil {
+		t.Fatalf("error loading maintenance mode: %v", err)
+	}
+	helper.app.Use(mode.Handler())
+	helper.setupRoute("GET", "/notes/:id", helper.handler.GetNote)
+
+	// The note is served without recording the view or a read receipt;
+	// attempts would fail against the mock and be logged
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	now := time.Now()
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE id = ?")).
+		WithArgs("note1", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}).
+			AddRow("note1", "user123", nil, "Title", "Body", false, false, 4, now, now, 0, 0, false, nil, nil, nil))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/note1", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Empty(t, logged.String())
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetNotes_Conditional(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/notes", helper.handler.GetNotes)
+
+	now := time.Now()
+	query := regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE user_id = ? AND workspace_id IS NULL AND archived = ? ORDER BY pinned DESC, updated_at DESC")
+	columns := []string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}
+	list := []Note{
+		{ID: "note1", Version: 2, Pinned: true},
+		{ID: "note2", Version: 1},
+	}
+
+	fetch := func(rows *sqlmock.Rows) int {
+		helper.mockDB.ExpectQuery(query).WithArgs("user123", false).WillReturnRows(rows)
+		req := httptest.NewRequest("GET", "/notes", nil)
+		req.Header.Set("If-None-Match", etagFor(list...))
+		resp, err := helper.app.Test(req)
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	unchanged := sqlmock.NewRows(columns).
+		AddRow("note1", "user123", nil, "A", "", true, false, 2, now, now, 0, 0, false, nil, nil, nil).
+		AddRow("note2", "user123", nil, "B", "", false, false, 1, now, now, 0, 0, false, nil, nil, nil)
+	assert.Equal(t, fiber.StatusNotModified, fetch(unchanged))
+
+	// Deleting note2 leaves every remaining timestamp alone but changes the ETag
+	deleted := sqlmock.NewRows(columns).
+		AddRow("note1", "user123", nil, "A", "", true, false, 2, now, now, 0, 0, false, nil, nil, nil)
+	assert.Equal(t, fiber.StatusOK, fetch(deleted))
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/changelog"
+	"quanta/internal/crdt"
+	"quanta/internal/db"
+	"quanta/internal/quota"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// MaxUpdateOps is the most CRDT operations one request may apply
+	MaxUpdateOps = 1000
+	// ServerClient is the client name of the operations the server makes
+	// itself, when a note was written some other way since its CRDT state
+	// was stored or its merged content had to be sanitized. Clients can't
+	// use it.
+	ServerClient = "server"
+)
+
+// NoteState is a note's CRDT state. Vector names every operation the
+// server has applied, Ops are those the requester is missing, in the order
+// to apply them, and Content and Version are the note as stored.
+type NoteState struct {
+	Version int64            `json:"version"`
+	Content string           `json:"content"`
+	Vector  crdt.StateVector `json:"vector"`
+	Ops     []crdt.Op        `json:"ops"`
+}
+
+// UpdatesPayload is the request body for ApplyUpdates: the operations a
+// client made offline and its state vector, counting those operations
+type UpdatesPayload struct {
+	Vector crdt.StateVector `json:"vector"`
+	Ops    []crdt.Op        `json:"ops"`
+}
+
+// errStaleCRDT is returned inside a CRDT write whose version guard failed
+// because another write landed first
+var errStaleCRDT = errors.New("note changed while merging")
+
+// GetState returns a note's CRDT state, with the operations a client at
+// the state vector in ?since is missing, or every operation without it
+func (h *Handler) GetState(c *fiber.Ctx) error {
+	var since crdt.StateVector
+	if raw := c.Query("since"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &since); err != nil {
+			return apperr.New(fiber.StatusBadRequest, "since must be a state vector")
+		}
+	}
+
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	state, err := h.mergeCRDT(c.UserContext(), userID, c.Params("id"), nil, since)
+	if err != nil {
+		return err
+	}
+	return c.JSON(state)
+}
+
+// ApplyUpdates merges operations a client made offline into a note and
+// answers with the operations the client is missing, so any number of
+// clients reconnecting in any order end up with the same content without
+// the realtime socket. Operations the server already has are skipped, so
+// a batch can be resent safely.
+func (h *Handler) ApplyUpdates(c *fiber.Ctx) error {
+	var payload UpdatesPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if len(payload.Ops) == 0 {
+		return apperr.New(fiber.StatusBadRequest, "No operations to apply")
+	}
+	if len(payload.Ops) > MaxUpdateOps {
+		return apperr.Newf(fiber.StatusBadRequest, "At most %d operations can be applied at once", MaxUpdateOps)
+	}
+	for _, op := range payload.Ops {
+		if op.ID.Client == ServerClient {
+			return apperr.Invalid(map[string]string{"ops": "client " + ServerClient + " is reserved"})
+		}
+	}
+
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	state, err := h.mergeCRDT(c.UserContext(), userID, c.Params("id"), payload.Ops, payload.Vector)
+	if err != nil {
+		return err
+	}
+	return c.JSON(state)
+}
+
+// mergeCRDT loads a note's CRDT state, catches it up with the note's
+// content, applies ops and stores the result, retrying when another write
+// lands in between. It returns the state with the operations since is
+// missing.
+func (h *Handler) mergeCRDT(ctx context.Context, userID, noteID string, ops []crdt.Op, since crdt.StateVector) (NoteState, error) {
+	for range MaxMergeAttempts {
+		var title, content string
+		var workspaceID sql.NullString
+		var version int64
+		var encrypted bool
+		err := h.db.QueryRowContext(ctx, "SELECT title, content, workspace_id, version, encrypted FROM notes WHERE id = ? AND "+accessible, noteID, userID, userID).
+			Scan(&title, &content, &workspaceID, &version, &encrypted)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return NoteState{}, apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+			}
+			return NoteState{}, fmt.Errorf("fetching note: %w", err)
+		}
+		// The server can't read ciphertext to merge it
+		if encrypted {
+			return NoteState{}, apperr.New(fiber.StatusBadRequest, "Encrypted notes cannot be merged by the server")
+		}
+		if len(ops) > 0 {
+			if err := h.requireEditor(ctx, noteID, userID); err != nil {
+				return NoteState{}, err
+			}
+			if err := h.checkLock(ctx, noteID, userID); err != nil {
+				return NoteState{}, err
+			}
+		}
+
+		stored, err := h.loadCRDT(ctx, noteID)
+		if err != nil {
+			return NoteState{}, err
+		}
+		doc := stored.doc
+		// Writes that didn't go through the CRDT are replayed into it as
+		// the server's own edit
+		changed := false
+		if stored.version != version && doc.Text() != content {
+			changed = len(doc.SetText(ServerClient, content)) > 0
+		}
+
+		applied, err := doc.Apply(ops)
+		if err != nil {
+			if errors.Is(err, crdt.ErrMissingDependency) {
+				return NoteState{}, apperr.New(fiber.StatusConflict, "Update depends on changes the server doesn't have")
+			}
+			return NoteState{}, apperr.Invalid(map[string]string{"ops": err.Error()})
+		}
+		changed = changed || len(applied) > 0
+		if !changed {
+			return NoteState{Version: version, Content: content, Vector: doc.Vector(), Ops: doc.Since(since)}, nil
+		}
+
+		merged := NotePayload{Title: title, Content: doc.Text()}
+		newVersion := version
+		if merged.Content != content {
+			if err := h.checkLimits(merged); err != nil {
+				return NoteState{}, err
+			}
+			var chargeTo *string
+			if workspaceID.Valid {
+				chargeTo = &workspaceID.String
+			}
+			if err := h.quota.Check(ctx, h.db, userID, chargeTo, quota.NoteSize(title, merged.Content)-quota.NoteSize(title, content)); err != nil {
+				return NoteState{}, err
+			}
+			newVersion++
+		}
+
+		err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+			if newVersion != version {
+				stats := statsOf(merged.Content)
+				result, err := tx.ExecContext(ctx, "UPDATE notes SET content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?",
+					merged.Content, quota.NoteSize(title, merged.Content), stats.WordCount, stats.CharCount, noteID, version)
+				if err != nil {
+					return fmt.Errorf("updating note: %w", err)
+				}
+				if n, _ := result.RowsAffected(); n == 0 {
+					return errStaleCRDT
+				}
+				if err := snapshotRevision(ctx, tx, noteID, userID); err != nil {
+					return fmt.Errorf("recording revision: %w", err)
+				}
+				if err := changelog.Record(ctx, tx, changelog.Updated, "id = ?", noteID); err != nil {
+					return err
+				}
+				if err := syncTasks(ctx, tx, noteID, content, merged.Content); err != nil {
+					return fmt.Errorf("syncing tasks: %w", err)
+				}
+			}
+			return storeCRDT(ctx, tx, noteID, stored, newVersion)
+		})
+		if errors.Is(err, errStaleCRDT) {
+			continue
+		}
+		if err != nil {
+			return NoteState{}, err
+		}
+
+		if newVersion != version {
+			h.recordChanges(ctx, noteID, userID, title, content, merged)
+		}
+		return NoteState{Version: newVersion, Content: merged.Content, Vector: doc.Vector(), Ops: doc.Since(since)}, nil
+	}
+
+	return NoteState{}, apperr.New(fiber.StatusConflict, "Note is changing too quickly to merge, try again")
+}
+
+// crdtState is a note's CRDT state as loaded. version is the note version
+// its text matches, and revision counts the times it was stored, guarding
+// against a concurrent write; both are 0 when it was never stored.
+type crdtState struct {
+	doc      *crdt.Doc
+	version  int64
+	revision int64
+}
+
+// loadCRDT loads a note's stored CRDT state, or an empty document when it
+// has none
+func (h *Handler) loadCRDT(ctx context.Context, noteID string) (crdtState, error) {
+	var raw string
+	var s crdtState
+	err := h.db.QueryRowContext(ctx, "SELECT state, version, revision FROM note_crdt WHERE note_id = ?", noteID).
+		Scan(&raw, &s.version, &s.revision)
+	if errors.Is(err, sql.ErrNoRows) {
+		return crdtState{doc: crdt.New()}, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("fetching CRDT state: %w", err)
+	}
+
+	var ops []crdt.Op
+	if err := json.Unmarshal([]byte(raw), &ops); err != nil {
+		return s, fmt.Errorf("decoding CRDT state of note %s: %w", noteID, err)
+	}
+	if s.doc, err = crdt.Load(ops); err != nil {
+		return s, fmt.Errorf("loading CRDT state of note %s: %w", noteID, err)
+	}
+	return s, nil
+}
+
+// storeCRDT writes a note's CRDT state as of version. The guard on the
+// revision it was loaded at stops two writers that caught up with the same
+// change from storing different operations under the same IDs.
+func storeCRDT(ctx context.Context, tx *sql.Tx, noteID string, s crdtState, version int64) error {
+	state, err := json.Marshal(s.doc.Ops())
+	if err != nil {
+		return fmt.Errorf("encoding CRDT state: %w", err)
+	}
+
+	if s.revision == 0 {
+		_, err := tx.ExecContext(ctx, "INSERT INTO note_crdt (note_id, state, version, revision) VALUES (?, ?, ?, 1)", noteID, string(state), version)
+		if db.IsDuplicate(err) {
+			return errStaleCRDT
+		}
+		if err != nil {
+			return fmt.Errorf("storing CRDT state: %w", err)
+		}
+		return nil
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE note_crdt SET state = ?, version = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP WHERE note_id = ? AND revision = ?",
+		string(state), version, noteID, s.revision)
+	if err != nil {
+		return fmt.Errorf("storing CRDT state: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errStaleCRDT
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"quanta/internal/activity"
+	"quanta/internal/crdt"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	crdtNoteQuery  = regexp.QuoteMeta("SELECT title, content, workspace_id, version, encrypted FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+	crdtStateQuery = regexp.QuoteMeta("SELECT state, version, revision FROM note_crdt WHERE note_id = ?")
+)
+
+// crdtNote returns the row of a stored note
+func crdtNote(content string, version int64, encrypted bool) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"title", "content", "workspace_id", "version", "encrypted"}).AddRow("T", content, nil, version, encrypted)
+}
+
+// storedState returns the stored CRDT state row of doc
+func storedState(t *testing.T, doc *crdt.Doc, version, revision int64) *sqlmock.Rows {
+	t.Helper()
+	state, err := json.Marshal(doc.Ops())
+	require.NoError(t, err)
+	return sqlmock.NewRows([]string{"state", "version", "revision"}).AddRow(string(state), version, revision)
+}
+
+func TestGetState(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.setupRoute("GET", "/notes/:id/state", helper.handler.GetState)
+
+	// A note that was never merged gets its content as the server's edit
+	helper.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("abc", 2, false))
+	helper.mockDB.ExpectQuery(crdtStateQuery).WithArgs("note1").WillReturnRows(sqlmock.NewRows([]string{"state"}))
+	helper.mockDB.ExpectBegin()
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO note_crdt (note_id, state, version, revision) VALUES (?, ?, ?, 1)")).
+		WithArgs("note1", `[{"id":{"client":"server","clock":1},"text":"abc"}]`, int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	helper.mockDB.ExpectCommit()
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/note1/state", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var state NoteState
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+	assert.Equal(t, NoteState{
+		Version: 2,
+		Content: "abc",
+		Vector:  crdt.StateVector{ServerClient: 3},
+		Ops:     []crdt.Op{{ID: crdt.ID{Client: ServerClient, Clock: 1}, Text: "abc"}},
+	}, state)
+
+	// A client that has seen everything is missing nothing
+	doc := crdt.New()
+	doc.SetText(ServerClient, "abc")
+	helper.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("abc", 2, false))
+	helper.mockDB.ExpectQuery(crdtStateQuery).WithArgs("note1").WillReturnRows(storedState(t, doc, 2, 1))
+	resp, err = helper.app.Test(httptest.NewRequest("GET", "/notes/note1/state?since="+url.QueryEscape(`{"server":3}`), nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+	assert.Empty(t, state.Ops)
+
+	resp, err = helper.app.Test(httptest.NewRequest("GET", "/notes/note1/state?since=3", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	assert.NoError(t, helper.mockDB.ExpectationsWereMet())
+}
+
+func TestApplyUpdates(t *testing.T) {
+	updateNote := regexp.QuoteMeta("UPDATE notes SET content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?")
+	updateState := regexp.QuoteMeta("UPDATE note_crdt SET state = ?, version = ?, revision = revision + 1, updated_at = CURRENT_TIMESTAMP WHERE note_id = ? AND revision = ?")
+
+	// The server has "abc"; the client appended "!" offline while someone
+	// else's "X" at the start reached the server first
+	base := crdt.New()
+	base.SetText(ServerClient, "abc")
+	server, err := crdt.Load(base.Ops())
+	require.NoError(t, err)
+	server.SetText("other", "Xabc")
+	client, err := crdt.Load(base.Ops())
+	require.NoError(t, err)
+	offline := client.SetText("client", "abc!")
+	body, err := json.Marshal(UpdatesPayload{Vector: client.Vector(), Ops: offline})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name           string
+		body           string
+		setupMock      func(h *testHelper)
+		expectedStatus int
+		expectedState  *NoteState
+		activities     []activity.Action
+	}{
+		{
+			name: "Merged",
+			body: string(body),
+			setupMock: func(h *testHelper) {
+				h.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("Xabc", 3, false))
+				h.expectRole("note1", "")
+				h.expectLock("note1", "")
+				h.mockDB.ExpectQuery(crdtStateQuery).WithArgs("note1").WillReturnRows(storedState(t, server, 3, 2))
+				h.mockDB.ExpectBegin()
+				h.mockDB.ExpectExec(updateNote).WithArgs("Xabc!", int64(6), int64(1), int64(5), "note1", int64(3)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectRevision(h.mockDB, "note1")
+				expectChange(h.mockDB, "updated", "id = ?", "note1")
+				h.mockDB.ExpectExec(updateState).WithArgs(sqlmock.AnyArg(), int64(4), "note1", int64(2)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				h.mockDB.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusOK,
+			expectedState: &NoteState{
+				Version: 4,
+				Content: "Xabc!",
+				Vector:  crdt.StateVector{ServerClient: 3, "other": 4, "client": 4},
+				Ops:     []crdt.Op{{ID: crdt.ID{Client: "other", Clock: 4}, Text: "X"}},
+			},
+			activities: []activity.Action{activity.ActionEdited},
+		},
+		{
+			name: "Retried After Concurrent Write",
+			body: string(body),
+			setupMock: func(h *testHelper) {
+				h.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("abc", 2, false))
+				h.expectRole("note1", "")
+				h.expectLock("note1", "")
+				h.mockDB.ExpectQuery(crdtStateQuery).WithArgs("note1").WillReturnRows(storedState(t, base, 2, 1))
+				h.mockDB.ExpectBegin()
+				h.mockDB.ExpectExec(updateNote).WithArgs("abc!", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "note1", int64(2)).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				h.mockDB.ExpectRollback()
+				h.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("Xabc", 3, false))
+				h.expectRole("note1", "")
+				h.expectLock("note1", "")
+				h.mockDB.ExpectQuery(crdtStateQuery).WithArgs("note1").WillReturnRows(storedState(t, server, 3, 2))
+				h.mockDB.ExpectBegin()
+				h.mockDB.ExpectExec(updateNote).WithArgs("Xabc!", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "note1", int64(3)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectRevision(h.mockDB, "note1")
+				expectChange(h.mockDB, "updated", "id = ?", "note1")
+				h.mockDB.ExpectExec(updateState).WithArgs(sqlmock.AnyArg(), int64(4), "note1", int64(2)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				h.mockDB.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusOK,
+			activities:     []activity.Action{activity.ActionEdited},
+		},
+		{
+			name: "Already Applied",
+			body: string(body),
+			setupMock: func(h *testHelper) {
+				merged, _ := crdt.Load(server.Ops())
+				_, _ = merged.Apply(offline)
+				h.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("Xabc!", 4, false))
+				h.expectRole("note1", "")
+				h.expectLock("note1", "")
+				h.mockDB.ExpectQuery(crdtStateQuery).WithArgs("note1").WillReturnRows(storedState(t, merged, 4, 3))
+			},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name: "Missing Dependency",
+			body: `{"ops":[{"id":{"client":"client","clock":9},"origin":{"client":"gone","clock":8},"text":"x"}]}`,
+			setupMock: func(h *testHelper) {
+				h.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("abc", 2, false))
+				h.expectRole("note1", "")
+				h.expectLock("note1", "")
+				h.mockDB.ExpectQuery(crdtStateQuery).WithArgs("note1").WillReturnRows(storedState(t, base, 2, 1))
+			},
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name: "Malformed Op",
+			body: `{"ops":[{"id":{"client":"client","clock":9}}]}`,
+			setupMock: func(h *testHelper) {
+				h.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("abc", 2, false))
+				h.expectRole("note1", "")
+				h.expectLock("note1", "")
+				h.mockDB.ExpectQuery(crdtStateQuery).WithArgs("note1").WillReturnRows(storedState(t, base, 2, 1))
+			},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Reserved Client",
+			body:           `{"ops":[{"id":{"client":"server","clock":9},"text":"x"}]}`,
+			setupMock:      func(*testHelper) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "No Ops",
+			body:           `{"ops":[]}`,
+			setupMock:      func(*testHelper) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name: "Encrypted",
+			body: string(body),
+			setupMock: func(h *testHelper) {
+				h.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("ciphertext", 2, true))
+			},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name: "Read Only",
+			body: string(body),
+			setupMock: func(h *testHelper) {
+				h.mockDB.ExpectQuery(crdtNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(crdtNote("abc", 2, false))
+				h.expectRole("note1", "viewer")
+			},
+			expectedStatus: fiber.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("POST", "/notes/:id/updates", helper.handler.ApplyUpdates)
+			tc.setupMock(helper)
+
+			req := httptest.NewRequest("POST", "/notes/note1/updates", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedState != nil {
+				var state NoteState
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+				assert.Equal(t, *tc.expectedState, state)
+			}
+
+			var actions []activity.Action
+			for _, a := range helper.recorder.recorded {
+				actions = append(actions, a.action)
+			}
+			assert.Equal(t, tc.activities, actions)
+			assert.NoError(t, helper.mockDB.ExpectationsWereMet())
+		})
+	}
+}
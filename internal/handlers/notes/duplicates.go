@@ -0,0 +1,285 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/changelog"
+	"quanta/internal/db"
+	"quanta/internal/quota"
+	"quanta/internal/similar"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DuplicateScanLimit is how many of the user's most recently updated notes
+// are compared when looking for duplicates
+const DuplicateScanLimit = 2000
+
+// MaxMergeNotes is how many notes one merge can combine
+const MaxMergeNotes = 20
+
+// DuplicateNote is a note in a group of likely duplicates
+type DuplicateNote struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DuplicateGroup is a set of notes with similar content. Similarity is the
+// lowest similarity, from 0 to 1, of the pairs of notes found alike.
+type DuplicateGroup struct {
+	Similarity float64         `json:"similarity"`
+	Notes      []DuplicateNote `json:"notes"`
+}
+
+// MergeNotesPayload is the request body for MergeNotes. Title defaults to
+// the first note's.
+type MergeNotesPayload struct {
+	NoteIDs []string `json:"note_ids"`
+	Title   string   `json:"title"`
+}
+
+// MergeNotesResult is the response to MergeNotes
+type MergeNotesResult struct {
+	ID      string `json:"id"`
+	Version int64  `json:"version"`
+}
+
+// GetDuplicates groups the user's private notes whose content is alike, by
+// how many runs of words they share. ?threshold= sets how alike, from 0 to
+// 1, two notes must be (default 0.5). Encrypted notes are left out.
+func (h *Handler) GetDuplicates(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	threshold := 0.5
+	if raw := c.Query("threshold"); raw != "" {
+		threshold, err = strconv.ParseFloat(raw, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			return apperr.New(fiber.StatusBadRequest, "threshold must be a number greater than 0 and at most 1")
+		}
+	}
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT id, title, content, updated_at FROM notes WHERE user_id = ? AND workspace_id IS NULL AND encrypted = ? ORDER BY updated_at DESC LIMIT ?",
+		userID, false, DuplicateScanLimit,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching notes: %w", err)
+	}
+	defer closeRows(rows)
+
+	var notes []DuplicateNote
+	var shingles []similar.Set
+	for rows.Next() {
+		var n DuplicateNote
+		var content sql.NullString
+		if err := rows.Scan(&n.ID, &n.Title, &content, &n.UpdatedAt); err != nil {
+			return fmt.Errorf("scanning note: %w", err)
+		}
+		notes = append(notes, n)
+		shingles = append(shingles, similar.Shingles(n.Title+"\n"+content.String))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating notes: %w", err)
+	}
+
+	groups := []DuplicateGroup{}
+	for _, cluster := range similar.Clusters(shingles, threshold) {
+		group := DuplicateGroup{Similarity: cluster.Similarity}
+		for _, i := range cluster.Members {
+			group.Notes = append(group.Notes, notes[i])
+		}
+		groups = append(groups, group)
+	}
+
+	return c.JSON(groups)
+}
+
+// MergeNotes combines several of the user's private notes into the first
+// of them. Its content becomes each note's content in turn, separated by a
+// blank line, and the rest are deleted. The other notes' titles and content
+// are kept as revisions of the merged note, one version each, ahead of the
+// merged version.
+func (h *Handler) MergeNotes(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	ctx := c.UserContext()
+
+	var payload MergeNotesPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	ids := payload.NoteIDs
+	distinct := make(map[string]bool)
+	for _, id := range ids {
+		distinct[id] = true
+	}
+	if len(ids) < 2 || len(ids) > MaxMergeNotes || len(distinct) != len(ids) {
+		return apperr.Invalid(map[string]string{"note_ids": fmt.Sprintf("must list between 2 and %d different notes", MaxMergeNotes)})
+	}
+
+	sources, err := h.mergeSources(ctx, userID, ids)
+	if err != nil {
+		return err
+	}
+	target := sources[0]
+
+	contents := make([]string, 0, len(sources))
+	var oldSize int64
+	for _, n := range sources {
+		if n.Encrypted {
+			return apperr.New(fiber.StatusBadRequest, "Encrypted notes cannot be merged by the server")
+		}
+		if text := strings.TrimRight(n.Content, "\n"); text != "" {
+			contents = append(contents, text)
+		}
+		oldSize += quota.NoteSize(n.Title, n.Content)
+	}
+	merged := NotePayload{Title: payload.Title, Content: strings.Join(contents, "\n\n")}
+	if merged.Title == "" {
+		merged.Title = target.Title
+	}
+	if err := h.validateNote(ctx, &merged); err != nil {
+		return err
+	}
+	size := quota.NoteSize(merged.Title, merged.Content)
+	if err := h.quota.Check(ctx, h.db, userID, nil, size-oldSize); err != nil {
+		return err
+	}
+
+	version := target.Version + int64(len(sources))
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		// Notes written before revisions were kept have none for their
+		// current version
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO note_revisions (note_id, version, user_id, title, content) SELECT id, version, ?, title, content FROM notes WHERE id = ? AND NOT EXISTS (SELECT 1 FROM note_revisions WHERE note_id = ? AND version = ?)",
+			userID, target.ID, target.ID, target.Version,
+		)
+		if err != nil {
+			return err
+		}
+		for i, n := range sources[1:] {
+			_, err := tx.ExecContext(ctx,
+				"INSERT INTO note_revisions (note_id, version, user_id, title, content) VALUES (?, ?, ?, ?, ?)",
+				target.ID, target.Version+int64(i)+1, userID, n.Title, n.Content,
+			)
+			// A revision already there was written by a change since the
+			// notes were read
+			if db.IsDuplicate(err) {
+				return errMergeRaced
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		stats := statsOf(merged.Content)
+		result, err := tx.ExecContext(ctx,
+			"UPDATE notes SET title = ?, content = ?, size = ?, word_count = ?, char_count = ?, version = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?",
+			merged.Title, merged.Content, size, stats.WordCount, stats.CharCount, version, target.ID, target.Version,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return errMergeRaced
+		}
+		if err := snapshotRevision(ctx, tx, target.ID, userID); err != nil {
+			return err
+		}
+		if err := changelog.Record(ctx, tx, changelog.Updated, "id = ?", target.ID); err != nil {
+			return err
+		}
+
+		others := ids[1:]
+		args := []any{userID}
+		for _, id := range others {
+			args = append(args, id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(others)), ", ")
+		if err := changelog.Record(ctx, tx, changelog.Deleted, "user_id = ? AND workspace_id IS NULL AND id IN ("+placeholders+")", args...); err != nil {
+			return err
+		}
+		result, err = tx.ExecContext(ctx, "DELETE FROM notes WHERE user_id = ? AND workspace_id IS NULL AND id IN ("+placeholders+")", args...)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n != int64(len(others)) {
+			return errMergeRaced
+		}
+		return nil
+	})
+	if errors.Is(err, errMergeRaced) {
+		return apperr.New(fiber.StatusConflict, "Notes changed while merging, try again")
+	}
+	if err != nil {
+		return fmt.Errorf("merging notes: %w", err)
+	}
+
+	h.recordTasks(ctx, target.ID, target.Content, merged.Content)
+	h.recordChanges(ctx, target.ID, userID, target.Title, target.Content, merged)
+	for _, n := range sources[1:] {
+		h.activity.Record(ctx, n.ID, userID, activity.ActionDeleted, nil)
+		h.announceDeleted(ctx, n.ID, userID)
+	}
+
+	return c.JSON(MergeNotesResult{ID: target.ID, Version: version})
+}
+
+// errMergeRaced rolls back a merge that lost a race with another change to
+// one of its notes
+var errMergeRaced = errors.New("notes changed while merging")
+
+// mergeSources loads the user's private notes with the given IDs, in the
+// same order
+func (h *Handler) mergeSources(ctx context.Context, userID string, ids []string) ([]Note, error) {
+	args := []any{userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT "+noteColumns+" FROM notes WHERE user_id = ? AND workspace_id IS NULL AND id IN ("+placeholders+")",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching notes: %w", err)
+	}
+	defer closeRows(rows)
+
+	byID := make(map[string]Note)
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning note: %w", err)
+		}
+		byID[n.ID] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating notes: %w", err)
+	}
+
+	notes := make([]Note, 0, len(ids))
+	for _, id := range ids {
+		n, ok := byID[id]
+		if !ok {
+			return nil, apperr.Newf(fiber.StatusNotFound, "Note %s not found or not one of your private notes", id)
+		}
+		notes = append(notes, n)
+	}
+	return notes, nil
+}
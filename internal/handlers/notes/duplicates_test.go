@@ -0,0 +1,195 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDuplicates(t *testing.T) {
+	query := regexp.QuoteMeta("SELECT id, title, content, updated_at FROM notes WHERE user_id = ? AND workspace_id IS NULL AND encrypted = ? ORDER BY updated_at DESC LIMIT ?")
+	now := time.Now()
+
+	t.Run("Groups Alike Notes", func(t *testing.T) {
+		helper := newTestHelper(t)
+		helper.setupRoute("GET", "/notes/duplicates", helper.handler.GetDuplicates)
+
+		helper.mockDB.ExpectQuery(query).WithArgs("user123", false, DuplicateScanLimit).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "updated_at"}).
+				AddRow("note1", "Groceries", "eggs milk bread butter and jam", now).
+				AddRow("note2", "Standup", "ship the release and fix the login bug", now).
+				AddRow("note3", "Groceries", "eggs milk bread butter and jam", now).
+				AddRow("note4", "Empty", nil, now))
+
+		resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/duplicates", nil))
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		var groups []DuplicateGroup
+		if err := json.NewDecoder(resp.Body).Decode(&groups); err != nil {
+			t.Fatalf("error decoding response: %v", err)
+		}
+		if assert.Len(t, groups, 1) {
+			assert.Equal(t, 1.0, groups[0].Similarity)
+			if assert.Len(t, groups[0].Notes, 2) {
+				assert.Equal(t, "note1", groups[0].Notes[0].ID)
+				assert.Equal(t, "note3", groups[0].Notes[1].ID)
+			}
+		}
+
+		if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Invalid Threshold", func(t *testing.T) {
+		helper := newTestHelper(t)
+		helper.setupRoute("GET", "/notes/duplicates", helper.handler.GetDuplicates)
+
+		resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/duplicates?threshold=2", nil))
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestMergeNotes(t *testing.T) {
+	selectQuery := regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE user_id = ? AND workspace_id IS NULL AND id IN (?, ?)")
+	snapshotTarget := regexp.QuoteMeta("INSERT INTO note_revisions (note_id, version, user_id, title, content) SELECT id, version, ?, title, content FROM notes WHERE id = ? AND NOT EXISTS (SELECT 1 FROM note_revisions WHERE note_id = ? AND version = ?)")
+	insertSource := regexp.QuoteMeta("INSERT INTO note_revisions (note_id, version, user_id, title, content) VALUES (?, ?, ?, ?, ?)")
+	updateQuery := regexp.QuoteMeta("UPDATE notes SET title = ?, content = ?, size = ?, word_count = ?, char_count = ?, version = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?")
+	deleteQuery := regexp.QuoteMeta("DELETE FROM notes WHERE user_id = ? AND workspace_id IS NULL AND id IN (?)")
+	now := time.Now()
+	notes := func(encrypted bool) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}).
+			AddRow("note2", "user123", nil, "Copy", "milk\n", false, false, 1, now, now, 1, 5, encrypted, nil, nil, nil).
+			AddRow("note1", "user123", nil, "List", "eggs\n", false, false, 3, now, now, 1, 5, false, nil, nil, nil)
+	}
+
+	testCases := []struct {
+		name           string
+		body           string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedError  string
+		activities     []activity.Action
+	}{
+		{
+			name: "Merged",
+			body: `{"note_ids":["note1","note2"]}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("user123", "note1", "note2").WillReturnRows(notes(false))
+				mock.ExpectBegin()
+				mock.ExpectExec(snapshotTarget).WithArgs("user123", "note1", "note1", int64(3)).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insertSource).WithArgs("note1", int64(4), "user123", "Copy", "milk\n").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(updateQuery).WithArgs("List", "eggs\n\nmilk", int64(14), int64(2), int64(10), int64(5), "note1", int64(3)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectRevision(mock, "note1")
+				expectChange(mock, "updated", "id = ?", "note1")
+				expectChange(mock, "deleted", "user_id = ? AND workspace_id IS NULL AND id IN (?)", "user123", "note2")
+				mock.ExpectExec(deleteQuery).WithArgs("user123", "note2").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusOK,
+			activities:     []activity.Action{activity.ActionEdited, activity.ActionDeleted},
+		},
+		{
+			name: "Raced",
+			body: `{"note_ids":["note1","note2"]}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("user123", "note1", "note2").WillReturnRows(notes(false))
+				mock.ExpectBegin()
+				mock.ExpectExec(snapshotTarget).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insertSource).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(updateQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			expectedStatus: fiber.StatusConflict,
+			expectedError:  "Notes changed while merging, try again",
+		},
+		{
+			name: "Encrypted",
+			body: `{"note_ids":["note1","note2"]}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("user123", "note1", "note2").WillReturnRows(notes(true))
+			},
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "Encrypted notes cannot be merged by the server",
+		},
+		{
+			name: "Not Found",
+			body: `{"note_ids":["note1","note9"]}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("user123", "note1", "note9").WillReturnRows(notes(false))
+			},
+			expectedStatus: fiber.StatusNotFound,
+			expectedError:  "Note note9 not found or not one of your private notes",
+		},
+		{
+			name:           "Repeated Note",
+			body:           `{"note_ids":["note1","note1"]}`,
+			setupMock:      func(mock sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Single Note",
+			body:           `{"note_ids":["note1"]}`,
+			setupMock:      func(mock sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("POST", "/notes/merge", helper.handler.MergeNotes)
+			tc.setupMock(helper.mockDB)
+
+			req := httptest.NewRequest("POST", "/notes/merge", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var result MergeNotesResult
+				if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, MergeNotesResult{ID: "note1", Version: 5}, result)
+			}
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+
+			var actions []activity.Action
+			for _, r := range helper.recorder.recorded {
+				actions = append(actions, r.action)
+			}
+			assert.Equal(t, tc.activities, actions)
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
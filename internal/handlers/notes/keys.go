@@ -0,0 +1,223 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/changelog"
+	"quanta/internal/db"
+	"quanta/internal/quota"
+	"quanta/internal/validate"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// KeyPayload is the request body for ShareKey. The wrapped key is opaque
+// to the server.
+type KeyPayload struct {
+	WrappedKey string `json:"wrapped_key" validate:"required,max=4096"`
+}
+
+// KeyHolder is someone with access to an encrypted note, with the public
+// key to wrap the note key for them. PublicKey is null until they publish
+// one on their profile.
+type KeyHolder struct {
+	UserID    string  `json:"user_id"`
+	PublicKey *string `json:"public_key"`
+	HasKey    bool    `json:"has_key"`
+}
+
+// NoteKeys is the response to GetKeys. WrappedKey is the caller's copy of
+// the note key, or null if nobody has shared it with them yet.
+type NoteKeys struct {
+	WrappedKey    *string     `json:"wrapped_key"`
+	Collaborators []KeyHolder `json:"collaborators"`
+}
+
+// createEncrypted stores an encrypted note written by the user, in
+// workspaceID if set, along with the user's copy of its key. Its content
+// is ciphertext, so it gets no statistics.
+func (h *Handler) createEncrypted(ctx context.Context, userID string, workspaceID *string, payload CreatePayload) (string, error) {
+	if err := h.validateNote(ctx, &payload.NotePayload); err != nil {
+		return "", err
+	}
+	key := KeyPayload{WrappedKey: payload.WrappedKey}
+	if errs := validate.Struct(&key); errs != nil {
+		return "", apperr.Invalid(errs)
+	}
+
+	size := quota.NoteSize(payload.Title, payload.Content)
+	if err := h.quota.Check(ctx, h.db, userID, workspaceID, size); err != nil {
+		return "", err
+	}
+
+	id := uuid.New().String()
+	err := db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO notes (id, user_id, workspace_id, title, content, size, encrypted) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			id, userID, workspaceID, payload.Title, payload.Content, size, true)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO note_keys (note_id, user_id, wrapped_key) VALUES (?, ?, ?)", id, userID, key.WrappedKey)
+		if err != nil {
+			return err
+		}
+		if err := snapshotRevision(ctx, tx, id, userID); err != nil {
+			return err
+		}
+		return changelog.Record(ctx, tx, changelog.Created, "id = ?", id)
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating encrypted note: %w", err)
+	}
+
+	h.activity.Record(ctx, id, userID, activity.ActionCreated, nil)
+
+	return id, nil
+}
+
+// GetKeys lists everyone with access to an encrypted note, with their
+// public keys and whether they hold the note key yet, so that a holder can
+// share it with the rest. It also returns the caller's own wrapped copy.
+func (h *Handler) GetKeys(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+	ctx := c.UserContext()
+
+	owner, workspaceID, err := h.encryptedNote(ctx, userID, noteID)
+	if err != nil {
+		return err
+	}
+
+	collaborators, arg := collaboratorsOf(owner, workspaceID)
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT u.id, u.public_key, k.wrapped_key FROM users u LEFT JOIN note_keys k ON k.note_id = ? AND k.user_id = u.id WHERE u.deleted_at IS NULL AND "+collaborators+" ORDER BY u.id",
+		noteID, arg,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching note keys: %w", err)
+	}
+	defer closeRows(rows)
+
+	keys := NoteKeys{Collaborators: []KeyHolder{}}
+	for rows.Next() {
+		var holder KeyHolder
+		var publicKey, wrappedKey sql.NullString
+		if err := rows.Scan(&holder.UserID, &publicKey, &wrappedKey); err != nil {
+			return fmt.Errorf("scanning note key: %w", err)
+		}
+		if publicKey.Valid {
+			holder.PublicKey = &publicKey.String
+		}
+		holder.HasKey = wrappedKey.Valid
+		if holder.UserID == userID && wrappedKey.Valid {
+			keys.WrappedKey = &wrappedKey.String
+		}
+		keys.Collaborators = append(keys.Collaborators, holder)
+	}
+
+	return c.JSON(keys)
+}
+
+// ShareKey stores another user's copy of an encrypted note's key, wrapped
+// by the caller with that user's public key. Only someone holding the key
+// can share it, and only with a user who can access the note and has
+// published a public key. Sharing again replaces the copy, which is how
+// clients rotate a note's key.
+func (h *Handler) ShareKey(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID, targetID := c.Params("id"), c.Params("userId")
+	ctx := c.UserContext()
+
+	var payload KeyPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if errs := validate.Struct(&payload); errs != nil {
+		return apperr.Invalid(errs)
+	}
+
+	owner, workspaceID, err := h.encryptedNote(ctx, userID, noteID)
+	if err != nil {
+		return err
+	}
+
+	var holds bool
+	err = h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM note_keys WHERE note_id = ? AND user_id = ?)", noteID, userID).Scan(&holds)
+	if err != nil {
+		return fmt.Errorf("checking note key: %w", err)
+	}
+	if !holds {
+		return apperr.New(fiber.StatusForbidden, "Only collaborators holding the note key can share it")
+	}
+
+	collaborators, arg := collaboratorsOf(owner, workspaceID)
+	var publicKey sql.NullString
+	err = h.db.QueryRowContext(ctx, "SELECT u.public_key FROM users u WHERE u.id = ? AND u.deleted_at IS NULL AND "+collaborators, targetID, arg).
+		Scan(&publicKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "User not found or cannot access this note")
+		}
+		return fmt.Errorf("fetching public key: %w", err)
+	}
+	if !publicKey.Valid {
+		return apperr.New(fiber.StatusConflict, "User has not published a public key")
+	}
+
+	result, err := h.db.ExecContext(ctx, "UPDATE note_keys SET wrapped_key = ? WHERE note_id = ? AND user_id = ?", payload.WrappedKey, noteID, targetID)
+	if err == nil {
+		if n, _ := result.RowsAffected(); n == 0 {
+			_, err = h.db.ExecContext(ctx, "INSERT INTO note_keys (note_id, user_id, wrapped_key) VALUES (?, ?, ?)", noteID, targetID, payload.WrappedKey)
+		}
+	}
+	// A duplicate means the row exists, either from a concurrent share or
+	// because MySQL counts an unchanged row as unaffected
+	if err != nil && !db.IsDuplicate(err) {
+		return fmt.Errorf("sharing note key: %w", err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// encryptedNote looks up an encrypted note the user can access, returning
+// its author and workspace
+func (h *Handler) encryptedNote(ctx context.Context, userID, noteID string) (string, sql.NullString, error) {
+	var owner string
+	var workspaceID sql.NullString
+	var encrypted bool
+	err := h.db.QueryRowContext(ctx, "SELECT user_id, workspace_id, encrypted FROM notes WHERE id = ? AND "+accessible, noteID, userID, userID).
+		Scan(&owner, &workspaceID, &encrypted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", workspaceID, apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+		}
+		return "", workspaceID, fmt.Errorf("fetching note: %w", err)
+	}
+	if !encrypted {
+		return "", workspaceID, apperr.New(fiber.StatusBadRequest, "Note is not encrypted")
+	}
+	return owner, workspaceID, nil
+}
+
+// collaboratorsOf returns a condition on users u matching everyone who can
+// access a note, and its argument: the members of the note's workspace, or
+// the author of a private note
+func collaboratorsOf(owner string, workspaceID sql.NullString) (string, any) {
+	if workspaceID.Valid {
+		return "u.id IN (SELECT user_id FROM workspace_members WHERE workspace_id = ?)", workspaceID.String
+	}
+	return "u.id = ?", owner
+}
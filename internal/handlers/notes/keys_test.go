@@ -0,0 +1,217 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"quanta/internal/activity"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var encryptedNoteQuery = regexp.QuoteMeta("SELECT user_id, workspace_id, encrypted FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+
+func TestCreateNote_Encrypted(t *testing.T) {
+	insertNote := regexp.QuoteMeta("INSERT INTO notes (id, user_id, workspace_id, title, content, size, encrypted) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	insertKey := regexp.QuoteMeta("INSERT INTO note_keys (note_id, user_id, wrapped_key) VALUES (?, ?, ?)")
+
+	testCases := []struct {
+		name           string
+		body           string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Success",
+			body: `{"title":"bm9wZQ==","content":"Y2lwaGVydGV4dA==","encrypted":true,"wrapped_key":"d3JhcHBlZA=="}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(insertNote).
+					WithArgs(sqlmock.AnyArg(), "user123", nil, "bm9wZQ==", "Y2lwaGVydGV4dA==", int64(24), true).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(insertKey).WithArgs(sqlmock.AnyArg(), "user123", "d3JhcHBlZA==").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectRevision(mock, sqlmock.AnyArg())
+				expectChange(mock, "created", "id = ?", sqlmock.AnyArg())
+				mock.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Missing Wrapped Key",
+			body:           `{"title":"bm9wZQ==","content":"Y2lwaGVydGV4dA==","encrypted":true}`,
+			setupMock:      func(sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("POST", "/notes", helper.handler.CreateNote)
+			tc.setupMock(helper.mockDB)
+
+			req := httptest.NewRequest("POST", "/notes", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusCreated {
+				assert.Len(t, helper.recorder.recorded, 1)
+				assert.Equal(t, activity.ActionCreated, helper.recorder.recorded[0].action)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetKeys(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.setupRoute("GET", "/notes/:id/keys", helper.handler.GetKeys)
+
+	helper.mockDB.ExpectQuery(encryptedNoteQuery).WithArgs("note1", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "workspace_id", "encrypted"}).AddRow("user456", "ws1", true))
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT u.id, u.public_key, k.wrapped_key FROM users u LEFT JOIN note_keys k ON k.note_id = ? AND k.user_id = u.id WHERE u.deleted_at IS NULL AND u.id IN (SELECT user_id FROM workspace_members WHERE workspace_id = ?) ORDER BY u.id")).
+		WithArgs("note1", "ws1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "public_key", "wrapped_key"}).
+			AddRow("user123", "pk-123", "wrapped-for-123").
+			AddRow("user456", "pk-456", "wrapped-for-456").
+			AddRow("user789", nil, nil))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/note1/keys", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var keys NoteKeys
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.NotNil(t, keys.WrappedKey) {
+		assert.Equal(t, "wrapped-for-123", *keys.WrappedKey)
+	}
+	assert.Len(t, keys.Collaborators, 3)
+	assert.True(t, keys.Collaborators[1].HasKey)
+	assert.False(t, keys.Collaborators[2].HasKey)
+	assert.Nil(t, keys.Collaborators[2].PublicKey)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestShareKey(t *testing.T) {
+	holds := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM note_keys WHERE note_id = ? AND user_id = ?)")
+	publicKey := regexp.QuoteMeta("SELECT u.public_key FROM users u WHERE u.id = ? AND u.deleted_at IS NULL AND u.id IN (SELECT user_id FROM workspace_members WHERE workspace_id = ?)")
+	update := regexp.QuoteMeta("UPDATE note_keys SET wrapped_key = ? WHERE note_id = ? AND user_id = ?")
+	insert := regexp.QuoteMeta("INSERT INTO note_keys (note_id, user_id, wrapped_key) VALUES (?, ?, ?)")
+	note := func(encrypted bool) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"user_id", "workspace_id", "encrypted"}).AddRow("user123", "ws1", encrypted)
+	}
+
+	testCases := []struct {
+		name           string
+		body           string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "First Share",
+			body: `{"wrapped_key":"wrapped-for-456"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(encryptedNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(true))
+				mock.ExpectQuery(holds).WithArgs("note1", "user123").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(publicKey).WithArgs("user456", "ws1").WillReturnRows(sqlmock.NewRows([]string{"public_key"}).AddRow("pk-456"))
+				mock.ExpectExec(update).WithArgs("wrapped-for-456", "note1", "user456").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insert).WithArgs("note1", "user456", "wrapped-for-456").WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			expectedStatus: fiber.StatusNoContent,
+		},
+		{
+			name: "Replace",
+			body: `{"wrapped_key":"rotated"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(encryptedNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(true))
+				mock.ExpectQuery(holds).WithArgs("note1", "user123").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(publicKey).WithArgs("user456", "ws1").WillReturnRows(sqlmock.NewRows([]string{"public_key"}).AddRow("pk-456"))
+				mock.ExpectExec(update).WithArgs("rotated", "note1", "user456").WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedStatus: fiber.StatusNoContent,
+		},
+		{
+			name: "Caller Holds No Key",
+			body: `{"wrapped_key":"wrapped-for-456"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(encryptedNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(true))
+				mock.ExpectQuery(holds).WithArgs("note1", "user123").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name: "No Public Key",
+			body: `{"wrapped_key":"wrapped-for-456"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(encryptedNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(true))
+				mock.ExpectQuery(holds).WithArgs("note1", "user123").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(publicKey).WithArgs("user456", "ws1").WillReturnRows(sqlmock.NewRows([]string{"public_key"}).AddRow(nil))
+			},
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name: "Recipient Cannot Access",
+			body: `{"wrapped_key":"wrapped-for-456"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(encryptedNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(true))
+				mock.ExpectQuery(holds).WithArgs("note1", "user123").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectQuery(publicKey).WithArgs("user456", "ws1").WillReturnRows(sqlmock.NewRows([]string{"public_key"}))
+			},
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name: "Note Not Encrypted",
+			body: `{"wrapped_key":"wrapped-for-456"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(encryptedNoteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(false))
+			},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Missing Wrapped Key",
+			body:           `{}`,
+			setupMock:      func(sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("PUT", "/notes/:id/keys/:userId", helper.handler.ShareKey)
+			tc.setupMock(helper.mockDB)
+
+			req := httptest.NewRequest("PUT", "/notes/note1/keys/user456", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
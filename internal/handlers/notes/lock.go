@@ -0,0 +1,174 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/realtime"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// DefaultLockTTL is how long a lock lasts when no ttl_seconds is given
+	DefaultLockTTL = 10 * time.Minute
+	// MaxLockTTL is the longest a lock can be taken or renewed for
+	MaxLockTTL = time.Hour
+)
+
+// LockPayload is the optional request body for LockNote
+type LockPayload struct {
+	TTLSeconds int `json:"ttl_seconds"`
+}
+
+// NoteLock is the response to LockNote
+type NoteLock struct {
+	NoteID    string    `json:"note_id"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// errNoteLocked answers a write to a note someone else has locked
+var errNoteLocked = apperr.New(fiber.StatusLocked, "Note is locked by another user")
+
+// LockNote gives the user exclusive editing of a note until they unlock it
+// or the lock expires. Until then updates from everyone else are refused
+// and their realtime connections are read-only. Locking a note the user
+// already holds renews the lock.
+func (h *Handler) LockNote(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+
+	var payload LockPayload
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+		}
+	}
+	ttl := DefaultLockTTL
+	if payload.TTLSeconds != 0 {
+		ttl = time.Duration(payload.TTLSeconds) * time.Second
+		if ttl < 0 || ttl > MaxLockTTL {
+			return apperr.Invalid(map[string]string{"ttl_seconds": fmt.Sprintf("must be between 1 and %d", int(MaxLockTTL.Seconds()))})
+		}
+	}
+
+	ctx := c.UserContext()
+	if err := h.requireAccess(ctx, noteID, userID); err != nil {
+		return err
+	}
+	if err := h.requireEditor(ctx, noteID, userID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	lock := NoteLock{NoteID: noteID, UserID: userID, ExpiresAt: now.Add(ttl).UTC().Truncate(time.Second)}
+
+	// Take over a lock that is ours or has expired, else create one. The
+	// primary key refuses a second lock taken concurrently.
+	result, err := h.db.ExecContext(ctx,
+		"UPDATE note_locks SET user_id = ?, expires_at = ? WHERE note_id = ? AND (user_id = ? OR expires_at <= ?)",
+		userID, lock.ExpiresAt, noteID, userID, now,
+	)
+	if err != nil {
+		return fmt.Errorf("renewing note lock: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		_, err := h.db.ExecContext(ctx,
+			"INSERT INTO note_locks (note_id, user_id, expires_at) VALUES (?, ?, ?)",
+			noteID, userID, lock.ExpiresAt,
+		)
+		if db.IsDuplicate(err) {
+			return errNoteLocked
+		}
+		if err != nil {
+			return fmt.Errorf("locking note: %w", err)
+		}
+	}
+
+	if h.rooms != nil {
+		h.rooms.SetLock(ctx, noteID, &realtime.Lock{UserID: userID, ExpiresAt: lock.ExpiresAt})
+	}
+
+	return c.JSON(lock)
+}
+
+// UnlockNote releases a note's lock. The lock's holder and the note's
+// author can release it; unlocking a note nobody holds succeeds.
+func (h *Handler) UnlockNote(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+
+	ctx := c.UserContext()
+	if err := h.requireAccess(ctx, noteID, userID); err != nil {
+		return err
+	}
+
+	result, err := h.db.ExecContext(ctx,
+		"DELETE FROM note_locks WHERE note_id = ? AND (user_id = ? OR expires_at <= ? OR EXISTS(SELECT 1 FROM notes WHERE id = ? AND user_id = ?))",
+		noteID, userID, time.Now(), noteID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("unlocking note: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if err := h.checkLock(ctx, noteID, userID); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	if h.rooms != nil {
+		h.rooms.SetLock(ctx, noteID, nil)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// requireAccess returns a 404 unless the user can access the note
+func (h *Handler) requireAccess(ctx context.Context, noteID, userID string) error {
+	var exists bool
+	err := h.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND "+accessible+")",
+		noteID, userID, userID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("checking note access: %w", err)
+	}
+	if !exists {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+	return nil
+}
+
+// checkLock returns errNoteLocked if someone other than the user holds an
+// unexpired lock on the note
+func (h *Handler) checkLock(ctx context.Context, noteID, userID string) error {
+	var holder string
+	err := h.db.QueryRowContext(ctx,
+		"SELECT user_id FROM note_locks WHERE note_id = ? AND expires_at > ?",
+		noteID, time.Now(),
+	).Scan(&holder)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking note lock: %w", err)
+	}
+	if holder != userID {
+		return errNoteLocked
+	}
+	return nil
+}
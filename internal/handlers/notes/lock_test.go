@@ -0,0 +1,186 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var accessQuery = regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)))")
+
+func TestLockNote(t *testing.T) {
+	renewQuery := regexp.QuoteMeta("UPDATE note_locks SET user_id = ?, expires_at = ? WHERE note_id = ? AND (user_id = ? OR expires_at <= ?)")
+	insertQuery := regexp.QuoteMeta("INSERT INTO note_locks (note_id, user_id, expires_at) VALUES (?, ?, ?)")
+
+	testCases := []struct {
+		name           string
+		body           string
+		access         bool
+		renewed        int64
+		insertErr      error
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "New Lock",
+			access:         true,
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Renewed",
+			body:           `{"ttl_seconds":60}`,
+			access:         true,
+			renewed:        1,
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Held By Another User",
+			access:         true,
+			insertErr:      &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+			expectedStatus: fiber.StatusLocked,
+			expectedError:  "Note is locked by another user",
+		},
+		{
+			name:           "No Access",
+			expectedStatus: fiber.StatusNotFound,
+			expectedError:  "Note not found or unauthorized",
+		},
+		{
+			name:           "TTL Too Long",
+			body:           `{"ttl_seconds":7200}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("POST", "/notes/:id/lock", helper.handler.LockNote)
+
+			if tc.expectedStatus != fiber.StatusUnprocessableEntity {
+				helper.mockDB.ExpectQuery(accessQuery).WithArgs("note1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.access))
+			}
+			if tc.access {
+				helper.expectRole("note1", "")
+				helper.mockDB.ExpectExec(renewQuery).
+					WithArgs("user123", sqlmock.AnyArg(), "note1", "user123", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, tc.renewed))
+				if tc.renewed == 0 {
+					insert := helper.mockDB.ExpectExec(insertQuery).WithArgs("note1", "user123", sqlmock.AnyArg())
+					if tc.insertErr != nil {
+						insert.WillReturnError(tc.insertErr)
+					} else {
+						insert.WillReturnResult(sqlmock.NewResult(1, 1))
+					}
+				}
+			}
+
+			req := httptest.NewRequest("POST", "/notes/note1/lock", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var lock NoteLock
+				if err := json.NewDecoder(resp.Body).Decode(&lock); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, "user123", lock.UserID)
+				assert.True(t, lock.ExpiresAt.After(time.Now()))
+				if assert.Len(t, helper.rooms.announced, 1) {
+					assert.Equal(t, "user123", helper.rooms.announced[0].UserID)
+					assert.Equal(t, lock.ExpiresAt, helper.rooms.announced[0].ExpiresAt)
+				}
+			} else {
+				assert.Empty(t, helper.rooms.announced)
+			}
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestUnlockNote(t *testing.T) {
+	deleteQuery := regexp.QuoteMeta("DELETE FROM note_locks WHERE note_id = ? AND (user_id = ? OR expires_at <= ? OR EXISTS(SELECT 1 FROM notes WHERE id = ? AND user_id = ?))")
+
+	testCases := []struct {
+		name           string
+		deleted        int64
+		lockedBy       string
+		expectedStatus int
+		announced      bool
+	}{
+		{
+			name:           "Released",
+			deleted:        1,
+			expectedStatus: fiber.StatusNoContent,
+			announced:      true,
+		},
+		{
+			name:           "Not Locked",
+			expectedStatus: fiber.StatusNoContent,
+		},
+		{
+			name:           "Held By Another User",
+			lockedBy:       "user456",
+			expectedStatus: fiber.StatusLocked,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("POST", "/notes/:id/unlock", helper.handler.UnlockNote)
+
+			helper.mockDB.ExpectQuery(accessQuery).WithArgs("note1", "user123", "user123").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			helper.mockDB.ExpectExec(deleteQuery).
+				WithArgs("note1", "user123", sqlmock.AnyArg(), "note1", "user123").
+				WillReturnResult(sqlmock.NewResult(0, tc.deleted))
+			if tc.deleted == 0 {
+				helper.expectLock("note1", tc.lockedBy)
+			}
+
+			resp, err := helper.app.Test(httptest.NewRequest("POST", "/notes/note1/unlock", nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.announced {
+				if assert.Len(t, helper.rooms.announced, 1) {
+					assert.Nil(t, helper.rooms.announced[0])
+				}
+			} else {
+				assert.Empty(t, helper.rooms.announced)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
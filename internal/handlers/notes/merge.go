@@ -0,0 +1,149 @@
+package notes
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/changelog"
+	"quanta/internal/merge"
+	"quanta/internal/quota"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxMergeAttempts is how many times a merge is retried when another write
+// lands between reading the note and storing the merge
+const MaxMergeAttempts = 3
+
+// MergePayload is the request body for PUT /notes/:id?mode=merge. The
+// client sends the version and content it started editing from along with
+// its new title and content.
+type MergePayload struct {
+	Title       string `json:"title"`
+	Content     string `json:"content"`
+	BaseVersion int64  `json:"base_version"`
+	BaseContent string `json:"base_content"`
+}
+
+// MergeResult is the response to a merge that was stored. Merged is false
+// when nobody changed the note since the base version, so the client's
+// content was stored as it was.
+type MergeResult struct {
+	Version int64  `json:"version"`
+	Content string `json:"content"`
+	Merged  bool   `json:"merged"`
+}
+
+// MergeConflict is the response to a merge that conflicts with changes
+// made since the base version. Nothing is stored. Content is the merged
+// document with each conflicting region between conflict markers, and
+// Version is the stored version to use as the base of the next attempt.
+type MergeConflict struct {
+	apperr.Response
+	Version   int64            `json:"version"`
+	Content   string           `json:"content"`
+	Conflicts []merge.Conflict `json:"conflicts"`
+}
+
+// mergeNote merges the client's changes into a note with a line-based
+// three-way merge against its base content, for clients that autosave
+// without the realtime protocol. Changes to separate lines are combined;
+// the title is taken from the client.
+func (h *Handler) mergeNote(c *fiber.Ctx) error {
+	var payload MergePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.BaseVersion < 1 {
+		return apperr.Invalid(map[string]string{"base_version": "required"})
+	}
+	note := NotePayload{Title: payload.Title, Content: payload.Content}
+	if err := h.validateNote(c.UserContext(), &note); err != nil {
+		return err
+	}
+	// Stored content is trimmed, so the base has to be too
+	base := strings.TrimSpace(payload.BaseContent)
+
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+	ctx := c.UserContext()
+
+	for range MaxMergeAttempts {
+		var oldTitle, oldContent string
+		var workspaceID sql.NullString
+		var version int64
+		var encrypted bool
+		err := h.db.QueryRowContext(ctx, "SELECT title, content, workspace_id, version, encrypted FROM notes WHERE id = ? AND "+accessible, noteID, userID, userID).
+			Scan(&oldTitle, &oldContent, &workspaceID, &version, &encrypted)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+			}
+			return fmt.Errorf("fetching note: %w", err)
+		}
+		// Lines of ciphertext mean nothing, so encrypted notes are merged
+		// by their clients
+		if encrypted {
+			return apperr.New(fiber.StatusBadRequest, "Encrypted notes cannot be merged by the server")
+		}
+		if err := h.requireEditor(ctx, noteID, userID); err != nil {
+			return err
+		}
+		if err := h.checkLock(ctx, noteID, userID); err != nil {
+			return err
+		}
+
+		merged := note
+		if version != payload.BaseVersion {
+			res := merge.Merge(base, oldContent, note.Content)
+			if len(res.Conflicts) > 0 {
+				return c.Status(fiber.StatusConflict).JSON(MergeConflict{
+					Response:  apperr.ResponseFor(c, apperr.New(fiber.StatusConflict, "Changes conflict with edits made since the base version")),
+					Version:   version,
+					Content:   res.Text,
+					Conflicts: res.Conflicts,
+				})
+			}
+			merged.Content = res.Text
+			if err := h.checkLimits(merged); err != nil {
+				return err
+			}
+		}
+
+		size := quota.NoteSize(merged.Title, merged.Content)
+		var chargeTo *string
+		if workspaceID.Valid {
+			chargeTo = &workspaceID.String
+		}
+		if err := h.quota.Check(ctx, h.db, userID, chargeTo, size-quota.NoteSize(oldTitle, oldContent)); err != nil {
+			return err
+		}
+
+		// The version guard catches a writer that got in since the read,
+		// in which case the merge is redone against their content
+		stats := statsOf(merged.Content)
+		result, err := h.db.ExecContext(ctx, "UPDATE notes SET title = ?, content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?",
+			merged.Title, merged.Content, size, stats.WordCount, stats.CharCount, noteID, version)
+		if err != nil {
+			return fmt.Errorf("updating note: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+
+		h.recordRevision(ctx, noteID, userID)
+		h.recordChange(ctx, changelog.Updated, noteID)
+		h.recordTasks(ctx, noteID, oldContent, merged.Content)
+		h.recordChanges(ctx, noteID, userID, oldTitle, oldContent, merged)
+		return c.JSON(MergeResult{Version: version + 1, Content: merged.Content, Merged: version != payload.BaseVersion})
+	}
+
+	return apperr.New(fiber.StatusConflict, "Note is changing too quickly to merge, try again")
+}
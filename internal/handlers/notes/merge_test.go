@@ -0,0 +1,188 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"quanta/internal/activity"
+	"quanta/internal/merge"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeNote(t *testing.T) {
+	selectQuery := regexp.QuoteMeta("SELECT title, content, workspace_id, version, encrypted FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+	updateQuery := regexp.QuoteMeta("UPDATE notes SET title = ?, content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?")
+	expectEditor := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT role FROM note_collaborators WHERE note_id = ? AND user_id = ?")).
+			WithArgs("note1", "user123").
+			WillReturnRows(sqlmock.NewRows([]string{"role"}))
+	}
+	expectUnlocked := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM note_locks WHERE note_id = ? AND expires_at > ?")).
+			WithArgs("note1", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	}
+	stored := func(content string, version int64) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"title", "content", "workspace_id", "version", "encrypted"}).AddRow("T", content, nil, version, false)
+	}
+
+	testCases := []struct {
+		name           string
+		body           string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedResult *MergeResult
+		conflicts      []merge.Conflict
+		activities     []activity.Action
+	}{
+		{
+			name: "Unchanged Since Base",
+			body: `{"title":"T","content":"a\nB","base_version":2,"base_content":"a\nb"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("note1", "user123", "user123").WillReturnRows(stored("a\nb", 2))
+				expectEditor(mock)
+				expectUnlocked(mock)
+				mock.ExpectExec(updateQuery).WithArgs("T", "a\nB", int64(4), int64(2), int64(3), "note1", int64(2)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectRevision(mock, "note1")
+				expectChange(mock, "updated", "id = ?", "note1")
+			},
+			expectedStatus: fiber.StatusOK,
+			expectedResult: &MergeResult{Version: 3, Content: "a\nB"},
+			activities:     []activity.Action{activity.ActionEdited},
+		},
+		{
+			name: "Merged",
+			body: `{"title":"T","content":"a\nb\nC","base_version":2,"base_content":"a\nb\nc"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("note1", "user123", "user123").WillReturnRows(stored("A\nb\nc", 3))
+				expectEditor(mock)
+				expectUnlocked(mock)
+				mock.ExpectExec(updateQuery).WithArgs("T", "A\nb\nC", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "note1", int64(3)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectRevision(mock, "note1")
+				expectChange(mock, "updated", "id = ?", "note1")
+			},
+			expectedStatus: fiber.StatusOK,
+			expectedResult: &MergeResult{Version: 4, Content: "A\nb\nC", Merged: true},
+			activities:     []activity.Action{activity.ActionEdited},
+		},
+		{
+			name: "Retried After Concurrent Write",
+			body: `{"title":"T","content":"a\nb\nc\nd\nE","base_version":2,"base_content":"a\nb\nc\nd\ne"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("note1", "user123", "user123").WillReturnRows(stored("A\nb\nc\nd\ne", 3))
+				expectEditor(mock)
+				expectUnlocked(mock)
+				mock.ExpectExec(updateQuery).WithArgs("T", "A\nb\nc\nd\nE", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "note1", int64(3)).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(selectQuery).WithArgs("note1", "user123", "user123").WillReturnRows(stored("A\nb\nC\nd\ne", 4))
+				expectEditor(mock)
+				expectUnlocked(mock)
+				mock.ExpectExec(updateQuery).WithArgs("T", "A\nb\nC\nd\nE", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "note1", int64(4)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectRevision(mock, "note1")
+				expectChange(mock, "updated", "id = ?", "note1")
+			},
+			expectedStatus: fiber.StatusOK,
+			expectedResult: &MergeResult{Version: 5, Content: "A\nb\nC\nd\nE", Merged: true},
+			activities:     []activity.Action{activity.ActionEdited},
+		},
+		{
+			name: "Conflict",
+			body: `{"title":"T","content":"a\nx","base_version":2,"base_content":"a\nb"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("note1", "user123", "user123").WillReturnRows(stored("a\ny", 3))
+				expectEditor(mock)
+				expectUnlocked(mock)
+			},
+			expectedStatus: fiber.StatusConflict,
+			conflicts:      []merge.Conflict{{Line: 2, Base: []string{"b"}, Ours: []string{"y"}, Theirs: []string{"x"}}},
+		},
+		{
+			name: "Encrypted",
+			body: `{"title":"T","content":"x","base_version":1,"base_content":""}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("note1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"title", "content", "workspace_id", "version", "encrypted"}).AddRow("T", "y", nil, 2, true))
+			},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name: "Not Found",
+			body: `{"title":"T","content":"x","base_version":1,"base_content":""}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(selectQuery).WithArgs("note1", "user123", "user123").WillReturnRows(sqlmock.NewRows([]string{"title"}))
+			},
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Missing Base Version",
+			body:           `{"title":"T","content":"x"}`,
+			setupMock:      func(sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("PUT", "/notes/:id", helper.handler.UpdateNote)
+			tc.setupMock(helper.mockDB)
+
+			req := httptest.NewRequest("PUT", "/notes/note1?mode=merge", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			switch {
+			case tc.expectedResult != nil:
+				var result MergeResult
+				if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, *tc.expectedResult, result)
+			case tc.conflicts != nil:
+				var conflict MergeConflict
+				if err := json.NewDecoder(resp.Body).Decode(&conflict); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, int64(3), conflict.Version)
+				assert.Equal(t, tc.conflicts, conflict.Conflicts)
+				assert.Contains(t, conflict.Content, merge.MarkerOurs)
+			}
+
+			var actions []activity.Action
+			for _, a := range helper.recorder.recorded {
+				actions = append(actions, a.action)
+			}
+			assert.Equal(t, tc.activities, actions)
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateNote_UnknownMode(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.setupRoute("PUT", "/notes/:id", helper.handler.UpdateNote)
+
+	req := httptest.NewRequest("PUT", "/notes/note1?mode=append", strings.NewReader(`{"title":"T"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := helper.app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,246 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/changelog"
+	"quanta/internal/db"
+	"quanta/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// metaPrefix starts the query parameters that filter a listing of notes by
+// their metadata, such as ?meta.project=alpha
+const metaPrefix = "meta."
+
+// Metadata is structured data a client attaches to a note. It is always a
+// JSON object, stored as-is in notes.metadata and never interpreted by the
+// server beyond filtering.
+type Metadata map[string]any
+
+// parseMetadata reads the metadata column, which is NULL for notes that
+// have none
+func parseMetadata(raw sql.NullString) (Metadata, error) {
+	if !raw.Valid || raw.String == "" {
+		return Metadata{}, nil
+	}
+	var m Metadata
+	if err := json.Unmarshal([]byte(raw.String), &m); err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+	if m == nil {
+		m = Metadata{}
+	}
+	return m, nil
+}
+
+// value returns m encoded for the metadata column, or nil to store NULL
+// when it is empty
+func (m Metadata) value() (any, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("encoding metadata: %w", err)
+	}
+	return string(b), nil
+}
+
+// checkMetadata rejects metadata with empty keys, that nests deeper than
+// models.MaxMetadataDepth or that is larger than models.MaxMetadataBytes
+func checkMetadata(m Metadata) *apperr.Error {
+	if err := checkValue(map[string]any(m), 1); err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return apperr.Invalid(map[string]string{"metadata": "must be a JSON object"})
+	}
+	if len(b) > models.MaxMetadataBytes {
+		return apperr.Newf(fiber.StatusRequestEntityTooLarge, "Note metadata exceeds the %d byte limit", models.MaxMetadataBytes)
+	}
+	return nil
+}
+
+// checkValue checks a value of metadata found depth levels down
+func checkValue(v any, depth int) *apperr.Error {
+	switch v := v.(type) {
+	case map[string]any:
+		if depth > models.MaxMetadataDepth {
+			return apperr.Invalid(map[string]string{"metadata": fmt.Sprintf("must nest at most %d levels deep", models.MaxMetadataDepth)})
+		}
+		for k, child := range v {
+			if strings.TrimSpace(k) == "" {
+				return apperr.Invalid(map[string]string{"metadata": "keys must not be empty"})
+			}
+			if err := checkValue(child, depth+1); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if depth > models.MaxMetadataDepth {
+			return apperr.Invalid(map[string]string{"metadata": fmt.Sprintf("must nest at most %d levels deep", models.MaxMetadataDepth)})
+		}
+		for _, child := range v {
+			if err := checkValue(child, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergePatch applies patch to target as a JSON merge patch (RFC 7396):
+// keys set to null are removed, objects are merged key by key and any
+// other value replaces what was there
+func mergePatch(target, patch map[string]any) map[string]any {
+	if target == nil {
+		target = map[string]any{}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(target, k)
+			continue
+		}
+		if obj, ok := v.(map[string]any); ok {
+			existing, _ := target[k].(map[string]any)
+			target[k] = mergePatch(existing, obj)
+			continue
+		}
+		target[k] = v
+	}
+	return target
+}
+
+// metadataFilters returns the ?meta.<key>=<value> parameters of a listing,
+// keyed by the dotted path below the metadata object
+func metadataFilters(c *fiber.Ctx) map[string]string {
+	var filters map[string]string
+	for k, v := range c.Queries() {
+		if path, ok := strings.CutPrefix(k, metaPrefix); ok && path != "" {
+			if filters == nil {
+				filters = map[string]string{}
+			}
+			filters[path] = v
+		}
+	}
+	return filters
+}
+
+// matches reports whether m has every filtered value. A path names nested
+// keys separated by dots; a scalar matches when its text equals the value
+// and an array when any of its scalars does.
+func (m Metadata) matches(filters map[string]string) bool {
+	for path, want := range filters {
+		var v any = map[string]any(m)
+		for _, key := range strings.Split(path, ".") {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return false
+			}
+			if v, ok = obj[key]; !ok {
+				return false
+			}
+		}
+		if !matchesValue(v, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesValue compares a metadata value with a filter's text
+func matchesValue(v any, want string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == want
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64) == want
+	case bool:
+		return strconv.FormatBool(v) == want
+	case []any:
+		for _, item := range v {
+			if _, nested := item.([]any); !nested && matchesValue(item, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PatchMetadata merges the request body, a JSON object, into a note's
+// metadata as a JSON merge patch and responds with the result. Changing
+// metadata moves the note's updated_at but not its version, as it is not
+// part of the note's revisions.
+func (h *Handler) PatchMetadata(c *fiber.Ctx) error {
+	var patch map[string]any
+	if err := json.Unmarshal(c.Body(), &patch); err != nil || patch == nil {
+		return apperr.New(fiber.StatusBadRequest, "Metadata patch must be a JSON object")
+	}
+
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	m, err := h.PatchNoteMetadata(c.UserContext(), userID, c.Params("id"), patch)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(m)
+}
+
+// PatchNoteMetadata merges patch into the metadata of a note the user can
+// edit and returns the merged metadata
+func (h *Handler) PatchNoteMetadata(ctx context.Context, userID, noteID string, patch map[string]any) (Metadata, error) {
+	if err := h.requireEditor(ctx, noteID, userID); err != nil {
+		return nil, err
+	}
+
+	var merged Metadata
+	err := db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		var raw sql.NullString
+		err := tx.QueryRowContext(ctx, "SELECT metadata FROM notes WHERE id = ? AND "+accessible, noteID, userID, userID).Scan(&raw)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+		}
+		if err != nil {
+			return fmt.Errorf("fetching note metadata: %w", err)
+		}
+		current, err := parseMetadata(raw)
+		if err != nil {
+			return err
+		}
+
+		merged = Metadata(mergePatch(current, patch))
+		if err := checkMetadata(merged); err != nil {
+			return err
+		}
+		value, err := merged.value()
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE notes SET metadata = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND "+accessible,
+			value, noteID, userID, userID)
+		if err != nil {
+			return fmt.Errorf("updating note metadata: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.recordChange(ctx, changelog.Updated, noteID)
+	return merged, nil
+}
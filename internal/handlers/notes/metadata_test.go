@@ -0,0 +1,226 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatchMetadata(t *testing.T) {
+	selectQuery := regexp.QuoteMeta("SELECT metadata FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+	updateQuery := regexp.QuoteMeta("UPDATE notes SET metadata = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+
+	testCases := []struct {
+		name           string
+		body           string
+		role           string
+		stored         any
+		found          bool
+		expectedStored any
+		expectedStatus int
+		expected       map[string]any
+	}{
+		{
+			name:           "Merges Into Existing",
+			body:           `{"project":"alpha","review":{"by":"bob"},"draft":null}`,
+			stored:         `{"draft":true,"review":{"due":"friday"},"priority":2}`,
+			found:          true,
+			expectedStored: `{"priority":2,"project":"alpha","review":{"by":"bob","due":"friday"}}`,
+			expectedStatus: fiber.StatusOK,
+			expected:       map[string]any{"priority": float64(2), "project": "alpha", "review": map[string]any{"by": "bob", "due": "friday"}},
+		},
+		{
+			name:           "First Metadata",
+			body:           `{"project":"alpha"}`,
+			stored:         nil,
+			found:          true,
+			expectedStored: `{"project":"alpha"}`,
+			expectedStatus: fiber.StatusOK,
+			expected:       map[string]any{"project": "alpha"},
+		},
+		{
+			name:           "Removing The Last Key Stores NULL",
+			body:           `{"project":null}`,
+			stored:         `{"project":"alpha"}`,
+			found:          true,
+			expectedStored: nil,
+			expectedStatus: fiber.StatusOK,
+			expected:       map[string]any{},
+		},
+		{
+			name:           "Not An Object",
+			body:           `["project"]`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Viewer",
+			body:           `{"project":"alpha"}`,
+			role:           "viewer",
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name:           "Note Not Found",
+			body:           `{"project":"alpha"}`,
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Too Deep",
+			body:           `{"a":{"b":{"c":{"d":{"e":1}}}}}`,
+			found:          true,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Empty Key",
+			body:           `{" ":1}`,
+			found:          true,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Too Large",
+			body:           `{"notes":"` + strings.Repeat("x", 9000) + `"}`,
+			found:          true,
+			expectedStatus: fiber.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("PATCH", "/notes/:id/metadata", helper.handler.PatchMetadata)
+
+			if tc.expectedStatus != fiber.StatusBadRequest {
+				helper.expectRole("note1", tc.role)
+			}
+			if tc.role == "" && tc.expectedStatus != fiber.StatusBadRequest {
+				helper.mockDB.ExpectBegin()
+				rows := sqlmock.NewRows([]string{"metadata"})
+				if tc.found {
+					rows.AddRow(tc.stored)
+				}
+				helper.mockDB.ExpectQuery(selectQuery).WithArgs("note1", "user123", "user123").WillReturnRows(rows)
+				if tc.expectedStatus == fiber.StatusOK {
+					helper.mockDB.ExpectExec(updateQuery).
+						WithArgs(tc.expectedStored, "note1", "user123", "user123").
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					helper.mockDB.ExpectCommit()
+					expectChange(helper.mockDB, "updated", "id = ?", "note1")
+				} else {
+					helper.mockDB.ExpectRollback()
+				}
+			}
+
+			req := httptest.NewRequest("PATCH", "/notes/note1/metadata", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expected != nil {
+				var got map[string]any
+				if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expected, got)
+			}
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetNotes_MetadataFilter(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/notes", helper.handler.GetNotes)
+
+	now := time.Now()
+	columns := []string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}
+	query := regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE user_id = ? AND workspace_id IS NULL AND archived = ? ORDER BY pinned DESC, updated_at DESC")
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{name: "No Filter", query: "", expected: []string{"note1", "note2", "note3"}},
+		{name: "String", query: "?meta.project=alpha", expected: []string{"note1", "note2"}},
+		{name: "Every Filter", query: "?meta.project=alpha&meta.priority=2", expected: []string{"note2"}},
+		{name: "Nested Path", query: "?meta.review.by=bob", expected: []string{"note1"}},
+		{name: "Array Item", query: "?meta.labels=urgent", expected: []string{"note3"}},
+		{name: "Boolean", query: "?meta.draft=true", expected: []string{"note3"}},
+		{name: "No Match", query: "?meta.project=beta", expected: []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper.mockDB.ExpectQuery(query).WithArgs("user123", false).WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("note1", "user123", nil, "A", "", false, false, 1, now, now, 0, 0, false, `{"project":"alpha","review":{"by":"bob"}}`, nil, nil).
+				AddRow("note2", "user123", nil, "B", "", false, false, 1, now, now, 0, 0, false, `{"project":"alpha","priority":2}`, nil, nil).
+				AddRow("note3", "user123", nil, "C", "", false, false, 1, now, now, 0, 0, false, `{"labels":["urgent","home"],"draft":true}`, nil, nil))
+
+			resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes"+tc.query, nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			var notes []Note
+			if err := json.NewDecoder(resp.Body).Decode(&notes); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			ids := []string{}
+			for _, n := range notes {
+				ids = append(ids, n.ID)
+			}
+			assert.Equal(t, tc.expected, ids)
+		})
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateNote_Metadata(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("POST", "/notes", helper.handler.CreateNote)
+
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO notes (id, user_id, workspace_id, title, content, size, word_count, char_count, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "user123", nil, "Title", "Body", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), `{"project":"alpha"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevision(helper.mockDB, sqlmock.AnyArg())
+	expectChange(helper.mockDB, "created", "id = ?", sqlmock.AnyArg())
+
+	req := httptest.NewRequest("POST", "/notes", strings.NewReader(`{"title":"Title","content":"Body","metadata":{"project":"alpha"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := helper.app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+
+	req = httptest.NewRequest("POST", "/notes", strings.NewReader(`{"title":"Title","content":"Body","metadata":{"a":{"b":{"c":{"d":[1]}}}}}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = helper.app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -3,49 +3,298 @@
 package notes
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"quanta/internal/access"
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/changelog"
+	"quanta/internal/db"
+	"quanta/internal/maintenance"
+	"quanta/internal/models"
+	"quanta/internal/notifications"
+	"quanta/internal/quota"
+	"quanta/internal/realtime"
+	"quanta/internal/validate"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("quanta/internal/handlers/notes")
+
 // DBInterface defines the methods for database operations
 type DBInterface interface {
-	Exec(query string, args ...any) (sql.Result, error)
-	Query(query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 }
 
-// Note represents a user's note with metadata
+// ActivityRecorder records note lifecycle events for the activity feed
+type ActivityRecorder interface {
+	Record(ctx context.Context, noteID, actorID string, action activity.Action, details map[string]string)
+}
+
+// Note represents a note with metadata. Notes with a WorkspaceID belong to
+// that workspace and are shared with its members; the rest are private to
+// UserID. The Title and Content of an Encrypted note are ciphertext that
+// only its collaborators can decrypt, see GetKeys. Metadata is the
+// client's own structured data, see PatchMetadata, and Color and Icon
+// label it in lists, see PatchNote.
 type Note struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	Title     string    `json:"title"`
-	Content   string    `json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	WorkspaceID *string   `json:"workspace_id"`
+	Title       string    `json:"title"`
+	Content     string    `json:"content"`
+	Pinned      bool      `json:"pinned"`
+	Archived    bool      `json:"archived"`
+	Version     int64     `json:"version"`
+	Stats       NoteStats `json:"stats"`
+	Encrypted   bool      `json:"encrypted"`
+	Metadata    Metadata  `json:"metadata"`
+	Color       *string   `json:"color"`
+	Icon        *string   `json:"icon"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// NotePayload is the request body for creating or updating a note.
+// Metadata is only stored when a note is created; updates leave it alone
+// and PATCH /notes/:id/metadata changes it.
+type NotePayload struct {
+	Title    string   `json:"title" validate:"required"`
+	Content  string   `json:"content"`
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// CreatePayload is the request body for creating a note. An encrypted note
+// carries ciphertext in place of its title and content, and the creator's
+// copy of the note key wrapped with their public key. Whether a note is
+// encrypted is fixed when it is created.
+type CreatePayload struct {
+	NotePayload
+	Encrypted  bool   `json:"encrypted"`
+	WrappedKey string `json:"wrapped_key"`
+}
+
+// RoomPublisher pushes updates to everyone connected to a note's realtime
+// room. SetLock and SetRole also record the lock and roles the room checks
+// edits against; NoteDeleted makes the room read-only and closes it.
+type RoomPublisher interface {
+	Publish(ctx context.Context, noteID string, message any)
+	SetLock(ctx context.Context, noteID string, lock *realtime.Lock)
+	SetRole(ctx context.Context, noteID, userID, role string)
+	NoteDeleted(ctx context.Context, noteID, userID string)
+}
+
+// RevisionReader reads a note's content at a version, including revisions
+// archived to blob storage. It is implemented by *revisions.Archive and
+// returns revisions.ErrNotFound for a version the note doesn't have.
+type RevisionReader interface {
+	Content(ctx context.Context, noteID string, version int64) (string, error)
+}
+
+// ContentProcessors runs a note's content through the configured content
+// processors some time after it is edited. It is implemented by
+// *processors.Pipeline.
+type ContentProcessors interface {
+	Enqueue(noteID string)
+}
+
+// Notifier delivers in-app notifications. It is implemented by
+// *notifications.Handler.
+type Notifier interface {
+	Notify(ctx context.Context, userID string, kind notifications.Kind, payload map[string]string) error
 }
 
 // Handler handles HTTP requests related to notes operations
 type Handler struct {
-	db DBInterface
+	db         DBInterface
+	activity   ActivityRecorder
+	rooms      RoomPublisher
+	processors ContentProcessors
+	limits     models.NoteLimits
+	quota      quota.Limits
+	revisions  RevisionReader
+	notifier   Notifier
+	dialect    db.Dialect
 }
 
-// NewHandler creates a new Handler with the provided database interface
-func NewHandler(db DBInterface) *Handler {
-	return &Handler{db: db}
+// noteColumns lists the columns scanNote reads, in order
+const noteColumns = "id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon"
+
+// accessible restricts a notes query to the notes the user may open. It
+// takes the user ID twice.
+const accessible = access.Notes
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanNote reads a row selected with noteColumns
+func scanNote(row scanner) (Note, error) {
+	var n Note
+	err := scanNoteWith(row, &n)
+	return n, err
+}
+
+// scanNoteWith reads a row selected with noteColumns into n, followed by
+// any extra columns into extra
+func scanNoteWith(row scanner, n *Note, extra ...any) error {
+	var workspaceID, metadata, color, icon sql.NullString
+	dest := []any{&n.ID, &n.UserID, &workspaceID, &n.Title, &n.Content, &n.Pinned, &n.Archived, &n.Version, &n.CreatedAt, &n.UpdatedAt, &n.Stats.WordCount, &n.Stats.CharCount, &n.Encrypted, &metadata, &color, &icon}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return err
+	}
+	n.WorkspaceID = nullable(workspaceID)
+	n.Color = nullable(color)
+	n.Icon = nullable(icon)
+	n.Stats = withReadingTime(n.Stats)
+	var err error
+	n.Metadata, err = parseMetadata(metadata)
+	return err
+}
+
+// NewHandler creates a new Handler with the provided database interface,
+// activity recorder, realtime rooms, content processors, size limits,
+// storage quotas, the reader of archived revisions, the notifier told
+// when a note is shared and the dialect of the database. rooms may be nil to skip realtime delivery,
+// processors to process nothing, revisions to read revisions from the
+// database only and notifier to notify no one. Zero size limits fall back
+// to the defaults; zero quotas are unlimited.
+func NewHandler(db DBInterface, recorder ActivityRecorder, rooms RoomPublisher, processors ContentProcessors, limits models.NoteLimits, quotas quota.Limits, revisions RevisionReader, notifier Notifier, dialect db.Dialect) *Handler {
+	return &Handler{db: db, activity: recorder, rooms: rooms, processors: processors, limits: limits.WithDefaults(), quota: quotas, revisions: revisions, notifier: notifier, dialect: dialect}
+}
+
+// validateNote applies the validation rules and size limits to payload,
+// normalizing it in place. Content is stored as written, markup included;
+// it is escaped or sanitized where it is rendered. It is traced so that
+// validation shows up as its own phase of a request.
+func (h *Handler) validateNote(ctx context.Context, payload *NotePayload) error {
+	_, span := tracer.Start(ctx, "notes.validate")
+	defer span.End()
+
+	if errs := validate.Struct(payload); errs != nil {
+		return apperr.Invalid(errs)
+	}
+	payload.Content = strings.TrimSpace(payload.Content)
+	if err := h.checkLimits(*payload); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkLimits rejects a payload that is too large to store
+func (h *Handler) checkLimits(payload NotePayload) *apperr.Error {
+	if n := h.limits.MaxTitleLength; utf8.RuneCountInString(payload.Title) > n {
+		return apperr.Invalid(map[string]string{"title": fmt.Sprintf("must be at most %d characters", n)})
+	}
+	if n := h.limits.MaxContentBytes; len(payload.Content) > n {
+		return apperr.Newf(fiber.StatusRequestEntityTooLarge, "Note content exceeds the %d byte limit", n)
+	}
+	return nil
 }
 
-// GetNotes retrieves all notes for a user
+// GetNotes retrieves a user's private notes, pinned first and then most
+// recently updated. Archived notes are left out unless ?state=archived asks
+// for them. The list carries an ETag and a Last-Modified of its newest note
+// for conditional requests. Last-Modified does not move when a note is
+// deleted, pinned or archived, so polling clients should prefer If-None-Match.
 func (h *Handler) GetNotes(c *fiber.Ctx) error {
-	userID := c.Locals("user-id").(string)
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	return h.listNotes(c, "user_id = ? AND workspace_id IS NULL", userID)
+}
+
+// ListOptions filters a listing of notes
+type ListOptions struct {
+	Archived bool
+	// Color, when set, lists only the notes with this color label
+	Color string
+	// Metadata leaves out notes whose metadata doesn't have every value,
+	// keyed by dotted path, that it lists
+	Metadata map[string]string
+	// UpdatedSince, when set, leaves out notes not updated after it and
+	// lists the rest newest first, which is what polling clients expect
+	UpdatedSince time.Time
+}
+
+// listNotes writes the notes matching where, which must select a single
+// owner or workspace, honoring ?state=, ?color=, ?updated_since=,
+// ?meta.<key>= and conditional request headers
+func (h *Handler) listNotes(c *fiber.Ctx, where string, args ...any) error {
+	opts := ListOptions{Metadata: metadataFilters(c)}
+	switch c.Query("state", "active") {
+	case "active":
+	case "archived":
+		opts.Archived = true
+	default:
+		return apperr.New(fiber.StatusBadRequest, "state must be active or archived")
+	}
+	if color := c.Query("color"); color != "" {
+		if !validColor(color) {
+			return apperr.New(fiber.StatusBadRequest, "color is not in the palette")
+		}
+		opts.Color = color
+	}
+	if since := c.Query("updated_since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return apperr.New(fiber.StatusBadRequest, "updated_since must be an RFC 3339 timestamp")
+		}
+		opts.UpdatedSince = t
+	}
 
-	rows, err := h.db.Query("SELECT id, user_id, title, content, created_at, updated_at FROM notes WHERE user_id = ?", userID)
+	notes, err := h.queryNotes(c.UserContext(), opts, where, args...)
 	if err != nil {
-		log.Println("Error fetching notes:", err)
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return err
+	}
+
+	return sendConditional(c, etagFor(notes...), lastModified(notes...), notes)
+}
+
+// List returns the user's private notes, pinned first and then most
+// recently updated
+func (h *Handler) List(ctx context.Context, userID string, opts ListOptions) ([]Note, error) {
+	return h.queryNotes(ctx, opts, "user_id = ? AND workspace_id IS NULL", userID)
+}
+
+// queryNotes fetches the notes matching where and opts
+func (h *Handler) queryNotes(ctx context.Context, opts ListOptions, where string, args ...any) ([]Note, error) {
+	where += " AND archived = ?"
+	args = append(args, opts.Archived)
+	if opts.Color != "" {
+		where += " AND color = ?"
+		args = append(args, opts.Color)
+	}
+
+	order := "pinned DESC, updated_at DESC"
+	if !opts.UpdatedSince.IsZero() {
+		where += " AND updated_at > ?"
+		args = append(args, opts.UpdatedSince.UTC())
+		order = "updated_at DESC"
+	}
+
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT "+noteColumns+" FROM notes WHERE "+where+" ORDER BY "+order,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching notes: %w", err)
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -55,98 +304,282 @@ func (h *Handler) GetNotes(c *fiber.Ctx) error {
 
 	notes := []Note{}
 	for rows.Next() {
-		var n Note
-		if err := rows.Scan(&n.ID, &n.UserID, &n.Title, &n.Content, &n.CreatedAt, &n.UpdatedAt); err != nil {
-			log.Println("Error scanning note:", err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+		n, err := scanNote(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning note: %w", err)
+		}
+		if n.Metadata.matches(opts.Metadata) {
+			notes = append(notes, n)
 		}
-		notes = append(notes, n)
 	}
 
-	return c.JSON(notes)
+	return notes, nil
 }
 
-// CreateNote creates a new note for the user
-func (h *Handler) CreateNote(c *fiber.Ctx) error {
-	userID := c.Locals("user-id").(string)
+// GetNote retrieves one of the user's private notes or a note from one of
+// their workspaces, recording the view for the recently viewed list and a
+// read receipt for its current version. The response carries an ETag
+// derived from the note's version and a Last-Modified of its updated_at,
+// and conditional requests that still match are answered 304 Not Modified.
+// Neither the view nor the receipt is recorded during maintenance.
+func (h *Handler) GetNote(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
 
-	var payload struct {
-		Title   string `json:"title"`
-		Content string `json:"content"`
+	n, err := h.Get(c.UserContext(), userID, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if !maintenance.ReadOnly(c) {
+		h.recordView(c.UserContext(), userID, n.ID)
+		if err := h.recordReceipt(c.UserContext(), userID, n, n.Version); err != nil {
+			log.Printf("Error recording read receipt for note %s: %v", n.ID, err)
+		}
+	}
+
+	return sendConditional(c, etagFor(n), n.UpdatedAt, n)
+}
+
+// Get returns one of the user's private notes or a note from one of their
+// workspaces
+func (h *Handler) Get(ctx context.Context, userID, noteID string) (Note, error) {
+	n, err := scanNote(h.db.QueryRowContext(ctx,
+		"SELECT "+noteColumns+" FROM notes WHERE id = ? AND "+accessible,
+		noteID, userID, userID,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Note{}, apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+		}
+		return Note{}, fmt.Errorf("fetching note: %w", err)
 	}
+	return n, nil
+}
+
+// CreateNote creates a new private note for the user
+func (h *Handler) CreateNote(c *fiber.Ctx) error {
+	return h.createNote(c, nil)
+}
+
+// createNote creates a note written by the user, in workspaceID if set
+func (h *Handler) createNote(c *fiber.Ctx, workspaceID *string) error {
+	var payload CreatePayload
 	if err := c.BodyParser(&payload); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request payload"})
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
 	}
 
-	// Input validation
-	payload.Title = strings.TrimSpace(payload.Title)
-	payload.Content = strings.TrimSpace(payload.Content)
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	var id string
+	if payload.Encrypted {
+		id, err = h.createEncrypted(c.UserContext(), userID, workspaceID, payload)
+	} else {
+		id, err = h.Create(c.UserContext(), userID, workspaceID, payload.NotePayload)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": id})
+}
 
-	if payload.Title == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Title cannot be empty"})
+// Create validates payload and stores it as a note written by the user, in
+// workspaceID if set, returning the new note's ID
+func (h *Handler) Create(ctx context.Context, userID string, workspaceID *string, payload NotePayload) (string, error) {
+	if err := h.validateNote(ctx, &payload); err != nil {
+		return "", err
+	}
+	if err := checkMetadata(payload.Metadata); err != nil {
+		return "", err
+	}
+	metadata, err := payload.Metadata.value()
+	if err != nil {
+		return "", err
+	}
+
+	size := quota.NoteSize(payload.Title, payload.Content)
+	if err := h.quota.Check(ctx, h.db, userID, workspaceID, size); err != nil {
+		return "", err
 	}
 
 	id := uuid.New().String()
-	_, err := h.db.Exec("INSERT INTO notes (id, user_id, title, content) VALUES (?, ?, ?, ?)",
-		id, userID, payload.Title, payload.Content)
+	stats := statsOf(payload.Content)
+	_, err = h.db.ExecContext(ctx, "INSERT INTO notes (id, user_id, workspace_id, title, content, size, word_count, char_count, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		id, userID, workspaceID, payload.Title, payload.Content, size, stats.WordCount, stats.CharCount, metadata)
 	if err != nil {
-		log.Println("Error creating note:", err)
-		return c.SendStatus(fiber.StatusInternalServerError)
+		return "", fmt.Errorf("creating note: %w", err)
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": id})
+	h.recordRevision(ctx, id, userID)
+	h.recordChange(ctx, changelog.Created, id)
+	h.recordTasks(ctx, id, "", payload.Content)
+	h.activity.Record(ctx, id, userID, activity.ActionCreated, nil)
+	h.process(id)
+
+	return id, nil
 }
 
-// UpdateNote updates an existing note
+// UpdateNote updates a private note or a note in one of the user's
+// workspaces. With ?mode=merge the update is merged with changes made
+// since the client's base version instead of overwriting them.
 func (h *Handler) UpdateNote(c *fiber.Ctx) error {
-	userID := c.Locals("user-id").(string)
-	noteID := c.Params("id")
-
-	var payload struct {
-		Title   string `json:"title"`
-		Content string `json:"content"`
+	switch c.Query("mode", "overwrite") {
+	case "overwrite":
+	case "merge":
+		return h.mergeNote(c)
+	default:
+		return apperr.New(fiber.StatusBadRequest, "mode must be overwrite or merge")
 	}
+
+	var payload NotePayload
 	if err := c.BodyParser(&payload); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid request payload"})
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
 	}
 
-	payload.Title = strings.TrimSpace(payload.Title)
-	payload.Content = strings.TrimSpace(payload.Content)
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	if err := h.Update(c.UserContext(), userID, c.Params("id"), payload); err != nil {
+		return err
+	}
 
-	if payload.Title == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Title cannot be empty"})
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Update validates payload and writes it over a note the user can access
+// and nobody else has locked
+func (h *Handler) Update(ctx context.Context, userID, noteID string, payload NotePayload) error {
+	if err := h.validateNote(ctx, &payload); err != nil {
+		return err
 	}
 
-	result, err := h.db.Exec("UPDATE notes SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?",
-		payload.Title, payload.Content, noteID, userID)
+	var oldTitle, oldContent string
+	var workspaceID sql.NullString
+	var encrypted bool
+	err := h.db.QueryRowContext(ctx, "SELECT title, content, workspace_id, encrypted FROM notes WHERE id = ? AND "+accessible, noteID, userID, userID).
+		Scan(&oldTitle, &oldContent, &workspaceID, &encrypted)
 	if err != nil {
-		log.Println("Error updating note:", err)
-		return c.SendStatus(fiber.StatusInternalServerError)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+		}
+		return fmt.Errorf("fetching note: %w", err)
+	}
+	if err := h.requireEditor(ctx, noteID, userID); err != nil {
+		return err
+	}
+	if err := h.checkLock(ctx, noteID, userID); err != nil {
+		return err
+	}
+
+	size := quota.NoteSize(payload.Title, payload.Content)
+	var chargeTo *string
+	if workspaceID.Valid {
+		chargeTo = &workspaceID.String
+	}
+	if err := h.quota.Check(ctx, h.db, userID, chargeTo, size-quota.NoteSize(oldTitle, oldContent)); err != nil {
+		return err
+	}
+
+	stats := contentStats(encrypted, payload.Content)
+	result, err := h.db.ExecContext(ctx, "UPDATE notes SET title = ?, content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND "+accessible,
+		payload.Title, payload.Content, size, stats.WordCount, stats.CharCount, noteID, userID, userID)
+	if err != nil {
+		return fmt.Errorf("updating note: %w", err)
 	}
 
 	affectedRows, _ := result.RowsAffected()
 	if affectedRows == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Note not found or unauthorized"})
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
 	}
 
-	return c.SendStatus(fiber.StatusNoContent)
+	h.recordRevision(ctx, noteID, userID)
+	h.recordChange(ctx, changelog.Updated, noteID)
+	if !encrypted {
+		h.recordTasks(ctx, noteID, oldContent, payload.Content)
+	}
+	h.recordChanges(ctx, noteID, userID, oldTitle, oldContent, payload)
+	return nil
 }
 
-// DeleteNote deletes a note by ID
-func (h *Handler) DeleteNote(c *fiber.Ctx) error {
-	userID := c.Locals("user-id").(string)
-	noteID := c.Params("id")
+// process queues a note whose content was written for the content
+// processors
+func (h *Handler) process(noteID string) {
+	if h.processors != nil {
+		h.processors.Enqueue(noteID)
+	}
+}
 
-	result, err := h.db.Exec("DELETE FROM notes WHERE id = ? AND user_id = ?", noteID, userID)
-	if err != nil {
-		log.Println("Error deleting note:", err)
-		return c.SendStatus(fiber.StatusInternalServerError)
+// recordChange logs a write of a note that has already been committed to
+// the changelog. Failures are logged rather than failing the write.
+func (h *Handler) recordChange(ctx context.Context, op changelog.Op, noteID string) {
+	if err := changelog.Record(ctx, h.db, op, "id = ?", noteID); err != nil {
+		log.Printf("Error logging change of note %s: %v", noteID, err)
 	}
+}
 
-	affectedRows, _ := result.RowsAffected()
-	if affectedRows == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Note not found or unauthorized"})
+// recordChanges records the renaming and editing of a note written over
+// with payload
+func (h *Handler) recordChanges(ctx context.Context, noteID, userID, oldTitle, oldContent string, payload NotePayload) {
+	if payload.Title != oldTitle {
+		h.activity.Record(ctx, noteID, userID, activity.ActionRenamed, map[string]string{"from": oldTitle, "to": payload.Title})
+	}
+	if payload.Content != oldContent {
+		h.activity.Record(ctx, noteID, userID, activity.ActionEdited, nil)
+		h.process(noteID)
+	}
+}
+
+// DeleteNote deletes a private note or a note in one of the user's workspaces
+func (h *Handler) DeleteNote(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	if err := h.Delete(c.UserContext(), userID, c.Params("id")); err != nil {
+		return err
 	}
 
 	return c.SendStatus(fiber.StatusNoContent)
 }
+
+// Delete removes a note the user can access
+func (h *Handler) Delete(ctx context.Context, userID, noteID string) error {
+	if err := h.requireEditor(ctx, noteID, userID); err != nil {
+		return err
+	}
+
+	err := db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		if err := changelog.Record(ctx, tx, changelog.Deleted, "id = ? AND "+accessible, noteID, userID, userID); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM notes WHERE id = ? AND "+accessible, noteID, userID, userID)
+		if err != nil {
+			return fmt.Errorf("deleting note: %w", err)
+		}
+		if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+			return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	h.activity.Record(ctx, noteID, userID, activity.ActionDeleted, nil)
+	h.announceDeleted(ctx, noteID, userID)
+
+	return nil
+}
+
+// announceDeleted tells the note's room, if anyone has it open, that the
+// user deleted it
+func (h *Handler) announceDeleted(ctx context.Context, noteID, userID string) {
+	if h.rooms != nil {
+		h.rooms.NoteDeleted(ctx, noteID, userID)
+	}
+}
@@ -2,37 +2,116 @@ package notes
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gofiber/fiber/v2"
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/db"
+	"quanta/internal/models"
+	"quanta/internal/notifications"
+	"quanta/internal/quota"
+	"quanta/internal/realtime"
+
 	"github.com/stretchr/testify/assert"
 )
 
+// recordedActivity is a single call to fakeRecorder.Record
+type recordedActivity struct {
+	noteID  string
+	actorID string
+	action  activity.Action
+	details map[string]string
+}
+
+// fakeRecorder captures recorded activities in memory
+type fakeRecorder struct {
+	recorded []recordedActivity
+}
+
+func (f *fakeRecorder) Record(_ context.Context, noteID, actorID string, action activity.Action, details map[string]string) {
+	f.recorded = append(f.recorded, recordedActivity{noteID: noteID, actorID: actorID, action: action, details: details})
+}
+
+// fakeRooms records the messages published, lock states announced (nil
+// for an unlock), role changes as user:role and the notes deleted
+type fakeRooms struct {
+	published []any
+	announced []*realtime.Lock
+	roles     []string
+	deleted   []string
+}
+
+func (f *fakeRooms) Publish(_ context.Context, _ string, message any) {
+	f.published = append(f.published, message)
+}
+
+func (f *fakeRooms) SetLock(_ context.Context, _ string, lock *realtime.Lock) {
+	f.announced = append(f.announced, lock)
+}
+
+func (f *fakeRooms) SetRole(_ context.Context, _, userID, role string) {
+	f.roles = append(f.roles, userID+":"+role)
+}
+
+func (f *fakeRooms) NoteDeleted(_ context.Context, noteID, _ string) {
+	f.deleted = append(f.deleted, noteID)
+}
+
+// fakeProcessors records the notes queued for processing
+type fakeProcessors struct {
+	queued []string
+}
+
+func (f *fakeProcessors) Enqueue(noteID string) {
+	f.queued = append(f.queued, noteID)
+}
+
+// fakeNotifier records the notifications sent as "user:kind:note"
+type fakeNotifier struct {
+	sent []string
+}
+
+func (f *fakeNotifier) Notify(_ context.Context, userID string, kind notifications.Kind, payload map[string]string) error {
+	f.sent = append(f.sent, userID+":"+string(kind)+":"+payload["note_id"])
+	return nil
+}
+
 // testHelper contains common test setup and utilities
 type testHelper struct {
-	t       *testing.T
-	db      *sql.DB
-	mockDB  sqlmock.Sqlmock
-	app     *fiber.App
-	handler *Handler
+	t          *testing.T
+	db         *sql.DB
+	mockDB     sqlmock.Sqlmock
+	app        *fiber.App
+	handler    *Handler
+	recorder   *fakeRecorder
+	rooms      *fakeRooms
+	processors *fakeProcessors
+	notifier   *fakeNotifier
 }
 
 // newTestHelper creates a new test helper with common setup
 func newTestHelper(t *testing.T) *testHelper {
-	db, mockDB, err := sqlmock.New()
+	conn, mockDB, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("error opening stub database: %v", err)
 	}
 
-	handler := NewHandler(db)
-	app := fiber.New()
+	recorder := &fakeRecorder{}
+	rooms := &fakeRooms{}
+	processors := &fakeProcessors{}
+	notifier := &fakeNotifier{}
+	handler := NewHandler(conn, recorder, rooms, processors, models.NoteLimits{MaxTitleLength: 20, MaxContentBytes: 32}, quota.Limits{}, nil, notifier, db.MySQL)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
 
 	// Mock user ID in context
 	app.Use(func(c *fiber.Ctx) error {
@@ -41,14 +120,42 @@ func newTestHelper(t *testing.T) *testHelper {
 	})
 
 	return &testHelper{
-		t:       t,
-		db:      db,
-		mockDB:  mockDB,
-		app:     app,
-		handler: handler,
+		t:          t,
+		db:         conn,
+		mockDB:     mockDB,
+		app:        app,
+		handler:    handler,
+		recorder:   recorder,
+		rooms:      rooms,
+		processors: processors,
+		notifier:   notifier,
 	}
 }
 
+// expectLock expects the note's lock to be looked up and found held by
+// holder, or not locked when holder is empty
+func (h *testHelper) expectLock(noteID, holder string) {
+	rows := sqlmock.NewRows([]string{"user_id"})
+	if holder != "" {
+		rows.AddRow(holder)
+	}
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM note_locks WHERE note_id = ? AND expires_at > ?")).
+		WithArgs(noteID, sqlmock.AnyArg()).
+		WillReturnRows(rows)
+}
+
+// expectRole expects user123's role on the note to be looked up and
+// found to be role, or not set when role is empty
+func (h *testHelper) expectRole(noteID, role string) {
+	rows := sqlmock.NewRows([]string{"role"})
+	if role != "" {
+		rows.AddRow(role)
+	}
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT role FROM note_collaborators WHERE note_id = ? AND user_id = ?")).
+		WithArgs(noteID, "user123").
+		WillReturnRows(rows)
+}
+
 // cleanup performs cleanup after tests
 func (h *testHelper) cleanup() {
 	// NOTE: Don't close the database connection here as sqlmock
@@ -64,6 +171,8 @@ func (h *testHelper) setupRoute(method, path string, handler fiber.Handler) {
 		h.app.Post(path, handler)
 	case "PUT":
 		h.app.Put(path, handler)
+	case "PATCH":
+		h.app.Patch(path, handler)
 	case "DELETE":
 		h.app.Delete(path, handler)
 	}
@@ -77,19 +186,24 @@ func TestGetNotes(t *testing.T) {
 
 	now := time.Now()
 	// Test cases
+	noteColumns := []string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}
+
 	testCases := []struct {
 		name           string
+		query          string
+		archived       bool
 		mockRows       *sqlmock.Rows
 		mockError      error
 		expectedStatus int
 		expectedNotes  int
 		expectedError  string
+		fieldErrors    map[string]string
 	}{
 		{
 			name: "Success",
-			mockRows: sqlmock.NewRows([]string{"id", "user_id", "title", "content", "created_at", "updated_at"}).
-				AddRow("note1", "user123", "Test Note 1", "Content 1", now, now).
-				AddRow("note2", "user123", "Test Note 2", "Content 2", now, now),
+			mockRows: sqlmock.NewRows(noteColumns).
+				AddRow("note1", "user123", nil, "Test Note 1", "Content 1", true, false, 1, now, now, 0, 0, false, nil, nil, nil).
+				AddRow("note2", "user123", nil, "Test Note 2", "Content 2", false, false, 3, now, now, 0, 0, false, nil, nil, nil),
 			expectedStatus: fiber.StatusOK,
 			expectedNotes:  2,
 		},
@@ -101,22 +215,36 @@ func TestGetNotes(t *testing.T) {
 		},
 		{
 			name:           "No Notes",
-			mockRows:       sqlmock.NewRows([]string{"id", "user_id", "title", "content", "created_at", "updated_at"}),
+			mockRows:       sqlmock.NewRows(noteColumns),
 			expectedStatus: fiber.StatusOK,
 			expectedNotes:  0,
 		},
+		{
+			name:           "Archived",
+			query:          "?state=archived",
+			archived:       true,
+			mockRows:       sqlmock.NewRows(noteColumns).AddRow("note3", "user123", nil, "Old Note", "", false, true, 2, now, now, 0, 0, false, nil, nil, nil),
+			expectedStatus: fiber.StatusOK,
+			expectedNotes:  1,
+		},
+		{
+			name:           "Unknown State",
+			query:          "?state=deleted",
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "state must be active or archived",
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			query := regexp.QuoteMeta("SELECT id, user_id, title, content, created_at, updated_at FROM notes WHERE user_id = ?")
+			query := regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE user_id = ? AND workspace_id IS NULL AND archived = ? ORDER BY pinned DESC, updated_at DESC")
 			if tc.mockError != nil {
-				helper.mockDB.ExpectQuery(query).WithArgs("user123").WillReturnError(tc.mockError)
-			} else {
-				helper.mockDB.ExpectQuery(query).WithArgs("user123").WillReturnRows(tc.mockRows)
+				helper.mockDB.ExpectQuery(query).WithArgs("user123", tc.archived).WillReturnError(tc.mockError)
+			} else if tc.mockRows != nil {
+				helper.mockDB.ExpectQuery(query).WithArgs("user123", tc.archived).WillReturnRows(tc.mockRows)
 			}
 
-			req := httptest.NewRequest("GET", "/notes", nil)
+			req := httptest.NewRequest("GET", "/notes"+tc.query, nil)
 			resp, err := helper.app.Test(req)
 			if err != nil {
 				t.Fatalf("error performing request: %v", err)
@@ -132,12 +260,12 @@ func TestGetNotes(t *testing.T) {
 				}
 				assert.Len(t, notes, tc.expectedNotes)
 			} else if tc.expectedError != "" {
-				var response map[string]string
+				var response apperr.Response
 				err = json.NewDecoder(resp.Body).Decode(&response)
 				if err != nil {
 					t.Fatalf("error decoding response: %v", err)
 				}
-				assert.Equal(t, tc.expectedError, response["error"])
+				assert.Equal(t, tc.expectedError, response.Message)
 			}
 		})
 	}
@@ -147,6 +275,41 @@ func TestGetNotes(t *testing.T) {
 	}
 }
 
+func TestGetNotes_UpdatedSince(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/notes", helper.handler.GetNotes)
+
+	since := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	now := time.Now()
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE user_id = ? AND workspace_id IS NULL AND archived = ? AND updated_at > ? ORDER BY updated_at DESC")).
+		WithArgs("user123", false, since).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}).
+			AddRow("note1", "user123", nil, "Fresh", "", false, false, 2, now, now, 0, 0, false, nil, nil, nil))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes?updated_since=2026-05-01T14:00:00%2B02:00", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var notes []Note
+	if err := json.NewDecoder(resp.Body).Decode(&notes); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.Len(t, notes, 1)
+
+	resp, err = helper.app.Test(httptest.NewRequest("GET", "/notes?updated_since=yesterday", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
 func TestCreateNote(t *testing.T) {
 	helper := newTestHelper(t)
 	defer helper.cleanup()
@@ -159,22 +322,35 @@ func TestCreateNote(t *testing.T) {
 		mockError      error
 		expectedStatus int
 		expectedError  string
+		fieldErrors    map[string]string
 		expectQuery    bool
 	}{
 		{
 			name:           "Empty Title",
 			payload:        map[string]string{"title": "", "content": "Some content"},
-			expectedStatus: fiber.StatusBadRequest,
-			expectedError:  "Title cannot be empty",
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"title": "required"},
 			expectQuery:    false,
 		},
 		{
 			name:           "Whitespace Title",
 			payload:        map[string]string{"title": "   ", "content": "Some content"},
-			expectedStatus: fiber.StatusBadRequest,
-			expectedError:  "Title cannot be empty",
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"title": "required"},
 			expectQuery:    false,
 		},
+		{
+			name:           "Title Too Long",
+			payload:        map[string]string{"title": strings.Repeat("é", 21), "content": "Some content"},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"title": "must be at most 20 characters"},
+		},
+		{
+			name:           "Content Too Large",
+			payload:        map[string]string{"title": "Valid Title", "content": strings.Repeat("x", 33)},
+			expectedStatus: fiber.StatusRequestEntityTooLarge,
+			expectedError:  "Note content exceeds the 32 byte limit",
+		},
 		{
 			name:           "Valid Note",
 			payload:        map[string]string{"title": "Valid Title", "content": "Some content"},
@@ -204,15 +380,17 @@ func TestCreateNote(t *testing.T) {
 			}
 
 			if tc.expectQuery {
-				query := regexp.QuoteMeta("INSERT INTO notes (id, user_id, title, content) VALUES (?, ?, ?, ?)")
+				query := regexp.QuoteMeta("INSERT INTO notes (id, user_id, workspace_id, title, content, size, word_count, char_count, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
 				if tc.mockError != nil {
 					helper.mockDB.ExpectExec(query).
-						WithArgs(sqlmock.AnyArg(), "user123", tc.payload["title"], tc.payload["content"]).
+						WithArgs(sqlmock.AnyArg(), "user123", nil, tc.payload["title"], tc.payload["content"], sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
 						WillReturnError(tc.mockError)
 				} else {
 					helper.mockDB.ExpectExec(query).
-						WithArgs(sqlmock.AnyArg(), "user123", tc.payload["title"], tc.payload["content"]).
+						WithArgs(sqlmock.AnyArg(), "user123", nil, tc.payload["title"], tc.payload["content"], sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
 						WillReturnResult(sqlmock.NewResult(1, 1))
+					expectRevision(helper.mockDB, sqlmock.AnyArg())
+					expectChange(helper.mockDB, "created", "id = ?", sqlmock.AnyArg())
 				}
 			}
 
@@ -225,13 +403,20 @@ func TestCreateNote(t *testing.T) {
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
 
-			if tc.expectedError != "" {
-				var response map[string]string
+			if tc.fieldErrors != nil {
+				var response apperr.Response
 				err = json.NewDecoder(resp.Body).Decode(&response)
 				if err != nil {
 					t.Fatalf("error decoding response: %v", err)
 				}
-				assert.Equal(t, tc.expectedError, response["error"])
+				assert.Equal(t, tc.fieldErrors, response.Errors)
+			} else if tc.expectedError != "" {
+				var response apperr.Response
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				if err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
 			} else if tc.expectedStatus == fiber.StatusCreated {
 				var response map[string]string
 				err = json.NewDecoder(resp.Body).Decode(&response)
@@ -248,6 +433,64 @@ func TestCreateNote(t *testing.T) {
 	}
 }
 
+func TestCreateNote_QuotaExceeded(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.handler.quota = quota.Limits{UserBytes: 100}
+	helper.setupRoute("POST", "/notes", helper.handler.CreateNote)
+
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT (SELECT COALESCE(SUM(size), 0) FROM notes WHERE user_id = ? AND workspace_id IS NULL)")).
+		WithArgs("user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"notes", "attachments"}).AddRow(60, 35))
+
+	req := httptest.NewRequest("POST", "/notes", strings.NewReader(`{"title":"Title","content":"Content"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := helper.app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+
+	assert.Equal(t, fiber.StatusPaymentRequired, resp.StatusCode)
+	var response apperr.Response
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.Equal(t, "Storage quota exceeded", response.Message)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateNote_KeepsMarkup(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("POST", "/notes", helper.handler.CreateNote)
+
+	// Content is stored as written; it is escaped where it is rendered
+	helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO notes (id, user_id, workspace_id, title, content, size, word_count, char_count, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "user123", nil, "Title", "if x<y { <textarea", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRevision(helper.mockDB, sqlmock.AnyArg())
+	expectChange(helper.mockDB, "created", "id = ?", sqlmock.AnyArg())
+
+	body := `{"title":"Title","content":"if x<y { <textarea"}`
+	req := httptest.NewRequest("POST", "/notes", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := helper.app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+	assert.Len(t, helper.processors.queued, 1, "the new note is queued for the content processors")
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
 func TestUpdateNote(t *testing.T) {
 	helper := newTestHelper(t)
 	defer helper.cleanup()
@@ -261,8 +504,13 @@ func TestUpdateNote(t *testing.T) {
 		mockError      error
 		expectedStatus int
 		expectedError  string
+		fieldErrors    map[string]string
 		expectQuery    bool
 		rowsAffected   int64
+		existing       []string
+		role           string
+		lockedBy       string
+		expectedAction []activity.Action
 	}{
 		{
 			name:           "Successful Update",
@@ -271,31 +519,66 @@ func TestUpdateNote(t *testing.T) {
 			expectedStatus: fiber.StatusNoContent,
 			expectQuery:    true,
 			rowsAffected:   1,
+			existing:       []string{"Updated Title", "Old content"},
+			expectedAction: []activity.Action{activity.ActionEdited},
+		},
+		{
+			name:           "Rename",
+			noteID:         "note1",
+			payload:        map[string]string{"title": "New Title", "content": "Same content"},
+			expectedStatus: fiber.StatusNoContent,
+			expectQuery:    true,
+			rowsAffected:   1,
+			existing:       []string{"Old Title", "Same content"},
+			expectedAction: []activity.Action{activity.ActionRenamed},
 		},
 		{
 			name:           "Empty Title",
 			noteID:         "note1",
 			payload:        map[string]string{"title": "", "content": "Some content"},
-			expectedStatus: fiber.StatusBadRequest,
-			expectedError:  "Title cannot be empty",
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"title": "required"},
 			expectQuery:    false,
 		},
 		{
 			name:           "Whitespace Title",
 			noteID:         "note1",
 			payload:        map[string]string{"title": "   ", "content": "Some content"},
-			expectedStatus: fiber.StatusBadRequest,
-			expectedError:  "Title cannot be empty",
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			fieldErrors:    map[string]string{"title": "required"},
 			expectQuery:    false,
 		},
+		{
+			name:           "Content Too Large",
+			noteID:         "note1",
+			payload:        map[string]string{"title": "Valid Title", "content": strings.Repeat("x", 33)},
+			expectedStatus: fiber.StatusRequestEntityTooLarge,
+			expectedError:  "Note content exceeds the 32 byte limit",
+		},
+		{
+			name:           "Locked By Another User",
+			noteID:         "note1",
+			payload:        map[string]string{"title": "Valid Title", "content": "Some content"},
+			expectedStatus: fiber.StatusLocked,
+			expectedError:  "Note is locked by another user",
+			existing:       []string{"Valid Title", "Old content"},
+			lockedBy:       "user456",
+		},
+		{
+			name:           "Viewer",
+			noteID:         "note1",
+			payload:        map[string]string{"title": "Valid Title", "content": "Some content"},
+			expectedStatus: fiber.StatusForbidden,
+			expectedError:  "You have read-only access to this note",
+			existing:       []string{"Valid Title", "Old content"},
+			role:           "viewer",
+		},
 		{
 			name:           "Note Not Found",
 			noteID:         "nonexistent",
 			payload:        map[string]string{"title": "Valid Title", "content": "Some content"},
 			expectedStatus: fiber.StatusNotFound,
 			expectedError:  "Note not found or unauthorized",
-			expectQuery:    true,
-			rowsAffected:   0,
 		},
 		{
 			name:           "Database Error",
@@ -304,26 +587,49 @@ func TestUpdateNote(t *testing.T) {
 			mockError:      errors.New("database error"),
 			expectedStatus: fiber.StatusInternalServerError,
 			expectQuery:    true,
+			existing:       []string{"Valid Title", "Old content"},
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
+			helper.recorder.recorded = nil
+
 			jsonPayload, err := json.Marshal(tc.payload)
 			if err != nil {
 				t.Fatalf("error marshaling payload: %v", err)
 			}
 
+			if tc.expectedStatus != fiber.StatusUnprocessableEntity && tc.expectedStatus != fiber.StatusRequestEntityTooLarge {
+				rows := sqlmock.NewRows([]string{"title", "content", "workspace_id", "encrypted"})
+				if tc.existing != nil {
+					rows.AddRow(tc.existing[0], tc.existing[1], nil, false)
+				}
+				helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT title, content, workspace_id, encrypted FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")).
+					WithArgs(tc.noteID, "user123", "user123").
+					WillReturnRows(rows)
+				if tc.existing != nil {
+					helper.expectRole(tc.noteID, tc.role)
+				}
+				if tc.existing != nil && tc.role == "" {
+					helper.expectLock(tc.noteID, tc.lockedBy)
+				}
+			}
+
 			if tc.expectQuery {
-				query := regexp.QuoteMeta("UPDATE notes SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?")
+				query := regexp.QuoteMeta("UPDATE notes SET title = ?, content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
 				if tc.mockError != nil {
 					helper.mockDB.ExpectExec(query).
-						WithArgs(tc.payload["title"], tc.payload["content"], tc.noteID, "user123").
+						WithArgs(tc.payload["title"], tc.payload["content"], sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), tc.noteID, "user123", "user123").
 						WillReturnError(tc.mockError)
 				} else {
 					helper.mockDB.ExpectExec(query).
-						WithArgs(tc.payload["title"], tc.payload["content"], tc.noteID, "user123").
+						WithArgs(tc.payload["title"], tc.payload["content"], sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), tc.noteID, "user123", "user123").
 						WillReturnResult(sqlmock.NewResult(0, tc.rowsAffected))
+					if tc.rowsAffected > 0 {
+						expectRevision(helper.mockDB, tc.noteID)
+						expectChange(helper.mockDB, "updated", "id = ?", tc.noteID)
+					}
 				}
 			}
 
@@ -336,13 +642,26 @@ func TestUpdateNote(t *testing.T) {
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
 
-			if tc.expectedError != "" {
-				var response map[string]string
+			var actions []activity.Action
+			for _, r := range helper.recorder.recorded {
+				actions = append(actions, r.action)
+			}
+			assert.Equal(t, tc.expectedAction, actions)
+
+			if tc.fieldErrors != nil {
+				var response apperr.Response
 				err = json.NewDecoder(resp.Body).Decode(&response)
 				if err != nil {
 					t.Fatalf("error decoding response: %v", err)
 				}
-				assert.Equal(t, tc.expectedError, response["error"])
+				assert.Equal(t, tc.fieldErrors, response.Errors)
+			} else if tc.expectedError != "" {
+				var response apperr.Response
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				if err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
 			}
 		})
 	}
@@ -364,6 +683,8 @@ func TestDeleteNote(t *testing.T) {
 		mockError      error
 		expectedStatus int
 		expectedError  string
+		fieldErrors    map[string]string
+		role           string
 		rowsAffected   int64
 	}{
 		{
@@ -379,6 +700,13 @@ func TestDeleteNote(t *testing.T) {
 			expectedError:  "Note not found or unauthorized",
 			rowsAffected:   0,
 		},
+		{
+			name:           "Commenter",
+			noteID:         "note1",
+			expectedStatus: fiber.StatusForbidden,
+			expectedError:  "You have read-only access to this note",
+			role:           "commenter",
+		},
 		{
 			name:           "Database Error",
 			noteID:         "note1",
@@ -389,15 +717,28 @@ func TestDeleteNote(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			query := regexp.QuoteMeta("DELETE FROM notes WHERE id = ? AND user_id = ?")
+			helper.rooms.deleted = nil
+			helper.expectRole(tc.noteID, tc.role)
+			where := "id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))"
+			query := regexp.QuoteMeta("DELETE FROM notes WHERE " + where)
 			if tc.mockError != nil {
+				helper.mockDB.ExpectBegin()
+				expectChange(helper.mockDB, "deleted", where, tc.noteID, "user123", "user123")
 				helper.mockDB.ExpectExec(query).
-					WithArgs(tc.noteID, "user123").
+					WithArgs(tc.noteID, "user123", "user123").
 					WillReturnError(tc.mockError)
-			} else {
+				helper.mockDB.ExpectRollback()
+			} else if tc.role == "" {
+				helper.mockDB.ExpectBegin()
+				expectChange(helper.mockDB, "deleted", where, tc.noteID, "user123", "user123")
 				helper.mockDB.ExpectExec(query).
-					WithArgs(tc.noteID, "user123").
+					WithArgs(tc.noteID, "user123", "user123").
 					WillReturnResult(sqlmock.NewResult(0, tc.rowsAffected))
+				if tc.rowsAffected > 0 {
+					helper.mockDB.ExpectCommit()
+				} else {
+					helper.mockDB.ExpectRollback()
+				}
 			}
 
 			req := httptest.NewRequest("DELETE", "/notes/"+tc.noteID, nil)
@@ -407,14 +748,26 @@ func TestDeleteNote(t *testing.T) {
 			}
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus == fiber.StatusNoContent {
+				assert.Equal(t, []string{tc.noteID}, helper.rooms.deleted)
+			} else {
+				assert.Empty(t, helper.rooms.deleted)
+			}
 
-			if tc.expectedError != "" {
-				var response map[string]string
+			if tc.fieldErrors != nil {
+				var response apperr.Response
+				err = json.NewDecoder(resp.Body).Decode(&response)
+				if err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.fieldErrors, response.Errors)
+			} else if tc.expectedError != "" {
+				var response apperr.Response
 				err = json.NewDecoder(resp.Body).Decode(&response)
 				if err != nil {
 					t.Fatalf("error decoding response: %v", err)
 				}
-				assert.Equal(t, tc.expectedError, response["error"])
+				assert.Equal(t, tc.expectedError, response.Message)
 			}
 		})
 	}
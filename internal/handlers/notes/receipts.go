@@ -0,0 +1,183 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/realtime"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Receipt is how far a collaborator has read a note. Version is 0 and
+// ReadAt null for someone who has never opened it.
+type Receipt struct {
+	UserID      string     `json:"user_id"`
+	DisplayName *string    `json:"display_name"`
+	Version     int64      `json:"version"`
+	ReadAt      *time.Time `json:"read_at"`
+	UpToDate    bool       `json:"up_to_date"`
+}
+
+// NoteReceipts is the response to GetReceipts
+type NoteReceipts struct {
+	NoteID   string    `json:"note_id"`
+	Version  int64     `json:"version"`
+	Receipts []Receipt `json:"receipts"`
+}
+
+// ReceiptPayload is the optional request body for MarkRead
+type ReceiptPayload struct {
+	Version int64 `json:"version"`
+}
+
+// ReceiptMessage is the realtime frame collaborators receive when someone
+// reads further into a note
+type ReceiptMessage struct {
+	Type    string  `json:"type"`
+	V       int     `json:"v"`
+	Receipt Receipt `json:"receipt"`
+}
+
+// recordReceipt moves the user's read receipt for a note up to version and
+// announces it to the note's room. A receipt never moves backwards, so
+// reading an older version changes nothing.
+func (h *Handler) recordReceipt(ctx context.Context, userID string, n Note, version int64) error {
+	readAt := time.Now().UTC().Truncate(time.Second)
+	result, err := h.db.ExecContext(ctx,
+		"UPDATE note_receipts SET version = ?, read_at = ? WHERE note_id = ? AND user_id = ? AND version < ?",
+		version, readAt, n.ID, userID, version,
+	)
+	if err != nil {
+		return fmt.Errorf("updating read receipt: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		_, err := h.db.ExecContext(ctx,
+			"INSERT INTO note_receipts (note_id, user_id, version, read_at) VALUES (?, ?, ?, ?)",
+			n.ID, userID, version, readAt,
+		)
+		// A duplicate means the receipt is already at or past version
+		if db.IsDuplicate(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("recording read receipt: %w", err)
+		}
+	}
+
+	if h.rooms != nil {
+		h.rooms.Publish(ctx, n.ID, ReceiptMessage{
+			Type: "receipt",
+			V:    realtime.ProtocolVersion,
+			Receipt: Receipt{
+				UserID:   userID,
+				Version:  version,
+				ReadAt:   &readAt,
+				UpToDate: version >= n.Version,
+			},
+		})
+	}
+	return nil
+}
+
+// MarkRead records that the user has read a note up to a version, by
+// default its current one. Opening a note records this already; clients
+// that keep a note open call it as later changes arrive.
+func (h *Handler) MarkRead(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload ReceiptPayload
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&payload); err != nil {
+			return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+		}
+	}
+
+	ctx := c.UserContext()
+	n, err := h.Get(ctx, userID, c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	version := n.Version
+	if payload.Version != 0 {
+		if payload.Version < 1 || payload.Version > n.Version {
+			return apperr.Invalid(map[string]string{"version": fmt.Sprintf("must be between 1 and %d", n.Version)})
+		}
+		version = payload.Version
+	}
+
+	if err := h.recordReceipt(ctx, userID, n, version); err != nil {
+		return err
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetReceipts lists how far everyone with access to a note has read it, so
+// collaborators can see whether their latest changes have been seen
+func (h *Handler) GetReceipts(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+	ctx := c.UserContext()
+
+	var owner string
+	var workspaceID sql.NullString
+	var version int64
+	err = h.db.QueryRowContext(ctx,
+		"SELECT user_id, workspace_id, version FROM notes WHERE id = ? AND "+accessible,
+		noteID, userID, userID,
+	).Scan(&owner, &workspaceID, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+	if err != nil {
+		return fmt.Errorf("fetching note: %w", err)
+	}
+
+	collaborators, arg := collaboratorsOf(owner, workspaceID)
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT u.id, u.display_name, r.version, r.read_at FROM users u LEFT JOIN note_receipts r ON r.note_id = ? AND r.user_id = u.id WHERE u.deleted_at IS NULL AND "+collaborators+" ORDER BY u.id",
+		noteID, arg,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching read receipts: %w", err)
+	}
+	defer closeRows(rows)
+
+	receipts := NoteReceipts{NoteID: noteID, Version: version, Receipts: []Receipt{}}
+	for rows.Next() {
+		var r Receipt
+		var displayName sql.NullString
+		var read sql.NullInt64
+		var readAt sql.NullTime
+		if err := rows.Scan(&r.UserID, &displayName, &read, &readAt); err != nil {
+			return fmt.Errorf("scanning read receipt: %w", err)
+		}
+		if displayName.Valid {
+			r.DisplayName = &displayName.String
+		}
+		if readAt.Valid {
+			r.ReadAt = &readAt.Time
+		}
+		r.Version = read.Int64
+		r.UpToDate = r.Version >= version
+		receipts.Receipts = append(receipts.Receipts, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating read receipts: %w", err)
+	}
+
+	return c.JSON(receipts)
+}
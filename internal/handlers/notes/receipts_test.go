@@ -0,0 +1,156 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkRead(t *testing.T) {
+	selectQuery := regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+	columns := []string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}
+	updateQuery := regexp.QuoteMeta("UPDATE note_receipts SET version = ?, read_at = ? WHERE note_id = ? AND user_id = ? AND version < ?")
+	insertQuery := regexp.QuoteMeta("INSERT INTO note_receipts (note_id, user_id, version, read_at) VALUES (?, ?, ?, ?)")
+
+	testCases := []struct {
+		name           string
+		body           string
+		version        int64
+		updated        int64
+		insertErr      error
+		expectedStatus int
+		published      bool
+		upToDate       bool
+	}{
+		{
+			name:           "First Read",
+			version:        3,
+			expectedStatus: fiber.StatusNoContent,
+			published:      true,
+			upToDate:       true,
+		},
+		{
+			name:           "Earlier Version",
+			body:           `{"version":2}`,
+			version:        2,
+			updated:        1,
+			expectedStatus: fiber.StatusNoContent,
+			published:      true,
+		},
+		{
+			name:           "Already Read",
+			version:        3,
+			insertErr:      &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"},
+			expectedStatus: fiber.StatusNoContent,
+		},
+		{
+			name:           "Future Version",
+			body:           `{"version":4}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("POST", "/notes/:id/receipts", helper.handler.MarkRead)
+
+			now := time.Now()
+			helper.mockDB.ExpectQuery(selectQuery).WithArgs("note1", "user123", "user123").
+				WillReturnRows(sqlmock.NewRows(columns).AddRow("note1", "user456", "ws1", "Shared", "", false, false, 3, now, now, 0, 0, false, nil, nil, nil))
+			if tc.version != 0 {
+				helper.mockDB.ExpectExec(updateQuery).
+					WithArgs(tc.version, sqlmock.AnyArg(), "note1", "user123", tc.version).
+					WillReturnResult(sqlmock.NewResult(0, tc.updated))
+				if tc.updated == 0 {
+					insert := helper.mockDB.ExpectExec(insertQuery).WithArgs("note1", "user123", tc.version, sqlmock.AnyArg())
+					if tc.insertErr != nil {
+						insert.WillReturnError(tc.insertErr)
+					} else {
+						insert.WillReturnResult(sqlmock.NewResult(1, 1))
+					}
+				}
+			}
+
+			req := httptest.NewRequest("POST", "/notes/note1/receipts", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.published {
+				if assert.Len(t, helper.rooms.published, 1) {
+					msg := helper.rooms.published[0].(ReceiptMessage)
+					assert.Equal(t, "receipt", msg.Type)
+					assert.Equal(t, "user123", msg.Receipt.UserID)
+					assert.Equal(t, tc.version, msg.Receipt.Version)
+					assert.Equal(t, tc.upToDate, msg.Receipt.UpToDate)
+					assert.NotNil(t, msg.Receipt.ReadAt)
+				}
+			} else {
+				assert.Empty(t, helper.rooms.published)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetReceipts(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/notes/:id/receipts", helper.handler.GetReceipts)
+
+	now := time.Now()
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT user_id, workspace_id, version FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")).
+		WithArgs("note1", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "workspace_id", "version"}).AddRow("user456", "ws1", 3))
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT u.id, u.display_name, r.version, r.read_at FROM users u LEFT JOIN note_receipts r ON r.note_id = ? AND r.user_id = u.id WHERE u.deleted_at IS NULL AND u.id IN (SELECT user_id FROM workspace_members WHERE workspace_id = ?) ORDER BY u.id")).
+		WithArgs("note1", "ws1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "display_name", "version", "read_at"}).
+			AddRow("user123", "Me", 3, now).
+			AddRow("user456", nil, 2, now.Add(-time.Hour)).
+			AddRow("user789", "Newcomer", nil, nil))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/note1/receipts", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var receipts NoteReceipts
+	if err := json.NewDecoder(resp.Body).Decode(&receipts); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.Equal(t, "note1", receipts.NoteID)
+	assert.Equal(t, int64(3), receipts.Version)
+	if assert.Len(t, receipts.Receipts, 3) {
+		assert.True(t, receipts.Receipts[0].UpToDate)
+		assert.Equal(t, "Me", *receipts.Receipts[0].DisplayName)
+
+		assert.False(t, receipts.Receipts[1].UpToDate)
+		assert.Nil(t, receipts.Receipts[1].DisplayName)
+		assert.Equal(t, int64(2), receipts.Receipts[1].Version)
+
+		assert.False(t, receipts.Receipts[2].UpToDate)
+		assert.Zero(t, receipts.Receipts[2].Version)
+		assert.Nil(t, receipts.Receipts[2].ReadAt)
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
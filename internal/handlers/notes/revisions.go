@@ -0,0 +1,156 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/merge"
+	"quanta/internal/revisions"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NoteDiff is the response to GetDiff. Unified is the change as a unified
+// diff; Hunks carries the same change line by line, with a word by word
+// diff of each hunk.
+type NoteDiff struct {
+	NoteID  string       `json:"note_id"`
+	From    int64        `json:"from"`
+	To      int64        `json:"to"`
+	Unified string       `json:"unified"`
+	Hunks   []merge.Hunk `json:"hunks"`
+}
+
+// execer runs a statement on the database or inside a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// snapshotRevision copies a note's current title and content into its
+// revision history, credited to userID. A write that races another on the
+// same note may find the later version already copied, which leaves its
+// own version out of the history.
+func snapshotRevision(ctx context.Context, ex execer, noteID, userID string) error {
+	_, err := ex.ExecContext(ctx,
+		"INSERT INTO note_revisions (note_id, version, user_id, title, content) SELECT id, version, ?, title, content FROM notes WHERE id = ?",
+		userID, noteID,
+	)
+	if db.IsDuplicate(err) {
+		return nil
+	}
+	return err
+}
+
+// recordRevision snapshots a note after a write that has already been
+// committed. Failures are logged rather than failing the write.
+func (h *Handler) recordRevision(ctx context.Context, noteID, userID string) {
+	if err := snapshotRevision(ctx, h.db, noteID, userID); err != nil {
+		log.Printf("Error recording revision of note %s: %v", noteID, err)
+	}
+}
+
+// GetDiff shows what changed in a note between two of its versions. to
+// defaults to the current version and from to the one before it.
+func (h *Handler) GetDiff(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+	ctx := c.UserContext()
+
+	var version int64
+	var encrypted bool
+	err = h.db.QueryRowContext(ctx, "SELECT version, encrypted FROM notes WHERE id = ? AND "+accessible, noteID, userID, userID).
+		Scan(&version, &encrypted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+		}
+		return fmt.Errorf("fetching note: %w", err)
+	}
+	// Lines of ciphertext mean nothing, so encrypted notes are diffed by
+	// their clients
+	if encrypted {
+		return apperr.New(fiber.StatusBadRequest, "Encrypted notes cannot be diffed by the server")
+	}
+
+	to, err := versionParam(c, "to", version)
+	if err != nil {
+		return err
+	}
+	from, err := versionParam(c, "from", to-1)
+	if err != nil {
+		return err
+	}
+	if from < 1 || to > version || from > to {
+		return apperr.Invalid(map[string]string{"from": fmt.Sprintf("from and to must satisfy 1 <= from <= to <= %d", version)})
+	}
+
+	before, err := h.revision(ctx, noteID, from)
+	if err != nil {
+		return err
+	}
+	after, err := h.revision(ctx, noteID, to)
+	if err != nil {
+		return err
+	}
+
+	hunks := merge.Diff(before, after, merge.DefaultContext)
+	if hunks == nil {
+		hunks = []merge.Hunk{}
+	}
+	return c.JSON(NoteDiff{
+		NoteID:  noteID,
+		From:    from,
+		To:      to,
+		Unified: merge.Unified(fmt.Sprintf("v%d", from), fmt.Sprintf("v%d", to), hunks),
+		Hunks:   hunks,
+	})
+}
+
+// revision returns a note's content at a version
+func (h *Handler) revision(ctx context.Context, noteID string, version int64) (string, error) {
+	if h.revisions != nil {
+		content, err := h.revisions.Content(ctx, noteID, version)
+		if errors.Is(err, revisions.ErrNotFound) {
+			return "", apperr.Newf(fiber.StatusNotFound, "Revision %d not found", version)
+		}
+		if err != nil {
+			return "", fmt.Errorf("fetching revision: %w", err)
+		}
+		return content, nil
+	}
+
+	var content sql.NullString
+	err := h.db.QueryRowContext(ctx, "SELECT content FROM note_revisions WHERE note_id = ? AND version = ?", noteID, version).
+		Scan(&content)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", apperr.Newf(fiber.StatusNotFound, "Revision %d not found", version)
+		}
+		return "", fmt.Errorf("fetching revision: %w", err)
+	}
+	return content.String, nil
+}
+
+// versionParam parses a version from the query string, or returns def when
+// it is absent
+func versionParam(c *fiber.Ctx, name string, def int64) (int64, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, apperr.Invalid(map[string]string{name: "must be a version number"})
+	}
+	return v, nil
+}
@@ -0,0 +1,152 @@
+package notes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// expectRevision expects the note to be copied into its revision history
+// on behalf of user123
+func expectRevision(mock sqlmock.Sqlmock, noteID any) {
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO note_revisions (note_id, version, user_id, title, content) SELECT id, version, ?, title, content FROM notes WHERE id = ?")).
+		WithArgs("user123", noteID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+// expectChange expects the notes matching where to be logged as changed
+// by op
+func expectChange(mock sqlmock.Sqlmock, op, where string, args ...driver.Value) {
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO note_changes (note_id, user_id, workspace_id, op, version) SELECT id, user_id, workspace_id, ?, version FROM notes WHERE " + where)).
+		WithArgs(append([]driver.Value{op}, args...)...).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestGetDiff(t *testing.T) {
+	noteQuery := regexp.QuoteMeta("SELECT version, encrypted FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+	revisionQuery := regexp.QuoteMeta("SELECT content FROM note_revisions WHERE note_id = ? AND version = ?")
+	note := func(version int64, encrypted bool) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"version", "encrypted"}).AddRow(version, encrypted)
+	}
+	revision := func(content any) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"content"}).AddRow(content)
+	}
+
+	testCases := []struct {
+		name            string
+		query           string
+		setupMock       func(mock sqlmock.Sqlmock)
+		expectedStatus  int
+		expectedError   string
+		expectedUnified string
+	}{
+		{
+			name:  "Latest Change",
+			query: "",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(3, false))
+				mock.ExpectQuery(revisionQuery).WithArgs("note1", int64(2)).WillReturnRows(revision("hello\nworld\n"))
+				mock.ExpectQuery(revisionQuery).WithArgs("note1", int64(3)).WillReturnRows(revision("hello\nthere\n"))
+			},
+			expectedStatus:  fiber.StatusOK,
+			expectedUnified: "--- v2\n+++ v3\n@@ -1,2 +1,2 @@\n hello\n-world\n+there\n",
+		},
+		{
+			name:  "Range",
+			query: "?from=1&to=2",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(3, false))
+				mock.ExpectQuery(revisionQuery).WithArgs("note1", int64(1)).WillReturnRows(revision(nil))
+				mock.ExpectQuery(revisionQuery).WithArgs("note1", int64(2)).WillReturnRows(revision("hello\n"))
+			},
+			expectedStatus:  fiber.StatusOK,
+			expectedUnified: "--- v1\n+++ v2\n@@ -0,0 +1 @@\n+hello\n",
+		},
+		{
+			name:  "Missing Revision",
+			query: "?from=1",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(3, false))
+				mock.ExpectQuery(revisionQuery).WithArgs("note1", int64(1)).WillReturnRows(sqlmock.NewRows([]string{"content"}))
+			},
+			expectedStatus: fiber.StatusNotFound,
+			expectedError:  "Revision 1 not found",
+		},
+		{
+			name:  "Out Of Range",
+			query: "?from=2&to=4",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(3, false))
+			},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:  "Not A Version",
+			query: "?to=latest",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(3, false))
+			},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:  "Encrypted",
+			query: "",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note(3, true))
+			},
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "Encrypted notes cannot be diffed by the server",
+		},
+		{
+			name:  "Not Found",
+			query: "",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").WillReturnRows(sqlmock.NewRows([]string{"version", "encrypted"}))
+			},
+			expectedStatus: fiber.StatusNotFound,
+			expectedError:  "Note not found or unauthorized",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("GET", "/notes/:id/diff", helper.handler.GetDiff)
+			tc.setupMock(helper.mockDB)
+
+			resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/note1/diff"+tc.query, nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var diff NoteDiff
+				if err := json.NewDecoder(resp.Body).Decode(&diff); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedUnified, diff.Unified)
+				assert.Len(t, diff.Hunks, 1)
+			}
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
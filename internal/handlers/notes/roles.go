@@ -0,0 +1,183 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"quanta/internal/access"
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/models"
+	"quanta/internal/notifications"
+	"quanta/internal/validate"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Collaborator is someone with access to a note and their role on it
+type Collaborator struct {
+	UserID      string  `json:"user_id"`
+	DisplayName *string `json:"display_name"`
+	Role        string  `json:"role"`
+}
+
+// RolePayload is the request body for SetRole
+type RolePayload struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// errReadOnly answers a change to a note the user may only read
+var errReadOnly = access.ErrReadOnly
+
+// GetCollaborators lists everyone with access to a note and their roles
+func (h *Handler) GetCollaborators(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+	ctx := c.UserContext()
+
+	owner, workspaceID, err := h.noteOwner(ctx, userID, noteID)
+	if err != nil {
+		return err
+	}
+
+	collaborators, arg := collaboratorsOf(owner, workspaceID)
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT u.id, u.display_name, c.role FROM users u LEFT JOIN note_collaborators c ON c.note_id = ? AND c.user_id = u.id WHERE u.deleted_at IS NULL AND "+collaborators+" ORDER BY u.id",
+		noteID, arg,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching collaborators: %w", err)
+	}
+	defer closeRows(rows)
+
+	list := []Collaborator{}
+	for rows.Next() {
+		var collaborator Collaborator
+		var displayName, role sql.NullString
+		if err := rows.Scan(&collaborator.UserID, &displayName, &role); err != nil {
+			return fmt.Errorf("scanning collaborator: %w", err)
+		}
+		if displayName.Valid {
+			collaborator.DisplayName = &displayName.String
+		}
+		collaborator.Role = models.ResolveNoteRole(owner, collaborator.UserID, role.String)
+		list = append(list, collaborator)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating collaborators: %w", err)
+	}
+
+	return c.JSON(list)
+}
+
+// SetRole changes what a collaborator may do with a note. Only the note's
+// owner can change roles, and only for users who can access the note; the
+// owner's own role is fixed. The collaborator's open realtime connections
+// pick up the new role straight away. Giving a collaborator a role for the
+// first time is recorded as sharing the note, and they are notified.
+func (h *Handler) SetRole(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID, targetID := c.Params("id"), c.Params("userId")
+	ctx := c.UserContext()
+
+	var payload RolePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if errs := validate.Struct(&payload); errs != nil {
+		return apperr.Invalid(errs)
+	}
+	if !slices.Contains(models.NoteRoles, payload.Role) {
+		return apperr.Invalid(map[string]string{"role": "must be viewer, commenter or editor"})
+	}
+
+	owner, workspaceID, err := h.noteOwner(ctx, userID, noteID)
+	if err != nil {
+		return err
+	}
+	if owner != userID {
+		return apperr.New(fiber.StatusForbidden, "Only the note's owner can change roles")
+	}
+	if targetID == owner {
+		return apperr.New(fiber.StatusBadRequest, "The owner's role cannot be changed")
+	}
+
+	collaborators, arg := collaboratorsOf(owner, workspaceID)
+	var exists bool
+	err = h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users u WHERE u.id = ? AND u.deleted_at IS NULL AND "+collaborators+")", targetID, arg).
+		Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("checking collaborator: %w", err)
+	}
+	if !exists {
+		return apperr.New(fiber.StatusNotFound, "User not found or cannot access this note")
+	}
+
+	shared := false
+	result, err := h.db.ExecContext(ctx, "UPDATE note_collaborators SET role = ? WHERE note_id = ? AND user_id = ?", payload.Role, noteID, targetID)
+	if err == nil {
+		if n, _ := result.RowsAffected(); n == 0 {
+			_, err = h.db.ExecContext(ctx, "INSERT INTO note_collaborators (note_id, user_id, role) VALUES (?, ?, ?)", noteID, targetID, payload.Role)
+			shared = err == nil
+		}
+	}
+	// A duplicate means the row exists, either from a concurrent change or
+	// because MySQL counts an unchanged row as unaffected
+	if err != nil && !db.IsDuplicate(err) {
+		return fmt.Errorf("setting collaborator role: %w", err)
+	}
+
+	if h.rooms != nil {
+		h.rooms.SetRole(ctx, noteID, targetID, payload.Role)
+	}
+	if shared {
+		h.activity.Record(ctx, noteID, userID, activity.ActionShared, map[string]string{"user_id": targetID, "role": payload.Role})
+	}
+	if shared && h.notifier != nil {
+		err := h.notifier.Notify(ctx, targetID, notifications.KindShareInvite, map[string]string{
+			"note_id":   noteID,
+			"role":      payload.Role,
+			"shared_by": userID,
+		})
+		if err != nil {
+			log.Printf("Error notifying %s of shared note %s: %v", targetID, noteID, err)
+		}
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// noteOwner looks up a note the user can access, returning its author and
+// workspace
+func (h *Handler) noteOwner(ctx context.Context, userID, noteID string) (string, sql.NullString, error) {
+	var owner string
+	var workspaceID sql.NullString
+	err := h.db.QueryRowContext(ctx, "SELECT user_id, workspace_id FROM notes WHERE id = ? AND "+accessible, noteID, userID, userID).
+		Scan(&owner, &workspaceID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", workspaceID, apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+		}
+		return "", workspaceID, fmt.Errorf("fetching note: %w", err)
+	}
+	return owner, workspaceID, nil
+}
+
+// requireEditor returns errReadOnly if the user was given a role that
+// cannot change the note. Whether they can access it at all is left to the
+// caller.
+func (h *Handler) requireEditor(ctx context.Context, noteID, userID string) error {
+	return access.RequireEditor(ctx, h.db, noteID, userID)
+}
@@ -0,0 +1,175 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var noteOwnerQuery = regexp.QuoteMeta("SELECT user_id, workspace_id FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+
+func TestGetCollaborators(t *testing.T) {
+	helper := newTestHelper(t)
+	helper.setupRoute("GET", "/notes/:id/collaborators", helper.handler.GetCollaborators)
+
+	helper.mockDB.ExpectQuery(noteOwnerQuery).WithArgs("note1", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "workspace_id"}).AddRow("user456", "ws1"))
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT u.id, u.display_name, c.role FROM users u LEFT JOIN note_collaborators c ON c.note_id = ? AND c.user_id = u.id WHERE u.deleted_at IS NULL AND u.id IN (SELECT user_id FROM workspace_members WHERE workspace_id = ?) ORDER BY u.id")).
+		WithArgs("note1", "ws1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "display_name", "role"}).
+			AddRow("user123", "Me", "viewer").
+			AddRow("user456", "Author", nil).
+			AddRow("user789", nil, nil))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/note1/collaborators", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var collaborators []Collaborator
+	if err := json.NewDecoder(resp.Body).Decode(&collaborators); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, collaborators, 3) {
+		assert.Equal(t, "viewer", collaborators[0].Role)
+		assert.Equal(t, "owner", collaborators[1].Role)
+		assert.Equal(t, "editor", collaborators[2].Role, "workspace members edit by default")
+		assert.Nil(t, collaborators[2].DisplayName)
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	collaborator := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM users u WHERE u.id = ? AND u.deleted_at IS NULL AND u.id IN (SELECT user_id FROM workspace_members WHERE workspace_id = ?))")
+	update := regexp.QuoteMeta("UPDATE note_collaborators SET role = ? WHERE note_id = ? AND user_id = ?")
+	insert := regexp.QuoteMeta("INSERT INTO note_collaborators (note_id, user_id, role) VALUES (?, ?, ?)")
+	note := func(owner string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"user_id", "workspace_id"}).AddRow(owner, "ws1")
+	}
+
+	testCases := []struct {
+		name           string
+		target         string
+		body           string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expectedError  string
+		announced      []string
+		notified       []string
+		recorded       []activity.Action
+	}{
+		{
+			name:   "New Role",
+			target: "user456",
+			body:   `{"role":"viewer"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteOwnerQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note("user123"))
+				mock.ExpectQuery(collaborator).WithArgs("user456", "ws1").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectExec(update).WithArgs("viewer", "note1", "user456").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insert).WithArgs("note1", "user456", "viewer").WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			expectedStatus: fiber.StatusNoContent,
+			announced:      []string{"user456:viewer"},
+			notified:       []string{"user456:share_invite:note1"},
+			recorded:       []activity.Action{activity.ActionShared},
+		},
+		{
+			name:   "Changed Role",
+			target: "user456",
+			body:   `{"role":"editor"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteOwnerQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note("user123"))
+				mock.ExpectQuery(collaborator).WithArgs("user456", "ws1").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectExec(update).WithArgs("editor", "note1", "user456").WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedStatus: fiber.StatusNoContent,
+			announced:      []string{"user456:editor"},
+		},
+		{
+			name:   "Not The Owner",
+			target: "user789",
+			body:   `{"role":"viewer"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteOwnerQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note("user456"))
+			},
+			expectedStatus: fiber.StatusForbidden,
+			expectedError:  "Only the note's owner can change roles",
+		},
+		{
+			name:   "Own Role",
+			target: "user123",
+			body:   `{"role":"viewer"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteOwnerQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note("user123"))
+			},
+			expectedStatus: fiber.StatusBadRequest,
+			expectedError:  "The owner's role cannot be changed",
+		},
+		{
+			name:   "Not A Collaborator",
+			target: "user999",
+			body:   `{"role":"viewer"}`,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteOwnerQuery).WithArgs("note1", "user123", "user123").WillReturnRows(note("user123"))
+				mock.ExpectQuery(collaborator).WithArgs("user999", "ws1").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+			expectedStatus: fiber.StatusNotFound,
+			expectedError:  "User not found or cannot access this note",
+		},
+		{
+			name:           "Unknown Role",
+			target:         "user456",
+			body:           `{"role":"owner"}`,
+			setupMock:      func(mock sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("PUT", "/notes/:id/collaborators/:userId", helper.handler.SetRole)
+			tc.setupMock(helper.mockDB)
+
+			req := httptest.NewRequest("PUT", "/notes/note1/collaborators/"+tc.target, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tc.announced, helper.rooms.roles)
+			assert.Equal(t, tc.notified, helper.notifier.sent)
+			var recorded []activity.Action
+			for _, a := range helper.recorder.recorded {
+				recorded = append(recorded, a.action)
+			}
+			assert.Equal(t, tc.recorded, recorded)
+
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
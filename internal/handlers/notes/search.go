@@ -0,0 +1,214 @@
+package notes
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/handlers/workspaces"
+	"quanta/internal/paging"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// DefaultSearchLimit is how many notes a search returns unless ?limit=
+	// asks for fewer or more
+	DefaultSearchLimit = 20
+	// MaxSearchLimit caps ?limit= on searches
+	MaxSearchLimit = 100
+)
+
+// SearchResult is a page of the notes matching a workspace search. Total
+// counts every match and Facets break them down, so clients can offer
+// filters that narrow the search.
+type SearchResult struct {
+	Notes  []Note       `json:"notes"`
+	Total  int          `json:"total"`
+	Facets SearchFacets `json:"facets"`
+}
+
+// SearchFacets counts the matching notes by author and by the notebook and
+// tags in their metadata
+type SearchFacets struct {
+	Authors   []Facet `json:"authors"`
+	Notebooks []Facet `json:"notebooks"`
+	Tags      []Facet `json:"tags"`
+}
+
+// Facet is one value of a facet and how many matching notes have it. Name
+// is an author's display name, when they have one.
+type Facet struct {
+	Value string  `json:"value"`
+	Name  *string `json:"name,omitempty"`
+	Count int     `json:"count"`
+}
+
+// SearchWorkspace searches the titles and content of a workspace's active
+// notes for ?q=, case-insensitively. Only the workspace's owners and admins
+// may search it. Results are trimmed to the notes the member can open:
+// encrypted notes, which the server cannot read, are left out. ?author=,
+// ?notebook= and ?tag= narrow the search to one facet value and ?limit=
+// and ?offset= page through the matches, most recently updated first.
+func (h *Handler) SearchWorkspace(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return apperr.New(fiber.StatusBadRequest, "Search query is required")
+	}
+	limit, offset, err := paging.Parse(c, DefaultSearchLimit, MaxSearchLimit)
+	if err != nil {
+		return err
+	}
+	if _, err := workspaces.RequireRole(c, h.db, workspaceID, workspaces.RoleOwner, workspaces.RoleAdmin); err != nil {
+		return err
+	}
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	pattern := "%" + db.EscapeLike(strings.ToLower(q)) + "%"
+	where := "workspace_id = ? AND " + accessible + " AND archived = ? AND encrypted = ? AND (LOWER(title) LIKE ? ESCAPE '!' OR LOWER(content) LIKE ? ESCAPE '!')"
+	args := []any{workspaceID, userID, userID, false, false, pattern, pattern}
+	if author := c.Query("author"); author != "" {
+		where += " AND user_id = ?"
+		args = append(args, author)
+	}
+	if notebook := c.Query("notebook"); notebook != "" {
+		where += " AND " + h.dialect.JSONText("metadata", "notebook") + " = ?"
+		args = append(args, notebook)
+	}
+	if tag := c.Query("tag"); tag != "" {
+		where += " AND " + h.dialect.JSONHolds("metadata", "tags")
+		args = append(args, tag)
+	}
+
+	result := SearchResult{Notes: []Note{}}
+	if err := h.db.QueryRowContext(c.UserContext(), "SELECT COUNT(*) FROM notes WHERE "+where, args...).Scan(&result.Total); err != nil {
+		return fmt.Errorf("counting matches: %w", err)
+	}
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT "+noteColumns+" FROM notes WHERE "+where+" ORDER BY updated_at DESC, id LIMIT ? OFFSET ?",
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return fmt.Errorf("searching notes: %w", err)
+	}
+	defer closeRows(rows)
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			return fmt.Errorf("scanning note: %w", err)
+		}
+		result.Notes = append(result.Notes, n)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("searching notes: %w", err)
+	}
+
+	if result.Facets, err = h.searchFacets(c.UserContext(), where, args); err != nil {
+		return err
+	}
+	return c.JSON(result)
+}
+
+// searchFacets counts the notes matching a search by author, notebook and
+// tag. Only the author and metadata of each match are read.
+func (h *Handler) searchFacets(ctx context.Context, where string, args []any) (SearchFacets, error) {
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT user_id, (SELECT display_name FROM users WHERE users.id = notes.user_id), metadata FROM notes WHERE "+where,
+		args...,
+	)
+	if err != nil {
+		return SearchFacets{}, fmt.Errorf("counting facets: %w", err)
+	}
+	defer closeRows(rows)
+
+	authors := map[string]*Facet{}
+	notebooks := map[string]*Facet{}
+	tags := map[string]*Facet{}
+	for rows.Next() {
+		var author string
+		var name, raw sql.NullString
+		if err := rows.Scan(&author, &name, &raw); err != nil {
+			return SearchFacets{}, fmt.Errorf("scanning facets: %w", err)
+		}
+		metadata, err := parseMetadata(raw)
+		if err != nil {
+			return SearchFacets{}, fmt.Errorf("scanning facets: %w", err)
+		}
+		tally(authors, author).Name = nullable(name)
+		if notebook := metadata.notebook(); notebook != "" {
+			tally(notebooks, notebook)
+		}
+		for _, t := range metadata.tags() {
+			tally(tags, t)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return SearchFacets{}, fmt.Errorf("counting facets: %w", err)
+	}
+
+	return SearchFacets{
+		Authors:   sortedFacets(authors),
+		Notebooks: sortedFacets(notebooks),
+		Tags:      sortedFacets(tags),
+	}, nil
+}
+
+// notebook returns the notebook a note was filed in, as imports record it
+// in the notebook key of its metadata
+func (m Metadata) notebook() string {
+	notebook, _ := m["notebook"].(string)
+	return notebook
+}
+
+// tags returns the distinct tags in the tags key of a note's metadata,
+// which holds a list of them or a single one
+func (m Metadata) tags() []string {
+	var tags []string
+	switch v := m["tags"].(type) {
+	case string:
+		if v != "" {
+			tags = append(tags, v)
+		}
+	case []any:
+		for _, item := range v {
+			if t, ok := item.(string); ok && t != "" && !slices.Contains(tags, t) {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// tally adds a note to the facet value in facets, returning it
+func tally(facets map[string]*Facet, value string) *Facet {
+	f, ok := facets[value]
+	if !ok {
+		f = &Facet{Value: value}
+		facets[value] = f
+	}
+	f.Count++
+	return f
+}
+
+// sortedFacets lists facets by how many notes have them, then by value
+func sortedFacets(facets map[string]*Facet) []Facet {
+	list := make([]Facet, 0, len(facets))
+	for _, f := range facets {
+		list = append(list, *f)
+	}
+	slices.SortFunc(list, func(a, b Facet) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Value, b.Value))
+	})
+	return list
+}
@@ -0,0 +1,204 @@
+package notes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchWorkspace(t *testing.T) {
+	roleQuery := regexp.QuoteMeta("SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?")
+	where := "FROM notes WHERE workspace_id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)) AND archived = ? AND encrypted = ? AND (LOWER(title) LIKE ? ESCAPE '!' OR LOWER(content) LIKE ? ESCAPE '!')"
+	columns := []string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}
+	now := time.Now()
+	stored := map[string][]driver.Value{
+		"note1": {"note1", "user1", "ws1", "Onboarding", "", false, false, 1, now, now, 0, 0, false, `{"notebook":"Handbook","tags":["hr","process"]}`, nil, nil},
+		"note2": {"note2", "user2", "ws1", "Deploy process", "", false, false, 1, now, now, 0, 0, false, `{"notebook":"Runbooks","tags":["ops","process"]}`, nil, nil},
+		"note3": {"note3", "user1", "ws1", "Hiring process", "", false, false, 1, now, now, 0, 0, false, `{"notebook":"Handbook","tags":"hr"}`, nil, nil},
+	}
+	names := map[string]driver.Value{"user1": "Ada", "user2": nil}
+	ada := "Ada"
+	all := []string{"note1", "note2", "note3"}
+
+	testCases := []struct {
+		name           string
+		query          string
+		role           string
+		pattern        string
+		filters        string
+		filterArgs     []driver.Value
+		limit, offset  int
+		matches        []string
+		expectedStatus int
+		expectedIDs    []string
+		expectedFacets *SearchFacets
+	}{
+		{
+			name:           "Facets",
+			query:          "?q=Process",
+			role:           "admin",
+			pattern:        "%process%",
+			limit:          DefaultSearchLimit,
+			matches:        all,
+			expectedStatus: fiber.StatusOK,
+			expectedIDs:    all,
+			expectedFacets: &SearchFacets{
+				Authors:   []Facet{{Value: "user1", Name: &ada, Count: 2}, {Value: "user2", Count: 1}},
+				Notebooks: []Facet{{Value: "Handbook", Count: 2}, {Value: "Runbooks", Count: 1}},
+				Tags:      []Facet{{Value: "hr", Count: 2}, {Value: "process", Count: 2}, {Value: "ops", Count: 1}},
+			},
+		},
+		{
+			name:           "Notebook And Tag",
+			query:          "?q=process&notebook=Handbook&tag=hr",
+			role:           "owner",
+			pattern:        "%process%",
+			filters:        " AND JSON_UNQUOTE(JSON_EXTRACT(metadata, '$.notebook')) = ? AND JSON_CONTAINS(JSON_EXTRACT(metadata, '$.tags'), JSON_QUOTE(?))",
+			filterArgs:     []driver.Value{"Handbook", "hr"},
+			limit:          DefaultSearchLimit,
+			matches:        []string{"note1", "note3"},
+			expectedStatus: fiber.StatusOK,
+			expectedIDs:    []string{"note1", "note3"},
+			expectedFacets: &SearchFacets{
+				Authors:   []Facet{{Value: "user1", Name: &ada, Count: 2}},
+				Notebooks: []Facet{{Value: "Handbook", Count: 2}},
+				Tags:      []Facet{{Value: "hr", Count: 2}, {Value: "process", Count: 1}},
+			},
+		},
+		{
+			name:           "Author",
+			query:          "?q=process&author=user1",
+			role:           "admin",
+			pattern:        "%process%",
+			filters:        " AND user_id = ?",
+			filterArgs:     []driver.Value{"user1"},
+			limit:          DefaultSearchLimit,
+			matches:        []string{"note1", "note3"},
+			expectedStatus: fiber.StatusOK,
+			expectedIDs:    []string{"note1", "note3"},
+		},
+		{
+			name:           "Page",
+			query:          "?q=process&limit=1&offset=1",
+			role:           "admin",
+			pattern:        "%process%",
+			limit:          1,
+			offset:         1,
+			matches:        all,
+			expectedStatus: fiber.StatusOK,
+			expectedIDs:    []string{"note2"},
+		},
+		{
+			name:           "Past The Last Page",
+			query:          "?q=process&offset=10",
+			role:           "admin",
+			pattern:        "%process%",
+			limit:          DefaultSearchLimit,
+			offset:         10,
+			matches:        all,
+			expectedStatus: fiber.StatusOK,
+			expectedIDs:    []string{},
+		},
+		{
+			name:           "Wildcards Are Literal",
+			query:          "?q=100%25_done",
+			role:           "admin",
+			pattern:        "%100!%!_done%",
+			limit:          DefaultSearchLimit,
+			matches:        all,
+			expectedStatus: fiber.StatusOK,
+			expectedIDs:    all,
+		},
+		{
+			name:           "Missing Query",
+			query:          "?q=%20",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Limit",
+			query:          "?q=process&limit=0",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Member",
+			query:          "?q=process",
+			role:           "member",
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name:           "Not A Member",
+			query:          "?q=process",
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("GET", "/workspaces/:id/search", helper.handler.SearchWorkspace)
+
+			if tc.expectedStatus != fiber.StatusBadRequest {
+				roles := sqlmock.NewRows([]string{"role"})
+				if tc.role != "" {
+					roles.AddRow(tc.role)
+				}
+				helper.mockDB.ExpectQuery(roleQuery).WithArgs("ws1", "user123").WillReturnRows(roles)
+			}
+			if tc.pattern != "" {
+				query := where + tc.filters
+				args := append([]driver.Value{"ws1", "user123", "user123", false, false, tc.pattern, tc.pattern}, tc.filterArgs...)
+
+				helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) " + query)).
+					WithArgs(args...).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(len(tc.matches)))
+				page := sqlmock.NewRows(columns)
+				for _, id := range tc.expectedIDs {
+					page.AddRow(stored[id]...)
+				}
+				helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon " + query + " ORDER BY updated_at DESC, id LIMIT ? OFFSET ?")).
+					WithArgs(append(args, tc.limit, tc.offset)...).
+					WillReturnRows(page)
+				facets := sqlmock.NewRows([]string{"user_id", "display_name", "metadata"})
+				for _, id := range tc.matches {
+					author := stored[id][1].(string)
+					facets.AddRow(author, names[author], stored[id][13])
+				}
+				helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT user_id, (SELECT display_name FROM users WHERE users.id = notes.user_id), metadata " + query)).
+					WithArgs(args...).
+					WillReturnRows(facets)
+			}
+
+			resp, err := helper.app.Test(httptest.NewRequest("GET", "/workspaces/ws1/search"+tc.query, nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var result SearchResult
+				if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				ids := []string{}
+				for _, n := range result.Notes {
+					ids = append(ids, n.ID)
+				}
+				assert.Equal(t, tc.expectedIDs, ids)
+				assert.Equal(t, len(tc.matches), result.Total)
+				if tc.expectedFacets != nil {
+					assert.Equal(t, *tc.expectedFacets, result.Facets)
+				}
+			}
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
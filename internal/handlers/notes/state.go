@@ -0,0 +1,60 @@
+package notes
+
+import (
+	"fmt"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PinNote pins a note to the top of its list
+func (h *Handler) PinNote(c *fiber.Ctx) error {
+	return h.setState(c, "pinned", true)
+}
+
+// UnpinNote removes a note's pin
+func (h *Handler) UnpinNote(c *fiber.Ctx) error {
+	return h.setState(c, "pinned", false)
+}
+
+// ArchiveNote hides a note from the default list
+func (h *Handler) ArchiveNote(c *fiber.Ctx) error {
+	return h.setState(c, "archived", true)
+}
+
+// UnarchiveNote returns an archived note to the default list
+func (h *Handler) UnarchiveNote(c *fiber.Ctx) error {
+	return h.setState(c, "archived", false)
+}
+
+// setState sets one of the note's boolean state columns. Pinning and
+// archiving are not edits, so updated_at is explicitly kept as it was
+// (MySQL would otherwise bump it).
+func (h *Handler) setState(c *fiber.Ctx, column string, value bool) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+
+	if err := h.requireEditor(c.UserContext(), noteID, userID); err != nil {
+		return err
+	}
+
+	result, err := h.db.ExecContext(c.UserContext(),
+		"UPDATE notes SET "+column+" = ?, updated_at = updated_at WHERE id = ? AND "+accessible,
+		value, noteID, userID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating note %s: %w", column, err)
+	}
+
+	affectedRows, _ := result.RowsAffected()
+	if affectedRows == 0 {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
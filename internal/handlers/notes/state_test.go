@@ -0,0 +1,116 @@
+package notes
+
+import (
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetState(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("POST", "/notes/:id/pin", helper.handler.PinNote)
+	helper.setupRoute("POST", "/notes/:id/unpin", helper.handler.UnpinNote)
+	helper.setupRoute("POST", "/notes/:id/archive", helper.handler.ArchiveNote)
+	helper.setupRoute("POST", "/notes/:id/unarchive", helper.handler.UnarchiveNote)
+
+	testCases := []struct {
+		name           string
+		path           string
+		column         string
+		value          bool
+		rowsAffected   int64
+		mockError      error
+		role           string
+		expectedStatus int
+	}{
+		{
+			name:           "Pin",
+			path:           "/notes/note1/pin",
+			column:         "pinned",
+			value:          true,
+			rowsAffected:   1,
+			expectedStatus: fiber.StatusNoContent,
+		},
+		{
+			name:           "Unpin",
+			path:           "/notes/note1/unpin",
+			column:         "pinned",
+			value:          false,
+			rowsAffected:   1,
+			expectedStatus: fiber.StatusNoContent,
+		},
+		{
+			name:           "Archive",
+			path:           "/notes/note1/archive",
+			column:         "archived",
+			value:          true,
+			rowsAffected:   1,
+			expectedStatus: fiber.StatusNoContent,
+		},
+		{
+			name:           "Unarchive",
+			path:           "/notes/note1/unarchive",
+			column:         "archived",
+			value:          false,
+			rowsAffected:   1,
+			expectedStatus: fiber.StatusNoContent,
+		},
+		{
+			name:           "Note Not Found",
+			path:           "/notes/note1/pin",
+			column:         "pinned",
+			value:          true,
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Viewer",
+			path:           "/notes/note1/archive",
+			column:         "archived",
+			value:          true,
+			role:           "viewer",
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name:           "Database Error",
+			path:           "/notes/note1/archive",
+			column:         "archived",
+			value:          true,
+			mockError:      errors.New("database error"),
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper.rooms.deleted = nil
+			helper.expectRole("note1", tc.role)
+			if tc.role == "" {
+				query := regexp.QuoteMeta("UPDATE notes SET " + tc.column + " = ?, updated_at = updated_at WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")
+				expect := helper.mockDB.ExpectExec(query).WithArgs(tc.value, "note1", "user123", "user123")
+				if tc.mockError != nil {
+					expect.WillReturnError(tc.mockError)
+				} else {
+					expect.WillReturnResult(sqlmock.NewResult(0, tc.rowsAffected))
+				}
+			}
+
+			resp, err := helper.app.Test(httptest.NewRequest("POST", tc.path, nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Empty(t, helper.rooms.deleted, "archiving leaves the note's room open")
+		})
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
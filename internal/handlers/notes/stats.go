@@ -0,0 +1,117 @@
+package notes
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// WordsPerMinute is the reading speed reading times are estimated at
+const WordsPerMinute = 200
+
+// MostEditedLimit is how many notes the dashboard lists as most edited
+const MostEditedLimit = 5
+
+// NoteStats describes a note's content. The counts are stored with the
+// note on every write so listings don't recount them.
+type NoteStats struct {
+	WordCount int `json:"word_count"`
+	CharCount int `json:"char_count"`
+	// ReadingMinutes is the estimated reading time, rounded up
+	ReadingMinutes int `json:"reading_minutes"`
+}
+
+// statsOf counts the words and characters of content
+func statsOf(content string) NoteStats {
+	return withReadingTime(NoteStats{
+		WordCount: len(strings.Fields(content)),
+		CharCount: utf8.RuneCountInString(content),
+	})
+}
+
+// contentStats is statsOf for a note's content. An encrypted note's content
+// is ciphertext, so it gets no statistics.
+func contentStats(encrypted bool, content string) NoteStats {
+	if encrypted {
+		return NoteStats{}
+	}
+	return statsOf(content)
+}
+
+// withReadingTime fills in ReadingMinutes from WordCount
+func withReadingTime(s NoteStats) NoteStats {
+	s.ReadingMinutes = (s.WordCount + WordsPerMinute - 1) / WordsPerMinute
+	return s
+}
+
+// EditedNote is a note on the dashboard's most edited list. Edits counts
+// the updates since the note was created.
+type EditedNote struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Edits     int64     `json:"edits"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Dashboard summarizes the notes a user can access
+type Dashboard struct {
+	TotalNotes     int          `json:"total_notes"`
+	EditedThisWeek int          `json:"edited_this_week"`
+	TotalWords     int64        `json:"total_words"`
+	MostEdited     []EditedNote `json:"most_edited"`
+}
+
+// GetStats summarizes the user's private notes and the notes of their
+// workspaces for a dashboard: how many there are, how many were edited in
+// the last seven days and which have been edited the most
+func (h *Handler) GetStats(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	weekAgo := time.Now().UTC().AddDate(0, 0, -7)
+
+	var d Dashboard
+	err = h.db.QueryRowContext(ctx,
+		"SELECT COUNT(*), COALESCE(SUM(CASE WHEN updated_at >= ? THEN 1 ELSE 0 END), 0), COALESCE(SUM(word_count), 0) FROM notes WHERE "+accessible,
+		weekAgo, userID, userID,
+	).Scan(&d.TotalNotes, &d.EditedThisWeek, &d.TotalWords)
+	if err != nil {
+		return fmt.Errorf("summarizing notes: %w", err)
+	}
+
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT id, title, version - 1, updated_at FROM notes WHERE version > 1 AND "+accessible+" ORDER BY version DESC, updated_at DESC LIMIT ?",
+		userID, userID, MostEditedLimit,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching most edited notes: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	d.MostEdited = []EditedNote{}
+	for rows.Next() {
+		var n EditedNote
+		if err := rows.Scan(&n.ID, &n.Title, &n.Edits, &n.UpdatedAt); err != nil {
+			return fmt.Errorf("scanning note: %w", err)
+		}
+		d.MostEdited = append(d.MostEdited, n)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching most edited notes: %w", err)
+	}
+
+	return c.JSON(d)
+}
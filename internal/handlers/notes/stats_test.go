@@ -0,0 +1,61 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsOf(t *testing.T) {
+	assert.Equal(t, NoteStats{}, statsOf(""))
+	assert.Equal(t, NoteStats{WordCount: 3, CharCount: 18, ReadingMinutes: 1}, statsOf("Grüße aus\n  Zürich"))
+
+	long := strings.Repeat("word ", WordsPerMinute+1)
+	assert.Equal(t, 2, statsOf(long).ReadingMinutes)
+}
+
+func TestGetStats(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/me/stats", helper.handler.GetStats)
+
+	now := time.Now()
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*), COALESCE(SUM(CASE WHEN updated_at >= ? THEN 1 ELSE 0 END), 0), COALESCE(SUM(word_count), 0) FROM notes WHERE ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))")).
+		WithArgs(sqlmock.AnyArg(), "user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"total", "edited", "words"}).AddRow(12, 3, 4800))
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, title, version - 1, updated_at FROM notes WHERE version > 1 AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)) ORDER BY version DESC, updated_at DESC LIMIT ?")).
+		WithArgs("user123", "user123", MostEditedLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "edits", "updated_at"}).
+			AddRow("note1", "Roadmap", 41, now).
+			AddRow("note2", "Groceries", 7, now))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/me/stats", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var dashboard Dashboard
+	if err := json.NewDecoder(resp.Body).Decode(&dashboard); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.Equal(t, 12, dashboard.TotalNotes)
+	assert.Equal(t, 3, dashboard.EditedThisWeek)
+	assert.Equal(t, int64(4800), dashboard.TotalWords)
+	if assert.Len(t, dashboard.MostEdited, 2) {
+		assert.Equal(t, "note1", dashboard.MostEdited[0].ID)
+		assert.Equal(t, int64(41), dashboard.MostEdited[0].Edits)
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
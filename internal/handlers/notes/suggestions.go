@@ -0,0 +1,77 @@
+package notes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/processors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// NoteSuggestions is the response to GetSuggestions. Version is the note
+// version the suggestions were made for, null when there are none. When it
+// is behind the note's, the offsets may no longer line up; the note is
+// processed again shortly after each edit.
+type NoteSuggestions struct {
+	Version     *int64                  `json:"version"`
+	Suggestions []processors.Suggestion `json:"suggestions"`
+}
+
+// GetSuggestions lists what the content processors, such as a spelling and
+// grammar checker, suggest changing in a note, in order of position
+func (h *Handler) GetSuggestions(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+	ctx := c.UserContext()
+
+	var encrypted bool
+	err = h.db.QueryRowContext(ctx, "SELECT encrypted FROM notes WHERE id = ? AND "+accessible, noteID, userID, userID).
+		Scan(&encrypted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+		}
+		return fmt.Errorf("fetching note: %w", err)
+	}
+	response := NoteSuggestions{Suggestions: []processors.Suggestion{}}
+	// Ciphertext is never sent to the processors
+	if encrypted {
+		return c.JSON(response)
+	}
+
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT version, processor, rule, message, position, length, replacements FROM note_suggestions WHERE note_id = ? ORDER BY position, processor",
+		noteID,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching suggestions: %w", err)
+	}
+	defer closeRows(rows)
+
+	for rows.Next() {
+		var version int64
+		var s processors.Suggestion
+		var replacements string
+		if err := rows.Scan(&version, &s.Processor, &s.Rule, &s.Message, &s.Offset, &s.Length, &replacements); err != nil {
+			return fmt.Errorf("scanning suggestion: %w", err)
+		}
+		if err := json.Unmarshal([]byte(replacements), &s.Replacements); err != nil {
+			return fmt.Errorf("decoding replacements: %w", err)
+		}
+		response.Version = &version
+		response.Suggestions = append(response.Suggestions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating suggestions: %w", err)
+	}
+
+	return c.JSON(response)
+}
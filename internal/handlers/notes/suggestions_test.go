@@ -0,0 +1,100 @@
+package notes
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"quanta/internal/processors"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSuggestions(t *testing.T) {
+	noteQuery := regexp.QuoteMeta("SELECT encrypted FROM notes WHERE id = ? AND " + accessible)
+	suggestionsQuery := regexp.QuoteMeta("SELECT version, processor, rule, message, position, length, replacements FROM note_suggestions WHERE note_id = ? ORDER BY position, processor")
+	version := int64(3)
+
+	testCases := []struct {
+		name           string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expected       NoteSuggestions
+	}{
+		{
+			name: "Suggestions",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"encrypted"}).AddRow(false))
+				mock.ExpectQuery(suggestionsQuery).WithArgs("note1").
+					WillReturnRows(sqlmock.NewRows([]string{"version", "processor", "rule", "message", "position", "length", "replacements"}).
+						AddRow(3, "languagetool", "MORFOLOGIK_RULE_EN_US", "Possible spelling mistake found.", 0, 5, `["This","Thus"]`))
+			},
+			expectedStatus: fiber.StatusOK,
+			expected: NoteSuggestions{Version: &version, Suggestions: []processors.Suggestion{{
+				Processor:    "languagetool",
+				Rule:         "MORFOLOGIK_RULE_EN_US",
+				Message:      "Possible spelling mistake found.",
+				Length:       5,
+				Replacements: []string{"This", "Thus"},
+			}}},
+		},
+		{
+			name: "None",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"encrypted"}).AddRow(false))
+				mock.ExpectQuery(suggestionsQuery).WithArgs("note1").
+					WillReturnRows(sqlmock.NewRows([]string{"version", "processor", "rule", "message", "position", "length", "replacements"}))
+			},
+			expectedStatus: fiber.StatusOK,
+			expected:       NoteSuggestions{Suggestions: []processors.Suggestion{}},
+		},
+		{
+			name: "Encrypted",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"encrypted"}).AddRow(true))
+			},
+			expectedStatus: fiber.StatusOK,
+			expected:       NoteSuggestions{Suggestions: []processors.Suggestion{}},
+		},
+		{
+			name: "Not Found",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"encrypted"}))
+			},
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("GET", "/notes/:id/suggestions", helper.handler.GetSuggestions)
+			tc.setupMock(helper.mockDB)
+
+			resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/note1/suggestions", nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var got NoteSuggestions
+				if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expected, got)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/changelog"
+	"quanta/internal/db"
+	"quanta/internal/quota"
+	"quanta/internal/validate"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// MaxSyncChanges is the largest batch a single sync request may carry
+const MaxSyncChanges = 100
+
+// SyncOp is the kind of change a client made while offline
+type SyncOp string
+
+// Supported sync operations
+const (
+	SyncCreate SyncOp = "create"
+	SyncUpdate SyncOp = "update"
+	SyncDelete SyncOp = "delete"
+)
+
+// SyncStatus is the outcome of a single change in a sync batch
+type SyncStatus string
+
+// Sync outcomes. A conflict carries the server's copy of the note so the
+// client can merge; a rejected change is invalid and should not be retried.
+const (
+	SyncApplied  SyncStatus = "applied"
+	SyncConflict SyncStatus = "conflict"
+	SyncRejected SyncStatus = "rejected"
+)
+
+// SyncChange is one change made on a client. Creates carry a client
+// generated UUID; updates and deletes carry the version the client last
+// saw in BaseVersion. ClientUpdatedAt is when the change was made on the
+// device and is echoed back in conflicts for the client's merge logic.
+type SyncChange struct {
+	ID              string    `json:"id"`
+	Op              SyncOp    `json:"op"`
+	BaseVersion     int64     `json:"base_version"`
+	Title           string    `json:"title"`
+	Content         string    `json:"content"`
+	ClientUpdatedAt time.Time `json:"client_updated_at"`
+}
+
+// SyncRequest is the request body for Sync
+type SyncRequest struct {
+	Changes []SyncChange `json:"changes"`
+}
+
+// SyncResult reports what happened to one change. Version is the note's
+// version after an applied create or update. For conflicts, Server is the
+// note as stored, or null when it was deleted on the server, and Client is
+// the change that could not be applied.
+type SyncResult struct {
+	ID      string            `json:"id"`
+	Op      SyncOp            `json:"op"`
+	Status  SyncStatus        `json:"status"`
+	Version int64             `json:"version,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+	Server  *Note             `json:"server,omitempty"`
+	Client  *SyncChange       `json:"client,omitempty"`
+}
+
+// SyncResponse is the response body for Sync, with one result per change
+// in request order
+type SyncResponse struct {
+	Results []SyncResult `json:"results"`
+}
+
+// pendingActivity is an activity to record once the sync transaction commits
+type pendingActivity struct {
+	noteID  string
+	action  activity.Action
+	details map[string]string
+}
+
+// Sync applies a batch of offline changes to the user's private notes in
+// order within one transaction. Changes whose base version no longer matches
+// the stored note are reported as conflicts and skipped; the rest are
+// applied. Activities are recorded only after the transaction commits.
+func (h *Handler) Sync(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload SyncRequest
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if len(payload.Changes) == 0 {
+		return apperr.New(fiber.StatusBadRequest, "No changes to sync")
+	}
+	if len(payload.Changes) > MaxSyncChanges {
+		return apperr.Newf(fiber.StatusBadRequest, "At most %d changes can be synced at once", MaxSyncChanges)
+	}
+
+	ctx := c.UserContext()
+	results := make([]SyncResult, 0, len(payload.Changes))
+	var activities []pendingActivity
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		for _, change := range payload.Changes {
+			result, acts, err := h.applyChange(ctx, tx, userID, change)
+			if err != nil {
+				return fmt.Errorf("syncing note %s: %w", change.ID, err)
+			}
+			results = append(results, result)
+			activities = append(activities, acts...)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, a := range activities {
+		h.activity.Record(ctx, a.noteID, userID, a.action, a.details)
+		if a.action == activity.ActionCreated || a.action == activity.ActionEdited {
+			h.process(a.noteID)
+		}
+		if a.action == activity.ActionDeleted {
+			h.announceDeleted(ctx, a.noteID, userID)
+		}
+	}
+
+	return c.JSON(SyncResponse{Results: results})
+}
+
+// applyChange applies a single change inside the sync transaction. Only
+// database failures are returned as errors; everything else is a result.
+func (h *Handler) applyChange(ctx context.Context, tx *sql.Tx, userID string, change SyncChange) (SyncResult, []pendingActivity, error) {
+	result := SyncResult{ID: change.ID, Op: change.Op}
+
+	switch change.Op {
+	case SyncCreate, SyncUpdate:
+		payload := NotePayload{Title: change.Title, Content: change.Content}
+		if errs := validate.Struct(&payload); errs != nil {
+			return rejected(result, apperr.Invalid(errs)), nil, nil
+		}
+		if err := h.checkLimits(payload); err != nil {
+			return rejected(result, err), nil, nil
+		}
+		change.Title, change.Content = payload.Title, payload.Content
+	case SyncDelete:
+	default:
+		return rejected(result, apperr.New(fiber.StatusBadRequest, "op must be create, update or delete")), nil, nil
+	}
+
+	if _, err := uuid.Parse(change.ID); err != nil {
+		return rejected(result, apperr.New(fiber.StatusBadRequest, "id must be a UUID")), nil, nil
+	}
+
+	current, err := loadNote(ctx, tx, change.ID)
+	if err != nil {
+		return result, nil, err
+	}
+	if current != nil && (current.UserID != userID || current.WorkspaceID != nil) {
+		return rejected(result, apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")), nil, nil
+	}
+
+	writes := (change.Op == SyncCreate && current == nil) ||
+		(change.Op == SyncUpdate && current != nil && current.Version == change.BaseVersion)
+	if writes {
+		delta := quota.NoteSize(change.Title, change.Content)
+		if current != nil {
+			delta -= quota.NoteSize(current.Title, current.Content)
+		}
+		if err := h.quota.Check(ctx, tx, userID, nil, delta); err != nil {
+			var appErr *apperr.Error
+			if errors.As(err, &appErr) {
+				return rejected(result, appErr), nil, nil
+			}
+			return result, nil, err
+		}
+	}
+
+	switch change.Op {
+	case SyncCreate:
+		return applyCreate(ctx, tx, userID, change, current, result)
+	case SyncUpdate:
+		return applyUpdate(ctx, tx, userID, change, current, result)
+	default:
+		return applyDelete(ctx, tx, userID, change, current, result)
+	}
+}
+
+// applyCreate inserts a note created offline. Replaying a create that
+// already landed is reported as applied so clients can retry safely.
+func applyCreate(ctx context.Context, tx *sql.Tx, userID string, change SyncChange, current *Note, result SyncResult) (SyncResult, []pendingActivity, error) {
+	if current != nil {
+		if current.Title == change.Title && current.Content == change.Content {
+			result.Status, result.Version = SyncApplied, current.Version
+			return result, nil, nil
+		}
+		return conflict(result, current, change), nil, nil
+	}
+
+	stats := statsOf(change.Content)
+	_, err := tx.ExecContext(ctx, "INSERT INTO notes (id, user_id, workspace_id, title, content, size, word_count, char_count) VALUES (?, ?, NULL, ?, ?, ?, ?, ?)",
+		change.ID, userID, change.Title, change.Content, quota.NoteSize(change.Title, change.Content), stats.WordCount, stats.CharCount)
+	if err != nil {
+		return result, nil, err
+	}
+	if err := snapshotRevision(ctx, tx, change.ID, userID); err != nil {
+		return result, nil, err
+	}
+	if err := changelog.Record(ctx, tx, changelog.Created, "id = ?", change.ID); err != nil {
+		return result, nil, err
+	}
+	if err := syncTasks(ctx, tx, change.ID, "", change.Content); err != nil {
+		return result, nil, err
+	}
+
+	result.Status, result.Version = SyncApplied, 1
+	return result, []pendingActivity{{noteID: change.ID, action: activity.ActionCreated}}, nil
+}
+
+// applyUpdate overwrites a note if nobody changed it since BaseVersion
+func applyUpdate(ctx context.Context, tx *sql.Tx, userID string, change SyncChange, current *Note, result SyncResult) (SyncResult, []pendingActivity, error) {
+	if current == nil || current.Version != change.BaseVersion {
+		return conflict(result, current, change), nil, nil
+	}
+
+	// The version guard catches a writer that got in after loadNote
+	stats := contentStats(current.Encrypted, change.Content)
+	updated, err := tx.ExecContext(ctx,
+		"UPDATE notes SET title = ?, content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND version = ?",
+		change.Title, change.Content, quota.NoteSize(change.Title, change.Content), stats.WordCount, stats.CharCount, change.ID, userID, change.BaseVersion)
+	if err != nil {
+		return result, nil, err
+	}
+	if n, _ := updated.RowsAffected(); n == 0 {
+		latest, err := loadNote(ctx, tx, change.ID)
+		if err != nil {
+			return result, nil, err
+		}
+		return conflict(result, latest, change), nil, nil
+	}
+	if err := snapshotRevision(ctx, tx, change.ID, userID); err != nil {
+		return result, nil, err
+	}
+	if err := changelog.Record(ctx, tx, changelog.Updated, "id = ?", change.ID); err != nil {
+		return result, nil, err
+	}
+	if !current.Encrypted {
+		if err := syncTasks(ctx, tx, change.ID, current.Content, change.Content); err != nil {
+			return result, nil, err
+		}
+	}
+
+	var acts []pendingActivity
+	if change.Title != current.Title {
+		acts = append(acts, pendingActivity{noteID: change.ID, action: activity.ActionRenamed, details: map[string]string{"from": current.Title, "to": change.Title}})
+	}
+	if change.Content != current.Content {
+		acts = append(acts, pendingActivity{noteID: change.ID, action: activity.ActionEdited})
+	}
+
+	result.Status, result.Version = SyncApplied, current.Version+1
+	return result, acts, nil
+}
+
+// applyDelete removes a note if nobody changed it since BaseVersion.
+// Deleting a note that is already gone is reported as applied.
+func applyDelete(ctx context.Context, tx *sql.Tx, userID string, change SyncChange, current *Note, result SyncResult) (SyncResult, []pendingActivity, error) {
+	if current == nil {
+		result.Status = SyncApplied
+		return result, nil, nil
+	}
+	if current.Version != change.BaseVersion {
+		return conflict(result, current, change), nil, nil
+	}
+
+	if err := changelog.Record(ctx, tx, changelog.Deleted, "id = ? AND user_id = ? AND version = ?", change.ID, userID, change.BaseVersion); err != nil {
+		return result, nil, err
+	}
+	deleted, err := tx.ExecContext(ctx, "DELETE FROM notes WHERE id = ? AND user_id = ? AND version = ?", change.ID, userID, change.BaseVersion)
+	if err != nil {
+		return result, nil, err
+	}
+	if n, _ := deleted.RowsAffected(); n == 0 {
+		latest, err := loadNote(ctx, tx, change.ID)
+		if err != nil {
+			return result, nil, err
+		}
+		if latest != nil {
+			return conflict(result, latest, change), nil, nil
+		}
+		result.Status = SyncApplied
+		return result, nil, nil
+	}
+
+	result.Status = SyncApplied
+	return result, []pendingActivity{{noteID: change.ID, action: activity.ActionDeleted}}, nil
+}
+
+// loadNote loads a note by ID within the sync transaction, returning nil
+// if it does not exist
+func loadNote(ctx context.Context, tx *sql.Tx, noteID string) (*Note, error) {
+	n, err := scanNote(tx.QueryRowContext(ctx, "SELECT "+noteColumns+" FROM notes WHERE id = ?", noteID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// rejected marks result as rejected with the message and field errors of err
+func rejected(result SyncResult, err *apperr.Error) SyncResult {
+	result.Status = SyncRejected
+	result.Error, result.Errors = err.Message, err.Fields
+	return result
+}
+
+// conflict marks result as a conflict between the stored note and change
+func conflict(result SyncResult, current *Note, change SyncChange) SyncResult {
+	result.Status = SyncConflict
+	result.Server = current
+	result.Client = &change
+	return result
+}
@@ -0,0 +1,207 @@
+package notes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/activity"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSync(t *testing.T) {
+	const noteID = "0b5e1c7a-3f4d-4a8e-9d1b-2c6f8e0a4b7d"
+	now := time.Now()
+	selectQuery := regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE id = ?")
+	insertQuery := regexp.QuoteMeta("INSERT INTO notes (id, user_id, workspace_id, title, content, size, word_count, char_count) VALUES (?, ?, NULL, ?, ?, ?, ?, ?)")
+	updateQuery := regexp.QuoteMeta("UPDATE notes SET title = ?, content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND version = ?")
+	deleteQuery := regexp.QuoteMeta("DELETE FROM notes WHERE id = ? AND user_id = ? AND version = ?")
+	noteRow := func(owner string, version int64) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}).
+			AddRow(noteID, owner, nil, "Server", "server text", false, false, version, now, now, 0, 0, false, nil, nil, nil)
+	}
+	noRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id"})
+	}
+
+	testCases := []struct {
+		name               string
+		changes            []SyncChange
+		setupMock          func(mock sqlmock.Sqlmock)
+		expectedStatus     int
+		expectedResults    []SyncStatus
+		expectedActivities []activity.Action
+		expectServer       bool
+	}{
+		{
+			name:    "Create",
+			changes: []SyncChange{{ID: noteID, Op: SyncCreate, Title: "Offline", Content: "text"}},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(noteID).WillReturnRows(noRows())
+				mock.ExpectExec(insertQuery).WithArgs(noteID, "user123", "Offline", "text", int64(11), int64(1), int64(4)).WillReturnResult(sqlmock.NewResult(1, 1))
+				expectRevision(mock, noteID)
+				expectChange(mock, "created", "id = ?", noteID)
+				mock.ExpectCommit()
+			},
+			expectedStatus:     fiber.StatusOK,
+			expectedResults:    []SyncStatus{SyncApplied},
+			expectedActivities: []activity.Action{activity.ActionCreated},
+		},
+		{
+			name:    "Create Retried",
+			changes: []SyncChange{{ID: noteID, Op: SyncCreate, Title: "Server", Content: "server text"}},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(noteID).WillReturnRows(noteRow("user123", 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus:  fiber.StatusOK,
+			expectedResults: []SyncStatus{SyncApplied},
+		},
+		{
+			name:    "Update",
+			changes: []SyncChange{{ID: noteID, Op: SyncUpdate, BaseVersion: 2, Title: "Server", Content: "offline text"}},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(noteID).WillReturnRows(noteRow("user123", 2))
+				mock.ExpectExec(updateQuery).WithArgs("Server", "offline text", int64(18), int64(2), int64(12), noteID, "user123", int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectRevision(mock, noteID)
+				expectChange(mock, "updated", "id = ?", noteID)
+				mock.ExpectCommit()
+			},
+			expectedStatus:     fiber.StatusOK,
+			expectedResults:    []SyncStatus{SyncApplied},
+			expectedActivities: []activity.Action{activity.ActionEdited},
+		},
+		{
+			name:    "Update Conflict",
+			changes: []SyncChange{{ID: noteID, Op: SyncUpdate, BaseVersion: 1, Title: "Offline", Content: "offline text"}},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(noteID).WillReturnRows(noteRow("user123", 3))
+				mock.ExpectCommit()
+			},
+			expectedStatus:  fiber.StatusOK,
+			expectedResults: []SyncStatus{SyncConflict},
+			expectServer:    true,
+		},
+		{
+			name:    "Update Deleted On Server",
+			changes: []SyncChange{{ID: noteID, Op: SyncUpdate, BaseVersion: 1, Title: "Offline"}},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(noteID).WillReturnRows(noRows())
+				mock.ExpectCommit()
+			},
+			expectedStatus:  fiber.StatusOK,
+			expectedResults: []SyncStatus{SyncConflict},
+		},
+		{
+			name:    "Delete",
+			changes: []SyncChange{{ID: noteID, Op: SyncDelete, BaseVersion: 1}},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(noteID).WillReturnRows(noteRow("user123", 1))
+				expectChange(mock, "deleted", "id = ? AND user_id = ? AND version = ?", noteID, "user123", int64(1))
+				mock.ExpectExec(deleteQuery).WithArgs(noteID, "user123", int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus:     fiber.StatusOK,
+			expectedResults:    []SyncStatus{SyncApplied},
+			expectedActivities: []activity.Action{activity.ActionDeleted},
+		},
+		{
+			name: "Mixed Batch",
+			changes: []SyncChange{
+				{ID: noteID, Op: SyncUpdate, BaseVersion: 1, Title: "Offline"},
+				{ID: "not-a-uuid", Op: SyncDelete},
+				{ID: noteID, Op: SyncUpdate, Title: strings.Repeat("x", 21)},
+				{ID: noteID, Op: "move"},
+			},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(noteID).WillReturnRows(noteRow("other", 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus:  fiber.StatusOK,
+			expectedResults: []SyncStatus{SyncRejected, SyncRejected, SyncRejected, SyncRejected},
+		},
+		{
+			name:    "Database Error Rolls Back",
+			changes: []SyncChange{{ID: noteID, Op: SyncCreate, Title: "Offline"}},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectQuery).WithArgs(noteID).WillReturnRows(noRows())
+				mock.ExpectExec(insertQuery).WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+		{
+			name:           "Empty Batch",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Batch Too Large",
+			changes:        make([]SyncChange, MaxSyncChanges+1),
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			defer helper.cleanup()
+			helper.setupRoute("POST", "/sync", helper.handler.Sync)
+
+			if tc.setupMock != nil {
+				tc.setupMock(helper.mockDB)
+			}
+
+			body, _ := json.Marshal(SyncRequest{Changes: tc.changes})
+			req := httptest.NewRequest("POST", "/sync", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var response SyncResponse
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				var statuses []SyncStatus
+				for _, r := range response.Results {
+					statuses = append(statuses, r.Status)
+				}
+				assert.Equal(t, tc.expectedResults, statuses)
+				if tc.expectedResults[0] == SyncConflict {
+					assert.Equal(t, tc.expectServer, response.Results[0].Server != nil)
+					assert.NotNil(t, response.Results[0].Client)
+				}
+			}
+
+			var actions []activity.Action
+			for _, a := range helper.recorder.recorded {
+				actions = append(actions, a.action)
+			}
+			assert.Equal(t, tc.expectedActivities, actions)
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/changelog"
+	"quanta/internal/checklist"
+	"quanta/internal/db"
+	"quanta/internal/realtime"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// MaxTaskList is the most tasks GetTasks returns
+const MaxTaskList = 500
+
+// Task is a task item ("- [ ]" or "- [x]") in a note. Line counts from 1.
+// Due is the YYYY-MM-DD date of a due:YYYY-MM-DD tag in the text.
+type Task struct {
+	ID        string  `json:"id"`
+	NoteID    string  `json:"note_id"`
+	NoteTitle string  `json:"note_title"`
+	Line      int     `json:"line"`
+	Text      string  `json:"text"`
+	Done      bool    `json:"done"`
+	Due       *string `json:"due"`
+}
+
+// TaskPayload is the request body for UpdateTask
+type TaskPayload struct {
+	Done *bool `json:"done"`
+}
+
+// TaskMessage is the realtime frame collaborators receive when someone
+// checks or unchecks a task, with the note's version and content after
+// the change
+type TaskMessage struct {
+	Type    string `json:"type"`
+	V       int    `json:"v"`
+	Task    Task   `json:"task"`
+	Version int64  `json:"version"`
+	Content string `json:"content"`
+}
+
+// taskStore reads and writes tasks on the database or inside a transaction
+type taskStore interface {
+	execer
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// syncTasks brings a note's tasks in step with its new content. A task
+// whose text is unchanged keeps its ID wherever it moved, so clients can
+// still update it. Content with no task items that had none before either
+// is skipped without a query.
+func syncTasks(ctx context.Context, ts taskStore, noteID, oldContent, content string) error {
+	items := checklist.Parse(content)
+	if len(items) == 0 && !checklist.Has(oldContent) {
+		return nil
+	}
+
+	rows, err := ts.QueryContext(ctx, "SELECT id, line, text, done, due_date FROM tasks WHERE note_id = ? ORDER BY line", noteID)
+	if err != nil {
+		return fmt.Errorf("fetching tasks: %w", err)
+	}
+	type stored struct {
+		id   string
+		item checklist.Item
+	}
+	byText := make(map[string][]stored)
+	for rows.Next() {
+		var s stored
+		var due sql.NullString
+		if err := rows.Scan(&s.id, &s.item.Line, &s.item.Text, &s.item.Done, &due); err != nil {
+			closeRows(rows)
+			return fmt.Errorf("scanning task: %w", err)
+		}
+		s.item.Due = due.String
+		byText[s.item.Text] = append(byText[s.item.Text], s)
+	}
+	// The rows have to be closed before writing on the same transaction
+	closeRows(rows)
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating tasks: %w", err)
+	}
+
+	for _, it := range items {
+		var due *string
+		if it.Due != "" {
+			due = &it.Due
+		}
+		if same := byText[it.Text]; len(same) > 0 {
+			byText[it.Text] = same[1:]
+			if same[0].item == it {
+				continue
+			}
+			_, err := ts.ExecContext(ctx, "UPDATE tasks SET line = ?, done = ?, due_date = ? WHERE id = ?", it.Line, it.Done, due, same[0].id)
+			if err != nil {
+				return fmt.Errorf("updating task: %w", err)
+			}
+			continue
+		}
+		_, err := ts.ExecContext(ctx, "INSERT INTO tasks (id, note_id, line, text, done, due_date) VALUES (?, ?, ?, ?, ?, ?)",
+			uuid.New().String(), noteID, it.Line, it.Text, it.Done, due)
+		if err != nil {
+			return fmt.Errorf("adding task: %w", err)
+		}
+	}
+
+	var gone []string
+	for _, same := range byText {
+		for _, s := range same {
+			gone = append(gone, s.id)
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	slices.Sort(gone)
+	args := make([]any, len(gone))
+	for i, id := range gone {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(gone)), ", ")
+	if _, err := ts.ExecContext(ctx, "DELETE FROM tasks WHERE id IN ("+placeholders+")", args...); err != nil {
+		return fmt.Errorf("removing tasks: %w", err)
+	}
+	return nil
+}
+
+// recordTasks syncs a note's tasks after a write that has already been
+// committed. Failures are logged rather than failing the write.
+func (h *Handler) recordTasks(ctx context.Context, noteID, oldContent, content string) {
+	if err := syncTasks(ctx, h.db, noteID, oldContent, content); err != nil {
+		log.Printf("Error recording tasks of note %s: %v", noteID, err)
+	}
+}
+
+// GetTasks lists the task items in every note the user can access, those
+// with the soonest due date first and the rest by note and line. ?done=
+// keeps only done or open tasks and ?due= those due on or before a
+// YYYY-MM-DD date.
+func (h *Handler) GetTasks(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	// tasks has no user_id or workspace_id, so accessible reads the note's
+	where := accessible
+	args := []any{userID, userID}
+	switch c.Query("done") {
+	case "":
+	case "true", "false":
+		where += " AND t.done = ?"
+		args = append(args, c.Query("done") == "true")
+	default:
+		return apperr.New(fiber.StatusBadRequest, "done must be true or false")
+	}
+	if due := c.Query("due"); due != "" {
+		if _, err := time.Parse(checklist.DateLayout, due); err != nil {
+			return apperr.New(fiber.StatusBadRequest, "due must be a date such as 2026-03-01")
+		}
+		where += " AND t.due_date <= ?"
+		args = append(args, due)
+	}
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT t.id, t.note_id, n.title, t.line, t.text, t.done, t.due_date FROM tasks t JOIN notes n ON n.id = t.note_id WHERE "+
+			where+" ORDER BY t.due_date IS NULL, t.due_date, n.title, t.note_id, t.line LIMIT ?",
+		append(args, MaxTaskList)...,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching tasks: %w", err)
+	}
+	defer closeRows(rows)
+
+	tasks := []Task{}
+	for rows.Next() {
+		var t Task
+		if err := rows.Scan(&t.ID, &t.NoteID, &t.NoteTitle, &t.Line, &t.Text, &t.Done, &t.Due); err != nil {
+			return fmt.Errorf("scanning task: %w", err)
+		}
+		tasks = append(tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating tasks: %w", err)
+	}
+
+	return c.JSON(tasks)
+}
+
+// UpdateTask checks or unchecks a task by rewriting its line of the note,
+// which makes a new version of the note, and sends the new content to the
+// note's room. Asking for the state the task is already in changes
+// nothing.
+func (h *Handler) UpdateTask(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload TaskPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.Done == nil {
+		return apperr.Invalid(map[string]string{"done": "required"})
+	}
+	ctx := c.UserContext()
+
+	var t Task
+	var content string
+	var version int64
+	err = h.db.QueryRowContext(ctx,
+		"SELECT t.id, t.note_id, n.title, t.line, t.text, t.done, t.due_date, n.content, n.version FROM tasks t JOIN notes n ON n.id = t.note_id WHERE t.id = ? AND "+accessible,
+		c.Params("id"), userID, userID,
+	).Scan(&t.ID, &t.NoteID, &t.NoteTitle, &t.Line, &t.Text, &t.Done, &t.Due, &content, &version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Task not found or unauthorized")
+		}
+		return fmt.Errorf("fetching task: %w", err)
+	}
+	if err := h.requireEditor(ctx, t.NoteID, userID); err != nil {
+		return err
+	}
+	if err := h.checkLock(ctx, t.NoteID, userID); err != nil {
+		return err
+	}
+	if t.Done == *payload.Done {
+		return c.JSON(t)
+	}
+
+	updated, ok := checklist.Toggle(content, t.Line, t.Text, *payload.Done)
+	if !ok {
+		return apperr.New(fiber.StatusConflict, "Note changed since the task was read, try again")
+	}
+
+	// Swapping the box's mark keeps the note's size
+	stats := statsOf(updated)
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			"UPDATE notes SET content = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?",
+			updated, stats.WordCount, stats.CharCount, t.NoteID, version,
+		)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return errTaskRaced
+		}
+		if err := snapshotRevision(ctx, tx, t.NoteID, userID); err != nil {
+			return err
+		}
+		if err := changelog.Record(ctx, tx, changelog.Updated, "id = ?", t.NoteID); err != nil {
+			return err
+		}
+		return syncTasks(ctx, tx, t.NoteID, content, updated)
+	})
+	if errors.Is(err, errTaskRaced) {
+		return apperr.New(fiber.StatusConflict, "Note changed since the task was read, try again")
+	}
+	if err != nil {
+		return fmt.Errorf("updating task: %w", err)
+	}
+
+	t.Done = *payload.Done
+	h.activity.Record(ctx, t.NoteID, userID, activity.ActionEdited, nil)
+	h.process(t.NoteID)
+	if h.rooms != nil {
+		h.rooms.Publish(ctx, t.NoteID, TaskMessage{
+			Type:    "task",
+			V:       realtime.ProtocolVersion,
+			Task:    t,
+			Version: version + 1,
+			Content: updated,
+		})
+	}
+
+	return c.JSON(t)
+}
+
+// errTaskRaced rolls back a task update that lost a race with another
+// change to its note
+var errTaskRaced = errors.New("note changed while updating task")
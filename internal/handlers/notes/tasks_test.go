@@ -0,0 +1,252 @@
+package notes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"quanta/internal/activity"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncTasks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	defer db.Close()
+
+	due := "2026-03-01"
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, line, text, done, due_date FROM tasks WHERE note_id = ? ORDER BY line")).
+		WithArgs("note1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "line", "text", "done", "due_date"}).
+			AddRow("t1", 1, "Eggs", false, nil).
+			AddRow("t2", 2, "Milk", false, nil).
+			AddRow("t3", 3, "Bread due:2026-03-01", false, due))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE tasks SET line = ?, done = ?, due_date = ? WHERE id = ?")).
+		WithArgs(1, true, nil, "t2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE tasks SET line = ?, done = ?, due_date = ? WHERE id = ?")).
+		WithArgs(2, false, due, "t3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO tasks (id, note_id, line, text, done, due_date) VALUES (?, ?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "note1", 3, "Jam", false, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM tasks WHERE id IN (?)")).
+		WithArgs("t1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Milk was checked, Eggs went and Jam is new. Milk and Bread keep
+	// their IDs on their new lines.
+	err = syncTasks(context.Background(), db, "note1",
+		"- [ ] Eggs\n- [ ] Milk\n- [ ] Bread due:2026-03-01",
+		"- [x] Milk\n- [ ] Bread due:2026-03-01\n- [ ] Jam")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Content without tasks that had none before needs no queries
+	assert.NoError(t, syncTasks(context.Background(), db, "note1", "just text", "more text"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetTasks(t *testing.T) {
+	query := func(filters string) string {
+		return regexp.QuoteMeta("SELECT t.id, t.note_id, n.title, t.line, t.text, t.done, t.due_date FROM tasks t JOIN notes n ON n.id = t.note_id WHERE " +
+			accessible + filters + " ORDER BY t.due_date IS NULL, t.due_date, n.title, t.note_id, t.line LIMIT ?")
+	}
+	columns := []string{"id", "note_id", "title", "line", "text", "done", "due_date"}
+	due := "2026-03-01"
+
+	testCases := []struct {
+		name           string
+		url            string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+		expected       []Task
+	}{
+		{
+			name: "All",
+			url:  "/tasks",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query("")).WithArgs("user123", "user123", MaxTaskList).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow("t1", "note1", "Launch", 2, "Announce due:2026-03-01", false, due).
+						AddRow("t2", "note1", "Launch", 3, "Book venue", true, nil))
+			},
+			expectedStatus: fiber.StatusOK,
+			expected: []Task{
+				{ID: "t1", NoteID: "note1", NoteTitle: "Launch", Line: 2, Text: "Announce due:2026-03-01", Due: &due},
+				{ID: "t2", NoteID: "note1", NoteTitle: "Launch", Line: 3, Text: "Book venue", Done: true},
+			},
+		},
+		{
+			name: "Open And Due",
+			url:  "/tasks?done=false&due=2026-03-01",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(query(" AND t.done = ? AND t.due_date <= ?")).
+					WithArgs("user123", "user123", false, "2026-03-01", MaxTaskList).
+					WillReturnRows(sqlmock.NewRows(columns))
+			},
+			expectedStatus: fiber.StatusOK,
+			expected:       []Task{},
+		},
+		{
+			name:           "Bad Done",
+			url:            "/tasks?done=yes",
+			setupMock:      func(mock sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:           "Bad Due",
+			url:            "/tasks?due=2026-02-30",
+			setupMock:      func(mock sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("GET", "/tasks", helper.handler.GetTasks)
+			tc.setupMock(helper.mockDB)
+
+			resp, err := helper.app.Test(httptest.NewRequest("GET", tc.url, nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var tasks []Task
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&tasks))
+				assert.Equal(t, tc.expected, tasks)
+			}
+			assert.NoError(t, helper.mockDB.ExpectationsWereMet())
+			helper.cleanup()
+		})
+	}
+}
+
+func TestUpdateTask(t *testing.T) {
+	taskQuery := regexp.QuoteMeta("SELECT t.id, t.note_id, n.title, t.line, t.text, t.done, t.due_date, n.content, n.version FROM tasks t JOIN notes n ON n.id = t.note_id WHERE t.id = ? AND " + accessible)
+	updateNote := regexp.QuoteMeta("UPDATE notes SET content = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?")
+	columns := []string{"id", "note_id", "title", "line", "text", "done", "due_date", "content", "version"}
+	content := "Todo\n- [ ] Eggs"
+
+	testCases := []struct {
+		name           string
+		body           string
+		setupMock      func(helper *testHelper)
+		expectedStatus int
+		published      bool
+	}{
+		{
+			name: "Checked",
+			body: `{"done":true}`,
+			setupMock: func(helper *testHelper) {
+				mock := helper.mockDB
+				mock.ExpectQuery(taskQuery).WithArgs("t1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("t1", "note1", "Shopping", 2, "Eggs", false, nil, content, 4))
+				helper.expectRole("note1", "")
+				helper.expectLock("note1", "")
+				mock.ExpectBegin()
+				mock.ExpectExec(updateNote).WithArgs("Todo\n- [x] Eggs", 4, 15, "note1", 4).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO note_revisions (note_id, version, user_id, title, content) SELECT id, version, ?, title, content FROM notes WHERE id = ?")).
+					WithArgs("user123", "note1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectChange(mock, "updated", "id = ?", "note1")
+				mock.ExpectQuery(regexp.QuoteMeta("SELECT id, line, text, done, due_date FROM tasks WHERE note_id = ? ORDER BY line")).
+					WithArgs("note1").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "line", "text", "done", "due_date"}).AddRow("t1", 2, "Eggs", false, nil))
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE tasks SET line = ?, done = ?, due_date = ? WHERE id = ?")).
+					WithArgs(2, true, nil, "t1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusOK,
+			published:      true,
+		},
+		{
+			name: "Already Open",
+			body: `{"done":false}`,
+			setupMock: func(helper *testHelper) {
+				helper.mockDB.ExpectQuery(taskQuery).WithArgs("t1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("t1", "note1", "Shopping", 2, "Eggs", false, nil, content, 4))
+				helper.expectRole("note1", "")
+				helper.expectLock("note1", "")
+			},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name: "Line Changed",
+			body: `{"done":true}`,
+			setupMock: func(helper *testHelper) {
+				helper.mockDB.ExpectQuery(taskQuery).WithArgs("t1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("t1", "note1", "Shopping", 1, "Eggs", false, nil, content, 4))
+				helper.expectRole("note1", "")
+				helper.expectLock("note1", "")
+			},
+			expectedStatus: fiber.StatusConflict,
+		},
+		{
+			name: "Read Only",
+			body: `{"done":true}`,
+			setupMock: func(helper *testHelper) {
+				helper.mockDB.ExpectQuery(taskQuery).WithArgs("t1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("t1", "note1", "Shopping", 2, "Eggs", false, nil, content, 4))
+				helper.expectRole("note1", "viewer")
+			},
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name: "Not Found",
+			body: `{"done":true}`,
+			setupMock: func(helper *testHelper) {
+				helper.mockDB.ExpectQuery(taskQuery).WithArgs("t1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows(columns))
+			},
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Missing Done",
+			body:           `{}`,
+			setupMock:      func(helper *testHelper) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("PATCH", "/tasks/:id", helper.handler.UpdateTask)
+			tc.setupMock(helper)
+
+			req := httptest.NewRequest("PATCH", "/tasks/t1", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.published {
+				if assert.Len(t, helper.rooms.published, 1) {
+					msg := helper.rooms.published[0].(TaskMessage)
+					assert.Equal(t, "task", msg.Type)
+					assert.True(t, msg.Task.Done)
+					assert.Equal(t, int64(5), msg.Version)
+					assert.Equal(t, "Todo\n- [x] Eggs", msg.Content)
+				}
+				assert.Equal(t, []recordedActivity{{noteID: "note1", actorID: "user123", action: activity.ActionEdited}}, helper.recorder.recorded)
+				assert.Equal(t, []string{"note1"}, helper.processors.queued)
+			} else {
+				assert.Empty(t, helper.rooms.published)
+			}
+			assert.NoError(t, helper.mockDB.ExpectationsWereMet())
+			helper.cleanup()
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package notes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RecentLimit is how many notes the recently viewed list holds
+const RecentLimit = 20
+
+// ViewedNote is a note on the recently viewed list
+type ViewedNote struct {
+	Note
+	ViewedAt time.Time `json:"viewed_at"`
+}
+
+// FavoriteNote is a note on the favorites list
+type FavoriteNote struct {
+	Note
+	FavoritedAt time.Time `json:"favorited_at"`
+}
+
+// recordView notes that the user opened a note. Each user keeps one row per
+// note, so a repeat view only moves its time. Failures are logged rather
+// than failing the read.
+func (h *Handler) recordView(ctx context.Context, userID, noteID string) {
+	result, err := h.db.ExecContext(ctx,
+		"UPDATE note_views SET viewed_at = CURRENT_TIMESTAMP WHERE user_id = ? AND note_id = ?",
+		userID, noteID,
+	)
+	if err == nil {
+		if n, _ := result.RowsAffected(); n > 0 {
+			return
+		}
+		_, err = h.db.ExecContext(ctx,
+			"INSERT INTO note_views (user_id, note_id, viewed_at) VALUES (?, ?, CURRENT_TIMESTAMP)",
+			userID, noteID,
+		)
+	}
+	// A duplicate means the row already exists, either from a concurrent
+	// view or because the time didn't change
+	if err != nil && !db.IsDuplicate(err) {
+		log.Printf("Error recording view of note %s: %v", noteID, err)
+	}
+}
+
+// GetRecent lists the notes the user viewed most recently, newest first.
+// Notes they have since lost access to are left out.
+func (h *Handler) GetRecent(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT "+noteColumns+", viewed_at FROM notes JOIN (SELECT note_id, viewed_at FROM note_views WHERE user_id = ?) v ON v.note_id = notes.id WHERE "+accessible+" ORDER BY viewed_at DESC LIMIT ?",
+		userID, userID, userID, RecentLimit,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching recent notes: %w", err)
+	}
+	defer closeRows(rows)
+
+	notes := []ViewedNote{}
+	for rows.Next() {
+		var n ViewedNote
+		if err := scanNoteWith(rows, &n.Note, &n.ViewedAt); err != nil {
+			return fmt.Errorf("scanning note: %w", err)
+		}
+		notes = append(notes, n)
+	}
+
+	return c.JSON(notes)
+}
+
+// GetFavorites lists the notes the user marked as favorites, most recently
+// favorited first
+func (h *Handler) GetFavorites(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT "+noteColumns+", favorited_at FROM notes JOIN (SELECT note_id, created_at AS favorited_at FROM note_favorites WHERE user_id = ?) f ON f.note_id = notes.id WHERE "+accessible+" ORDER BY favorited_at DESC",
+		userID, userID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching favorite notes: %w", err)
+	}
+	defer closeRows(rows)
+
+	notes := []FavoriteNote{}
+	for rows.Next() {
+		var n FavoriteNote
+		if err := scanNoteWith(rows, &n.Note, &n.FavoritedAt); err != nil {
+			return fmt.Errorf("scanning note: %w", err)
+		}
+		notes = append(notes, n)
+	}
+
+	return c.JSON(notes)
+}
+
+// ToggleFavorite adds a note the user can access to their favorites, or
+// removes it if it is already there, and reports which it did
+func (h *Handler) ToggleFavorite(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+	ctx := c.UserContext()
+
+	var exists bool
+	err = h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND "+accessible+")", noteID, userID, userID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("checking note access: %w", err)
+	}
+	if !exists {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+
+	var favorited bool
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "DELETE FROM note_favorites WHERE user_id = ? AND note_id = ?", userID, noteID)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			return nil
+		}
+
+		favorited = true
+		_, err = tx.ExecContext(ctx, "INSERT INTO note_favorites (user_id, note_id) VALUES (?, ?)", userID, noteID)
+		return err
+	})
+	// A concurrent toggle already favorited the note
+	if err != nil && !db.IsDuplicate(err) {
+		return fmt.Errorf("toggling favorite: %w", err)
+	}
+
+	return c.JSON(fiber.Map{"favorited": favorited})
+}
+
+func closeRows(rows *sql.Rows) {
+	if err := rows.Close(); err != nil {
+		log.Println("Error closing rows:", err)
+	}
+}
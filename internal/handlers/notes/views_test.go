@@ -0,0 +1,177 @@
+package notes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordView(t *testing.T) {
+	update := regexp.QuoteMeta("UPDATE note_views SET viewed_at = CURRENT_TIMESTAMP WHERE user_id = ? AND note_id = ?")
+	insert := regexp.QuoteMeta("INSERT INTO note_views (user_id, note_id, viewed_at) VALUES (?, ?, CURRENT_TIMESTAMP)")
+
+	testCases := []struct {
+		name      string
+		updated   int64
+		insertErr error
+	}{
+		{name: "Repeat View", updated: 1},
+		{name: "First View"},
+		{name: "Concurrent First View", insertErr: &mysql.MySQLError{Number: 1062}},
+		{name: "Insert Fails", insertErr: errors.New("database error")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.mockDB.ExpectExec(update).WithArgs("user123", "note1").WillReturnResult(sqlmock.NewResult(0, tc.updated))
+			if tc.updated == 0 {
+				expect := helper.mockDB.ExpectExec(insert).WithArgs("user123", "note1")
+				if tc.insertErr != nil {
+					expect.WillReturnError(tc.insertErr)
+				} else {
+					expect.WillReturnResult(sqlmock.NewResult(1, 1))
+				}
+			}
+
+			// Failures are only logged
+			helper.handler.recordView(t.Context(), "user123", "note1")
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetRecent(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/notes/recent", helper.handler.GetRecent)
+
+	now := time.Now()
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon, viewed_at FROM notes JOIN (SELECT note_id, viewed_at FROM note_views WHERE user_id = ?) v ON v.note_id = notes.id WHERE ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)) ORDER BY viewed_at DESC LIMIT ?")).
+		WithArgs("user123", "user123", "user123", RecentLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon", "viewed_at"}).
+			AddRow("note2", "user123", nil, "Latest", "", false, false, 1, now, now, 0, 0, false, nil, nil, nil, now).
+			AddRow("note1", "user456", "ws1", "Shared", "", false, false, 3, now, now, 0, 0, false, nil, nil, nil, now.Add(-time.Hour)))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/recent", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var notes []ViewedNote
+	if err := json.NewDecoder(resp.Body).Decode(&notes); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, notes, 2) {
+		assert.Equal(t, "note2", notes[0].ID)
+		assert.WithinDuration(t, now, notes[0].ViewedAt, time.Second)
+		assert.Equal(t, "ws1", *notes[1].WorkspaceID)
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetFavorites(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/notes/favorites", helper.handler.GetFavorites)
+
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon, favorited_at FROM notes JOIN (SELECT note_id, created_at AS favorited_at FROM note_favorites WHERE user_id = ?) f ON f.note_id = notes.id WHERE ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)) ORDER BY favorited_at DESC")).
+		WithArgs("user123", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/notes/favorites", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var notes []FavoriteNote
+	if err := json.NewDecoder(resp.Body).Decode(&notes); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.NotNil(t, notes)
+	assert.Empty(t, notes)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestToggleFavorite(t *testing.T) {
+	exists := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)))")
+	remove := regexp.QuoteMeta("DELETE FROM note_favorites WHERE user_id = ? AND note_id = ?")
+	insert := regexp.QuoteMeta("INSERT INTO note_favorites (user_id, note_id) VALUES (?, ?)")
+
+	testCases := []struct {
+		name              string
+		accessible        bool
+		removed           int64
+		insertErr         error
+		expectedStatus    int
+		expectedFavorited bool
+	}{
+		{name: "Favorite", accessible: true, expectedStatus: fiber.StatusOK, expectedFavorited: true},
+		{name: "Unfavorite", accessible: true, removed: 1, expectedStatus: fiber.StatusOK},
+		{name: "Concurrent Favorite", accessible: true, insertErr: &mysql.MySQLError{Number: 1062}, expectedStatus: fiber.StatusOK, expectedFavorited: true},
+		{name: "Not Accessible", expectedStatus: fiber.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			helper.setupRoute("POST", "/notes/:id/favorite", helper.handler.ToggleFavorite)
+
+			helper.mockDB.ExpectQuery(exists).WithArgs("note1", "user123", "user123").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.accessible))
+			if tc.accessible {
+				helper.mockDB.ExpectBegin()
+				helper.mockDB.ExpectExec(remove).WithArgs("user123", "note1").WillReturnResult(sqlmock.NewResult(0, tc.removed))
+				switch {
+				case tc.removed > 0:
+					helper.mockDB.ExpectCommit()
+				case tc.insertErr != nil:
+					helper.mockDB.ExpectExec(insert).WithArgs("user123", "note1").WillReturnError(tc.insertErr)
+					helper.mockDB.ExpectRollback()
+				default:
+					helper.mockDB.ExpectExec(insert).WithArgs("user123", "note1").WillReturnResult(sqlmock.NewResult(1, 1))
+					helper.mockDB.ExpectCommit()
+				}
+			}
+
+			resp, err := helper.app.Test(httptest.NewRequest("POST", "/notes/note1/favorite", nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var body map[string]bool
+				if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedFavorited, body["favorited"])
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package notes
+
+import (
+	"fmt"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetWorkspaceNotes lists the notes of a workspace the user belongs to, in
+// the same order and with the same ?state= filter and conditional request
+// support as GetNotes
+func (h *Handler) GetWorkspaceNotes(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	if err := h.requireMember(c, workspaceID); err != nil {
+		return err
+	}
+	return h.listNotes(c, "workspace_id = ?", workspaceID)
+}
+
+// CreateWorkspaceNote creates a note in a workspace the user belongs to
+func (h *Handler) CreateWorkspaceNote(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	if err := h.requireMember(c, workspaceID); err != nil {
+		return err
+	}
+	return h.createNote(c, &workspaceID)
+}
+
+// requireMember returns a 404 error unless the user belongs to the workspace
+func (h *Handler) requireMember(c *fiber.Ctx, workspaceID string) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var member bool
+	err = h.db.QueryRowContext(c.UserContext(),
+		"SELECT EXISTS(SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user_id = ?)",
+		workspaceID, userID,
+	).Scan(&member)
+	if err != nil {
+		return fmt.Errorf("checking workspace membership: %w", err)
+	}
+	if !member {
+		return apperr.New(fiber.StatusNotFound, "Workspace not found")
+	}
+	return nil
+}
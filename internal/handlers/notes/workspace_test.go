@@ -0,0 +1,72 @@
+package notes
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkspaceNotes(t *testing.T) {
+	helper := newTestHelper(t)
+	defer helper.cleanup()
+
+	helper.setupRoute("GET", "/workspaces/:id/notes", helper.handler.GetWorkspaceNotes)
+	helper.setupRoute("POST", "/workspaces/:id/notes", helper.handler.CreateWorkspaceNote)
+
+	memberQuery := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user_id = ?)")
+	listQuery := regexp.QuoteMeta("SELECT id, user_id, workspace_id, title, content, pinned, archived, version, created_at, updated_at, word_count, char_count, encrypted, metadata, color, icon FROM notes WHERE workspace_id = ? AND archived = ? ORDER BY pinned DESC, updated_at DESC")
+	insertQuery := regexp.QuoteMeta("INSERT INTO notes (id, user_id, workspace_id, title, content, size, word_count, char_count, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	isMember := func(member bool) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"exists"}).AddRow(member)
+	}
+	now := time.Now()
+
+	t.Run("List", func(t *testing.T) {
+		helper.mockDB.ExpectQuery(memberQuery).WithArgs("ws1", "user123").WillReturnRows(isMember(true))
+		helper.mockDB.ExpectQuery(listQuery).WithArgs("ws1", false).WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id", "workspace_id", "title", "content", "pinned", "archived", "version", "created_at", "updated_at", "word_count", "char_count", "encrypted", "metadata", "color", "icon"}).
+				AddRow("note1", "user456", "ws1", "Shared", "", false, false, 1, now, now, 0, 0, false, nil, nil, nil))
+
+		resp, err := helper.app.Test(httptest.NewRequest("GET", "/workspaces/ws1/notes", nil))
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		helper.mockDB.ExpectQuery(memberQuery).WithArgs("ws1", "user123").WillReturnRows(isMember(true))
+		helper.mockDB.ExpectExec(insertQuery).WithArgs(sqlmock.AnyArg(), "user123", "ws1", "Shared", "Body", int64(10), int64(1), int64(4), nil).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectRevision(helper.mockDB, sqlmock.AnyArg())
+		expectChange(helper.mockDB, "created", "id = ?", sqlmock.AnyArg())
+
+		req := httptest.NewRequest("POST", "/workspaces/ws1/notes", bytes.NewBufferString(`{"title":"Shared","content":"Body"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := helper.app.Test(req)
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+	})
+
+	t.Run("Not A Member", func(t *testing.T) {
+		helper.mockDB.ExpectQuery(memberQuery).WithArgs("ws2", "user123").WillReturnRows(isMember(false))
+
+		resp, err := helper.app.Test(httptest.NewRequest("GET", "/workspaces/ws2/notes", nil))
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	})
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
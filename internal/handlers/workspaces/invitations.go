@@ -0,0 +1,422 @@
+package workspaces
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/validate"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// IssuedInvitation is the response body for CreateInvitation. The token
+// is only ever returned here; the database keeps its hash.
+type IssuedInvitation struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Emailed reports whether the link was sent to the invitee
+	Emailed bool `json:"emailed"`
+}
+
+// InvitePreview is what GetInvite shows about an invitation before it is
+// accepted, so the web app can offer to sign up or log in first
+type InvitePreview struct {
+	WorkspaceID   string    `json:"workspace_id"`
+	WorkspaceName string    `json:"workspace_name"`
+	Email         *string   `json:"email"`
+	Role          string    `json:"role"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+const invitationColumns = "i.id, i.workspace_id, w.name, i.email, i.role, i.invited_by, i.expires_at, i.created_at"
+
+// CreateInvitation invites someone to join a workspace. With an email the
+// link is mailed to that address and only an account with it can accept;
+// without one the link is returned for sharing and works once for whoever
+// opens it first. Owners and admins may invite.
+func (h *Handler) CreateInvitation(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	workspaceID := c.Params("id")
+
+	var payload InvitationPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if errs := validate.Struct(&payload); errs != nil {
+		return apperr.Invalid(errs)
+	}
+	payload.Role = strings.TrimSpace(payload.Role)
+	if payload.Role == "" {
+		payload.Role = RoleMember
+	}
+	if payload.Role != RoleAdmin && payload.Role != RoleMember {
+		return apperr.Invalid(map[string]string{"role": "must be admin or member"})
+	}
+
+	if _, err := RequireRole(c, h.db, workspaceID, RoleOwner, RoleAdmin); err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	now := time.Now()
+	var email *string
+	if payload.Email != "" {
+		normalized := normalizeEmail(payload.Email)
+		email = &normalized
+
+		// An expired invitation shouldn't block inviting the same address again
+		if _, err := h.db.ExecContext(ctx,
+			"DELETE FROM workspace_invitations WHERE workspace_id = ? AND email = ? AND expires_at <= ?",
+			workspaceID, normalized, now,
+		); err != nil {
+			return fmt.Errorf("clearing expired invitations: %w", err)
+		}
+
+		var member, invited bool
+		err := h.db.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM workspace_members m JOIN users u ON u.id = m.user_id WHERE m.workspace_id = ? AND LOWER(u.email) = ?), "+
+				"EXISTS(SELECT 1 FROM workspace_invitations WHERE workspace_id = ? AND email = ?)",
+			workspaceID, normalized, workspaceID, normalized,
+		).Scan(&member, &invited)
+		if err != nil {
+			return fmt.Errorf("checking invitation: %w", err)
+		}
+		if member {
+			return apperr.New(fiber.StatusConflict, "User is already a member")
+		}
+		if invited {
+			return apperr.New(fiber.StatusConflict, "User has already been invited")
+		}
+	}
+
+	token, hash, err := newInviteToken()
+	if err != nil {
+		return fmt.Errorf("generating invitation token: %w", err)
+	}
+	issued := IssuedInvitation{
+		ID:        uuid.New().String(),
+		Token:     token,
+		URL:       h.invites.BaseURL + "/invites/" + token,
+		ExpiresAt: now.Add(h.invites.TTL).UTC().Truncate(time.Second),
+	}
+
+	if _, err := h.db.ExecContext(ctx,
+		"INSERT INTO workspace_invitations (id, workspace_id, email, token_hash, role, invited_by, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		issued.ID, workspaceID, email, hash, payload.Role, userID, issued.ExpiresAt,
+	); err != nil {
+		return fmt.Errorf("inserting invitation: %w", err)
+	}
+
+	if email != nil {
+		issued.Emailed = h.sendInvitation(ctx, workspaceID, *email, issued)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(issued)
+}
+
+// sendInvitation mails the invitation link. A failure is logged rather than
+// returned: the invitation exists and the link in the response still works.
+func (h *Handler) sendInvitation(ctx context.Context, workspaceID, email string, issued IssuedInvitation) bool {
+	var name string
+	if err := h.db.QueryRowContext(ctx, "SELECT name FROM workspaces WHERE id = ?", workspaceID).Scan(&name); err != nil {
+		log.Printf("Error loading workspace %s for invitation: %v", workspaceID, err)
+		return false
+	}
+
+	subject := fmt.Sprintf("You're invited to join %s", name)
+	body := fmt.Sprintf("You've been invited to join the %s workspace.\n\nOpen this link to accept. "+
+		"If you don't have an account yet you can create one on the way:\n\n%s\n\nThe link expires on %s.\n",
+		name, issued.URL, issued.ExpiresAt.Format("January 2, 2006 at 15:04 MST"))
+	if err := h.mailer.Send(email, subject, body); err != nil {
+		log.Printf("Error emailing invitation %s: %v", issued.ID, err)
+		return false
+	}
+	return true
+}
+
+// ListInvitations lists a workspace's pending invitations, newest first
+func (h *Handler) ListInvitations(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	if _, err := RequireRole(c, h.db, workspaceID, RoleOwner, RoleAdmin); err != nil {
+		return err
+	}
+
+	return h.listInvitations(c,
+		"SELECT "+invitationColumns+" FROM workspace_invitations i JOIN workspaces w ON w.id = i.workspace_id WHERE i.workspace_id = ? AND i.expires_at > ? ORDER BY i.created_at DESC",
+		workspaceID, time.Now(),
+	)
+}
+
+// RevokeInvitation withdraws a pending invitation
+func (h *Handler) RevokeInvitation(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	if _, err := RequireRole(c, h.db, workspaceID, RoleOwner, RoleAdmin); err != nil {
+		return err
+	}
+
+	result, err := h.db.ExecContext(c.UserContext(),
+		"DELETE FROM workspace_invitations WHERE id = ? AND workspace_id = ?",
+		c.Params("invitationId"), workspaceID,
+	)
+	if err != nil {
+		return fmt.Errorf("revoking invitation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return apperr.New(fiber.StatusNotFound, "Invitation not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// MyInvitations lists the pending invitations addressed to the current
+// user's email
+func (h *Handler) MyInvitations(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	email, err := h.userEmail(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	return h.listInvitations(c,
+		"SELECT "+invitationColumns+" FROM workspace_invitations i JOIN workspaces w ON w.id = i.workspace_id WHERE i.email = ? AND i.expires_at > ? ORDER BY i.created_at DESC",
+		email, time.Now(),
+	)
+}
+
+func (h *Handler) listInvitations(c *fiber.Ctx, query string, args ...any) error {
+	rows, err := h.db.QueryContext(c.UserContext(), query, args...)
+	if err != nil {
+		return fmt.Errorf("listing invitations: %w", err)
+	}
+	defer closeRows(rows)
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var i Invitation
+		var email sql.NullString
+		if err := rows.Scan(&i.ID, &i.WorkspaceID, &i.WorkspaceName, &email, &i.Role, &i.InvitedBy, &i.ExpiresAt, &i.CreatedAt); err != nil {
+			return fmt.Errorf("scanning invitation: %w", err)
+		}
+		if email.Valid {
+			i.Email = &email.String
+		}
+		invitations = append(invitations, i)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("listing invitations: %w", err)
+	}
+
+	return c.JSON(invitations)
+}
+
+// AcceptInvitation accepts one of the invitations listed by MyInvitations
+func (h *Handler) AcceptInvitation(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	email, err := h.userEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return h.accept(c, func(tx *sql.Tx) (string, error) {
+		return redeem(ctx, tx, "id = ? AND email = ?", []any{c.Params("id"), email}, userID, email)
+	})
+}
+
+// DeclineInvitation discards an invitation addressed to the current user
+func (h *Handler) DeclineInvitation(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	email, err := h.userEmail(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.db.ExecContext(c.UserContext(),
+		"DELETE FROM workspace_invitations WHERE id = ? AND email = ?",
+		c.Params("id"), email,
+	)
+	if err != nil {
+		return fmt.Errorf("declining invitation: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return apperr.New(fiber.StatusNotFound, "Invitation not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetInvite describes the invitation behind a link. It needs no
+// authentication, so someone without an account can see which workspace
+// they were invited to before signing up with the token.
+func (h *Handler) GetInvite(c *fiber.Ctx) error {
+	var p InvitePreview
+	var email sql.NullString
+	err := h.db.QueryRowContext(c.UserContext(),
+		"SELECT i.workspace_id, w.name, i.email, i.role, i.expires_at FROM workspace_invitations i JOIN workspaces w ON w.id = i.workspace_id WHERE i.token_hash = ?",
+		hashInviteToken(c.Params("token")),
+	).Scan(&p.WorkspaceID, &p.WorkspaceName, &email, &p.Role, &p.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Invitation not found")
+		}
+		return fmt.Errorf("fetching invitation: %w", err)
+	}
+	if !p.ExpiresAt.After(time.Now()) {
+		return apperr.New(fiber.StatusGone, "Invitation has expired")
+	}
+	if email.Valid {
+		p.Email = &email.String
+	}
+
+	return c.JSON(p)
+}
+
+// AcceptInvite accepts the invitation behind a link for the current user.
+// New users can accept during signup instead by passing the token to
+// POST /signup.
+func (h *Handler) AcceptInvite(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	email, err := h.userEmail(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return h.accept(c, func(tx *sql.Tx) (string, error) {
+		return Redeem(ctx, tx, c.Params("token"), userID, email)
+	})
+}
+
+// accept runs redeem in a transaction and reports the joined workspace
+func (h *Handler) accept(c *fiber.Ctx, redeem func(tx *sql.Tx) (string, error)) error {
+	var workspaceID string
+	err := db.InTx(c.UserContext(), h.db, func(tx *sql.Tx) error {
+		var err error
+		workspaceID, err = redeem(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	userID, _ := auth.UserIDFromCtx(c)
+	entry := audit.FromRequest(c, audit.EventMemberJoined, userID)
+	entry.Details = map[string]string{"workspace_id": workspaceID}
+	h.audit.Log(c.UserContext(), entry)
+
+	return c.JSON(fiber.Map{"workspace_id": workspaceID})
+}
+
+// Redeem accepts the invitation with the given token on behalf of a user,
+// adding them to the workspace and using up the invitation. It runs inside
+// the caller's transaction so signup can create the account and join in
+// one step. Invalid, expired or misaddressed invitations are reported as
+// *apperr.Error.
+func Redeem(ctx context.Context, tx *sql.Tx, token, userID, email string) (string, error) {
+	return redeem(ctx, tx, "token_hash = ?", []any{hashInviteToken(token)}, userID, normalizeEmail(email))
+}
+
+// redeem finds the invitation matching where and makes the user a member
+func redeem(ctx context.Context, tx *sql.Tx, where string, args []any, userID, email string) (string, error) {
+	var id, workspaceID, role string
+	var invited sql.NullString
+	var expiresAt time.Time
+	err := tx.QueryRowContext(ctx,
+		"SELECT id, workspace_id, email, role, expires_at FROM workspace_invitations WHERE "+where,
+		args...,
+	).Scan(&id, &workspaceID, &invited, &role, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", apperr.New(fiber.StatusNotFound, "Invitation not found")
+		}
+		return "", fmt.Errorf("fetching invitation: %w", err)
+	}
+	if !expiresAt.After(time.Now()) {
+		return "", apperr.New(fiber.StatusGone, "Invitation has expired")
+	}
+	if invited.Valid && invited.String != email {
+		return "", apperr.New(fiber.StatusForbidden, "This invitation was sent to a different email address")
+	}
+
+	var member bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user_id = ?)",
+		workspaceID, userID,
+	).Scan(&member)
+	if err != nil {
+		return "", fmt.Errorf("checking workspace membership: %w", err)
+	}
+	if member {
+		return "", apperr.New(fiber.StatusConflict, "You are already a member of this workspace")
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)",
+		workspaceID, userID, role,
+	); err != nil {
+		return "", fmt.Errorf("adding member: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM workspace_invitations WHERE id = ?", id); err != nil {
+		return "", fmt.Errorf("deleting invitation: %w", err)
+	}
+
+	return workspaceID, nil
+}
+
+// userEmail returns a user's normalized email address
+func (h *Handler) userEmail(ctx context.Context, userID string) (string, error) {
+	var email string
+	err := h.db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?", userID).Scan(&email)
+	if err != nil {
+		return "", fmt.Errorf("fetching user: %w", err)
+	}
+	return normalizeEmail(email), nil
+}
+
+// newInviteToken returns a random URL-safe token and the hash to store
+func newInviteToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashInviteToken(token), nil
+}
+
+// hashInviteToken returns the hex SHA-256 of a token. Tokens are random
+// enough that an unsalted hash is safe to store.
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,368 @@
+package workspaces
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	emailQuery         = regexp.QuoteMeta("SELECT email FROM users WHERE id = ?")
+	redeemQuery        = regexp.QuoteMeta("SELECT id, workspace_id, email, role, expires_at FROM workspace_invitations WHERE ")
+	memberExistsQuery  = regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM workspace_members WHERE workspace_id = ? AND user_id = ?)")
+	insertMemberQuery  = regexp.QuoteMeta("INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)")
+	deleteInviteQuery  = regexp.QuoteMeta("DELETE FROM workspace_invitations WHERE id = ?")
+	invitationRowNames = []string{"id", "workspace_id", "email", "role", "expires_at"}
+)
+
+func TestCreateInvitation(t *testing.T) {
+	clearQuery := regexp.QuoteMeta("DELETE FROM workspace_invitations WHERE workspace_id = ? AND email = ? AND expires_at <= ?")
+	checkQuery := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM workspace_members m JOIN users u ON u.id = m.user_id WHERE m.workspace_id = ? AND LOWER(u.email) = ?), EXISTS(SELECT 1 FROM workspace_invitations WHERE workspace_id = ? AND email = ?)")
+	insertQuery := regexp.QuoteMeta("INSERT INTO workspace_invitations (id, workspace_id, email, token_hash, role, invited_by, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	nameQuery := regexp.QuoteMeta("SELECT name FROM workspaces WHERE id = ?")
+
+	testCases := []struct {
+		name           string
+		payload        InvitationPayload
+		role           string
+		member         bool
+		invited        bool
+		expectInsert   bool
+		mailErr        error
+		expectedStatus int
+		expectedError  string
+		expectEmailed  bool
+	}{
+		{
+			name:           "Email",
+			payload:        InvitationPayload{Email: "Bob@Example.com"},
+			role:           RoleAdmin,
+			expectInsert:   true,
+			expectedStatus: fiber.StatusCreated,
+			expectEmailed:  true,
+		},
+		{
+			name:           "Email Fails",
+			payload:        InvitationPayload{Email: "bob@example.com"},
+			role:           RoleAdmin,
+			expectInsert:   true,
+			mailErr:        errors.New("relay down"),
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Link",
+			payload:        InvitationPayload{Role: RoleAdmin},
+			role:           RoleOwner,
+			expectInsert:   true,
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "Already A Member",
+			payload:        InvitationPayload{Email: "bob@example.com"},
+			role:           RoleOwner,
+			member:         true,
+			expectedStatus: fiber.StatusConflict,
+			expectedError:  "User is already a member",
+		},
+		{
+			name:           "Already Invited",
+			payload:        InvitationPayload{Email: "bob@example.com"},
+			role:           RoleOwner,
+			invited:        true,
+			expectedStatus: fiber.StatusConflict,
+			expectedError:  "User has already been invited",
+		},
+		{
+			name:           "Member Cannot Invite",
+			payload:        InvitationPayload{Email: "bob@example.com"},
+			role:           RoleMember,
+			expectedStatus: fiber.StatusForbidden,
+		},
+		{
+			name:           "Invalid Role",
+			payload:        InvitationPayload{Email: "bob@example.com", Role: RoleOwner},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Invalid Email",
+			payload:        InvitationPayload{Email: "bob"},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			h.mailer.err = tc.mailErr
+			if tc.role != "" {
+				h.expectRole("user123", tc.role)
+			}
+			hasEmail := tc.payload.Email != ""
+			if hasEmail && (tc.role == RoleOwner || tc.role == RoleAdmin) {
+				h.mockDB.ExpectExec(clearQuery).WithArgs("ws1", "bob@example.com", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+				h.mockDB.ExpectQuery(checkQuery).WithArgs("ws1", "bob@example.com", "ws1", "bob@example.com").
+					WillReturnRows(sqlmock.NewRows([]string{"member", "invited"}).AddRow(tc.member, tc.invited))
+			}
+			if tc.expectInsert {
+				var email any
+				role := RoleAdmin
+				if hasEmail {
+					email, role = "bob@example.com", RoleMember
+				}
+				h.mockDB.ExpectExec(insertQuery).WithArgs(sqlmock.AnyArg(), "ws1", email, sqlmock.AnyArg(), role, "user123", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				if hasEmail {
+					h.mockDB.ExpectQuery(nameQuery).WithArgs("ws1").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Team"))
+				}
+			}
+
+			req := httptest.NewRequest("POST", "/workspaces/ws1/invites", strings.NewReader(mustJSON(tc.payload)))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := h.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusCreated {
+				var issued IssuedInvitation
+				if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, "https://notes.example.com/invites/"+issued.Token, issued.URL)
+				assert.WithinDuration(t, time.Now().Add(DefaultInviteTTL), issued.ExpiresAt, time.Minute)
+				assert.Equal(t, tc.expectEmailed, issued.Emailed)
+				if hasEmail {
+					assert.Len(t, h.mailer.sent, 1)
+					assert.Contains(t, h.mailer.sent[0], issued.URL)
+				} else {
+					assert.Empty(t, h.mailer.sent)
+				}
+			} else if tc.expectedError != "" {
+				var body apperr.Response
+				_ = json.NewDecoder(resp.Body).Decode(&body)
+				assert.Equal(t, tc.expectedError, body.Message)
+			}
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	h := newTestHelper(t)
+	h.expectRole("user123", RoleAdmin)
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM workspace_invitations WHERE id = ? AND workspace_id = ?")).
+		WithArgs("inv1", "ws1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	resp := h.do(t, "DELETE", "/workspaces/ws1/invites/inv1", nil)
+	assert.Equal(t, fiber.StatusNotFound, resp.Code)
+
+	if err := h.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestMyInvitations(t *testing.T) {
+	h := newTestHelper(t)
+	h.mockDB.ExpectQuery(emailQuery).WithArgs("user123").WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("Bob@Example.com"))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT i.id, i.workspace_id, w.name, i.email, i.role, i.invited_by, i.expires_at, i.created_at FROM workspace_invitations i JOIN workspaces w ON w.id = i.workspace_id WHERE i.email = ? AND i.expires_at > ? ORDER BY i.created_at DESC")).
+		WithArgs("bob@example.com", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "workspace_id", "name", "email", "role", "invited_by", "expires_at", "created_at"}).
+			AddRow("inv1", "ws1", "Team", "bob@example.com", RoleMember, "owner1", time.Now().Add(time.Hour), time.Now()))
+
+	resp := h.do(t, "GET", "/me/invitations", nil)
+	assert.Equal(t, fiber.StatusOK, resp.Code)
+
+	if err := h.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	testCases := []struct {
+		name           string
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name: "Success",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(redeemQuery+regexp.QuoteMeta("id = ? AND email = ?")).WithArgs("inv1", "bob@example.com").
+					WillReturnRows(sqlmock.NewRows(invitationRowNames).AddRow("inv1", "ws1", "bob@example.com", RoleAdmin, time.Now().Add(time.Hour)))
+				mock.ExpectQuery(memberExistsQuery).WithArgs("ws1", "user123").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(insertMemberQuery).WithArgs("ws1", "user123", RoleAdmin).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(deleteInviteQuery).WithArgs("inv1").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name: "Addressed To Someone Else",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(redeemQuery).WithArgs("inv1", "bob@example.com").WillReturnRows(sqlmock.NewRows(invitationRowNames))
+				mock.ExpectRollback()
+			},
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name: "Database Error Rolls Back",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(redeemQuery).WithArgs("inv1", "bob@example.com").
+					WillReturnRows(sqlmock.NewRows(invitationRowNames).AddRow("inv1", "ws1", "bob@example.com", RoleMember, time.Now().Add(time.Hour)))
+				mock.ExpectQuery(memberExistsQuery).WithArgs("ws1", "user123").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(insertMemberQuery).WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			h.mockDB.ExpectQuery(emailQuery).WithArgs("user123").WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("bob@example.com"))
+			tc.setupMock(h.mockDB)
+
+			resp := h.do(t, "POST", "/invitations/inv1/accept", nil)
+			assert.Equal(t, tc.expectedStatus, resp.Code)
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeclineInvitation(t *testing.T) {
+	h := newTestHelper(t)
+	h.mockDB.ExpectQuery(emailQuery).WithArgs("user123").WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("bob@example.com"))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM workspace_invitations WHERE id = ? AND email = ?")).
+		WithArgs("inv1", "bob@example.com").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp := h.do(t, "POST", "/invitations/inv1/decline", nil)
+	assert.Equal(t, fiber.StatusNoContent, resp.Code)
+
+	if err := h.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetInvite(t *testing.T) {
+	query := regexp.QuoteMeta("SELECT i.workspace_id, w.name, i.email, i.role, i.expires_at FROM workspace_invitations i JOIN workspaces w ON w.id = i.workspace_id WHERE i.token_hash = ?")
+	columns := []string{"workspace_id", "name", "email", "role", "expires_at"}
+
+	testCases := []struct {
+		name           string
+		rows           *sqlmock.Rows
+		expectedStatus int
+	}{
+		{
+			name:           "Link",
+			rows:           sqlmock.NewRows(columns).AddRow("ws1", "Team", nil, RoleMember, time.Now().Add(time.Hour)),
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Expired",
+			rows:           sqlmock.NewRows(columns).AddRow("ws1", "Team", "bob@example.com", RoleMember, time.Now().Add(-time.Hour)),
+			expectedStatus: fiber.StatusGone,
+		},
+		{
+			name:           "Unknown Token",
+			rows:           sqlmock.NewRows(columns),
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			h.mockDB.ExpectQuery(query).WithArgs(hashInviteToken("secret")).WillReturnRows(tc.rows)
+
+			resp := h.do(t, "GET", "/invites/secret", nil)
+			assert.Equal(t, tc.expectedStatus, resp.Code)
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestAcceptInvite(t *testing.T) {
+	byToken := redeemQuery + regexp.QuoteMeta("token_hash = ?")
+	hash := hashInviteToken("secret")
+
+	testCases := []struct {
+		name           string
+		invitedEmail   any
+		expiresAt      time.Time
+		member         bool
+		expectJoin     bool
+		expectedStatus int
+	}{
+		{name: "Link", expiresAt: time.Now().Add(time.Hour), expectJoin: true, expectedStatus: fiber.StatusOK},
+		{name: "Addressed To User", invitedEmail: "bob@example.com", expiresAt: time.Now().Add(time.Hour), expectJoin: true, expectedStatus: fiber.StatusOK},
+		{name: "Addressed To Someone Else", invitedEmail: "carol@example.com", expiresAt: time.Now().Add(time.Hour), expectedStatus: fiber.StatusForbidden},
+		{name: "Expired", expiresAt: time.Now().Add(-time.Minute), expectedStatus: fiber.StatusGone},
+		{name: "Already A Member", expiresAt: time.Now().Add(time.Hour), member: true, expectedStatus: fiber.StatusConflict},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			h.mockDB.ExpectQuery(emailQuery).WithArgs("user123").WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("Bob@example.com"))
+			h.mockDB.ExpectBegin()
+			h.mockDB.ExpectQuery(byToken).WithArgs(hash).
+				WillReturnRows(sqlmock.NewRows(invitationRowNames).AddRow("inv1", "ws1", tc.invitedEmail, RoleMember, tc.expiresAt))
+			if tc.expectJoin || tc.member {
+				h.mockDB.ExpectQuery(memberExistsQuery).WithArgs("ws1", "user123").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tc.member))
+			}
+			if tc.expectJoin {
+				h.mockDB.ExpectExec(insertMemberQuery).WithArgs("ws1", "user123", RoleMember).WillReturnResult(sqlmock.NewResult(1, 1))
+				h.mockDB.ExpectExec(deleteInviteQuery).WithArgs("inv1").WillReturnResult(sqlmock.NewResult(0, 1))
+				h.mockDB.ExpectCommit()
+			} else {
+				h.mockDB.ExpectRollback()
+			}
+
+			resp := h.do(t, "POST", "/invites/secret/accept", nil)
+			assert.Equal(t, tc.expectedStatus, resp.Code)
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewInviteToken(t *testing.T) {
+	a, hashA, err := newInviteToken()
+	assert.NoError(t, err)
+	b, _, err := newInviteToken()
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, a, b)
+	assert.Len(t, hashA, 64)
+	assert.Equal(t, hashInviteToken(a), hashA)
+}
+
+func mustJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
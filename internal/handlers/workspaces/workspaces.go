@@ -0,0 +1,427 @@
+// Package workspaces provides handlers for shared workspaces: creating
+// them, managing their members and inviting people to join. The notes
+// inside a workspace are served by the notes package.
+package workspaces
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/validate"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Member roles. Every workspace has exactly one owner, who created it.
+// Admins manage members and invitations; members read and edit notes.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Workspace is a workspace as one of its members sees it. Role is the
+// caller's own role; Members is only filled in by GetWorkspace.
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	Members   []Member  `json:"members,omitempty"`
+}
+
+// Member is a user's membership of a workspace
+type Member struct {
+	UserID      string    `json:"user_id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+// Invitation is a pending invitation to join a workspace. Email is nil for
+// invitations shared as a link.
+type Invitation struct {
+	ID            string    `json:"id"`
+	WorkspaceID   string    `json:"workspace_id"`
+	WorkspaceName string    `json:"workspace_name,omitempty"`
+	Email         *string   `json:"email"`
+	Role          string    `json:"role"`
+	InvitedBy     string    `json:"invited_by"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// WorkspacePayload is the request body for CreateWorkspace
+type WorkspacePayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+// InvitationPayload is the request body for CreateInvitation. Without an
+// email the invitation is a link anyone can use once. Role defaults to
+// member.
+type InvitationPayload struct {
+	Email string `json:"email" validate:"email,max=255"`
+	Role  string `json:"role"`
+}
+
+// RolePayload is the request body for UpdateMember
+type RolePayload struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// Mailer sends plain-text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// InviteConfig controls the invitation links
+type InviteConfig struct {
+	// BaseURL is where the web app is served. Links point to
+	// BaseURL/invites/<token>.
+	BaseURL string
+	// TTL is how long an invitation can be accepted. Zero means
+	// DefaultInviteTTL.
+	TTL time.Duration
+}
+
+// DefaultInviteTTL is how long invitations stay valid unless configured
+const DefaultInviteTTL = 7 * 24 * time.Hour
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// AccessRevoker closes a user's realtime connections to notes they may
+// no longer open
+type AccessRevoker interface {
+	RevokeAccess(ctx context.Context, userID string)
+}
+
+// Handler handles HTTP requests related to workspaces
+type Handler struct {
+	db      DBInterface
+	mailer  Mailer
+	invites InviteConfig
+	audit   AuditLogger
+	sockets AccessRevoker
+}
+
+// NewHandler creates a new Handler with the provided database interface,
+// the mailer that delivers invitations, the audit log that records
+// membership and role changes and the realtime connections to close when
+// a member is removed. sockets may be nil.
+func NewHandler(db DBInterface, mailer Mailer, invites InviteConfig, auditLog AuditLogger, sockets AccessRevoker) *Handler {
+	if invites.TTL <= 0 {
+		invites.TTL = DefaultInviteTTL
+	}
+	invites.BaseURL = strings.TrimSuffix(invites.BaseURL, "/")
+	return &Handler{db: db, mailer: mailer, invites: invites, audit: auditLog, sockets: sockets}
+}
+
+// CreateWorkspace creates a workspace owned by the current user
+func (h *Handler) CreateWorkspace(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload WorkspacePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if errs := validate.Struct(&payload); errs != nil {
+		return apperr.Invalid(errs)
+	}
+
+	ctx := c.UserContext()
+	id := uuid.New().String()
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO workspaces (id, name) VALUES (?, ?)", id, payload.Name); err != nil {
+			return fmt.Errorf("inserting workspace: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)",
+			id, userID, RoleOwner,
+		); err != nil {
+			return fmt.Errorf("adding owner: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": id})
+}
+
+// ListWorkspaces lists the workspaces the current user belongs to
+func (h *Handler) ListWorkspaces(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT w.id, w.name, m.role, w.created_at FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id WHERE m.user_id = ? ORDER BY w.name",
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("listing workspaces: %w", err)
+	}
+	defer closeRows(rows)
+
+	workspaces := []Workspace{}
+	for rows.Next() {
+		var w Workspace
+		if err := rows.Scan(&w.ID, &w.Name, &w.Role, &w.CreatedAt); err != nil {
+			return fmt.Errorf("scanning workspace: %w", err)
+		}
+		workspaces = append(workspaces, w)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("listing workspaces: %w", err)
+	}
+
+	return c.JSON(workspaces)
+}
+
+// GetWorkspace returns a workspace with its members
+func (h *Handler) GetWorkspace(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	role, err := RequireRole(c, h.db, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	w := Workspace{ID: workspaceID, Role: role}
+	err = h.db.QueryRowContext(ctx, "SELECT name, created_at FROM workspaces WHERE id = ?", workspaceID).Scan(&w.Name, &w.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("fetching workspace: %w", err)
+	}
+
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT m.user_id, u.email, COALESCE(u.display_name, ''), m.role, m.created_at FROM workspace_members m JOIN users u ON u.id = m.user_id WHERE m.workspace_id = ? ORDER BY m.created_at",
+		workspaceID,
+	)
+	if err != nil {
+		return fmt.Errorf("listing members: %w", err)
+	}
+	defer closeRows(rows)
+
+	w.Members = []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Email, &m.DisplayName, &m.Role, &m.JoinedAt); err != nil {
+			return fmt.Errorf("scanning member: %w", err)
+		}
+		w.Members = append(w.Members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("listing members: %w", err)
+	}
+
+	return c.JSON(w)
+}
+
+// DeleteWorkspace deletes a workspace together with its notes, members and
+// invitations. Only the owner may delete it.
+func (h *Handler) DeleteWorkspace(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	if _, err := RequireRole(c, h.db, workspaceID, RoleOwner); err != nil {
+		return err
+	}
+
+	if _, err := h.db.ExecContext(c.UserContext(), "DELETE FROM workspaces WHERE id = ?", workspaceID); err != nil {
+		return fmt.Errorf("deleting workspace: %w", err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// UpdateMember changes a member's role between admin and member. Only the
+// owner may promote or demote, and the owner's own role is fixed.
+func (h *Handler) UpdateMember(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	workspaceID := c.Params("id")
+	memberID := c.Params("userId")
+
+	var payload RolePayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if errs := validate.Struct(&payload); errs != nil {
+		return apperr.Invalid(errs)
+	}
+	if payload.Role != RoleAdmin && payload.Role != RoleMember {
+		return apperr.Invalid(map[string]string{"role": "must be admin or member"})
+	}
+
+	if _, err := RequireRole(c, h.db, workspaceID, RoleOwner); err != nil {
+		return err
+	}
+	if memberID == userID {
+		return apperr.New(fiber.StatusBadRequest, "The owner's role cannot be changed")
+	}
+
+	result, err := h.db.ExecContext(c.UserContext(),
+		"UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?",
+		payload.Role, workspaceID, memberID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating member: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return apperr.New(fiber.StatusNotFound, "Member not found")
+	}
+
+	entry := audit.FromRequest(c, audit.EventRoleChanged, memberID)
+	entry.Details = map[string]string{"workspace_id": workspaceID, "role": payload.Role}
+	h.audit.Log(c.UserContext(), entry)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RemoveMember removes a member from a workspace. Any member may remove
+// themselves to leave, except the owner, who has to delete the workspace
+// instead. The owner may remove anyone else; admins may remove members.
+// Their roles on the workspace's notes go with them, and their open
+// connections to those notes are closed.
+func (h *Handler) RemoveMember(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	workspaceID := c.Params("id")
+	memberID := c.Params("userId")
+
+	role, err := RequireRole(c, h.db, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	if memberID == userID {
+		if role == RoleOwner {
+			return apperr.New(fiber.StatusBadRequest, "The owner cannot leave the workspace; delete it instead")
+		}
+	} else {
+		if role == RoleMember {
+			return apperr.New(fiber.StatusForbidden, "Insufficient permissions")
+		}
+		target, err := memberRole(c.UserContext(), h.db, workspaceID, memberID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return apperr.New(fiber.StatusNotFound, "Member not found")
+			}
+			return fmt.Errorf("fetching member: %w", err)
+		}
+		if target == RoleOwner || (role == RoleAdmin && target == RoleAdmin) {
+			return apperr.New(fiber.StatusForbidden, "Insufficient permissions")
+		}
+	}
+
+	// Sharing a workspace note grants nothing once the member has left, so
+	// the grants go with the membership
+	ctx := c.UserContext()
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM note_collaborators WHERE user_id = ? AND note_id IN (SELECT id FROM notes WHERE workspace_id = ?)",
+			memberID, workspaceID,
+		); err != nil {
+			return fmt.Errorf("removing member's note roles: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			"DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?",
+			workspaceID, memberID,
+		); err != nil {
+			return fmt.Errorf("removing member: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if h.sockets != nil {
+		h.sockets.RevokeAccess(ctx, memberID)
+	}
+
+	entry := audit.FromRequest(c, audit.EventMemberRemoved, memberID)
+	entry.Details = map[string]string{"workspace_id": workspaceID}
+	h.audit.Log(c.UserContext(), entry)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RoleQuerier is the database access RequireRole needs
+type RoleQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// RequireRole returns the current user's role in a workspace. Non-members
+// get a 404 so workspace IDs can't be probed, and members whose role is
+// not one of roles get a 403. With no roles any member passes.
+func RequireRole(c *fiber.Ctx, conn RoleQuerier, workspaceID string, roles ...string) (string, error) {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return "", err
+	}
+
+	role, err := memberRole(c.UserContext(), conn, workspaceID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", apperr.New(fiber.StatusNotFound, "Workspace not found")
+		}
+		return "", fmt.Errorf("checking workspace membership: %w", err)
+	}
+	if len(roles) > 0 && !slices.Contains(roles, role) {
+		return "", apperr.New(fiber.StatusForbidden, "Insufficient permissions")
+	}
+	return role, nil
+}
+
+// memberRole looks up a user's role in a workspace, returning
+// sql.ErrNoRows if they are not a member
+func memberRole(ctx context.Context, conn RoleQuerier, workspaceID, userID string) (string, error) {
+	var role string
+	err := conn.QueryRowContext(ctx,
+		"SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?",
+		workspaceID, userID,
+	).Scan(&role)
+	return role, err
+}
+
+// normalizeEmail lowercases an address so invitations match the account
+// regardless of how either was typed
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func closeRows(rows *sql.Rows) {
+	if err := rows.Close(); err != nil {
+		log.Println("Error closing rows:", err)
+	}
+}
@@ -0,0 +1,374 @@
+package workspaces
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var roleQuery = regexp.QuoteMeta("SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?")
+
+// fakeMailer records sent emails
+type fakeMailer struct {
+	sent []string
+	err  error
+}
+
+func (m *fakeMailer) Send(to, subject, body string) error {
+	m.sent = append(m.sent, to+": "+body)
+	return m.err
+}
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+// fakeSockets records whose access was revoked
+type fakeSockets struct {
+	revoked []string
+}
+
+func (f *fakeSockets) RevokeAccess(_ context.Context, userID string) {
+	f.revoked = append(f.revoked, userID)
+}
+
+// testHelper contains common test setup and utilities
+type testHelper struct {
+	mockDB  sqlmock.Sqlmock
+	app     *fiber.App
+	handler *Handler
+	mailer  *fakeMailer
+	audit   *fakeAudit
+	sockets *fakeSockets
+}
+
+// newTestHelper creates a handler backed by sqlmock, with user123 as the
+// authenticated user
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	mailer := &fakeMailer{}
+	auditLog := &fakeAudit{}
+	sockets := &fakeSockets{}
+	handler := NewHandler(db, mailer, InviteConfig{BaseURL: "https://notes.example.com/"}, auditLog, sockets)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Post("/workspaces", handler.CreateWorkspace)
+	app.Get("/workspaces", handler.ListWorkspaces)
+	app.Get("/workspaces/:id", handler.GetWorkspace)
+	app.Delete("/workspaces/:id", handler.DeleteWorkspace)
+	app.Patch("/workspaces/:id/members/:userId", handler.UpdateMember)
+	app.Delete("/workspaces/:id/members/:userId", handler.RemoveMember)
+	app.Post("/workspaces/:id/invites", handler.CreateInvitation)
+	app.Get("/workspaces/:id/invites", handler.ListInvitations)
+	app.Delete("/workspaces/:id/invites/:invitationId", handler.RevokeInvitation)
+	app.Get("/me/invitations", handler.MyInvitations)
+	app.Post("/invitations/:id/accept", handler.AcceptInvitation)
+	app.Post("/invitations/:id/decline", handler.DeclineInvitation)
+	app.Get("/invites/:token", handler.GetInvite)
+	app.Post("/invites/:token/accept", handler.AcceptInvite)
+
+	return &testHelper{mockDB: mockDB, app: app, handler: handler, mailer: mailer, audit: auditLog, sockets: sockets}
+}
+
+// do performs a request with an optional JSON body
+func (h *testHelper) do(t *testing.T, method, path string, body any) *apperr.Response {
+	reader := bytes.NewReader(nil)
+	if body != nil {
+		b, _ := json.Marshal(body)
+		reader = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+
+	var response apperr.Response
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+	response.Code = resp.StatusCode
+	return &response
+}
+
+// expectRole makes userID a member of ws1 with role, or a non-member when
+// role is empty
+func (h *testHelper) expectRole(userID, role string) {
+	rows := sqlmock.NewRows([]string{"role"})
+	if role != "" {
+		rows.AddRow(role)
+	}
+	h.mockDB.ExpectQuery(roleQuery).WithArgs("ws1", userID).WillReturnRows(rows)
+}
+
+func TestCreateWorkspace(t *testing.T) {
+	testCases := []struct {
+		name           string
+		payload        any
+		setupMock      func(mock sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			name:    "Success",
+			payload: WorkspacePayload{Name: "  Team  "},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO workspaces (id, name) VALUES (?, ?)")).
+					WithArgs(sqlmock.AnyArg(), "Team").WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (?, ?, ?)")).
+					WithArgs(sqlmock.AnyArg(), "user123", RoleOwner).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:    "Database Error Rolls Back",
+			payload: WorkspacePayload{Name: "Team"},
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta("INSERT INTO workspaces (id, name) VALUES (?, ?)")).WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+		{
+			name:           "Missing Name",
+			payload:        WorkspacePayload{Name: " "},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			if tc.setupMock != nil {
+				tc.setupMock(h.mockDB)
+			}
+
+			resp := h.do(t, "POST", "/workspaces", tc.payload)
+			assert.Equal(t, tc.expectedStatus, resp.Code)
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestListWorkspaces(t *testing.T) {
+	h := newTestHelper(t)
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT w.id, w.name, m.role, w.created_at FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id WHERE m.user_id = ? ORDER BY w.name")).
+		WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "role", "created_at"}).AddRow("ws1", "Team", RoleAdmin, time.Now()))
+
+	req := httptest.NewRequest("GET", "/workspaces", nil)
+	resp, err := h.app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var workspaces []Workspace
+	if err := json.NewDecoder(resp.Body).Decode(&workspaces); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.Len(t, workspaces, 1)
+	assert.Equal(t, RoleAdmin, workspaces[0].Role)
+
+	if err := h.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetWorkspace(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		h := newTestHelper(t)
+		now := time.Now()
+		h.expectRole("user123", RoleMember)
+		h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT name, created_at FROM workspaces WHERE id = ?")).
+			WithArgs("ws1").WillReturnRows(sqlmock.NewRows([]string{"name", "created_at"}).AddRow("Team", now))
+		h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT m.user_id, u.email, COALESCE(u.display_name, ''), m.role, m.created_at FROM workspace_members m JOIN users u ON u.id = m.user_id WHERE m.workspace_id = ? ORDER BY m.created_at")).
+			WithArgs("ws1").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "display_name", "role", "created_at"}).
+				AddRow("owner1", "owner@example.com", "Owner", RoleOwner, now).
+				AddRow("user123", "me@example.com", "", RoleMember, now))
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/workspaces/ws1", nil))
+		if err != nil {
+			t.Fatalf("error performing request: %v", err)
+		}
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		var w Workspace
+		if err := json.NewDecoder(resp.Body).Decode(&w); err != nil {
+			t.Fatalf("error decoding response: %v", err)
+		}
+		assert.Equal(t, "Team", w.Name)
+		assert.Len(t, w.Members, 2)
+
+		if err := h.mockDB.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %v", err)
+		}
+	})
+
+	t.Run("Not A Member", func(t *testing.T) {
+		h := newTestHelper(t)
+		h.expectRole("user123", "")
+
+		resp := h.do(t, "GET", "/workspaces/ws1", nil)
+		assert.Equal(t, fiber.StatusNotFound, resp.Code)
+		assert.Equal(t, "Workspace not found", resp.Message)
+	})
+}
+
+func TestDeleteWorkspace(t *testing.T) {
+	deleteQuery := regexp.QuoteMeta("DELETE FROM workspaces WHERE id = ?")
+
+	testCases := []struct {
+		name           string
+		role           string
+		expectDelete   bool
+		expectedStatus int
+	}{
+		{name: "Owner", role: RoleOwner, expectDelete: true, expectedStatus: fiber.StatusNoContent},
+		{name: "Admin", role: RoleAdmin, expectedStatus: fiber.StatusForbidden},
+		{name: "Not A Member", expectedStatus: fiber.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			h.expectRole("user123", tc.role)
+			if tc.expectDelete {
+				h.mockDB.ExpectExec(deleteQuery).WithArgs("ws1").WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			resp := h.do(t, "DELETE", "/workspaces/ws1", nil)
+			assert.Equal(t, tc.expectedStatus, resp.Code)
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestUpdateMember(t *testing.T) {
+	updateQuery := regexp.QuoteMeta("UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?")
+
+	testCases := []struct {
+		name           string
+		path           string
+		payload        RolePayload
+		role           string
+		affected       int64
+		expectUpdate   bool
+		expectedStatus int
+	}{
+		{name: "Promote", path: "/workspaces/ws1/members/user456", payload: RolePayload{Role: RoleAdmin}, role: RoleOwner, affected: 1, expectUpdate: true, expectedStatus: fiber.StatusNoContent},
+		{name: "Unknown Member", path: "/workspaces/ws1/members/user456", payload: RolePayload{Role: RoleMember}, role: RoleOwner, expectUpdate: true, expectedStatus: fiber.StatusNotFound},
+		{name: "Own Role", path: "/workspaces/ws1/members/user123", payload: RolePayload{Role: RoleMember}, role: RoleOwner, expectedStatus: fiber.StatusBadRequest},
+		{name: "Admin Cannot Promote", path: "/workspaces/ws1/members/user456", payload: RolePayload{Role: RoleAdmin}, role: RoleAdmin, expectedStatus: fiber.StatusForbidden},
+		{name: "Invalid Role", path: "/workspaces/ws1/members/user456", payload: RolePayload{Role: RoleOwner}, expectedStatus: fiber.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			if tc.role != "" {
+				h.expectRole("user123", tc.role)
+			}
+			if tc.expectUpdate {
+				h.mockDB.ExpectExec(updateQuery).WithArgs(tc.payload.Role, "ws1", "user456").WillReturnResult(sqlmock.NewResult(0, tc.affected))
+			}
+
+			resp := h.do(t, "PATCH", tc.path, tc.payload)
+			assert.Equal(t, tc.expectedStatus, resp.Code)
+			if tc.expectedStatus == fiber.StatusNoContent && assert.Len(t, h.audit.entries, 1) {
+				entry := h.audit.entries[0]
+				assert.Equal(t, audit.EventRoleChanged, entry.Event)
+				assert.Equal(t, "user456", entry.UserID)
+				assert.Equal(t, map[string]string{"workspace_id": "ws1", "role": RoleAdmin}, entry.Details)
+			}
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	rolesQuery := regexp.QuoteMeta("DELETE FROM note_collaborators WHERE user_id = ? AND note_id IN (SELECT id FROM notes WHERE workspace_id = ?)")
+	deleteQuery := regexp.QuoteMeta("DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?")
+
+	testCases := []struct {
+		name           string
+		member         string
+		role           string
+		targetRole     string
+		expectDelete   bool
+		expectedStatus int
+	}{
+		{name: "Leave", member: "user123", role: RoleMember, expectDelete: true, expectedStatus: fiber.StatusNoContent},
+		{name: "Owner Cannot Leave", member: "user123", role: RoleOwner, expectedStatus: fiber.StatusBadRequest},
+		{name: "Owner Removes Admin", member: "user456", role: RoleOwner, targetRole: RoleAdmin, expectDelete: true, expectedStatus: fiber.StatusNoContent},
+		{name: "Admin Removes Member", member: "user456", role: RoleAdmin, targetRole: RoleMember, expectDelete: true, expectedStatus: fiber.StatusNoContent},
+		{name: "Admin Cannot Remove Admin", member: "user456", role: RoleAdmin, targetRole: RoleAdmin, expectedStatus: fiber.StatusForbidden},
+		{name: "Admin Cannot Remove Owner", member: "user456", role: RoleAdmin, targetRole: RoleOwner, expectedStatus: fiber.StatusForbidden},
+		{name: "Member Cannot Remove Others", member: "user456", role: RoleMember, expectedStatus: fiber.StatusForbidden},
+		{name: "Unknown Member", member: "user456", role: RoleOwner, expectedStatus: fiber.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			h.expectRole("user123", tc.role)
+			if tc.member != "user123" && tc.role != RoleMember {
+				h.expectRole(tc.member, tc.targetRole)
+			}
+			if tc.expectDelete {
+				h.mockDB.ExpectBegin()
+				h.mockDB.ExpectExec(rolesQuery).WithArgs(tc.member, "ws1").WillReturnResult(sqlmock.NewResult(0, 2))
+				h.mockDB.ExpectExec(deleteQuery).WithArgs("ws1", tc.member).WillReturnResult(sqlmock.NewResult(0, 1))
+				h.mockDB.ExpectCommit()
+			}
+
+			resp := h.do(t, "DELETE", "/workspaces/ws1/members/"+tc.member, nil)
+			assert.Equal(t, tc.expectedStatus, resp.Code)
+			if tc.expectDelete {
+				assert.Equal(t, []string{tc.member}, h.sockets.revoked)
+			} else {
+				assert.Empty(t, h.sockets.revoked)
+			}
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Package idempotency lets clients retry requests that create things
+// without creating them twice. A request sent with an Idempotency-Key
+// header has its response stored, and a retry with the same key gets that
+// response back instead of running again. Reusing a key for a different
+// request is refused.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// Header carries the client's key for a request
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set to true on responses replayed from a key
+	ReplayedHeader = "Idempotent-Replayed"
+	// MaxKeyLength is the longest key accepted
+	MaxKeyLength = 255
+	// TTL is how long a response is kept for retries
+	TTL = 24 * time.Hour
+	// Lease is how long a running request holds its key. A request that
+	// never finishes, because the server crashed, stops blocking retries
+	// once its lease runs out.
+	Lease = 5 * time.Minute
+	// DefaultPurgeInterval is how often expired keys are deleted
+	DefaultPurgeInterval = time.Hour
+)
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Store keeps the responses to requests made with an idempotency key
+type Store struct {
+	db DBInterface
+}
+
+// New creates a Store backed by the idempotency_keys table
+func New(db DBInterface) *Store {
+	return &Store{db: db}
+}
+
+// Handler returns a middleware for routes that create things. Requests
+// without an Idempotency-Key header pass straight through. Otherwise the
+// first request with a key runs and its response is kept for TTL;
+// retries get it back with an Idempotent-Replayed header. A retry while
+// the first is still running gets 409, and reusing a key with a different
+// method, path or body gets 422. Keys belong to a user, so the middleware
+// must come after the one that authenticates them. Requests that fail
+// with an error, a 5xx response or a panic keep nothing, so they can be
+// retried with the same key.
+func (s *Store) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := strings.TrimSpace(c.Get(Header))
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > MaxKeyLength {
+			return apperr.New(fiber.StatusBadRequest, "Idempotency-Key is too long")
+		}
+		userID, err := auth.UserIDFromCtx(c)
+		if err != nil {
+			return err
+		}
+		hash, err := requestHash(c)
+		if err != nil {
+			return err
+		}
+
+		ctx := c.UserContext()
+		now := time.Now().UTC()
+		var storedHash string
+		var status sql.NullInt64
+		var contentType, body sql.NullString
+		err = s.db.QueryRowContext(ctx,
+			"SELECT request_hash, status, content_type, response FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ? AND expires_at > ?",
+			userID, key, now,
+		).Scan(&storedHash, &status, &contentType, &body)
+		switch {
+		case err == nil:
+			if storedHash != hash {
+				return apperr.New(fiber.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			}
+			if !status.Valid {
+				return apperr.New(fiber.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			}
+			c.Set(ReplayedHeader, "true")
+			if contentType.Valid {
+				c.Set(fiber.HeaderContentType, contentType.String)
+			}
+			return c.Status(int(status.Int64)).SendString(body.String)
+		case !errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("looking up idempotency key: %w", err)
+		}
+
+		if err := s.claim(ctx, userID, key, hash, now); err != nil {
+			return err
+		}
+
+		// The outcome is recorded even if the request's own context ran out
+		ctx = context.WithoutCancel(ctx)
+		defer func() {
+			if r := recover(); r != nil {
+				s.release(ctx, userID, key)
+				panic(r)
+			}
+		}()
+		err = c.Next()
+		resp := c.Response()
+		if err != nil || resp.StatusCode() >= fiber.StatusInternalServerError {
+			s.release(ctx, userID, key)
+			return err
+		}
+		_, dbErr := s.db.ExecContext(ctx,
+			"UPDATE idempotency_keys SET status = ?, content_type = ?, response = ?, expires_at = ? WHERE user_id = ? AND idempotency_key = ?",
+			resp.StatusCode(), string(resp.Header.ContentType()), string(resp.Body()), time.Now().UTC().Add(TTL), userID, key,
+		)
+		if dbErr != nil {
+			log.Printf("Error saving idempotent response of %s: %v", userID, dbErr)
+		}
+		return nil
+	}
+}
+
+// claim records that the request with key is running, holding the key for
+// Lease. A key left from an expired request or lease is taken over; one
+// held by a request still running is refused with 409.
+func (s *Store) claim(ctx context.Context, userID, key, hash string, now time.Time) error {
+	insert := func() error {
+		_, err := s.db.ExecContext(ctx,
+			"INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+			userID, key, hash, now, now.Add(Lease),
+		)
+		return err
+	}
+
+	err := insert()
+	if err != nil && db.IsDuplicate(err) {
+		_, err = s.db.ExecContext(ctx,
+			"DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ? AND expires_at <= ?",
+			userID, key, now,
+		)
+		if err == nil {
+			err = insert()
+		}
+	}
+	if err != nil {
+		if db.IsDuplicate(err) {
+			return apperr.New(fiber.StatusConflict, "A request with this Idempotency-Key is still in progress")
+		}
+		return fmt.Errorf("claiming idempotency key: %w", err)
+	}
+	return nil
+}
+
+// release drops the claim on key of a request that kept no response
+func (s *Store) release(ctx context.Context, userID, key string) {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?", userID, key); err != nil {
+		log.Printf("Error releasing idempotency key of %s: %v", userID, err)
+	}
+}
+
+// requestHash identifies a request by its method, path and body. The
+// parts of multipart forms are hashed rather than the raw body, whose
+// boundary changes each time a client builds the request.
+func requestHash(c *fiber.Ctx) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", c.Method(), c.Path())
+
+	if !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEMultipartForm) {
+		h.Write(c.Body())
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return "", apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	for _, name := range sortedKeys(form.Value) {
+		for _, v := range form.Value[name] {
+			fmt.Fprintf(h, "value %q %q\n", name, v)
+		}
+	}
+	for _, name := range sortedKeys(form.File) {
+		for _, fh := range form.File[name] {
+			fmt.Fprintf(h, "file %q %q %d\n", name, fh.Filename, fh.Size)
+			f, err := fh.Open()
+			if err != nil {
+				return "", fmt.Errorf("reading upload: %w", err)
+			}
+			_, err = io.Copy(h, f)
+			_ = f.Close()
+			if err != nil {
+				return "", fmt.Errorf("reading upload: %w", err)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Purge deletes the keys that expired before now and returns how many
+// went
+func (s *Store) Purge(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("purging idempotency keys: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// StartPurger runs Purge every interval until stop is closed
+// Nothing is purged while paused reports true.
+func (s *Store) StartPurger(interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if _, err := s.Purge(ctx, now); err != nil {
+				log.Println("Error purging idempotency keys:", err)
+			}
+			cancel()
+		case <-stop:
+			return
+		}
+	}
+}
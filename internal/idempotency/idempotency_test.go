@@ -0,0 +1,247 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/middleware"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	lookupQuery = regexp.QuoteMeta("SELECT request_hash, status, content_type, response FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ? AND expires_at > ?")
+	lookupCols  = []string{"request_hash", "status", "content_type", "response"}
+	insertQuery = regexp.QuoteMeta("INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)")
+	saveQuery   = regexp.QuoteMeta("UPDATE idempotency_keys SET status = ?, content_type = ?, response = ?, expires_at = ? WHERE user_id = ? AND idempotency_key = ?")
+	deleteQuery = regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?")
+)
+
+type testHelper struct {
+	mockDB sqlmock.Sqlmock
+	app    *fiber.App
+	// runs counts the requests that reached the route
+	runs int
+}
+
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+
+	h := &testHelper{mockDB: mockDB}
+	h.app = fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	h.app.Use(middleware.Recover())
+	h.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	h.app.Post("/notes", New(db).Handler(), func(c *fiber.Ctx) error {
+		h.runs++
+		if c.Query("fail") != "" {
+			return errors.New("database is down")
+		}
+		if c.Query("panic") != "" {
+			panic("nil map")
+		}
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": "note1"})
+	})
+	return h
+}
+
+func (h *testHelper) post(t *testing.T, target, key, body string) *http.Response {
+	req := httptest.NewRequest("POST", target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	resp, err := h.app.Test(req)
+	require.NoError(t, err)
+	return resp
+}
+
+// hashOf is the hash the handler gives a JSON request
+func hashOf(t *testing.T, method, path, body string) string {
+	app := fiber.New()
+	var hash string
+	app.All("/*", func(c *fiber.Ctx) error {
+		var err error
+		hash, err = requestHash(c)
+		return err
+	})
+	_, err := app.Test(httptest.NewRequest(method, path, strings.NewReader(body)))
+	require.NoError(t, err)
+	return hash
+}
+
+func TestHandler(t *testing.T) {
+	body := `{"title":"Groceries"}`
+	hash := hashOf(t, "POST", "/notes", body)
+
+	t.Run("First Request Is Kept", func(t *testing.T) {
+		h := newTestHelper(t)
+		h.mockDB.ExpectQuery(lookupQuery).WithArgs("user123", "k1", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(lookupCols))
+		h.mockDB.ExpectExec(insertQuery).WithArgs("user123", "k1", hash, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		h.mockDB.ExpectExec(saveQuery).WithArgs(fiber.StatusCreated, "application/json", `{"id":"note1"}`, sqlmock.AnyArg(), "user123", "k1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		resp := h.post(t, "/notes", "k1", body)
+		assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get(ReplayedHeader))
+		assert.Equal(t, 1, h.runs)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Retry Is Replayed", func(t *testing.T) {
+		h := newTestHelper(t)
+		h.mockDB.ExpectQuery(lookupQuery).WithArgs("user123", "k1", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(lookupCols).AddRow(hash, 201, "application/json", `{"id":"note1"}`))
+
+		resp := h.post(t, "/notes", "k1", body)
+		assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "true", resp.Header.Get(ReplayedHeader))
+		got, _ := io.ReadAll(resp.Body)
+		assert.JSONEq(t, `{"id":"note1"}`, string(got))
+		assert.Zero(t, h.runs)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Key Reused For Another Request", func(t *testing.T) {
+		h := newTestHelper(t)
+		h.mockDB.ExpectQuery(lookupQuery).WithArgs("user123", "k1", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(lookupCols).AddRow(hash, 201, "application/json", `{"id":"note1"}`))
+
+		resp := h.post(t, "/notes", "k1", `{"title":"Other"}`)
+		assert.Equal(t, fiber.StatusUnprocessableEntity, resp.StatusCode)
+		assert.Zero(t, h.runs)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("First Request Still Running", func(t *testing.T) {
+		h := newTestHelper(t)
+		h.mockDB.ExpectQuery(lookupQuery).WithArgs("user123", "k1", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(lookupCols).AddRow(hash, nil, nil, nil))
+
+		resp := h.post(t, "/notes", "k1", body)
+		assert.Equal(t, fiber.StatusConflict, resp.StatusCode)
+		assert.Zero(t, h.runs)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Concurrent First Requests", func(t *testing.T) {
+		h := newTestHelper(t)
+		h.mockDB.ExpectQuery(lookupQuery).WithArgs("user123", "k1", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(lookupCols))
+		h.mockDB.ExpectExec(insertQuery).WillReturnError(&mysql.MySQLError{Number: 1062})
+		// The key isn't an expired one, so it is still held
+		h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ? AND expires_at <= ?")).
+			WithArgs("user123", "k1", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		h.mockDB.ExpectExec(insertQuery).WillReturnError(&mysql.MySQLError{Number: 1062})
+
+		resp := h.post(t, "/notes", "k1", body)
+		assert.Equal(t, fiber.StatusConflict, resp.StatusCode)
+		assert.Zero(t, h.runs)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Failed Request Releases The Key", func(t *testing.T) {
+		h := newTestHelper(t)
+		failHash := hashOf(t, "POST", "/notes", body)
+		h.mockDB.ExpectQuery(lookupQuery).WithArgs("user123", "k1", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(lookupCols))
+		h.mockDB.ExpectExec(insertQuery).WithArgs("user123", "k1", failHash, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		h.mockDB.ExpectExec(deleteQuery).WithArgs("user123", "k1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		resp := h.post(t, "/notes?fail=1", "k1", body)
+		assert.Equal(t, fiber.StatusInternalServerError, resp.StatusCode)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Panicking Request Releases The Key", func(t *testing.T) {
+		h := newTestHelper(t)
+		h.mockDB.ExpectQuery(lookupQuery).WithArgs("user123", "k1", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(lookupCols))
+		h.mockDB.ExpectExec(insertQuery).WithArgs("user123", "k1", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		h.mockDB.ExpectExec(deleteQuery).WithArgs("user123", "k1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		resp := h.post(t, "/notes?panic=1", "k1", body)
+		assert.Equal(t, fiber.StatusInternalServerError, resp.StatusCode)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Without A Key", func(t *testing.T) {
+		h := newTestHelper(t)
+		resp := h.post(t, "/notes", "", body)
+		assert.Equal(t, fiber.StatusCreated, resp.StatusCode)
+		assert.Equal(t, 1, h.runs)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Key Too Long", func(t *testing.T) {
+		h := newTestHelper(t)
+		resp := h.post(t, "/notes", strings.Repeat("k", MaxKeyLength+1), body)
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+		assert.Zero(t, h.runs)
+	})
+}
+
+func TestRequestHash_Multipart(t *testing.T) {
+	// form builds the same upload with a fresh boundary each time
+	form := func(content string) (string, string) {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		require.NoError(t, w.WriteField("format", "enex"))
+		f, err := w.CreateFormFile("file", "export.enex")
+		require.NoError(t, err)
+		_, _ = f.Write([]byte(content))
+		require.NoError(t, w.Close())
+		return buf.String(), w.FormDataContentType()
+	}
+	hash := func(body, contentType string) string {
+		app := fiber.New()
+		var h string
+		app.Post("/imports", func(c *fiber.Ctx) error {
+			var err error
+			h, err = requestHash(c)
+			return err
+		})
+		req := httptest.NewRequest("POST", "/imports", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		_, err := app.Test(req)
+		require.NoError(t, err)
+		return h
+	}
+
+	first := hash(form("<en-export/>"))
+	assert.Equal(t, first, hash(form("<en-export/>")))
+	assert.NotEqual(t, first, hash(form("<en-export>other</en-export>")))
+}
+
+func TestPurge(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM idempotency_keys WHERE expires_at <= ?")).
+		WithArgs(now).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	n, err := New(db).Purge(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,188 @@
+package imports
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// enexNote is a <note> of an Evernote export
+type enexNote struct {
+	Title     string         `xml:"title"`
+	Content   string         `xml:"content"`
+	Tags      []string       `xml:"tag"`
+	Resources []enexResource `xml:"resource"`
+}
+
+// enexResource is a file attached to an Evernote note, base64 encoded
+type enexResource struct {
+	Data     string `xml:"data"`
+	Mime     string `xml:"mime"`
+	FileName string `xml:"resource-attributes>file-name"`
+}
+
+// ParseENEX reads the notes of an Evernote .enex export. Evernote exports
+// one notebook per file without naming it, so notes are put in a notebook
+// named after filename.
+func ParseENEX(r io.Reader, filename string) ([]Note, error) {
+	notebook := strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	dec := xml.NewDecoder(r)
+	var notes []Note
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading export: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+
+		var en enexNote
+		if err := dec.DecodeElement(&en, &start); err != nil {
+			return nil, fmt.Errorf("reading note: %w", err)
+		}
+		n := Note{
+			Title:    strings.TrimSpace(en.Title),
+			Content:  enmlToText(en.Content),
+			Notebook: notebook,
+			Tags:     en.Tags,
+		}
+		for i, res := range en.Resources {
+			data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(res.Data), ""))
+			if err != nil {
+				return nil, fmt.Errorf("decoding attachment of %q: %w", n.Title, err)
+			}
+			name := res.FileName
+			if name == "" {
+				name = fmt.Sprintf("attachment-%d", i+1)
+			}
+			n.Files = append(n.Files, File{Name: name, Data: data})
+		}
+		notes = append(notes, n)
+	}
+	if notes == nil {
+		return nil, errors.New("no notes found, is this an Evernote export?")
+	}
+	return notes, nil
+}
+
+// blocks end their line
+var blocks = map[string]bool{
+	"div": true, "p": true, "li": true, "ul": true, "ol": true, "tr": true, "table": true,
+	"blockquote": true, "pre": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// enmlToText turns the ENML (Evernote's XHTML) of a note into the
+// Markdown-like text notes are written in. Lists become "- " items, check
+// boxes become task items, headings "#" lines and web links Markdown
+// links. Attached files are imported separately, so <en-media> is dropped.
+func enmlToText(enml string) string {
+	var b strings.Builder
+	atLineStart := func() bool {
+		s := b.String()
+		return s == "" || strings.HasSuffix(s, "\n")
+	}
+	newline := func() {
+		if !atLineStart() {
+			b.WriteString("\n")
+		}
+	}
+	var hrefs []string
+
+	z := html.NewTokenizer(strings.NewReader(enml))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return tidy(b.String())
+		case html.TextToken:
+			text := strings.ReplaceAll(string(z.Text()), "\n", " ")
+			if atLineStart() {
+				text = strings.TrimLeft(text, " \t")
+			}
+			b.WriteString(text)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			attrs := map[string]string{}
+			for more := true; more; {
+				var k, v []byte
+				k, v, more = z.TagAttr()
+				attrs[string(k)] = string(v)
+			}
+			switch tag := string(name); tag {
+			case "br":
+				b.WriteString("\n")
+			case "hr":
+				newline()
+				b.WriteString("---\n")
+			case "li":
+				newline()
+				b.WriteString("- ")
+			case "h1", "h2", "h3", "h4", "h5", "h6":
+				newline()
+				b.WriteString(strings.Repeat("#", int(tag[1]-'0')) + " ")
+			case "en-todo":
+				if atLineStart() {
+					b.WriteString("- ")
+				}
+				if attrs["checked"] == "true" {
+					b.WriteString("[x] ")
+				} else {
+					b.WriteString("[ ] ")
+				}
+			case "a":
+				href := attrs["href"]
+				if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") && !strings.HasPrefix(href, "mailto:") {
+					href = ""
+				}
+				hrefs = append(hrefs, href)
+				if href != "" {
+					b.WriteString("[")
+				}
+			default:
+				if blocks[tag] {
+					newline()
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch tag := string(name); {
+			case tag == "a" && len(hrefs) > 0:
+				if href := hrefs[len(hrefs)-1]; href != "" {
+					b.WriteString("](" + href + ")")
+				}
+				hrefs = hrefs[:len(hrefs)-1]
+			case blocks[tag]:
+				newline()
+			}
+		}
+	}
+}
+
+// tidy trims each line and the text, and collapses runs of blank lines
+func tidy(text string) string {
+	var out []string
+	blank := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			if !blank && len(out) > 0 {
+				out = append(out, "")
+			}
+			blank = true
+			continue
+		}
+		blank = false
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,61 @@
+package imports
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const enex = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export4.dtd">
+<en-export export-date="20260101T000000Z" application="Evernote">
+  <note>
+    <title>Groceries</title>
+    <content><![CDATA[<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+<en-note><h2>This week</h2><div><en-todo checked="true"/>Milk</div><div><en-todo/>Bread</div>
+<ul><li>Apples</li><li>See <a href="https://example.com/pears">pears</a></li></ul>
+<div>Line one<br/>Line two</div><en-media type="image/png" hash="abc"/></en-note>]]></content>
+    <tag>food</tag>
+    <tag>weekly shop</tag>
+    <resource>
+      <data encoding="base64">aGVs
+bG8=</data>
+      <mime>text/plain</mime>
+      <resource-attributes><file-name>list.txt</file-name></resource-attributes>
+    </resource>
+    <resource>
+      <data encoding="base64">d29ybGQ=</data>
+      <mime>text/plain</mime>
+    </resource>
+  </note>
+  <note>
+    <title>Empty</title>
+    <content><![CDATA[<en-note></en-note>]]></content>
+  </note>
+</en-export>`
+
+func TestParseENEX(t *testing.T) {
+	notes, err := ParseENEX(strings.NewReader(enex), "Home.enex")
+	require.NoError(t, err)
+	require.Len(t, notes, 2)
+
+	n := notes[0]
+	assert.Equal(t, "Groceries", n.Title)
+	assert.Equal(t, "Home", n.Notebook)
+	assert.Equal(t, []string{"food", "weekly shop"}, n.Tags)
+	assert.Equal(t, "## This week\n- [x] Milk\n- [ ] Bread\n- Apples\n- See [pears](https://example.com/pears)\nLine one\nLine two", n.Content)
+	assert.Equal(t, []File{{Name: "list.txt", Data: []byte("hello")}, {Name: "attachment-2", Data: []byte("world")}}, n.Files)
+
+	assert.Equal(t, Note{Title: "Empty", Notebook: "Home"}, notes[1])
+}
+
+func TestParseENEXRejectsOtherFiles(t *testing.T) {
+	_, err := ParseENEX(strings.NewReader("<html><body>hi</body></html>"), "page.html")
+	assert.EqualError(t, err, "no notes found, is this an Evernote export?")
+
+	_, err = ParseENEX(strings.NewReader("<en-export><note><title>"), "broken.enex")
+	assert.Error(t, err)
+}
@@ -0,0 +1,348 @@
+// Package imports brings notes exported from other apps, Evernote and
+// Notion, into a user's private notes. Imports run in the background and
+// clients poll them for progress.
+package imports
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/handlers/attachments"
+	"quanta/internal/handlers/notes"
+	"quanta/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// MaxImportSize is the largest export accepted, in bytes
+const MaxImportSize = 50 << 20
+
+// Format is the kind of export being imported
+type Format string
+
+const (
+	// ENEX is an Evernote .enex export of a notebook
+	ENEX Format = "enex"
+	// Notion is a Notion "Markdown & CSV" ZIP export
+	Notion Format = "notion"
+)
+
+// Status is how far along an import is
+type Status string
+
+const (
+	// Running imports are still creating notes
+	Running Status = "running"
+	// Done imports have created every note they could
+	Done Status = "done"
+	// Failed imports could not read the export; Error says why
+	Failed Status = "failed"
+)
+
+// Note is a note read from an export
+type Note struct {
+	Title    string
+	Content  string
+	Notebook string
+	Tags     []string
+	Files    []File
+}
+
+// File is a file attached to a note in an export
+type File struct {
+	Name string
+	Data []byte
+}
+
+// body returns the note's content for storing. Notes have no notebooks or
+// tags, so besides going into metadata they are kept as lines at the end of
+// the content where search still finds them.
+func (n Note) body() string {
+	var meta []string
+	if n.Notebook != "" {
+		meta = append(meta, "Notebook: "+n.Notebook)
+	}
+	if len(n.Tags) > 0 {
+		tags := make([]string, len(n.Tags))
+		for i, t := range n.Tags {
+			tags[i] = "#" + strings.Join(strings.Fields(t), "-")
+		}
+		meta = append(meta, "Tags: "+strings.Join(tags, " "))
+	}
+	if len(meta) == 0 {
+		return n.Content
+	}
+	if n.Content == "" {
+		return strings.Join(meta, "\n")
+	}
+	return n.Content + "\n\n" + strings.Join(meta, "\n")
+}
+
+// metadata returns the note's notebook and tags as metadata, so clients
+// can filter imported notes by them
+func (n Note) metadata() notes.Metadata {
+	m := notes.Metadata{}
+	if n.Notebook != "" {
+		m["notebook"] = n.Notebook
+	}
+	if len(n.Tags) > 0 {
+		tags := make([]any, len(n.Tags))
+		for i, t := range n.Tags {
+			tags[i] = t
+		}
+		m["tags"] = tags
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// Import is an import of an export and its progress. Total is 0 until the
+// export has been read. Skipped counts notes that could not be created,
+// e.g. for being over the size limit.
+type Import struct {
+	ID          string     `json:"id"`
+	Format      Format     `json:"format"`
+	Status      Status     `json:"status"`
+	Total       int        `json:"total"`
+	Imported    int        `json:"imported"`
+	Skipped     int        `json:"skipped"`
+	Attachments int        `json:"attachments"`
+	Error       *string    `json:"error"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// NoteCreator creates a note as if the user had written it
+type NoteCreator interface {
+	Create(ctx context.Context, userID string, workspaceID *string, payload notes.NotePayload) (string, error)
+}
+
+// Attacher stores a file as an attachment of a user's note
+type Attacher interface {
+	Attach(ctx context.Context, userID, noteID, filename string, data []byte) (attachments.Attachment, error)
+}
+
+// Handler handles HTTP requests related to imports
+type Handler struct {
+	db     DBInterface
+	notes  NoteCreator
+	files  Attacher
+	limits models.NoteLimits
+	// async runs an import in the background
+	async func(func())
+}
+
+// NewHandler creates a new Handler that creates notes with notes and their
+// attachments with files, shortening titles to fit limits
+func NewHandler(db DBInterface, notes NoteCreator, files Attacher, limits models.NoteLimits) *Handler {
+	return &Handler{
+		db:     db,
+		notes:  notes,
+		files:  files,
+		limits: limits,
+		async:  func(run func()) { go run() },
+	}
+}
+
+// CreateImport starts importing an uploaded export, given as the multipart
+// file field, whose format is enex or notion. It responds straight away
+// with the import to poll.
+func (h *Handler) CreateImport(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	format := Format(c.FormValue("format"))
+	if format != ENEX && format != Notion {
+		return apperr.Invalid(map[string]string{"format": "must be enex or notion"})
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Missing file")
+	}
+	if fileHeader.Size == 0 {
+		return apperr.New(fiber.StatusBadRequest, "File is empty")
+	}
+	if fileHeader.Size > MaxImportSize {
+		return apperr.New(fiber.StatusRequestEntityTooLarge, "File exceeds the maximum import size")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return fmt.Errorf("opening uploaded file: %w", err)
+	}
+	data, err := io.ReadAll(file)
+	if closeErr := file.Close(); closeErr != nil {
+		log.Println("Error closing uploaded file:", closeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("reading uploaded file: %w", err)
+	}
+
+	imp := Import{
+		ID:        uuid.New().String(),
+		Format:    format,
+		Status:    Running,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	_, err = h.db.ExecContext(c.UserContext(),
+		"INSERT INTO imports (id, user_id, format, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		imp.ID, userID, string(imp.Format), string(imp.Status), imp.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("creating import: %w", err)
+	}
+
+	filename := fileHeader.Filename
+	h.async(func() {
+		h.run(context.Background(), imp, userID, filename, data)
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(imp)
+}
+
+// export is an export whose notes can be counted before they are read
+type export interface {
+	Len() int
+	Each(fn func(Note)) error
+}
+
+// noteList is an export read in full up front
+type noteList []Note
+
+func (l noteList) Len() int {
+	return len(l)
+}
+
+func (l noteList) Each(fn func(Note)) error {
+	for _, n := range l {
+		fn(n)
+	}
+	return nil
+}
+
+// open reads an export in format
+func open(format Format, filename string, data []byte) (export, error) {
+	if format == Notion {
+		return OpenNotion(data)
+	}
+	parsed, err := ParseENEX(bytes.NewReader(data), filename)
+	return noteList(parsed), err
+}
+
+// run reads an export and creates its notes as they are read, recording
+// progress after each one
+func (h *Handler) run(ctx context.Context, imp Import, userID, filename string, data []byte) {
+	exp, err := open(imp.Format, filename, data)
+	if err != nil {
+		h.finish(ctx, imp.ID, Failed, err.Error())
+		return
+	}
+
+	imp.Total = exp.Len()
+	if _, err := h.db.ExecContext(ctx, "UPDATE imports SET total = ? WHERE id = ?", imp.Total, imp.ID); err != nil {
+		log.Printf("Error updating import %s: %v", imp.ID, err)
+	}
+
+	err = exp.Each(func(n Note) {
+		noteID, err := h.notes.Create(ctx, userID, nil, notes.NotePayload{Title: h.title(n.Title), Content: n.body(), Metadata: n.metadata()})
+		if err != nil {
+			log.Printf("Import %s skipped note %q: %v", imp.ID, n.Title, err)
+			imp.Skipped++
+		} else {
+			imp.Imported++
+			for _, f := range n.Files {
+				if _, err := h.files.Attach(ctx, userID, noteID, f.Name, f.Data); err != nil {
+					log.Printf("Import %s skipped attachment %q of note %s: %v", imp.ID, f.Name, noteID, err)
+					continue
+				}
+				imp.Attachments++
+			}
+		}
+
+		_, err = h.db.ExecContext(ctx, "UPDATE imports SET imported = ?, skipped = ?, attachments = ? WHERE id = ?",
+			imp.Imported, imp.Skipped, imp.Attachments, imp.ID)
+		if err != nil {
+			log.Printf("Error updating import %s: %v", imp.ID, err)
+		}
+	})
+	if err != nil {
+		// The notes created so far are kept
+		h.finish(ctx, imp.ID, Failed, err.Error())
+		return
+	}
+
+	h.finish(ctx, imp.ID, Done, "")
+}
+
+// finish records the outcome of an import
+func (h *Handler) finish(ctx context.Context, id string, status Status, reason string) {
+	_, err := h.db.ExecContext(ctx, "UPDATE imports SET status = ?, error = ?, finished_at = ? WHERE id = ?",
+		string(status), sql.NullString{String: reason, Valid: reason != ""}, time.Now().UTC(), id)
+	if err != nil {
+		log.Printf("Error finishing import %s: %v", id, err)
+	}
+}
+
+// title fits an imported note's title within the title limit, naming
+// untitled notes
+func (h *Handler) title(title string) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return "Untitled"
+	}
+	if n := h.limits.MaxTitleLength; n > 0 && utf8.RuneCountInString(title) > n {
+		return string([]rune(title)[:n])
+	}
+	return title
+}
+
+// GetImport returns one of the user's imports with its progress
+func (h *Handler) GetImport(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var imp Import
+	var reason sql.NullString
+	var finishedAt sql.NullTime
+	err = h.db.QueryRowContext(c.UserContext(),
+		"SELECT id, format, status, total, imported, skipped, attachments, error, created_at, finished_at FROM imports WHERE id = ? AND user_id = ?",
+		c.Params("id"), userID,
+	).Scan(&imp.ID, &imp.Format, &imp.Status, &imp.Total, &imp.Imported, &imp.Skipped, &imp.Attachments, &reason, &imp.CreatedAt, &finishedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "Import not found")
+		}
+		return fmt.Errorf("fetching import: %w", err)
+	}
+	if reason.Valid {
+		imp.Error = &reason.String
+	}
+	if finishedAt.Valid {
+		imp.FinishedAt = &finishedAt.Time
+	}
+
+	return c.JSON(imp)
+}
@@ -0,0 +1,215 @@
+package imports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/handlers/attachments"
+	"quanta/internal/handlers/notes"
+	"quanta/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeNotes creates notes named note-1, note-2... and rejects those titled
+// "Too big"
+type fakeNotes struct {
+	created []notes.NotePayload
+}
+
+func (f *fakeNotes) Create(_ context.Context, userID string, workspaceID *string, payload notes.NotePayload) (string, error) {
+	if userID != "user123" || workspaceID != nil {
+		return "", errors.New("unexpected owner")
+	}
+	if payload.Title == "Too big" {
+		return "", apperr.New(fiber.StatusPaymentRequired, "Storage quota exceeded")
+	}
+	f.created = append(f.created, payload)
+	return fmt.Sprintf("note-%d", len(f.created)), nil
+}
+
+type attached struct {
+	noteID, name string
+}
+
+// fakeFiles attaches any file except .exe ones
+type fakeFiles struct {
+	attached []attached
+}
+
+func (f *fakeFiles) Attach(_ context.Context, _, noteID, filename string, _ []byte) (attachments.Attachment, error) {
+	if strings.HasSuffix(filename, ".exe") {
+		return attachments.Attachment{}, apperr.New(fiber.StatusUnsupportedMediaType, "Unsupported file type")
+	}
+	f.attached = append(f.attached, attached{noteID, filename})
+	return attachments.Attachment{NoteID: noteID, Filename: filename}, nil
+}
+
+type testHelper struct {
+	mockDB sqlmock.Sqlmock
+	app    *fiber.App
+	notes  *fakeNotes
+	files  *fakeFiles
+}
+
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	h := &testHelper{mockDB: mockDB, notes: &fakeNotes{}, files: &fakeFiles{}}
+	handler := NewHandler(db, h.notes, h.files, models.NoteLimits{MaxTitleLength: 10})
+	// Run imports before responding so the test sees their progress
+	handler.async = func(run func()) { run() }
+
+	h.app = fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	h.app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	h.app.Post("/imports", handler.CreateImport)
+	h.app.Get("/imports/:id", handler.GetImport)
+	return h
+}
+
+// uploadBody builds a multipart form with the format field and, if
+// filename is set, the file field
+func uploadBody(t *testing.T, format, filename string, content []byte) (*bytes.Buffer, string) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	require.NoError(t, writer.WriteField("format", format))
+	if filename != "" {
+		part, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		_, err = part.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	return body, writer.FormDataContentType()
+}
+
+func TestCreateImport(t *testing.T) {
+	h := newTestHelper(t)
+	data := []byte(`<en-export>
+<note><title>A rather long title</title><content>&lt;en-note&gt;Hello&lt;/en-note&gt;</content><tag>to do</tag>
+<resource><data>aGk=</data><resource-attributes><file-name>hi.txt</file-name></resource-attributes></resource>
+<resource><data>aGk=</data><resource-attributes><file-name>hi.exe</file-name></resource-attributes></resource></note>
+<note><title>Too big</title><content>&lt;en-note/&gt;</content></note>
+<note><title></title><content>&lt;en-note&gt;x&lt;/en-note&gt;</content></note>
+</en-export>`)
+	progress := regexp.QuoteMeta("UPDATE imports SET imported = ?, skipped = ?, attachments = ? WHERE id = ?")
+
+	h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO imports (id, user_id, format, status, created_at) VALUES (?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "user123", "enex", "running", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE imports SET total = ? WHERE id = ?")).
+		WithArgs(3, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(progress).WithArgs(1, 0, 1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(progress).WithArgs(1, 1, 1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(progress).WithArgs(2, 1, 1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE imports SET status = ?, error = ?, finished_at = ? WHERE id = ?")).
+		WithArgs("done", nil, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	body, contentType := uploadBody(t, "enex", "Personal.enex", data)
+	req := httptest.NewRequest("POST", "/imports", body)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := h.app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+
+	var imp Import
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&imp))
+	assert.NotEmpty(t, imp.ID)
+	assert.Equal(t, Running, imp.Status)
+
+	assert.Equal(t, []notes.NotePayload{
+		{Title: "A rather l", Content: "Hello\n\nNotebook: Personal\nTags: #to-do", Metadata: notes.Metadata{"notebook": "Personal", "tags": []any{"to do"}}},
+		{Title: "Untitled", Content: "x\n\nNotebook: Personal", Metadata: notes.Metadata{"notebook": "Personal"}},
+	}, h.notes.created)
+	assert.Equal(t, []attached{{"note-1", "hi.txt"}}, h.files.attached)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestCreateImportFailure(t *testing.T) {
+	h := newTestHelper(t)
+
+	h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO imports")).
+		WithArgs(sqlmock.AnyArg(), "user123", "notion", "running", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE imports SET status = ?, error = ?, finished_at = ? WHERE id = ?")).
+		WithArgs("failed", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	body, contentType := uploadBody(t, "notion", "export.zip", []byte("not a zip"))
+	req := httptest.NewRequest("POST", "/imports", body)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := h.app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+	assert.Empty(t, h.notes.created)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestCreateImportValidation(t *testing.T) {
+	testCases := []struct {
+		name           string
+		format         string
+		filename       string
+		content        []byte
+		expectedStatus int
+	}{
+		{name: "unknown format", format: "onenote", filename: "a.one", content: []byte("x"), expectedStatus: fiber.StatusUnprocessableEntity},
+		{name: "missing file", format: "enex", expectedStatus: fiber.StatusBadRequest},
+		{name: "empty file", format: "enex", filename: "a.enex", expectedStatus: fiber.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+
+			body, contentType := uploadBody(t, tc.format, tc.filename, tc.content)
+			req := httptest.NewRequest("POST", "/imports", body)
+			req.Header.Set("Content-Type", contentType)
+			resp, err := h.app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, h.mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetImport(t *testing.T) {
+	h := newTestHelper(t)
+	query := regexp.QuoteMeta("SELECT id, format, status, total, imported, skipped, attachments, error, created_at, finished_at FROM imports WHERE id = ? AND user_id = ?")
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{"id", "format", "status", "total", "imported", "skipped", "attachments", "error", "created_at", "finished_at"}
+
+	h.mockDB.ExpectQuery(query).WithArgs("imp1", "user123").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("imp1", "notion", "running", 20, 7, 1, 3, nil, created, nil))
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/imports/imp1", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var imp Import
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&imp))
+	assert.Equal(t, Import{ID: "imp1", Format: Notion, Status: Running, Total: 20, Imported: 7, Skipped: 1, Attachments: 3, CreatedAt: created}, imp)
+
+	h.mockDB.ExpectQuery(query).WithArgs("other", "user123").WillReturnRows(sqlmock.NewRows(columns))
+	resp, err = h.app.Test(httptest.NewRequest("GET", "/imports/other", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
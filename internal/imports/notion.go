@@ -0,0 +1,167 @@
+package imports
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MaxEntrySize is the most bytes read from a single file of a ZIP export.
+// Larger files are left out rather than trusting the sizes the archive
+// claims.
+const MaxEntrySize = 10 << 20
+
+// MaxUnpackedSize is the most bytes read from all the files of a ZIP
+// export together, and MaxEntries the most files it may hold, so a small
+// upload can't unpack into an unbounded amount of work
+const (
+	MaxUnpackedSize = 500 << 20
+	MaxEntries      = 10000
+)
+
+// notionID matches the 32 hex digit page ID Notion appends to the names
+// of exported pages and their folders
+var notionID = regexp.MustCompile(`\s+[0-9a-f]{32}$`)
+
+// localLink matches the target of a Markdown link or image
+var localLink = regexp.MustCompile(`\]\(([^)\s]+)\)`)
+
+// NotionExport is a Notion "Markdown & CSV" ZIP export. Each page is a note
+// in a notebook named after the pages it is nested under, and the files
+// its links point to inside the export are attached to it. Databases,
+// exported as CSV, are left out.
+type NotionExport struct {
+	files map[string]*zip.File
+	pages []string
+	// unpacked counts the bytes read from the export so far
+	unpacked int64
+}
+
+// OpenNotion lists the pages of a Notion export without reading them
+func OpenNotion(data []byte) (*NotionExport, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("reading export: %w", err)
+	}
+	if len(zr.File) > MaxEntries {
+		return nil, fmt.Errorf("export holds more than %d files", MaxEntries)
+	}
+	e := &NotionExport{files: make(map[string]*zip.File, len(zr.File))}
+	for _, f := range zr.File {
+		name := path.Clean(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") {
+			continue
+		}
+		e.files[name] = f
+		if path.Ext(name) == ".md" {
+			e.pages = append(e.pages, name)
+		}
+	}
+	if len(e.pages) == 0 {
+		return nil, errors.New("no pages found, is this a Notion Markdown export?")
+	}
+	sort.Strings(e.pages)
+	return e, nil
+}
+
+// Len returns the number of pages in the export
+func (e *NotionExport) Len() int {
+	return len(e.pages)
+}
+
+// Each reads the pages in order and calls fn with each as it is read, so
+// only one page and its attachments are held at a time. A file linked from
+// several pages is attached to the first of them.
+func (e *NotionExport) Each(fn func(Note)) error {
+	attached := map[string]bool{}
+	for _, name := range e.pages {
+		content, err := e.read(e.files[name])
+		if err != nil {
+			return err
+		}
+		if content == nil {
+			continue
+		}
+
+		n := Note{Title: pageName(strings.TrimSuffix(path.Base(name), ".md")), Notebook: notebookOf(name)}
+		text := strings.ReplaceAll(string(content), "\r\n", "\n")
+		// Pages start with their title as a heading
+		if first, rest, _ := strings.Cut(text, "\n"); strings.TrimSpace(first) == "# "+n.Title {
+			text = rest
+		}
+		n.Content = strings.TrimSpace(text)
+
+		for _, m := range localLink.FindAllStringSubmatch(n.Content, -1) {
+			target, err := url.PathUnescape(m[1])
+			if err != nil || strings.Contains(target, ":") {
+				continue
+			}
+			target = path.Join(path.Dir(name), target)
+			f, ok := e.files[target]
+			if !ok || attached[target] || path.Ext(target) == ".md" || path.Ext(target) == ".csv" {
+				continue
+			}
+			attached[target] = true
+			data, err := e.read(f)
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				n.Files = append(n.Files, File{Name: path.Base(target), Data: data})
+			}
+		}
+		fn(n)
+	}
+	return nil
+}
+
+// read reads a file of the export, or returns nil if it is larger than
+// MaxEntrySize. It fails once the export has unpacked to more than
+// MaxUnpackedSize.
+func (e *NotionExport) read(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", f.Name, err)
+	}
+	defer func() { _ = rc.Close() }()
+
+	limit := min(MaxEntrySize, MaxUnpackedSize-e.unpacked) + 1
+	data, err := io.ReadAll(io.LimitReader(rc, limit))
+	e.unpacked += int64(len(data))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", f.Name, err)
+	}
+	if e.unpacked > MaxUnpackedSize {
+		return nil, fmt.Errorf("export unpacks to more than %d MB", MaxUnpackedSize>>20)
+	}
+	if len(data) > MaxEntrySize {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// pageName strips Notion's page ID from the name of a page or its folder
+func pageName(name string) string {
+	return notionID.ReplaceAllString(name, "")
+}
+
+// notebookOf names the notebook of the page at name after the pages it is
+// nested under, such as "Work / Projects"
+func notebookOf(name string) string {
+	dir := path.Dir(name)
+	if dir == "." {
+		return ""
+	}
+	parts := strings.Split(dir, "/")
+	for i, p := range parts {
+		parts[i] = pageName(p)
+	}
+	return strings.Join(parts, " / ")
+}
@@ -0,0 +1,94 @@
+package imports
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readNotion opens a Notion export and reads all its pages
+func readNotion(data []byte) ([]Note, error) {
+	e, err := OpenNotion(data)
+	if err != nil {
+		return nil, err
+	}
+	var notes []Note
+	err = e.Each(func(n Note) { notes = append(notes, n) })
+	return notes, err
+}
+
+// zipOf builds a ZIP archive of files by name
+func zipOf(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestParseNotion(t *testing.T) {
+	data := zipOf(t, map[string]string{
+		"Work 0123456789abcdef0123456789abcdef.md": "# Work\r\n\r\nSee [Roadmap](Work%200123456789abcdef0123456789abcdef/Roadmap%20fedcba9876543210fedcba9876543210.md).\r\n",
+		"Work 0123456789abcdef0123456789abcdef/Roadmap fedcba9876543210fedcba9876543210.md": "# Roadmap\n\n" +
+			"![diagram](Roadmap%20fedcba9876543210fedcba9876543210/diagram.png)\n" +
+			"[spec](Roadmap%20fedcba9876543210fedcba9876543210/spec.pdf) [again](Roadmap%20fedcba9876543210fedcba9876543210/spec.pdf)\n" +
+			"[site](https://example.com) [missing](gone.txt) [table](Tasks.csv)\n",
+		"Work 0123456789abcdef0123456789abcdef/Spec fedcba9876543210fedcba9876543211.md":             "[spec](Roadmap%20fedcba9876543210fedcba9876543210/spec.pdf)",
+		"Work 0123456789abcdef0123456789abcdef/Roadmap fedcba9876543210fedcba9876543210/diagram.png": "png",
+		"Work 0123456789abcdef0123456789abcdef/Roadmap fedcba9876543210fedcba9876543210/spec.pdf":    "pdf",
+		"Work 0123456789abcdef0123456789abcdef/Tasks.csv":                                            "a,b",
+		"__MACOSX/Work 0123456789abcdef0123456789abcdef.md":                                          "junk",
+	})
+
+	e, err := OpenNotion(data)
+	require.NoError(t, err)
+	assert.Equal(t, 3, e.Len())
+	notes, err := readNotion(data)
+	require.NoError(t, err)
+	require.Len(t, notes, 3)
+
+	assert.Equal(t, Note{
+		Title:   "Work",
+		Content: "See [Roadmap](Work%200123456789abcdef0123456789abcdef/Roadmap%20fedcba9876543210fedcba9876543210.md).",
+	}, notes[0])
+
+	assert.Equal(t, "Roadmap", notes[1].Title)
+	assert.Equal(t, "Work", notes[1].Notebook)
+	assert.NotContains(t, notes[1].Content, "# Roadmap")
+	assert.Equal(t, []File{{Name: "diagram.png", Data: []byte("png")}, {Name: "spec.pdf", Data: []byte("pdf")}}, notes[1].Files)
+	assert.Empty(t, notes[2].Files, "a file is attached to the first page linking it")
+}
+
+func TestParseNotionRejectsOtherFiles(t *testing.T) {
+	_, err := OpenNotion([]byte("not a zip"))
+	assert.Error(t, err)
+
+	_, err = OpenNotion(zipOf(t, map[string]string{"Tasks.csv": "a,b"}))
+	assert.EqualError(t, err, "no pages found, is this a Notion Markdown export?")
+}
+
+func TestNotionExportLimits(t *testing.T) {
+	files := make(map[string]string, MaxEntries+1)
+	for i := range MaxEntries + 1 {
+		files[fmt.Sprintf("Page %d.md", i)] = ""
+	}
+	_, err := OpenNotion(zipOf(t, files))
+	assert.EqualError(t, err, "export holds more than 10000 files")
+
+	e, err := OpenNotion(zipOf(t, map[string]string{"A.md": "first", "B.md": "second"}))
+	require.NoError(t, err)
+	e.unpacked = MaxUnpackedSize - int64(len("first"))
+	var read []string
+	err = e.Each(func(n Note) { read = append(read, n.Title) })
+	assert.EqualError(t, err, "export unpacks to more than 500 MB")
+	assert.Equal(t, []string{"A"}, read, "pages read before the limit are kept")
+}
@@ -0,0 +1,232 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"quanta/pkg/client"
+
+	"github.com/fasthttp/websocket"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signUp registers a fresh account and returns its email and token
+func signUp(t *testing.T) (string, string) {
+	t.Helper()
+	email := "user-" + uuid.NewString()[:8] + "@example.com"
+	body, _ := json.Marshal(map[string]string{"email": email, "password": "correct horse"})
+
+	resp, err := http.Post(baseURL+"/signup", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var session struct {
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&session))
+	return email, session.Token
+}
+
+// dial opens a realtime connection to a note and reads past the roster and
+// history sent on join
+func dial(t *testing.T, token, noteID string) *websocket.Conn {
+	t.Helper()
+	var ticket struct {
+		Ticket string `json:"ticket"`
+	}
+	req, _ := http.NewRequest(http.MethodPost, baseURL+"/ws/ticket", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&ticket))
+	_ = resp.Body.Close()
+
+	u := "ws" + strings.TrimPrefix(baseURL, "http") + "/ws/notes/" + noteID + "?" + url.Values{"ticket": {ticket.Ticket}}.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(u, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	for _, want := range []string{"presence:list", "history"} {
+		msg := readMessage(t, conn)
+		require.Equal(t, want, msg["type"])
+	}
+	return conn
+}
+
+// readMessage reads the next realtime message, failing after a few seconds
+func readMessage(t *testing.T, conn *websocket.Conn) map[string]any {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, raw, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	var msg map[string]any
+	require.NoError(t, json.Unmarshal(raw, &msg))
+	return msg
+}
+
+// readEdits reads messages until n edits have arrived, skipping presence
+// updates from other connections joining
+func readEdits(t *testing.T, conn *websocket.Conn, n int) []map[string]any {
+	t.Helper()
+	var edits []map[string]any
+	for len(edits) < n {
+		if msg := readMessage(t, conn); msg["type"] == "edit" {
+			edits = append(edits, msg)
+		}
+	}
+	return edits
+}
+
+func TestSignupLoginAndNoteCRUD(t *testing.T) {
+	ctx := context.Background()
+	email, _ := signUp(t)
+
+	token, err := client.New(baseURL, "").Login(ctx, email, "correct horse")
+	require.NoError(t, err)
+	c := client.New(baseURL, token)
+
+	id, err := c.CreateNote(ctx, "Groceries", "Milk")
+	require.NoError(t, err)
+
+	notes, err := c.ListNotes(ctx, client.ListOptions{})
+	require.NoError(t, err)
+	if assert.Len(t, notes, 1) {
+		assert.Equal(t, id, notes[0].ID)
+		assert.Equal(t, int64(1), notes[0].Version)
+	}
+
+	require.NoError(t, c.UpdateNote(ctx, id, "Groceries", "Milk, eggs"))
+	note, err := c.GetNote(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "Milk, eggs", note.Content)
+	assert.Equal(t, int64(2), note.Version)
+
+	require.NoError(t, c.DeleteNote(ctx, id))
+	_, err = c.GetNote(ctx, id)
+	var apiErr *client.Error
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusNotFound, apiErr.Status)
+	}
+}
+
+func TestLogin_WrongPassword(t *testing.T) {
+	email, _ := signUp(t)
+
+	_, err := client.New(baseURL, "").Login(context.Background(), email, "wrong password")
+	var apiErr *client.Error
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusUnauthorized, apiErr.Status)
+	}
+}
+
+func TestNotesArePrivate(t *testing.T) {
+	ctx := context.Background()
+	_, ownerToken := signUp(t)
+	_, otherToken := signUp(t)
+
+	id, err := client.New(baseURL, ownerToken).CreateNote(ctx, "Diary", "Secret")
+	require.NoError(t, err)
+
+	other := client.New(baseURL, otherToken)
+	_, err = other.GetNote(ctx, id)
+	var apiErr *client.Error
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusNotFound, apiErr.Status)
+	}
+	err = other.UpdateNote(ctx, id, "Diary", "Overwritten")
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusNotFound, apiErr.Status)
+	}
+}
+
+func TestRealtimeEditReachesOtherConnections(t *testing.T) {
+	_, token := signUp(t)
+	id, err := client.New(baseURL, token).CreateNote(context.Background(), "Shared", "")
+	require.NoError(t, err)
+
+	alice := dial(t, token, id)
+	bob := dial(t, token, id)
+
+	require.NoError(t, alice.WriteJSON(map[string]string{"type": "edit", "content": "Hello"}))
+	edit := readEdits(t, bob, 1)[0]
+	assert.Equal(t, "Hello", edit["content"])
+	assert.Equal(t, float64(1), edit["rev"])
+}
+
+func TestConcurrentRealtimeEdits(t *testing.T) {
+	const writers, editsEach = 5, 20
+
+	_, token := signUp(t)
+	id, err := client.New(baseURL, token).CreateNote(context.Background(), "Busy", "")
+	require.NoError(t, err)
+
+	conns := make([]*websocket.Conn, writers)
+	for i := range conns {
+		conns[i] = dial(t, token, id)
+	}
+	observer := dial(t, token, id)
+
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range editsEach {
+				if err := conn.WriteJSON(map[string]string{"type": "edit", "content": fmt.Sprintf("writer %d edit %d", i, j)}); err != nil {
+					t.Errorf("writer %d: %v", i, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every edit gets its own revision, and everyone sees them in order
+	edits := readEdits(t, observer, writers*editsEach)
+	for i, edit := range edits {
+		assert.Equal(t, float64(i+1), edit["rev"])
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	const updates = 20
+	ctx := context.Background()
+
+	_, token := signUp(t)
+	c := client.New(baseURL, token)
+	id, err := c.CreateNote(ctx, "Counter", "0")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := range updates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.UpdateNote(ctx, id, "Counter", fmt.Sprint(i+1)); err != nil {
+				t.Errorf("update %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The version is bumped in SQL, so no update is lost even though the
+	// last writer wins the content
+	note, err := c.GetNote(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(updates+1), note.Version)
+}
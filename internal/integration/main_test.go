@@ -0,0 +1,183 @@
+//go:build integration
+
+// Package integration runs end-to-end tests against a real MySQL server.
+// By default TestMain starts one in Docker with dockertest; set
+// QUANTA_TEST_MYSQL_DSN to use an existing server instead, as in CI jobs
+// that provide MySQL as a service. Run with:
+//
+//	go test -tags integration ./internal/integration/...
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"quanta/internal/activity"
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/db"
+	"quanta/internal/handlers/auth"
+	"quanta/internal/handlers/notes"
+	"quanta/internal/middleware"
+	"quanta/internal/models"
+	"quanta/internal/notifications"
+	"quanta/internal/quota"
+	"quanta/internal/realtime"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+)
+
+var jwtKeys = pkg.SingleJWTKey("integration-secret")
+
+var (
+	// conn is the database every test shares
+	conn *sql.DB
+	// baseURL is where the app under test listens
+	baseURL string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+// run sets up the database and app around the tests, so deferred cleanup
+// happens before TestMain exits
+func run(m *testing.M) int {
+	dsn := os.Getenv("QUANTA_TEST_MYSQL_DSN")
+	if dsn == "" {
+		var purge func()
+		var err error
+		dsn, purge, err = startMySQL()
+		if err != nil {
+			log.Printf("starting MySQL: %v", err)
+			return 1
+		}
+		defer purge()
+	}
+
+	ctx := context.Background()
+	var err error
+	conn, err = db.Connect(ctx, db.Options{
+		Driver: db.DriverMySQL,
+		DSN:    dsn,
+		Pool:   db.PoolConfig{MaxOpenConns: 20, MaxIdleConns: 20, ConnMaxLifetime: time.Minute},
+	})
+	if err != nil {
+		log.Printf("connecting to MySQL: %v", err)
+		return 1
+	}
+	defer func() { _ = conn.Close() }()
+	if err := db.ApplySchema(ctx, conn, db.MySQL); err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Printf("listening: %v", err)
+		return 1
+	}
+	app := newApp(conn)
+	go func() {
+		if err := app.Listener(ln); err != nil {
+			log.Printf("serving: %v", err)
+		}
+	}()
+	defer func() { _ = app.Shutdown() }()
+	baseURL = "http://" + ln.Addr().String()
+
+	return m.Run()
+}
+
+// startMySQL runs a throwaway MySQL container and waits until it accepts
+// connections, returning its DSN and a function that removes it
+func startMySQL() (string, func(), error) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return "", nil, fmt.Errorf("connecting to Docker: %w", err)
+	}
+	pool.MaxWait = 2 * time.Minute
+
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "mysql",
+		Tag:        "8.0",
+		Env:        []string{"MYSQL_ROOT_PASSWORD=secret", "MYSQL_DATABASE=quanta"},
+	}, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("starting container: %w", err)
+	}
+	purge := func() {
+		if err := pool.Purge(resource); err != nil {
+			log.Printf("removing MySQL container: %v", err)
+		}
+	}
+	// Never leave the container behind for long if the tests hang
+	_ = resource.Expire(600)
+
+	dsn := fmt.Sprintf("root:secret@tcp(localhost:%s)/quanta", resource.GetPort("3306/tcp"))
+	if err := pool.Retry(func() error {
+		probe, err := sql.Open("mysql", dsn)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = probe.Close() }()
+		return probe.Ping()
+	}); err != nil {
+		purge()
+		return "", nil, fmt.Errorf("waiting for MySQL: %w", err)
+	}
+	return dsn, purge, nil
+}
+
+// newApp wires the routes under test the way cmd/main.go does
+func newApp(conn *sql.DB) *fiber.App {
+	limits := models.NoteLimits{MaxTitleLength: models.MaxTitleColumn, MaxContentBytes: 1 << 20}
+
+	authHandler := auth.NewHandler(conn, &auth.JWTService{}, jwtKeys, audit.NewLogger(conn), notifications.LogMailer{}, auth.EmailConfig{}, auth.SSOConfig{})
+	realtimeHandler := realtime.NewHandler(conn, realtime.Options{
+		Heartbeat: realtime.HeartbeatConfig{
+			PingInterval:   30 * time.Second,
+			MaxMissedPongs: 2,
+		},
+		QueryTimeout: 5 * time.Second,
+		Limits:       limits,
+		HistorySize:  100,
+	})
+	notesHandler := notes.NewHandler(conn, activity.NewRecorder(conn, realtimeHandler), realtimeHandler, nil, limits, quota.Limits{
+		UserBytes:      100 << 20,
+		WorkspaceBytes: 1 << 30,
+	}, nil, nil, db.MySQL)
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(middleware.Timeout(5 * time.Second))
+
+	requireAuth := middleware.Protected(conn, jwtKeys)
+	app.Post("/signup", authHandler.SignUp)
+	app.Post("/login", authHandler.Login)
+
+	note := app.Group("/notes", middleware.ProtectedOrAPIKey(conn, jwtKeys, middleware.NotesScope))
+	note.Get("/", notesHandler.GetNotes)
+	note.Post("/", notesHandler.CreateNote)
+	note.Get("/:id", notesHandler.GetNote)
+	note.Put("/:id", notesHandler.UpdateNote)
+	note.Delete("/:id", notesHandler.DeleteNote)
+
+	tickets := middleware.NewTicketStore(30 * time.Second)
+	ws := app.Group("/ws")
+	ws.Post("/ticket", requireAuth, tickets.IssueTicket)
+	ws.Get("/notes/:id", middleware.WebSocketAuth(conn, tickets, jwtKeys), realtimeHandler.HandleWebSocket)
+
+	return app
+}
@@ -0,0 +1,104 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MaxMessageLength is the longest message maintenance can be announced with
+const MaxMessageLength = 255
+
+// Update is the request body for UpdateMode
+type Update struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message"`
+	EndsAt  *time.Time `json:"ends_at"`
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Handler handles HTTP requests related to maintenance mode
+type Handler struct {
+	db    DBInterface
+	mode  *Mode
+	audit AuditLogger
+}
+
+// NewHandler creates a new Handler that applies changes to mode and
+// records them in the audit log
+func NewHandler(db DBInterface, mode *Mode, auditLog AuditLogger) *Handler {
+	return &Handler{db: db, mode: mode, audit: auditLog}
+}
+
+// GetMode returns whether the server is in maintenance mode, so clients
+// can show the notice and switch to read-only
+func (h *Handler) GetMode(c *fiber.Ctx) error {
+	return c.JSON(h.mode.Current())
+}
+
+// UpdateMode turns maintenance mode on or off. The change applies at once
+// on this instance and announces itself to everyone connected to it;
+// other instances follow on their next reload.
+func (h *Handler) UpdateMode(c *fiber.Ctx) error {
+	var payload Update
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	fields := map[string]string{}
+	if utf8.RuneCountInString(payload.Message) > MaxMessageLength {
+		fields["message"] = fmt.Sprintf("must be at most %d characters", MaxMessageLength)
+	}
+	if payload.EndsAt != nil && !payload.EndsAt.After(time.Now()) {
+		fields["ends_at"] = "must be in the future"
+	}
+	if len(fields) > 0 {
+		return apperr.Invalid(fields)
+	}
+
+	state := State{Enabled: payload.Enabled, Message: payload.Message}
+	if payload.EndsAt != nil {
+		endsAt := payload.EndsAt.UTC()
+		state.EndsAt = &endsAt
+	}
+
+	ctx := c.UserContext()
+	res, err := h.db.ExecContext(ctx,
+		"UPDATE maintenance SET enabled = ?, message = ?, ends_at = ?, updated_at = ? WHERE id = 1",
+		state.Enabled, state.Message, state.EndsAt, time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("updating maintenance mode: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("updating maintenance mode: %w", err)
+	} else if n == 0 {
+		_, err = h.db.ExecContext(ctx,
+			"INSERT INTO maintenance (id, enabled, message, ends_at) VALUES (1, ?, ?, ?)",
+			state.Enabled, state.Message, state.EndsAt,
+		)
+		if err != nil && !db.IsDuplicate(err) {
+			return fmt.Errorf("creating maintenance mode: %w", err)
+		}
+	}
+	h.mode.set(state)
+
+	userID, _ := auth.UserIDFromCtx(c)
+	entry := audit.FromRequest(c, audit.EventMaintenanceUpdated, userID)
+	entry.Details = map[string]string{"enabled": strconv.FormatBool(state.Enabled)}
+	h.audit.Log(ctx, entry)
+
+	return c.JSON(state)
+}
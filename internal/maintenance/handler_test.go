@@ -0,0 +1,105 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+func TestUpdateMode(t *testing.T) {
+	update := regexp.QuoteMeta("UPDATE maintenance SET enabled = ?, message = ?, ends_at = ?, updated_at = ? WHERE id = 1")
+	insert := regexp.QuoteMeta("INSERT INTO maintenance (id, enabled, message, ends_at) VALUES (1, ?, ?, ?)")
+	endsAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	testCases := []struct {
+		name           string
+		body           string
+		existing       bool
+		expectedStatus int
+		expectedErrors map[string]string
+	}{
+		{name: "first time", body: `{"enabled":true,"message":"Upgrading","ends_at":"` + endsAt.Format(time.RFC3339) + `"}`, expectedStatus: fiber.StatusOK},
+		{name: "again", body: `{"enabled":true,"message":"Upgrading","ends_at":"` + endsAt.Format(time.RFC3339) + `"}`, existing: true, expectedStatus: fiber.StatusOK},
+		{
+			name:           "invalid",
+			body:           `{"enabled":true,"message":"` + strings.Repeat("x", 256) + `","ends_at":"2020-01-01T00:00:00Z"}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{
+				"message": "must be at most 255 characters",
+				"ends_at": "must be in the future",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mockDB, err := sqlmock.New()
+			require.NoError(t, err)
+			broadcaster := &fakeBroadcaster{}
+			auditLog := &fakeAudit{}
+			mode := New(db, broadcaster)
+			handler := NewHandler(db, mode, auditLog)
+			app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+			app.Put("/admin/maintenance", handler.UpdateMode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				rows := int64(0)
+				if tc.existing {
+					rows = 1
+				}
+				mockDB.ExpectExec(update).
+					WithArgs(true, "Upgrading", &endsAt, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, rows))
+				if !tc.existing {
+					mockDB.ExpectExec(insert).WithArgs(true, "Upgrading", &endsAt).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+			}
+
+			req := httptest.NewRequest("PUT", "/admin/maintenance", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedErrors != nil {
+				var body apperr.Response
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tc.expectedErrors, body.Errors)
+				assert.False(t, mode.Current().Enabled)
+				assert.Empty(t, auditLog.entries)
+			} else {
+				expected := State{Enabled: true, Message: "Upgrading", EndsAt: &endsAt}
+				var state State
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+				assert.Equal(t, expected, state)
+				assert.Equal(t, expected, mode.Current())
+				assert.Len(t, broadcaster.messages, 1)
+				if assert.Len(t, auditLog.entries, 1) {
+					assert.Equal(t, audit.EventMaintenanceUpdated, auditLog.entries[0].Event)
+				}
+			}
+			assert.NoError(t, mockDB.ExpectationsWereMet())
+		})
+	}
+}
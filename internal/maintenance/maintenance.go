@@ -0,0 +1,202 @@
+// Package maintenance provides a maintenance mode that makes the server
+// read-only, so migrations can run without writes racing them. While it is
+// on, writes are refused with 503 and a Retry-After header, reads are still
+// served without their side writes, and no new WebSocket connections are
+// accepted. Edits sent over sockets that are already open are refused too,
+// and the background workers pause until it ends. The mode lives in the
+// database and is reloaded periodically so every instance follows it.
+package maintenance
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/realtime"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+const (
+	// DefaultRefresh is how often the mode is reloaded from the database
+	DefaultRefresh = 10 * time.Second
+	// DefaultRetryAfter is the Retry-After given when maintenance has no
+	// expected end
+	DefaultRetryAfter = time.Minute
+)
+
+// readOnlyKey is the Locals key Handler marks the requests it lets through
+// during maintenance with
+const readOnlyKey = "maintenance-read-only"
+
+// MessageTypeMaintenance is the realtime message sent to every note room
+// when maintenance starts, changes or ends
+const MessageTypeMaintenance realtime.MessageType = "maintenance"
+
+// State is whether the server is in maintenance mode
+type State struct {
+	Enabled bool `json:"enabled"`
+	// Message is shown to users while maintenance is on
+	Message string `json:"message"`
+	// EndsAt is when maintenance is expected to finish, if known
+	EndsAt *time.Time `json:"ends_at"`
+}
+
+// equal reports whether two states would look the same to users
+func (s State) equal(other State) bool {
+	if !s.Enabled && !other.Enabled {
+		return true
+	}
+	sameEnd := s.EndsAt == nil && other.EndsAt == nil ||
+		s.EndsAt != nil && other.EndsAt != nil && s.EndsAt.Equal(*other.EndsAt)
+	return s.Enabled == other.Enabled && s.Message == other.Message && sameEnd
+}
+
+// retryAfter returns how many seconds clients should wait before retrying
+// a write
+func (s State) retryAfter(now time.Time) int {
+	if s.EndsAt != nil && s.EndsAt.After(now) {
+		return int(math.Ceil(s.EndsAt.Sub(now).Seconds()))
+	}
+	return int(DefaultRetryAfter.Seconds())
+}
+
+// Notice tells connected clients about a change to maintenance mode
+type Notice struct {
+	Type realtime.MessageType `json:"type"`
+	V    int                  `json:"v"`
+	State
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Broadcaster sends a message to everyone connected to the realtime server
+type Broadcaster interface {
+	Broadcast(message any)
+}
+
+// Mode holds the maintenance state last loaded by Reload
+type Mode struct {
+	db          DBInterface
+	broadcaster Broadcaster
+	state       atomic.Pointer[State]
+}
+
+// New creates a maintenance mode that is off until Reload or StartReloader
+// finds it on. Changes are announced through broadcaster.
+func New(db DBInterface, broadcaster Broadcaster) *Mode {
+	m := &Mode{db: db, broadcaster: broadcaster}
+	m.state.Store(&State{})
+	return m
+}
+
+// Reload replaces the state with the one in the database. On error the
+// previous state stays in force.
+func (m *Mode) Reload(ctx context.Context) error {
+	var state State
+	var endsAt sql.NullTime
+	err := m.db.QueryRowContext(ctx,
+		"SELECT enabled, message, ends_at FROM maintenance WHERE id = 1",
+	).Scan(&state.Enabled, &state.Message, &endsAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("fetching maintenance mode: %w", err)
+	}
+	if endsAt.Valid {
+		state.EndsAt = &endsAt.Time
+	}
+	m.set(state)
+	return nil
+}
+
+// StartReloader reloads the state every interval until stop is closed.
+// It blocks, so run it in its own goroutine.
+func (m *Mode) StartReloader(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := m.Reload(ctx); err != nil {
+				log.Println("Error reloading maintenance mode:", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Current returns the maintenance state
+func (m *Mode) Current() State {
+	return *m.state.Load()
+}
+
+// ReadOnly reports whether maintenance is on, so nothing may be written.
+// Background workers and the realtime server ask it before writing.
+func (m *Mode) ReadOnly() bool {
+	return m.Current().Enabled
+}
+
+// set replaces the state, telling connected clients if it changed
+func (m *Mode) set(state State) {
+	prev := m.state.Swap(&state)
+	if prev.equal(state) {
+		return
+	}
+	if state.Enabled {
+		log.Println("Maintenance mode on")
+	} else {
+		log.Println("Maintenance mode off")
+	}
+	if m.broadcaster != nil {
+		m.broadcaster.Broadcast(Notice{Type: MessageTypeMaintenance, V: realtime.ProtocolVersion, State: state})
+	}
+}
+
+// Handler returns a middleware that, while maintenance is on, refuses
+// WebSocket upgrades and any request other than GET, HEAD or OPTIONS with
+// 503. Requests for the exempt paths are let through, so administrators
+// can still log in and turn maintenance off. The reads it lets through are
+// marked so handlers can skip their side writes; see ReadOnly.
+func (m *Mode) Handler(exempt ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		state := m.Current()
+		if !state.Enabled {
+			return c.Next()
+		}
+		if !websocket.IsWebSocketUpgrade(c) {
+			switch c.Method() {
+			case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+				c.Locals(readOnlyKey, true)
+				return c.Next()
+			}
+			if slices.Contains(exempt, c.Path()) {
+				return c.Next()
+			}
+		}
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(state.retryAfter(time.Now())))
+		return apperr.New(fiber.StatusServiceUnavailable, "Down for maintenance, try again later")
+	}
+}
+
+// ReadOnly reports whether Handler served the request during maintenance,
+// in which case reads must not record views, receipts or anything else
+func ReadOnly(c *fiber.Ctx) bool {
+	readOnly, _ := c.Locals(readOnlyKey).(bool)
+	return readOnly
+}
@@ -0,0 +1,127 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	stateQuery = regexp.QuoteMeta("SELECT enabled, message, ends_at FROM maintenance WHERE id = 1")
+	stateCols  = []string{"enabled", "message", "ends_at"}
+)
+
+// fakeBroadcaster records the messages broadcast
+type fakeBroadcaster struct {
+	messages []any
+}
+
+func (f *fakeBroadcaster) Broadcast(message any) {
+	f.messages = append(f.messages, message)
+}
+
+func TestReload(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	broadcaster := &fakeBroadcaster{}
+	m := New(db, broadcaster)
+	endsAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Without a row maintenance is off, which is no change
+	mockDB.ExpectQuery(stateQuery).WillReturnRows(sqlmock.NewRows(stateCols))
+	require.NoError(t, m.Reload(context.Background()))
+	assert.Equal(t, State{}, m.Current())
+	assert.Empty(t, broadcaster.messages)
+
+	// Turning it on is announced once
+	for range 2 {
+		mockDB.ExpectQuery(stateQuery).WillReturnRows(sqlmock.NewRows(stateCols).AddRow(true, "Upgrading", endsAt))
+		require.NoError(t, m.Reload(context.Background()))
+	}
+	expected := State{Enabled: true, Message: "Upgrading", EndsAt: &endsAt}
+	assert.Equal(t, expected, m.Current())
+	assert.Equal(t, []any{Notice{Type: MessageTypeMaintenance, V: 1, State: expected}}, broadcaster.messages)
+
+	// Errors leave it on
+	mockDB.ExpectQuery(stateQuery).WillReturnError(errors.New("connection refused"))
+	assert.Error(t, m.Reload(context.Background()))
+	assert.True(t, m.Current().Enabled)
+
+	mockDB.ExpectQuery(stateQuery).WillReturnRows(sqlmock.NewRows(stateCols).AddRow(false, "", nil))
+	require.NoError(t, m.Reload(context.Background()))
+	assert.False(t, m.Current().Enabled)
+	assert.Len(t, broadcaster.messages, 2)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestHandler(t *testing.T) {
+	m := New(nil, nil)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(m.Handler("/admin/maintenance"))
+	ok := func(c *fiber.Ctx) error { return c.SendString(strconv.FormatBool(ReadOnly(c))) }
+	app.Get("/notes", ok)
+	app.Post("/notes", ok)
+	app.Put("/admin/maintenance", ok)
+	app.Get("/ws/notes/1", ok)
+
+	testCases := []struct {
+		name           string
+		method, path   string
+		upgrade        bool
+		enabled        bool
+		expectedStatus int
+		readOnly       bool
+	}{
+		{name: "write while off", method: "POST", path: "/notes", expectedStatus: fiber.StatusOK},
+		{name: "upgrade while off", method: "GET", path: "/ws/notes/1", upgrade: true, expectedStatus: fiber.StatusOK},
+		{name: "read", method: "GET", path: "/notes", enabled: true, expectedStatus: fiber.StatusOK, readOnly: true},
+		{name: "write", method: "POST", path: "/notes", enabled: true, expectedStatus: fiber.StatusServiceUnavailable},
+		{name: "exempt", method: "PUT", path: "/admin/maintenance", enabled: true, expectedStatus: fiber.StatusOK},
+		{name: "upgrade", method: "GET", path: "/ws/notes/1", upgrade: true, enabled: true, expectedStatus: fiber.StatusServiceUnavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			endsAt := time.Now().Add(90 * time.Second)
+			m.state.Store(&State{Enabled: tc.enabled, EndsAt: &endsAt})
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.upgrade {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, strconv.FormatBool(tc.readOnly), string(body))
+			}
+			if tc.expectedStatus == fiber.StatusServiceUnavailable {
+				retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+				require.NoError(t, err)
+				assert.InDelta(t, 90, retryAfter, 2)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	assert.Equal(t, 60, State{Enabled: true}.retryAfter(now))
+	assert.Equal(t, 60, State{Enabled: true, EndsAt: &past}.retryAfter(now))
+}
@@ -0,0 +1,194 @@
+package merge
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Kinds of line and word in a diff
+const (
+	OpEqual  = "equal"
+	OpDelete = "delete"
+	OpInsert = "insert"
+)
+
+// DefaultContext is how many unchanged lines surround each hunk's changes
+const DefaultContext = 3
+
+// Line is one line of a hunk, without its line ending
+type Line struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Segment is a run of words, with the whitespace between them, that was
+// kept, deleted or inserted
+type Segment struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Hunk is a changed region of a document with the unchanged lines around
+// it. Starts count from 1, as in a unified diff; a side with no lines
+// starts at the line before the hunk. Words diffs the hunk's old and new
+// text word by word, for highlighting changes within lines.
+type Hunk struct {
+	OldStart int       `json:"old_start"`
+	OldLines int       `json:"old_lines"`
+	NewStart int       `json:"new_start"`
+	NewLines int       `json:"new_lines"`
+	Lines    []Line    `json:"lines"`
+	Words    []Segment `json:"words"`
+}
+
+// Diff compares two documents line by line, returning the hunks that turn
+// a into b with up to context unchanged lines around each change. Equal
+// documents have no hunks.
+func Diff(a, b string, context int) []Hunk {
+	ops := script(lines(a), lines(b))
+
+	var hunks []Hunk
+	for start := 0; start < len(ops); {
+		// Find the next change, then extend the hunk until the unchanged
+		// run after a change is too long to bridge
+		first := start
+		for first < len(ops) && ops[first].Op == OpEqual {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].Op != OpEqual {
+				last = i
+			} else if i-last > 2*context {
+				break
+			}
+		}
+
+		from, to := max(first-context, 0), min(last+context+1, len(ops))
+		hunks = append(hunks, hunk(ops, from, to))
+		start = to
+	}
+	return hunks
+}
+
+// Unified renders hunks as a unified diff between documents labelled from
+// and to
+func Unified(from, to string, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", from, to)
+	for _, h := range hunks {
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", span(h.OldStart, h.OldLines), span(h.NewStart, h.NewLines))
+		for _, l := range h.Lines {
+			switch l.Op {
+			case OpDelete:
+				out.WriteByte('-')
+			case OpInsert:
+				out.WriteByte('+')
+			default:
+				out.WriteByte(' ')
+			}
+			out.WriteString(l.Text)
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
+
+// DiffWords compares two texts word by word. Runs of whitespace count as
+// words, so joining the kept and inserted segments restores b.
+func DiffWords(a, b string) []Segment {
+	ops := script(words.FindAllString(a, -1), words.FindAllString(b, -1))
+
+	var segments []Segment
+	for _, op := range ops {
+		if n := len(segments); n > 0 && segments[n-1].Op == op.Op {
+			segments[n-1].Text += op.Text
+			continue
+		}
+		segments = append(segments, Segment(op))
+	}
+	return segments
+}
+
+var words = regexp.MustCompile(`\s+|\S+`)
+
+// edit is one step of an edit script: a line or word of a that is kept or
+// deleted, or one of b that is inserted
+type edit struct {
+	Op   string
+	Text string
+}
+
+// script returns the edits that turn a into b along a longest common
+// subsequence, deletions before insertions within each changed region
+func script(a, b []string) []edit {
+	m := match(a, b)
+
+	var ops []edit
+	j := 0
+	for i, partner := range m {
+		if partner < 0 {
+			ops = append(ops, edit{OpDelete, a[i]})
+			continue
+		}
+		for ; j < partner; j++ {
+			ops = append(ops, edit{OpInsert, b[j]})
+		}
+		ops = append(ops, edit{OpEqual, a[i]})
+		j++
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, edit{OpInsert, b[j]})
+	}
+	return ops
+}
+
+// hunk builds the hunk covering ops[from:to]
+func hunk(ops []edit, from, to int) Hunk {
+	var h Hunk
+	for _, op := range ops[:from] {
+		if op.Op != OpInsert {
+			h.OldStart++
+		}
+		if op.Op != OpDelete {
+			h.NewStart++
+		}
+	}
+
+	var oldText, newText strings.Builder
+	for _, op := range ops[from:to] {
+		h.Lines = append(h.Lines, Line{Op: op.Op, Text: strings.TrimSuffix(op.Text, "\n")})
+		if op.Op != OpInsert {
+			h.OldLines++
+			oldText.WriteString(op.Text)
+		}
+		if op.Op != OpDelete {
+			h.NewLines++
+			newText.WriteString(op.Text)
+		}
+	}
+	if h.OldLines > 0 {
+		h.OldStart++
+	}
+	if h.NewLines > 0 {
+		h.NewStart++
+	}
+	h.Words = DiffWords(oldText.String(), newText.String())
+	return h
+}
+
+// span formats a hunk range, leaving out the length when it is one line
+func span(start, n int) string {
+	if n == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, n)
+}
@@ -0,0 +1,89 @@
+package merge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+
+	testCases := []struct {
+		name     string
+		a, b     string
+		expected string
+	}{
+		{
+			name: "Equal",
+			a:    a,
+			b:    a,
+		},
+		{
+			name: "Changed Line",
+			a:    a,
+			b:    strings.Replace(a, "five", "FIVE", 1),
+			expected: "--- v1\n+++ v2\n" +
+				"@@ -2,7 +2,7 @@\n two\n three\n four\n-five\n+FIVE\n six\n seven\n eight\n",
+		},
+		{
+			name: "Separate Hunks",
+			a:    a,
+			b:    strings.Replace(strings.Replace(a, "one\n", "", 1), "ten\n", "ten\neleven\n", 1),
+			expected: "--- v1\n+++ v2\n" +
+				"@@ -1,4 +1,3 @@\n-one\n two\n three\n four\n" +
+				"@@ -8,3 +7,4 @@\n eight\n nine\n ten\n+eleven\n",
+		},
+		{
+			name:     "From Empty",
+			a:        "",
+			b:        "hello\n",
+			expected: "--- v1\n+++ v2\n@@ -0,0 +1 @@\n+hello\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Unified("v1", "v2", Diff(tc.a, tc.b, DefaultContext)))
+		})
+	}
+}
+
+func TestDiff_Hunks(t *testing.T) {
+	hunks := Diff("keep\nthe quick fox\nkeep\n", "keep\nthe slow fox\nkeep\n", 1)
+
+	if assert.Len(t, hunks, 1) {
+		h := hunks[0]
+		assert.Equal(t, []int{1, 3, 1, 3}, []int{h.OldStart, h.OldLines, h.NewStart, h.NewLines})
+		assert.Equal(t, []Line{
+			{OpEqual, "keep"},
+			{OpDelete, "the quick fox"},
+			{OpInsert, "the slow fox"},
+			{OpEqual, "keep"},
+		}, h.Lines)
+		assert.Equal(t, []Segment{
+			{OpEqual, "keep\nthe "},
+			{OpDelete, "quick"},
+			{OpInsert, "slow"},
+			{OpEqual, " fox\nkeep\n"},
+		}, h.Words)
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	segments := DiffWords("a b c", "a c d")
+
+	var kept, restored strings.Builder
+	for _, s := range segments {
+		if s.Op != OpInsert {
+			kept.WriteString(s.Text)
+		}
+		if s.Op != OpDelete {
+			restored.WriteString(s.Text)
+		}
+	}
+	assert.Equal(t, "a b c", kept.String())
+	assert.Equal(t, "a c d", restored.String())
+	assert.Nil(t, DiffWords("", ""))
+}
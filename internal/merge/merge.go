@@ -0,0 +1,179 @@
+// Package merge performs line-based three-way merges (diff3) of text
+// documents, and the two-way diffs that show what changed between versions
+package merge
+
+import (
+	"slices"
+	"strings"
+)
+
+// MaxCells bounds the work matching two documents' lines. Documents whose
+// changed regions are larger than this compare as entirely different, which
+// makes concurrent edits to them conflict rather than tie up the server.
+const MaxCells = 1 << 22
+
+// Conflict markers written around conflicting regions of a merged document
+const (
+	MarkerOurs   = "<<<<<<< ours\n"
+	MarkerBase   = "||||||| base\n"
+	MarkerSep    = "=======\n"
+	MarkerTheirs = ">>>>>>> theirs\n"
+)
+
+// Conflict is a region both sides changed in different ways. Line is where
+// the region starts in the merged document, counting from 1.
+type Conflict struct {
+	Line   int      `json:"line"`
+	Base   []string `json:"base"`
+	Ours   []string `json:"ours"`
+	Theirs []string `json:"theirs"`
+}
+
+// Result is the outcome of a merge. When there are conflicts, Text holds
+// both sides of each conflicting region between conflict markers.
+type Result struct {
+	Text      string
+	Conflicts []Conflict
+}
+
+// Merge combines the changes ours and theirs each made to base. Regions only
+// one side changed take that side's version, as do regions both changed the
+// same way; any other region is a conflict.
+func Merge(base, ours, theirs string) Result {
+	b, o, t := lines(base), lines(ours), lines(theirs)
+	matchOurs, matchTheirs := match(b, o), match(b, t)
+
+	var res Result
+	var out strings.Builder
+	line := 1
+	emit := func(ls []string) {
+		for _, l := range ls {
+			out.WriteString(l)
+		}
+		line += len(ls)
+	}
+
+	i, j, k := 0, 0, 0
+	for {
+		// The next stable line is a base line both sides kept
+		next := i
+		for next < len(b) && (matchOurs[next] < 0 || matchTheirs[next] < 0) {
+			next++
+		}
+		ni, nj, nk := len(b), len(o), len(t)
+		if next < len(b) {
+			ni, nj, nk = next, matchOurs[next], matchTheirs[next]
+		}
+
+		cb, co, ct := b[i:ni], o[j:nj], t[k:nk]
+		switch {
+		case slices.Equal(co, cb):
+			emit(ct)
+		case slices.Equal(ct, cb), slices.Equal(co, ct):
+			emit(co)
+		default:
+			res.Conflicts = append(res.Conflicts, Conflict{Line: line, Base: trim(cb), Ours: trim(co), Theirs: trim(ct)})
+			out.WriteString(MarkerOurs)
+			writeSide(&out, co)
+			out.WriteString(MarkerBase)
+			writeSide(&out, cb)
+			out.WriteString(MarkerSep)
+			writeSide(&out, ct)
+			out.WriteString(MarkerTheirs)
+			line += len(co) + len(cb) + len(ct) + 4
+		}
+
+		if next == len(b) {
+			break
+		}
+		emit(b[ni : ni+1])
+		i, j, k = ni+1, nj+1, nk+1
+	}
+
+	res.Text = out.String()
+	return res
+}
+
+// lines splits s after each newline, so joining the lines restores s
+func lines(s string) []string {
+	ls := strings.SplitAfter(s, "\n")
+	if ls[len(ls)-1] == "" {
+		ls = ls[:len(ls)-1]
+	}
+	return ls
+}
+
+// writeSide writes one side of a conflict, ending it with a newline so the
+// marker after it starts on its own line
+func writeSide(out *strings.Builder, ls []string) {
+	for _, l := range ls {
+		out.WriteString(l)
+	}
+	if n := len(ls); n > 0 && !strings.HasSuffix(ls[n-1], "\n") {
+		out.WriteString("\n")
+	}
+}
+
+// trim drops the line endings for reporting
+func trim(ls []string) []string {
+	out := make([]string, len(ls))
+	for i, l := range ls {
+		out[i] = strings.TrimSuffix(l, "\n")
+	}
+	return out
+}
+
+// match pairs the lines of a with those of b along a longest common
+// subsequence, returning for each line of a the index of its partner in b,
+// or -1 if it has none
+func match(a, b []string) []int {
+	m := make([]int, len(a))
+	for i := range m {
+		m[i] = -1
+	}
+
+	// Common leading and trailing lines always match, leaving the table to
+	// cover only the changed middle
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		m[pre] = pre
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		m[len(a)-1-suf] = len(b) - 1 - suf
+		suf++
+	}
+
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(ma) == 0 || len(mb) == 0 || len(ma)*len(mb) > MaxCells {
+		return m
+	}
+
+	// lcs[x][y] is the length of the longest common subsequence of ma[x:]
+	// and mb[y:]
+	w := len(mb) + 1
+	lcs := make([]int32, (len(ma)+1)*w)
+	for x := len(ma) - 1; x >= 0; x-- {
+		for y := len(mb) - 1; y >= 0; y-- {
+			if ma[x] == mb[y] {
+				lcs[x*w+y] = lcs[(x+1)*w+y+1] + 1
+			} else {
+				lcs[x*w+y] = max(lcs[(x+1)*w+y], lcs[x*w+y+1])
+			}
+		}
+	}
+	for x, y := 0, 0; x < len(ma) && y < len(mb); {
+		switch {
+		case ma[x] == mb[y]:
+			m[pre+x] = pre + y
+			x++
+			y++
+		case lcs[(x+1)*w+y] >= lcs[x*w+y+1]:
+			x++
+		default:
+			y++
+		}
+	}
+	return m
+}
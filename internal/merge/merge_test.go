@@ -0,0 +1,116 @@
+package merge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	base := "one\ntwo\nthree\nfour\nfive\n"
+
+	testCases := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		expected  string
+		conflicts []Conflict
+	}{
+		{
+			name:     "Unchanged",
+			base:     base,
+			ours:     base,
+			theirs:   base,
+			expected: base,
+		},
+		{
+			name:     "Only Theirs Changed",
+			base:     base,
+			ours:     base,
+			theirs:   "one\n2\nthree\nfour\nfive\n",
+			expected: "one\n2\nthree\nfour\nfive\n",
+		},
+		{
+			name:     "Separate Regions",
+			base:     base,
+			ours:     "zero\none\ntwo\nthree\nfour\nfive\n",
+			theirs:   "one\ntwo\nthree\n4\nfive\nsix\n",
+			expected: "zero\none\ntwo\nthree\n4\nfive\nsix\n",
+		},
+		{
+			name:     "Same Change On Both Sides",
+			base:     base,
+			ours:     "one\ntwo\n3\nfour\nfive\n",
+			theirs:   "one\ntwo\n3\nfour\nfive\n",
+			expected: "one\ntwo\n3\nfour\nfive\n",
+		},
+		{
+			name:     "Deletion And Edit Elsewhere",
+			base:     base,
+			ours:     "one\nthree\nfour\nfive\n",
+			theirs:   "one\ntwo\nthree\nfour\nFIVE\n",
+			expected: "one\nthree\nfour\nFIVE\n",
+		},
+		{
+			name:     "From Empty",
+			base:     "",
+			ours:     "",
+			theirs:   "hello\n",
+			expected: "hello\n",
+		},
+		{
+			name:     "Conflict",
+			base:     base,
+			ours:     "one\ntwo\nTHREE\nfour\nfive\n",
+			theirs:   "one\ntwo\n3\nfour\nfive\n",
+			expected: "one\ntwo\n" + MarkerOurs + "THREE\n" + MarkerBase + "three\n" + MarkerSep + "3\n" + MarkerTheirs + "four\nfive\n",
+			conflicts: []Conflict{
+				{Line: 3, Base: []string{"three"}, Ours: []string{"THREE"}, Theirs: []string{"3"}},
+			},
+		},
+		{
+			name:     "Conflict Without Trailing Newline",
+			base:     "a",
+			ours:     "b",
+			theirs:   "c",
+			expected: MarkerOurs + "b\n" + MarkerBase + "a\n" + MarkerSep + "c\n" + MarkerTheirs,
+			conflicts: []Conflict{
+				{Line: 1, Base: []string{"a"}, Ours: []string{"b"}, Theirs: []string{"c"}},
+			},
+		},
+		{
+			name:     "Two Conflicts",
+			base:     "a\nb\nc\n",
+			ours:     "A\nb\nC\n",
+			theirs:   "1\nb\n3\n",
+			expected: MarkerOurs + "A\n" + MarkerBase + "a\n" + MarkerSep + "1\n" + MarkerTheirs + "b\n" + MarkerOurs + "C\n" + MarkerBase + "c\n" + MarkerSep + "3\n" + MarkerTheirs,
+			conflicts: []Conflict{
+				{Line: 1, Base: []string{"a"}, Ours: []string{"A"}, Theirs: []string{"1"}},
+				{Line: 9, Base: []string{"c"}, Ours: []string{"C"}, Theirs: []string{"3"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res := Merge(tc.base, tc.ours, tc.theirs)
+			assert.Equal(t, tc.expected, res.Text)
+			assert.Equal(t, tc.conflicts, res.Conflicts)
+		})
+	}
+}
+
+func TestMerge_LargeDocuments(t *testing.T) {
+	// Too many changed lines to match compare as entirely different
+	var base, ours, theirs strings.Builder
+	for i := range 3000 {
+		base.WriteString("line\n")
+		ours.WriteString(strings.Repeat("o", i%7+1) + "\n")
+		theirs.WriteString(strings.Repeat("t", i%5+1) + "\n")
+	}
+
+	res := Merge(base.String(), ours.String(), theirs.String())
+	assert.Len(t, res.Conflicts, 1)
+}
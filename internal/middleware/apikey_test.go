@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProtectedOrAPIKey(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use("/notes", ProtectedOrAPIKey(db, testKeys, NotesScope))
+	ok := func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"user-id": c.Locals("user-id")})
+	}
+	app.Get("/notes", ok)
+	app.Post("/notes", ok)
+
+	const key = "qk_secret"
+	sum := sha256.Sum256([]byte(key))
+	keyQuery := regexp.QuoteMeta("SELECT k.id, k.user_id, u.role, k.scopes FROM api_keys k JOIN users u ON u.id = k.user_id WHERE k.key_hash = ?")
+	keyRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "role", "scopes"}).AddRow("key1", "user123", "user", "notes:read")
+	}
+
+	testCases := []struct {
+		name           string
+		method         string
+		header, value  string
+		setupMock      func()
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:   "Key In Header",
+			method: "GET",
+			header: APIKeyHeader, value: key,
+			setupMock: func() {
+				mockDB.ExpectQuery(keyQuery).WithArgs(hex.EncodeToString(sum[:]), sqlmock.AnyArg()).WillReturnRows(keyRows())
+			},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:   "Key As Bearer Token",
+			method: "GET",
+			header: "Authorization", value: "Bearer " + key,
+			setupMock: func() {
+				mockDB.ExpectQuery(keyQuery).WithArgs(hex.EncodeToString(sum[:]), sqlmock.AnyArg()).WillReturnRows(keyRows())
+			},
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:   "Missing Scope",
+			method: "POST",
+			header: APIKeyHeader, value: key,
+			setupMock: func() {
+				mockDB.ExpectQuery(keyQuery).WithArgs(hex.EncodeToString(sum[:]), sqlmock.AnyArg()).WillReturnRows(keyRows())
+			},
+			expectedStatus: fiber.StatusForbidden,
+			expectedError:  "API key lacks the notes:write scope",
+		},
+		{
+			name:   "Unknown Key",
+			method: "GET",
+			header: APIKeyHeader, value: "qk_revoked",
+			setupMock: func() {
+				mockDB.ExpectQuery(keyQuery).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Invalid API key",
+		},
+		{
+			name:   "JWT",
+			method: "POST",
+			header: "Authorization", value: "Bearer " + signToken(t, jwt.MapClaims{"user-id": "user123", "exp": time.Now().Add(time.Hour).Unix()}),
+			setupMock: func() {
+				mockDB.ExpectQuery(regexp.QuoteMeta("SELECT token_version, role FROM users WHERE id = ? AND deleted_at IS NULL")).
+					WithArgs("user123").
+					WillReturnRows(sqlmock.NewRows([]string{"token_version", "role"}).AddRow(0, "user"))
+			},
+			expectedStatus: fiber.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.setupMock()
+
+			req := httptest.NewRequest(tc.method, "/notes", nil)
+			req.Header.Set(tc.header, tc.value)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+		})
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
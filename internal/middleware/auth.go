@@ -3,54 +3,210 @@
 package middleware
 
 import (
-	"os"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
 	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/pkg"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// Protected returns a middleware that validates JWT tokens and injects user ID into the request context.
+var (
+	errInvalidClaims = errors.New("invalid token claims")
+	errTokenRevoked  = errors.New("token has been revoked")
+	errSessionEnded  = errors.New("session has ended")
+	errTokenLookup   = errors.New("token version lookup failed")
+)
+
+// SessionTouchInterval is how often a session's last_seen_at is updated
+// while its token is in use
+const SessionTouchInterval = time.Minute
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Protected returns a middleware that validates JWT tokens and injects the user ID and role into the request context.
+// Tokens whose token-version claim is older than the user's current token version
+// (bumped on password change) are rejected. The role is read from the database
+// alongside the token version, so role changes apply without a new token.
+// Tokens naming a session in their jti claim are rejected once the session
+// has been ended, and the session ID is injected as "session-id".
 // This middleware should be used on routes that require authentication.
-func Protected() fiber.Handler {
+func Protected(db DBInterface, keys *pkg.JWTKeys) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		var tokenString string
-
-		// Check if it's a WebSocket connection
-		if c.Get("Upgrade") == "websocket" {
-			tokenString = c.Query("token")
-		} else {
-			// Regular HTTP request
-			authHeader := c.Get("Authorization")
-			if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer") {
-				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing or invalid Authorization header"})
-			}
-			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+		authHeader := c.Get("Authorization")
+		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer") {
+			return apperr.New(fiber.StatusUnauthorized, "Missing or invalid Authorization header")
 		}
+		tokenString := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
 
 		if tokenString == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing token"})
+			return apperr.New(fiber.StatusUnauthorized, "Missing token")
 		}
 
-		secret := os.Getenv("JWT_SECRET")
-		tokenString = strings.TrimSpace(tokenString)
+		userID, role, sessionID, err := authenticate(c.UserContext(), db, keys, tokenString)
+		if err != nil {
+			return authError(err)
+		}
+
+		// Inject user ID, role and session into context
+		c.Locals(auth.UserIDKey, userID)
+		c.Locals("role", role)
+		c.Locals("session-id", sessionID)
+
+		return c.Next()
+	}
+}
 
-		token, err := jwt.Parse(tokenString, func(_ *jwt.Token) (any, error) {
-			return []byte(secret), nil
-		})
+// WebSocketAuth returns a middleware for WebSocket upgrade routes. Browsers cannot
+// set an Authorization header on WebSocket connections, so the handshake accepts
+// either a one-time `?ticket=` issued by the TicketStore or a raw `?token=` JWT.
+// The user ID is injected into the context before the connection is upgraded.
+func WebSocketAuth(db DBInterface, tickets *TicketStore, keys *pkg.JWTKeys) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return apperr.New(fiber.StatusUpgradeRequired, "WebSocket upgrade required")
+		}
 
-		if err != nil || !token.Valid {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired token"})
+		if ticket := c.Query("ticket"); ticket != "" {
+			userID, ok := tickets.Redeem(ticket)
+			if !ok {
+				return apperr.New(fiber.StatusUnauthorized, "Invalid or expired ticket")
+			}
+			c.Locals(auth.UserIDKey, userID)
+			return c.Next()
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || claims["user-id"] == nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid token claims"})
+		tokenString := strings.TrimSpace(c.Query("token"))
+		if tokenString == "" {
+			return apperr.New(fiber.StatusUnauthorized, "Missing token")
 		}
 
-		// Inject user ID into context
-		c.Locals("user-id", claims["user-id"])
+		userID, _, _, err := authenticate(c.UserContext(), db, keys, tokenString)
+		if err != nil {
+			return authError(err)
+		}
 
+		c.Locals(auth.UserIDKey, userID)
 		return c.Next()
 	}
 }
+
+// authError maps a failed authenticate call to the error to respond with
+func authError(err error) error {
+	switch {
+	case errors.Is(err, errInvalidClaims):
+		return apperr.New(fiber.StatusUnauthorized, "Invalid token claims")
+	case errors.Is(err, errTokenRevoked):
+		return apperr.New(fiber.StatusUnauthorized, "Token has been revoked")
+	case errors.Is(err, errSessionEnded):
+		return apperr.New(fiber.StatusUnauthorized, "Session has ended")
+	case errors.Is(err, sql.ErrNoRows):
+		return apperr.New(fiber.StatusUnauthorized, "User no longer exists")
+	case errors.Is(err, errTokenLookup):
+		return err
+	default:
+		return apperr.New(fiber.StatusUnauthorized, "Invalid or expired token")
+	}
+}
+
+// Authenticate validates a bearer token outside of Fiber, for the gRPC API,
+// returning the user ID and role or the error Protected would answer with
+func Authenticate(ctx context.Context, db DBInterface, keys *pkg.JWTKeys, tokenString string) (string, string, error) {
+	userID, role, _, err := authenticate(ctx, db, keys, tokenString)
+	if err != nil {
+		return "", "", authError(err)
+	}
+	return userID, role, nil
+}
+
+// authenticate validates a token and checks that it hasn't been revoked by a
+// token version bump or the end of its session, returning the user ID it
+// was issued to, the user's current role and the session ID. Tokens issued
+// before sessions were tracked have none.
+func authenticate(ctx context.Context, db DBInterface, keys *pkg.JWTKeys, tokenString string) (string, string, string, error) {
+	claims, err := parseToken(keys, tokenString)
+	if err != nil {
+		return "", "", "", err
+	}
+	userID := claims["user-id"].(string)
+
+	var currentVersion int
+	var role string
+	err = db.QueryRowContext(ctx, "SELECT token_version, role FROM users WHERE id = ? AND deleted_at IS NULL", userID).Scan(&currentVersion, &role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", "", err
+		}
+		return "", "", "", fmt.Errorf("%w: %w", errTokenLookup, err)
+	}
+
+	// Tokens issued before versioning carry no claim and count as version 0
+	tokenVersion, _ := claims["token-version"].(float64)
+	if int(tokenVersion) != currentVersion {
+		return "", "", "", errTokenRevoked
+	}
+
+	sessionID, _ := claims["jti"].(string)
+	if sessionID != "" {
+		if err := touchSession(ctx, db, sessionID, userID); err != nil {
+			return "", "", "", err
+		}
+	}
+
+	return userID, role, sessionID, nil
+}
+
+// touchSession checks that a session hasn't been ended and records that it
+// was seen, at most once per SessionTouchInterval
+func touchSession(ctx context.Context, db DBInterface, sessionID string, userID string) error {
+	var lastSeen time.Time
+	err := db.QueryRowContext(ctx, "SELECT last_seen_at FROM sessions WHERE id = ? AND user_id = ?", sessionID, userID).Scan(&lastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errSessionEnded
+		}
+		return fmt.Errorf("%w: %w", errTokenLookup, err)
+	}
+
+	if time.Since(lastSeen) >= SessionTouchInterval {
+		if _, err := db.ExecContext(ctx, "UPDATE sessions SET last_seen_at = ? WHERE id = ?", time.Now().UTC(), sessionID); err != nil {
+			log.Printf("Error updating session %s: %v", sessionID, err)
+		}
+	}
+	return nil
+}
+
+// parseToken validates a JWT signed with one of keys and returns its
+// claims, which are guaranteed to carry a string user ID
+func parseToken(keys *pkg.JWTKeys, tokenString string) (jwt.MapClaims, error) {
+	token, err := keys.ParseJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errInvalidClaims
+	}
+	if id, _ := claims["user-id"].(string); id == "" {
+		return nil, errInvalidClaims
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/pkg"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// testKeys holds the test secret as the only signing key
+var testKeys = pkg.SingleJWTKey("test-secret")
+
+// signToken creates a token signed with the test secret
+func signToken(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("error signing token: %v", err)
+	}
+	return token
+}
+
+// signHS512 signs a token with the test secret but an algorithm the
+// middleware doesn't accept
+func signHS512(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatalf("error signing token: %v", err)
+	}
+	return token
+}
+
+func TestProtected(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Get("/protected", Protected(db, testKeys), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"user-id": c.Locals("user-id")})
+	})
+
+	exp := time.Now().Add(time.Hour).Unix()
+	versionQuery := regexp.QuoteMeta("SELECT token_version, role FROM users WHERE id = ? AND deleted_at IS NULL")
+
+	testCases := []struct {
+		name           string
+		header         string
+		currentVersion *int
+		mockError      error
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Current Token Version",
+			header:         "Bearer " + signToken(t, jwt.MapClaims{"user-id": "user123", "token-version": 2, "exp": exp}),
+			currentVersion: intPtr(2),
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Legacy Token Without Version",
+			header:         "Bearer " + signToken(t, jwt.MapClaims{"user-id": "user123", "exp": exp}),
+			currentVersion: intPtr(0),
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Revoked Token",
+			header:         "Bearer " + signToken(t, jwt.MapClaims{"user-id": "user123", "token-version": 1, "exp": exp}),
+			currentVersion: intPtr(2),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Token has been revoked",
+		},
+		{
+			name:           "Deleted User",
+			header:         "Bearer " + signToken(t, jwt.MapClaims{"user-id": "user123", "exp": exp}),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "User no longer exists",
+		},
+		{
+			name:           "Database Error",
+			header:         "Bearer " + signToken(t, jwt.MapClaims{"user-id": "user123", "exp": exp}),
+			mockError:      errors.New("database error"),
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+		{
+			name:           "Expired Token",
+			header:         "Bearer " + signToken(t, jwt.MapClaims{"user-id": "user123", "exp": time.Now().Add(-time.Hour).Unix()}),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Invalid or expired token",
+		},
+		{
+			name:           "Other Signing Algorithm",
+			header:         "Bearer " + signHS512(t, jwt.MapClaims{"user-id": "user123", "exp": exp}),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Invalid or expired token",
+		},
+		{
+			name:           "Numeric User ID",
+			header:         "Bearer " + signToken(t, jwt.MapClaims{"user-id": 123, "exp": exp}),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Invalid token claims",
+		},
+		{
+			name:           "Missing Header",
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Missing or invalid Authorization header",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			switch {
+			case tc.currentVersion != nil:
+				mockDB.ExpectQuery(versionQuery).WithArgs("user123").
+					WillReturnRows(sqlmock.NewRows([]string{"token_version", "role"}).AddRow(*tc.currentVersion, "user"))
+			case tc.mockError != nil:
+				mockDB.ExpectQuery(versionQuery).WithArgs("user123").WillReturnError(tc.mockError)
+			case tc.name == "Deleted User":
+				mockDB.ExpectQuery(versionQuery).WithArgs("user123").
+					WillReturnRows(sqlmock.NewRows([]string{"token_version", "role"}))
+			}
+
+			req := httptest.NewRequest("GET", "/protected", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedError != "" {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedError, response.Message)
+			}
+		})
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestProtectedSession(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Get("/protected", Protected(db, testKeys), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"session-id": c.Locals("session-id")})
+	})
+
+	token := signToken(t, jwt.MapClaims{"user-id": "user123", "jti": "session1", "exp": time.Now().Add(time.Hour).Unix()})
+	versionQuery := regexp.QuoteMeta("SELECT token_version, role FROM users WHERE id = ? AND deleted_at IS NULL")
+	sessionQuery := regexp.QuoteMeta("SELECT last_seen_at FROM sessions WHERE id = ? AND user_id = ?")
+	touchUpdate := regexp.QuoteMeta("UPDATE sessions SET last_seen_at = ? WHERE id = ?")
+
+	testCases := []struct {
+		name           string
+		lastSeen       *time.Time
+		expectTouch    bool
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Stale Session Is Touched",
+			lastSeen:       timePtr(time.Now().Add(-time.Hour)),
+			expectTouch:    true,
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Recently Seen Session",
+			lastSeen:       timePtr(time.Now()),
+			expectedStatus: fiber.StatusOK,
+		},
+		{
+			name:           "Ended Session",
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Session has ended",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB.ExpectQuery(versionQuery).WithArgs("user123").
+				WillReturnRows(sqlmock.NewRows([]string{"token_version", "role"}).AddRow(0, "user"))
+			rows := sqlmock.NewRows([]string{"last_seen_at"})
+			if tc.lastSeen != nil {
+				rows.AddRow(*tc.lastSeen)
+			}
+			mockDB.ExpectQuery(sessionQuery).WithArgs("session1", "user123").WillReturnRows(rows)
+			if tc.expectTouch {
+				mockDB.ExpectExec(touchUpdate).WithArgs(sqlmock.AnyArg(), "session1").WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			req := httptest.NewRequest("GET", "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			var response map[string]any
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			if tc.expectedError != "" {
+				assert.Equal(t, tc.expectedError, response["message"])
+			} else {
+				assert.Equal(t, "session1", response["session-id"])
+			}
+		})
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// DefaultTraceBodyBytes is how much of each body HTTPTrace logs unless
+	// configured
+	DefaultTraceBodyBytes = 4096
+	// redacted replaces the values of credentials in traced requests
+	redacted = "[REDACTED]"
+)
+
+// sensitiveHeaders are never logged by HTTPTrace
+var sensitiveHeaders = []string{fiber.HeaderAuthorization, fiber.HeaderCookie, fiber.HeaderSetCookie, APIKeyHeader}
+
+// HTTPTraceConfig controls which requests HTTPTrace logs
+type HTTPTraceConfig struct {
+	// SampleRatio is the fraction of requests logged, from 0 to 1
+	SampleRatio float64
+	// UserID limits tracing to the requests of one authenticated user.
+	// Empty traces everyone's.
+	UserID string
+	// MaxBodyBytes truncates logged bodies. Zero means
+	// DefaultTraceBodyBytes.
+	MaxBodyBytes int
+	// Logger receives the traces. Nil means the standard logger.
+	Logger *log.Logger
+}
+
+// HTTPTrace returns a middleware that logs requests and their responses,
+// bodies included, to diagnose client integrations. Passwords, tokens,
+// keys and secrets are redacted from headers, query strings and JSON
+// bodies; other bodies are only described by their type and size. Paths
+// are logged as the route they matched, such as /invites/:token, since
+// path parameters can be credentials too. It has
+// to run before the route's authentication so that UserID can be matched
+// once the request has been handled.
+func HTTPTrace(cfg HTTPTraceConfig) fiber.Handler {
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = DefaultTraceBodyBytes
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		userID, _ := auth.UserIDFromCtx(c)
+		if cfg.UserID != "" && userID != cfg.UserID {
+			return err
+		}
+		if rand.Float64() >= cfg.SampleRatio {
+			return err
+		}
+
+		entry := traceEntry{
+			RequestID:      c.GetRespHeader(fiber.HeaderXRequestID),
+			Method:         c.Method(),
+			Path:           c.Route().Path,
+			Query:          redactQuery(string(c.Request().URI().QueryString())),
+			UserID:         userID,
+			Status:         c.Response().StatusCode(),
+			DurationMS:     time.Since(start).Milliseconds(),
+			RequestHeaders: redactHeaders(c.GetReqHeaders()),
+			RequestBody:    traceBody(c.Get(fiber.HeaderContentType), c.Body(), cfg.MaxBodyBytes),
+		}
+		if err != nil {
+			// The error handler writes the response after this returns
+			entry.Status = apperr.Status(err)
+			entry.Error = err.Error()
+		} else {
+			entry.ResponseBody = traceBody(c.GetRespHeader(fiber.HeaderContentType), c.Response().Body(), cfg.MaxBodyBytes)
+		}
+
+		encoded, merr := json.Marshal(entry)
+		if merr != nil {
+			cfg.Logger.Printf("Error encoding HTTP trace: %v", merr)
+			return err
+		}
+		cfg.Logger.Printf("http trace: %s", encoded)
+		return err
+	}
+}
+
+// traceEntry is one logged request
+type traceEntry struct {
+	RequestID      string              `json:"request_id,omitempty"`
+	Method         string              `json:"method"`
+	Path           string              `json:"path"`
+	Query          string              `json:"query,omitempty"`
+	UserID         string              `json:"user_id,omitempty"`
+	Status         int                 `json:"status"`
+	DurationMS     int64               `json:"duration_ms"`
+	RequestHeaders map[string][]string `json:"request_headers"`
+	RequestBody    string              `json:"request_body,omitempty"`
+	ResponseBody   string              `json:"response_body,omitempty"`
+	Error          string              `json:"error,omitempty"`
+}
+
+// isSensitive reports whether a field, header or query parameter named
+// name holds a credential
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range []string{"password", "token", "secret", "ticket", "authorization"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	// OAuth sends back an authorization code and the state binding it to
+	// the login that asked for it
+	if name == "code" || name == "state" {
+		return true
+	}
+	// Public keys are published on purpose; other keys are credentials
+	return (name == "key" || strings.HasSuffix(name, "_key") || strings.HasSuffix(name, "-key")) && name != "public_key"
+}
+
+// redactHeaders redacts the credentials in headers
+func redactHeaders(headers map[string][]string) map[string][]string {
+	for name := range headers {
+		if isSensitive(name) || slices.ContainsFunc(sensitiveHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+			headers[name] = []string{redacted}
+		}
+	}
+	return headers
+}
+
+// redactQuery redacts the values of sensitive query parameters
+func redactQuery(query string) string {
+	if query == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return redacted
+	}
+	for name := range values {
+		if isSensitive(name) {
+			values[name] = []string{redacted}
+		}
+	}
+	return values.Encode()
+}
+
+// traceBody renders a body for the log. JSON bodies are redacted and
+// truncated to limit bytes; anything else could hold credentials or
+// binary data that can't be redacted, so only its type and size are shown.
+func traceBody(contentType string, body []byte, limit int) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if !strings.HasPrefix(contentType, fiber.MIMEApplicationJSON) {
+		return "<" + strconv.Itoa(len(body)) + " bytes of " + contentTypeOrUnknown(contentType) + ">"
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "<" + strconv.Itoa(len(body)) + " bytes of invalid JSON>"
+	}
+	encoded, err := json.Marshal(redactJSON(v))
+	if err != nil {
+		return "<" + strconv.Itoa(len(body)) + " bytes of JSON>"
+	}
+	if len(encoded) > limit {
+		return string(encoded[:limit]) + "...(truncated)"
+	}
+	return string(encoded)
+}
+
+// redactJSON replaces the values of sensitive fields anywhere in v
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for name, field := range v {
+			if isSensitive(name) {
+				v[name] = redacted
+			} else {
+				v[name] = redactJSON(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactJSON(item)
+		}
+	}
+	return v
+}
+
+func contentTypeOrUnknown(contentType string) string {
+	if contentType == "" {
+		return "unknown type"
+	}
+	contentType, _, _ = strings.Cut(contentType, ";")
+	return contentType
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTraceApp serves routes behind HTTPTrace, authenticating as the user
+// named in the X-User header, and returns the traces it logs
+func newTraceApp(cfg HTTPTraceConfig) (*fiber.App, *bytes.Buffer) {
+	var out bytes.Buffer
+	cfg.Logger = log.New(&out, "", 0)
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(HTTPTrace(cfg))
+	app.Use(func(c *fiber.Ctx) error {
+		if user := c.Get("X-User"); user != "" {
+			c.Locals("user-id", user)
+		}
+		return c.Next()
+	})
+	app.Post("/login", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"token": "eyJhbGciOi.secret.jwt", "user": fiber.Map{"id": "user123"}})
+	})
+	app.Post("/missing", func(c *fiber.Ctx) error {
+		return apperr.New(fiber.StatusNotFound, "Note not found")
+	})
+	app.Post("/invites/:token/accept", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	app.Post("/upload", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	return app, &out
+}
+
+// traces decodes the logged traces
+func traces(t *testing.T, out *bytes.Buffer) []traceEntry {
+	var entries []traceEntry
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry traceEntry
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "http trace: ")), &entry); err != nil {
+			t.Fatalf("error decoding trace %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func doTrace(t *testing.T, app *fiber.App, path, contentType, body string, headers map[string]string) {
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if _, err := app.Test(req); err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+}
+
+func TestHTTPTrace_Redacts(t *testing.T) {
+	app, out := newTraceApp(HTTPTraceConfig{SampleRatio: 1})
+
+	doTrace(t, app, "/login?ticket=abc&page=2", "application/json",
+		`{"email":"a@example.com","password":"hunter22","device":{"name":"cli","api_key":"k"},"public_key":"pk"}`,
+		map[string]string{"Authorization": "Bearer abc", "X-API-Key": "qk_123", "User-Agent": "cli/1.0"})
+
+	entries := traces(t, out)
+	if !assert.Len(t, entries, 1) {
+		return
+	}
+	entry := entries[0]
+	assert.Equal(t, fiber.StatusOK, entry.Status)
+	assert.Equal(t, "page=2&ticket=%5BREDACTED%5D", entry.Query)
+	assert.Equal(t, []string{redacted}, entry.RequestHeaders["Authorization"])
+	assert.Equal(t, []string{redacted}, entry.RequestHeaders["X-Api-Key"])
+	assert.Equal(t, []string{"cli/1.0"}, entry.RequestHeaders["User-Agent"])
+	assert.JSONEq(t, `{"email":"a@example.com","password":"[REDACTED]","device":{"name":"cli","api_key":"[REDACTED]"},"public_key":"pk"}`, entry.RequestBody)
+	assert.JSONEq(t, `{"token":"[REDACTED]","user":{"id":"user123"}}`, entry.ResponseBody)
+	assert.NotContains(t, out.String(), "hunter22")
+	assert.NotContains(t, out.String(), "eyJhbGciOi")
+}
+
+func TestHTTPTrace_RedactsPathsAndOAuth(t *testing.T) {
+	app, out := newTraceApp(HTTPTraceConfig{SampleRatio: 1})
+
+	doTrace(t, app, "/invites/inv_s3cr3t/accept?code=the-code&state=the-state", "application/json", `{}`, nil)
+
+	entries := traces(t, out)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "/invites/:token/accept", entries[0].Path)
+		assert.Equal(t, "code=%5BREDACTED%5D&state=%5BREDACTED%5D", entries[0].Query)
+	}
+	assert.NotContains(t, out.String(), "inv_s3cr3t")
+	assert.NotContains(t, out.String(), "the-code")
+}
+
+func TestHTTPTrace_ErrorsAndOpaqueBodies(t *testing.T) {
+	app, out := newTraceApp(HTTPTraceConfig{SampleRatio: 1})
+
+	doTrace(t, app, "/missing", "application/json", `{"id":"note1"}`, nil)
+	doTrace(t, app, "/upload", "application/x-www-form-urlencoded; charset=utf-8", "password=hunter22", nil)
+
+	entries := traces(t, out)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, fiber.StatusNotFound, entries[0].Status)
+		assert.Equal(t, "Note not found", entries[0].Error)
+		assert.Equal(t, "<17 bytes of application/x-www-form-urlencoded>", entries[1].RequestBody)
+	}
+	assert.NotContains(t, out.String(), "hunter22")
+}
+
+func TestHTTPTrace_Selection(t *testing.T) {
+	app, out := newTraceApp(HTTPTraceConfig{SampleRatio: 1, UserID: "user123"})
+	doTrace(t, app, "/login", "application/json", `{}`, map[string]string{"X-User": "user456"})
+	doTrace(t, app, "/login", "application/json", `{}`, nil)
+	doTrace(t, app, "/login", "application/json", `{}`, map[string]string{"X-User": "user123"})
+	if entries := traces(t, out); assert.Len(t, entries, 1) {
+		assert.Equal(t, "user123", entries[0].UserID)
+	}
+
+	app, out = newTraceApp(HTTPTraceConfig{SampleRatio: 0})
+	doTrace(t, app, "/login", "application/json", `{}`, nil)
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultDenylistRefresh is how often the denylist is reloaded from the
+// database unless configured
+const DefaultDenylistRefresh = time.Minute
+
+// ParseIPPrefix parses a CIDR range such as 10.0.0.0/8, or a single address,
+// which matches only itself
+func ParseIPPrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// clientAddr returns the address the request came from, with IPv4-mapped
+// IPv6 addresses unwrapped so they match IPv4 ranges. Behind a reverse
+// proxy this is the address the app's trusted proxies report.
+func clientAddr(c *fiber.Ctx) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(c.IP())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// containsAddr reports whether any of prefixes contains addr
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPAllowlist returns a middleware that refuses requests from outside the
+// given ranges with 403. With no ranges every address is allowed, so it can
+// guard a route group unconditionally.
+func IPAllowlist(prefixes []netip.Prefix) fiber.Handler {
+	if len(prefixes) == 0 {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+
+	return func(c *fiber.Ctx) error {
+		if addr, ok := clientAddr(c); !ok || !containsAddr(prefixes, addr) {
+			return apperr.New(fiber.StatusForbidden, "Access from your network is not allowed")
+		}
+		return c.Next()
+	}
+}
+
+// DenylistDB is the database access IPDenylist needs
+type DenylistDB interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// IPDenylist refuses requests from blocked address ranges: those fixed in
+// the configuration and those in the ip_denylist table, which is reloaded
+// periodically so ranges can be blocked without a restart
+type IPDenylist struct {
+	db     DenylistDB
+	static []netip.Prefix
+	// blocked holds the static ranges followed by those last loaded from
+	// the database
+	blocked atomic.Pointer[[]netip.Prefix]
+}
+
+// NewIPDenylist creates a denylist of the static ranges. The ip_denylist
+// table is only consulted once Reload or StartReloader runs; db may be nil
+// to use the static ranges alone.
+func NewIPDenylist(db DenylistDB, static []netip.Prefix) *IPDenylist {
+	d := &IPDenylist{db: db, static: static}
+	d.blocked.Store(&static)
+	return d
+}
+
+// Reload replaces the ranges loaded from the ip_denylist table. Rows that
+// don't parse are logged and skipped. On error the previous ranges stay in
+// force.
+func (d *IPDenylist) Reload(ctx context.Context) error {
+	if d.db == nil {
+		return nil
+	}
+
+	rows, err := d.db.QueryContext(ctx, "SELECT cidr FROM ip_denylist")
+	if err != nil {
+		return fmt.Errorf("fetching IP denylist: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	blocked := append([]netip.Prefix(nil), d.static...)
+	for rows.Next() {
+		var cidr string
+		if err := rows.Scan(&cidr); err != nil {
+			return fmt.Errorf("scanning IP denylist: %w", err)
+		}
+		prefix, err := ParseIPPrefix(cidr)
+		if err != nil {
+			log.Printf("Skipping invalid IP denylist entry %q: %v", cidr, err)
+			continue
+		}
+		blocked = append(blocked, prefix)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching IP denylist: %w", err)
+	}
+
+	d.blocked.Store(&blocked)
+	return nil
+}
+
+// StartReloader reloads the denylist every interval until stop is closed.
+// It blocks, so run it in its own goroutine.
+func (d *IPDenylist) StartReloader(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := d.Reload(ctx); err != nil {
+				log.Println("Error reloading IP denylist:", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Blocked reports whether addr is in a denied range
+func (d *IPDenylist) Blocked(addr netip.Addr) bool {
+	return containsAddr(*d.blocked.Load(), addr.Unmap())
+}
+
+// Handler returns a middleware that refuses requests from denied ranges
+// with 403
+func (d *IPDenylist) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if addr, ok := clientAddr(c); ok && d.Blocked(addr) {
+			return apperr.New(fiber.StatusForbidden, "Access from your network is not allowed")
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"net/netip"
+	"regexp"
+	"testing"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// requestFrom sends a request to app that appears to come from ip
+func requestFrom(t *testing.T, app *fiber.App, ip string) int {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Real-IP", ip)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	return resp.StatusCode
+}
+
+// newIPApp serves / behind handler, taking the client address from X-Real-IP
+func newIPApp(handler fiber.Handler) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler, ProxyHeader: "X-Real-IP"})
+	app.Get("/", handler, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	return app
+}
+
+func TestParseIPPrefix(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected string
+	}{
+		{in: "10.1.2.3/8", expected: "10.0.0.0/8"},
+		{in: " 192.168.1.7 ", expected: "192.168.1.7/32"},
+		{in: "::ffff:192.168.1.7", expected: "192.168.1.7/32"},
+		{in: "2001:db8::1", expected: "2001:db8::1/128"},
+	}
+	for _, tc := range testCases {
+		prefix, err := ParseIPPrefix(tc.in)
+		if assert.NoError(t, err, tc.in) {
+			assert.Equal(t, tc.expected, prefix.String())
+		}
+	}
+
+	for _, in := range []string{"", "10.0.0.0/33", "example.com"} {
+		_, err := ParseIPPrefix(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestIPAllowlist(t *testing.T) {
+	app := newIPApp(IPAllowlist([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}))
+
+	assert.Equal(t, fiber.StatusOK, requestFrom(t, app, "10.20.30.40"))
+	assert.Equal(t, fiber.StatusOK, requestFrom(t, app, "::ffff:10.0.0.1"))
+	assert.Equal(t, fiber.StatusOK, requestFrom(t, app, "2001:db8::5"))
+	assert.Equal(t, fiber.StatusForbidden, requestFrom(t, app, "192.168.1.1"))
+	assert.Equal(t, fiber.StatusForbidden, requestFrom(t, app, "not an address"))
+
+	open := newIPApp(IPAllowlist(nil))
+	assert.Equal(t, fiber.StatusOK, requestFrom(t, open, "192.168.1.1"))
+}
+
+func TestIPAllowlist_TrustedProxies(t *testing.T) {
+	// Test requests come from 0.0.0.0
+	behind := func(proxies ...string) *fiber.App {
+		app := fiber.New(fiber.Config{
+			ErrorHandler:            apperr.Handler,
+			EnableTrustedProxyCheck: true,
+			TrustedProxies:          proxies,
+			ProxyHeader:             "X-Real-IP",
+		})
+		app.Get("/", IPAllowlist([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}), func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusOK)
+		})
+		return app
+	}
+
+	assert.Equal(t, fiber.StatusOK, requestFrom(t, behind("0.0.0.0/32"), "10.20.30.40"))
+	assert.Equal(t, fiber.StatusForbidden, requestFrom(t, behind("192.168.0.0/16"), "10.20.30.40"))
+	assert.Equal(t, fiber.StatusForbidden, requestFrom(t, behind(), "10.20.30.40"))
+}
+
+func TestIPDenylist(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	query := regexp.QuoteMeta("SELECT cidr FROM ip_denylist")
+
+	denylist := NewIPDenylist(db, []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")})
+	app := newIPApp(denylist.Handler())
+
+	assert.Equal(t, fiber.StatusForbidden, requestFrom(t, app, "203.0.113.9"))
+	assert.Equal(t, fiber.StatusOK, requestFrom(t, app, "198.51.100.1"))
+
+	// Rows added to the table apply once reloaded, and bad rows are skipped
+	mockDB.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"cidr"}).AddRow("198.51.100.0/24").AddRow("garbage"))
+	assert.NoError(t, denylist.Reload(context.Background()))
+	assert.Equal(t, fiber.StatusForbidden, requestFrom(t, app, "198.51.100.1"))
+	assert.Equal(t, fiber.StatusForbidden, requestFrom(t, app, "203.0.113.9"))
+
+	// A failed reload keeps the ranges in force
+	mockDB.ExpectQuery(query).WillReturnError(errors.New("database error"))
+	assert.Error(t, denylist.Reload(context.Background()))
+	assert.Equal(t, fiber.StatusForbidden, requestFrom(t, app, "198.51.100.1"))
+
+	// Removed rows stop applying
+	mockDB.ExpectQuery(query).WillReturnRows(sqlmock.NewRows([]string{"cidr"}))
+	assert.NoError(t, denylist.Reload(context.Background()))
+	assert.Equal(t, fiber.StatusOK, requestFrom(t, app, "198.51.100.1"))
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// DefaultRateLimit is how many requests a user may make in each
+	// window unless configured
+	DefaultRateLimit = 600
+	// DefaultRateLimitWindow is how long a rate limit window lasts unless
+	// configured
+	DefaultRateLimitWindow = time.Minute
+)
+
+// Rate limit headers set on every limited response
+const (
+	RateLimitHeader     = "X-RateLimit-Limit"
+	RateRemainingHeader = "X-RateLimit-Remaining"
+	// RateResetHeader holds when the window ends, in seconds since the
+	// Unix epoch
+	RateResetHeader = "X-RateLimit-Reset"
+)
+
+// RateLimiter caps how many requests each user makes per fixed window.
+// Requests made with an API key count against the key's owner. Counts are
+// kept in memory, so each server instance limits on its own.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+	// sweepAt is when windows that have ended are next dropped
+	sweepAt time.Time
+}
+
+// rateWindow counts a user's requests in the window starting at start,
+// which falls on a whole second so the reset time in the headers is exact
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter creates a RateLimiter allowing limit requests per window
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		now:     time.Now,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Handler counts the request against the current user's limit and sets
+// the rate limit headers, refusing it with 429 and a Retry-After header
+// once the limit is spent. It must come after the middleware that
+// authenticates the user; anonymous requests pass unlimited.
+func (l *RateLimiter) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals(auth.UserIDKey).(string)
+		if !ok || userID == "" {
+			return c.Next()
+		}
+
+		allowed, remaining, reset := l.take(userID)
+		c.Set(RateLimitHeader, strconv.Itoa(l.limit))
+		c.Set(RateRemainingHeader, strconv.Itoa(remaining))
+		c.Set(RateResetHeader, strconv.FormatInt(reset.Unix(), 10))
+		if !allowed {
+			wait := int(reset.Sub(l.now()).Seconds() + 0.999)
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(max(wait, 1)))
+			return apperr.New(fiber.StatusTooManyRequests, "Too many requests, slow down")
+		}
+		return c.Next()
+	}
+}
+
+// Status returns a user's limit, the requests they have left in the
+// current window and when it ends, without counting a request
+func (l *RateLimiter) Status(userID string) (limit, remaining int, reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	w := l.windows[userID]
+	if w == nil || !now.Before(w.start.Add(l.window)) {
+		return l.limit, l.limit, now.Truncate(time.Second).Add(l.window)
+	}
+	return l.limit, max(l.limit-w.count, 0), w.start.Add(l.window)
+}
+
+// take counts a request by userID and reports whether it is within the
+// limit, with the requests left and when the window ends
+func (l *RateLimiter) take(userID string) (allowed bool, remaining int, reset time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if !now.Before(l.sweepAt) {
+		for id, w := range l.windows {
+			if !now.Before(w.start.Add(l.window)) {
+				delete(l.windows, id)
+			}
+		}
+		l.sweepAt = now.Add(l.window)
+	}
+
+	w := l.windows[userID]
+	if w == nil || !now.Before(w.start.Add(l.window)) {
+		w = &rateWindow{start: now.Truncate(time.Second)}
+		// Fiber may reuse the memory behind strings taken from a request
+		l.windows[strings.Clone(userID)] = w
+	}
+	reset = w.start.Add(l.window)
+	if w.count >= l.limit {
+		return false, 0, reset
+	}
+	w.count++
+	return true, l.limit - w.count, reset
+}
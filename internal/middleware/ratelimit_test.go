@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(2, time.Minute)
+	limiter.now = func() time.Time { return now }
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		if user := c.Get("X-User"); user != "" {
+			c.Locals(auth.UserIDKey, user)
+		}
+		return c.Next()
+	})
+	app.Get("/", limiter.Handler(), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	get := func(user string) (int, fiber.Map) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", user)
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode, fiber.Map{
+			"limit":       resp.Header.Get(RateLimitHeader),
+			"remaining":   resp.Header.Get(RateRemainingHeader),
+			"reset":       resp.Header.Get(RateResetHeader),
+			"retry_after": resp.Header.Get(fiber.HeaderRetryAfter),
+		}
+	}
+	reset := strconv.FormatInt(now.Add(time.Minute).Unix(), 10)
+
+	status, headers := get("user1")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, fiber.Map{"limit": "2", "remaining": "1", "reset": reset, "retry_after": ""}, headers)
+	status, _ = get("user1")
+	assert.Equal(t, fiber.StatusOK, status)
+
+	status, headers = get("user1")
+	assert.Equal(t, fiber.StatusTooManyRequests, status)
+	assert.Equal(t, "0", headers["remaining"])
+	assert.NotEmpty(t, headers["retry_after"])
+
+	// Users have limits of their own, and anonymous requests have none
+	status, _ = get("user2")
+	assert.Equal(t, fiber.StatusOK, status)
+	status, headers = get("")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Empty(t, headers["limit"])
+
+	limit, remaining, resetAt := limiter.Status("user1")
+	assert.Equal(t, 2, limit)
+	assert.Equal(t, 0, remaining)
+	assert.Equal(t, now.Add(time.Minute), resetAt)
+
+	// A new window starts once the last has ended
+	now = now.Add(time.Minute)
+	_, remaining, _ = limiter.Status("user1")
+	assert.Equal(t, 2, remaining)
+	status, headers = get("user1")
+	assert.Equal(t, fiber.StatusOK, status)
+	assert.Equal(t, "1", headers["remaining"])
+	assert.Len(t, limiter.windows, 1)
+}
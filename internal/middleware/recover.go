@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Recover turns a panic in a later handler into an error, so it is answered
+// with a 500 carrying the request ID and logged with its stack trace by the
+// error handler instead of taking down the connection
+func Recover() fiber.Handler {
+	return func(c *fiber.Ctx) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+			}
+		}()
+		return c.Next()
+	}
+}
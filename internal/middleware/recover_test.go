@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecover(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(requestid.New())
+	app.Use(Recover())
+	app.Get("/panic", func(c *fiber.Ctx) error {
+		_ = c.Locals("user-id").(string)
+		return nil
+	})
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/panic", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusInternalServerError, resp.StatusCode)
+
+	var body apperr.Response
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.Equal(t, "Internal server error", body.Message)
+	assert.NotEmpty(t, body.RequestID)
+	assert.Equal(t, resp.Header.Get(fiber.HeaderXRequestID), body.RequestID)
+
+	// The server keeps serving after a panic
+	resp, err = app.Test(httptest.NewRequest("GET", "/ok", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"slices"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireRole returns a middleware that only lets through users holding one
+// of the given roles. It must run after Protected, which loads the role.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, _ := c.Locals("role").(string)
+		if !slices.Contains(roles, role) {
+			return apperr.New(fiber.StatusForbidden, "Insufficient permissions")
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"quanta/internal/apperr"
+	"quanta/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	testCases := []struct {
+		name           string
+		role           any
+		expectedStatus int
+	}{
+		{name: "Admin", role: models.RoleAdmin, expectedStatus: fiber.StatusOK},
+		{name: "User", role: models.RoleUser, expectedStatus: fiber.StatusForbidden},
+		{name: "No Role", expectedStatus: fiber.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+			app.Use(func(c *fiber.Ctx) error {
+				if tc.role != nil {
+					c.Locals("role", tc.role)
+				}
+				return c.Next()
+			})
+			app.Get("/admin", RequireRole(models.RoleAdmin), func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/admin", nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	app := fiber.New()
+	app.Use(SecurityHeaders(SecurityConfig{HSTSMaxAge: 24 * time.Hour}))
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+	app.Get("/page", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'self'")
+		return c.SendString("page")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+	assert.Equal(t, "DENY", resp.Header.Get("X-Frame-Options"))
+	assert.Equal(t, DefaultContentSecurityPolicy, resp.Header.Get("Content-Security-Policy"))
+	assert.Equal(t, "max-age=86400; includeSubDomains", resp.Header.Get("Strict-Transport-Security"))
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/page", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "default-src 'self'", resp.Header.Get("Content-Security-Policy"))
+}
+
+func TestCORS(t *testing.T) {
+	testCases := []struct {
+		name           string
+		config         CORSConfig
+		origin         string
+		expectedOrigin string
+	}{
+		{
+			name:           "Allowed Origin",
+			config:         CORSConfig{AllowedOrigins: []string{"https://notes.example.com"}, AllowCredentials: true, MaxAge: time.Hour},
+			origin:         "https://notes.example.com",
+			expectedOrigin: "https://notes.example.com",
+		},
+		{
+			name:   "Other Origin",
+			config: CORSConfig{AllowedOrigins: []string{"https://notes.example.com"}},
+			origin: "https://evil.example.com",
+		},
+		{
+			name:   "Disabled",
+			origin: "https://notes.example.com",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(CORS(tc.config))
+			app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+			req := httptest.NewRequest("OPTIONS", "/", nil)
+			req.Header.Set("Origin", tc.origin)
+			req.Header.Set("Access-Control-Request-Method", "GET")
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedOrigin, resp.Header.Get("Access-Control-Allow-Origin"))
+			if tc.expectedOrigin != "" {
+				assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+				assert.Equal(t, "3600", resp.Header.Get("Access-Control-Max-Age"))
+			}
+		})
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	testCases := []struct {
+		name           string
+		allowed        []string
+		origin         string
+		upgrade        bool
+		expectedStatus int
+	}{
+		{name: "Not An Upgrade", origin: "http://example.com", expectedStatus: fiber.StatusUpgradeRequired},
+		{name: "No Origin", upgrade: true, expectedStatus: fiber.StatusOK},
+		{name: "Same Origin", origin: "http://example.com", upgrade: true, expectedStatus: fiber.StatusOK},
+		{name: "Allowed Origin", allowed: []string{"https://notes.example.com"}, origin: "https://Notes.example.com", upgrade: true, expectedStatus: fiber.StatusOK},
+		{name: "Other Origin", allowed: []string{"https://notes.example.com"}, origin: "https://evil.example.com", upgrade: true, expectedStatus: fiber.StatusForbidden},
+		{name: "Same Host Other Port", origin: "http://example.com:8080", upgrade: true, expectedStatus: fiber.StatusForbidden},
+		{name: "Any Origin", allowed: []string{"*"}, origin: "https://evil.example.com", upgrade: true, expectedStatus: fiber.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+			app.Get("/ws", WebSocketOrigin(tc.allowed), func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+			// httptest requests are for host example.com
+			req := httptest.NewRequest("GET", "/ws", nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			if tc.upgrade {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+}
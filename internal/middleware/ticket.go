@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// ticket is a single-use credential bound to a user
+type ticket struct {
+	userID    string
+	expiresAt time.Time
+}
+
+// TicketStore issues short-lived, single-use tickets that WebSocket clients
+// can present in the handshake query string instead of a long-lived JWT
+type TicketStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	tickets map[string]ticket
+}
+
+// NewTicketStore creates a TicketStore whose tickets expire after ttl
+func NewTicketStore(ttl time.Duration) *TicketStore {
+	return &TicketStore{
+		ttl:     ttl,
+		tickets: make(map[string]ticket),
+	}
+}
+
+// Issue creates a new ticket for the given user
+func (s *TicketStore) Issue(userID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, t := range s.tickets {
+		if now.After(t.expiresAt) {
+			delete(s.tickets, id)
+		}
+	}
+
+	id := uuid.New().String()
+	s.tickets[id] = ticket{userID: userID, expiresAt: now.Add(s.ttl)}
+	return id
+}
+
+// Redeem consumes a ticket and returns the user it was issued to.
+// A ticket can only be redeemed once and never after it has expired.
+func (s *TicketStore) Redeem(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, exists := s.tickets[id]
+	if !exists {
+		return "", false
+	}
+	delete(s.tickets, id)
+
+	if time.Now().After(t.expiresAt) {
+		return "", false
+	}
+	return t.userID, true
+}
+
+// IssueTicket handles requests for a WebSocket ticket. It must be mounted
+// behind Protected so the caller's user ID is already in the context.
+func (s *TicketStore) IssueTicket(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"ticket":     s.Issue(userID),
+		"expires_in": int(s.ttl.Seconds()),
+	})
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTicketStore_IssueAndRedeem(t *testing.T) {
+	store := NewTicketStore(time.Minute)
+
+	ticket := store.Issue("user123")
+	assert.NotEmpty(t, ticket)
+
+	userID, ok := store.Redeem(ticket)
+	assert.True(t, ok)
+	assert.Equal(t, "user123", userID)
+
+	// Tickets are single-use
+	_, ok = store.Redeem(ticket)
+	assert.False(t, ok)
+
+	// Unknown tickets are rejected
+	_, ok = store.Redeem("unknown")
+	assert.False(t, ok)
+}
+
+func TestTicketStore_Expiry(t *testing.T) {
+	store := NewTicketStore(-time.Second)
+
+	ticket := store.Issue("user123")
+	_, ok := store.Redeem(ticket)
+	assert.False(t, ok)
+}
+
+func TestWebSocketAuth(t *testing.T) {
+	store := NewTicketStore(time.Minute)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Get("/ws/notes/:id", WebSocketAuth(nil, store, testKeys), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"user-id": c.Locals("user-id")})
+	})
+
+	testCases := []struct {
+		name           string
+		query          string
+		upgrade        bool
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "Not An Upgrade",
+			query:          "?ticket=" + store.Issue("user123"),
+			expectedStatus: fiber.StatusUpgradeRequired,
+			expectedError:  "WebSocket upgrade required",
+		},
+		{
+			name:           "Missing Credentials",
+			upgrade:        true,
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Missing token",
+		},
+		{
+			name:           "Invalid Ticket",
+			query:          "?ticket=bogus",
+			upgrade:        true,
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Invalid or expired ticket",
+		},
+		{
+			name:           "Invalid Token",
+			query:          "?token=bogus",
+			upgrade:        true,
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedError:  "Invalid or expired token",
+		},
+		{
+			name:           "Valid Ticket",
+			query:          "?ticket=" + store.Issue("user123"),
+			upgrade:        true,
+			expectedStatus: fiber.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/ws/notes/note1"+tc.query, nil)
+			if tc.upgrade {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			var response struct {
+				apperr.Response
+				UserID string `json:"user-id"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("error decoding response: %v", err)
+			}
+			if tc.expectedError != "" {
+				assert.Equal(t, tc.expectedError, response.Message)
+			} else {
+				assert.Equal(t, "user123", response.UserID)
+			}
+		})
+	}
+}
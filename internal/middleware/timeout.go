@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Timeout bounds each request's user context to d. Handlers pass
+// c.UserContext() to their queries, so a slow database fails the request
+// instead of piling up goroutines. A request that failed because its
+// deadline passed is answered with 504 Gateway Timeout.
+func Timeout(d time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), d)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && apperr.Status(err) == fiber.StatusInternalServerError {
+			return apperr.New(fiber.StatusGatewayTimeout, "Request timed out")
+		}
+		return err
+	}
+}
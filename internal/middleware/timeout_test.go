@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeout(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(Timeout(20 * time.Millisecond))
+	app.Get("/fast", func(c *fiber.Ctx) error {
+		_, hasDeadline := c.UserContext().Deadline()
+		assert.True(t, hasDeadline)
+		return c.SendStatus(fiber.StatusOK)
+	})
+	app.Get("/slow", func(c *fiber.Ctx) error {
+		// Stands in for a query that fails once its context expires
+		<-c.UserContext().Done()
+		return c.UserContext().Err()
+	})
+	app.Get("/slow-not-found", func(c *fiber.Ctx) error {
+		<-c.UserContext().Done()
+		return apperr.New(fiber.StatusNotFound, "Note not found")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/fast", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/slow", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusGatewayTimeout, resp.StatusCode)
+
+	// Errors meant for the client pass through untouched
+	resp, err = app.Test(httptest.NewRequest("GET", "/slow-not-found", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
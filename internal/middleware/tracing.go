@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("quanta/internal/middleware")
+
+// Tracing starts a server span for each request, continuing the trace
+// named by an incoming traceparent header. The span goes into the user
+// context, so database queries and broadcasts made while handling the
+// request become its children. It must run before Timeout.
+func Tracing() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header := http.Header{}
+		c.Request().Header.VisitAll(func(key, value []byte) {
+			header.Add(string(key), string(value))
+		})
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), propagation.HeaderCarrier(header))
+
+		ctx, span := tracer.Start(ctx, c.Method(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Method()),
+				semconv.URLPath(c.Path()),
+			),
+		)
+		defer span.End()
+		c.SetUserContext(ctx)
+
+		self := c.Route()
+		err := c.Next()
+
+		// The route is only known once routing has happened. Requests no
+		// route matched are left named by their method alone.
+		if route := c.Route(); route != self {
+			span.SetName(c.Method() + " " + route.Path)
+			span.SetAttributes(semconv.HTTPRoute(route.Path))
+		}
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = apperr.Status(err)
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+			if err != nil {
+				span.RecordError(err)
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var handlerSpan trace.SpanContext
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(Tracing())
+	app.Get("/notes/:id", func(c *fiber.Ctx) error {
+		handlerSpan = trace.SpanContextFromContext(c.UserContext())
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+	app.Get("/broken", func(c *fiber.Ctx) error {
+		return apperr.New(fiber.StatusServiceUnavailable, "Down for maintenance")
+	})
+
+	req := httptest.NewRequest("GET", "/notes/note1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if _, err := app.Test(req); err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	if _, err := app.Test(httptest.NewRequest("GET", "/broken", nil)); err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	if _, err := app.Test(httptest.NewRequest("GET", "/missing", nil)); err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+
+	span := spans[0]
+	assert.Equal(t, "GET /notes/:id", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+	assert.Equal(t, span.SpanContext(), handlerSpan)
+	assert.Contains(t, span.Attributes(), semconv.HTTPRoute("/notes/:id"))
+	assert.Contains(t, span.Attributes(), semconv.HTTPResponseStatusCode(fiber.StatusNoContent))
+
+	span = spans[1]
+	assert.False(t, span.Parent().IsValid())
+	assert.Contains(t, span.Attributes(), semconv.HTTPResponseStatusCode(fiber.StatusServiceUnavailable))
+	assert.Equal(t, codes.Error, span.Status().Code)
+
+	span = spans[2]
+	assert.Equal(t, "GET", span.Name())
+	assert.Contains(t, span.Attributes(), semconv.HTTPResponseStatusCode(fiber.StatusNotFound))
+}
@@ -0,0 +1,14 @@
+package models
+
+// APIKeyPrefix starts every API key, which tells them apart from JWTs
+const APIKeyPrefix = "qk_"
+
+// Scopes an API key can be granted. Keys only reach the routes that accept
+// them, and only with the scope each of those routes asks for.
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+)
+
+// Scopes lists every API key scope
+var Scopes = []string{ScopeNotesRead, ScopeNotesWrite}
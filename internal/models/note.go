@@ -0,0 +1,82 @@
+package models
+
+// MaxTitleColumn is the width of the notes.title column
+const MaxTitleColumn = 255
+
+// MaxContentColumn is the capacity in bytes of the notes.content column,
+// which is MEDIUMTEXT on MySQL
+const MaxContentColumn = 1<<24 - 1
+
+// MaxMetadataBytes is the largest a note's custom metadata may be,
+// encoded as JSON
+const MaxMetadataBytes = 8 << 10
+
+// MaxMetadataDepth is how deeply objects and arrays may nest in a note's
+// custom metadata, counting its top-level object
+const MaxMetadataDepth = 4
+
+// NoteColors is the palette a note's color label is picked from
+var NoteColors = []string{"red", "orange", "yellow", "green", "teal", "blue", "purple", "pink", "gray"}
+
+// NoteIcons are the emoji a note's icon is picked from
+var NoteIcons = []string{
+	"📝", "📌", "📎", "📅", "📚", "📊", "💡", "✅", "⭐", "❤️",
+	"🔥", "🎯", "🚀", "🎨", "🎵", "✈️", "🏠", "💼", "🛒", "💰",
+	"🔒", "⚠️", "🐛", "🌱",
+}
+
+// NoteLimits caps the size of a note. Titles are measured in characters
+// and content in bytes.
+type NoteLimits struct {
+	MaxTitleLength  int
+	MaxContentBytes int
+}
+
+// DefaultNoteLimits are used when no limits are configured
+var DefaultNoteLimits = NoteLimits{
+	MaxTitleLength:  MaxTitleColumn,
+	MaxContentBytes: 1 << 20,
+}
+
+// WithDefaults fills zero limits from DefaultNoteLimits
+func (l NoteLimits) WithDefaults() NoteLimits {
+	if l.MaxTitleLength <= 0 {
+		l.MaxTitleLength = DefaultNoteLimits.MaxTitleLength
+	}
+	if l.MaxContentBytes <= 0 {
+		l.MaxContentBytes = DefaultNoteLimits.MaxContentBytes
+	}
+	return l
+}
+
+// Roles a user can hold on a note, from least to most access. Viewers and
+// commenters can only read its content; editors may also change and delete
+// it. The note's author is its owner, the only one who can change the
+// others' roles.
+const (
+	NoteRoleViewer    = "viewer"
+	NoteRoleCommenter = "commenter"
+	NoteRoleEditor    = "editor"
+	NoteRoleOwner     = "owner"
+)
+
+// NoteRoles lists the roles a note's owner can give its collaborators
+var NoteRoles = []string{NoteRoleViewer, NoteRoleCommenter, NoteRoleEditor}
+
+// CanEditNote reports whether a note role may change the note
+func CanEditNote(role string) bool {
+	return role == NoteRoleEditor || role == NoteRoleOwner
+}
+
+// ResolveNoteRole gives the role on a note of a user who can access it:
+// owner for its author, else the role they were granted, else editor
+func ResolveNoteRole(author, userID, granted string) string {
+	switch {
+	case author == userID:
+		return NoteRoleOwner
+	case granted != "":
+		return granted
+	default:
+		return NoteRoleEditor
+	}
+}
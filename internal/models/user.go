@@ -2,10 +2,71 @@
 // the application's domain objects and database schema
 package models
 
+import "time"
+
+// Roles a user can hold. Every account starts as RoleUser; RoleAdmin
+// grants access to the /admin routes.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// MaxPublicKeyLength is the longest public key a user can publish for
+// sharing encrypted notes
+const MaxPublicKeyLength = 4096
+
 // User represents a user account in the system with
 // identification, authentication and profile information
 type User struct {
-	ID       int    `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"-"`
+	ID          string    `json:"id"`
+	Email       string    `json:"email"`
+	Password    string    `json:"-"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   string    `json:"avatar_url"`
+	Timezone    string    `json:"timezone"`
+	PublicKey   string    `json:"public_key,omitempty"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// DigestFrequency is how often a user is emailed a digest of the activity
+// on their notes
+type DigestFrequency string
+
+const (
+	// DigestOff sends no digests
+	DigestOff DigestFrequency = "off"
+	// DigestDaily sends a digest every day
+	DigestDaily DigestFrequency = "daily"
+	// DigestWeekly sends a digest every 7 days
+	DigestWeekly DigestFrequency = "weekly"
+)
+
+// Valid reports whether f is a known frequency
+func (f DigestFrequency) Valid() bool {
+	switch f {
+	case DigestOff, DigestDaily, DigestWeekly:
+		return true
+	}
+	return false
+}
+
+// Period returns the time between digests, or 0 when they are off
+func (f DigestFrequency) Period() time.Duration {
+	switch f {
+	case DigestDaily:
+		return 24 * time.Hour
+	case DigestWeekly:
+		return 7 * 24 * time.Hour
+	}
+	return 0
 }
+
+// Preferences are a user's settings, stored in user_preferences. Users
+// without a row there have the defaults.
+type Preferences struct {
+	Digest DigestFrequency `json:"digest"`
+}
+
+// DefaultPreferences are the settings of users who never changed them
+var DefaultPreferences = Preferences{Digest: DigestOff}
@@ -0,0 +1,207 @@
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"quanta/internal/activity"
+	"quanta/internal/models"
+)
+
+// DefaultActivityDigestPoll is how often users are checked for a due
+// activity digest
+const DefaultActivityDigestPoll = time.Hour
+
+// MaxDigestNotes is the most edited notes an activity digest lists
+const MaxDigestNotes = 10
+
+// editedNote is a note that collaborators edited since the last digest
+type editedNote struct {
+	title string
+	edits int
+}
+
+// activitySummary is what an activity digest reports
+type activitySummary struct {
+	edited []editedNote
+	shared int
+}
+
+func (s activitySummary) empty() bool {
+	return len(s.edited) == 0 && s.shared == 0
+}
+
+type digestRecipient struct {
+	userID string
+	email  string
+	digest models.DigestFrequency
+	sentAt sql.NullTime
+}
+
+// SendActivityDigests emails each user whose daily or weekly digest is due
+// a summary of the notes collaborators edited and shared with them since
+// their last one. A user's first digest
+// covers one period. Users with nothing to report get no email, but their
+// period starts over all the same. It returns the number of digests sent.
+func SendActivityDigests(ctx context.Context, db DBInterface, mailer Mailer, now time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT p.user_id, u.email, p.digest, p.digest_sent_at FROM user_preferences p "+
+			"JOIN users u ON u.id = p.user_id "+
+			"WHERE p.digest <> ? AND u.deleted_at IS NULL ORDER BY p.user_id",
+		string(models.DigestOff),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var due []digestRecipient
+	for rows.Next() {
+		var r digestRecipient
+		if err := rows.Scan(&r.userID, &r.email, &r.digest, &r.sentAt); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		period := r.digest.Period()
+		if period > 0 && (!r.sentAt.Valid || !now.Before(r.sentAt.Time.Add(period))) {
+			due = append(due, r)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, r := range due {
+		since := now.Add(-r.digest.Period())
+		if r.sentAt.Valid {
+			since = r.sentAt.Time
+		}
+		summary, err := summarize(ctx, db, r.userID, since)
+		if err != nil {
+			return sent, err
+		}
+
+		if !summary.empty() {
+			if err := mailer.Send(r.email, activityDigestSubject(r.digest), activityDigestBody(summary)); err != nil {
+				log.Printf("Error sending activity digest to %s: %v", r.userID, err)
+				continue
+			}
+			sent++
+		}
+
+		if _, err := db.ExecContext(ctx, "UPDATE user_preferences SET digest_sent_at = ? WHERE user_id = ?", now.UTC(), r.userID); err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// summarize gathers what happened to the user's notes since a time: edits
+// by others to the notes they can access, busiest first, and how many
+// notes were shared with them
+func summarize(ctx context.Context, db DBInterface, userID string, since time.Time) (activitySummary, error) {
+	var s activitySummary
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT n.title, COUNT(*) FROM activities a JOIN notes n ON n.id = a.note_id "+
+			"WHERE a.action = ? AND a.actor_id <> ? AND a.created_at > ? "+
+			"AND ((n.workspace_id IS NULL AND n.user_id = ?) OR n.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)) "+
+			"GROUP BY n.id, n.title ORDER BY COUNT(*) DESC, n.title LIMIT ?",
+		string(activity.ActionEdited), userID, since.UTC(), userID, userID, MaxDigestNotes,
+	)
+	if err != nil {
+		return s, fmt.Errorf("fetching edits: %w", err)
+	}
+	for rows.Next() {
+		var e editedNote
+		if err := rows.Scan(&e.title, &e.edits); err != nil {
+			_ = rows.Close()
+			return s, fmt.Errorf("scanning edits: %w", err)
+		}
+		s.edited = append(s.edited, e)
+	}
+	if err := rows.Close(); err != nil {
+		return s, err
+	}
+
+	rows, err = db.QueryContext(ctx,
+		"SELECT COUNT(*) FROM notifications WHERE user_id = ? AND kind = ? AND created_at > ?",
+		userID, string(KindShareInvite), since.UTC(),
+	)
+	if err != nil {
+		return s, fmt.Errorf("fetching notifications: %w", err)
+	}
+	for rows.Next() {
+		if err := rows.Scan(&s.shared); err != nil {
+			_ = rows.Close()
+			return s, fmt.Errorf("scanning notifications: %w", err)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+// StartActivityDigestWorker sends the activity digests that are due every
+// interval until stop is closed. Each run must finish within the interval.
+// Digests wait while paused reports true.
+func StartActivityDigestWorker(db DBInterface, mailer Mailer, interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			sent, err := SendActivityDigests(ctx, db, mailer, now)
+			cancel()
+			if err != nil {
+				log.Println("Error sending activity digests:", err)
+				continue
+			}
+			if sent > 0 {
+				log.Printf("Sent %d activity digests", sent)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func activityDigestSubject(digest models.DigestFrequency) string {
+	return fmt.Sprintf("Your %s notes digest", digest)
+}
+
+func activityDigestBody(s activitySummary) string {
+	var b strings.Builder
+	b.WriteString("Here is what happened since your last digest:\n\n")
+	if len(s.edited) > 0 {
+		b.WriteString("Notes your collaborators edited:\n")
+		for _, e := range s.edited {
+			fmt.Fprintf(&b, "- %s (%s)\n", e.title, plural(e.edits, "edit"))
+		}
+		b.WriteString("\n")
+	}
+	if s.shared > 0 {
+		fmt.Fprintf(&b, "%s shared with you\n", plural(s.shared, "note"))
+	}
+	b.WriteString("\nYou can change how often you get this digest, or turn it off, in your preferences.\n")
+	return b.String()
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,58 @@
+package notifications
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendActivityDigests(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	now := time.Date(2026, 3, 10, 8, 0, 0, 0, time.UTC)
+	editsQuery := regexp.QuoteMeta("SELECT n.title, COUNT(*) FROM activities a JOIN notes n ON n.id = a.note_id WHERE a.action = ? AND a.actor_id <> ? AND a.created_at > ? AND ((n.workspace_id IS NULL AND n.user_id = ?) OR n.workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)) GROUP BY n.id, n.title ORDER BY COUNT(*) DESC, n.title LIMIT ?")
+	notificationsQuery := regexp.QuoteMeta("SELECT COUNT(*) FROM notifications WHERE user_id = ? AND kind = ? AND created_at > ?")
+	markSent := regexp.QuoteMeta("UPDATE user_preferences SET digest_sent_at = ? WHERE user_id = ?")
+
+	// alice's daily digest is due, bob's first weekly one covers the last
+	// week but finds nothing and carol's weekly one isn't due yet
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT p.user_id, u.email, p.digest, p.digest_sent_at FROM user_preferences p JOIN users u ON u.id = p.user_id WHERE p.digest <> ? AND u.deleted_at IS NULL ORDER BY p.user_id")).
+		WithArgs("off").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "digest", "digest_sent_at"}).
+			AddRow("alice", "alice@example.com", "daily", now.Add(-25*time.Hour)).
+			AddRow("bob", "bob@example.com", "weekly", nil).
+			AddRow("carol", "carol@example.com", "weekly", now.Add(-48*time.Hour)))
+	mockDB.ExpectQuery(editsQuery).
+		WithArgs("edited", "alice", now.Add(-25*time.Hour), "alice", "alice", MaxDigestNotes).
+		WillReturnRows(sqlmock.NewRows([]string{"title", "count"}).AddRow("Roadmap", 3).AddRow("Budget", 1))
+	mockDB.ExpectQuery(notificationsQuery).
+		WithArgs("alice", "share_invite", now.Add(-25*time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mockDB.ExpectExec(markSent).WithArgs(now, "alice").WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectQuery(editsQuery).
+		WithArgs("edited", "bob", now.Add(-7*24*time.Hour), "bob", "bob", MaxDigestNotes).
+		WillReturnRows(sqlmock.NewRows([]string{"title", "count"}))
+	mockDB.ExpectQuery(notificationsQuery).
+		WithArgs("bob", "share_invite", now.Add(-7*24*time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mockDB.ExpectExec(markSent).WithArgs(now, "bob").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mailer := &fakeMailer{}
+	sent, err := SendActivityDigests(context.Background(), db, mailer, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	if assert.Len(t, mailer.sent, 1) {
+		assert.Equal(t, "alice@example.com", mailer.sent[0].to)
+		assert.Equal(t, "Your daily notes digest", mailer.sent[0].subject)
+		assert.Contains(t, mailer.sent[0].body, "- Roadmap (3 edits)\n- Budget (1 edit)\n")
+		assert.Contains(t, mailer.sent[0].body, "1 note shared with you\n")
+	}
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,133 @@
+package notifications
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// DefaultDigestInterval is how often pending notifications are emailed
+const DefaultDigestInterval = 24 * time.Hour
+
+type pendingNotification struct {
+	id      string
+	userID  string
+	email   string
+	kind    Kind
+	created time.Time
+}
+
+// SendDigests emails every user a summary of their unread notifications that
+// have not been included in an earlier digest, then marks them as emailed.
+// It returns the number of digests sent.
+func SendDigests(ctx context.Context, db DBInterface, mailer Mailer) (int, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT n.id, n.user_id, u.email, n.kind, n.created_at FROM notifications n "+
+			"JOIN users u ON u.id = n.user_id "+
+			"WHERE n.read_at IS NULL AND n.emailed_at IS NULL AND u.deleted_at IS NULL "+
+			"ORDER BY n.user_id, n.created_at",
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var pending []pendingNotification
+	for rows.Next() {
+		var p pendingNotification
+		if err := rows.Scan(&p.id, &p.userID, &p.email, &p.kind, &p.created); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		pending = append(pending, p)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for start := 0; start < len(pending); {
+		end := start
+		for end < len(pending) && pending[end].userID == pending[start].userID {
+			end++
+		}
+		batch := pending[start:end]
+		start = end
+
+		if err := mailer.Send(batch[0].email, digestSubject(len(batch)), digestBody(batch)); err != nil {
+			log.Printf("Error sending digest to %s: %v", batch[0].userID, err)
+			continue
+		}
+
+		ids := make([]any, len(batch))
+		for i, p := range batch {
+			ids[i] = p.id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+		if _, err := db.ExecContext(ctx, "UPDATE notifications SET emailed_at = CURRENT_TIMESTAMP WHERE id IN ("+placeholders+")", ids...); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// StartDigestWorker sends digests every interval until stop is closed.
+// Each run must finish within the interval.
+// Digests wait while paused reports true.
+func StartDigestWorker(db DBInterface, mailer Mailer, interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			sent, err := SendDigests(ctx, db, mailer)
+			cancel()
+			if err != nil {
+				log.Println("Error sending notification digests:", err)
+				continue
+			}
+			if sent > 0 {
+				log.Printf("Sent %d notification digests", sent)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func digestSubject(count int) string {
+	if count == 1 {
+		return "You have 1 unread notification"
+	}
+	return fmt.Sprintf("You have %d unread notifications", count)
+}
+
+func digestBody(batch []pendingNotification) string {
+	var b strings.Builder
+	b.WriteString("Here is what you missed:\n\n")
+	for _, p := range batch {
+		fmt.Fprintf(&b, "- %s (%s)\n", describe(p.kind), p.created.Format(time.RFC1123))
+	}
+	return b.String()
+}
+
+func describe(kind Kind) string {
+	switch kind {
+	case KindShareInvite:
+		return "A note was shared with you"
+	case KindReminder:
+		return "A reminder is due"
+	case KindAttachmentQuarantined:
+		return "A file you uploaded was quarantined"
+	default:
+		return string(kind)
+	}
+}
@@ -0,0 +1,62 @@
+package notifications
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type sentMail struct {
+	to, subject, body string
+}
+
+// fakeMailer captures sent emails
+type fakeMailer struct {
+	sent []sentMail
+}
+
+func (f *fakeMailer) Send(to, subject, body string) error {
+	f.sent = append(f.sent, sentMail{to, subject, body})
+	return nil
+}
+
+func TestSendDigests(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	now := time.Now()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT n.id, n.user_id, u.email, n.kind, n.created_at FROM notifications n JOIN users u ON u.id = n.user_id WHERE n.read_at IS NULL AND n.emailed_at IS NULL AND u.deleted_at IS NULL ORDER BY n.user_id, n.created_at")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "email", "kind", "created_at"}).
+			AddRow("n1", "alice", "alice@example.com", "share_invite", now).
+			AddRow("n2", "alice", "alice@example.com", "reminder", now).
+			AddRow("n3", "bob", "bob@example.com", "attachment_quarantined", now))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE notifications SET emailed_at = CURRENT_TIMESTAMP WHERE id IN (?, ?)")).
+		WithArgs("n1", "n2").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE notifications SET emailed_at = CURRENT_TIMESTAMP WHERE id IN (?)")).
+		WithArgs("n3").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	mailer := &fakeMailer{}
+	sent, err := SendDigests(context.Background(), db, mailer)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+
+	if assert.Len(t, mailer.sent, 2) {
+		assert.Equal(t, "alice@example.com", mailer.sent[0].to)
+		assert.Equal(t, "You have 2 unread notifications", mailer.sent[0].subject)
+		assert.Contains(t, mailer.sent[0].body, "A note was shared with you")
+		assert.Equal(t, "bob@example.com", mailer.sent[1].to)
+		assert.Equal(t, "You have 1 unread notification", mailer.sent[1].subject)
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
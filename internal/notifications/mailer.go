@@ -0,0 +1,52 @@
+package notifications
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer sends plain-text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer sends email through an SMTP relay
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// Send delivers a single plain-text message
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		m.From, to, subject, body)
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, []byte(msg))
+}
+
+// LogMailer writes emails to the log instead of sending them. It is used
+// when no SMTP relay is configured.
+type LogMailer struct{}
+
+// Send logs the message
+func (LogMailer) Send(to, subject, body string) error {
+	log.Printf("Email to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// NewMailer builds an SMTPMailer for the relay at addr, authenticating when
+// a username is given. It falls back to a LogMailer when addr is empty.
+func NewMailer(addr, from, username, password string) Mailer {
+	if addr == "" {
+		return LogMailer{}
+	}
+
+	mailer := &SMTPMailer{Addr: addr, From: from}
+	if username != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		mailer.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
@@ -0,0 +1,238 @@
+// Package notifications stores per-user notifications and delivers them
+// live over a per-user WebSocket channel and as periodic email digests
+package notifications
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/realtime"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"github.com/google/uuid"
+)
+
+// Kind identifies what a notification is about
+type Kind string
+
+const (
+	// KindShareInvite is sent when someone shares a note with the user
+	KindShareInvite Kind = "share_invite"
+	// KindReminder is sent when a reminder the user set on a note is due
+	KindReminder Kind = "reminder"
+	// KindAttachmentQuarantined is sent when a file the user uploaded was
+	// found to contain malware
+	KindAttachmentQuarantined Kind = "attachment_quarantined"
+)
+
+// MaxListLimit is the most notifications returned by a single list request
+const MaxListLimit = 100
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Notification is a single message addressed to a user
+type Notification struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id"`
+	Kind      Kind              `json:"kind"`
+	Payload   map[string]string `json:"payload,omitempty"`
+	ReadAt    *time.Time        `json:"read_at"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Message is the realtime frame pushed to a user's notification channel
+type Message struct {
+	Type         string       `json:"type"`
+	V            int          `json:"v"`
+	Notification Notification `json:"notification"`
+}
+
+// Handler creates notifications and serves the notification endpoints
+type Handler struct {
+	db  DBInterface
+	hub *realtime.RoomManager
+}
+
+// NewHandler creates a notification Handler. Each user's live connections
+// share a room keyed by their user ID.
+func NewHandler(db DBInterface) *Handler {
+	return &Handler{
+		db:  db,
+		hub: realtime.NewRoomManager(),
+	}
+}
+
+// Notify stores a notification for a user and pushes it to any of their
+// open notification sockets
+func (h *Handler) Notify(ctx context.Context, userID string, kind Kind, payload map[string]string) error {
+	return h.notify(ctx, userID, kind, payload, false)
+}
+
+// NotifyEmailed is Notify for notifications the caller has already emailed
+// on their own, so digests leave them out
+func (h *Handler) NotifyEmailed(ctx context.Context, userID string, kind Kind, payload map[string]string) error {
+	return h.notify(ctx, userID, kind, payload, true)
+}
+
+func (h *Handler) notify(ctx context.Context, userID string, kind Kind, payload map[string]string, emailed bool) error {
+	n := Notification{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Kind:      kind,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}
+
+	var payloadJSON sql.NullString
+	if len(payload) > 0 {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		payloadJSON = sql.NullString{String: string(encoded), Valid: true}
+	}
+
+	var emailedAt sql.NullTime
+	if emailed {
+		emailedAt = sql.NullTime{Time: n.CreatedAt, Valid: true}
+	}
+
+	_, err := h.db.ExecContext(ctx,
+		"INSERT INTO notifications (id, user_id, kind, payload, emailed_at) VALUES (?, ?, ?, ?, ?)",
+		n.ID, n.UserID, string(n.Kind), payloadJSON, emailedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	message, err := json.Marshal(Message{Type: "notification", V: realtime.ProtocolVersion, Notification: n})
+	if err != nil {
+		return err
+	}
+	h.hub.BroadcastToRoom(userID, nil, websocket.TextMessage, message)
+
+	return nil
+}
+
+// ListNotifications returns the user's notifications, newest first.
+// Pass ?unread=true to only list unread ones.
+func (h *Handler) ListNotifications(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT id, user_id, kind, payload, read_at, created_at FROM notifications WHERE user_id = ?"
+	if c.QueryBool("unread") {
+		query += " AND read_at IS NULL"
+	}
+	query += " ORDER BY created_at DESC LIMIT ?"
+
+	rows, err := h.db.QueryContext(c.UserContext(), query, userID, MaxListLimit)
+	if err != nil {
+		return fmt.Errorf("fetching notifications: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		var payload sql.NullString
+		var readAt sql.NullTime
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Kind, &payload, &readAt, &n.CreatedAt); err != nil {
+			return fmt.Errorf("scanning notification: %w", err)
+		}
+		if payload.Valid {
+			if err := json.Unmarshal([]byte(payload.String), &n.Payload); err != nil {
+				log.Println("Error decoding notification payload:", err)
+			}
+		}
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+		notifications = append(notifications, n)
+	}
+
+	return c.JSON(notifications)
+}
+
+// MarkRead marks a single notification as read
+func (h *Handler) MarkRead(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	notificationID := c.Params("id")
+
+	result, err := h.db.ExecContext(c.UserContext(),
+		"UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP) WHERE id = ? AND user_id = ?",
+		notificationID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("marking notification read: %w", err)
+	}
+
+	affectedRows, _ := result.RowsAffected()
+	if affectedRows == 0 {
+		return apperr.New(fiber.StatusNotFound, "Notification not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// MarkAllRead marks every unread notification of the user as read
+func (h *Handler) MarkAllRead(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.db.ExecContext(c.UserContext(), "UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL", userID); err != nil {
+		return fmt.Errorf("marking notifications read: %w", err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// HandleWebSocket subscribes a connection to the user's notification channel.
+// The channel is push-only; anything the client sends is ignored.
+func (h *Handler) HandleWebSocket(c *fiber.Ctx) error {
+	return websocket.New(func(c *websocket.Conn) {
+		userID, err := auth.UserIDFromConn(c)
+		if err != nil {
+			if err := c.WriteJSON(realtime.ErrorMessage{
+				Type:  realtime.MessageTypeError,
+				V:     realtime.ProtocolVersion,
+				Code:  realtime.ErrorCodeBadRequest,
+				Error: "User ID not found in context",
+			}); err != nil {
+				log.Printf("Error sending user ID not found message: %v", err)
+			}
+			return
+		}
+
+		h.hub.JoinRoom(userID, c, realtime.Participant{UserID: userID})
+		defer h.hub.LeaveRoom(userID, c)
+
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})(c)
+}
@@ -0,0 +1,198 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/realtime"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConn records messages written to a notification socket
+type fakeConn struct {
+	written chan []byte
+}
+
+func (f *fakeConn) WriteMessage(_ int, data []byte) error {
+	f.written <- data
+	return nil
+}
+
+func (f *fakeConn) ReadMessage() (int, []byte, error) { return 0, nil, nil }
+
+func (f *fakeConn) Close() error { return nil }
+
+type testHelper struct {
+	t       *testing.T
+	mockDB  sqlmock.Sqlmock
+	app     *fiber.App
+	handler *Handler
+}
+
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	handler := NewHandler(db)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/notifications", handler.ListNotifications)
+	app.Post("/notifications/read", handler.MarkAllRead)
+	app.Post("/notifications/:id/read", handler.MarkRead)
+
+	return &testHelper{t: t, mockDB: mockDB, app: app, handler: handler}
+}
+
+func TestNotify(t *testing.T) {
+	h := newTestHelper(t)
+	conn := &fakeConn{written: make(chan []byte, 1)}
+	h.handler.hub.JoinRoom("user123", conn, realtime.Participant{UserID: "user123"})
+
+	h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO notifications (id, user_id, kind, payload, emailed_at) VALUES (?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "user123", "share_invite", `{"note_id":"note1"}`, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := h.handler.Notify(context.Background(), "user123", KindShareInvite, map[string]string{"note_id": "note1"})
+	assert.NoError(t, err)
+
+	select {
+	case data := <-conn.written:
+		var msg Message
+		assert.NoError(t, json.Unmarshal(data, &msg))
+		assert.Equal(t, "notification", msg.Type)
+		assert.Equal(t, KindShareInvite, msg.Notification.Kind)
+		assert.Equal(t, "note1", msg.Notification.Payload["note_id"])
+	case <-time.After(time.Second):
+		t.Fatal("notification was not delivered")
+	}
+
+	if err := h.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestNotifyEmailed(t *testing.T) {
+	h := newTestHelper(t)
+
+	h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO notifications (id, user_id, kind, payload, emailed_at) VALUES (?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "user123", "reminder", `{"note_id":"note1"}`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := h.handler.NotifyEmailed(context.Background(), "user123", KindReminder, map[string]string{"note_id": "note1"})
+	assert.NoError(t, err)
+
+	if err := h.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestListNotifications(t *testing.T) {
+	columns := []string{"id", "user_id", "kind", "payload", "read_at", "created_at"}
+	now := time.Now()
+
+	testCases := []struct {
+		name      string
+		url       string
+		query     string
+		wantCount int
+	}{
+		{
+			name:      "All notifications",
+			url:       "/notifications",
+			query:     "SELECT id, user_id, kind, payload, read_at, created_at FROM notifications WHERE user_id = ? ORDER BY created_at DESC LIMIT ?",
+			wantCount: 2,
+		},
+		{
+			name:      "Unread only",
+			url:       "/notifications?unread=true",
+			query:     "SELECT id, user_id, kind, payload, read_at, created_at FROM notifications WHERE user_id = ? AND read_at IS NULL ORDER BY created_at DESC LIMIT ?",
+			wantCount: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			rows := sqlmock.NewRows(columns).
+				AddRow("n1", "user123", "share_invite", `{"note_id":"note1"}`, nil, now)
+			if tc.wantCount == 2 {
+				rows.AddRow("n2", "user123", "reminder", nil, now, now)
+			}
+			h.mockDB.ExpectQuery(regexp.QuoteMeta(tc.query)).
+				WithArgs("user123", MaxListLimit).
+				WillReturnRows(rows)
+
+			resp, err := h.app.Test(httptest.NewRequest("GET", tc.url, nil))
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			var got []Notification
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+			assert.Len(t, got, tc.wantCount)
+			assert.Equal(t, "note1", got[0].Payload["note_id"])
+			assert.Nil(t, got[0].ReadAt)
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	query := regexp.QuoteMeta("UPDATE notifications SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP) WHERE id = ? AND user_id = ?")
+
+	testCases := []struct {
+		name         string
+		affected     int64
+		expectedCode int
+	}{
+		{name: "Marked read", affected: 1, expectedCode: fiber.StatusNoContent},
+		{name: "Not found", affected: 0, expectedCode: fiber.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			h.mockDB.ExpectExec(query).
+				WithArgs("n1", "user123").
+				WillReturnResult(sqlmock.NewResult(0, tc.affected))
+
+			resp, err := h.app.Test(httptest.NewRequest("POST", "/notifications/n1/read", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCode, resp.StatusCode)
+
+			if err := h.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestMarkAllRead(t *testing.T) {
+	h := newTestHelper(t)
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE notifications SET read_at = CURRENT_TIMESTAMP WHERE user_id = ? AND read_at IS NULL")).
+		WithArgs("user123").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	resp, err := h.app.Test(httptest.NewRequest("POST", "/notifications/read", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+
+	if err := h.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
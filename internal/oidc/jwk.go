@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jsonWebKey is a public key in a provider's key set (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// N and E are the modulus and exponent of an RSA key
+	N string `json:"n"`
+	E string `json:"e"`
+	// Crv, X and Y are the curve and point of an EC key
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes the key, or returns nil for key types ID tokens can't
+// be signed with here
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		return k.ecdsaKey()
+	}
+	return nil, nil
+}
+
+// ecdsaKey decodes an EC key, checking its point is on the curve
+func (k jsonWebKey) ecdsaKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var check ecdh.Curve
+	switch k.Crv {
+	case "P-256":
+		curve, check = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, check = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, check = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, errors.New("unsupported curve " + k.Crv)
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	x, errX := base64.RawURLEncoding.DecodeString(k.X)
+	y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+	if errX != nil || errY != nil || len(x) != size || len(y) != size {
+		return nil, errors.New("invalid EC point")
+	}
+	if _, err := check.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+		return nil, errors.New("EC point is not on the curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
+
+// decodeInt decodes a base64url big-endian integer
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid RSA key")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// keyFits reports whether key can verify signatures made with method, so a
+// token can't pick an algorithm the key wasn't meant for
+func keyFits(key any, method jwt.SigningMethod) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return true
+		}
+	case *ecdsa.PublicKey:
+		if m, ok := method.(*jwt.SigningMethodECDSA); ok {
+			return m.CurveBits == key.Curve.Params().BitSize
+		}
+	}
+	return false
+}
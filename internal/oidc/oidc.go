@@ -0,0 +1,326 @@
+// Package oidc signs users in through an OpenID Connect identity provider,
+// such as Okta, Azure AD or Keycloak, with the authorization code flow
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// KeyRefreshInterval is the least time between two fetches of the
+// provider's signing keys, so tokens naming an unknown key can't make the
+// server hammer the provider
+const KeyRefreshInterval = time.Minute
+
+// Config identifies the provider and this application's registration with
+// it
+type Config struct {
+	// Issuer is the provider's issuer URL. Its endpoints are discovered
+	// from Issuer/.well-known/openid-configuration.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback the provider sends users back to, as
+	// registered with it
+	RedirectURL string
+	// Scopes are requested along with openid
+	Scopes []string
+}
+
+// Identity is who the provider says signed in: the subject it knows them
+// by and the claims of their ID token
+type Identity struct {
+	Issuer  string
+	Subject string
+	Claims  map[string]any
+}
+
+// String returns a string claim, or an empty string when the claim is
+// missing or isn't a string
+func (id Identity) String(claim string) string {
+	s, _ := id.Claims[claim].(string)
+	return s
+}
+
+// Strings returns a claim holding a list of strings, or a single one as
+// some providers send when there is only one, such as a groups claim
+func (id Identity) Strings(claim string) []string {
+	switch v := id.Claims[claim].(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []any:
+		var items []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				items = append(items, s)
+			}
+		}
+		return items
+	}
+	return nil
+}
+
+// EmailVerified reports whether the provider vouches for the email claim
+// with email_verified set to true. Anything else, a missing claim
+// included, counts as unverified.
+func (id Identity) EmailVerified() bool {
+	switch v := id.Claims["email_verified"].(type) {
+	case bool:
+		return v
+	case string:
+		// Some providers send the claim as a string
+		return v == "true"
+	}
+	return false
+}
+
+// GroupMapping makes the members of a provider group members of a
+// workspace with Role
+type GroupMapping struct {
+	Group       string
+	WorkspaceID string
+	Role        string
+}
+
+// Provider talks to an OpenID Connect provider. Its endpoints and signing
+// keys are fetched on first use and cached.
+type Provider struct {
+	config     Config
+	HTTPClient *http.Client
+
+	mu       sync.Mutex
+	metadata *metadata
+	keys     map[string]any
+	fetched  time.Time
+}
+
+// metadata is the part of the discovery document the code flow needs
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// New creates a Provider for config. Nothing is fetched until a user signs
+// in, so a provider that is down doesn't stop the server from starting.
+func New(config Config) *Provider {
+	return &Provider{
+		config:     config,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// AuthCodeURL returns the provider's page to send a user to for signing
+// in. state comes back with the user, nonce in their ID token, and the
+// PKCE challenge derived from verifier binds the code to this login.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := []string{"openid"}
+	for _, scope := range p.config.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(md.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return md.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// Exchange trades the code the provider sent the user back with for their
+// ID token, and returns who it identifies once its signature, issuer,
+// audience, expiry and nonce check out
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Identity, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &tokens); err != nil {
+		return Identity{}, fmt.Errorf("exchanging code: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return Identity{}, errors.New("token response has no id_token")
+	}
+	return p.verify(ctx, tokens.IDToken, nonce)
+}
+
+// verify checks an ID token and returns its identity
+func (p *Provider) verify(ctx context.Context, idToken, nonce string) (Identity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.key(ctx, kid, token.Method)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return Identity{}, fmt.Errorf("verifying ID token: %w", err)
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return Identity{}, errors.New("verifying ID token: nonce does not match")
+	}
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return Identity{}, errors.New("verifying ID token: no subject")
+	}
+	return Identity{Issuer: p.config.Issuer, Subject: subject, Claims: claims}, nil
+}
+
+// discover fetches the provider's discovery document, once it succeeds
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var md metadata
+	if err := p.do(req, &md); err != nil {
+		return nil, fmt.Errorf("discovering provider: %w", err)
+	}
+	if md.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovering provider: issuer is %q, expected %q", md.Issuer, p.config.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, errors.New("discovering provider: endpoints missing from the discovery document")
+	}
+	p.metadata = &md
+	return p.metadata, nil
+}
+
+// key returns the provider's signing key kid, refetching the key set when
+// the provider has rotated to a key not seen yet
+func (p *Provider) key(ctx context.Context, kid string, method jwt.SigningMethod) (any, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[kid]
+	if !ok && time.Since(p.fetched) >= KeyRefreshInterval {
+		keys, err := p.fetchKeys(ctx, md.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+		p.keys, p.fetched = keys, time.Now()
+		key, ok = p.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if !keyFits(key, method) {
+		return nil, fmt.Errorf("signing key %q cannot verify %s", kid, method.Alg())
+	}
+	return key, nil
+}
+
+// fetchKeys downloads the provider's JSON Web Key Set. Keys for anything
+// but signatures, and types other than RSA and EC, are skipped.
+func (p *Provider) fetchKeys(ctx context.Context, uri string) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.do(req, &set); err != nil {
+		return nil, fmt.Errorf("fetching signing keys: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("fetching signing keys: key %q: %w", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// do sends req and decodes the JSON response into v
+func (p *Provider) do(req *http.Request, v any) error {
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		// Token errors explain themselves in the body (RFC 6749 5.2)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("provider answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding provider response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testProvider is an identity provider whose token endpoint hands out the
+// ID token in idToken
+type testProvider struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := &testProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, _ *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "k1", "use": "sig", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(key.N.Bytes()), "e": "AQAB"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "quanta" || secret != "s3cret" || r.PostFormValue("code") != "the-code" || r.PostFormValue("code_verifier") != "the-verifier" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": p.idToken})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// sign issues an ID token with claims on top of valid defaults
+func (p *testProvider) sign(t *testing.T, claims jwt.MapClaims) {
+	all := jwt.MapClaims{
+		"iss":            p.URL,
+		"aud":            "quanta",
+		"sub":            "00u1",
+		"nonce":          "the-nonce",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"email":          "ada@example.com",
+		"email_verified": true,
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, all)
+	token.Header["kid"] = "k1"
+	signed, err := token.SignedString(p.key)
+	require.NoError(t, err)
+	p.idToken = signed
+}
+
+func (p *testProvider) client() *Provider {
+	return New(Config{
+		Issuer:       p.URL,
+		ClientID:     "quanta",
+		ClientSecret: "s3cret",
+		RedirectURL:  "https://api.example.com/auth/oidc/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+}
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	p := newTestProvider(t)
+
+	raw, err := p.client().AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-verifier")
+	require.NoError(t, err)
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	assert.Equal(t, p.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+
+	challenge := sha256.Sum256([]byte("the-verifier"))
+	assert.Equal(t, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"quanta"},
+		"redirect_uri":          {"https://api.example.com/auth/oidc/callback"},
+		"scope":                 {"openid email profile"},
+		"state":                 {"the-state"},
+		"nonce":                 {"the-nonce"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}, u.Query())
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	testCases := []struct {
+		name          string
+		claims        jwt.MapClaims
+		code          string
+		expectedError string
+	}{
+		{
+			name:   "Valid",
+			claims: jwt.MapClaims{"groups": []string{"eng", "ops"}},
+		},
+		{
+			name:          "Wrong Nonce",
+			claims:        jwt.MapClaims{"nonce": "replayed"},
+			expectedError: "verifying ID token: nonce does not match",
+		},
+		{
+			name:          "Other Audience",
+			claims:        jwt.MapClaims{"aud": "another-app"},
+			expectedError: "token has invalid audience",
+		},
+		{
+			name:          "Other Issuer",
+			claims:        jwt.MapClaims{"iss": "https://evil.example.com"},
+			expectedError: "token has invalid issuer",
+		},
+		{
+			name:          "Expired",
+			claims:        jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()},
+			expectedError: "token is expired",
+		},
+		{
+			name:          "No Subject",
+			claims:        jwt.MapClaims{"sub": ""},
+			expectedError: "verifying ID token: no subject",
+		},
+		{
+			name:          "Bad Code",
+			code:          "stolen",
+			expectedError: `exchanging code: provider answered 400 Bad Request: {"error":"invalid_grant"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestProvider(t)
+			p.sign(t, tc.claims)
+			code := tc.code
+			if code == "" {
+				code = "the-code"
+			}
+
+			id, err := p.client().Exchange(context.Background(), code, "the-verifier", "the-nonce")
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, p.URL, id.Issuer)
+			assert.Equal(t, "00u1", id.Subject)
+			assert.Equal(t, "ada@example.com", id.String("email"))
+			assert.Equal(t, []string{"eng", "ops"}, id.Strings("groups"))
+			assert.True(t, id.EmailVerified())
+		})
+	}
+}
+
+func TestProvider_UnknownKey(t *testing.T) {
+	p := newTestProvider(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": p.URL, "aud": "quanta", "sub": "00u1", "nonce": "the-nonce", "exp": time.Now().Add(time.Hour).Unix()})
+	token.Header["kid"] = "rotated"
+	p.idToken, err = token.SignedString(other)
+	require.NoError(t, err)
+
+	_, err = p.client().Exchange(context.Background(), "the-code", "the-verifier", "the-nonce")
+	assert.ErrorContains(t, err, `unknown signing key "rotated"`)
+}
+
+func TestIdentity_Claims(t *testing.T) {
+	id := Identity{Claims: map[string]any{
+		"groups":         "eng",
+		"email_verified": false,
+		"count":          float64(3),
+	}}
+	assert.Equal(t, []string{"eng"}, id.Strings("groups"))
+	assert.Nil(t, id.Strings("roles"))
+	assert.Empty(t, id.String("count"))
+	assert.False(t, id.EmailVerified())
+	assert.False(t, Identity{Claims: map[string]any{"email_verified": "yes"}}.EmailVerified())
+	assert.True(t, Identity{Claims: map[string]any{"email_verified": "true"}}.EmailVerified())
+	assert.False(t, Identity{}.EmailVerified(), "a missing claim is not a verification")
+}
+
+func TestKeyFits(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	assert.True(t, keyFits(&rsaKey.PublicKey, jwt.SigningMethodRS256))
+	assert.True(t, keyFits(&rsaKey.PublicKey, jwt.SigningMethodPS256))
+	assert.False(t, keyFits(&rsaKey.PublicKey, jwt.SigningMethodES256))
+	assert.True(t, keyFits(&ecKey.PublicKey, jwt.SigningMethodES256))
+	assert.False(t, keyFits(&ecKey.PublicKey, jwt.SigningMethodES384))
+}
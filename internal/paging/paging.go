@@ -0,0 +1,35 @@
+// Package paging reads the ?limit= and ?offset= query parameters that list
+// endpoints are paged with
+package paging
+
+import (
+	"strconv"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Parse reads the optional ?limit= and ?offset= query parameters. The
+// limit defaults to defaultLimit and is capped at maxLimit.
+func Parse(c *fiber.Ctx, defaultLimit, maxLimit int) (int, int, error) {
+	limit := defaultLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return 0, 0, apperr.New(fiber.StatusBadRequest, "Invalid limit")
+		}
+		limit = min(n, maxLimit)
+	}
+
+	offset := 0
+	if raw := c.Query("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return 0, 0, apperr.New(fiber.StatusBadRequest, "Invalid offset")
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
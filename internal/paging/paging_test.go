@@ -0,0 +1,52 @@
+package paging
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"quanta/internal/apperr"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name           string
+		query          string
+		expectedLimit  int
+		expectedOffset int
+		expectedError  string
+	}{
+		{name: "Defaults", query: "", expectedLimit: 50},
+		{name: "Limit And Offset", query: "?limit=10&offset=20", expectedLimit: 10, expectedOffset: 20},
+		{name: "Limit Capped", query: "?limit=1000", expectedLimit: 200},
+		{name: "Zero Limit", query: "?limit=0", expectedError: "Invalid limit"},
+		{name: "Negative Offset", query: "?offset=-1", expectedError: "Invalid offset"},
+		{name: "Not A Number", query: "?limit=ten", expectedError: "Invalid limit"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/", func(c *fiber.Ctx) error {
+				limit, offset, err := Parse(c, 50, 200)
+				if tc.expectedError != "" {
+					var appErr *apperr.Error
+					if assert.ErrorAs(t, err, &appErr) {
+						assert.Equal(t, fiber.StatusBadRequest, appErr.Code)
+						assert.Equal(t, tc.expectedError, appErr.Message)
+					}
+					return nil
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedLimit, limit)
+				assert.Equal(t, tc.expectedOffset, offset)
+				return nil
+			})
+
+			_, err := app.Test(httptest.NewRequest("GET", "/"+tc.query, nil))
+			assert.NoError(t, err)
+		})
+	}
+}
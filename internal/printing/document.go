@@ -0,0 +1,118 @@
+package printing
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+
+	"quanta/internal/sanitize"
+)
+
+// Document is what goes into a printed note
+type Document struct {
+	Title     string
+	Author    string
+	Content   string
+	Version   int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Attachments are listed in an appendix after the note
+	Attachments []Attachment
+}
+
+// Attachment is a file listed in a printed note's appendix
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Size        int64
+}
+
+var page = template.Must(template.New("note").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04 UTC") },
+	"size": humanSize,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 11pt; line-height: 1.5; color: #222; margin: 0; }
+header { border-bottom: 1px solid #ccc; margin-bottom: 1.5em; }
+header h1 { margin: 0 0 0.3em; }
+dl.meta { display: grid; grid-template-columns: max-content auto; gap: 0 1em; font-size: 9pt; color: #666; }
+dl.meta dt, dl.meta dd { margin: 0; }
+pre { background: #f5f5f5; padding: 0.6em; white-space: pre-wrap; }
+code { font-family: Menlo, Consolas, monospace; font-size: 9.5pt; }
+blockquote { border-left: 3px solid #ccc; margin-left: 0; padding-left: 1em; color: #555; }
+li.task { list-style: none; }
+li.done { color: #777; }
+section.appendix { page-break-before: always; }
+table { border-collapse: collapse; width: 100%; font-size: 9pt; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 0.5em; text-align: left; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Title}}</h1>
+<dl class="meta">
+{{with .Author}}<dt>Author</dt><dd>{{.}}</dd>{{end}}
+<dt>Created</dt><dd>{{date .CreatedAt}}</dd>
+<dt>Updated</dt><dd>{{date .UpdatedAt}}</dd>
+<dt>Version</dt><dd>{{.Version}}</dd>
+</dl>
+</header>
+<main>
+{{.Body}}
+</main>
+{{with .Attachments}}<section class="appendix">
+<h2>Attachments</h2>
+<table>
+<thead><tr><th>File</th><th>Type</th><th>Size</th></tr></thead>
+<tbody>
+{{range .}}<tr><td>{{.Filename}}</td><td>{{.ContentType}}</td><td>{{size .Size}}</td></tr>
+{{end}}</tbody>
+</table>
+</section>{{end}}
+</body>
+</html>
+`))
+
+// HTML lays the document out as a page ready to be printed, with HTML in
+// its content sanitized under policy
+func (d Document) HTML(policy sanitize.Policy) ([]byte, error) {
+	title := d.Title
+	if title == "" {
+		title = "Untitled"
+	}
+	var buf bytes.Buffer
+	err := page.Execute(&buf, struct {
+		Document
+		Title string
+		Body  template.HTML
+	}{
+		Document: d,
+		Title:    title,
+		// markdownToHTML escapes or sanitizes everything it doesn't render
+		// itself
+		Body: template.HTML(markdownToHTML(d.Content, policy)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("laying out note: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// humanSize formats a size in bytes the way file listings do
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,74 @@
+package printing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxPDFSize is the largest PDF accepted from a renderer
+const MaxPDFSize = 64 << 20
+
+// Gotenberg renders pages to PDF with a Gotenberg server's Chromium module
+// (https://gotenberg.dev)
+type Gotenberg struct {
+	// URL is the server's base URL
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewGotenberg creates a renderer for the Gotenberg server at baseURL
+func NewGotenberg(baseURL string) *Gotenberg {
+	return &Gotenberg{
+		URL:        strings.TrimRight(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// Render implements Renderer
+func (g *Gotenberg) Render(ctx context.Context, page []byte) ([]byte, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("files", "index.html")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(page); err != nil {
+		return nil, err
+	}
+	if err := form.WriteField("printBackground", "true"); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.URL+"/forms/chromium/convert/html", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := g.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gotenberg answered %s", resp.Status)
+	}
+
+	pdf, err := io.ReadAll(io.LimitReader(resp.Body, MaxPDFSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading gotenberg response: %w", err)
+	}
+	if len(pdf) > MaxPDFSize {
+		return nil, fmt.Errorf("gotenberg answered with more than %d bytes", MaxPDFSize)
+	}
+	return pdf, nil
+}
@@ -0,0 +1,42 @@
+package printing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGotenberg_Render(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/forms/chromium/convert/html", r.URL.Path)
+		file, header, err := r.FormFile("files")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "index.html", header.Filename)
+		page, _ := io.ReadAll(file)
+		assert.Equal(t, "<p>hi</p>", string(page))
+		w.Header().Set("Content-Type", "application/pdf")
+		_, _ = w.Write([]byte("%PDF-1.7"))
+	}))
+	defer server.Close()
+
+	pdf, err := NewGotenberg(server.URL+"/").Render(context.Background(), []byte("<p>hi</p>"))
+	require.NoError(t, err)
+	assert.Equal(t, "%PDF-1.7", string(pdf))
+}
+
+func TestGotenberg_ServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := NewGotenberg(server.URL).Render(context.Background(), []byte("<p>hi</p>"))
+	assert.EqualError(t, err, "gotenberg answered 503 Service Unavailable")
+}
@@ -0,0 +1,200 @@
+package printing
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"quanta/internal/sanitize"
+)
+
+var (
+	heading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)(?:\s+#+)?\s*$`)
+	rule     = regexp.MustCompile(`^([-*_])(?:\s*([-*_])){2,}$`)
+	task     = regexp.MustCompile(`^[-*+]\s+\[([ xX])\]\s+(.*)$`)
+	bullet   = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	numbered = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+
+	codeSpan = regexp.MustCompile("`([^`]+)`")
+	link     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	autolink = regexp.MustCompile(`&lt;((?:https?://|mailto:)[^\s]+?)&gt;`)
+	strong   = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	em       = regexp.MustCompile(`\*([^*]+)\*`)
+	emWord   = regexp.MustCompile(`(^|\W)_([^_]+)_(\W|$)`)
+	strike   = regexp.MustCompile(`~~([^~]+)~~`)
+
+	htmlBlock = regexp.MustCompile(`^</?[a-zA-Z][a-zA-Z0-9]*(?:[\s/>]|$)`)
+)
+
+// markdownToHTML renders a note's Markdown as HTML for printing. It covers
+// what the editor writes: headings, paragraphs, block quotes, fenced code,
+// bulleted, numbered and task lists, rules, and inline emphasis, code and
+// links. A paragraph that starts with an HTML tag is run through policy;
+// anything else, and raw HTML when policy is sanitize.Off, is escaped and
+// printed as written, so the result is safe to embed.
+func markdownToHTML(src string, policy sanitize.Policy) string {
+	r := mdRenderer{policy: policy}
+	for _, line := range strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n") {
+		r.line(line)
+	}
+	r.flush()
+	if r.code {
+		r.b.WriteString("</code></pre>\n")
+	}
+	return r.b.String()
+}
+
+// mdRenderer holds the blocks being built as lines are read
+type mdRenderer struct {
+	policy sanitize.Policy
+	b      strings.Builder
+	para   []string
+	quote  []string
+	list   string
+	code   bool
+}
+
+func (r *mdRenderer) line(line string) {
+	trimmed := strings.TrimSpace(line)
+	if r.code {
+		if strings.HasPrefix(trimmed, "```") {
+			r.b.WriteString("</code></pre>\n")
+			r.code = false
+		} else {
+			r.b.WriteString(html.EscapeString(line) + "\n")
+		}
+		return
+	}
+
+	// Block quotes are gathered and rendered as Markdown of their own
+	if rest, ok := strings.CutPrefix(trimmed, ">"); ok {
+		r.flushPara()
+		r.closeList()
+		r.quote = append(r.quote, strings.TrimPrefix(rest, " "))
+		return
+	}
+	r.flushQuote()
+
+	switch {
+	case trimmed == "":
+		r.flush()
+	case strings.HasPrefix(trimmed, "```"):
+		r.flush()
+		r.b.WriteString("<pre><code>")
+		r.code = true
+	case heading.MatchString(trimmed):
+		r.flush()
+		m := heading.FindStringSubmatch(trimmed)
+		level := string(rune('0' + len(m[1])))
+		r.b.WriteString("<h" + level + ">" + inline(m[2]) + "</h" + level + ">\n")
+	case rule.MatchString(trimmed):
+		r.flush()
+		r.b.WriteString("<hr>\n")
+	case task.MatchString(trimmed):
+		m := task.FindStringSubmatch(trimmed)
+		if m[1] == " " {
+			r.item("ul", `<li class="task"><span class="box">&#9744;</span> `+inline(m[2])+"</li>")
+		} else {
+			r.item("ul", `<li class="task done"><span class="box">&#9745;</span> `+inline(m[2])+"</li>")
+		}
+	case bullet.MatchString(trimmed):
+		r.item("ul", "<li>"+inline(bullet.FindStringSubmatch(trimmed)[1])+"</li>")
+	case numbered.MatchString(trimmed):
+		r.item("ol", "<li>"+inline(numbered.FindStringSubmatch(trimmed)[1])+"</li>")
+	default:
+		r.closeList()
+		r.para = append(r.para, trimmed)
+	}
+}
+
+// item adds a list item, starting a list of kind when needed
+func (r *mdRenderer) item(kind, li string) {
+	r.flushPara()
+	if r.list != kind {
+		r.closeList()
+		r.b.WriteString("<" + kind + ">\n")
+		r.list = kind
+	}
+	r.b.WriteString(li + "\n")
+}
+
+func (r *mdRenderer) flush() {
+	r.flushPara()
+	r.closeList()
+	r.flushQuote()
+}
+
+// flushPara writes the paragraph being gathered. Notes are written a line
+// at a time, so their line breaks are kept.
+func (r *mdRenderer) flushPara() {
+	if len(r.para) == 0 {
+		return
+	}
+	if r.policy != sanitize.Off && htmlBlock.MatchString(r.para[0]) {
+		r.b.WriteString("<div>" + r.policy.HTML(strings.Join(r.para, "\n")) + "</div>\n")
+		r.para = nil
+		return
+	}
+	lines := make([]string, len(r.para))
+	for i, l := range r.para {
+		lines[i] = inline(l)
+	}
+	r.b.WriteString("<p>" + strings.Join(lines, "<br>\n") + "</p>\n")
+	r.para = nil
+}
+
+func (r *mdRenderer) closeList() {
+	if r.list != "" {
+		r.b.WriteString("</" + r.list + ">\n")
+		r.list = ""
+	}
+}
+
+func (r *mdRenderer) flushQuote() {
+	if len(r.quote) == 0 {
+		return
+	}
+	r.b.WriteString("<blockquote>\n" + markdownToHTML(strings.Join(r.quote, "\n"), r.policy) + "</blockquote>\n")
+	r.quote = nil
+}
+
+// inline renders the inline formatting of a line of text. Code spans are
+// cut out first so nothing inside them is formatted.
+func inline(s string) string {
+	var b strings.Builder
+	for {
+		loc := codeSpan.FindStringSubmatchIndex(s)
+		if loc == nil {
+			b.WriteString(format(s))
+			return b.String()
+		}
+		b.WriteString(format(s[:loc[0]]))
+		b.WriteString("<code>" + html.EscapeString(s[loc[2]:loc[3]]) + "</code>")
+		s = s[loc[1]:]
+	}
+}
+
+// format escapes text and renders its links, emphasis and strikethrough.
+// Links that don't use http, https or mailto are left as text.
+func format(s string) string {
+	s = html.EscapeString(s)
+	s = link.ReplaceAllStringFunc(s, func(m string) string {
+		parts := link.FindStringSubmatch(m)
+		if !safeURL(parts[2]) {
+			return m
+		}
+		return `<a href="` + parts[2] + `">` + parts[1] + "</a>"
+	})
+	s = autolink.ReplaceAllString(s, `<a href="$1">$1</a>`)
+	s = strong.ReplaceAllString(s, "<strong>$1$2</strong>")
+	s = em.ReplaceAllString(s, "<em>$1</em>")
+	s = emWord.ReplaceAllString(s, "$1<em>$2</em>$3")
+	return strike.ReplaceAllString(s, "<del>$1</del>")
+}
+
+// safeURL reports whether an escaped link target is one a printed note
+// may point at
+func safeURL(escaped string) bool {
+	u := strings.ToLower(html.UnescapeString(escaped))
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "mailto:")
+}
@@ -0,0 +1,89 @@
+package printing
+
+import (
+	"testing"
+	"time"
+
+	"quanta/internal/sanitize"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkdownToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"Heading", "## Plan ##", "<h2>Plan</h2>\n"},
+		{"Paragraph Keeps Line Breaks", "one\ntwo\n\nthree", "<p>one<br>\ntwo</p>\n<p>three</p>\n"},
+		{"Emphasis", "**bold**, *it*, _it_ and ~~gone~~", "<p><strong>bold</strong>, <em>it</em>, <em>it</em> and <del>gone</del></p>\n"},
+		{"Snake Case Left Alone", "call my_var_name", "<p>call my_var_name</p>\n"},
+		{"Code Span", "run `a **b** <c>`", "<p>run <code>a **b** &lt;c&gt;</code></p>\n"},
+		{"Fenced Code", "```go\nx := <-ch\n```", "<pre><code>x := &lt;-ch\n</code></pre>\n"},
+		{"Lists", "- a\n- b\n1. c", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n<ol>\n<li>c</li>\n</ol>\n"},
+		{"Tasks", "- [ ] milk\n- [x] eggs", "<ul>\n" +
+			`<li class="task"><span class="box">&#9744;</span> milk</li>` + "\n" +
+			`<li class="task done"><span class="box">&#9745;</span> eggs</li>` + "\n</ul>\n"},
+		{"Quote", "> **note**\n> more", "<blockquote>\n<p><strong>note</strong><br>\nmore</p>\n</blockquote>\n"},
+		{"Rule", "a\n\n---", "<p>a</p>\n<hr>\n"},
+		{"Links", "[site](https://example.com/?a=1&b=2) <mailto:a@example.com>",
+			`<p><a href="https://example.com/?a=1&amp;b=2">site</a> <a href="mailto:a@example.com">mailto:a@example.com</a></p>` + "\n"},
+		{"Unsafe Link", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>\n"},
+		{"Raw HTML Escaped", `<script>alert("x")</script>`, "<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>\n"},
+		{"Unclosed Fence", "```\ncode", "<pre><code>code\n</code></pre>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, markdownToHTML(tt.in, sanitize.Off))
+		})
+	}
+}
+
+func TestMarkdownToHTML_Policy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy sanitize.Policy
+		in     string
+		want   string
+	}{
+		{"HTML Block Sanitized", sanitize.Basic, `<p onclick="x()">hi <b>there</b></p><script>alert(1)</script>`, "<div><p>hi <b>there</b></p></div>\n"},
+		{"Images Dropped When Strict", sanitize.Strict, `<img src="https://example.com/a.png"> caption`, "<div> caption</div>\n"},
+		{"Plain Text Still Escaped", sanitize.Basic, "if x<y { <textarea", "<p>if x&lt;y { &lt;textarea</p>\n"},
+		{"Code Still Escaped", sanitize.Basic, "```\n<script>x</script>\n```", "<pre><code>&lt;script&gt;x&lt;/script&gt;\n</code></pre>\n"},
+		{"Quoted HTML", sanitize.Basic, "> <em>a</em>", "<blockquote>\n<div><em>a</em></div>\n</blockquote>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, markdownToHTML(tt.in, tt.policy))
+		})
+	}
+}
+
+func TestDocument_HTML(t *testing.T) {
+	doc := Document{
+		Title:     "<Plans>",
+		Author:    "Ada",
+		Content:   "# Hello",
+		Version:   3,
+		CreatedAt: time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC),
+		Attachments: []Attachment{
+			{Filename: "a.png", ContentType: "image/png", Size: 1536},
+		},
+	}
+	page, err := doc.HTML(sanitize.Basic)
+	require.NoError(t, err)
+	html := string(page)
+	assert.Contains(t, html, "<h1>&lt;Plans&gt;</h1>")
+	assert.Contains(t, html, "<dt>Author</dt><dd>Ada</dd>")
+	assert.Contains(t, html, "<dd>2024-03-01 09:30 UTC</dd>")
+	assert.Contains(t, html, "<h1>Hello</h1>")
+	assert.Contains(t, html, "<td>a.png</td><td>image/png</td><td>1.5 KiB</td>")
+
+	page, err = Document{}.HTML(sanitize.Basic)
+	require.NoError(t, err)
+	assert.Contains(t, string(page), "<h1>Untitled</h1>")
+	assert.NotContains(t, string(page), "Attachments")
+}
@@ -0,0 +1,447 @@
+// Package printing exports notes as PDF. A note's Markdown is laid out as
+// an HTML page with its title, a header of who wrote it and when, and an
+// appendix listing its attachments, which a Renderer turns into a PDF.
+// Large notes are rendered in the background and fetched once ready.
+package printing
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"quanta/internal/access"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/sanitize"
+	"quanta/internal/storage"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	// AsyncThreshold is the content size, in bytes, above which a note is
+	// rendered in the background rather than during the request
+	AsyncThreshold = 64 << 10
+	// PDFTTL is how long a PDF rendered in the background can be
+	// downloaded before it is deleted
+	PDFTTL = 24 * time.Hour
+	// renderTimeout bounds rendering a PDF in the background
+	renderTimeout = 5 * time.Minute
+)
+
+// Renderer turns an HTML page into a PDF. It is implemented by *Gotenberg.
+type Renderer interface {
+	Render(ctx context.Context, page []byte) ([]byte, error)
+}
+
+// Status is how far along a background render is
+type Status string
+
+const (
+	// Running PDFs are still being rendered
+	Running Status = "running"
+	// Done PDFs can be downloaded until they expire
+	Done Status = "done"
+	// Failed PDFs could not be rendered; Error says why
+	Failed Status = "failed"
+)
+
+// PDF is a note's PDF rendered in the background and its progress.
+// DownloadURL is set once the file is ready.
+type PDF struct {
+	ID          string     `json:"id"`
+	NoteID      string     `json:"note_id"`
+	Status      Status     `json:"status"`
+	Size        int64      `json:"size"`
+	Error       *string    `json:"error"`
+	StatusURL   string     `json:"status_url"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Handler handles HTTP requests related to printing notes
+type Handler struct {
+	db       DBInterface
+	store    storage.Storage
+	renderer Renderer
+	html     sanitize.Policy
+	// async renders large notes in the background
+	async func(func())
+}
+
+// NewHandler creates a new Handler that renders PDFs with renderer and
+// keeps those rendered in the background in store. A nil renderer turns
+// PDF export off. HTML in note content is sanitized under html as the
+// note is laid out.
+func NewHandler(db DBInterface, store storage.Storage, renderer Renderer, html sanitize.Policy) *Handler {
+	return &Handler{
+		db:       db,
+		store:    store,
+		renderer: renderer,
+		html:     html,
+		async:    func(run func()) { go run() },
+	}
+}
+
+// GetPDF renders a note as PDF. Notes up to AsyncThreshold are sent
+// straight away; larger ones, or any with ?async=true, are rendered in
+// the background and answered with the PDF to poll. A note has one render
+// running per user at a time.
+func (h *Handler) GetPDF(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	if h.renderer == nil {
+		return apperr.New(fiber.StatusNotImplemented, "PDF export is not enabled")
+	}
+	noteID := c.Params("id")
+	ctx := c.UserContext()
+	if err := h.requireNote(ctx, noteID, userID); err != nil {
+		return err
+	}
+
+	doc, size, err := h.document(ctx, noteID)
+	if err != nil {
+		return err
+	}
+
+	if size <= AsyncThreshold && !c.QueryBool("async") {
+		pdf, err := h.render(ctx, doc)
+		if err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "application/pdf")
+		c.Set(fiber.HeaderContentDisposition, "attachment; filename="+strconv.Quote(filename(doc.Title)))
+		return c.Send(pdf)
+	}
+
+	// Renders older than renderTimeout were cut off, say by a restart, and
+	// are not waited on
+	var runningID string
+	err = h.db.QueryRowContext(ctx,
+		"SELECT id FROM note_pdfs WHERE note_id = ? AND user_id = ? AND status = ? AND created_at > ?",
+		noteID, userID, string(Running), time.Now().UTC().Add(-renderTimeout),
+	).Scan(&runningID)
+	switch {
+	case err == nil:
+		pdf, _, err := h.pdf(ctx, runningID, noteID, userID)
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusAccepted).JSON(pdf)
+	case !errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("checking running PDFs: %w", err)
+	}
+
+	pdf := PDF{
+		ID:        uuid.New().String(),
+		NoteID:    noteID,
+		Status:    Running,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	pdf.StatusURL = "/notes/" + noteID + "/pdf/" + pdf.ID
+	// A render that never finishes is purged like any other PDF
+	expiresAt := pdf.CreatedAt.Add(PDFTTL)
+	pdf.ExpiresAt = &expiresAt
+	key := "pdfs/" + userID + "/" + pdf.ID + ".pdf"
+	_, err = h.db.ExecContext(ctx,
+		"INSERT INTO note_pdfs (id, note_id, user_id, status, storage_key, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		pdf.ID, noteID, userID, string(pdf.Status), key, pdf.CreatedAt, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("creating PDF: %w", err)
+	}
+
+	h.async(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), renderTimeout)
+		defer cancel()
+		h.run(ctx, pdf.ID, doc, key)
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(pdf)
+}
+
+// run renders and stores a PDF in the background and records the outcome
+func (h *Handler) run(ctx context.Context, id string, doc Document, key string) {
+	pdf, err := h.render(ctx, doc)
+	if err == nil {
+		err = h.store.Put(ctx, key, bytes.NewReader(pdf), int64(len(pdf)), "application/pdf")
+	}
+
+	now := time.Now().UTC()
+	if err != nil {
+		log.Printf("Error rendering PDF %s: %v", id, err)
+		_, err = h.db.ExecContext(ctx,
+			"UPDATE note_pdfs SET status = ?, error = ?, finished_at = ? WHERE id = ?",
+			string(Failed), err.Error(), now, id,
+		)
+	} else {
+		_, err = h.db.ExecContext(ctx,
+			"UPDATE note_pdfs SET status = ?, size = ?, finished_at = ?, expires_at = ? WHERE id = ?",
+			string(Done), len(pdf), now, now.Add(PDFTTL), id,
+		)
+	}
+	if err != nil {
+		log.Printf("Error finishing PDF %s: %v", id, err)
+	}
+}
+
+// GetPDFStatus returns a PDF being rendered in the background with its
+// progress
+func (h *Handler) GetPDFStatus(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	pdf, _, err := h.pdf(c.UserContext(), c.Params("pdfId"), c.Params("id"), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(pdf)
+}
+
+// DownloadPDF streams a PDF rendered in the background. Access to the note
+// is checked again, so a user removed from it can no longer download it.
+func (h *Handler) DownloadPDF(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+	ctx := c.UserContext()
+	if err := h.requireNote(ctx, noteID, userID); err != nil {
+		return err
+	}
+	pdf, key, err := h.pdf(ctx, c.Params("pdfId"), noteID, userID)
+	if err != nil {
+		return err
+	}
+	if pdf.Status != Done {
+		return apperr.New(fiber.StatusConflict, "PDF is not ready")
+	}
+	if pdf.ExpiresAt != nil && !time.Now().Before(*pdf.ExpiresAt) {
+		return apperr.New(fiber.StatusGone, "PDF has expired")
+	}
+
+	var title string
+	if err := h.db.QueryRowContext(ctx, "SELECT title FROM notes WHERE id = ?", noteID).Scan(&title); err != nil {
+		return fmt.Errorf("fetching note title: %w", err)
+	}
+
+	body, err := h.store.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return apperr.New(fiber.StatusGone, "PDF has expired")
+		}
+		return fmt.Errorf("fetching PDF: %w", err)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, "attachment; filename="+strconv.Quote(filename(title)))
+	// SendStream closes the body once it has been written
+	return c.SendStream(body, int(pdf.Size))
+}
+
+// pdf fetches one of the user's PDFs of a note and its storage key
+func (h *Handler) pdf(ctx context.Context, id, noteID, userID string) (PDF, string, error) {
+	var pdf PDF
+	var key string
+	var reason sql.NullString
+	var finishedAt, expiresAt sql.NullTime
+	err := h.db.QueryRowContext(ctx,
+		"SELECT id, note_id, status, size, error, storage_key, created_at, finished_at, expires_at FROM note_pdfs WHERE id = ? AND note_id = ? AND user_id = ?",
+		id, noteID, userID,
+	).Scan(&pdf.ID, &pdf.NoteID, &pdf.Status, &pdf.Size, &reason, &key, &pdf.CreatedAt, &finishedAt, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return pdf, "", apperr.New(fiber.StatusNotFound, "PDF not found")
+		}
+		return pdf, "", fmt.Errorf("fetching PDF: %w", err)
+	}
+	if reason.Valid {
+		pdf.Error = &reason.String
+	}
+	if finishedAt.Valid {
+		pdf.FinishedAt = &finishedAt.Time
+	}
+	if expiresAt.Valid {
+		pdf.ExpiresAt = &expiresAt.Time
+	}
+	pdf.StatusURL = "/notes/" + pdf.NoteID + "/pdf/" + pdf.ID
+	if pdf.Status == Done {
+		pdf.DownloadURL = pdf.StatusURL + "/download"
+	}
+	return pdf, key, nil
+}
+
+// requireNote checks the user may open the note
+func (h *Handler) requireNote(ctx context.Context, noteID, userID string) error {
+	allowed, err := access.CanOpen(ctx, h.db, noteID, userID)
+	if err != nil {
+		return fmt.Errorf("checking note access: %w", err)
+	}
+	if !allowed {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+	return nil
+}
+
+// document loads what goes into a note's PDF and the size of its content.
+// Quarantined attachments are left out of the appendix.
+func (h *Handler) document(ctx context.Context, noteID string) (Document, int64, error) {
+	var doc Document
+	var author sql.NullString
+	var encrypted bool
+	var size int64
+	err := h.db.QueryRowContext(ctx,
+		"SELECT n.title, n.content, n.encrypted, n.size, n.version, n.created_at, n.updated_at, u.display_name FROM notes n JOIN users u ON u.id = n.user_id WHERE n.id = ?",
+		noteID,
+	).Scan(&doc.Title, &doc.Content, &encrypted, &size, &doc.Version, &doc.CreatedAt, &doc.UpdatedAt, &author)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return doc, 0, apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+		}
+		return doc, 0, fmt.Errorf("fetching note: %w", err)
+	}
+	// The server can't read ciphertext to lay it out
+	if encrypted {
+		return doc, 0, apperr.New(fiber.StatusBadRequest, "Encrypted notes cannot be printed by the server")
+	}
+	doc.Author = author.String
+
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT filename, content_type, size FROM attachments WHERE note_id = ? AND status <> 'quarantined' ORDER BY created_at",
+		noteID,
+	)
+	if err != nil {
+		return doc, 0, fmt.Errorf("fetching attachments: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.Filename, &a.ContentType, &a.Size); err != nil {
+			return doc, 0, fmt.Errorf("scanning attachment: %w", err)
+		}
+		doc.Attachments = append(doc.Attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return doc, 0, fmt.Errorf("fetching attachments: %w", err)
+	}
+	return doc, size, nil
+}
+
+// render lays out and renders a document
+func (h *Handler) render(ctx context.Context, doc Document) ([]byte, error) {
+	page, err := doc.HTML(h.html)
+	if err != nil {
+		return nil, err
+	}
+	pdf, err := h.renderer.Render(ctx, page)
+	if err != nil {
+		return nil, fmt.Errorf("rendering PDF: %w", err)
+	}
+	return pdf, nil
+}
+
+// filename names a note's PDF after its title, keeping only characters
+// that are safe in a file name
+func filename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_':
+			return r
+		case unicode.IsSpace(r) || r == '.':
+			return ' '
+		default:
+			return -1
+		}
+	}, title)
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		name = "note"
+	}
+	return name + ".pdf"
+}
+
+// PurgeExpired deletes the files and records of PDFs that expired before
+// now and returns how many went
+func PurgeExpired(ctx context.Context, db DBInterface, store storage.Storage, now time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, storage_key FROM note_pdfs WHERE expires_at < ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("listing expired PDFs: %w", err)
+	}
+	type expired struct{ id, key string }
+	var pdfs []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.key); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("listing expired PDFs: %w", err)
+		}
+		pdfs = append(pdfs, e)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, fmt.Errorf("listing expired PDFs: %w", err)
+	}
+
+	purged := 0
+	for _, e := range pdfs {
+		// The record goes only once the file has, so a failed delete is
+		// retried on the next run
+		if err := store.Delete(ctx, e.key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error deleting PDF %s: %v", e.id, err)
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM note_pdfs WHERE id = ?", e.id); err != nil {
+			return purged, fmt.Errorf("deleting expired PDF: %w", err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// StartPurger runs PurgeExpired every interval until stop is closed
+// Nothing is purged while paused reports true.
+func StartPurger(db DBInterface, store storage.Storage, interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			purged, err := PurgeExpired(ctx, db, store, now)
+			cancel()
+			if err != nil {
+				log.Println("Error purging expired PDFs:", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d expired PDFs", purged)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
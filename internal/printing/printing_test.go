@@ -0,0 +1,292 @@
+package printing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/sanitize"
+	"quanta/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	accessQuery   = regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)))")
+	noteQuery     = regexp.QuoteMeta("SELECT n.title, n.content, n.encrypted, n.size, n.version, n.created_at, n.updated_at, u.display_name FROM notes n JOIN users u ON u.id = n.user_id WHERE n.id = ?")
+	noteCols      = []string{"title", "content", "encrypted", "size", "version", "created_at", "updated_at", "display_name"}
+	filesQuery    = regexp.QuoteMeta("SELECT filename, content_type, size FROM attachments WHERE note_id = ? AND status <> 'quarantined' ORDER BY created_at")
+	pdfQuery      = regexp.QuoteMeta("SELECT id, note_id, status, size, error, storage_key, created_at, finished_at, expires_at FROM note_pdfs WHERE id = ? AND note_id = ? AND user_id = ?")
+	pdfCols       = []string{"id", "note_id", "status", "size", "error", "storage_key", "created_at", "finished_at", "expires_at"}
+	runningQuery  = regexp.QuoteMeta("SELECT id FROM note_pdfs WHERE note_id = ? AND user_id = ? AND status = ? AND created_at > ?")
+	noteCreatedAt = time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+)
+
+// fakeRenderer returns the page it was given as the PDF, or fails with err
+type fakeRenderer struct {
+	err error
+}
+
+func (r fakeRenderer) Render(_ context.Context, page []byte) ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return append([]byte("%PDF "), page...), nil
+}
+
+type testHelper struct {
+	mockDB  sqlmock.Sqlmock
+	store   *storage.Local
+	handler *Handler
+	app     *fiber.App
+}
+
+func newTestHelper(t *testing.T, renderer Renderer) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	handler := NewHandler(db, store, renderer, sanitize.Basic)
+	handler.async = func(run func()) { run() }
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/notes/:id/pdf", handler.GetPDF)
+	app.Get("/notes/:id/pdf/:pdfId", handler.GetPDFStatus)
+	app.Get("/notes/:id/pdf/:pdfId/download", handler.DownloadPDF)
+
+	return &testHelper{mockDB: mockDB, store: store, handler: handler, app: app}
+}
+
+func (h *testHelper) expectAccess(allowed bool) {
+	h.mockDB.ExpectQuery(accessQuery).
+		WithArgs("note1", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(allowed))
+}
+
+func (h *testHelper) expectNote(size int64, encrypted bool) {
+	h.mockDB.ExpectQuery(noteQuery).WithArgs("note1").
+		WillReturnRows(sqlmock.NewRows(noteCols).
+			AddRow("Trip: Rome", "# Day 1\n- [x] Colosseum", encrypted, size, 4, noteCreatedAt, noteCreatedAt, "Ada"))
+	if encrypted {
+		return
+	}
+	h.mockDB.ExpectQuery(filesQuery).WithArgs("note1").
+		WillReturnRows(sqlmock.NewRows([]string{"filename", "content_type", "size"}).AddRow("ticket.pdf", "application/pdf", 2048))
+}
+
+func TestGetPDF(t *testing.T) {
+	t.Run("Small Note", func(t *testing.T) {
+		h := newTestHelper(t, fakeRenderer{})
+		h.expectAccess(true)
+		h.expectNote(100, false)
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="Trip Rome.pdf"`, resp.Header.Get("Content-Disposition"))
+		body, _ := io.ReadAll(resp.Body)
+		assert.True(t, strings.HasPrefix(string(body), "%PDF "))
+		assert.Contains(t, string(body), "<h1>Day 1</h1>")
+		assert.Contains(t, string(body), "<td>ticket.pdf</td>")
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Large Note Rendered In Background", func(t *testing.T) {
+		h := newTestHelper(t, fakeRenderer{})
+		h.expectAccess(true)
+		h.expectNote(AsyncThreshold+1, false)
+		h.mockDB.ExpectQuery(runningQuery).WithArgs("note1", "user123", "running", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO note_pdfs (id, note_id, user_id, status, storage_key, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)")).
+			WithArgs(sqlmock.AnyArg(), "note1", "user123", "running", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE note_pdfs SET status = ?, size = ?, finished_at = ?, expires_at = ? WHERE id = ?")).
+			WithArgs("done", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+		var pdf PDF
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&pdf))
+		assert.Equal(t, Running, pdf.Status)
+		assert.Equal(t, "/notes/note1/pdf/"+pdf.ID, pdf.StatusURL)
+
+		stored, err := h.store.Get(context.Background(), "pdfs/user123/"+pdf.ID+".pdf")
+		require.NoError(t, err)
+		_ = stored.Close()
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Render Already Running", func(t *testing.T) {
+		h := newTestHelper(t, fakeRenderer{})
+		h.expectAccess(true)
+		h.expectNote(10, false)
+		h.mockDB.ExpectQuery(runningQuery).WithArgs("note1", "user123", "running", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("pdf1"))
+		h.mockDB.ExpectQuery(pdfQuery).WithArgs("pdf1", "note1", "user123").
+			WillReturnRows(sqlmock.NewRows(pdfCols).AddRow("pdf1", "note1", "running", 0, nil, "pdfs/user123/pdf1.pdf", noteCreatedAt, nil, nil))
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf?async=true", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+		var pdf PDF
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&pdf))
+		assert.Equal(t, "pdf1", pdf.ID)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Render Fails", func(t *testing.T) {
+		h := newTestHelper(t, fakeRenderer{err: errors.New("gotenberg answered 503 Service Unavailable")})
+		h.expectAccess(true)
+		h.expectNote(AsyncThreshold+1, false)
+		h.mockDB.ExpectQuery(runningQuery).WithArgs("note1", "user123", "running", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO note_pdfs")).WillReturnResult(sqlmock.NewResult(0, 1))
+		h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE note_pdfs SET status = ?, error = ?, finished_at = ? WHERE id = ?")).
+			WithArgs("failed", "rendering PDF: gotenberg answered 503 Service Unavailable", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		h := newTestHelper(t, fakeRenderer{})
+		h.expectAccess(true)
+		h.expectNote(100, true)
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("No Access", func(t *testing.T) {
+		h := newTestHelper(t, fakeRenderer{})
+		h.expectAccess(false)
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		h := newTestHelper(t, nil)
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusNotImplemented, resp.StatusCode)
+	})
+}
+
+func TestDownloadPDF(t *testing.T) {
+	finished := time.Now().UTC().Add(-time.Minute)
+
+	t.Run("Done", func(t *testing.T) {
+		h := newTestHelper(t, fakeRenderer{})
+		require.NoError(t, h.store.Put(context.Background(), "pdfs/user123/pdf1.pdf", strings.NewReader("%PDF"), 4, "application/pdf"))
+		h.expectAccess(true)
+		h.mockDB.ExpectQuery(pdfQuery).WithArgs("pdf1", "note1", "user123").
+			WillReturnRows(sqlmock.NewRows(pdfCols).AddRow("pdf1", "note1", "done", 4, nil, "pdfs/user123/pdf1.pdf", noteCreatedAt, finished, finished.Add(PDFTTL)))
+		h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT title FROM notes WHERE id = ?")).WithArgs("note1").
+			WillReturnRows(sqlmock.NewRows([]string{"title"}).AddRow("Trip"))
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf/pdf1/download", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Equal(t, `attachment; filename="Trip.pdf"`, resp.Header.Get("Content-Disposition"))
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "%PDF", string(body))
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Not Ready", func(t *testing.T) {
+		h := newTestHelper(t, fakeRenderer{})
+		h.expectAccess(true)
+		h.mockDB.ExpectQuery(pdfQuery).WithArgs("pdf1", "note1", "user123").
+			WillReturnRows(sqlmock.NewRows(pdfCols).AddRow("pdf1", "note1", "running", 0, nil, "pdfs/user123/pdf1.pdf", noteCreatedAt, nil, nil))
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf/pdf1/download", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusConflict, resp.StatusCode)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		h := newTestHelper(t, fakeRenderer{})
+		h.expectAccess(true)
+		h.mockDB.ExpectQuery(pdfQuery).WithArgs("pdf1", "note1", "user123").
+			WillReturnRows(sqlmock.NewRows(pdfCols).AddRow("pdf1", "note1", "done", 4, nil, "pdfs/user123/pdf1.pdf", noteCreatedAt, finished, finished))
+
+		resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf/pdf1/download", nil))
+		require.NoError(t, err)
+		assert.Equal(t, fiber.StatusGone, resp.StatusCode)
+		assert.NoError(t, h.mockDB.ExpectationsWereMet())
+	})
+}
+
+func TestGetPDFStatus(t *testing.T) {
+	h := newTestHelper(t, fakeRenderer{})
+	h.mockDB.ExpectQuery(pdfQuery).WithArgs("pdf1", "note1", "user123").
+		WillReturnRows(sqlmock.NewRows(pdfCols).AddRow("pdf1", "note1", "done", 4, nil, "pdfs/user123/pdf1.pdf", noteCreatedAt, noteCreatedAt, noteCreatedAt.Add(PDFTTL)))
+
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/pdf/pdf1", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var pdf PDF
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&pdf))
+	assert.Equal(t, "/notes/note1/pdf/pdf1/download", pdf.DownloadURL)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestPurgeExpired(t *testing.T) {
+	h := newTestHelper(t, nil)
+	ctx := context.Background()
+	require.NoError(t, h.store.Put(ctx, "pdfs/user123/pdf1.pdf", strings.NewReader("%PDF"), 4, "application/pdf"))
+	now := time.Now()
+
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, storage_key FROM note_pdfs WHERE expires_at < ?")).
+		WithArgs(now.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "storage_key"}).
+			AddRow("pdf1", "pdfs/user123/pdf1.pdf").
+			// Renders that failed never stored a file
+			AddRow("pdf2", "pdfs/user123/pdf2.pdf"))
+	for _, id := range []string{"pdf1", "pdf2"} {
+		h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM note_pdfs WHERE id = ?")).WithArgs(id).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	purged, err := PurgeExpired(ctx, h.handler.db, h.store, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	_, err = h.store.Get(ctx, "pdfs/user123/pdf1.pdf")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestFilename(t *testing.T) {
+	assert.Equal(t, "Q1 plan v2.pdf", filename("Q1 plan: v2"))
+	assert.Equal(t, "Trip to Zürich.pdf", filename(`Trip to "Zürich"`))
+	assert.Equal(t, "note.pdf", filename("../"))
+}
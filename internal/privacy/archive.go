@@ -0,0 +1,187 @@
+package privacy
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"quanta/internal/storage"
+)
+
+// section is one JSON file of an export: the rows a query returns for the
+// user. Every placeholder in query is the user's ID. Secrets such as
+// password hashes, API key hashes and deploy keys are never selected.
+type section struct {
+	file  string
+	query string
+}
+
+var sections = []section{
+	{"account.json", "SELECT id, email, display_name, avatar_url, timezone, public_key, role, created_at FROM users WHERE id = ?"},
+	{"preferences.json", "SELECT digest, digest_sent_at, updated_at FROM user_preferences WHERE user_id = ?"},
+	{"workspaces.json", "SELECT w.id, w.name, m.role, m.created_at AS joined_at FROM workspace_members m JOIN workspaces w ON w.id = m.workspace_id WHERE m.user_id = ? ORDER BY m.created_at"},
+	{"notes.json", "SELECT id, workspace_id, title, content, pinned, archived, encrypted, metadata, color, icon, version, created_at, updated_at FROM notes WHERE user_id = ? ORDER BY created_at, id"},
+	{"revisions.json", "SELECT note_id, version, user_id, title, content, created_at FROM note_revisions WHERE user_id = ? OR note_id IN (SELECT id FROM notes WHERE user_id = ?) ORDER BY note_id, version"},
+	{"tasks.json", "SELECT t.id, t.note_id, t.line, t.text, t.done, t.due_date FROM tasks t JOIN notes n ON n.id = t.note_id WHERE n.user_id = ? ORDER BY t.note_id, t.line"},
+	{"collaborations.json", "SELECT note_id, role, created_at FROM note_collaborators WHERE user_id = ? ORDER BY created_at"},
+	{"favorites.json", "SELECT note_id, created_at FROM note_favorites WHERE user_id = ? ORDER BY created_at"},
+	{"views.json", "SELECT note_id, viewed_at FROM note_views WHERE user_id = ? ORDER BY viewed_at"},
+	{"reminders.json", "SELECT id, note_id, due_at, recurrence, created_at FROM reminders WHERE user_id = ? ORDER BY due_at"},
+	{"attachments.json", "SELECT id, note_id, filename, content_type, size, status, created_at FROM attachments WHERE user_id = ? ORDER BY created_at, id"},
+	{"activity.json", "SELECT id, note_id, action, details, created_at FROM activities WHERE actor_id = ? ORDER BY created_at"},
+	{"notifications.json", "SELECT id, kind, payload, read_at, created_at FROM notifications WHERE user_id = ? ORDER BY created_at"},
+	{"sessions.json", "SELECT id, device, ip, user_agent, created_at, last_seen_at FROM sessions WHERE user_id = ? ORDER BY created_at"},
+	{"api_keys.json", "SELECT id, name, scopes, created_at FROM api_keys WHERE user_id = ? ORDER BY created_at"},
+	{"git_sync.json", "SELECT remote_url, branch, public_key, last_synced_at, created_at FROM git_syncs WHERE user_id = ?"},
+	{"audit_log.json", "SELECT id, user_id, actor_id, event, ip, user_agent, details, created_at FROM audit_log WHERE user_id = ? OR actor_id = ? ORDER BY created_at"},
+}
+
+// readme describes an export's files
+const readme = `This archive holds the data Quanta keeps about your account, as of %s.
+
+Each .json file is an array of records:
+
+  account.json         your profile
+  preferences.json     your email digest settings
+  workspaces.json      the workspaces you belong to and your role in each
+  notes.json           the notes you own; encrypted notes are as stored
+  revisions.json       earlier versions of your notes and revisions you wrote
+  tasks.json           the checklist items in your notes
+  collaborations.json  notes shared with you
+  favorites.json       notes you starred
+  views.json           when you last opened each note
+  reminders.json       your reminders
+  attachments.json     your uploads; the files are under attachments/
+  activity.json        what you did to notes
+  notifications.json   notifications sent to you
+  sessions.json        the devices signed in to your account
+  api_keys.json        your API keys, without the keys themselves
+  git_sync.json        the repository your notes are pushed to
+  audit_log.json       security events about or caused by your account
+`
+
+// build writes a ZIP of the user's data to w and returns how many records
+// it holds. Attachment files whose blobs are missing are left out.
+func build(ctx context.Context, db DBInterface, store storage.Storage, w io.Writer, userID string, now time.Time) (int, error) {
+	zw := zip.NewWriter(w)
+	f, err := zw.Create("README.txt")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := fmt.Fprintf(f, readme, now.UTC().Format(time.RFC3339)); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, s := range sections {
+		rows, err := query(ctx, db, s.query, userID)
+		if err != nil {
+			return total, fmt.Errorf("exporting %s: %w", s.file, err)
+		}
+		f, err := zw.Create(s.file)
+		if err != nil {
+			return total, err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			return total, err
+		}
+		total += len(rows)
+	}
+
+	if err := addAttachments(ctx, db, store, zw, userID); err != nil {
+		return total, err
+	}
+	return total, zw.Close()
+}
+
+// query returns the rows of query as maps keyed by column
+func query(ctx context.Context, db DBInterface, query, userID string) ([]map[string]any, error) {
+	args := make([]any, strings.Count(query, "?"))
+	for i := range args {
+		args[i] = userID
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	out := []map[string]any{}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, col := range columns {
+			// MySQL returns text as bytes
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[col] = values[i]
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// addAttachments copies the user's uploads into the archive under
+// attachments/<id>/<filename>, leaving out those quarantined as malware
+func addAttachments(ctx context.Context, db DBInterface, store storage.Storage, zw *zip.Writer, userID string) error {
+	rows, err := db.QueryContext(ctx, "SELECT id, filename, storage_key FROM attachments WHERE user_id = ? AND status <> 'quarantined' ORDER BY created_at, id", userID)
+	if err != nil {
+		return fmt.Errorf("exporting attachments: %w", err)
+	}
+	type file struct{ id, name, key string }
+	var files []file
+	for rows.Next() {
+		var f file
+		if err := rows.Scan(&f.id, &f.name, &f.key); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("exporting attachments: %w", err)
+		}
+		files = append(files, f)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("exporting attachments: %w", err)
+	}
+
+	for _, f := range files {
+		body, err := store.Get(ctx, f.key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("exporting attachment %s: %w", f.id, err)
+		}
+		name := path.Base("/" + f.name)
+		if name == "/" {
+			name = "file"
+		}
+		w, err := zw.Create("attachments/" + f.id + "/" + name)
+		if err == nil {
+			_, err = io.Copy(w, body)
+		}
+		_ = body.Close()
+		if err != nil {
+			return fmt.Errorf("exporting attachment %s: %w", f.id, err)
+		}
+	}
+	return nil
+}
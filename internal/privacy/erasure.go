@@ -0,0 +1,250 @@
+package privacy
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/pkg"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErasureRequest is the request body for Erase
+type ErasureRequest struct {
+	Password string `json:"password"`
+}
+
+// Erasure sums up what erasing an account did with what the user shared
+type Erasure struct {
+	// WorkspacesTransferred is how many workspaces the user owned that
+	// passed to another member
+	WorkspacesTransferred int `json:"workspaces_transferred"`
+	// WorkspacesDeleted is how many workspaces the user owned alone
+	WorkspacesDeleted int `json:"workspaces_deleted"`
+	// NotesReassigned is how many workspace notes the user wrote that now
+	// belong to the workspace's owner
+	NotesReassigned int `json:"notes_reassigned"`
+	// RevisionsAnonymized is how many revisions no longer name the user
+	// as their author
+	RevisionsAnonymized int `json:"revisions_anonymized"`
+}
+
+// Erase permanently removes the authenticated user after confirming their
+// password. Unlike DELETE /me there is no grace period. What they wrote in
+// shared workspaces stays, handed to each workspace's owner; workspaces
+// they owned pass to an admin, or the longest-standing member, and are
+// deleted only when nobody else is in them. Revisions they wrote lose their
+// author and the audit trail loses their ID and addresses, so the records
+// others rely on stay without identifying them. Everything else, including
+// their uploads and data exports, is deleted.
+//
+// Backups taken before the erasure still hold the user until they are
+// pruned.
+func (h *Handler) Erase(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	var payload ErasureRequest
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+
+	ctx := c.UserContext()
+	var email, hashedPw string
+	err = h.db.QueryRowContext(ctx, "SELECT email, password FROM users WHERE id = ? AND deleted_at IS NULL", userID).Scan(&email, &hashedPw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperr.New(fiber.StatusNotFound, "User not found")
+		}
+		return fmt.Errorf("fetching user for erasure: %w", err)
+	}
+	if err := pkg.CheckPasswordHash(payload.Password, hashedPw); err != nil {
+		return apperr.New(fiber.StatusUnauthorized, "Password is incorrect")
+	}
+
+	var res Erasure
+	var blobs []string
+	err = db.InTx(ctx, h.db, func(tx *sql.Tx) error {
+		var err error
+		res, blobs, err = erase(ctx, tx, userID, email)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("erasing account: %w", err)
+	}
+
+	h.sessions.DisconnectUser(userID)
+	for _, key := range blobs {
+		if err := h.store.Delete(ctx, key); err != nil {
+			log.Printf("Error deleting file %s of erased user: %v", key, err)
+		}
+	}
+
+	// The entry records that an erasure happened, not who asked for it
+	h.audit.Log(ctx, audit.Entry{
+		Event: audit.EventAccountErased,
+		Details: map[string]string{
+			"workspaces_transferred": strconv.Itoa(res.WorkspacesTransferred),
+			"workspaces_deleted":     strconv.Itoa(res.WorkspacesDeleted),
+			"notes_reassigned":       strconv.Itoa(res.NotesReassigned),
+		},
+	})
+
+	return c.JSON(res)
+}
+
+// HandOver passes what a departing user shares with others to those who
+// stay, within tx. Workspaces they own pass to an admin, or the
+// longest-standing member, and are deleted only when nobody else is in
+// them; they leave every other workspace; and the notes they wrote in
+// shared workspaces, with the files attached to them, now belong to each
+// workspace's owner. Their private notes are left to the caller.
+//
+// It returns the storage keys of the files of deleted workspaces, to
+// delete once tx has committed. Blobs attachments can share are left for
+// the attachments collector.
+func HandOver(ctx context.Context, tx *sql.Tx, userID string) (Erasure, []string, error) {
+	var res Erasure
+
+	owned, err := column(ctx, tx, "SELECT workspace_id FROM workspace_members WHERE user_id = ? AND role = ?", userID, "owner")
+	if err != nil {
+		return res, nil, fmt.Errorf("listing owned workspaces: %w", err)
+	}
+	var blobs []string
+	for _, workspaceID := range owned {
+		var successor string
+		err := tx.QueryRowContext(ctx,
+			"SELECT user_id FROM workspace_members WHERE workspace_id = ? AND user_id <> ? ORDER BY CASE role WHEN 'admin' THEN 0 ELSE 1 END, created_at, user_id LIMIT 1",
+			workspaceID, userID,
+		).Scan(&successor)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			keys, err := column(ctx, tx, "SELECT a.storage_key FROM attachments a JOIN notes n ON n.id = a.note_id WHERE n.workspace_id = ? AND NOT EXISTS (SELECT 1 FROM attachment_blobs b WHERE b.storage_key = a.storage_key)", workspaceID)
+			if err != nil {
+				return res, nil, fmt.Errorf("listing workspace files: %w", err)
+			}
+			blobs = append(blobs, keys...)
+			if _, err := tx.ExecContext(ctx, "DELETE FROM workspaces WHERE id = ?", workspaceID); err != nil {
+				return res, nil, fmt.Errorf("deleting workspace: %w", err)
+			}
+			res.WorkspacesDeleted++
+		case err != nil:
+			return res, nil, fmt.Errorf("choosing workspace owner: %w", err)
+		default:
+			if _, err := tx.ExecContext(ctx,
+				"UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?", "owner", workspaceID, successor,
+			); err != nil {
+				return res, nil, fmt.Errorf("transferring workspace: %w", err)
+			}
+			res.WorkspacesTransferred++
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM workspace_members WHERE user_id = ?", userID); err != nil {
+		return res, nil, fmt.Errorf("leaving workspaces: %w", err)
+	}
+
+	// Reassigning isn't an edit, so updated_at is kept as it was
+	n, err := exec(ctx, tx,
+		"UPDATE notes SET user_id = (SELECT m.user_id FROM workspace_members m WHERE m.workspace_id = notes.workspace_id AND m.role = ? ORDER BY m.created_at LIMIT 1), updated_at = updated_at WHERE user_id = ? AND workspace_id IS NOT NULL",
+		"owner", userID,
+	)
+	if err != nil {
+		return res, nil, fmt.Errorf("reassigning notes: %w", err)
+	}
+	res.NotesReassigned = n
+	// Uploads follow the notes they are attached to
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE attachments SET user_id = (SELECT n.user_id FROM notes n WHERE n.id = attachments.note_id) WHERE user_id = ?", userID,
+	); err != nil {
+		return res, nil, fmt.Errorf("reassigning attachments: %w", err)
+	}
+	return res, blobs, nil
+}
+
+// erase does the work of Erase within tx and returns the storage keys of
+// the files to delete once it has committed. Blobs attachments can share
+// are left for the attachments collector, which deletes them once nothing
+// else refers to them.
+func erase(ctx context.Context, tx *sql.Tx, userID, email string) (Erasure, []string, error) {
+	res, blobs, err := HandOver(ctx, tx, userID)
+	if err != nil {
+		return res, nil, err
+	}
+	if res.RevisionsAnonymized, err = exec(ctx, tx, "UPDATE note_revisions SET user_id = NULL WHERE user_id = ?", userID); err != nil {
+		return res, nil, fmt.Errorf("anonymizing revisions: %w", err)
+	}
+
+	keys, err := column(ctx, tx, "SELECT a.storage_key FROM attachments a JOIN notes n ON n.id = a.note_id WHERE n.user_id = ? AND NOT EXISTS (SELECT 1 FROM attachment_blobs b WHERE b.storage_key = a.storage_key)", userID)
+	if err != nil {
+		return res, nil, fmt.Errorf("listing files: %w", err)
+	}
+	blobs = append(blobs, keys...)
+	keys, err = column(ctx, tx, "SELECT storage_key FROM data_exports WHERE user_id = ?", userID)
+	if err != nil {
+		return res, nil, fmt.Errorf("listing exports: %w", err)
+	}
+	blobs = append(blobs, keys...)
+	keys, err = column(ctx, tx, "SELECT storage_key FROM note_pdfs WHERE user_id = ?", userID)
+	if err != nil {
+		return res, nil, fmt.Errorf("listing PDFs: %w", err)
+	}
+	blobs = append(blobs, keys...)
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{"UPDATE audit_log SET user_id = NULL, ip = '', user_agent = '' WHERE user_id = ?", []any{userID}},
+		{"UPDATE audit_log SET actor_id = NULL, ip = '', user_agent = '' WHERE actor_id = ?", []any{userID}},
+		// Activities name their actor and can't be kept without them
+		{"DELETE FROM activities WHERE actor_id = ?", []any{userID}},
+		{"DELETE FROM workspace_invitations WHERE email = ?", []any{email}},
+		{"DELETE FROM feature_flag_overrides WHERE scope = ? AND target_id = ?", []any{"user", userID}},
+		// Everything else the user owns goes with them
+		{"DELETE FROM users WHERE id = ?", []any{userID}},
+	}
+	for _, s := range statements {
+		if _, err := tx.ExecContext(ctx, s.query, s.args...); err != nil {
+			return res, nil, fmt.Errorf("erasing account: %w", err)
+		}
+	}
+	return res, blobs, nil
+}
+
+// column returns the single column query selects
+func column(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func exec(ctx context.Context, tx *sql.Tx, query string, args ...any) (int, error) {
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
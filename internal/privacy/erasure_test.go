@@ -0,0 +1,163 @@
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"quanta/internal/audit"
+	"quanta/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Use a valid bcrypt hash for 'password123'
+const validHash = "$2a$10$E6HhdnHa3eB0JwE2ZyieJuxCAYpWDYe403HM/LKSPi3FVetNZDk4i"
+
+var userQuery = regexp.QuoteMeta("SELECT email, password FROM users WHERE id = ? AND deleted_at IS NULL")
+
+// erasureRequest confirms an erasure with password
+func erasureRequest(password string) *http.Request {
+	req := httptest.NewRequest("POST", "/me/erasure", strings.NewReader(`{"password":"`+password+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestErase(t *testing.T) {
+	h := newTestHelper(t)
+	ctx := context.Background()
+	for _, key := range []string{"attachments/solo", "attachments/private", "attachments/shared", "exports/user123/e1.zip"} {
+		require.NoError(t, h.store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"))
+	}
+
+	h.mockDB.ExpectQuery(userQuery).WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "password"}).AddRow("me@example.com", validHash))
+	h.mockDB.ExpectBegin()
+	// ws1 passes to an admin; nobody else is in ws2, so it goes
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT workspace_id FROM workspace_members WHERE user_id = ? AND role = ?")).
+		WithArgs("user123", "owner").
+		WillReturnRows(sqlmock.NewRows([]string{"workspace_id"}).AddRow("ws1").AddRow("ws2"))
+	successor := regexp.QuoteMeta("SELECT user_id FROM workspace_members WHERE workspace_id = ? AND user_id <> ?")
+	h.mockDB.ExpectQuery(successor).WithArgs("ws1", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("admin1"))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE workspace_members SET role = ? WHERE workspace_id = ? AND user_id = ?")).
+		WithArgs("owner", "ws1", "admin1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectQuery(successor).WithArgs("ws2", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT a.storage_key FROM attachments a JOIN notes n ON n.id = a.note_id WHERE n.workspace_id = ? AND NOT EXISTS (SELECT 1 FROM attachment_blobs b WHERE b.storage_key = a.storage_key)")).
+		WithArgs("ws2").
+		WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("attachments/solo"))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM workspaces WHERE id = ?")).WithArgs("ws2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM workspace_members WHERE user_id = ?")).WithArgs("user123").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE notes SET user_id = (SELECT m.user_id FROM workspace_members m WHERE m.workspace_id = notes.workspace_id AND m.role = ?")).
+		WithArgs("owner", "user123").
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE attachments SET user_id = (SELECT n.user_id FROM notes n WHERE n.id = attachments.note_id) WHERE user_id = ?")).
+		WithArgs("user123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE note_revisions SET user_id = NULL WHERE user_id = ?")).WithArgs("user123").
+		WillReturnResult(sqlmock.NewResult(0, 9))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT a.storage_key FROM attachments a JOIN notes n ON n.id = a.note_id WHERE n.user_id = ? AND NOT EXISTS (SELECT 1 FROM attachment_blobs b WHERE b.storage_key = a.storage_key)")).
+		WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("attachments/private"))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT storage_key FROM data_exports WHERE user_id = ?")).
+		WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("exports/user123/e1.zip"))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT storage_key FROM note_pdfs WHERE user_id = ?")).
+		WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"storage_key"}))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE audit_log SET user_id = NULL, ip = '', user_agent = '' WHERE user_id = ?")).WithArgs("user123").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE audit_log SET actor_id = NULL, ip = '', user_agent = '' WHERE actor_id = ?")).WithArgs("user123").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM activities WHERE actor_id = ?")).WithArgs("user123").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM workspace_invitations WHERE email = ?")).WithArgs("me@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM feature_flag_overrides WHERE scope = ? AND target_id = ?")).WithArgs("user", "user123").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM users WHERE id = ?")).WithArgs("user123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.mockDB.ExpectCommit()
+
+	resp, err := h.app.Test(erasureRequest("password123"))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var res Erasure
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	assert.Equal(t, Erasure{WorkspacesTransferred: 1, WorkspacesDeleted: 1, NotesReassigned: 4, RevisionsAnonymized: 9}, res)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+
+	assert.Equal(t, []string{"user123"}, h.sessions.disconnected)
+	for _, key := range []string{"attachments/solo", "attachments/private", "exports/user123/e1.zip"} {
+		_, err := h.store.Get(ctx, key)
+		assert.ErrorIs(t, err, storage.ErrNotFound, key)
+	}
+	// Files that passed to others stay
+	body, err := h.store.Get(ctx, "attachments/shared")
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+
+	if assert.Len(t, h.audit.entries, 1) {
+		entry := h.audit.entries[0]
+		assert.Equal(t, audit.EventAccountErased, entry.Event)
+		assert.Empty(t, entry.UserID)
+		assert.Empty(t, entry.ActorID)
+		assert.Empty(t, entry.IP)
+		assert.Equal(t, "1", entry.Details["workspaces_deleted"])
+	}
+}
+
+func TestErase_Rejected(t *testing.T) {
+	testCases := []struct {
+		name           string
+		password       string
+		rows           *sqlmock.Rows
+		expectedStatus int
+	}{
+		{name: "wrong password", password: "nope", rows: sqlmock.NewRows([]string{"email", "password"}).AddRow("me@example.com", validHash), expectedStatus: fiber.StatusUnauthorized},
+		{name: "deleted user", password: "password123", rows: sqlmock.NewRows([]string{"email", "password"}), expectedStatus: fiber.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			h.mockDB.ExpectQuery(userQuery).WithArgs("user123").WillReturnRows(tc.rows)
+
+			resp, err := h.app.Test(erasureRequest(tc.password))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Empty(t, h.sessions.disconnected)
+			assert.Empty(t, h.audit.entries)
+			assert.NoError(t, h.mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestErase_RollsBack(t *testing.T) {
+	h := newTestHelper(t)
+
+	h.mockDB.ExpectQuery(userQuery).WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "password"}).AddRow("me@example.com", validHash))
+	h.mockDB.ExpectBegin()
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT workspace_id FROM workspace_members WHERE user_id = ? AND role = ?")).
+		WillReturnError(errors.New("connection reset"))
+	h.mockDB.ExpectRollback()
+
+	resp, err := h.app.Test(erasureRequest("password123"))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusInternalServerError, resp.StatusCode)
+	assert.Empty(t, h.sessions.disconnected)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
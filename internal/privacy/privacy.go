@@ -0,0 +1,299 @@
+// Package privacy implements users' data protection rights: downloading
+// everything kept about them as a machine-readable archive, and erasing
+// their account so that what they shared with others stays but no longer
+// identifies them.
+package privacy
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/storage"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// ExportTTL is how long a finished export can be downloaded before it is
+// deleted
+const ExportTTL = 7 * 24 * time.Hour
+
+// Status is how far along an export is
+type Status string
+
+const (
+	// Running exports are still being built
+	Running Status = "running"
+	// Done exports can be downloaded until they expire
+	Done Status = "done"
+	// Failed exports could not be built; Error says why
+	Failed Status = "failed"
+)
+
+// Export is a data export and its progress. DownloadURL is set once the
+// archive is ready.
+type Export struct {
+	ID          string     `json:"id"`
+	Status      Status     `json:"status"`
+	Records     int        `json:"records"`
+	Size        int64      `json:"size"`
+	Error       *string    `json:"error"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  *time.Time `json:"finished_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// SessionCloser disconnects a user's live realtime connections
+type SessionCloser interface {
+	DisconnectUser(userID string)
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Handler handles HTTP requests related to data exports and erasure
+type Handler struct {
+	db       DBInterface
+	store    storage.Storage
+	sessions SessionCloser
+	audit    AuditLogger
+	// async builds exports in the background
+	async func(func())
+}
+
+// NewHandler creates a new Handler that keeps export archives in store,
+// closes the realtime sessions of erased users and records erasures in
+// the audit log
+func NewHandler(db DBInterface, store storage.Storage, sessions SessionCloser, auditLog AuditLogger) *Handler {
+	return &Handler{
+		db:       db,
+		store:    store,
+		sessions: sessions,
+		audit:    auditLog,
+		async:    func(run func()) { go run() },
+	}
+}
+
+// CreateExport starts building an archive of everything kept about the
+// user. It responds straight away with the export to poll; a user has one
+// export running at a time.
+func (h *Handler) CreateExport(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	var running int
+	err = h.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM data_exports WHERE user_id = ? AND status = ?", userID, string(Running),
+	).Scan(&running)
+	if err != nil {
+		return fmt.Errorf("checking running exports: %w", err)
+	}
+	if running > 0 {
+		return apperr.New(fiber.StatusConflict, "A data export is already running")
+	}
+
+	exp := Export{
+		ID:        uuid.New().String(),
+		Status:    Running,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	key := "exports/" + userID + "/" + exp.ID + ".zip"
+	_, err = h.db.ExecContext(ctx,
+		"INSERT INTO data_exports (id, user_id, status, storage_key, created_at) VALUES (?, ?, ?, ?, ?)",
+		exp.ID, userID, string(exp.Status), key, exp.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("creating export: %w", err)
+	}
+
+	h.async(func() {
+		h.run(context.Background(), exp, userID, key)
+	})
+
+	return c.Status(fiber.StatusAccepted).JSON(exp)
+}
+
+// run builds and stores an export's archive and records the outcome
+func (h *Handler) run(ctx context.Context, exp Export, userID, key string) {
+	var buf bytes.Buffer
+	records, err := build(ctx, h.db, h.store, &buf, userID, exp.CreatedAt)
+	if err == nil {
+		err = h.store.Put(ctx, key, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "application/zip")
+	}
+
+	now := time.Now().UTC()
+	if err != nil {
+		log.Printf("Error building data export %s: %v", exp.ID, err)
+		_, err = h.db.ExecContext(ctx,
+			"UPDATE data_exports SET status = ?, error = ?, finished_at = ? WHERE id = ?",
+			string(Failed), err.Error(), now, exp.ID,
+		)
+	} else {
+		_, err = h.db.ExecContext(ctx,
+			"UPDATE data_exports SET status = ?, records = ?, size = ?, finished_at = ?, expires_at = ? WHERE id = ?",
+			string(Done), records, buf.Len(), now, now.Add(ExportTTL), exp.ID,
+		)
+	}
+	if err != nil {
+		log.Printf("Error finishing data export %s: %v", exp.ID, err)
+	}
+}
+
+// GetExport returns one of the user's exports with its progress
+func (h *Handler) GetExport(c *fiber.Ctx) error {
+	exp, _, err := h.export(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(exp)
+}
+
+// DownloadExport streams a finished export's archive
+func (h *Handler) DownloadExport(c *fiber.Ctx) error {
+	exp, key, err := h.export(c)
+	if err != nil {
+		return err
+	}
+	if exp.Status != Done {
+		return apperr.New(fiber.StatusConflict, "Data export is not ready")
+	}
+	if exp.ExpiresAt != nil && !time.Now().Before(*exp.ExpiresAt) {
+		return apperr.New(fiber.StatusGone, "Data export has expired")
+	}
+
+	body, err := h.store.Get(c.UserContext(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return apperr.New(fiber.StatusGone, "Data export has expired")
+		}
+		return fmt.Errorf("fetching export: %w", err)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="quanta-export-%s.zip"`, exp.CreatedAt.Format("20060102")))
+	// SendStream closes the body once it has been written
+	return c.SendStream(body, int(exp.Size))
+}
+
+// export fetches the user's export named in the path and its storage key
+func (h *Handler) export(c *fiber.Ctx) (Export, string, error) {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return Export{}, "", err
+	}
+
+	var exp Export
+	var key string
+	var reason sql.NullString
+	var finishedAt, expiresAt sql.NullTime
+	err = h.db.QueryRowContext(c.UserContext(),
+		"SELECT id, status, records, size, error, storage_key, created_at, finished_at, expires_at FROM data_exports WHERE id = ? AND user_id = ?",
+		c.Params("id"), userID,
+	).Scan(&exp.ID, &exp.Status, &exp.Records, &exp.Size, &reason, &key, &exp.CreatedAt, &finishedAt, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return exp, "", apperr.New(fiber.StatusNotFound, "Data export not found")
+		}
+		return exp, "", fmt.Errorf("fetching export: %w", err)
+	}
+	if reason.Valid {
+		exp.Error = &reason.String
+	}
+	if finishedAt.Valid {
+		exp.FinishedAt = &finishedAt.Time
+	}
+	if expiresAt.Valid {
+		exp.ExpiresAt = &expiresAt.Time
+	}
+	if exp.Status == Done {
+		exp.DownloadURL = "/me/data-export/" + exp.ID + "/download"
+	}
+	return exp, key, nil
+}
+
+// PurgeExpiredExports deletes the archives and records of exports that
+// expired before now and returns how many went
+func PurgeExpiredExports(ctx context.Context, db DBInterface, store storage.Storage, now time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, storage_key FROM data_exports WHERE expires_at < ?", now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("listing expired exports: %w", err)
+	}
+	type expired struct{ id, key string }
+	var exports []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.key); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("listing expired exports: %w", err)
+		}
+		exports = append(exports, e)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, fmt.Errorf("listing expired exports: %w", err)
+	}
+
+	purged := 0
+	for _, e := range exports {
+		// The record goes only once the archive has, so a failed delete
+		// is retried on the next run
+		if err := store.Delete(ctx, e.key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error deleting data export %s: %v", e.id, err)
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM data_exports WHERE id = ?", e.id); err != nil {
+			return purged, fmt.Errorf("deleting expired export: %w", err)
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// StartPurger runs PurgeExpiredExports every interval until stop is closed
+// Nothing is purged while paused reports true.
+func StartPurger(db DBInterface, store storage.Storage, interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			purged, err := PurgeExpiredExports(ctx, db, store, now)
+			cancel()
+			if err != nil {
+				log.Println("Error purging expired data exports:", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d expired data exports", purged)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
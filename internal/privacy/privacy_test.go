@@ -0,0 +1,251 @@
+package privacy
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	exportQuery = regexp.QuoteMeta("SELECT id, status, records, size, error, storage_key, created_at, finished_at, expires_at FROM data_exports WHERE id = ? AND user_id = ?")
+	exportCols  = []string{"id", "status", "records", "size", "error", "storage_key", "created_at", "finished_at", "expires_at"}
+)
+
+// fakeSessions records which users were disconnected
+type fakeSessions struct {
+	disconnected []string
+}
+
+func (f *fakeSessions) DisconnectUser(userID string) {
+	f.disconnected = append(f.disconnected, userID)
+}
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+type testHelper struct {
+	mockDB   sqlmock.Sqlmock
+	store    *storage.Local
+	app      *fiber.App
+	sessions *fakeSessions
+	audit    *fakeAudit
+}
+
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	sessions := &fakeSessions{}
+	auditLog := &fakeAudit{}
+	handler := NewHandler(db, store, sessions, auditLog)
+	handler.async = func(run func()) { run() }
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Post("/me/erasure", handler.Erase)
+	app.Post("/me/data-export", handler.CreateExport)
+	app.Get("/me/data-export/:id", handler.GetExport)
+	app.Get("/me/data-export/:id/download", handler.DownloadExport)
+
+	return &testHelper{mockDB: mockDB, store: store, app: app, sessions: sessions, audit: auditLog}
+}
+
+func TestCreateExport(t *testing.T) {
+	h := newTestHelper(t)
+	ctx := context.Background()
+	require.NoError(t, h.store.Put(ctx, "attachments/a1", strings.NewReader("hello"), 5, "text/plain"))
+
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM data_exports WHERE user_id = ? AND status = ?")).
+		WithArgs("user123", "running").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO data_exports (id, user_id, status, storage_key, created_at) VALUES (?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "user123", "running", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, s := range sections {
+		args := make([]driver.Value, strings.Count(s.query, "?"))
+		for i := range args {
+			args[i] = "user123"
+		}
+		rows := sqlmock.NewRows([]string{"id", "title"})
+		if s.file == "notes.json" {
+			rows.AddRow("note1", []byte("Groceries"))
+		}
+		h.mockDB.ExpectQuery(regexp.QuoteMeta(s.query)).WithArgs(args...).WillReturnRows(rows)
+	}
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, filename, storage_key FROM attachments WHERE user_id = ? AND status <> 'quarantined'")).
+		WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "filename", "storage_key"}).
+			AddRow("a1", "list.txt", "attachments/a1").
+			AddRow("a2", "gone.txt", "attachments/a2"))
+	h.mockDB.ExpectExec(regexp.QuoteMeta("UPDATE data_exports SET status = ?, records = ?, size = ?, finished_at = ?, expires_at = ? WHERE id = ?")).
+		WithArgs("done", 1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	resp, err := h.app.Test(httptest.NewRequest("POST", "/me/data-export", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+	var exp Export
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&exp))
+	assert.Equal(t, Running, exp.Status)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+
+	body, err := h.store.Get(ctx, "exports/user123/"+exp.ID+".zip")
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	require.NoError(t, body.Close())
+	require.NoError(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		files[f.Name] = string(b)
+	}
+	assert.Len(t, files, len(sections)+2)
+	assert.Contains(t, files, "README.txt")
+	assert.JSONEq(t, `[{"id":"note1","title":"Groceries"}]`, files["notes.json"])
+	assert.JSONEq(t, `[]`, files["audit_log.json"])
+	// The missing blob is left out
+	assert.Equal(t, "hello", files["attachments/a1/list.txt"])
+}
+
+func TestCreateExport_AlreadyRunning(t *testing.T) {
+	h := newTestHelper(t)
+
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM data_exports WHERE user_id = ? AND status = ?")).
+		WithArgs("user123", "running").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	resp, err := h.app.Test(httptest.NewRequest("POST", "/me/data-export", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusConflict, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestGetExport(t *testing.T) {
+	h := newTestHelper(t)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	finished := created.Add(time.Minute)
+	expires := finished.Add(ExportTTL)
+
+	h.mockDB.ExpectQuery(exportQuery).WithArgs("exp1", "user123").
+		WillReturnRows(sqlmock.NewRows(exportCols).AddRow("exp1", "done", 12, 2048, nil, "exports/user123/exp1.zip", created, finished, expires))
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/me/data-export/exp1", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var exp Export
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&exp))
+	assert.Equal(t, Export{
+		ID:          "exp1",
+		Status:      Done,
+		Records:     12,
+		Size:        2048,
+		DownloadURL: "/me/data-export/exp1/download",
+		CreatedAt:   created,
+		FinishedAt:  &finished,
+		ExpiresAt:   &expires,
+	}, exp)
+
+	// Other users' exports aren't found
+	h.mockDB.ExpectQuery(exportQuery).WithArgs("exp2", "user123").WillReturnRows(sqlmock.NewRows(exportCols))
+	resp, err = h.app.Test(httptest.NewRequest("GET", "/me/data-export/exp2", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestDownloadExport(t *testing.T) {
+	h := newTestHelper(t)
+	require.NoError(t, h.store.Put(context.Background(), "exports/user123/exp1.zip", strings.NewReader("PK"), 2, "application/zip"))
+	created := time.Now().UTC().Add(-time.Hour)
+	later := created.Add(ExportTTL)
+	earlier := created.Add(-time.Minute)
+
+	testCases := []struct {
+		name           string
+		status         string
+		expiresAt      any
+		expectedStatus int
+	}{
+		{name: "ready", status: "done", expiresAt: later, expectedStatus: fiber.StatusOK},
+		{name: "running", status: "running", expiresAt: nil, expectedStatus: fiber.StatusConflict},
+		{name: "expired", status: "done", expiresAt: earlier, expectedStatus: fiber.StatusGone},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h.mockDB.ExpectQuery(exportQuery).WithArgs("exp1", "user123").
+				WillReturnRows(sqlmock.NewRows(exportCols).AddRow("exp1", tc.status, 3, 2, nil, "exports/user123/exp1.zip", created, nil, tc.expiresAt))
+
+			resp, err := h.app.Test(httptest.NewRequest("GET", "/me/data-export/exp1/download", nil))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus == fiber.StatusOK {
+				assert.Equal(t, "application/zip", resp.Header.Get(fiber.HeaderContentType))
+				assert.Contains(t, resp.Header.Get(fiber.HeaderContentDisposition), "attachment")
+				b, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, "PK", string(b))
+			}
+			assert.NoError(t, h.mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPurgeExpiredExports(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	ctx := context.Background()
+	now := time.Date(2026, 3, 9, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, store.Put(ctx, "exports/u1/e1.zip", strings.NewReader("PK"), 2, "application/zip"))
+
+	// e2's archive is already gone, which doesn't keep its record
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, storage_key FROM data_exports WHERE expires_at < ?")).
+		WithArgs(now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "storage_key"}).
+			AddRow("e1", "exports/u1/e1.zip").
+			AddRow("e2", "exports/u2/e2.zip"))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM data_exports WHERE id = ?")).WithArgs("e1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM data_exports WHERE id = ?")).WithArgs("e2").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	purged, err := PurgeExpiredExports(ctx, db, store, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	_, err = store.Get(ctx, "exports/u1/e1.zip")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
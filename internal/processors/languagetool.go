@@ -0,0 +1,100 @@
+package processors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MaxReplacements is how many of the replacements LanguageTool proposes
+// for one match are kept
+const MaxReplacements = 5
+
+// LanguageTool checks spelling and grammar with a LanguageTool server, such
+// as https://api.languagetool.org or a self-hosted one
+type LanguageTool struct {
+	// URL is the server's base URL, without the /v2 path
+	URL string
+	// Language is the code of the language content is checked as, or auto
+	// to detect it
+	Language   string
+	HTTPClient *http.Client
+}
+
+// NewLanguageTool creates a LanguageTool processor for the server at
+// baseURL that detects each note's language
+func NewLanguageTool(baseURL string) *LanguageTool {
+	return &LanguageTool{
+		URL:        strings.TrimRight(baseURL, "/"),
+		Language:   "auto",
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name implements Processor
+func (lt *LanguageTool) Name() string {
+	return "languagetool"
+}
+
+// checkResponse is the part of a /v2/check response that becomes
+// suggestions
+type checkResponse struct {
+	Matches []struct {
+		Message      string `json:"message"`
+		Offset       int    `json:"offset"`
+		Length       int    `json:"length"`
+		Replacements []struct {
+			Value string `json:"value"`
+		} `json:"replacements"`
+		Rule struct {
+			ID string `json:"id"`
+		} `json:"rule"`
+	} `json:"matches"`
+}
+
+// Process implements Processor. LanguageTool reports offsets in UTF-16
+// code units, as Suggestion expects.
+func (lt *LanguageTool) Process(ctx context.Context, content string) ([]Suggestion, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, nil
+	}
+
+	form := url.Values{"text": {content}, "language": {lt.Language}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lt.URL+"/v2/check", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := lt.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("languagetool answered %s", resp.Status)
+	}
+
+	var check checkResponse
+	if err := json.NewDecoder(resp.Body).Decode(&check); err != nil {
+		return nil, fmt.Errorf("decoding languagetool response: %w", err)
+	}
+
+	suggestions := make([]Suggestion, 0, len(check.Matches))
+	for _, m := range check.Matches {
+		s := Suggestion{Rule: m.Rule.ID, Message: m.Message, Offset: m.Offset, Length: m.Length, Replacements: []string{}}
+		for _, r := range m.Replacements {
+			if len(s.Replacements) == MaxReplacements {
+				break
+			}
+			s.Replacements = append(s.Replacements, r.Value)
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, nil
+}
@@ -0,0 +1,49 @@
+package processors
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLanguageTool_Process(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/check", r.URL.Path)
+		assert.Equal(t, "Thiss is fine", r.PostFormValue("text"))
+		assert.Equal(t, "auto", r.PostFormValue("language"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"matches":[{"message":"Possible spelling mistake found.","offset":0,"length":5,` +
+			`"replacements":[{"value":"This"},{"value":"Thus"},{"value":"Thigs"},{"value":"Thess"},{"value":"Tess"},{"value":"Tiss"}],` +
+			`"rule":{"id":"MORFOLOGIK_RULE_EN_US"}}]}`))
+	}))
+	defer server.Close()
+
+	lt := NewLanguageTool(server.URL + "/")
+	suggestions, err := lt.Process(context.Background(), "Thiss is fine")
+	require.NoError(t, err)
+	assert.Equal(t, []Suggestion{{
+		Rule:         "MORFOLOGIK_RULE_EN_US",
+		Message:      "Possible spelling mistake found.",
+		Offset:       0,
+		Length:       5,
+		Replacements: []string{"This", "Thus", "Thigs", "Thess", "Tess"},
+	}}, suggestions)
+
+	suggestions, err = lt.Process(context.Background(), "  ")
+	assert.NoError(t, err)
+	assert.Empty(t, suggestions)
+}
+
+func TestLanguageTool_ServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := NewLanguageTool(server.URL).Process(context.Background(), "text")
+	assert.EqualError(t, err, "languagetool answered 429 Too Many Requests")
+}
@@ -0,0 +1,214 @@
+// Package processors runs note content through external services, such as
+// a grammar checker, some time after it is edited and stores what they
+// suggest in note_suggestions
+package processors
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"quanta/internal/db"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultDelay is how long a note must go unedited before it is
+	// processed
+	DefaultDelay = 10 * time.Second
+	// DefaultTimeout bounds each processor's run on a note
+	DefaultTimeout = 30 * time.Second
+	// DefaultWorkers is how many notes are processed at once
+	DefaultWorkers = 2
+	// QueueSize is how many notes can wait to be processed. Edits beyond
+	// it are not processed.
+	QueueSize = 1000
+)
+
+// Suggestion is a change a processor proposes to a stretch of a note's
+// content. Offset and Length are in UTF-16 code units, as JavaScript
+// strings are indexed.
+type Suggestion struct {
+	Processor    string   `json:"processor"`
+	Rule         string   `json:"rule"`
+	Message      string   `json:"message"`
+	Offset       int      `json:"offset"`
+	Length       int      `json:"length"`
+	Replacements []string `json:"replacements"`
+}
+
+// Processor checks a note's content. Name identifies it in the suggestions
+// it makes, which Process need not fill in.
+type Processor interface {
+	Name() string
+	Process(ctx context.Context, content string) ([]Suggestion, error)
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Options tunes a Pipeline. Zero fields take the defaults above.
+type Options struct {
+	// Delay lets a burst of edits, such as autosaves while typing, be
+	// processed once
+	Delay   time.Duration
+	Timeout time.Duration
+	Workers int
+}
+
+// Pipeline processes notes after they are edited. Queued notes are kept in
+// memory, so edits made just before a restart are not processed until the
+// note is edited again.
+type Pipeline struct {
+	db         DBInterface
+	processors []Processor
+	opts       Options
+
+	queue  chan string
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+// NewPipeline creates a Pipeline that runs each note through processors in
+// turn. Call Run to start processing.
+func NewPipeline(db DBInterface, processors []Processor, opts Options) *Pipeline {
+	if opts.Delay <= 0 {
+		opts.Delay = DefaultDelay
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	return &Pipeline{
+		db:         db,
+		processors: processors,
+		opts:       opts,
+		queue:      make(chan string, QueueSize),
+		timers:     make(map[string]*time.Timer),
+	}
+}
+
+// Enqueue schedules a note to be processed once it has gone the delay
+// without another edit. It never blocks the edit that calls it.
+func (p *Pipeline) Enqueue(noteID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.timers[noteID]; ok {
+		t.Reset(p.opts.Delay)
+		return
+	}
+	p.timers[noteID] = time.AfterFunc(p.opts.Delay, func() {
+		p.mu.Lock()
+		delete(p.timers, noteID)
+		p.mu.Unlock()
+
+		select {
+		case p.queue <- noteID:
+		default:
+			log.Printf("Content processing queue is full, skipping note %s", noteID)
+		}
+	})
+}
+
+// Run processes queued notes until stop is closed
+func (p *Pipeline) Run(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for range p.opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case noteID := <-p.queue:
+					if err := p.Process(context.Background(), noteID); err != nil {
+						log.Printf("Error processing note %s: %v", noteID, err)
+					}
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Process runs a note's current content through every processor and
+// replaces its suggestions with theirs. A processor that fails is logged
+// and skipped. Encrypted notes are left alone, and nothing is stored if the
+// note changed while it was processed: that edit queued it again.
+func (p *Pipeline) Process(ctx context.Context, noteID string) error {
+	var content sql.NullString
+	var version int64
+	var encrypted bool
+	err := p.db.QueryRowContext(ctx, "SELECT content, version, encrypted FROM notes WHERE id = ?", noteID).
+		Scan(&content, &version, &encrypted)
+	if errors.Is(err, sql.ErrNoRows) || encrypted {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("fetching note: %w", err)
+	}
+
+	var suggestions []Suggestion
+	for _, proc := range p.processors {
+		pctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+		found, err := proc.Process(pctx, content.String)
+		cancel()
+		if err != nil {
+			log.Printf("Error running %s on note %s: %v", proc.Name(), noteID, err)
+			continue
+		}
+		for _, s := range found {
+			s.Processor = proc.Name()
+			if s.Replacements == nil {
+				s.Replacements = []string{}
+			}
+			suggestions = append(suggestions, s)
+		}
+	}
+
+	return db.InTx(ctx, p.db, func(tx *sql.Tx) error {
+		var current int64
+		err := tx.QueryRowContext(ctx, "SELECT version FROM notes WHERE id = ?", noteID).Scan(&current)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("fetching note version: %w", err)
+		}
+		if current != version {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM note_suggestions WHERE note_id = ?", noteID); err != nil {
+			return fmt.Errorf("clearing suggestions: %w", err)
+		}
+		for _, s := range suggestions {
+			replacements, err := json.Marshal(s.Replacements)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx,
+				"INSERT INTO note_suggestions (id, note_id, version, processor, rule, message, position, length, replacements) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+				uuid.New().String(), noteID, version, s.Processor, s.Rule, s.Message, s.Offset, s.Length, string(replacements),
+			)
+			if err != nil {
+				return fmt.Errorf("storing suggestion: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+package processors
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProcessor flags every occurrence of a word, or fails when err is set
+type fakeProcessor struct {
+	name string
+	word string
+	err  error
+}
+
+func (f *fakeProcessor) Name() string { return f.name }
+
+func (f *fakeProcessor) Process(_ context.Context, content string) ([]Suggestion, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var found []Suggestion
+	for _, loc := range regexp.MustCompile(regexp.QuoteMeta(f.word)).FindAllStringIndex(content, -1) {
+		found = append(found, Suggestion{Rule: "WORD", Message: "Avoid " + f.word, Offset: loc[0], Length: loc[1] - loc[0]})
+	}
+	return found, nil
+}
+
+func TestPipeline_Process(t *testing.T) {
+	noteQuery := regexp.QuoteMeta("SELECT content, version, encrypted FROM notes WHERE id = ?")
+	versionQuery := regexp.QuoteMeta("SELECT version FROM notes WHERE id = ?")
+	insert := regexp.QuoteMeta("INSERT INTO note_suggestions (id, note_id, version, processor, rule, message, position, length, replacements) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	processors := []Processor{
+		&fakeProcessor{name: "broken", err: errors.New("service down")},
+		&fakeProcessor{name: "style", word: "very"},
+	}
+
+	testCases := []struct {
+		name      string
+		setupMock func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "Stores Suggestions",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1").
+					WillReturnRows(sqlmock.NewRows([]string{"content", "version", "encrypted"}).AddRow("a very good note", 4, false))
+				mock.ExpectBegin()
+				mock.ExpectQuery(versionQuery).WithArgs("note1").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM note_suggestions WHERE note_id = ?")).WithArgs("note1").
+					WillReturnResult(sqlmock.NewResult(0, 3))
+				mock.ExpectExec(insert).WithArgs(sqlmock.AnyArg(), "note1", int64(4), "style", "WORD", "Avoid very", 2, 4, "[]").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Edited Meanwhile",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1").
+					WillReturnRows(sqlmock.NewRows([]string{"content", "version", "encrypted"}).AddRow("a very good note", 4, false))
+				mock.ExpectBegin()
+				mock.ExpectQuery(versionQuery).WithArgs("note1").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name: "Encrypted",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1").
+					WillReturnRows(sqlmock.NewRows([]string{"content", "version", "encrypted"}).AddRow("dmVyeQ==", 2, true))
+			},
+		},
+		{
+			name: "Deleted",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(noteQuery).WithArgs("note1").WillReturnRows(sqlmock.NewRows([]string{"content", "version", "encrypted"}))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error opening stub database: %v", err)
+			}
+			defer func() { _ = db.Close() }()
+			tc.setupMock(mock)
+
+			p := NewPipeline(db, processors, Options{})
+			assert.NoError(t, p.Process(context.Background(), "note1"))
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestPipeline_Enqueue(t *testing.T) {
+	p := NewPipeline(nil, nil, Options{Delay: 20 * time.Millisecond})
+
+	// A burst of edits to one note queues it once
+	p.Enqueue("note1")
+	p.Enqueue("note2")
+	time.Sleep(5 * time.Millisecond)
+	p.Enqueue("note1")
+
+	var queued []string
+	timeout := time.After(time.Second)
+	for len(queued) < 2 {
+		select {
+		case id := <-p.queue:
+			queued = append(queued, id)
+		case <-timeout:
+			t.Fatalf("only %v were queued", queued)
+		}
+	}
+	assert.ElementsMatch(t, []string{"note1", "note2"}, queued)
+
+	select {
+	case id := <-p.queue:
+		t.Errorf("%s was queued twice", id)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,229 @@
+// Package quota accounts for the bytes stored by each user and workspace,
+// refuses writes that would go over the configured limits and serves the
+// current usage, along with how much of their request rate limit users
+// have left
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// DefaultUserBytes is the storage each user gets for their private
+	// notes and the files attached to them
+	DefaultUserBytes = 100 << 20
+	// DefaultWorkspaceBytes is the storage each workspace gets for its
+	// notes and their attachments
+	DefaultWorkspaceBytes = 1 << 30
+)
+
+// Querier is satisfied by *sql.DB and *sql.Tx, so checks can run inside
+// the transaction that is about to write
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	Querier
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Limits caps the bytes stored per owner. Zero means unlimited.
+type Limits struct {
+	UserBytes      int64
+	WorkspaceBytes int64
+}
+
+// Usage is how much an owner stores against its limit. LimitBytes is zero
+// when the owner is unlimited.
+type Usage struct {
+	NoteBytes       int64 `json:"note_bytes"`
+	AttachmentBytes int64 `json:"attachment_bytes"`
+	UsedBytes       int64 `json:"used_bytes"`
+	LimitBytes      int64 `json:"limit_bytes"`
+}
+
+// WorkspaceUsage is a workspace's usage as listed by GetUsage
+type WorkspaceUsage struct {
+	WorkspaceID string `json:"workspace_id"`
+	Name        string `json:"name"`
+	Usage
+}
+
+// Report is the response body for GetUsage
+type Report struct {
+	User       Usage            `json:"user"`
+	Workspaces []WorkspaceUsage `json:"workspaces"`
+}
+
+// RateLimit is how much of their request rate limit a user has left in the
+// current window
+type RateLimit struct {
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+// LimitsReport is the response body for GetLimits
+type LimitsReport struct {
+	RateLimit RateLimit `json:"rate_limit"`
+	Storage   Report    `json:"storage"`
+}
+
+// RateLimiter tells how much of a user's rate limit is left. It is
+// implemented by *middleware.RateLimiter.
+type RateLimiter interface {
+	Status(userID string) (limit, remaining int, reset time.Time)
+}
+
+// NoteSize is the number of bytes a note counts for
+func NoteSize(title, content string) int64 {
+	return int64(len(title) + len(content))
+}
+
+// Check refuses with 402 Payment Required a write that grows the storage
+// of a note's owner by delta bytes past its limit. Private notes are
+// charged to userID and workspace notes to workspaceID. Writes that don't
+// grow the storage always pass, so owners already over a lowered limit can
+// still trim their notes.
+func (l Limits) Check(ctx context.Context, q Querier, userID string, workspaceID *string, delta int64) error {
+	limit, msg := l.UserBytes, "Storage quota exceeded"
+	if workspaceID != nil {
+		limit, msg = l.WorkspaceBytes, "Workspace storage quota exceeded"
+	}
+	if limit <= 0 || delta <= 0 {
+		return nil
+	}
+
+	used, err := usage(ctx, q, userID, workspaceID)
+	if err != nil {
+		return fmt.Errorf("checking storage usage: %w", err)
+	}
+	if used.UsedBytes+delta > limit {
+		return apperr.New(fiber.StatusPaymentRequired, msg)
+	}
+	return nil
+}
+
+// usage totals the notes and attachments charged to an owner
+func usage(ctx context.Context, q Querier, userID string, workspaceID *string) (Usage, error) {
+	notes, attachments, arg := "user_id = ? AND workspace_id IS NULL", "n.user_id = ? AND n.workspace_id IS NULL", any(userID)
+	if workspaceID != nil {
+		notes, attachments, arg = "workspace_id = ?", "n.workspace_id = ?", *workspaceID
+	}
+
+	var u Usage
+	err := q.QueryRowContext(ctx,
+		"SELECT (SELECT COALESCE(SUM(size), 0) FROM notes WHERE "+notes+"), "+
+			"(SELECT COALESCE(SUM(a.size), 0) FROM attachments a JOIN notes n ON n.id = a.note_id WHERE "+attachments+")",
+		arg, arg,
+	).Scan(&u.NoteBytes, &u.AttachmentBytes)
+	u.UsedBytes = u.NoteBytes + u.AttachmentBytes
+	return u, err
+}
+
+// Handler serves storage usage and rate limits
+type Handler struct {
+	db     DBInterface
+	limits Limits
+	rate   RateLimiter
+}
+
+// NewHandler creates a new Handler reporting usage against limits and
+// what is left of users' rate limits in rate
+func NewHandler(db DBInterface, limits Limits, rate RateLimiter) *Handler {
+	return &Handler{db: db, limits: limits, rate: rate}
+}
+
+// GetUsage reports the storage used by the current user's private notes
+// and by each workspace they belong to
+func (h *Handler) GetUsage(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	report, err := h.report(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(report)
+}
+
+// GetLimits reports what is left of the current user's rate limit along
+// with their storage usage, so clients can back off before being refused
+func (h *Handler) GetLimits(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	report, err := h.report(c.UserContext(), userID)
+	if err != nil {
+		return err
+	}
+
+	var rate RateLimit
+	rate.Limit, rate.Remaining, rate.ResetAt = h.rate.Status(userID)
+	rate.ResetAt = rate.ResetAt.UTC()
+	return c.JSON(LimitsReport{RateLimit: rate, Storage: report})
+}
+
+// report totals the storage of a user's private notes and of each
+// workspace they belong to
+func (h *Handler) report(ctx context.Context, userID string) (Report, error) {
+	user, err := usage(ctx, h.db, userID, nil)
+	if err != nil {
+		return Report{}, fmt.Errorf("fetching storage usage: %w", err)
+	}
+	user.LimitBytes = h.limits.UserBytes
+	report := Report{User: user, Workspaces: []WorkspaceUsage{}}
+
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT w.id, w.name FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id WHERE m.user_id = ? ORDER BY w.name",
+		userID,
+	)
+	if err != nil {
+		return Report{}, fmt.Errorf("listing workspaces: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+	for rows.Next() {
+		var w WorkspaceUsage
+		if err := rows.Scan(&w.WorkspaceID, &w.Name); err != nil {
+			return Report{}, fmt.Errorf("scanning workspace: %w", err)
+		}
+		report.Workspaces = append(report.Workspaces, w)
+	}
+	if err := rows.Err(); err != nil {
+		return Report{}, fmt.Errorf("listing workspaces: %w", err)
+	}
+	// Workspaces are totalled once the listing is closed, so this also
+	// works on a database with a single connection
+	if err := rows.Close(); err != nil {
+		return Report{}, fmt.Errorf("listing workspaces: %w", err)
+	}
+
+	for i := range report.Workspaces {
+		w := &report.Workspaces[i]
+		u, err := usage(ctx, h.db, "", &w.WorkspaceID)
+		if err != nil {
+			return Report{}, fmt.Errorf("fetching workspace usage: %w", err)
+		}
+		u.LimitBytes = h.limits.WorkspaceBytes
+		w.Usage = u
+	}
+
+	return report, nil
+}
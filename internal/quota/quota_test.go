@@ -0,0 +1,198 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	userUsageQuery      = regexp.QuoteMeta("SELECT (SELECT COALESCE(SUM(size), 0) FROM notes WHERE user_id = ? AND workspace_id IS NULL)")
+	workspaceUsageQuery = regexp.QuoteMeta("SELECT (SELECT COALESCE(SUM(size), 0) FROM notes WHERE workspace_id = ?)")
+)
+
+func usageRows(notes, attachments int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"notes", "attachments"}).AddRow(notes, attachments)
+}
+
+func TestCheck(t *testing.T) {
+	workspaceID := "ws1"
+
+	testCases := []struct {
+		name          string
+		limits        Limits
+		workspaceID   *string
+		delta         int64
+		setupMock     func(mock sqlmock.Sqlmock)
+		expectedCode  int
+		expectedError string
+	}{
+		{
+			name:   "Under Limit",
+			limits: Limits{UserBytes: 100},
+			delta:  10,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(userUsageQuery).WithArgs("user123", "user123").WillReturnRows(usageRows(50, 40))
+			},
+		},
+		{
+			name:   "Over Limit",
+			limits: Limits{UserBytes: 100},
+			delta:  11,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(userUsageQuery).WithArgs("user123", "user123").WillReturnRows(usageRows(50, 40))
+			},
+			expectedCode:  fiber.StatusPaymentRequired,
+			expectedError: "Storage quota exceeded",
+		},
+		{
+			name:        "Workspace Over Limit",
+			limits:      Limits{UserBytes: 1 << 20, WorkspaceBytes: 100},
+			workspaceID: &workspaceID,
+			delta:       20,
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(workspaceUsageQuery).WithArgs("ws1", "ws1").WillReturnRows(usageRows(90, 0))
+			},
+			expectedCode:  fiber.StatusPaymentRequired,
+			expectedError: "Workspace storage quota exceeded",
+		},
+		{
+			name:   "Unlimited",
+			limits: Limits{WorkspaceBytes: 100},
+			delta:  1 << 30,
+		},
+		{
+			name:   "Shrinking",
+			limits: Limits{UserBytes: 100},
+			delta:  -5,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error opening stub database: %v", err)
+			}
+			if tc.setupMock != nil {
+				tc.setupMock(mock)
+			}
+
+			err = tc.limits.Check(context.Background(), db, "user123", tc.workspaceID, tc.delta)
+			if tc.expectedCode == 0 {
+				assert.NoError(t, err)
+			} else {
+				var appErr *apperr.Error
+				if assert.True(t, errors.As(err, &appErr)) {
+					assert.Equal(t, tc.expectedCode, appErr.Code)
+					assert.Equal(t, tc.expectedError, appErr.Message)
+				}
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetUsage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	handler := NewHandler(db, Limits{UserBytes: 1000, WorkspaceBytes: 5000}, nil)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/me/usage", handler.GetUsage)
+
+	mock.ExpectQuery(userUsageQuery).WithArgs("user123", "user123").WillReturnRows(usageRows(120, 300))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT w.id, w.name FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id WHERE m.user_id = ? ORDER BY w.name")).
+		WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow("ws1", "Design").AddRow("ws2", "Research"))
+	mock.ExpectQuery(workspaceUsageQuery).WithArgs("ws1", "ws1").WillReturnRows(usageRows(10, 0))
+	mock.ExpectQuery(workspaceUsageQuery).WithArgs("ws2", "ws2").WillReturnRows(usageRows(0, 0))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/me/usage", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var report Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.Equal(t, Usage{NoteBytes: 120, AttachmentBytes: 300, UsedBytes: 420, LimitBytes: 1000}, report.User)
+	assert.Equal(t, []WorkspaceUsage{
+		{WorkspaceID: "ws1", Name: "Design", Usage: Usage{NoteBytes: 10, UsedBytes: 10, LimitBytes: 5000}},
+		{WorkspaceID: "ws2", Name: "Research", Usage: Usage{LimitBytes: 5000}},
+	}, report.Workspaces)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+// fixedRate reports the same rate limit status for every user
+type fixedRate struct {
+	remaining int
+	reset     time.Time
+}
+
+func (r fixedRate) Status(string) (int, int, time.Time) {
+	return 600, r.remaining, r.reset
+}
+
+func TestGetLimits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	reset := time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC)
+	handler := NewHandler(db, Limits{UserBytes: 1000, WorkspaceBytes: 5000}, fixedRate{remaining: 42, reset: reset})
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/me/limits", handler.GetLimits)
+
+	mock.ExpectQuery(userUsageQuery).WithArgs("user123", "user123").WillReturnRows(usageRows(100, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT w.id, w.name FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id WHERE m.user_id = ? ORDER BY w.name")).
+		WithArgs("user123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/me/limits", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var report LimitsReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	assert.Equal(t, RateLimit{Limit: 600, Remaining: 42, ResetAt: reset}, report.RateLimit)
+	assert.Equal(t, Usage{NoteBytes: 100, UsedBytes: 100, LimitBytes: 1000}, report.Storage.User)
+	assert.Empty(t, report.Storage.Workspaces)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
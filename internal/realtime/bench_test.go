@@ -0,0 +1,233 @@
+package realtime
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// benchConn is a WebSocketConn whose writes cost nothing, so benchmarks
+// measure the room manager rather than the network. onWrite, if set, runs
+// after every write.
+type benchConn struct {
+	writes  atomic.Int64
+	onWrite func()
+}
+
+func (c *benchConn) WriteMessage(int, []byte) error {
+	c.writes.Add(1)
+	if c.onWrite != nil {
+		c.onWrite()
+	}
+	return nil
+}
+
+func (c *benchConn) ReadMessage() (int, []byte, error) { select {} }
+
+func (c *benchConn) Close() error { return nil }
+
+// fillRoom joins n benchConns to a room and returns them
+func fillRoom(rm *RoomManager, noteID string, n int, onWrite func()) []*benchConn {
+	conns := make([]*benchConn, n)
+	for i := range conns {
+		conns[i] = &benchConn{onWrite: onWrite}
+		rm.JoinRoom(noteID, conns[i], Participant{UserID: fmt.Sprintf("user-%d", i)})
+	}
+	return conns
+}
+
+// emptyRooms removes every connection so the writer goroutines exit
+func emptyRooms(rm *RoomManager) {
+	type roomConn struct {
+		noteID string
+		conn   WebSocketConn
+	}
+
+	var leaving []roomConn
+	for _, s := range rm.shards {
+		s.mu.RLock()
+		for noteID, room := range s.rooms {
+			for conn := range room {
+				leaving = append(leaving, roomConn{noteID: noteID, conn: conn})
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	for _, rc := range leaving {
+		rm.LeaveRoom(rc.noteID, rc.conn)
+	}
+}
+
+// quietLogs silences the room manager's per-room logging for a benchmark
+func quietLogs(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// reportLatencies adds percentile metrics for the observed latencies
+func reportLatencies(b *testing.B, latencies []time.Duration) {
+	if len(latencies) == 0 {
+		return
+	}
+	slices.Sort(latencies)
+	at := func(p float64) float64 {
+		return float64(latencies[int(p*float64(len(latencies)-1))].Microseconds())
+	}
+	b.ReportMetric(at(0.50), "p50-µs")
+	b.ReportMetric(at(0.99), "p99-µs")
+}
+
+// BenchmarkBroadcastToRoom measures fan-out to a single room: the time from
+// BroadcastToRoom until every other member has written the message
+func BenchmarkBroadcastToRoom(b *testing.B) {
+	quietLogs(b)
+	payload := []byte(`{"type":"edit","v":1,"rev":1,"content":"hello","user-id":"user-0"}`)
+
+	for _, size := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("members=%d", size), func(b *testing.B) {
+			rm := NewRoomManager()
+			var delivered sync.WaitGroup
+			conns := fillRoom(rm, "note", size, delivered.Done)
+			defer emptyRooms(rm)
+			sender := conns[0]
+
+			latencies := make([]time.Duration, 0, b.N)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				delivered.Add(size - 1)
+				start := time.Now()
+				rm.BroadcastToRoom("note", sender, websocket.TextMessage, payload)
+				delivered.Wait()
+				latencies = append(latencies, time.Since(start))
+			}
+			b.StopTimer()
+
+			reportLatencies(b, latencies)
+		})
+	}
+}
+
+// BenchmarkBroadcastEdit measures edits arriving concurrently across many
+// rooms, as on a busy server. Edits take the history lock, so this shows
+// how much rooms contend with each other. Dropped slow consumers are
+// reported as drops, a sign the send queues can't keep up.
+func BenchmarkBroadcastEdit(b *testing.B) {
+	quietLogs(b)
+	for _, shape := range []struct{ rooms, members int }{
+		{rooms: 100, members: 10},
+		{rooms: 500, members: 10},
+		{rooms: 200, members: 25},
+	} {
+		b.Run(fmt.Sprintf("rooms=%d/members=%d", shape.rooms, shape.members), func(b *testing.B) {
+			rm := NewRoomManager()
+			senders := make([]*benchConn, shape.rooms)
+			for i := range senders {
+				senders[i] = fillRoom(rm, fmt.Sprintf("note-%d", i), shape.members, nil)[0]
+			}
+			defer emptyRooms(rm)
+
+			var next atomic.Int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					room := int(next.Add(1)) % shape.rooms
+					rm.BroadcastEdit(fmt.Sprintf("note-%d", room), senders[room], websocket.TextMessage, EditMessage{
+						Type:    MessageTypeEdit,
+						V:       ProtocolVersion,
+						Content: "hello",
+						UserID:  "user-0",
+					})
+				}
+			})
+			b.StopTimer()
+
+			drops := 0
+			for i := range shape.rooms {
+				drops += rm.Stats(fmt.Sprintf("note-%d", i)).SlowConsumers
+			}
+			b.ReportMetric(float64(drops), "drops")
+		})
+	}
+}
+
+// BenchmarkJoinLeave measures connection churn with many rooms open
+func BenchmarkJoinLeave(b *testing.B) {
+	quietLogs(b)
+	rm := NewRoomManager()
+	for i := range 500 {
+		fillRoom(rm, fmt.Sprintf("note-%d", i), 10, nil)
+	}
+	defer emptyRooms(rm)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		conn := &benchConn{}
+		noteID := fmt.Sprintf("note-%d", i%500)
+		rm.JoinRoom(noteID, conn, Participant{UserID: "churn"})
+		rm.LeaveRoom(noteID, conn)
+	}
+}
+
+// BenchmarkQuietRoomUnderLoad measures joins and leaves in an idle room
+// while other goroutines broadcast into large, busy rooms. With one shard
+// the idle room waits behind every broadcast; sharded, it shouldn't notice.
+func BenchmarkQuietRoomUnderLoad(b *testing.B) {
+	quietLogs(b)
+	const busyRooms, members = 8, 1000
+	payload := []byte(`{"type":"edit","v":1,"rev":1,"content":"hello","user-id":"user-0"}`)
+
+	for _, shards := range []int{1, roomShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			rm := newRoomManager(shards)
+			senders := make([]*benchConn, busyRooms)
+			for i := range senders {
+				senders[i] = fillRoom(rm, fmt.Sprintf("busy-%d", i), members, nil)[0]
+			}
+			defer emptyRooms(rm)
+
+			stop := make(chan struct{})
+			var load sync.WaitGroup
+			for i := range senders {
+				load.Add(1)
+				go func() {
+					defer load.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+							rm.BroadcastToRoom(fmt.Sprintf("busy-%d", i), senders[i], websocket.TextMessage, payload)
+						}
+					}
+				}()
+			}
+
+			conn := &benchConn{}
+			latencies := make([]time.Duration, 0, b.N)
+			b.ResetTimer()
+			for range b.N {
+				start := time.Now()
+				rm.JoinRoom("quiet", conn, Participant{UserID: "user"})
+				rm.LeaveRoom("quiet", conn)
+				latencies = append(latencies, time.Since(start))
+			}
+			b.StopTimer()
+			close(stop)
+			load.Wait()
+
+			reportLatencies(b, latencies)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// DefaultCoalesceInterval is how long cursor and typing updates are held
+// for batching unless configured
+const DefaultCoalesceInterval = 75 * time.Millisecond
+
+// MessageTypeBatch carries several cursor and typing updates in one frame
+const MessageTypeBatch MessageType = "batch"
+
+// BatchMessage combines the cursor and typing updates a room produced
+// within one coalescing interval, oldest first. A recipient with only one
+// update due is sent that message on its own instead.
+type BatchMessage struct {
+	Type     MessageType       `json:"type"`
+	V        int               `json:"v"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+// pendingUpdate is a coalesced message waiting for the room's next flush.
+// Updates with a sender aren't sent back to it.
+type pendingUpdate struct {
+	sender  WebSocketConn
+	payload []byte
+}
+
+// pendingBatch holds a room's updates until its flush timer fires. cursors
+// indexes each connection's cursor update in updates, so a newer position
+// replaces the one still waiting rather than queuing behind it.
+type pendingBatch struct {
+	updates []pendingUpdate
+	cursors map[WebSocketConn]int
+}
+
+// BroadcastCursor sends a cursor update to the rest of the room with the
+// room's next batch. Only a connection's latest position is sent.
+func (rm *RoomManager) BroadcastCursor(noteID string, sender WebSocketConn, cursor CursorMessage) {
+	payload, err := json.Marshal(cursor)
+	if err != nil {
+		log.Printf("Error marshalling cursor message: %v", err)
+		return
+	}
+	rm.coalesce(noteID, sender, payload, true)
+}
+
+// coalesce adds an update to the room's pending batch, starting the flush
+// timer if it is the first. With latest set, it replaces the sender's
+// earlier update instead of following it.
+func (rm *RoomManager) coalesce(noteID string, sender WebSocketConn, payload []byte, latest bool) {
+	s := rm.shard(noteID)
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	b, exists := s.batches[noteID]
+	if !exists {
+		b = &pendingBatch{cursors: make(map[WebSocketConn]int)}
+		s.batches[noteID] = b
+		time.AfterFunc(rm.coalesceInterval, func() { rm.flush(noteID) })
+	}
+	if latest {
+		if i, exists := b.cursors[sender]; exists {
+			b.updates[i].payload = payload
+			return
+		}
+		b.cursors[sender] = len(b.updates)
+	}
+	b.updates = append(b.updates, pendingUpdate{sender: sender, payload: payload})
+}
+
+// flush sends a room's pending updates. Connections that sent none of them
+// share a single frame; the others each get one without their own updates.
+func (rm *RoomManager) flush(noteID string) {
+	s := rm.shard(noteID)
+	s.batchMu.Lock()
+	b := s.batches[noteID]
+	delete(s.batches, noteID)
+	s.batchMu.Unlock()
+	if b == nil || len(b.updates) == 0 {
+		return
+	}
+
+	shared, ok := batchFrame(b.updates, nil)
+	if !ok {
+		return
+	}
+	senders := make(map[WebSocketConn]bool)
+	for _, u := range b.updates {
+		if u.sender != nil {
+			senders[u.sender] = true
+		}
+	}
+
+	s.mu.RLock()
+	var slow []WebSocketConn
+	for conn, m := range s.rooms[noteID] {
+		msg := shared
+		if senders[conn] {
+			if msg, ok = batchFrame(b.updates, conn); !ok {
+				continue
+			}
+		}
+		if !enqueue(m, msg) {
+			slow = append(slow, conn)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, conn := range slow {
+		rm.dropSlowConsumer(noteID, conn)
+	}
+}
+
+// batchFrame builds the frame a connection receives for a batch, leaving
+// out its own updates. It reports false if nothing is left to send.
+func batchFrame(updates []pendingUpdate, recipient WebSocketConn) (outboundMessage, bool) {
+	var messages []json.RawMessage
+	for _, u := range updates {
+		if recipient != nil && u.sender == recipient {
+			continue
+		}
+		messages = append(messages, u.payload)
+	}
+	switch len(messages) {
+	case 0:
+		return outboundMessage{}, false
+	case 1:
+		return newOutbound(websocket.TextMessage, messages[0]), true
+	}
+	payload, err := json.Marshal(BatchMessage{Type: MessageTypeBatch, V: ProtocolVersion, Messages: messages})
+	if err != nil {
+		log.Printf("Error marshalling batch message: %v", err)
+		return outboundMessage{}, false
+	}
+	return newOutbound(websocket.TextMessage, payload), true
+}
+
+// dropPending discards a departed connection's pending cursor, so the room
+// isn't shown a caret for someone who already left
+func (s *roomShard) dropPending(noteID string, conn WebSocketConn) {
+	s.batchMu.Lock()
+	defer s.batchMu.Unlock()
+
+	b, exists := s.batches[noteID]
+	if !exists {
+		return
+	}
+	i, exists := b.cursors[conn]
+	if !exists {
+		return
+	}
+	b.updates = append(b.updates[:i], b.updates[i+1:]...)
+	delete(b.cursors, conn)
+	for c, j := range b.cursors {
+		if j > i {
+			b.cursors[c] = j - 1
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// receive reads frames until n messages have arrived, unpacking batches
+func receive(t *testing.T, written <-chan []byte, n int) [][]byte {
+	t.Helper()
+	var messages [][]byte
+	for len(messages) < n {
+		select {
+		case payload := <-written:
+			var batch BatchMessage
+			if err := json.Unmarshal(payload, &batch); err == nil && batch.Type == MessageTypeBatch {
+				for _, m := range batch.Messages {
+					messages = append(messages, m)
+				}
+				continue
+			}
+			messages = append(messages, payload)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d messages", len(messages), n)
+		}
+	}
+	return messages
+}
+
+// recordingConn returns a connection whose text frames are sent to a channel
+func recordingConn() (*MockWebSocketConn, chan []byte) {
+	written := make(chan []byte, 8)
+	conn := new(MockWebSocketConn)
+	conn.On("WriteMessage", 1, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		written <- args.Get(1).([]byte)
+	})
+	return conn, written
+}
+
+func cursorAt(userID string, offset int) CursorMessage {
+	return CursorMessage{Type: MessageTypeCursor, V: ProtocolVersion, UserID: userID, Position: Position{Offset: offset}}
+}
+
+func TestRoomManager_BroadcastCursor(t *testing.T) {
+	rm := NewRoomManager()
+	rm.coalesceInterval = 20 * time.Millisecond
+	noteID := "test-note"
+
+	alice, aliceWritten := recordingConn()
+	bob, bobWritten := recordingConn()
+	carol, carolWritten := recordingConn()
+	rm.JoinRoom(noteID, alice, Participant{UserID: "alice"})
+	rm.JoinRoom(noteID, bob, Participant{UserID: "bob"})
+	rm.JoinRoom(noteID, carol, Participant{UserID: "carol"})
+
+	// Only the latest position of each sender is sent
+	for offset := range 10 {
+		rm.BroadcastCursor(noteID, alice, cursorAt("alice", offset))
+	}
+	rm.BroadcastCursor(noteID, bob, cursorAt("bob", 3))
+
+	aliceLatest, _ := json.Marshal(cursorAt("alice", 9))
+	bobLatest, _ := json.Marshal(cursorAt("bob", 3))
+
+	// A bystander gets both in one frame
+	payload := <-carolWritten
+	var batch BatchMessage
+	require.NoError(t, json.Unmarshal(payload, &batch))
+	assert.Equal(t, MessageTypeBatch, batch.Type)
+	assert.Equal(t, []json.RawMessage{aliceLatest, bobLatest}, batch.Messages)
+
+	// Senders don't get their own update back, leaving a single message
+	// sent as it is
+	assert.Equal(t, bobLatest, <-aliceWritten)
+	assert.Equal(t, aliceLatest, <-bobWritten)
+
+	time.Sleep(40 * time.Millisecond)
+	assert.Empty(t, aliceWritten)
+	assert.Empty(t, bobWritten)
+	assert.Empty(t, carolWritten)
+}
+
+func TestRoomManager_BroadcastCursorAfterLeave(t *testing.T) {
+	rm := NewRoomManager()
+	rm.coalesceInterval = 20 * time.Millisecond
+	noteID := "test-note"
+
+	alice, _ := recordingConn()
+	bob, bobWritten := recordingConn()
+	carol, _ := recordingConn()
+	rm.JoinRoom(noteID, alice, Participant{UserID: "alice"})
+	rm.JoinRoom(noteID, bob, Participant{UserID: "bob"})
+	rm.JoinRoom(noteID, carol, Participant{UserID: "carol"})
+
+	// A cursor from someone who has since left is never shown
+	rm.BroadcastCursor(noteID, alice, cursorAt("alice", 1))
+	rm.BroadcastCursor(noteID, carol, cursorAt("carol", 2))
+	rm.LeaveRoom(noteID, alice)
+
+	carolCursor, _ := json.Marshal(cursorAt("carol", 2))
+	assert.Equal(t, [][]byte{carolCursor}, receive(t, bobWritten, 1))
+	time.Sleep(40 * time.Millisecond)
+	assert.Empty(t, bobWritten)
+}
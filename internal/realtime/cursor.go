@@ -0,0 +1,76 @@
+package realtime
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+// cursorColors is the palette participants are assigned colors from
+var cursorColors = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4",
+	"#42d4f4", "#f032e6", "#469990", "#9a6324", "#800000",
+}
+
+// Position is a location in a note. Clients either address it by line and
+// offset within that line, or by an opaque CRDT anchor.
+type Position struct {
+	Line   int    `json:"line"`
+	Offset int    `json:"offset"`
+	Anchor string `json:"anchor,omitempty"`
+}
+
+// Selection is a highlighted range between an anchor and a head position
+type Selection struct {
+	Anchor Position `json:"anchor"`
+	Head   Position `json:"head"`
+}
+
+// CursorPayload is the structured body of a cursor message sent by a client
+type CursorPayload struct {
+	Position  Position   `json:"position"`
+	Selection *Selection `json:"selection,omitempty"`
+}
+
+// CursorMessage is a cursor update rebroadcast to the rest of the room,
+// decorated with the sender's display name and color
+type CursorMessage struct {
+	Type        MessageType `json:"type"`
+	V           int         `json:"v"`
+	UserID      string      `json:"user-id"`
+	DisplayName string      `json:"display_name"`
+	Color       string      `json:"color"`
+	Position    Position    `json:"position"`
+	Selection   *Selection  `json:"selection,omitempty"`
+}
+
+// colorFor picks a stable color for a user so they keep the same caret
+// color across reconnects and across every client in the room
+func colorFor(userID string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userID))
+	return cursorColors[h.Sum32()%uint32(len(cursorColors))]
+}
+
+// validate rejects positions that can't exist in any document
+func (p Position) validate() error {
+	if p.Line < 0 || p.Offset < 0 {
+		return errors.New("cursor position must not be negative")
+	}
+	return nil
+}
+
+// validate checks the cursor position and, if present, the selection bounds
+func (c *CursorPayload) validate() error {
+	if err := c.Position.validate(); err != nil {
+		return err
+	}
+	if c.Selection != nil {
+		if err := c.Selection.Anchor.validate(); err != nil {
+			return err
+		}
+		if err := c.Selection.Head.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package realtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColorFor(t *testing.T) {
+	// Colors are stable for a user and always come from the palette
+	assert.Equal(t, colorFor("user1"), colorFor("user1"))
+	assert.Contains(t, cursorColors, colorFor("user1"))
+	assert.Contains(t, cursorColors, colorFor(""))
+}
+
+func TestCursorPayload_Validate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		payload CursorPayload
+		wantErr bool
+	}{
+		{
+			name:    "Position Only",
+			payload: CursorPayload{Position: Position{Line: 2, Offset: 5}},
+		},
+		{
+			name:    "CRDT Anchor",
+			payload: CursorPayload{Position: Position{Anchor: "site1:42"}},
+		},
+		{
+			name: "With Selection",
+			payload: CursorPayload{
+				Position:  Position{Line: 1, Offset: 3},
+				Selection: &Selection{Anchor: Position{Line: 1}, Head: Position{Line: 1, Offset: 3}},
+			},
+		},
+		{
+			name:    "Negative Offset",
+			payload: CursorPayload{Position: Position{Line: 0, Offset: -1}},
+			wantErr: true,
+		},
+		{
+			name: "Negative Selection",
+			payload: CursorPayload{
+				Position:  Position{Line: 1},
+				Selection: &Selection{Anchor: Position{Line: -1}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.payload.validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
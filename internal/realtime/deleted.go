@@ -0,0 +1,96 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultDeletedGrace is how long a deleted note's room stays open, so
+// clients can tell their users and copy out unsaved work, unless configured
+const DefaultDeletedGrace = 30 * time.Second
+
+// MessageTypeNoteDeleted announces that the room's note was deleted
+const MessageTypeNoteDeleted MessageType = "note:deleted"
+
+// ErrNoteDeleted is returned by TryJoinRoom when the room's note was
+// deleted and the room is waiting to close
+var ErrNoteDeleted = errors.New("note was deleted")
+
+// NoteDeletedMessage tells a room who deleted its note. Clients should
+// switch to read-only:
+// edits are refused from now on and the room is closed with
+// CloseNoteNotFound after CloseIn seconds.
+type NoteDeletedMessage struct {
+	Type    MessageType `json:"type"`
+	V       int         `json:"v"`
+	UserID  string      `json:"user-id"`
+	CloseIn int         `json:"close_in"`
+}
+
+// markDeleted makes a room read-only and closes it after grace. It reports
+// false when nobody is connected to the note, so there is nothing to
+// close. Deleting a note again restarts the grace period.
+func (rm *RoomManager) markDeleted(noteID string, grace time.Duration) bool {
+	s := rm.shard(noteID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.rooms[noteID]) == 0 {
+		return false
+	}
+	if t, ok := s.deleted[noteID]; ok {
+		t.Stop()
+	}
+	var t *time.Timer
+	t = time.AfterFunc(grace, func() {
+		s.mu.RLock()
+		current := s.deleted[noteID] == t
+		s.mu.RUnlock()
+		if current {
+			rm.closeRoom(noteID)
+		}
+	})
+	s.deleted[noteID] = t
+	return true
+}
+
+// isDeleted reports whether a room's note was deleted
+func (rm *RoomManager) isDeleted(noteID string) bool {
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.deleted[noteID]
+	return ok
+}
+
+// closeRoom disconnects everyone in a deleted note's room. Removing the
+// last member removes the room, and its deleted mark with it.
+func (rm *RoomManager) closeRoom(noteID string) {
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	conns := make([]WebSocketConn, 0, len(s.rooms[noteID]))
+	for conn := range s.rooms[noteID] {
+		conns = append(conns, conn)
+	}
+	s.mu.RUnlock()
+
+	for _, conn := range conns {
+		rm.closeMember(noteID, conn, CloseNoteNotFound, ErrorCodeNoteDeleted, "This note was deleted")
+	}
+}
+
+// NoteDeleted tells a note's room that userID deleted the note. The room
+// turns read-only and is closed once the grace period is over.
+func (h *Handler) NoteDeleted(ctx context.Context, noteID, userID string) {
+	if !h.manager.markDeleted(noteID, h.manager.deletedGrace) {
+		return
+	}
+	h.Publish(ctx, noteID, NoteDeletedMessage{
+		Type:    MessageTypeNoteDeleted,
+		V:       ProtocolVersion,
+		UserID:  userID,
+		CloseIn: int(h.manager.deletedGrace.Seconds()),
+	})
+}
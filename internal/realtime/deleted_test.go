@@ -0,0 +1,48 @@
+package realtime
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestHandler_NoteDeleted(t *testing.T) {
+	handler := NewHandler(nil, Options{DeletedGrace: 50 * time.Millisecond})
+	rm := handler.manager
+	conn := new(MockWebSocketConn)
+	closed := make(chan struct{}, 1)
+	conn.On("WriteMessage", mock.Anything, mock.Anything).Return(nil)
+	conn.On("Close").Return(nil).Run(func(mock.Arguments) { closed <- struct{}{} })
+	rm.JoinRoom("note1", conn, Participant{UserID: "user1"})
+
+	// Nobody has note2 open, so there is no room to mark
+	handler.NoteDeleted(t.Context(), "note2", "user2")
+	assert.False(t, rm.isDeleted("note2"))
+
+	handler.NoteDeleted(t.Context(), "note1", "user2")
+	assert.True(t, rm.isDeleted("note1"))
+	assert.ErrorIs(t, rm.TryJoinRoom("note1", new(MockWebSocketConn), Participant{UserID: "user3"}), ErrNoteDeleted)
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("room was not closed")
+	}
+	conn.AssertCalled(t, "WriteMessage", websocket.TextMessage, mock.MatchedBy(func(data []byte) bool {
+		return strings.Contains(string(data), `"type":"note:deleted"`) && strings.Contains(string(data), `"user-id":"user2"`)
+	}))
+	conn.AssertCalled(t, "WriteMessage", websocket.TextMessage, mock.MatchedBy(func(data []byte) bool {
+		return strings.Contains(string(data), `"code":"note_deleted"`)
+	}))
+	conn.AssertCalled(t, "WriteMessage", websocket.CloseMessage, websocket.FormatCloseMessage(CloseNoteNotFound, "This note was deleted"))
+	assert.False(t, inRoom(rm, "note1", conn))
+	assert.False(t, rm.isDeleted("note1"), "the mark goes with the room")
+
+	// The note's next room starts out editable
+	rm.JoinRoom("note1", conn, Participant{UserID: "user1"})
+	assert.False(t, rm.isDeleted("note1"))
+}
@@ -0,0 +1,187 @@
+package realtime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// MaxOpsPerEdit caps the ops one delta edit may carry
+const MaxOpsPerEdit = 100
+
+// MessageTypeEditAck tells the sender of a delta edit the revision it
+// produced, which the sender's next delta edit is based on
+const MessageTypeEditAck MessageType = "edit:ack"
+
+// EditAckMessage acknowledges a delta edit
+type EditAckMessage struct {
+	Type MessageType `json:"type"`
+	V    int         `json:"v"`
+	Rev  int64       `json:"rev"`
+}
+
+var (
+	// ErrStaleRevision is returned by ApplyOps when the edit was made
+	// against an older revision than the room's
+	ErrStaleRevision = errors.New("edit is based on a stale revision")
+	// ErrUnknownDocument is returned by ApplyOps when the room has no copy
+	// of the note's content to apply ops to
+	ErrUnknownDocument = errors.New("room document is not known")
+	// ErrInvalidOp is returned by ApplyOps for ops that are malformed or
+	// reach outside the document
+	ErrInvalidOp = errors.New("invalid op")
+	// ErrDocumentTooLarge is returned by ApplyOps when the edit would make
+	// the document larger than allowed
+	ErrDocumentTooLarge = errors.New("document too large")
+)
+
+// Op is one change in a delta edit: Insert adds text at At, Delete removes
+// that many characters starting at At. Offsets count Unicode code points
+// in the document as left by the previous op.
+type Op struct {
+	At     int    `json:"at"`
+	Insert string `json:"insert,omitempty"`
+	Delete int    `json:"delete,omitempty"`
+}
+
+// applyOps returns doc with ops applied in order, or ErrInvalidOp if any
+// is malformed or out of bounds
+func applyOps(doc string, ops []Op) (string, error) {
+	if len(ops) > MaxOpsPerEdit {
+		return "", fmt.Errorf("%w: more than %d ops", ErrInvalidOp, MaxOpsPerEdit)
+	}
+	runes := []rune(doc)
+	for i, op := range ops {
+		if (op.Insert == "") == (op.Delete == 0) {
+			return "", fmt.Errorf("%w: op %d must either insert or delete", ErrInvalidOp, i)
+		}
+		if op.At < 0 || op.At > len(runes) {
+			return "", fmt.Errorf("%w: op %d is at %d outside a document of %d characters", ErrInvalidOp, i, op.At, len(runes))
+		}
+		if op.Insert != "" {
+			if !utf8.ValidString(op.Insert) {
+				return "", fmt.Errorf("%w: op %d inserts invalid UTF-8", ErrInvalidOp, i)
+			}
+			insert := []rune(op.Insert)
+			runes = append(runes[:op.At], append(insert, runes[op.At:]...)...)
+			continue
+		}
+		if op.Delete < 0 || op.Delete > len(runes)-op.At {
+			return "", fmt.Errorf("%w: op %d deletes past the end of the document", ErrInvalidOp, i)
+		}
+		runes = append(runes[:op.At], runes[op.At+op.Delete:]...)
+	}
+	return string(runes), nil
+}
+
+// ApplyOps applies a delta edit made against revision base to the room's
+// document, then records and broadcasts it like BroadcastEdit and
+// acknowledges it to the sender. The edit is refused if another edit
+// landed first, if an op is out of bounds, or if the document would grow
+// beyond maxBytes.
+func (rm *RoomManager) ApplyOps(noteID string, sender WebSocketConn, messageType int, base int64, maxBytes int, edit EditMessage) error {
+	s := rm.shard(noteID)
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	h, exists := s.history[noteID]
+	if !exists || !h.known {
+		return ErrUnknownDocument
+	}
+	if base != h.revision {
+		return fmt.Errorf("%w: based on %d, room is at %d", ErrStaleRevision, base, h.revision)
+	}
+	doc, err := applyOps(h.doc, edit.Ops)
+	if err != nil {
+		return err
+	}
+	if len(doc) > maxBytes {
+		return ErrDocumentTooLarge
+	}
+
+	h.doc, h.dirty = doc, true
+	rev := rm.recordEdit(h, noteID, sender, messageType, edit)
+	// Still under the history lock, so the ack reaches the sender before
+	// any later revision does
+	if sender != nil {
+		ack, _ := json.Marshal(EditAckMessage{Type: MessageTypeEditAck, V: ProtocolVersion, Rev: rev})
+		rm.SendTo(noteID, sender, messageType, ack)
+	}
+	return nil
+}
+
+// SeedDocument gives a room the note's content to apply delta edits to,
+// unless an edit has already set it
+func (rm *RoomManager) SeedDocument(noteID, content string) {
+	rm.seedDocument(noteID, content, nil)
+}
+
+// seedDocument is SeedDocument for content loaded from the saved note,
+// which the janitor may then save the room's edits over
+func (rm *RoomManager) seedDocument(noteID, content string, saved *savedNote) {
+	s := rm.shard(noteID)
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	h, exists := s.history[noteID]
+	if !exists {
+		h = &roomHistory{}
+		s.history[noteID] = h
+	}
+	if !h.known {
+		h.doc, h.known, h.saved = content, true, saved
+	}
+}
+
+// HasDocument reports whether a room knows its note's content
+func (rm *RoomManager) HasDocument(noteID string) bool {
+	s := rm.shard(noteID)
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	h, exists := s.history[noteID]
+	return exists && h.known
+}
+
+// loadContent reads a note's saved content and the row it came from
+func (h *Handler) loadContent(ctx context.Context, noteID string) (string, savedNote, error) {
+	var content sql.NullString
+	var saved savedNote
+	err := h.db.QueryRowContext(ctx, "SELECT title, content, version, encrypted FROM notes WHERE id = ?", noteID).
+		Scan(&saved.title, &content, &saved.version, &saved.encrypted)
+	if err != nil {
+		return "", savedNote{}, err
+	}
+	return content.String, saved, nil
+}
+
+// applyOps handles a delta edit from a client, answering with the reason
+// if it was refused
+func (h *Handler) applyOps(noteID string, conn WebSocketConn, participant Participant, incoming IncomingMessage) {
+	err := h.manager.ApplyOps(noteID, conn, websocket.TextMessage, incoming.Base, h.limits.MaxContentBytes, EditMessage{
+		Type:        MessageTypeEdit,
+		V:           ProtocolVersion,
+		Ops:         incoming.Ops,
+		UserID:      participant.UserID,
+		DisplayName: participant.DisplayName,
+	})
+	var frame []byte
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, ErrStaleRevision):
+		frame = errorFrame(ErrorCodeStaleRevision, "The note changed since this edit's base revision")
+	case errors.Is(err, ErrUnknownDocument):
+		frame = errorFrame(ErrorCodeDocumentUnknown, "The note's content isn't known to the server; send the full content")
+	case errors.Is(err, ErrDocumentTooLarge):
+		frame = errorFrame(ErrorCodeContentTooLarge, fmt.Sprintf("Note content exceeds the %d byte limit", h.limits.MaxContentBytes))
+	default:
+		frame = errorFrame(ErrorCodeInvalidOp, err.Error())
+	}
+	h.manager.SendTo(noteID, conn, websocket.TextMessage, frame)
+}
@@ -0,0 +1,102 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyOps(t *testing.T) {
+	testCases := []struct {
+		name     string
+		doc      string
+		ops      []Op
+		expected string
+		invalid  bool
+	}{
+		{name: "insert", doc: "hello world", ops: []Op{{At: 5, Insert: ","}}, expected: "hello, world"},
+		{name: "insert at end", doc: "hello", ops: []Op{{At: 5, Insert: "!"}}, expected: "hello!"},
+		{name: "delete", doc: "hello world", ops: []Op{{At: 5, Delete: 6}}, expected: "hello"},
+		{name: "in order", doc: "abc", ops: []Op{{At: 0, Delete: 1}, {At: 2, Insert: "d"}}, expected: "bcd"},
+		{name: "code points", doc: "héllo 👋", ops: []Op{{At: 1, Delete: 1}, {At: 6, Insert: "!"}}, expected: "hllo 👋!"},
+		{name: "insert past the end", doc: "abc", ops: []Op{{At: 4, Insert: "d"}}, invalid: true},
+		{name: "negative offset", doc: "abc", ops: []Op{{At: -1, Insert: "d"}}, invalid: true},
+		{name: "delete past the end", doc: "abc", ops: []Op{{At: 1, Delete: 3}}, invalid: true},
+		{name: "negative delete", doc: "abc", ops: []Op{{At: 1, Delete: -1}}, invalid: true},
+		{name: "both", doc: "abc", ops: []Op{{At: 1, Insert: "x", Delete: 1}}, invalid: true},
+		{name: "neither", doc: "abc", ops: []Op{{At: 1}}, invalid: true},
+		{name: "later op out of bounds", doc: "abc", ops: []Op{{At: 0, Delete: 2}, {At: 2, Insert: "x"}}, invalid: true},
+		{name: "too many", doc: "", ops: make([]Op, MaxOpsPerEdit+1), invalid: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			doc, err := applyOps(tc.doc, tc.ops)
+			if tc.invalid {
+				assert.ErrorIs(t, err, ErrInvalidOp)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, doc)
+		})
+	}
+}
+
+func TestRoomManager_ApplyOps(t *testing.T) {
+	rm := NewRoomManager()
+	sender := new(MockWebSocketConn)
+	other := new(MockWebSocketConn)
+	sent := make(chan []byte, 10)
+	sender.On("WriteMessage", 1, mock.Anything).Return(nil).Run(func(args mock.Arguments) { sent <- args.Get(1).([]byte) })
+	received := make(chan []byte, 10)
+	other.On("WriteMessage", 1, mock.Anything).Return(nil).Run(func(args mock.Arguments) { received <- args.Get(1).([]byte) })
+	rm.JoinRoom("note-1", sender, Participant{UserID: "user1"})
+	rm.JoinRoom("note-1", other, Participant{UserID: "user2"})
+	next := func(ch chan []byte) map[string]any {
+		select {
+		case payload := <-ch:
+			var msg map[string]any
+			require.NoError(t, json.Unmarshal(payload, &msg))
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+			return nil
+		}
+	}
+	edit := func(ops ...Op) EditMessage {
+		return EditMessage{Type: MessageTypeEdit, V: 1, Ops: ops, UserID: "user1"}
+	}
+
+	// Without the note's content there is nothing to apply ops to
+	assert.ErrorIs(t, rm.ApplyOps("note-1", sender, 1, 0, 100, edit(Op{At: 0, Insert: "x"})), ErrUnknownDocument)
+
+	rm.SeedDocument("note-1", "hello")
+	require.NoError(t, rm.ApplyOps("note-1", sender, 1, 0, 100, edit(Op{At: 5, Insert: " world"})))
+	assert.Equal(t, map[string]any{"type": "edit:ack", "v": 1.0, "rev": 1.0}, next(sent))
+	broadcast := next(received)
+	assert.Equal(t, 1.0, broadcast["rev"])
+	assert.Equal(t, []any{map[string]any{"at": 5.0, "insert": " world"}}, broadcast["ops"])
+	assert.NotContains(t, broadcast, "content")
+
+	// Seeding again doesn't undo edits
+	rm.SeedDocument("note-1", "stale")
+
+	// Edits based on an old revision are refused
+	assert.ErrorIs(t, rm.ApplyOps("note-1", sender, 1, 0, 100, edit(Op{At: 0, Insert: "x"})), ErrStaleRevision)
+	assert.ErrorIs(t, rm.ApplyOps("note-1", sender, 1, 1, 100, edit(Op{At: 12, Insert: "x"})), ErrInvalidOp)
+	assert.ErrorIs(t, rm.ApplyOps("note-1", sender, 1, 1, 12, edit(Op{At: 11, Insert: "!!"})), ErrDocumentTooLarge)
+
+	// Full-content edits replace the document
+	rm.BroadcastEdit("note-1", other, 1, EditMessage{Type: MessageTypeEdit, V: 1, Content: "bye"})
+	next(sent)
+	require.NoError(t, rm.ApplyOps("note-1", sender, 1, 2, 100, edit(Op{At: 0, Delete: 1}, Op{At: 2, Insert: "!"})))
+	assert.Equal(t, 3.0, next(sent)["rev"])
+
+	s := rm.shard("note-1")
+	s.historyMu.Lock()
+	assert.Equal(t, "ye!", s.history["note-1"].doc)
+	s.historyMu.Unlock()
+}
@@ -0,0 +1,139 @@
+package realtime
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	mathrand "math/rand/v2"
+	"time"
+)
+
+// Events written to the realtime event log
+const (
+	EventJoin             = "join"
+	EventLeave            = "leave"
+	EventOpApplied        = "op_applied"
+	EventBroadcastFailure = "broadcast_failure"
+	// EventInvalidMessage is a message from a client that was ignored
+	// because it could not be decoded or made no sense
+	EventInvalidMessage = "invalid_message"
+)
+
+// EventLogConfig controls which collaboration sessions have their events
+// logged. Failures are logged for every session.
+type EventLogConfig struct {
+	// SampleRatio is the fraction of sessions logged, from 0 to 1. A
+	// session is sampled when it joins, so its events are logged in full
+	// or not at all.
+	SampleRatio float64
+	// NoteID and UserID limit logging to the sessions on one note or of
+	// one user. Empty logs everyone's.
+	NoteID string
+	UserID string
+	// Logger receives the events. Nil means the standard logger.
+	Logger *log.Logger
+}
+
+// eventLog writes realtime events as JSON lines
+type eventLog struct {
+	cfg EventLogConfig
+}
+
+func newEventLog(cfg EventLogConfig) *eventLog {
+	if cfg.Logger == nil {
+		cfg.Logger = log.Default()
+	}
+	return &eventLog{cfg: cfg}
+}
+
+// event is one logged realtime event
+type event struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	NoteID  string    `json:"note_id"`
+	UserID  string    `json:"user_id,omitempty"`
+	Session string    `json:"session,omitempty"`
+	// Resumed is set on joins that took over a dropped session
+	Resumed bool `json:"resumed,omitempty"`
+	// DurationMS is how long a session lasted, on leave
+	DurationMS int64 `json:"duration_ms,omitempty"`
+	// Revision, Ops and Recipients describe an applied edit; Ops is zero
+	// for edits carrying the full content
+	Revision   int64  `json:"revision,omitempty"`
+	Ops        int    `json:"ops,omitempty"`
+	Recipients int    `json:"recipients,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// eventSession is one connection's run of events, sharing an ID so a
+// collaboration session can be traced through the log
+type eventSession struct {
+	log     *eventLog
+	id      string
+	noteID  string
+	userID  string
+	sampled bool
+	started time.Time
+}
+
+// start begins the events of a connection joining a room, deciding
+// whether they are logged
+func (l *eventLog) start(noteID, userID string) *eventSession {
+	sampled := (l.cfg.NoteID == "" || l.cfg.NoteID == noteID) &&
+		(l.cfg.UserID == "" || l.cfg.UserID == userID) &&
+		mathrand.Float64() < l.cfg.SampleRatio
+	return &eventSession{
+		log:     l,
+		id:      rand.Text(),
+		noteID:  noteID,
+		userID:  userID,
+		sampled: sampled,
+		started: time.Now(),
+	}
+}
+
+// record logs e if the session is sampled. A nil session logs nothing.
+func (s *eventSession) record(e event) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.write(e)
+}
+
+// fail logs e whether or not the session is sampled
+func (s *eventSession) fail(e event) {
+	if s == nil {
+		return
+	}
+	s.write(e)
+}
+
+// elapsed is how long ago the session began
+func (s *eventSession) elapsed() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return time.Since(s.started)
+}
+
+func (s *eventSession) write(e event) {
+	e.Time = time.Now().UTC()
+	e.NoteID, e.UserID, e.Session = s.noteID, s.userID, s.id
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		s.log.cfg.Logger.Printf("Error encoding realtime event: %v", err)
+		return
+	}
+	s.log.cfg.Logger.Printf("realtime event: %s", encoded)
+}
+
+// eventsOf returns the event session of a connection in a room, or nil
+func (rm *RoomManager) eventsOf(noteID string, conn WebSocketConn) *eventSession {
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if m, exists := s.rooms[noteID][conn]; exists {
+		return m.events
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// eventLines is a log destination handing each event to the test
+type eventLines chan event
+
+func (l eventLines) Write(p []byte) (int, error) {
+	var e event
+	if _, encoded, ok := strings.Cut(string(p), "realtime event: "); ok && json.Unmarshal([]byte(encoded), &e) == nil {
+		l <- e
+	}
+	return len(p), nil
+}
+
+func nextEvent(t *testing.T, events eventLines) event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event logged")
+		return event{}
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	events := make(eventLines, 10)
+	rm := NewRoomManager()
+	rm.eventLog = newEventLog(EventLogConfig{SampleRatio: 1, UserID: "user1", Logger: log.New(events, "", 0)})
+
+	traced, other := new(MockWebSocketConn), new(MockWebSocketConn)
+	traced.On("WriteMessage", mock.Anything, mock.Anything).Return(nil)
+	other.On("WriteMessage", mock.Anything, mock.Anything).Return(errors.New("broken pipe"))
+	other.On("Close").Return(nil)
+	rm.JoinRoom("note1", traced, Participant{UserID: "user1"})
+	rm.JoinRoom("note1", other, Participant{UserID: "user2"})
+
+	// Only the sampled user's edits are logged, but every failure is
+	rm.BroadcastEdit("note1", traced, websocket.TextMessage, EditMessage{Type: MessageTypeEdit, Content: "hello"})
+	e := nextEvent(t, events)
+	assert.Equal(t, EventOpApplied, e.Event)
+	assert.Equal(t, "note1", e.NoteID)
+	assert.Equal(t, "user1", e.UserID)
+	assert.Equal(t, rm.eventsOf("note1", traced).id, e.Session)
+	assert.Equal(t, int64(1), e.Revision)
+	assert.Equal(t, 1, e.Recipients)
+
+	e = nextEvent(t, events)
+	assert.Equal(t, EventBroadcastFailure, e.Event)
+	assert.Equal(t, "user2", e.UserID)
+	assert.Equal(t, "broken pipe", e.Error)
+
+	rm.BroadcastEdit("note1", nil, websocket.TextMessage, EditMessage{Type: MessageTypeEdit, Content: "bye"})
+	require.Empty(t, events)
+}
@@ -0,0 +1,100 @@
+package realtime
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+const (
+	// DefaultPingInterval is how often the server pings each connection
+	DefaultPingInterval = 30 * time.Second
+	// DefaultMaxMissedPongs is how many consecutive pings may go unanswered
+	// before a connection is considered dead
+	DefaultMaxMissedPongs = 2
+)
+
+// HeartbeatConfig controls how the server detects dead connections
+type HeartbeatConfig struct {
+	PingInterval   time.Duration
+	MaxMissedPongs int
+}
+
+// withDefaults fills unset or non-positive settings with the defaults
+func (cfg HeartbeatConfig) withDefaults() HeartbeatConfig {
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = DefaultPingInterval
+	}
+	if cfg.MaxMissedPongs <= 0 {
+		cfg.MaxMissedPongs = DefaultMaxMissedPongs
+	}
+	return cfg
+}
+
+// idleTimeout is how long a connection may stay silent before reads fail
+func (cfg HeartbeatConfig) idleTimeout() time.Duration {
+	return cfg.PingInterval * time.Duration(cfg.MaxMissedPongs+1)
+}
+
+// heartbeatConn is the subset of a WebSocket connection used for keepalives
+type heartbeatConn interface {
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetPongHandler(h func(appData string) error)
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// startHeartbeat pings conn on every interval and closes it once it misses
+// more than MaxMissedPongs pongs in a row. Closing the connection unblocks
+// the read loop, which then removes it from its room. The returned function
+// stops the heartbeat.
+func startHeartbeat(conn heartbeatConn, cfg HeartbeatConfig) func() {
+	var missed atomic.Int32
+
+	extendDeadline := func() {
+		if err := conn.SetReadDeadline(time.Now().Add(cfg.idleTimeout())); err != nil {
+			log.Printf("Error setting read deadline: %v", err)
+		}
+	}
+	extendDeadline()
+
+	conn.SetPongHandler(func(string) error {
+		missed.Store(0)
+		extendDeadline()
+		return nil
+	})
+
+	ticker := time.NewTicker(cfg.PingInterval)
+	done := make(chan struct{})
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if int(missed.Add(1)) > cfg.MaxMissedPongs {
+					log.Printf("Closing connection after %d missed pongs", cfg.MaxMissedPongs)
+					if err := conn.Close(); err != nil {
+						log.Printf("Error closing dead connection: %v", err)
+					}
+					return
+				}
+				deadline := time.Now().Add(cfg.PingInterval)
+				if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+					log.Printf("Error sending ping: %v", err)
+				}
+			}
+		}
+	}()
+
+	var once atomic.Bool
+	return func() {
+		if once.CompareAndSwap(false, true) {
+			close(done)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package realtime
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHeartbeatConn records pings and answers them when respond is set
+type fakeHeartbeatConn struct {
+	mu          sync.Mutex
+	respond     bool
+	pings       int
+	closed      bool
+	pongHandler func(string) error
+}
+
+func (f *fakeHeartbeatConn) WriteControl(_ int, _ []byte, _ time.Time) error {
+	f.mu.Lock()
+	f.pings++
+	respond, handler := f.respond, f.pongHandler
+	f.mu.Unlock()
+
+	if respond {
+		return handler("")
+	}
+	return nil
+}
+
+func (f *fakeHeartbeatConn) SetPongHandler(h func(string) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pongHandler = h
+}
+
+func (f *fakeHeartbeatConn) SetReadDeadline(_ time.Time) error {
+	return nil
+}
+
+func (f *fakeHeartbeatConn) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeHeartbeatConn) state() (pings int, closed bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.pings, f.closed
+}
+
+func TestHeartbeat_ClosesUnresponsiveConnection(t *testing.T) {
+	conn := &fakeHeartbeatConn{}
+	stop := startHeartbeat(conn, HeartbeatConfig{PingInterval: 5 * time.Millisecond, MaxMissedPongs: 2})
+	defer stop()
+
+	assert.Eventually(t, func() bool {
+		_, closed := conn.state()
+		return closed
+	}, time.Second, 5*time.Millisecond)
+
+	pings, _ := conn.state()
+	assert.Equal(t, 2, pings)
+}
+
+func TestHeartbeat_KeepsResponsiveConnection(t *testing.T) {
+	conn := &fakeHeartbeatConn{respond: true}
+	stop := startHeartbeat(conn, HeartbeatConfig{PingInterval: 5 * time.Millisecond, MaxMissedPongs: 1})
+
+	assert.Eventually(t, func() bool {
+		pings, _ := conn.state()
+		return pings >= 5
+	}, time.Second, 5*time.Millisecond)
+	stop()
+
+	_, closed := conn.state()
+	assert.False(t, closed)
+}
+
+func TestHeartbeatConfig_WithDefaults(t *testing.T) {
+	cfg := HeartbeatConfig{PingInterval: 10 * time.Second, MaxMissedPongs: 4}
+	assert.Equal(t, cfg, cfg.withDefaults())
+
+	cfg = HeartbeatConfig{MaxMissedPongs: -1}
+	assert.Equal(t, HeartbeatConfig{PingInterval: DefaultPingInterval, MaxMissedPongs: DefaultMaxMissedPongs}, cfg.withDefaults())
+}
@@ -0,0 +1,154 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// DefaultHistorySize is how many edits each room keeps for replay
+const DefaultHistorySize = 100
+
+const (
+	// MessageTypeHistory carries the edits a joining client may have missed
+	MessageTypeHistory MessageType = "history"
+)
+
+// EditMessage is an edit rebroadcast to the room. Rev is the room revision
+// the edit produced; revisions increase by one with every edit. An edit
+// carries either the note's full Content or the Ops that turn the
+// previous revision into this one.
+type EditMessage struct {
+	Type        MessageType `json:"type"`
+	V           int         `json:"v"`
+	Rev         int64       `json:"rev"`
+	Content     string      `json:"content,omitempty"`
+	Ops         []Op        `json:"ops,omitempty"`
+	UserID      string      `json:"user-id"`
+	DisplayName string      `json:"display_name"`
+}
+
+// HistoryMessage is sent to a client on join with the room's current
+// revision and the buffered edits after the revision the client asked for.
+// Truncated means edits the client needs are no longer buffered and it
+// should refetch the note instead. Ops can overlap with live edits that
+// arrive right after joining, so clients skip ops at or below the last
+// revision they applied.
+type HistoryMessage struct {
+	Type      MessageType       `json:"type"`
+	V         int               `json:"v"`
+	Revision  int64             `json:"revision"`
+	Truncated bool              `json:"truncated"`
+	Ops       []json.RawMessage `json:"ops"`
+}
+
+// roomHistory is a ring buffer of a room's most recent edits, with the
+// document they produced once it is known
+type roomHistory struct {
+	revision int64
+	ops      []json.RawMessage
+	// next is where the next op is written once the buffer is full
+	next int
+	// doc is the note's content as of revision, if known is set. dirty
+	// means edits changed it since it was loaded from saved or saved.
+	doc   string
+	known bool
+	dirty bool
+	saved *savedNote
+}
+
+// add stores the encoded op for the room's newest revision
+func (h *roomHistory) add(op json.RawMessage, size int) {
+	if len(h.ops) < size {
+		h.ops = append(h.ops, op)
+		return
+	}
+	h.ops[h.next] = op
+	h.next = (h.next + 1) % size
+}
+
+// since returns the buffered ops after revision, oldest first, and whether
+// some of the requested ops have already been evicted
+func (h *roomHistory) since(revision int64) ([]json.RawMessage, bool) {
+	oldest := h.revision - int64(len(h.ops)) + 1
+	truncated := revision > h.revision || revision < oldest-1
+	if truncated || revision < oldest {
+		revision = oldest - 1
+	}
+
+	ops := make([]json.RawMessage, 0, h.revision-revision)
+	for i := range len(h.ops) {
+		if rev := oldest + int64(i); rev > revision {
+			ops = append(ops, h.ops[(h.next+i)%len(h.ops)])
+		}
+	}
+	return ops, truncated
+}
+
+// BroadcastEdit makes a full-content edit the room's document, assigns the
+// edit the room's next revision, keeps it in the room's history and
+// broadcasts it to everyone but the sender. The history
+// lock is held while broadcasting so every client sees revisions in order.
+func (rm *RoomManager) BroadcastEdit(noteID string, sender WebSocketConn, messageType int, edit EditMessage) {
+	s := rm.shard(noteID)
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	h, exists := s.history[noteID]
+	if !exists {
+		h = &roomHistory{}
+		s.history[noteID] = h
+	}
+	h.doc, h.known, h.dirty = edit.Content, true, true
+	rm.recordEdit(h, noteID, sender, messageType, edit)
+}
+
+// recordEdit gives an edit the room's next revision, buffers it and
+// broadcasts it. s.historyMu must be held.
+func (rm *RoomManager) recordEdit(h *roomHistory, noteID string, sender WebSocketConn, messageType int, edit EditMessage) int64 {
+	edit.Rev = h.revision + 1
+	payload, err := json.Marshal(edit)
+	if err != nil {
+		log.Printf("Error marshalling edit: %v", err)
+		return h.revision
+	}
+	h.revision = edit.Rev
+	h.add(payload, rm.historySize)
+
+	recipients := rm.BroadcastToRoom(noteID, sender, messageType, payload)
+	rm.record(noteID, edit.UserID, h.revision, payload)
+	if sender != nil {
+		rm.eventsOf(noteID, sender).record(event{Event: EventOpApplied, Revision: h.revision, Ops: len(edit.Ops), Recipients: recipients})
+	}
+	return h.revision
+}
+
+// History returns the replay message for a client that last saw revision.
+// A revision of zero asks for everything since the room was created.
+func (rm *RoomManager) History(noteID string, revision int64) HistoryMessage {
+	s := rm.shard(noteID)
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	msg := HistoryMessage{Type: MessageTypeHistory, V: ProtocolVersion, Ops: []json.RawMessage{}}
+	h, exists := s.history[noteID]
+	if !exists {
+		msg.Truncated = revision > 0
+		return msg
+	}
+
+	msg.Revision = h.revision
+	msg.Ops, msg.Truncated = h.since(revision)
+	return msg
+}
+
+// dropHistory forgets a room's history once its last client leaves,
+// unless its document has edits the janitor can still save
+func (s *roomShard) dropHistory(noteID string) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	if h, exists := s.history[noteID]; exists && h.dirty && h.saved != nil {
+		return
+	}
+	delete(s.history, noteID)
+}
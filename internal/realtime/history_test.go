@@ -0,0 +1,91 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// revisions decodes the rev of each op in a history message
+func revisions(t *testing.T, msg HistoryMessage) []int64 {
+	revs := []int64{}
+	for _, op := range msg.Ops {
+		var edit EditMessage
+		if err := json.Unmarshal(op, &edit); err != nil {
+			t.Fatalf("error decoding op: %v", err)
+		}
+		revs = append(revs, edit.Rev)
+	}
+	return revs
+}
+
+func TestRoomManager_History(t *testing.T) {
+	rm := NewRoomManager()
+	rm.historySize = 3
+	noteID := "test-note"
+
+	msg := rm.History(noteID, 0)
+	assert.Equal(t, int64(0), msg.Revision)
+	assert.False(t, msg.Truncated)
+	assert.Empty(t, msg.Ops)
+
+	for range 5 {
+		rm.BroadcastEdit(noteID, nil, 1, EditMessage{Type: MessageTypeEdit, Content: "x"})
+	}
+
+	testCases := []struct {
+		name      string
+		since     int64
+		expected  []int64
+		truncated bool
+	}{
+		{name: "Everything Buffered", since: 0, expected: []int64{3, 4, 5}, truncated: true},
+		{name: "Just Before Buffer", since: 2, expected: []int64{3, 4, 5}},
+		{name: "Partial", since: 4, expected: []int64{5}},
+		{name: "Up To Date", since: 5, expected: []int64{}},
+		{name: "Evicted", since: 1, expected: []int64{3, 4, 5}, truncated: true},
+		{name: "Ahead Of Room", since: 9, expected: []int64{3, 4, 5}, truncated: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := rm.History(noteID, tc.since)
+			assert.Equal(t, MessageTypeHistory, msg.Type)
+			assert.Equal(t, int64(5), msg.Revision)
+			assert.Equal(t, tc.truncated, msg.Truncated)
+			assert.Equal(t, tc.expected, revisions(t, msg))
+		})
+	}
+}
+
+func TestRoomManager_BroadcastEdit(t *testing.T) {
+	rm := NewRoomManager()
+	sender := new(MockWebSocketConn)
+	receiver := new(MockWebSocketConn)
+	noteID := "test-note"
+
+	delivered := make(chan EditMessage, 2)
+	receiver.On("WriteMessage", 1, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		var edit EditMessage
+		_ = json.Unmarshal(args.Get(1).([]byte), &edit)
+		delivered <- edit
+	})
+
+	rm.JoinRoom(noteID, sender, Participant{UserID: "user1"})
+	rm.JoinRoom(noteID, receiver, Participant{UserID: "user2"})
+
+	rm.BroadcastEdit(noteID, sender, 1, EditMessage{Type: MessageTypeEdit, Content: "a"})
+	rm.BroadcastEdit(noteID, sender, 1, EditMessage{Type: MessageTypeEdit, Content: "b"})
+
+	assert.Equal(t, int64(1), (<-delivered).Rev)
+	assert.Equal(t, int64(2), (<-delivered).Rev)
+	sender.AssertNotCalled(t, "WriteMessage", mock.Anything, mock.Anything)
+
+	// History goes away with the room
+	rm.LeaveRoom(noteID, sender)
+	rm.LeaveRoom(noteID, receiver)
+	assert.Equal(t, int64(0), rm.History(noteID, 0).Revision)
+	assert.True(t, rm.History(noteID, 2).Truncated)
+}
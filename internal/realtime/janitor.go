@@ -0,0 +1,265 @@
+package realtime
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"quanta/internal/changelog"
+)
+
+const (
+	// DefaultRoomIdleTimeout is how long a room may go without messages
+	// before the janitor saves and drops its buffers unless configured. A
+	// connection silent for as long, pongs included, is closed as a zombie.
+	DefaultRoomIdleTimeout = 10 * time.Minute
+	// DefaultJanitorInterval is how often the janitor sweeps the rooms
+	DefaultJanitorInterval = time.Minute
+)
+
+// savedNote is the note row a room's document was loaded from, which the
+// janitor saves the document over as long as nobody else saved it first
+type savedNote struct {
+	title     string
+	version   int64
+	encrypted bool
+}
+
+// roomMetrics counts what the janitor and room lifecycle have done since
+// startup
+type roomMetrics struct {
+	roomsClosed   atomic.Int64
+	roomLifetime  atomic.Int64
+	roomsFlushed  atomic.Int64
+	zombiesClosed atomic.Int64
+}
+
+// meanLifetime returns the average time closed rooms stayed open
+func (m *roomMetrics) meanLifetime() time.Duration {
+	closed := m.roomsClosed.Load()
+	if closed == 0 {
+		return 0
+	}
+	return time.Duration(m.roomLifetime.Load() / closed)
+}
+
+// bufferedBytes returns how much edit history and document content the
+// rooms hold in memory
+func (rm *RoomManager) bufferedBytes() int64 {
+	var total int64
+	for _, s := range rm.shards {
+		s.historyMu.Lock()
+		for _, h := range s.history {
+			total += int64(len(h.doc))
+			for _, op := range h.ops {
+				total += int64(len(op))
+			}
+		}
+		s.historyMu.Unlock()
+	}
+	return total
+}
+
+// touch records that a connection is alive, and with message set that it
+// sent the room a message
+func (rm *RoomManager) touch(noteID string, conn WebSocketConn, message bool) {
+	now := time.Now().UnixNano()
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, exists := s.rooms[noteID][conn]
+	if !exists {
+		return
+	}
+	m.seen.Store(now)
+	if message {
+		m.active.Store(now)
+	}
+}
+
+// pongConn counts a connection's pongs as signs of life for the janitor
+type pongConn struct {
+	heartbeatConn
+	seen func()
+}
+
+func (c pongConn) SetPongHandler(h func(appData string) error) {
+	c.heartbeatConn.SetPongHandler(func(appData string) error {
+		c.seen()
+		return h(appData)
+	})
+}
+
+// idleRooms returns the rooms whose members have all been quiet since
+// before cutoff and the connections that haven't been heard from at all
+// since then
+func (s *roomShard) idleRooms(cutoff int64) (idle map[string]bool, zombies []WebSocketConn, zombieRooms []string) {
+	idle = make(map[string]bool)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for noteID, room := range s.rooms {
+		quiet := true
+		for conn, m := range room {
+			if m.active.Load() >= cutoff {
+				quiet = false
+			}
+			if m.seen.Load() < cutoff {
+				zombies = append(zombies, conn)
+				zombieRooms = append(zombieRooms, noteID)
+			}
+		}
+		if quiet {
+			idle[noteID] = true
+		}
+	}
+	return idle, zombies, zombieRooms
+}
+
+// pendingFlush is a document the janitor is saving, as of revision
+type pendingFlush struct {
+	noteID   string
+	doc      string
+	saved    savedNote
+	revision int64
+}
+
+// collect frees the buffers of rooms that are idle or were left with
+// edits still to save, returning the documents that need saving first
+func (s *roomShard) collect(idle map[string]bool, holding func(string) bool) []pendingFlush {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	var flushes []pendingFlush
+	for noteID, h := range s.history {
+		s.mu.RLock()
+		_, open := s.rooms[noteID]
+		s.mu.RUnlock()
+		if open && !idle[noteID] || !open && holding(noteID) {
+			continue
+		}
+		if h.dirty && h.saved != nil {
+			flushes = append(flushes, pendingFlush{noteID: noteID, doc: h.doc, saved: *h.saved, revision: h.revision})
+			continue
+		}
+		s.release(noteID, h, open)
+	}
+	return flushes
+}
+
+// release drops a room's history, or for a room still open only its
+// buffers, keeping the revision so clients' revisions stay valid.
+// s.historyMu must be held.
+func (s *roomShard) release(noteID string, h *roomHistory, open bool) {
+	if !open {
+		delete(s.history, noteID)
+		return
+	}
+	h.ops, h.next = nil, 0
+	h.doc, h.known, h.dirty = "", false, false
+}
+
+// flushed frees a document the janitor tried to save, unless an edit
+// arrived meanwhile. A document that wasn't written because the note was
+// saved elsewhere can't be saved later either.
+func (s *roomShard) flushed(f pendingFlush, written bool) {
+	s.historyMu.Lock()
+	defer s.historyMu.Unlock()
+
+	h, exists := s.history[f.noteID]
+	if !exists || h.revision != f.revision {
+		return
+	}
+	h.dirty = false
+	if written {
+		h.saved.version = f.saved.version + 1
+	} else {
+		h.saved = nil
+	}
+	s.mu.RLock()
+	_, open := s.rooms[f.noteID]
+	s.mu.RUnlock()
+	s.release(f.noteID, h, open)
+}
+
+// flushDocument saves a room's document over its note, unless the note
+// was saved elsewhere since it was loaded. It reports whether it was saved.
+func (h *Handler) flushDocument(ctx context.Context, f pendingFlush) (bool, error) {
+	doc := f.doc
+	var words, chars int
+	if !f.saved.encrypted {
+		words, chars = len(strings.Fields(doc)), utf8.RuneCountInString(doc)
+	}
+	result, err := h.db.ExecContext(ctx,
+		"UPDATE notes SET content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?",
+		doc, len(f.saved.title)+len(doc), words, chars, f.noteID, f.saved.version,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	if err := changelog.Record(ctx, h.db, changelog.Updated, "id = ?", f.noteID); err != nil {
+		log.Printf("Error logging change of note %s: %v", f.noteID, err)
+	}
+	return true, nil
+}
+
+// Sweep saves and frees the documents of idle rooms and of rooms left
+// with unsaved edits, then closes connections that have gone silent.
+// Nothing is saved in maintenance mode; the documents wait for the next
+// sweep after it ends.
+func (h *Handler) Sweep(ctx context.Context, now time.Time) {
+	rm := h.manager
+	cutoff := now.Add(-rm.idleTimeout).UnixNano()
+	for _, s := range rm.shards {
+		idle, zombies, zombieRooms := s.idleRooms(cutoff)
+		for i, conn := range zombies {
+			if rm.dropConnection(zombieRooms[i], conn) {
+				rm.metrics.zombiesClosed.Add(1)
+				log.Printf("Closed zombie connection in room %s", zombieRooms[i])
+			}
+		}
+
+		if h.readOnly() {
+			continue
+		}
+		for _, f := range s.collect(idle, rm.resumes.holding) {
+			ok, err := h.flushDocument(ctx, f)
+			if err != nil {
+				log.Printf("Error saving idle room %s: %v", f.noteID, err)
+				continue
+			}
+			if ok {
+				rm.metrics.roomsFlushed.Add(1)
+			} else {
+				// Someone saved the note since, so theirs is newer
+				log.Printf("Discarded idle room %s's document, the note was saved since", f.noteID)
+			}
+			s.flushed(f, ok)
+		}
+	}
+}
+
+// StartJanitor sweeps the rooms on every interval until stop is closed
+func (h *Handler) StartJanitor(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			h.Sweep(ctx, now)
+			cancel()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package realtime
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var flushQuery = regexp.QuoteMeta("UPDATE notes SET content = ?, size = ?, word_count = ?, char_count = ?, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND version = ?")
+
+// editedRoom returns a Handler whose room for note-1 holds conn and a
+// document loaded at version 3, then edited
+func editedRoom(t *testing.T, conn *MockWebSocketConn) (*Handler, sqlmock.Sqlmock) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	h := NewHandler(db, Options{RoomIdleTimeout: time.Minute})
+	conn.On("WriteMessage", mock.Anything, mock.Anything).Return(nil).Maybe()
+	h.manager.JoinRoom("note-1", conn, Participant{UserID: "user1"})
+	h.manager.seedDocument("note-1", "hello", &savedNote{title: "Note", version: 3})
+	h.manager.BroadcastEdit("note-1", conn, 1, EditMessage{Type: MessageTypeEdit, Content: "hello <b>world</b>"})
+	return h, mockDB
+}
+
+func TestHandler_SweepIdleRoom(t *testing.T) {
+	conn := new(MockWebSocketConn)
+	h, mockDB := editedRoom(t, conn)
+	later := time.Now().Add(2 * time.Minute)
+	// Still answering pings, so not a zombie
+	members(h.manager, "note-1")[conn].seen.Store(later.UnixNano())
+
+	mockDB.ExpectExec(flushQuery).
+		WithArgs("hello <b>world</b>", 22, 2, 18, "note-1", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.Sweep(context.Background(), later)
+
+	// The room stays open, but its buffers are gone
+	assert.True(t, inRoom(h.manager, "note-1", conn))
+	assert.False(t, h.manager.HasDocument("note-1"))
+	history := h.manager.History("note-1", 0)
+	assert.Equal(t, int64(1), history.Revision)
+	assert.Empty(t, history.Ops)
+	stats := h.ServerStats()
+	assert.Equal(t, int64(1), stats.RoomsFlushed)
+	assert.Zero(t, stats.BufferedBytes)
+	assert.Zero(t, stats.ZombiesClosed)
+
+	// Nothing is left to save on the next sweep
+	h.Sweep(context.Background(), later)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestHandler_SweepZombie(t *testing.T) {
+	conn := new(MockWebSocketConn)
+	conn.On("Close").Return(nil)
+	h, mockDB := editedRoom(t, conn)
+
+	// The note was saved elsewhere since the room loaded it
+	mockDB.ExpectExec(flushQuery).WillReturnResult(sqlmock.NewResult(0, 0))
+	h.Sweep(context.Background(), time.Now().Add(2*time.Minute))
+
+	conn.AssertCalled(t, "Close")
+	assert.False(t, inRoom(h.manager, "note-1", conn))
+	assert.False(t, h.manager.HasDocument("note-1"))
+	stats := h.ServerStats()
+	assert.Equal(t, int64(1), stats.ZombiesClosed)
+	assert.Equal(t, int64(1), stats.RoomsClosed)
+	assert.Zero(t, stats.RoomsFlushed)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestHandler_SweepActiveRoom(t *testing.T) {
+	conn := new(MockWebSocketConn)
+	h, mockDB := editedRoom(t, conn)
+
+	// A room that has had messages lately keeps everything
+	h.Sweep(context.Background(), time.Now().Add(30*time.Second))
+	assert.True(t, h.manager.HasDocument("note-1"))
+	assert.Len(t, h.manager.History("note-1", 0).Ops, 1)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
+
+func TestHandler_SweepMaintenance(t *testing.T) {
+	conn := new(MockWebSocketConn)
+	h, mockDB := editedRoom(t, conn)
+	later := time.Now().Add(2 * time.Minute)
+	members(h.manager, "note-1")[conn].seen.Store(later.UnixNano())
+
+	// Nothing is saved while maintenance is on
+	maintenance := true
+	h.readOnly = func() bool { return maintenance }
+	h.Sweep(context.Background(), later)
+	assert.True(t, h.manager.HasDocument("note-1"))
+	assert.Zero(t, h.ServerStats().RoomsFlushed)
+
+	// and the document is saved by the first sweep after it ends
+	maintenance = false
+	mockDB.ExpectExec(flushQuery).
+		WithArgs("hello <b>world</b>", 22, 2, 18, "note-1", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	h.Sweep(context.Background(), later)
+	assert.False(t, h.manager.HasDocument("note-1"))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,76 @@
+package realtime
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultMaxUserConnections is how many note connections one user may
+	// have open at once unless configured
+	DefaultMaxUserConnections = 5
+	// DefaultMaxRoomConnections is how many connections one note's room
+	// may hold unless configured
+	DefaultMaxRoomConnections = 100
+)
+
+var (
+	// ErrTooManyConnections is returned by TryJoinRoom when the user
+	// already has as many connections open as allowed
+	ErrTooManyConnections = errors.New("too many connections for this user")
+	// ErrRoomFull is returned by TryJoinRoom when the room already holds
+	// as many connections as allowed
+	ErrRoomFull = errors.New("room is full")
+)
+
+// connectionLimits caps connections per user and per room, counting the
+// connections refused
+type connectionLimits struct {
+	maxPerUser int
+	maxPerRoom int
+
+	mu        sync.Mutex
+	userConns map[string]int
+
+	rejectedUser atomic.Int64
+	rejectedRoom atomic.Int64
+}
+
+func newConnectionLimits() *connectionLimits {
+	return &connectionLimits{
+		maxPerUser: DefaultMaxUserConnections,
+		maxPerRoom: DefaultMaxRoomConnections,
+		userConns:  make(map[string]int),
+	}
+}
+
+// acquire counts a new connection for a user, refusing it when enforce is
+// set and the user is at their limit
+func (l *connectionLimits) acquire(userID string, enforce bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if enforce && l.userConns[userID] >= l.maxPerUser {
+		l.rejectedUser.Add(1)
+		return ErrTooManyConnections
+	}
+	l.userConns[userID]++
+	return nil
+}
+
+// release uncounts a user's closed connection
+func (l *connectionLimits) release(userID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.userConns[userID] <= 1 {
+		delete(l.userConns, userID)
+		return
+	}
+	l.userConns[userID]--
+}
+
+// TryJoinRoom adds a connection to a room like JoinRoom, unless the room
+// or the participant's user already has as many connections as allowed
+func (rm *RoomManager) TryJoinRoom(noteID string, conn WebSocketConn, participant Participant) error {
+	return rm.join(noteID, conn, participant, true)
+}
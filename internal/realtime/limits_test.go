@@ -0,0 +1,47 @@
+package realtime
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomManager_TryJoinRoom(t *testing.T) {
+	h := NewHandler(nil, Options{MaxUserConnections: 2, MaxRoomConnections: 3})
+	rm := h.manager
+	newConn := func() *MockWebSocketConn {
+		conn := new(MockWebSocketConn)
+		conn.On("WriteMessage", mock.Anything, mock.Anything).Return(nil).Maybe()
+		conn.On("Close").Return(nil).Maybe()
+		return conn
+	}
+
+	// A user's connections count across rooms
+	first := newConn()
+	require.NoError(t, rm.TryJoinRoom("note-1", first, Participant{UserID: "user1"}))
+	require.NoError(t, rm.TryJoinRoom("note-2", newConn(), Participant{UserID: "user1"}))
+	assert.ErrorIs(t, rm.TryJoinRoom("note-3", newConn(), Participant{UserID: "user1"}), ErrTooManyConnections)
+
+	// Rejoining with a connection already in the room isn't a new one
+	assert.NoError(t, rm.TryJoinRoom("note-1", first, Participant{UserID: "user1"}))
+
+	// Leaving frees a place
+	rm.LeaveRoom("note-1", first)
+	assert.NoError(t, rm.TryJoinRoom("note-3", newConn(), Participant{UserID: "user1"}))
+
+	// Rooms fill up whoever is joining
+	require.NoError(t, rm.TryJoinRoom("note-2", newConn(), Participant{UserID: "user2"}))
+	require.NoError(t, rm.TryJoinRoom("note-2", newConn(), Participant{UserID: "user3"}))
+	assert.ErrorIs(t, rm.TryJoinRoom("note-2", newConn(), Participant{UserID: "user4"}), ErrRoomFull)
+
+	stats := h.ServerStats()
+	assert.Equal(t, int64(1), stats.RejectedUserLimit)
+	assert.Equal(t, int64(1), stats.RejectedRoomLimit)
+
+	// Disconnecting a user frees their places too
+	rm.DisconnectUser("user1")
+	assert.NoError(t, rm.TryJoinRoom("note-4", newConn(), Participant{UserID: "user1"}))
+	assert.NoError(t, rm.TryJoinRoom("note-5", newConn(), Participant{UserID: "user1"}))
+}
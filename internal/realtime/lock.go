@@ -0,0 +1,89 @@
+package realtime
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// MessageTypeLock announces that a note was locked, renewed or unlocked
+const MessageTypeLock MessageType = "lock"
+
+// Lock is a user's exclusive hold on editing a note. Everyone else in the
+// room is read-only until it is released or expires.
+type Lock struct {
+	UserID    string    `json:"user-id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// heldByOther reports whether the lock is in force for someone other than
+// userID, who must then not edit
+func (l *Lock) heldByOther(userID string, now time.Time) bool {
+	return l != nil && l.UserID != userID && now.Before(l.ExpiresAt)
+}
+
+// LockMessage carries a note's lock state. Lock is null when the note is
+// unlocked.
+type LockMessage struct {
+	Type MessageType `json:"type"`
+	V    int         `json:"v"`
+	Lock *Lock       `json:"lock"`
+}
+
+// SetLock records a room's lock, or its absence when lock is nil, for
+// checking edits against
+func (rm *RoomManager) SetLock(noteID string, lock *Lock) {
+	s := rm.shard(noteID)
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	if lock == nil {
+		delete(s.locks, noteID)
+		return
+	}
+	s.locks[noteID] = *lock
+}
+
+// Lock returns a room's lock, or nil when it has none
+func (rm *RoomManager) Lock(noteID string) *Lock {
+	s := rm.shard(noteID)
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	lock, ok := s.locks[noteID]
+	if !ok {
+		return nil
+	}
+	return &lock
+}
+
+// dropLock forgets a removed room's lock. The next joiner reloads it.
+func (s *roomShard) dropLock(noteID string) {
+	s.locksMu.Lock()
+	delete(s.locks, noteID)
+	s.locksMu.Unlock()
+}
+
+// SetLock records a note's new lock state, or that it was unlocked when
+// lock is nil, and announces it to the note's room
+func (h *Handler) SetLock(ctx context.Context, noteID string, lock *Lock) {
+	h.manager.SetLock(noteID, lock)
+	h.Publish(ctx, noteID, LockMessage{Type: MessageTypeLock, V: ProtocolVersion, Lock: lock})
+}
+
+// loadLock reads a note's unexpired lock from the database
+func (h *Handler) loadLock(ctx context.Context, noteID string) (*Lock, error) {
+	var lock Lock
+	err := h.db.QueryRowContext(ctx,
+		"SELECT user_id, expires_at FROM note_locks WHERE note_id = ? AND expires_at > ?",
+		noteID, time.Now(),
+	).Scan(&lock.UserID, &lock.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
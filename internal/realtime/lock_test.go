@@ -0,0 +1,42 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLock_HeldByOther(t *testing.T) {
+	now := time.Now()
+	lock := &Lock{UserID: "user1", ExpiresAt: now.Add(time.Minute)}
+
+	assert.True(t, lock.heldByOther("user2", now))
+	assert.False(t, lock.heldByOther("user1", now), "the holder can still edit")
+	assert.False(t, lock.heldByOther("user2", now.Add(2*time.Minute)), "an expired lock holds nobody back")
+
+	var none *Lock
+	assert.False(t, none.heldByOther("user2", now))
+}
+
+func TestRoomManager_SetLock(t *testing.T) {
+	rm := NewRoomManager()
+	conn := new(MockWebSocketConn)
+	conn.On("WriteMessage", mock.Anything, mock.Anything).Return(nil)
+	rm.JoinRoom("note1", conn, Participant{UserID: "user1"})
+
+	assert.Nil(t, rm.Lock("note1"))
+
+	lock := &Lock{UserID: "user1", ExpiresAt: time.Now().Add(time.Minute)}
+	rm.SetLock("note1", lock)
+	assert.Equal(t, lock, rm.Lock("note1"))
+
+	rm.SetLock("note1", nil)
+	assert.Nil(t, rm.Lock("note1"))
+
+	// The cached lock goes with the room
+	rm.SetLock("note1", lock)
+	rm.LeaveRoom("note1", conn)
+	assert.Nil(t, rm.Lock("note1"))
+}
@@ -0,0 +1,103 @@
+package realtime
+
+import (
+	"sync"
+	"time"
+)
+
+// ServerStats is a snapshot of the realtime server's load
+type ServerStats struct {
+	// Rooms is how many notes have at least one connection open
+	Rooms int `json:"rooms"`
+	// Connections is how many WebSocket connections are open
+	Connections int `json:"connections"`
+	// MessagesPerMinute is how many messages clients sent in the last
+	// minute
+	MessagesPerMinute int64 `json:"messages_per_minute"`
+	// RejectedUserLimit counts connections refused since startup because
+	// their user already had as many open as allowed
+	RejectedUserLimit int64 `json:"rejected_user_limit"`
+	// RejectedRoomLimit counts connections refused since startup because
+	// their note's room was full
+	RejectedRoomLimit int64 `json:"rejected_room_limit"`
+	// RoomsClosed counts rooms closed since startup and
+	// MeanRoomLifetimeSeconds how long they stayed open on average
+	RoomsClosed             int64   `json:"rooms_closed"`
+	MeanRoomLifetimeSeconds float64 `json:"mean_room_lifetime_seconds"`
+	// BufferedBytes is how much edit history and note content rooms hold
+	// in memory
+	BufferedBytes int64 `json:"buffered_bytes"`
+	// RoomsFlushed counts idle rooms whose edits the janitor saved, and
+	// ZombiesClosed the silent connections it closed
+	RoomsFlushed  int64 `json:"rooms_flushed"`
+	ZombiesClosed int64 `json:"zombies_closed"`
+}
+
+// rateWindow is how many one-second buckets a messageRate keeps
+const rateWindow = 60
+
+// messageRate counts messages over the last minute in one-second buckets
+type messageRate struct {
+	mu      sync.Mutex
+	counts  [rateWindow]int64
+	seconds [rateWindow]int64
+}
+
+// add counts a message received at now
+func (r *messageRate) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % rateWindow
+	r.mu.Lock()
+	if r.seconds[i] != sec {
+		r.seconds[i], r.counts[i] = sec, 0
+	}
+	r.counts[i]++
+	r.mu.Unlock()
+}
+
+// perMinute returns how many messages were counted in the minute up to now
+func (r *messageRate) perMinute(now time.Time) int64 {
+	sec := now.Unix()
+	var total int64
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, s := range r.seconds {
+		if s > sec-rateWindow && s <= sec {
+			total += r.counts[i]
+		}
+	}
+	return total
+}
+
+// counts returns how many rooms are open and how many connections they hold
+func (rm *RoomManager) counts() (rooms, connections int) {
+	for _, s := range rm.shards {
+		s.mu.RLock()
+		rooms += len(s.rooms)
+		for _, room := range s.rooms {
+			connections += len(room)
+		}
+		s.mu.RUnlock()
+	}
+	return rooms, connections
+}
+
+// ServerStats reports the open rooms and connections, the rate clients are
+// sending messages at, how many connections were refused for exceeding a
+// limit, and the rooms' lifetimes and memory use
+func (h *Handler) ServerStats() ServerStats {
+	rm := h.manager
+	rooms, connections := rm.counts()
+	return ServerStats{
+		Rooms:                   rooms,
+		Connections:             connections,
+		MessagesPerMinute:       h.messages.perMinute(time.Now()),
+		RejectedUserLimit:       rm.limits.rejectedUser.Load(),
+		RejectedRoomLimit:       rm.limits.rejectedRoom.Load(),
+		RoomsClosed:             rm.metrics.roomsClosed.Load(),
+		MeanRoomLifetimeSeconds: rm.metrics.meanLifetime().Seconds(),
+		BufferedBytes:           rm.bufferedBytes(),
+		RoomsFlushed:            rm.metrics.roomsFlushed.Load(),
+		ZombiesClosed:           rm.metrics.zombiesClosed.Load(),
+	}
+}
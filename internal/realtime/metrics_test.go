@@ -0,0 +1,41 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageRate(t *testing.T) {
+	var r messageRate
+	start := time.Unix(1_700_000_000, 0)
+
+	r.add(start)
+	r.add(start)
+	r.add(start.Add(30 * time.Second))
+	assert.Equal(t, int64(3), r.perMinute(start.Add(59*time.Second)))
+
+	// The first two fall out of the window, and a bucket reused a minute
+	// later starts again from zero
+	r.add(start.Add(60 * time.Second))
+	assert.Equal(t, int64(2), r.perMinute(start.Add(60*time.Second)))
+	assert.Equal(t, int64(0), r.perMinute(start.Add(3*time.Minute)))
+}
+
+func TestRoomManagerCounts(t *testing.T) {
+	rm := NewRoomManager()
+	a, b, c := new(MockWebSocketConn), new(MockWebSocketConn), new(MockWebSocketConn)
+	rm.JoinRoom("note1", a, Participant{UserID: "u1"})
+	rm.JoinRoom("note1", b, Participant{UserID: "u2"})
+	rm.JoinRoom("note2", c, Participant{UserID: "u1"})
+
+	rooms, connections := rm.counts()
+	assert.Equal(t, 2, rooms)
+	assert.Equal(t, 3, connections)
+
+	rm.LeaveRoom("note2", c)
+	rooms, connections = rm.counts()
+	assert.Equal(t, 1, rooms)
+	assert.Equal(t, 2, connections)
+}
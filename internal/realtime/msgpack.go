@@ -0,0 +1,367 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// WebSocket subprotocols a client can ask for in Sec-WebSocket-Protocol.
+// Frames are JSON text unless MessagePack is negotiated, in which case
+// every message is a binary frame holding the MessagePack encoding of the
+// same object.
+const (
+	SubprotocolMsgpack = "quanta.msgpack"
+	SubprotocolJSON    = "quanta.json"
+)
+
+// Subprotocols lists the subprotocols the server accepts, most preferred
+// first
+var Subprotocols = []string{SubprotocolMsgpack, SubprotocolJSON}
+
+// maxMsgpackDepth bounds how deeply a client's MessagePack may nest
+const maxMsgpackDepth = 64
+
+// errMsgpack is returned for MessagePack the decoder can't turn into JSON
+var errMsgpack = errors.New("invalid MessagePack")
+
+// codec is how a connection's frames are encoded
+type codec int
+
+const (
+	codecJSON codec = iota
+	codecMsgpack
+)
+
+// codecFor returns the codec negotiated for a connection. Connections that
+// don't report a subprotocol speak JSON.
+func codecFor(conn WebSocketConn) codec {
+	if c, ok := conn.(interface{ Subprotocol() string }); ok && c.Subprotocol() == SubprotocolMsgpack {
+		return codecMsgpack
+	}
+	return codecJSON
+}
+
+// encodeFrame returns a JSON text frame as the codec sends it
+func encodeFrame(c codec, messageType int, payload []byte) (int, []byte, error) {
+	if c != codecMsgpack || messageType != websocket.TextMessage {
+		return messageType, payload, nil
+	}
+	packed, err := jsonToMsgpack(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	return websocket.BinaryMessage, packed, nil
+}
+
+// writeFrame writes a JSON payload straight to a connection in its codec,
+// for messages sent before it has joined a room
+func writeFrame(conn WebSocketConn, payload []byte) error {
+	mt, data, err := encodeFrame(codecFor(conn), websocket.TextMessage, payload)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(mt, data)
+}
+
+// packedFrame is a broadcast frame's MessagePack encoding, made once
+// however many connections need it
+type packedFrame struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// jsonToMsgpack re-encodes a JSON document as MessagePack
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := packValue(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// packValue appends the MessagePack encoding of a decoded JSON value
+func packValue(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			packInt(buf, n)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		_ = binary.Write(buf, binary.BigEndian, f)
+	case string:
+		packLength(buf, len(v), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []any:
+		packLength(buf, len(v), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := packValue(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		packLength(buf, len(v), 0x80, 16, 0, 0xde, 0xdf)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			if err := packValue(buf, k); err != nil {
+				return err
+			}
+			if err := packValue(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode %T as MessagePack", v)
+	}
+	return nil
+}
+
+// packInt appends an integer in the smallest encoding that holds it
+func packInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= math.MaxInt8:
+		buf.WriteByte(byte(n))
+	case n < 0 && n >= -32:
+		buf.WriteByte(byte(int8(n)))
+	case n >= 0 && n <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(n)})
+	case n >= 0 && n <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	case n >= 0:
+		buf.WriteByte(0xcf)
+		_ = binary.Write(buf, binary.BigEndian, uint64(n))
+	case n >= math.MinInt8:
+		buf.Write([]byte{0xd0, byte(int8(n))})
+	case n >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		_ = binary.Write(buf, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		_ = binary.Write(buf, binary.BigEndian, int32(n))
+	default:
+		buf.WriteByte(0xd3)
+		_ = binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// packLength appends the header of a string, array or map of n items:
+// the fix format when n is below fixMax, else the 8, 16 or 32 bit one.
+// Arrays and maps have no 8 bit format, given as 0.
+func packLength(buf *bytes.Buffer, n int, fix byte, fixMax int, f8, f16, f32 byte) {
+	switch {
+	case n < fixMax:
+		buf.WriteByte(fix | byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{f8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(f16)
+		_ = binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(f32)
+		_ = binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// msgpackToJSON re-encodes a client's MessagePack message as JSON, so it
+// can be handled like any other. Binary strings become text and extension
+// types are refused.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	d := &unpacker{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("%w: trailing data", errMsgpack)
+	}
+	return json.Marshal(v)
+}
+
+// unpacker decodes MessagePack into values json.Marshal accepts
+type unpacker struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next n bytes
+func (d *unpacker) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, fmt.Errorf("%w: truncated", errMsgpack)
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of size bytes
+func (d *unpacker) uint(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (d *unpacker) value(depth int) (any, error) {
+	if depth > maxMsgpackDepth {
+		return nil, fmt.Errorf("%w: nested too deeply", errMsgpack)
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch t := b[0]; {
+	case t <= 0x7f:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t&0xe0 == 0xa0:
+		return d.str(int(t & 0x1f))
+	case t&0xf0 == 0x90:
+		return d.array(int(t&0x0f), depth)
+	case t&0xf0 == 0x80:
+		return d.object(int(t&0x0f), depth)
+	case t == 0xc0:
+		return nil, nil
+	case t == 0xc2:
+		return false, nil
+	case t == 0xc3:
+		return true, nil
+	case t >= 0xcc && t <= 0xcf:
+		return d.uint(1 << (t - 0xcc))
+	case t >= 0xd0 && t <= 0xd3:
+		size := 1 << (t - 0xd0)
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, nil
+	case t == 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case t == 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case t == 0xd9 || t == 0xc4:
+		n, err := d.uint(1)
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case t == 0xda || t == 0xc5:
+		n, err := d.uint(2)
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case t == 0xdb || t == 0xc6:
+		n, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case t == 0xdc || t == 0xdd:
+		n, err := d.uint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n), depth)
+	case t == 0xde || t == 0xdf:
+		n, err := d.uint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.object(int(n), depth)
+	default:
+		return nil, fmt.Errorf("%w: unsupported type 0x%02x", errMsgpack, t)
+	}
+}
+
+func (d *unpacker) str(n int) (any, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *unpacker) array(n, depth int) (any, error) {
+	// Every item takes at least a byte, so a length beyond what's left is
+	// refused before allocating for it
+	if n > len(d.data)-d.pos {
+		return nil, fmt.Errorf("%w: truncated", errMsgpack)
+	}
+	items := make([]any, n)
+	for i := range items {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = v
+	}
+	return items, nil
+}
+
+func (d *unpacker) object(n, depth int) (any, error) {
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, fmt.Errorf("%w: truncated", errMsgpack)
+	}
+	obj := make(map[string]any, n)
+	for range n {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: map keys must be strings", errMsgpack)
+		}
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = v
+	}
+	return obj, nil
+}
@@ -0,0 +1,101 @@
+package realtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgpack_RoundTrip(t *testing.T) {
+	testCases := map[string]string{
+		"small ints":     `[0,1,127,128,255,256,65535,65536,4294967295,4294967296]`,
+		"negative ints":  `[-1,-32,-33,-128,-129,-32768,-32769,-2147483648,-2147483649]`,
+		"floats":         `[0.5,-1.25,1e+100]`,
+		"scalars":        `[true,false,null,""]`,
+		"long string":    `"` + strings.Repeat("a", 300) + `"`,
+		"longer string":  `"` + strings.Repeat("é", 40000) + `"`,
+		"long array":     `[` + strings.TrimSuffix(strings.Repeat("1,", 20), ",") + `]`,
+		"nested objects": `{"cursor":{"position":12,"selection":{"end":20,"start":12}},"type":"cursor","v":1}`,
+	}
+	for name, doc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			packed, err := jsonToMsgpack([]byte(doc))
+			require.NoError(t, err)
+			unpacked, err := msgpackToJSON(packed)
+			require.NoError(t, err)
+			assert.JSONEq(t, doc, string(unpacked))
+		})
+	}
+}
+
+func TestMsgpack_Encoding(t *testing.T) {
+	packed, err := jsonToMsgpack([]byte(`{"v":1,"type":"cursor","pos":-1}`))
+	require.NoError(t, err)
+	// Keys are sorted: pos, type, v
+	expected := []byte{0x83, 0xa3, 'p', 'o', 's', 0xff, 0xa4, 't', 'y', 'p', 'e', 0xa6, 'c', 'u', 'r', 's', 'o', 'r', 0xa1, 'v', 0x01}
+	assert.Equal(t, expected, packed)
+
+	// A cursor update is smaller than its JSON
+	cursor, _ := json.Marshal(CursorMessage{Type: MessageTypeCursor, V: 1, UserID: "3f1c2a9e-0b7d-4e55-9a1e-2c4b8d6f0a13", DisplayName: "ada", Color: "#e6194b", Position: Position{Line: 12, Offset: 1042}})
+	packed, err = jsonToMsgpack(cursor)
+	require.NoError(t, err)
+	assert.Less(t, len(packed), len(cursor))
+}
+
+func TestMsgpackToJSON_Invalid(t *testing.T) {
+	testCases := map[string][]byte{
+		"empty":           {},
+		"truncated":       {0xa5, 'a', 'b'},
+		"trailing":        {0x01, 0x02},
+		"huge array":      {0xdd, 0xff, 0xff, 0xff, 0xff},
+		"huge map":        {0xdf, 0xff, 0xff, 0xff, 0xff},
+		"non-string key":  {0x81, 0x01, 0x02},
+		"extension":       {0xd4, 0x01, 0x02},
+		"nested too deep": bytes.Repeat([]byte{0x91}, maxMsgpackDepth+2),
+	}
+	for name, data := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := msgpackToJSON(data)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// msgpackConn is a mock connection that negotiated MessagePack
+type msgpackConn struct {
+	MockWebSocketConn
+}
+
+func (c *msgpackConn) Subprotocol() string { return SubprotocolMsgpack }
+
+func TestRoomManager_BroadcastMixedCodecs(t *testing.T) {
+	rm := NewRoomManager()
+	message := []byte(`{"type":"cursor","v":1}`)
+	packed, err := jsonToMsgpack(message)
+	require.NoError(t, err)
+
+	delivered := make(chan struct{}, 2)
+	jsonConn := new(MockWebSocketConn)
+	jsonConn.On("WriteMessage", 1, message).Return(nil).Run(func(mock.Arguments) { delivered <- struct{}{} })
+	packConn := new(msgpackConn)
+	packConn.On("WriteMessage", 2, packed).Return(nil).Run(func(mock.Arguments) { delivered <- struct{}{} })
+	rm.JoinRoom("note-1", jsonConn, Participant{UserID: "user1"})
+	rm.JoinRoom("note-1", packConn, Participant{UserID: "user2"})
+
+	rm.BroadcastToRoom("note-1", nil, 1, message)
+	for range 2 {
+		select {
+		case <-delivered:
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+	}
+	jsonConn.AssertExpectations(t)
+	packConn.AssertExpectations(t)
+}
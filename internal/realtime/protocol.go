@@ -0,0 +1,126 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// ProtocolVersion is the realtime message format this server speaks. Every
+// message it sends carries it in the v field. Bump it, and keep the old
+// version in SupportedVersions for as long as old clients must keep
+// working, whenever the shape of edit, cursor or presence messages changes.
+const ProtocolVersion = 1
+
+// SupportedVersions lists every protocol version a client may negotiate
+var SupportedVersions = []int{ProtocolVersion}
+
+const (
+	// MessageTypeHello is sent by a client to negotiate a protocol version
+	MessageTypeHello MessageType = "hello"
+	// MessageTypeWelcome answers a hello with the agreed version
+	MessageTypeWelcome MessageType = "welcome"
+)
+
+// Error codes carried by error frames
+const (
+	ErrorCodeUnsupportedVersion = "unsupported_version"
+	ErrorCodeContentTooLarge    = "content_too_large"
+	ErrorCodeBadRequest         = "bad_request"
+	ErrorCodeNoteLocked         = "note_locked"
+	ErrorCodeReadOnly           = "read_only"
+	// ErrorCodeMaintenance refuses edits while the server is in
+	// maintenance mode; the client should keep them and send them again
+	// once it ends
+	ErrorCodeMaintenance = "maintenance"
+	// ErrorCodeNoteDeleted refuses edits to a deleted note and is sent
+	// before its room is closed with CloseNoteNotFound
+	ErrorCodeNoteDeleted = "note_deleted"
+	// ErrorCodeTooManyConnections and ErrorCodeRoomFull are sent just
+	// before a connection over a limit is closed
+	ErrorCodeTooManyConnections = "too_many_connections"
+	ErrorCodeRoomFull           = "room_full"
+	// ErrorCodeStaleRevision and ErrorCodeDocumentUnknown refuse delta
+	// edits the client should redo against the latest revision, or send
+	// as full content, respectively
+	ErrorCodeStaleRevision   = "stale_revision"
+	ErrorCodeDocumentUnknown = "document_unknown"
+	ErrorCodeInvalidOp       = "invalid_op"
+	// ErrorCodeUnauthorized, ErrorCodeNoteNotFound and ErrorCodeInternal
+	// are sent just before a connection is closed with CloseUnauthorized,
+	// CloseNoteNotFound and CloseTryAgainLater respectively
+	ErrorCodeUnauthorized = "unauthorized"
+	ErrorCodeNoteNotFound = "note_not_found"
+	ErrorCodeInternal     = "internal_error"
+)
+
+// Close codes the server ends a connection with, always right after an
+// error frame saying why. The 4000s mirror the matching HTTP statuses.
+const (
+	// CloseUnauthorized means the client isn't signed in, or was signed
+	// out; it should authenticate again rather than reconnect
+	CloseUnauthorized = 4001
+	// CloseNoteNotFound means the note doesn't exist or the user can't
+	// open it; the client should leave the note
+	CloseNoteNotFound = 4004
+	// ClosePolicyViolation means the client broke the protocol; it should
+	// not reconnect as it was
+	ClosePolicyViolation = 4008
+	// CloseTryAgainLater means a limit was reached or the server failed;
+	// the client should reconnect after backing off
+	CloseTryAgainLater = websocket.CloseTryAgainLater
+)
+
+// WelcomeMessage confirms the protocol version for the rest of the connection
+type WelcomeMessage struct {
+	Type MessageType `json:"type"`
+	V    int         `json:"v"`
+}
+
+// ErrorMessage is sent back to a client whose message was rejected.
+// Supported is set on unsupported_version errors so the client can retry
+// the handshake with a version the server understands.
+type ErrorMessage struct {
+	Type      MessageType `json:"type"`
+	V         int         `json:"v"`
+	Code      string      `json:"code"`
+	Error     string      `json:"error"`
+	Supported []int       `json:"supported,omitempty"`
+}
+
+// negotiate picks the newest version offered by the client that the server
+// supports. A client that offers nothing gets the current version.
+func negotiate(offered []int) (int, bool) {
+	if len(offered) == 0 {
+		return ProtocolVersion, true
+	}
+	best := 0
+	for _, v := range offered {
+		if v > best && slices.Contains(SupportedVersions, v) {
+			best = v
+		}
+	}
+	return best, best != 0
+}
+
+// errorFrame encodes the error message for code
+func errorFrame(code, msg string) []byte {
+	e := ErrorMessage{Type: MessageTypeError, V: ProtocolVersion, Code: code, Error: msg}
+	if code == ErrorCodeUnsupportedVersion {
+		e.Supported = SupportedVersions
+	}
+	payload, _ := json.Marshal(e)
+	return payload
+}
+
+// refuse sends a connection that hasn't joined a room an error frame, then
+// closes it with code. The frame goes first as browsers don't expose close
+// reasons reliably.
+func refuse(conn WebSocketConn, code int, errCode, msg string) {
+	if err := writeFrame(conn, errorFrame(errCode, msg)); err != nil {
+		log.Printf("Error sending %s error frame: %v", errCode, err)
+	}
+	closeWithReason(conn, code, msg)
+}
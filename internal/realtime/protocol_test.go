@@ -0,0 +1,46 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		offered  []int
+		expected int
+		ok       bool
+	}{
+		{name: "Nothing Offered", offered: nil, expected: ProtocolVersion, ok: true},
+		{name: "Current Version", offered: []int{ProtocolVersion}, expected: ProtocolVersion, ok: true},
+		{name: "Newest Common Version", offered: []int{ProtocolVersion, ProtocolVersion + 1}, expected: ProtocolVersion, ok: true},
+		{name: "Only Unknown Versions", offered: []int{ProtocolVersion + 1, 99}, ok: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			version, ok := negotiate(tc.offered)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, version)
+		})
+	}
+}
+
+func TestErrorFrame(t *testing.T) {
+	var msg ErrorMessage
+	assert.NoError(t, json.Unmarshal(errorFrame(ErrorCodeUnsupportedVersion, "Protocol version 7 is not supported"), &msg))
+	assert.Equal(t, ErrorMessage{
+		Type:      MessageTypeError,
+		V:         ProtocolVersion,
+		Code:      ErrorCodeUnsupportedVersion,
+		Error:     "Protocol version 7 is not supported",
+		Supported: SupportedVersions,
+	}, msg)
+
+	msg = ErrorMessage{}
+	assert.NoError(t, json.Unmarshal(errorFrame(ErrorCodeContentTooLarge, "too big"), &msg))
+	assert.Empty(t, msg.Supported)
+}
@@ -0,0 +1,221 @@
+package realtime
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// DefaultSendQueueSize is how many outbound messages may be buffered for a
+// connection before it is treated as a slow consumer and disconnected
+const DefaultSendQueueSize = 64
+
+// outboundMessage is a frame waiting to be written to a connection. Text
+// frames hold JSON, which is re-encoded for MessagePack connections.
+type outboundMessage struct {
+	messageType int
+	data        []byte
+	packed      *packedFrame
+	// final closes the connection once the frame is written
+	final bool
+}
+
+// newOutbound returns a frame that can be queued for any number of
+// connections, sharing its MessagePack encoding between them
+func newOutbound(messageType int, data []byte) outboundMessage {
+	return outboundMessage{messageType: messageType, data: data, packed: &packedFrame{}}
+}
+
+// encode returns the frame as a connection speaking c receives it
+func (msg outboundMessage) encode(c codec) (int, []byte, error) {
+	if c != codecMsgpack || msg.messageType != websocket.TextMessage {
+		return msg.messageType, msg.data, nil
+	}
+	msg.packed.once.Do(func() {
+		_, msg.packed.data, msg.packed.err = encodeFrame(c, msg.messageType, msg.data)
+	})
+	return websocket.BinaryMessage, msg.packed.data, msg.packed.err
+}
+
+// member is a connection's membership in a room. Every member owns a
+// buffered send queue drained by its own writer goroutine, so a slow client
+// can never stall broadcasts to the rest of the room.
+type member struct {
+	participant Participant
+	send        chan outboundMessage
+	codec       codec
+	events      *eventSession
+	// seen is when the connection last sent anything, pongs included,
+	// and active when it last sent a message, both in Unix nanoseconds
+	seen   atomic.Int64
+	active atomic.Int64
+}
+
+// RoomStats summarizes the state of a single room
+type RoomStats struct {
+	Connections   int `json:"connections"`
+	SlowConsumers int `json:"slow_consumers"`
+}
+
+// writeLoop drains a member's queue onto its connection until the queue is
+// closed by LeaveRoom. A failed write drops the connection from the room,
+// and a final frame closes the connection once written.
+func (rm *RoomManager) writeLoop(noteID string, conn WebSocketConn, codec codec, send <-chan outboundMessage) {
+	for msg := range send {
+		messageType, data, err := msg.encode(codec)
+		if err != nil {
+			rm.eventsOf(noteID, conn).fail(event{Event: EventBroadcastFailure, Error: err.Error()})
+			continue
+		}
+		if err := conn.WriteMessage(messageType, data); err != nil {
+			rm.eventsOf(noteID, conn).fail(event{Event: EventBroadcastFailure, Error: err.Error()})
+			rm.dropConnection(noteID, conn)
+			// Discard whatever is left so LeaveRoom's close ends the loop
+			for range send {
+			}
+			return
+		}
+		if msg.final {
+			if err := conn.Close(); err != nil {
+				log.Printf("Error closing connection in room %s: %v", noteID, err)
+			}
+			for range send {
+			}
+			return
+		}
+	}
+}
+
+// enqueue queues a message without blocking. It reports false when the
+// member's queue is full. The caller must hold at least a read lock.
+func enqueue(m *member, msg outboundMessage) bool {
+	select {
+	case m.send <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// SendTo queues a message for a single connection in a room
+func (rm *RoomManager) SendTo(noteID string, conn WebSocketConn, messageType int, message []byte) {
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	m, exists := s.rooms[noteID][conn]
+	queued := exists && enqueue(m, newOutbound(messageType, message))
+	s.mu.RUnlock()
+
+	if exists && !queued {
+		rm.dropSlowConsumer(noteID, conn)
+	}
+}
+
+// closeMember removes a connection from its room, then sends it an error
+// frame and closes it with code once everything queued before them is
+// written. A connection whose queue is full is closed straight away.
+func (rm *RoomManager) closeMember(noteID string, conn WebSocketConn, code int, errCode, msg string) {
+	closing := newOutbound(websocket.CloseMessage, websocket.FormatCloseMessage(code, msg))
+	closing.final = true
+
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	m, exists := s.rooms[noteID][conn]
+	queued := exists && enqueue(m, newOutbound(websocket.TextMessage, errorFrame(errCode, msg))) && enqueue(m, closing)
+	s.mu.RUnlock()
+
+	switch {
+	case queued:
+		rm.leave(noteID, conn)
+	case exists:
+		rm.dropConnection(noteID, conn)
+	}
+}
+
+// dropSlowConsumer records a queue overflow for the room and disconnects the client
+func (rm *RoomManager) dropSlowConsumer(noteID string, conn WebSocketConn) {
+	events := rm.eventsOf(noteID, conn)
+	if !rm.dropConnection(noteID, conn) {
+		return
+	}
+
+	s := rm.shard(noteID)
+	s.statsMu.Lock()
+	s.slowConsumers[noteID]++
+	s.statsMu.Unlock()
+	events.fail(event{Event: EventBroadcastFailure, Error: "send queue full"})
+}
+
+// dropConnection removes a connection from its room and closes it, which
+// also ends the connection's read loop in HandleWebSocket. It reports false
+// if the connection had already been removed.
+func (rm *RoomManager) dropConnection(noteID string, conn WebSocketConn) bool {
+	removed, _ := rm.leave(noteID, conn)
+	if !removed {
+		return false
+	}
+
+	if err := conn.Close(); err != nil {
+		log.Printf("Error closing connection in room %s: %v", noteID, err)
+	}
+	return true
+}
+
+// Stats reports the connection count and the number of slow consumers
+// dropped from a room since it was created
+func (rm *RoomManager) Stats(noteID string) RoomStats {
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	connections := len(s.rooms[noteID])
+	s.mu.RUnlock()
+
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	return RoomStats{
+		Connections:   connections,
+		SlowConsumers: s.slowConsumers[noteID],
+	}
+}
+
+// DisconnectUser closes every connection a user has open, in any room,
+// with CloseUnauthorized
+func (rm *RoomManager) DisconnectUser(userID string) {
+	rm.disconnectUserWhere(userID, func(string) bool { return true }, "Signed out")
+}
+
+// disconnectUserWhere closes the connections a user has open in the rooms
+// of notes for which match returns true, with CloseUnauthorized. match is
+// called once per note, without any room locked, so it may query the
+// database.
+func (rm *RoomManager) disconnectUserWhere(userID string, match func(noteID string) bool, reason string) {
+	type roomConn struct {
+		noteID string
+		conn   WebSocketConn
+	}
+
+	var conns []roomConn
+	for _, s := range rm.shards {
+		s.mu.RLock()
+		for noteID, room := range s.rooms {
+			for conn, m := range room {
+				if m.participant.UserID == userID {
+					conns = append(conns, roomConn{noteID: noteID, conn: conn})
+				}
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	matched := map[string]bool{}
+	for _, rc := range conns {
+		ok, seen := matched[rc.noteID]
+		if !seen {
+			ok = match(rc.noteID)
+			matched[rc.noteID] = ok
+		}
+		if ok {
+			rm.closeMember(rc.noteID, rc.conn, CloseUnauthorized, ErrorCodeUnauthorized, reason)
+		}
+	}
+}
@@ -3,14 +3,34 @@
 package realtime
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/maphash"
 	"log"
-	"sync"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"quanta/internal/access"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+	"quanta/internal/models"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("quanta/internal/realtime")
+
 // MessageType represents the type of message being sent
 type MessageType string
 
@@ -21,6 +41,12 @@ const (
 	MessageTypeTyping MessageType = "typing"
 	// MessageTypeCursor represents a cursor position update
 	MessageTypeCursor MessageType = "cursor"
+	// MessageTypePresence represents a single join/leave update
+	MessageTypePresence MessageType = "presence"
+	// MessageTypePresenceList represents the roster sent to a new joiner
+	MessageTypePresenceList MessageType = "presence:list"
+	// MessageTypeError tells the sender why their message was rejected
+	MessageTypeError MessageType = "error"
 )
 
 // PresenceAction represents the type of presence action
@@ -40,168 +66,771 @@ type WebSocketConn interface {
 	Close() error
 }
 
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Participant identifies a user connected to a note room
+type Participant struct {
+	UserID      string `json:"user-id"`
+	DisplayName string `json:"display_name"`
+	Color       string `json:"color"`
+	Role        string `json:"role,omitempty"`
+}
+
 // PresenceMessage represents a presence update message (join/leave)
 type PresenceMessage struct {
-	Type   MessageType    `json:"type"`
-	Action PresenceAction `json:"action"`
-	UserID string         `json:"user-id"`
+	Type        MessageType    `json:"type"`
+	V           int            `json:"v"`
+	Action      PresenceAction `json:"action"`
+	UserID      string         `json:"user-id"`
+	DisplayName string         `json:"display_name"`
+}
+
+// PresenceListMessage lists everyone currently connected to a room
+type PresenceListMessage struct {
+	Type  MessageType   `json:"type"`
+	V     int           `json:"v"`
+	Users []Participant `json:"users"`
 }
 
-// IncomingMessage represents a message from a client
+// IncomingMessage represents a message from a client. V is the protocol
+// version the message was written for; clients that predate versioning
+// omit it and are treated as version 1. Versions is only set on hello.
 type IncomingMessage struct {
-	Type    MessageType `json:"type"`
-	Content string      `json:"content"`
+	Type     MessageType `json:"type"`
+	V        int         `json:"v,omitempty"`
+	Versions []int       `json:"versions,omitempty"`
+	Content  string      `json:"content"`
+	// Ops and Base make a delta edit: ops applied to the room's document
+	// as of revision Base, instead of the full Content
+	Ops      []Op           `json:"ops,omitempty"`
+	Base     int64          `json:"base,omitempty"`
+	Cursor   *CursorPayload `json:"cursor,omitempty"`
+	IsTyping *bool          `json:"is_typing,omitempty"`
 }
 
-// RoomManager handles WebSocket room management with thread safety
+// RoomManager handles WebSocket room management with thread safety. Rooms
+// are spread across shards by note ID, each with its own locks.
 type RoomManager struct {
-	mu    sync.RWMutex
-	rooms map[string]map[WebSocketConn]bool
+	seed        maphash.Seed
+	shards      []*roomShard
+	queueSize   int
+	typingTTL   time.Duration
+	historySize int
+	limits      *connectionLimits
+	resumes     *resumeStore
+	// coalesceInterval is how long cursor and typing updates wait to be
+	// sent together
+	coalesceInterval time.Duration
+	idleTimeout      time.Duration
+	// deletedGrace is how long a deleted note's room stays open
+	deletedGrace time.Duration
+	metrics      roomMetrics
+	eventLog     *eventLog
+	recorder     Recorder
 }
 
 // NewRoomManager creates a new RoomManager instance
 func NewRoomManager() *RoomManager {
-	return &RoomManager{
-		rooms: make(map[string]map[WebSocketConn]bool),
+	return newRoomManager(roomShards)
+}
+
+// newRoomManager creates a RoomManager with the given number of shards
+func newRoomManager(shards int) *RoomManager {
+	rm := &RoomManager{
+		seed:             maphash.MakeSeed(),
+		shards:           make([]*roomShard, shards),
+		queueSize:        DefaultSendQueueSize,
+		typingTTL:        TypingTimeout,
+		historySize:      DefaultHistorySize,
+		limits:           newConnectionLimits(),
+		resumes:          newResumeStore(),
+		coalesceInterval: DefaultCoalesceInterval,
+		idleTimeout:      DefaultRoomIdleTimeout,
+		deletedGrace:     DefaultDeletedGrace,
+		eventLog:         newEventLog(EventLogConfig{}),
+	}
+	for i := range rm.shards {
+		rm.shards[i] = newRoomShard()
 	}
+	return rm
 }
 
-// Global singleton room manager
-var manager = NewRoomManager()
+// JoinRoom adds a connection to a specific note room and starts its writer
+func (rm *RoomManager) JoinRoom(noteID string, conn WebSocketConn, participant Participant) {
+	_ = rm.join(noteID, conn, participant, false)
+}
+
+// join does the work of JoinRoom and TryJoinRoom, refusing the connection
+// when enforce is set and a limit is reached. A connection already in the
+// room only has its participant updated.
+func (rm *RoomManager) join(noteID string, conn WebSocketConn, participant Participant, enforce bool) error {
+	s := rm.shard(noteID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m, exists := s.rooms[noteID][conn]; exists {
+		m.participant = participant
+		return nil
+	}
+	if _, deleted := s.deleted[noteID]; deleted && enforce {
+		return ErrNoteDeleted
+	}
+	if enforce && len(s.rooms[noteID]) >= rm.limits.maxPerRoom {
+		rm.limits.rejectedRoom.Add(1)
+		return ErrRoomFull
+	}
+	if err := rm.limits.acquire(participant.UserID, enforce); err != nil {
+		return err
+	}
+
+	if _, exists := s.rooms[noteID]; !exists {
+		s.rooms[noteID] = make(map[WebSocketConn]*member)
+		s.opened[noteID] = time.Now()
+	}
+
+	m := &member{
+		participant: participant,
+		send:        make(chan outboundMessage, rm.queueSize),
+		codec:       codecFor(conn),
+		events:      rm.eventLog.start(noteID, participant.UserID),
+	}
+	now := time.Now().UnixNano()
+	m.seen.Store(now)
+	m.active.Store(now)
+	s.rooms[noteID][conn] = m
+	go rm.writeLoop(noteID, conn, m.codec, m.send)
+	return nil
+}
 
-// JoinRoom adds a connection to a specific note room
-func (rm *RoomManager) JoinRoom(noteID string, conn WebSocketConn) {
-	rm.mu.Lock()
-	defer rm.mu.Unlock()
+// Participants returns the distinct users connected to a room, ordered by user ID.
+// A user with several open connections is only listed once.
+func (rm *RoomManager) Participants(noteID string) []Participant {
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if _, exists := rm.rooms[noteID]; !exists {
-		rm.rooms[noteID] = make(map[WebSocketConn]bool)
-		log.Printf("Created new note room: %s", noteID)
+	seen := make(map[string]bool)
+	participants := []Participant{}
+	for _, m := range s.rooms[noteID] {
+		if seen[m.participant.UserID] {
+			continue
+		}
+		seen[m.participant.UserID] = true
+		participants = append(participants, m.participant)
 	}
 
-	rm.rooms[noteID][conn] = true
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].UserID < participants[j].UserID
+	})
+	return participants
 }
 
-// LeaveRoom removes a connection from a specific note room
+// LeaveRoom removes a connection from a specific note room and stops its writer
 // Returns true if the room is now empty and was removed
 func (rm *RoomManager) LeaveRoom(noteID string, conn WebSocketConn) bool {
-	rm.mu.Lock()
-	defer rm.mu.Unlock()
+	_, roomRemoved := rm.leave(noteID, conn)
+	return roomRemoved
+}
+
+// leave removes a connection from a room, reporting whether the connection
+// was a member and whether the room was removed as a result. A removed
+// room's edit history and cached lock go with it.
+func (rm *RoomManager) leave(noteID string, conn WebSocketConn) (removed, roomRemoved bool) {
+	s := rm.shard(noteID)
+	s.mu.Lock()
+	m := s.rooms[noteID][conn]
+	removed, roomRemoved = s.removeMember(noteID, conn)
+	if removed {
+		rm.limits.release(m.participant.UserID)
+	}
+	if roomRemoved {
+		rm.metrics.roomsClosed.Add(1)
+		rm.metrics.roomLifetime.Add(int64(time.Since(s.opened[noteID])))
+		delete(s.opened, noteID)
+		rm.endRecording(s, noteID)
+	}
+	s.mu.Unlock()
+	if removed {
+		s.dropPending(noteID, conn)
+	}
 
-	room, exists := rm.rooms[noteID]
+	// Not under s.mu: BroadcastEdit takes the two locks in the other order.
+	// History outlives the room while a dropped client may resume, and
+	// a document with unsaved edits until the janitor saves it.
+	if roomRemoved {
+		if !rm.resumes.holding(noteID) {
+			s.dropHistory(noteID)
+		}
+		s.dropLock(noteID)
+	}
+	return removed, roomRemoved
+}
+
+// removeMember does the work of leave. s.mu must be held.
+func (s *roomShard) removeMember(noteID string, conn WebSocketConn) (removed, roomRemoved bool) {
+	room, exists := s.rooms[noteID]
 	if !exists {
-		return false
+		return false, false
 	}
 
-	delete(room, conn)
+	if m, exists := room[conn]; exists {
+		close(m.send)
+		delete(room, conn)
+		removed = true
+	}
 	if len(room) == 0 {
-		delete(rm.rooms, noteID)
-		log.Printf("Removed empty note room: %s", noteID)
-		return true
+		delete(s.rooms, noteID)
+		if t, ok := s.deleted[noteID]; ok {
+			t.Stop()
+			delete(s.deleted, noteID)
+		}
+		s.statsMu.Lock()
+		delete(s.slowConsumers, noteID)
+		s.statsMu.Unlock()
+		return removed, true
 	}
 
-	return false
+	return removed, false
 }
 
-// BroadcastToRoom sends a message to all connections in a room except the sender
-func (rm *RoomManager) BroadcastToRoom(noteID string, sender WebSocketConn, messageType int, message []byte) {
-	rm.mu.RLock()
-	defer rm.mu.RUnlock()
+// BroadcastToRoom queues a message for all connections in a room except the sender.
+// Connections whose queues are full are disconnected as slow consumers. It
+// returns how many connections the message was queued for.
+func (rm *RoomManager) BroadcastToRoom(noteID string, sender WebSocketConn, messageType int, message []byte) int {
+	msg := newOutbound(messageType, message)
 
-	room, exists := rm.rooms[noteID]
-	if !exists {
-		return
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	var slow []WebSocketConn
+	queued := 0
+	for conn, m := range s.rooms[noteID] {
+		if conn == sender {
+			continue
+		}
+		if enqueue(m, msg) {
+			queued++
+		} else {
+			slow = append(slow, conn)
+		}
 	}
+	s.mu.RUnlock()
 
-	for conn := range room {
-		if conn != sender {
-			if err := conn.WriteMessage(messageType, message); err != nil {
-				log.Printf("Broadcast error to a client in room %s: %v", noteID, err)
+	for _, conn := range slow {
+		rm.dropSlowConsumer(noteID, conn)
+	}
+	return queued
+}
+
+// BroadcastAll queues a message for every connection in every room,
+// disconnecting slow consumers as BroadcastToRoom does. It returns how many
+// connections the message was queued for.
+func (rm *RoomManager) BroadcastAll(messageType int, message []byte) int {
+	type roomConn struct {
+		noteID string
+		conn   WebSocketConn
+	}
+
+	msg := newOutbound(messageType, message)
+	var slow []roomConn
+	queued := 0
+	for _, s := range rm.shards {
+		s.mu.RLock()
+		for noteID, room := range s.rooms {
+			for conn, m := range room {
+				if enqueue(m, msg) {
+					queued++
+				} else {
+					slow = append(slow, roomConn{noteID: noteID, conn: conn})
+				}
 			}
 		}
+		s.mu.RUnlock()
+	}
+
+	for _, rc := range slow {
+		rm.dropSlowConsumer(rc.noteID, rc.conn)
 	}
+	return queued
 }
 
-// HandleWebSocket handles WebSocket connections for note collaboration
-func HandleWebSocket(c *fiber.Ctx) error {
+// DefaultQueryTimeout bounds connection setup lookups when no timeout is set
+const DefaultQueryTimeout = 5 * time.Second
+
+// Handler serves the realtime collaboration endpoints
+type Handler struct {
+	db           DBInterface
+	manager      *RoomManager
+	heartbeat    HeartbeatConfig
+	queryTimeout time.Duration
+	limits       models.NoteLimits
+	// messages counts the messages clients send, for ServerStats
+	messages *messageRate
+	readOnly func() bool
+}
+
+// Options configures a Handler. Zero values fall back to the defaults.
+type Options struct {
+	Heartbeat HeartbeatConfig
+	// QueryTimeout bounds the lookups made while setting up a WebSocket
+	// connection
+	QueryTimeout time.Duration
+	Limits       models.NoteLimits
+	// HistorySize is how many recent edits each room keeps for replay
+	HistorySize int
+	// MaxUserConnections caps the note connections one user may have open
+	MaxUserConnections int
+	// MaxRoomConnections caps the connections one note's room may hold
+	MaxRoomConnections int
+	// ResumeGrace is how long a dropped connection's session can be
+	// resumed before the room is told the user left
+	ResumeGrace time.Duration
+	// CoalesceInterval is how long cursor and typing updates are held so
+	// a room's updates go out together in one frame
+	CoalesceInterval time.Duration
+	// RoomIdleTimeout is how long a room may go without messages before
+	// its document is saved and its buffers dropped, and how long a
+	// connection may go unheard from before it is closed
+	RoomIdleTimeout time.Duration
+	// EventLog controls the structured log of joins, leaves, applied
+	// edits and broadcast failures
+	EventLog EventLogConfig
+	// DeletedGrace is how long the room of a deleted note stays open,
+	// read-only, before everyone in it is disconnected
+	DeletedGrace time.Duration
+	// Recorder records the edits of rooms in workspaces that record
+	// collaboration sessions. Nil records nothing.
+	Recorder Recorder
+	// ReadOnly reports whether the server is in maintenance mode. While
+	// it is, edits are refused and idle rooms' documents are kept unsaved
+	// until it ends. Nil never is.
+	ReadOnly func() bool
+}
+
+// NewHandler creates a new Handler with its own RoomManager
+func NewHandler(db DBInterface, opts Options) *Handler {
+	manager := NewRoomManager()
+	if opts.HistorySize > 0 {
+		manager.historySize = opts.HistorySize
+	}
+	if opts.MaxUserConnections > 0 {
+		manager.limits.maxPerUser = opts.MaxUserConnections
+	}
+	if opts.MaxRoomConnections > 0 {
+		manager.limits.maxPerRoom = opts.MaxRoomConnections
+	}
+	if opts.ResumeGrace > 0 {
+		manager.resumes.grace = opts.ResumeGrace
+	}
+	if opts.CoalesceInterval > 0 {
+		manager.coalesceInterval = opts.CoalesceInterval
+	}
+	if opts.RoomIdleTimeout > 0 {
+		manager.idleTimeout = opts.RoomIdleTimeout
+	}
+	if opts.DeletedGrace > 0 {
+		manager.deletedGrace = opts.DeletedGrace
+	}
+	manager.eventLog = newEventLog(opts.EventLog)
+	manager.recorder = opts.Recorder
+	if opts.QueryTimeout <= 0 {
+		opts.QueryTimeout = DefaultQueryTimeout
+	}
+	if opts.ReadOnly == nil {
+		opts.ReadOnly = func() bool { return false }
+	}
+
+	return &Handler{
+		db:           db,
+		manager:      manager,
+		heartbeat:    opts.Heartbeat.withDefaults(),
+		queryTimeout: opts.QueryTimeout,
+		limits:       opts.Limits.WithDefaults(),
+		messages:     &messageRate{},
+		readOnly:     opts.ReadOnly,
+	}
+}
+
+// Publish sends a server-originated message to everyone in a note's room,
+// recording the broadcast as a span of ctx
+func (h *Handler) Publish(ctx context.Context, noteID string, message any) {
+	_, span := tracer.Start(ctx, "realtime.Publish", trace.WithAttributes(attribute.String("note.id", noteID)))
+	defer span.End()
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshalling published message: %v", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	queued := h.manager.BroadcastToRoom(noteID, nil, websocket.TextMessage, payload)
+	span.SetAttributes(attribute.Int("realtime.recipients", queued))
+}
+
+// Broadcast sends a server-originated message to everyone connected to
+// any note
+func (h *Handler) Broadcast(message any) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshalling broadcast message: %v", err)
+		return
+	}
+	h.manager.BroadcastAll(websocket.TextMessage, payload)
+}
+
+// DisconnectUser closes all of a user's realtime connections
+func (h *Handler) DisconnectUser(userID string) {
+	h.manager.DisconnectUser(userID)
+}
+
+// RevokeAccess closes a user's connections to notes they may no longer
+// open, such as those of a workspace they were removed from. Connections
+// whose access can't be checked are left open.
+func (h *Handler) RevokeAccess(ctx context.Context, userID string) {
+	h.manager.disconnectUserWhere(userID, func(noteID string) bool {
+		allowed, err := access.CanOpen(ctx, h.db, noteID, userID)
+		if err != nil {
+			log.Printf("Error checking note access: %v", err)
+			return false
+		}
+		return !allowed
+	}, "Access revoked")
+}
+
+// displayName resolves a human readable name for a user: their chosen
+// display name, else the local part of their email, else the user ID
+func (h *Handler) displayName(ctx context.Context, userID string) string {
+	var name, email string
+	err := h.db.QueryRowContext(ctx, "SELECT COALESCE(display_name, ''), email FROM users WHERE id = ?", userID).Scan(&name, &email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error looking up display name for %s: %v", userID, err)
+		}
+		return userID
+	}
+
+	if name != "" {
+		return name
+	}
+	name, _, _ = strings.Cut(email, "@")
+	return name
+}
+
+// closeWithReason sends a close frame with the given code and reason
+func closeWithReason(conn WebSocketConn, code int, reason string) {
+	if err := conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason)); err != nil {
+		log.Printf("Error sending close frame: %v", err)
+	}
+}
+
+// GetPresence lists the users currently connected to a note's room
+func (h *Handler) GetPresence(c *fiber.Ctx) error {
+	noteID := c.Params("id")
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := access.CanOpen(c.UserContext(), h.db, noteID, userID)
+	if err != nil {
+		return fmt.Errorf("checking note access: %w", err)
+	}
+	if !allowed {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+
+	return c.JSON(fiber.Map{
+		"note_id": noteID,
+		"users":   h.manager.Participants(noteID),
+	})
+}
+
+// HandleWebSocket handles WebSocket connections for note collaboration.
+// Clients that negotiate the quanta.msgpack subprotocol exchange
+// MessagePack binary frames instead of JSON text.
+func (h *Handler) HandleWebSocket(c *fiber.Ctx) error {
 	return websocket.New(func(c *websocket.Conn) {
 		noteID := c.Params("id")
 		if noteID == "" {
-			if err := c.WriteJSON(fiber.Map{
-				"error": "Missing note ID",
-			}); err != nil {
-				log.Printf("Error sending missing note ID message: %v", err)
-			}
+			refuse(c, ClosePolicyViolation, ErrorCodeBadRequest, "Missing note ID")
 			return
 		}
 
-		userIDInterface := c.Locals("user-id")
-		userID, ok := userIDInterface.(string)
-		if !ok {
-			if err := c.WriteJSON(fiber.Map{
-				"error": "User ID not found in context",
-			}); err != nil {
-				log.Printf("Error sending user ID not found message: %v", err)
-			}
+		userID, err := auth.UserIDFromConn(c)
+		if err != nil {
+			refuse(c, CloseUnauthorized, ErrorCodeUnauthorized, "Not signed in")
+			return
+		}
+
+		// The upgraded connection outlives the HTTP request, so queries get
+		// their own deadline rather than the request context
+		ctx, cancel := context.WithTimeout(context.Background(), h.queryTimeout)
+		defer cancel()
+
+		// Failed lookups are worth retrying; a note the user can't open
+		// is reported as missing, as the REST API does
+		allowed, err := access.CanOpen(ctx, h.db, noteID, userID)
+		if err != nil {
+			log.Printf("Error checking note access: %v", err)
+			refuse(c, CloseTryAgainLater, ErrorCodeInternal, "Internal server error")
+			return
+		}
+		if !allowed {
+			refuse(c, CloseNoteNotFound, ErrorCodeNoteNotFound, "Note not found or unauthorized")
+			return
+		}
+
+		lock, err := h.loadLock(ctx, noteID)
+		if err != nil {
+			log.Printf("Error loading note lock: %v", err)
+			refuse(c, CloseTryAgainLater, ErrorCodeInternal, "Internal server error")
+			return
+		}
+		role, err := h.noteRole(ctx, noteID, userID)
+		if err != nil {
+			log.Printf("Error loading note role: %v", err)
+			refuse(c, CloseTryAgainLater, ErrorCodeInternal, "Internal server error")
 			return
 		}
 
+		participant := Participant{
+			UserID:      userID,
+			DisplayName: h.displayName(ctx, userID),
+			Color:       colorFor(userID),
+			Role:        role,
+		}
+
 		joinPayload, _ := json.Marshal(PresenceMessage{
-			Type:   "presence",
-			Action: PresenceActionJoin,
-			UserID: userID,
+			Type:        MessageTypePresence,
+			V:           ProtocolVersion,
+			Action:      PresenceActionJoin,
+			UserID:      userID,
+			DisplayName: participant.DisplayName,
+		})
+		if err := h.manager.TryJoinRoom(noteID, c, participant); err != nil {
+			if errors.Is(err, ErrNoteDeleted) {
+				refuse(c, CloseNoteNotFound, ErrorCodeNoteDeleted, "This note was deleted")
+				return
+			}
+			code, msg := ErrorCodeRoomFull, fmt.Sprintf("This note already has %d connections open", h.manager.limits.maxPerRoom)
+			if errors.Is(err, ErrTooManyConnections) {
+				code, msg = ErrorCodeTooManyConnections, fmt.Sprintf("You already have %d note connections open", h.manager.limits.maxPerUser)
+			}
+			refuse(c, CloseTryAgainLater, code, msg)
+			return
+		}
+		stopHeartbeat := startHeartbeat(pongConn{heartbeatConn: c, seen: func() {
+			h.manager.touch(noteID, c, false)
+		}}, h.heartbeat)
+		defer stopHeartbeat()
+
+		h.startRecording(ctx, noteID)
+
+		// The first joiner gives the room the saved content delta edits
+		// apply to; after that, edits keep it current
+		if !h.manager.HasDocument(noteID) {
+			if content, saved, err := h.loadContent(ctx, noteID); err != nil {
+				log.Printf("Error loading note content: %v", err)
+			} else {
+				h.manager.seedDocument(noteID, content, &saved)
+			}
+		}
+
+		h.manager.SetLock(noteID, lock)
+		// A client resuming a dropped session never appeared to leave, so
+		// the room isn't told it joined either
+		resumed := h.manager.resumes.take(c.Query("resume"), noteID, userID)
+		if !resumed {
+			h.manager.BroadcastToRoom(noteID, c, websocket.TextMessage, joinPayload)
+		}
+		token, err := h.manager.resumes.issue(noteID, userID)
+		if err != nil {
+			log.Printf("Error issuing resume token: %v", err)
+		} else {
+			sessionPayload, _ := json.Marshal(SessionMessage{
+				Type:        MessageTypeSession,
+				V:           ProtocolVersion,
+				ResumeToken: token,
+				Resumed:     resumed,
+				ResumeGrace: int(h.manager.resumes.grace.Seconds()),
+			})
+			h.manager.SendTo(noteID, c, websocket.TextMessage, sessionPayload)
+		}
+
+		// Let the new joiner know who is already here
+		rosterPayload, _ := json.Marshal(PresenceListMessage{
+			Type:  MessageTypePresenceList,
+			V:     ProtocolVersion,
+			Users: h.manager.Participants(noteID),
 		})
-		manager.JoinRoom(noteID, c)
-		manager.BroadcastToRoom(noteID, c, websocket.TextMessage, joinPayload)
-		log.Println("User joined note room:", noteID)
+		h.manager.SendTo(noteID, c, websocket.TextMessage, rosterPayload)
+
+		// Tell the joiner whether someone else holds the lock, in which
+		// case the client should open the note read-only
+		lockPayload, _ := json.Marshal(LockMessage{Type: MessageTypeLock, V: ProtocolVersion, Lock: lock})
+		h.manager.SendTo(noteID, c, websocket.TextMessage, lockPayload)
 
-		// Ensure user is removed from room when connection closes
+		// Replay the edits a reconnecting client missed. ?since= is the last
+		// revision it applied; without it every buffered edit is sent.
+		since, _ := strconv.ParseInt(c.Query("since"), 10, 64)
+		historyPayload, _ := json.Marshal(h.manager.History(noteID, max(since, 0)))
+		h.manager.SendTo(noteID, c, websocket.TextMessage, historyPayload)
+		events := h.manager.eventsOf(noteID, c)
+		events.record(event{Event: EventJoin, Resumed: resumed})
+
+		// Ensure user is removed from room when connection closes. The
+		// room is only told the user left once the session can no longer
+		// be resumed, and not at all if another connection resumed it.
 		defer func() {
 			leavePayload, _ := json.Marshal(PresenceMessage{
-				Type:   "presence",
-				Action: PresenceActionLeave,
-				UserID: userID,
+				Type:        MessageTypePresence,
+				V:           ProtocolVersion,
+				Action:      PresenceActionLeave,
+				UserID:      userID,
+				DisplayName: participant.DisplayName,
+			})
+			announce := func() {
+				h.manager.BroadcastToRoom(noteID, nil, websocket.TextMessage, leavePayload)
+			}
+			held := token != "" && h.manager.resumes.hold(token, func() {
+				announce()
+				h.manager.forgetIfEmpty(noteID)
 			})
-			manager.LeaveRoom(noteID, c)
-			manager.BroadcastToRoom(noteID, c, websocket.TextMessage, leavePayload)
-			log.Println("User left note room:", noteID)
+			h.manager.LeaveRoom(noteID, c)
+			events.record(event{Event: EventLeave, DurationMS: events.elapsed().Milliseconds()})
+			if token != "" && !held {
+				return
+			}
+			h.manager.StopTyping(noteID, userID)
+			if token == "" {
+				announce()
+			}
 		}()
 
+		// Frames far beyond the content limit close the connection rather
+		// than being buffered. JSON escaping can grow content up to six-fold.
+		c.SetReadLimit(int64(h.limits.MaxContentBytes)*6 + 64<<10)
+
 		for {
 			mt, message, err := c.ReadMessage()
 			if err != nil {
 				break
 			}
+			h.messages.add(time.Now())
+			h.manager.touch(noteID, c, true)
+			if mt == websocket.BinaryMessage {
+				if codecFor(c) != codecMsgpack {
+					events.record(event{Event: EventInvalidMessage, Error: "binary message on a JSON connection"})
+					continue
+				}
+				if message, err = msgpackToJSON(message); err != nil {
+					events.record(event{Event: EventInvalidMessage, Error: err.Error()})
+					continue
+				}
+			}
 
 			var incoming IncomingMessage
 			if err := json.Unmarshal(message, &incoming); err != nil {
-				log.Printf("Invalid message JSON: %v", err)
+				events.record(event{Event: EventInvalidMessage, Error: err.Error()})
 				continue
 			}
 
-			if incoming.Type == "" || incoming.Content == "" {
-				log.Printf("Invalid message received: missing type or content")
+			if incoming.Type == MessageTypeHello {
+				version, ok := negotiate(incoming.Versions)
+				if !ok {
+					h.manager.SendTo(noteID, c, websocket.TextMessage,
+						errorFrame(ErrorCodeUnsupportedVersion, "None of the offered protocol versions are supported"))
+					continue
+				}
+				welcome, _ := json.Marshal(WelcomeMessage{Type: MessageTypeWelcome, V: version})
+				h.manager.SendTo(noteID, c, websocket.TextMessage, welcome)
 				continue
 			}
-
-			// Validate message type
-			switch incoming.Type {
-			case MessageTypeEdit, MessageTypeTyping, MessageTypeCursor:
-			default:
-				log.Printf("Invalid message type: %s", incoming.Type)
+			if incoming.V != 0 && !slices.Contains(SupportedVersions, incoming.V) {
+				h.manager.SendTo(noteID, c, websocket.TextMessage,
+					errorFrame(ErrorCodeUnsupportedVersion, fmt.Sprintf("Protocol version %d is not supported", incoming.V)))
 				continue
 			}
 
-			outgoing := map[string]interface{}{
-				"type":    incoming.Type,
-				"content": incoming.Content,
-				"user-id": userID,
-			}
-			rebroadcast, err := json.Marshal(outgoing)
-			if err != nil {
-				log.Printf("Error marshalling outgoing message: %v", err)
+			switch incoming.Type {
+			case MessageTypeCursor:
+				if incoming.Cursor == nil {
+					events.record(event{Event: EventInvalidMessage, Error: "cursor message without a cursor"})
+					continue
+				}
+				if err := incoming.Cursor.validate(); err != nil {
+					events.record(event{Event: EventInvalidMessage, Error: err.Error()})
+					continue
+				}
+				h.manager.BroadcastCursor(noteID, c, CursorMessage{
+					Type:        MessageTypeCursor,
+					V:           ProtocolVersion,
+					UserID:      userID,
+					DisplayName: participant.DisplayName,
+					Color:       participant.Color,
+					Position:    incoming.Cursor.Position,
+					Selection:   incoming.Cursor.Selection,
+				})
+				continue
+			case MessageTypeTyping:
+				// Typing state is aggregated server-side; an explicit
+				// is_typing=false stops the indicator, anything else refreshes it
+				if incoming.IsTyping != nil && !*incoming.IsTyping {
+					h.manager.StopTyping(noteID, userID)
+				} else {
+					h.manager.SetTyping(noteID, participant)
+				}
+				continue
+			case MessageTypeEdit:
+				if incoming.Content == "" && len(incoming.Ops) == 0 {
+					events.record(event{Event: EventInvalidMessage, Error: "edit without content or ops"})
+					continue
+				}
+				// A deleted note's room stays open, read-only, until it closes
+				if h.manager.isDeleted(noteID) {
+					h.manager.SendTo(noteID, c, websocket.TextMessage,
+						errorFrame(ErrorCodeNoteDeleted, "This note was deleted"))
+					continue
+				}
+				if h.readOnly() {
+					h.manager.SendTo(noteID, c, websocket.TextMessage,
+						errorFrame(ErrorCodeMaintenance, "Down for maintenance, try again later"))
+					continue
+				}
+				// Viewers and commenters get a read-only connection
+				if !h.manager.canEdit(noteID, c) {
+					h.manager.SendTo(noteID, c, websocket.TextMessage,
+						errorFrame(ErrorCodeReadOnly, "You have read-only access to this note"))
+					continue
+				}
+				if h.manager.Lock(noteID).heldByOther(userID, time.Now()) {
+					h.manager.SendTo(noteID, c, websocket.TextMessage,
+						errorFrame(ErrorCodeNoteLocked, "Note is locked by another user"))
+					continue
+				}
+				if len(incoming.Ops) > 0 {
+					h.applyOps(noteID, c, participant, incoming)
+					continue
+				}
+				if n := h.limits.MaxContentBytes; len(incoming.Content) > n {
+					h.manager.SendTo(noteID, c, websocket.TextMessage,
+						errorFrame(ErrorCodeContentTooLarge, fmt.Sprintf("Note content exceeds the %d byte limit", n)))
+					continue
+				}
+				h.manager.BroadcastEdit(noteID, c, websocket.TextMessage, EditMessage{
+					Type:        MessageTypeEdit,
+					V:           ProtocolVersion,
+					Content:     incoming.Content,
+					UserID:      userID,
+					DisplayName: participant.DisplayName,
+				})
+				continue
+			default:
+				events.record(event{Event: EventInvalidMessage, Error: fmt.Sprintf("unknown message type %q", incoming.Type)})
 			}
-			manager.BroadcastToRoom(noteID, c, mt, rebroadcast)
 		}
-	})(c)
+	}, websocket.Config{Subprotocols: Subprotocols})(c)
 }
@@ -1,8 +1,21 @@
 package realtime
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -33,15 +46,15 @@ func TestRoomManager_JoinRoom(t *testing.T) {
 	noteID := "test-note"
 
 	// Test joining a new room
-	rm.JoinRoom(noteID, mockConn)
-	assert.Contains(t, rm.rooms, noteID)
-	assert.Contains(t, rm.rooms[noteID], mockConn)
+	rm.JoinRoom(noteID, mockConn, Participant{UserID: "user1"})
+	assert.NotNil(t, members(rm, noteID))
+	assert.Contains(t, members(rm, noteID), mockConn)
 
 	// Test joining an existing room
 	mockConn2 := new(MockWebSocketConn)
-	rm.JoinRoom(noteID, mockConn2)
-	assert.Contains(t, rm.rooms[noteID], mockConn2)
-	assert.Equal(t, 2, len(rm.rooms[noteID]))
+	rm.JoinRoom(noteID, mockConn2, Participant{UserID: "user2"})
+	assert.Contains(t, members(rm, noteID), mockConn2)
+	assert.Equal(t, 2, len(members(rm, noteID)))
 }
 
 func TestRoomManager_LeaveRoom(t *testing.T) {
@@ -53,18 +66,18 @@ func TestRoomManager_LeaveRoom(t *testing.T) {
 	assert.False(t, rm.LeaveRoom(noteID, mockConn))
 
 	// Test leaving an existing room
-	rm.JoinRoom(noteID, mockConn)
+	rm.JoinRoom(noteID, mockConn, Participant{UserID: "user1"})
 	assert.True(t, rm.LeaveRoom(noteID, mockConn))
-	assert.NotContains(t, rm.rooms, noteID)
+	assert.Nil(t, members(rm, noteID))
 
 	// Test leaving a room with multiple connections
 	mockConn1 := new(MockWebSocketConn)
 	mockConn2 := new(MockWebSocketConn)
-	rm.JoinRoom(noteID, mockConn1)
-	rm.JoinRoom(noteID, mockConn2)
+	rm.JoinRoom(noteID, mockConn1, Participant{UserID: "user1"})
+	rm.JoinRoom(noteID, mockConn2, Participant{UserID: "user2"})
 	assert.False(t, rm.LeaveRoom(noteID, mockConn1))
-	assert.Contains(t, rm.rooms, noteID)
-	assert.Equal(t, 1, len(rm.rooms[noteID]))
+	assert.NotNil(t, members(rm, noteID))
+	assert.Equal(t, 1, len(members(rm, noteID)))
 }
 
 func TestRoomManager_BroadcastToRoom(t *testing.T) {
@@ -75,23 +88,129 @@ func TestRoomManager_BroadcastToRoom(t *testing.T) {
 	message := []byte("test message")
 
 	mockConn1.On("WriteMessage", 1, message).Return(nil)
-	mockConn2.On("WriteMessage", 1, message).Return(nil)
+	delivered := make(chan struct{}, 1)
+	mockConn2.On("WriteMessage", 1, message).Return(nil).Run(func(mock.Arguments) {
+		delivered <- struct{}{}
+	})
 
 	// Test broadcasting to empty room
 	rm.BroadcastToRoom(noteID, mockConn1, 1, message)
 
 	// Test broadcasting to room with one connection
-	rm.JoinRoom(noteID, mockConn1)
+	rm.JoinRoom(noteID, mockConn1, Participant{UserID: "user1"})
 	rm.BroadcastToRoom(noteID, mockConn1, 1, message)
 
 	// Test broadcasting to room with multiple connections
-	rm.JoinRoom(noteID, mockConn2)
+	rm.JoinRoom(noteID, mockConn2, Participant{UserID: "user2"})
 	rm.BroadcastToRoom(noteID, mockConn1, 1, message)
 
+	// Verify that other connection received the message
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered")
+	}
 	// Verify that sender didn't receive the message
 	mockConn1.AssertNotCalled(t, "WriteMessage", 1, message)
-	// Verify that other connection received the message
-	mockConn2.AssertCalled(t, "WriteMessage", 1, message)
+}
+
+func TestRoomManager_BroadcastAll(t *testing.T) {
+	rm := NewRoomManager()
+	message := []byte("maintenance")
+	delivered := make(chan string, 2)
+	for _, noteID := range []string{"note-1", "note-2"} {
+		conn := new(MockWebSocketConn)
+		conn.On("WriteMessage", 1, message).Return(nil).Run(func(mock.Arguments) {
+			delivered <- noteID
+		})
+		rm.JoinRoom(noteID, conn, Participant{UserID: "user-" + noteID})
+	}
+
+	assert.Equal(t, 2, rm.BroadcastAll(1, message))
+	got := map[string]bool{}
+	for range 2 {
+		select {
+		case noteID := <-delivered:
+			got[noteID] = true
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+	}
+	assert.Equal(t, map[string]bool{"note-1": true, "note-2": true}, got)
+}
+
+// inRoom reports whether a connection is currently a member of a room
+func inRoom(rm *RoomManager, noteID string, conn WebSocketConn) bool {
+	_, exists := members(rm, noteID)[conn]
+	return exists
+}
+
+// members returns a snapshot of a room's connections, nil if there is no room
+func members(rm *RoomManager, noteID string) map[WebSocketConn]*member {
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	room, exists := s.rooms[noteID]
+	if !exists {
+		return nil
+	}
+	return maps.Clone(room)
+}
+
+func TestRoomManager_BroadcastReapsDeadConnections(t *testing.T) {
+	rm := NewRoomManager()
+	healthy := new(MockWebSocketConn)
+	dead := new(MockWebSocketConn)
+	noteID := "test-note"
+	message := []byte("test message")
+
+	healthy.On("WriteMessage", 1, message).Return(nil)
+	dead.On("WriteMessage", 1, message).Return(errors.New("broken pipe"))
+	dead.On("Close").Return(nil)
+
+	rm.JoinRoom(noteID, healthy, Participant{UserID: "user1"})
+	rm.JoinRoom(noteID, dead, Participant{UserID: "user2"})
+	rm.BroadcastToRoom(noteID, nil, 1, message)
+
+	assert.Eventually(t, func() bool {
+		return !inRoom(rm, noteID, dead)
+	}, time.Second, 5*time.Millisecond)
+	dead.AssertCalled(t, "Close")
+	assert.True(t, inRoom(rm, noteID, healthy))
+}
+
+func TestRoomManager_SlowConsumer(t *testing.T) {
+	rm := NewRoomManager()
+	rm.queueSize = 1
+	noteID := "test-note"
+
+	// A client that never finishes its first write backs up its queue
+	release := make(chan struct{})
+	defer close(release)
+	slow := new(MockWebSocketConn)
+	slow.On("WriteMessage", 1, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		<-release
+	})
+	slow.On("Close").Return(nil)
+	delivered := make(chan struct{}, 1)
+	fast := new(MockWebSocketConn)
+	fast.On("WriteMessage", 1, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+		delivered <- struct{}{}
+	})
+
+	rm.JoinRoom(noteID, slow, Participant{UserID: "user1"})
+	rm.JoinRoom(noteID, fast, Participant{UserID: "user2"})
+
+	// The fast client keeps receiving while the slow one falls behind
+	for i := 0; i < 5; i++ {
+		rm.BroadcastToRoom(noteID, nil, 1, []byte("message"))
+		<-delivered
+	}
+
+	assert.False(t, inRoom(rm, noteID, slow))
+	assert.True(t, inRoom(rm, noteID, fast))
+	slow.AssertCalled(t, "Close")
+	assert.Equal(t, RoomStats{Connections: 1, SlowConsumers: 1}, rm.Stats(noteID))
 }
 
 func TestRoomManager_ConcurrentAccess(t *testing.T) {
@@ -108,7 +227,7 @@ func TestRoomManager_ConcurrentAccess(t *testing.T) {
 	done := make(chan bool)
 	for _, conn := range connections {
 		go func(c *MockWebSocketConn) {
-			rm.JoinRoom(noteID, c)
+			rm.JoinRoom(noteID, c, Participant{UserID: "user"})
 			done <- true
 		}(conn)
 	}
@@ -118,7 +237,7 @@ func TestRoomManager_ConcurrentAccess(t *testing.T) {
 		<-done
 	}
 
-	assert.Equal(t, len(connections), len(rm.rooms[noteID]))
+	assert.Equal(t, len(connections), len(members(rm, noteID)))
 
 	// Test concurrent leaves
 	for _, conn := range connections {
@@ -133,5 +252,176 @@ func TestRoomManager_ConcurrentAccess(t *testing.T) {
 	}
 
 	// Verify room is empty
-	assert.NotContains(t, rm.rooms, noteID)
+	assert.Nil(t, members(rm, noteID))
+}
+
+func TestRoomManager_Participants(t *testing.T) {
+	rm := NewRoomManager()
+	noteID := "test-note"
+
+	assert.Empty(t, rm.Participants(noteID))
+
+	// The same user in two tabs is listed once
+	rm.JoinRoom(noteID, new(MockWebSocketConn), Participant{UserID: "user2", DisplayName: "bob"})
+	rm.JoinRoom(noteID, new(MockWebSocketConn), Participant{UserID: "user1", DisplayName: "alice"})
+	rm.JoinRoom(noteID, new(MockWebSocketConn), Participant{UserID: "user1", DisplayName: "alice"})
+
+	assert.Equal(t, []Participant{
+		{UserID: "user1", DisplayName: "alice"},
+		{UserID: "user2", DisplayName: "bob"},
+	}, rm.Participants(noteID))
+}
+
+func TestHandler_GetPresence(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	handler := NewHandler(db, Options{QueryTimeout: time.Second})
+	handler.manager.JoinRoom("note1", new(MockWebSocketConn), Participant{UserID: "user1", DisplayName: "alice"})
+
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user1")
+		return c.Next()
+	})
+	app.Get("/notes/:id/presence", handler.GetPresence)
+
+	accessQuery := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)))")
+
+	testCases := []struct {
+		name           string
+		noteID         string
+		allowed        bool
+		mockError      error
+		expectedStatus int
+		expectedUsers  []Participant
+	}{
+		{
+			name:           "Success",
+			noteID:         "note1",
+			allowed:        true,
+			expectedStatus: fiber.StatusOK,
+			expectedUsers:  []Participant{{UserID: "user1", DisplayName: "alice"}},
+		},
+		{
+			name:           "No Access",
+			noteID:         "note2",
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Database Error",
+			noteID:         "note1",
+			mockError:      errors.New("database error"),
+			expectedStatus: fiber.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expectation := mockDB.ExpectQuery(accessQuery).WithArgs(tc.noteID, "user1", "user1")
+			if tc.mockError != nil {
+				expectation.WillReturnError(tc.mockError)
+			} else {
+				expectation.WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(tc.allowed))
+			}
+
+			resp, err := app.Test(httptest.NewRequest("GET", "/notes/"+tc.noteID+"/presence", nil))
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var response struct {
+					NoteID string        `json:"note_id"`
+					Users  []Participant `json:"users"`
+				}
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.noteID, response.NoteID)
+				assert.Equal(t, tc.expectedUsers, response.Users)
+			}
+		})
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRoomManager_DisconnectUser(t *testing.T) {
+	rm := NewRoomManager()
+	target1 := new(MockWebSocketConn)
+	target2 := new(MockWebSocketConn)
+	other := new(MockWebSocketConn)
+	closed := make(chan struct{}, 2)
+	for _, conn := range []*MockWebSocketConn{target1, target2} {
+		conn.On("WriteMessage", mock.Anything, mock.Anything).Return(nil)
+		conn.On("Close").Return(nil).Run(func(mock.Arguments) { closed <- struct{}{} })
+	}
+
+	rm.JoinRoom("note1", target1, Participant{UserID: "user1"})
+	rm.JoinRoom("note2", target2, Participant{UserID: "user1"})
+	rm.JoinRoom("note1", other, Participant{UserID: "user2"})
+
+	rm.DisconnectUser("user1")
+
+	// Each connection gets an error frame, then a 4001 close, before closing
+	for range 2 {
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("connection was not closed")
+		}
+	}
+	for _, conn := range []*MockWebSocketConn{target1, target2} {
+		conn.AssertCalled(t, "WriteMessage", 1, mock.MatchedBy(func(data []byte) bool {
+			return strings.Contains(string(data), `"code":"unauthorized"`)
+		}))
+		conn.AssertCalled(t, "WriteMessage", websocket.CloseMessage, websocket.FormatCloseMessage(CloseUnauthorized, "Signed out"))
+	}
+	other.AssertNotCalled(t, "Close")
+	assert.False(t, inRoom(rm, "note1", target1))
+	assert.False(t, inRoom(rm, "note2", target2))
+	assert.True(t, inRoom(rm, "note1", other))
+}
+
+func TestHandler_RevokeAccess(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	handler := NewHandler(db, Options{QueryTimeout: time.Second})
+	revoked := new(MockWebSocketConn)
+	kept := new(MockWebSocketConn)
+	closed := make(chan struct{}, 1)
+	revoked.On("WriteMessage", mock.Anything, mock.Anything).Return(nil)
+	revoked.On("Close").Return(nil).Run(func(mock.Arguments) { closed <- struct{}{} })
+	handler.manager.JoinRoom("note1", revoked, Participant{UserID: "user1"})
+	handler.manager.JoinRoom("note2", kept, Participant{UserID: "user1"})
+
+	accessQuery := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)))")
+	mockDB.MatchExpectationsInOrder(false)
+	mockDB.ExpectQuery(accessQuery).WithArgs("note1", "user1", "user1").
+		WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(false))
+	mockDB.ExpectQuery(accessQuery).WithArgs("note2", "user1", "user1").
+		WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(true))
+
+	handler.RevokeAccess(context.Background(), "user1")
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed")
+	}
+	revoked.AssertCalled(t, "WriteMessage", websocket.CloseMessage, websocket.FormatCloseMessage(CloseUnauthorized, "Access revoked"))
+	kept.AssertNotCalled(t, "Close")
+	assert.False(t, inRoom(handler.manager, "note1", revoked))
+	assert.True(t, inRoom(handler.manager, "note2", kept))
+	assert.NoError(t, mockDB.ExpectationsWereMet())
 }
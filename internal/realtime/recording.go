@@ -0,0 +1,89 @@
+package realtime
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Recorder persists the edits made in rooms on notes whose workspace
+// records collaboration sessions. Record and End are called with room
+// locks held, so they must not block.
+type Recorder interface {
+	// Start begins recording a room that just opened, returning the
+	// session's ID, or "" when the note's workspace doesn't record
+	Start(ctx context.Context, noteID string) (string, error)
+	// Record adds an edit, the EditMessage broadcast for it, to a session
+	Record(sessionID string, at time.Time, userID string, revision int64, edit []byte)
+	// End marks a session finished when its room closes
+	End(sessionID string, at time.Time)
+}
+
+// startRecording asks the recorder whether a room should be recorded the
+// first time a connection joins it. Turning recording on or off takes
+// effect for the rooms opened afterwards.
+func (h *Handler) startRecording(ctx context.Context, noteID string) {
+	rm := h.manager
+	if rm.recorder == nil || !rm.claimRecording(noteID) {
+		return
+	}
+	sessionID, err := rm.recorder.Start(ctx, noteID)
+	if err != nil {
+		log.Printf("Error starting session recording for note %s: %v", noteID, err)
+	}
+	if sessionID == "" {
+		return
+	}
+
+	s := rm.shard(noteID)
+	s.mu.Lock()
+	_, open := s.rooms[noteID]
+	if open {
+		s.recordings[noteID] = sessionID
+	}
+	s.mu.Unlock()
+	// Everyone left while the session was being created
+	if !open {
+		rm.recorder.End(sessionID, time.Now())
+	}
+}
+
+// claimRecording reports whether the caller is the first to check if a
+// room is recorded. The room holds "" until the recorder answers.
+func (rm *RoomManager) claimRecording(noteID string) bool {
+	s := rm.shard(noteID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.rooms[noteID]; !exists {
+		return false
+	}
+	if _, claimed := s.recordings[noteID]; claimed {
+		return false
+	}
+	s.recordings[noteID] = ""
+	return true
+}
+
+// record hands an edit to the recorder if its room is being recorded
+func (rm *RoomManager) record(noteID, userID string, revision int64, edit []byte) {
+	if rm.recorder == nil {
+		return
+	}
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	sessionID := s.recordings[noteID]
+	s.mu.RUnlock()
+	if sessionID != "" {
+		rm.recorder.Record(sessionID, time.Now(), userID, revision, edit)
+	}
+}
+
+// endRecording finishes the recording of a room that was removed. s.mu
+// must be held.
+func (rm *RoomManager) endRecording(s *roomShard, noteID string) {
+	sessionID := s.recordings[noteID]
+	delete(s.recordings, noteID)
+	if sessionID != "" {
+		rm.recorder.End(sessionID, time.Now())
+	}
+}
@@ -0,0 +1,70 @@
+package realtime
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// fakeRecorder records a session for every room and keeps what it's given
+type fakeRecorder struct {
+	mu      sync.Mutex
+	started []string
+	edits   []int64
+	ended   []string
+}
+
+func (f *fakeRecorder) Start(_ context.Context, noteID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started = append(f.started, noteID)
+	return "session-" + noteID, nil
+}
+
+func (f *fakeRecorder) Record(_ string, _ time.Time, _ string, revision int64, _ []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edits = append(f.edits, revision)
+}
+
+func (f *fakeRecorder) End(sessionID string, _ time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ended = append(f.ended, sessionID)
+}
+
+func TestHandler_RecordsSessions(t *testing.T) {
+	recorder := &fakeRecorder{}
+	h := NewHandler(nil, Options{Recorder: recorder})
+	ctx := context.Background()
+	first, second := new(MockWebSocketConn), new(MockWebSocketConn)
+	for _, conn := range []*MockWebSocketConn{first, second} {
+		conn.On("WriteMessage", mock.Anything, mock.Anything).Return(nil).Maybe()
+	}
+
+	// The room is recorded once, however many connections join it
+	h.manager.JoinRoom("note1", first, Participant{UserID: "user1"})
+	h.startRecording(ctx, "note1")
+	h.manager.JoinRoom("note1", second, Participant{UserID: "user2"})
+	h.startRecording(ctx, "note1")
+	assert.Equal(t, []string{"note1"}, recorder.started)
+
+	h.manager.BroadcastEdit("note1", first, websocket.TextMessage, EditMessage{Type: MessageTypeEdit, Content: "a", UserID: "user1"})
+	h.manager.BroadcastEdit("note1", second, websocket.TextMessage, EditMessage{Type: MessageTypeEdit, Content: "ab", UserID: "user2"})
+	assert.Equal(t, []int64{1, 2}, recorder.edits)
+
+	h.manager.LeaveRoom("note1", first)
+	assert.Empty(t, recorder.ended)
+	h.manager.LeaveRoom("note1", second)
+	assert.Equal(t, []string{"session-note1"}, recorder.ended)
+
+	// A reopened room is a new session
+	h.manager.JoinRoom("note1", first, Participant{UserID: "user1"})
+	h.startRecording(ctx, "note1")
+	assert.Len(t, recorder.started, 2)
+}
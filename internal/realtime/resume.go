@@ -0,0 +1,147 @@
+package realtime
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// DefaultResumeGrace is how long a dropped connection's session can be
+// resumed unless configured
+const DefaultResumeGrace = 15 * time.Second
+
+// MessageTypeSession gives a joining client the token to resume with
+const MessageTypeSession MessageType = "session"
+
+// SessionMessage is sent to a client on join. Reconnecting within
+// ResumeGrace seconds with ?resume=<ResumeToken> continues the session
+// without the room seeing the user leave and rejoin. Resumed says whether
+// this connection continued an earlier one. Each connection gets a new
+// token.
+type SessionMessage struct {
+	Type        MessageType `json:"type"`
+	V           int         `json:"v"`
+	ResumeToken string      `json:"resume_token"`
+	Resumed     bool        `json:"resumed"`
+	ResumeGrace int         `json:"resume_grace"`
+}
+
+// session is a connection's resumable state. timer is set once the
+// connection has dropped and runs the leave when the grace period ends.
+type session struct {
+	noteID string
+	userID string
+	timer  *time.Timer
+}
+
+// resumeStore tracks the sessions that can be resumed: those still
+// connected and those dropped within the grace period
+type resumeStore struct {
+	grace time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	// held counts each room's dropped sessions awaiting a resume
+	held map[string]int
+}
+
+func newResumeStore() *resumeStore {
+	return &resumeStore{
+		grace:    DefaultResumeGrace,
+		sessions: make(map[string]*session),
+		held:     make(map[string]int),
+	}
+}
+
+// issue returns a new token for a connection to a room
+func (r *resumeStore) issue(noteID, userID string) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	r.mu.Lock()
+	r.sessions[token] = &session{noteID: noteID, userID: userID}
+	r.mu.Unlock()
+	return token, nil
+}
+
+// take claims a session for a reconnecting client, reporting whether the
+// token belongs to the same user and room and hasn't expired. A claimed
+// session's pending leave is cancelled; if its connection hasn't dropped
+// yet, the leave it would have made when it did is skipped too.
+func (r *resumeStore) take(token, noteID, userID string) bool {
+	if token == "" {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[token]
+	if !ok || s.noteID != noteID || s.userID != userID {
+		return false
+	}
+	delete(r.sessions, token)
+	if s.timer != nil {
+		s.timer.Stop()
+		r.release(noteID)
+	}
+	return true
+}
+
+// hold keeps a dropped connection's session for the grace period, then
+// calls leave unless it was resumed. It reports false, without calling
+// leave, if the session was already resumed by another connection.
+func (r *resumeStore) hold(token string, leave func()) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[token]
+	if !ok {
+		return false
+	}
+	r.held[s.noteID]++
+	s.timer = time.AfterFunc(r.grace, func() {
+		r.mu.Lock()
+		if r.sessions[token] != s {
+			r.mu.Unlock()
+			return
+		}
+		delete(r.sessions, token)
+		r.release(s.noteID)
+		r.mu.Unlock()
+		leave()
+	})
+	return true
+}
+
+// release uncounts one of a room's held sessions. r.mu must be held.
+func (r *resumeStore) release(noteID string) {
+	if r.held[noteID] <= 1 {
+		delete(r.held, noteID)
+		return
+	}
+	r.held[noteID]--
+}
+
+// holding reports whether any of a room's dropped sessions may still be
+// resumed
+func (r *resumeStore) holding(noteID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.held[noteID] > 0
+}
+
+// forgetIfEmpty drops a room's history once nobody is connected to it or
+// may still resume
+func (rm *RoomManager) forgetIfEmpty(noteID string) {
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	_, open := s.rooms[noteID]
+	s.mu.RUnlock()
+	if !open && !rm.resumes.holding(noteID) {
+		s.dropHistory(noteID)
+	}
+}
@@ -0,0 +1,76 @@
+package realtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeStore(t *testing.T) {
+	r := newResumeStore()
+	r.grace = 50 * time.Millisecond
+
+	token, err := r.issue("note-1", "user1")
+	require.NoError(t, err)
+	other, err := r.issue("note-1", "user1")
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+
+	// Tokens only resume the same user's session in the same room
+	assert.False(t, r.take(token, "note-2", "user1"))
+	assert.False(t, r.take(token, "note-1", "user2"))
+	assert.False(t, r.take("", "note-1", "user1"))
+
+	// A resume within the grace period cancels the leave
+	left := make(chan string, 2)
+	require.True(t, r.hold(token, func() { left <- token }))
+	assert.True(t, r.holding("note-1"))
+	assert.True(t, r.take(token, "note-1", "user1"))
+	assert.False(t, r.holding("note-1"))
+	assert.False(t, r.take(token, "note-1", "user1"), "tokens are single use")
+
+	// Without one the leave happens once the grace period ends
+	require.True(t, r.hold(other, func() { left <- other }))
+	select {
+	case got := <-left:
+		assert.Equal(t, other, got)
+	case <-time.After(time.Second):
+		t.Fatal("leave was not called")
+	}
+	assert.False(t, r.holding("note-1"))
+	assert.False(t, r.take(other, "note-1", "user1"))
+
+	// A session resumed before its connection noticed the drop isn't held
+	third, err := r.issue("note-1", "user1")
+	require.NoError(t, err)
+	require.True(t, r.take(third, "note-1", "user1"))
+	assert.False(t, r.hold(third, func() { left <- third }))
+	assert.Empty(t, left)
+}
+
+func TestRoomManager_HistoryKeptWhileResumable(t *testing.T) {
+	rm := NewRoomManager()
+	rm.resumes.grace = time.Hour
+	conn := new(MockWebSocketConn)
+	conn.On("WriteMessage", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	rm.JoinRoom("note-1", conn, Participant{UserID: "user1"})
+	rm.BroadcastEdit("note-1", conn, 1, EditMessage{Type: MessageTypeEdit, Content: "hello"})
+	token, err := rm.resumes.issue("note-1", "user1")
+	require.NoError(t, err)
+
+	// The last client dropping doesn't lose the edits it may resume from
+	require.True(t, rm.resumes.hold(token, func() {}))
+	assert.True(t, rm.LeaveRoom("note-1", conn))
+	history := rm.History("note-1", 0)
+	assert.Equal(t, int64(1), history.Revision)
+	assert.Len(t, history.Ops, 1)
+
+	// Once it can no longer resume they go
+	require.True(t, rm.resumes.take(token, "note-1", "user1"))
+	rm.forgetIfEmpty("note-1")
+	assert.Equal(t, int64(0), rm.History("note-1", 0).Revision)
+}
@@ -0,0 +1,70 @@
+package realtime
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"quanta/internal/models"
+)
+
+// MessageTypeRole announces that a collaborator's role on a note changed
+const MessageTypeRole MessageType = "role"
+
+// RoleMessage carries a collaborator's new role. Clients of a user who can
+// no longer edit should switch to read-only.
+type RoleMessage struct {
+	Type   MessageType `json:"type"`
+	V      int         `json:"v"`
+	UserID string      `json:"user-id"`
+	Role   string      `json:"role"`
+}
+
+// SetRole changes the role of a user's connections to a room
+func (rm *RoomManager) SetRole(noteID, userID, role string) {
+	s := rm.shard(noteID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range s.rooms[noteID] {
+		if m.participant.UserID == userID {
+			m.participant.Role = role
+		}
+	}
+}
+
+// canEdit reports whether a connection's role lets it edit the note
+func (rm *RoomManager) canEdit(noteID string, conn WebSocketConn) bool {
+	s := rm.shard(noteID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.rooms[noteID][conn]
+	return ok && models.CanEditNote(m.participant.Role)
+}
+
+// SetRole records a collaborator's new role on a note and announces it to
+// the note's room
+func (h *Handler) SetRole(ctx context.Context, noteID, userID, role string) {
+	h.manager.SetRole(noteID, userID, role)
+	h.Publish(ctx, noteID, RoleMessage{Type: MessageTypeRole, V: ProtocolVersion, UserID: userID, Role: role})
+}
+
+// noteRole resolves the role of a user who can access a note. A note
+// deleted meanwhile gives no role, which cannot edit.
+func (h *Handler) noteRole(ctx context.Context, noteID, userID string) (string, error) {
+	var author string
+	var role sql.NullString
+	err := h.db.QueryRowContext(ctx,
+		"SELECT n.user_id, c.role FROM notes n LEFT JOIN note_collaborators c ON c.note_id = n.id AND c.user_id = ? WHERE n.id = ?",
+		userID, noteID,
+	).Scan(&author, &role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return models.ResolveNoteRole(author, userID, role.String), nil
+}
@@ -0,0 +1,77 @@
+package realtime
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRoomManager_SetRole(t *testing.T) {
+	rm := NewRoomManager()
+	viewer := new(MockWebSocketConn)
+	viewer.On("WriteMessage", mock.Anything, mock.Anything).Return(nil)
+	editor := new(MockWebSocketConn)
+	editor.On("WriteMessage", mock.Anything, mock.Anything).Return(nil)
+	rm.JoinRoom("note1", viewer, Participant{UserID: "user1", Role: models.NoteRoleViewer})
+	rm.JoinRoom("note1", editor, Participant{UserID: "user2", Role: models.NoteRoleEditor})
+
+	assert.False(t, rm.canEdit("note1", viewer))
+	assert.True(t, rm.canEdit("note1", editor))
+
+	rm.SetRole("note1", "user1", models.NoteRoleEditor)
+	rm.SetRole("note1", "user2", models.NoteRoleCommenter)
+	assert.True(t, rm.canEdit("note1", viewer))
+	assert.False(t, rm.canEdit("note1", editor))
+
+	participants := rm.Participants("note1")
+	if assert.Len(t, participants, 2) {
+		assert.Equal(t, models.NoteRoleEditor, participants[0].Role)
+		assert.Equal(t, models.NoteRoleCommenter, participants[1].Role)
+	}
+
+	rm.LeaveRoom("note1", viewer)
+	assert.False(t, rm.canEdit("note1", viewer), "a connection that left can't edit")
+}
+
+func TestNoteRole(t *testing.T) {
+	query := regexp.QuoteMeta("SELECT n.user_id, c.role FROM notes n LEFT JOIN note_collaborators c ON c.note_id = n.id AND c.user_id = ? WHERE n.id = ?")
+
+	testCases := []struct {
+		name     string
+		author   string
+		granted  any
+		expected string
+	}{
+		{name: "Author", author: "user1", expected: models.NoteRoleOwner},
+		{name: "Granted Role", author: "user2", granted: models.NoteRoleViewer, expected: models.NoteRoleViewer},
+		{name: "Workspace Member", author: "user2", expected: models.NoteRoleEditor},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mockDB, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error opening stub database: %v", err)
+			}
+			defer db.Close()
+
+			mockDB.ExpectQuery(query).WithArgs("user1", "note1").
+				WillReturnRows(sqlmock.NewRows([]string{"user_id", "role"}).AddRow(tc.author, tc.granted))
+
+			handler := NewHandler(db, Options{QueryTimeout: time.Second})
+			role, err := handler.noteRole(t.Context(), "note1", "user1")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, role)
+
+			if err := mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
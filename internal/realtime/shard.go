@@ -0,0 +1,60 @@
+package realtime
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// roomShards is how many independently locked shards rooms are spread
+// across. Joins, leaves and broadcasts only contend with rooms in the same
+// shard, so a busy note can't hold up the rest of the server.
+const roomShards = 64
+
+// roomShard holds the state of the rooms whose note IDs hash to it. Where
+// both are needed, historyMu is taken before mu.
+type roomShard struct {
+	mu    sync.RWMutex
+	rooms map[string]map[WebSocketConn]*member
+	// opened is when each room was created
+	opened map[string]time.Time
+	// recordings holds the recorded session of each room being recorded,
+	// "" while the recorder is asked or when the room isn't recorded
+	recordings map[string]string
+	// deleted holds the timer closing each room whose note was deleted
+	deleted map[string]*time.Timer
+
+	historyMu sync.Mutex
+	history   map[string]*roomHistory
+
+	typingMu sync.Mutex
+	typing   map[string]map[string]*typingState
+
+	locksMu sync.Mutex
+	locks   map[string]Lock
+
+	batchMu sync.Mutex
+	batches map[string]*pendingBatch
+
+	statsMu       sync.Mutex
+	slowConsumers map[string]int
+}
+
+func newRoomShard() *roomShard {
+	return &roomShard{
+		rooms:         make(map[string]map[WebSocketConn]*member),
+		opened:        make(map[string]time.Time),
+		recordings:    make(map[string]string),
+		deleted:       make(map[string]*time.Timer),
+		history:       make(map[string]*roomHistory),
+		typing:        make(map[string]map[string]*typingState),
+		locks:         make(map[string]Lock),
+		batches:       make(map[string]*pendingBatch),
+		slowConsumers: make(map[string]int),
+	}
+}
+
+// shard returns the shard a room lives in
+func (rm *RoomManager) shard(noteID string) *roomShard {
+	return rm.shards[maphash.String(rm.seed, noteID)%uint64(len(rm.shards))]
+}
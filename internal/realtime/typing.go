@@ -0,0 +1,126 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// TypingTimeout is how long a typing indicator stays active without a refresh
+const TypingTimeout = 5 * time.Second
+
+const (
+	// MessageTypeTypingStart is broadcast when a user starts typing
+	MessageTypeTypingStart MessageType = "typing:start"
+	// MessageTypeTypingStop is broadcast when a user stops typing or goes silent
+	MessageTypeTypingStop MessageType = "typing:stop"
+)
+
+// TypingMessage is a consolidated typing indicator update
+type TypingMessage struct {
+	Type        MessageType `json:"type"`
+	V           int         `json:"v"`
+	UserID      string      `json:"user-id"`
+	DisplayName string      `json:"display_name"`
+}
+
+// typingState tracks an active typing indicator and its expiry timer
+type typingState struct {
+	participant Participant
+	timer       *time.Timer
+}
+
+// SetTyping marks a user as typing in a room, pushing back the expiry of an
+// existing indicator. A typing:start event is broadcast only when the user
+// wasn't already typing; typing:stop is broadcast automatically on expiry.
+func (rm *RoomManager) SetTyping(noteID string, participant Participant) {
+	s := rm.shard(noteID)
+	s.typingMu.Lock()
+	users, exists := s.typing[noteID]
+	if !exists {
+		users = make(map[string]*typingState)
+		s.typing[noteID] = users
+	}
+
+	previous, wasTyping := users[participant.UserID]
+	if wasTyping {
+		previous.timer.Stop()
+	}
+
+	state := &typingState{participant: participant}
+	state.timer = time.AfterFunc(rm.typingTTL, func() {
+		rm.expireTyping(noteID, participant.UserID, state)
+	})
+	users[participant.UserID] = state
+	s.typingMu.Unlock()
+
+	if !wasTyping {
+		rm.broadcastTyping(noteID, MessageTypeTypingStart, participant)
+	}
+}
+
+// StopTyping clears a user's typing indicator and broadcasts typing:stop if one was active
+func (rm *RoomManager) StopTyping(noteID, userID string) {
+	s := rm.shard(noteID)
+	s.typingMu.Lock()
+	state, exists := s.typing[noteID][userID]
+	if exists {
+		state.timer.Stop()
+		s.removeTyping(noteID, userID)
+	}
+	s.typingMu.Unlock()
+
+	if exists {
+		rm.broadcastTyping(noteID, MessageTypeTypingStop, state.participant)
+	}
+}
+
+// IsTyping reports whether a user currently has an active typing indicator
+func (rm *RoomManager) IsTyping(noteID, userID string) bool {
+	s := rm.shard(noteID)
+	s.typingMu.Lock()
+	defer s.typingMu.Unlock()
+
+	_, exists := s.typing[noteID][userID]
+	return exists
+}
+
+// expireTyping is called by a typing timer once its TTL passes. It ignores
+// timers that were superseded by a later SetTyping call.
+func (rm *RoomManager) expireTyping(noteID, userID string, state *typingState) {
+	s := rm.shard(noteID)
+	s.typingMu.Lock()
+	current, exists := s.typing[noteID][userID]
+	if !exists || current != state {
+		s.typingMu.Unlock()
+		return
+	}
+	s.removeTyping(noteID, userID)
+	s.typingMu.Unlock()
+
+	rm.broadcastTyping(noteID, MessageTypeTypingStop, state.participant)
+}
+
+// removeTyping deletes a typing entry. The caller must hold s.typingMu.
+func (s *roomShard) removeTyping(noteID, userID string) {
+	delete(s.typing[noteID], userID)
+	if len(s.typing[noteID]) == 0 {
+		delete(s.typing, noteID)
+	}
+}
+
+// broadcastTyping sends a typing event to everyone in the room with the
+// room's next batch
+func (rm *RoomManager) broadcastTyping(noteID string, messageType MessageType, participant Participant) {
+	payload, err := json.Marshal(TypingMessage{
+		Type:        messageType,
+		V:           ProtocolVersion,
+		UserID:      participant.UserID,
+		DisplayName: participant.DisplayName,
+	})
+	if err != nil {
+		log.Printf("Error marshalling typing message: %v", err)
+		return
+	}
+	rm.coalesce(noteID, nil, payload, false)
+}
@@ -0,0 +1,73 @@
+package realtime
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// typingPayload builds the JSON a typing event is expected to serialize to
+func typingPayload(t *testing.T, messageType MessageType, p Participant) []byte {
+	payload, err := json.Marshal(TypingMessage{Type: messageType, V: ProtocolVersion, UserID: p.UserID, DisplayName: p.DisplayName})
+	if err != nil {
+		t.Fatalf("error marshaling typing message: %v", err)
+	}
+	return payload
+}
+
+func TestRoomManager_SetTyping(t *testing.T) {
+	rm := NewRoomManager()
+	rm.coalesceInterval = 5 * time.Millisecond
+	noteID := "test-note"
+	alice := Participant{UserID: "user1", DisplayName: "alice"}
+
+	written := make(chan []byte, 4)
+	conn := new(MockWebSocketConn)
+	conn.On("WriteMessage", 1, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		written <- args.Get(1).([]byte)
+	})
+	rm.JoinRoom(noteID, conn, Participant{UserID: "user2"})
+
+	// Repeated typing messages only produce a single start event
+	rm.SetTyping(noteID, alice)
+	rm.SetTyping(noteID, alice)
+	assert.True(t, rm.IsTyping(noteID, "user1"))
+
+	rm.StopTyping(noteID, "user1")
+	assert.False(t, rm.IsTyping(noteID, "user1"))
+
+	// Stopping a user who isn't typing is a no-op
+	rm.StopTyping(noteID, "user1")
+
+	assert.Equal(t, [][]byte{
+		typingPayload(t, MessageTypeTypingStart, alice),
+		typingPayload(t, MessageTypeTypingStop, alice),
+	}, receive(t, written, 2))
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, written)
+}
+
+func TestRoomManager_TypingExpiry(t *testing.T) {
+	rm := NewRoomManager()
+	rm.typingTTL = 20 * time.Millisecond
+	rm.coalesceInterval = 5 * time.Millisecond
+	noteID := "test-note"
+	alice := Participant{UserID: "user1", DisplayName: "alice"}
+
+	written := make(chan []byte, 2)
+	conn := new(MockWebSocketConn)
+	conn.On("WriteMessage", 1, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		written <- args.Get(1).([]byte)
+	})
+	rm.JoinRoom(noteID, conn, Participant{UserID: "user2"})
+
+	rm.SetTyping(noteID, alice)
+	assert.Equal(t, [][]byte{
+		typingPayload(t, MessageTypeTypingStart, alice),
+		typingPayload(t, MessageTypeTypingStop, alice),
+	}, receive(t, written, 2))
+	assert.False(t, rm.IsTyping(noteID, "user1"))
+}
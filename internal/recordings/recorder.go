@@ -0,0 +1,164 @@
+package recordings
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultQueueSize is how many edits wait to be written before more
+	// are dropped
+	DefaultQueueSize = 4096
+	// writeTimeout bounds each write of a recorded edit
+	writeTimeout = 5 * time.Second
+)
+
+// Recorder writes the edits of recorded rooms to the database. The realtime
+// handler calls it with room locks held, so edits are queued and written by
+// Run; when the queue is full they are dropped rather than stalling the
+// room.
+type Recorder struct {
+	db    DBInterface
+	queue chan recorded
+
+	mu sync.Mutex
+	// sessions are the open recordings, by ID
+	sessions map[string]*recording
+	dropped  atomic.Int64
+}
+
+// recording is an open session. seq is only touched by Run.
+type recording struct {
+	started time.Time
+	seq     int
+}
+
+// recorded is a queued edit, or the end of a session
+type recorded struct {
+	sessionID string
+	at        time.Time
+	userID    string
+	revision  int64
+	edit      []byte
+	end       bool
+}
+
+// NewRecorder creates a Recorder; call Run to start writing
+func NewRecorder(db DBInterface) *Recorder {
+	return &Recorder{
+		db:       db,
+		queue:    make(chan recorded, DefaultQueueSize),
+		sessions: make(map[string]*recording),
+	}
+}
+
+// Start opens a recorded session for a note's room if the note's workspace
+// records sessions, returning its ID, or "" when it doesn't
+func (r *Recorder) Start(ctx context.Context, noteID string) (string, error) {
+	var enabled bool
+	err := r.db.QueryRowContext(ctx,
+		"SELECT r.enabled FROM notes n JOIN workspace_recording r ON r.workspace_id = n.workspace_id WHERE n.id = ?",
+		noteID,
+	).Scan(&enabled)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !enabled) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("checking session recording: %w", err)
+	}
+
+	id := uuid.New().String()
+	started := time.Now().UTC()
+	if _, err := r.db.ExecContext(ctx,
+		"INSERT INTO note_sessions (id, note_id, started_at) VALUES (?, ?, ?)",
+		id, noteID, started,
+	); err != nil {
+		return "", fmt.Errorf("creating recorded session: %w", err)
+	}
+
+	r.mu.Lock()
+	r.sessions[id] = &recording{started: started}
+	r.mu.Unlock()
+	return id, nil
+}
+
+// Record queues an edit of a session
+func (r *Recorder) Record(sessionID string, at time.Time, userID string, revision int64, edit []byte) {
+	r.enqueue(recorded{sessionID: sessionID, at: at, userID: userID, revision: revision, edit: edit})
+}
+
+// End queues the end of a session
+func (r *Recorder) End(sessionID string, at time.Time) {
+	r.enqueue(recorded{sessionID: sessionID, at: at, end: true})
+}
+
+func (r *Recorder) enqueue(rec recorded) {
+	select {
+	case r.queue <- rec:
+	default:
+		if n := r.dropped.Add(1); n == 1 || n%1000 == 0 {
+			log.Printf("Session recording queue is full; %d recorded edits dropped so far", n)
+		}
+	}
+}
+
+// Run writes queued edits until stop is closed, then writes what is
+// still queued
+func (r *Recorder) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case rec := <-r.queue:
+			r.write(rec)
+		case <-stop:
+			for {
+				select {
+				case rec := <-r.queue:
+					r.write(rec)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write stores a queued edit, or marks its session ended
+func (r *Recorder) write(rec recorded) {
+	r.mu.Lock()
+	session := r.sessions[rec.sessionID]
+	if rec.end {
+		delete(r.sessions, rec.sessionID)
+	}
+	r.mu.Unlock()
+	if session == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	if rec.end {
+		if _, err := r.db.ExecContext(ctx, "UPDATE note_sessions SET ended_at = ? WHERE id = ?", rec.at.UTC(), rec.sessionID); err != nil {
+			log.Printf("Error ending recorded session %s: %v", rec.sessionID, err)
+		}
+		return
+	}
+
+	session.seq++
+	_, err := r.db.ExecContext(ctx,
+		"INSERT INTO note_session_ops (session_id, seq, offset_ms, user_id, revision, edit) VALUES (?, ?, ?, ?, ?, ?)",
+		rec.sessionID, session.seq, rec.at.Sub(session.started).Milliseconds(),
+		sql.NullString{String: rec.userID, Valid: rec.userID != ""}, rec.revision, string(rec.edit),
+	)
+	if err != nil {
+		log.Printf("Error recording edit of session %s: %v", rec.sessionID, err)
+	}
+}
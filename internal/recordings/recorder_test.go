@@ -0,0 +1,60 @@
+package recordings
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	r := NewRecorder(db)
+	ctx := context.Background()
+	enabledQuery := regexp.QuoteMeta("SELECT r.enabled FROM notes n JOIN workspace_recording r ON r.workspace_id = n.workspace_id WHERE n.id = ?")
+
+	// Notes outside recording workspaces aren't recorded
+	mockDB.ExpectQuery(enabledQuery).WithArgs("private").WillReturnError(sql.ErrNoRows)
+	id, err := r.Start(ctx, "private")
+	require.NoError(t, err)
+	assert.Empty(t, id)
+
+	mockDB.ExpectQuery(enabledQuery).WithArgs("note1").
+		WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(true))
+	mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO note_sessions (id, note_id, started_at) VALUES (?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "note1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	id, err = r.Start(ctx, "note1")
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+	started := r.sessions[id].started
+
+	insert := regexp.QuoteMeta("INSERT INTO note_session_ops (session_id, seq, offset_ms, user_id, revision, edit) VALUES (?, ?, ?, ?, ?, ?)")
+	mockDB.ExpectExec(insert).
+		WithArgs(id, 1, int64(250), sql.NullString{String: "user1", Valid: true}, int64(4), `{"type":"edit"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(insert).
+		WithArgs(id, 2, int64(1000), sql.NullString{}, int64(5), `{"type":"edit","ops":[]}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE note_sessions SET ended_at = ? WHERE id = ?")).
+		WithArgs(sqlmock.AnyArg(), id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	r.Record(id, started.Add(250*time.Millisecond), "user1", 4, []byte(`{"type":"edit"}`))
+	r.Record(id, started.Add(time.Second), "", 5, []byte(`{"type":"edit","ops":[]}`))
+	r.End(id, started.Add(2*time.Second))
+	// Edits of sessions that already ended are ignored
+	r.Record(id, started.Add(3*time.Second), "user1", 6, []byte(`{}`))
+
+	stop := make(chan struct{})
+	close(stop)
+	r.Run(stop)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+	assert.Empty(t, r.sessions)
+}
@@ -0,0 +1,283 @@
+// Package recordings records collaboration sessions in the workspaces that
+// turn recording on: every edit made over a note's realtime socket, with
+// when it was made, so the session can be played back or reviewed later.
+package recordings
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"quanta/internal/access"
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/handlers/workspaces"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// SessionsLimit is the most sessions a listing returns
+	SessionsLimit = 100
+	// replayTimeout bounds streaming a session back
+	replayTimeout = 5 * time.Minute
+)
+
+// Settings is whether a workspace records collaboration sessions
+type Settings struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Session is a recorded collaboration session on a note. EndedAt is nil
+// while its room is open, or if the server stopped before it closed.
+type Session struct {
+	ID        string     `json:"id"`
+	NoteID    string     `json:"note_id"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Edits     int        `json:"edits"`
+}
+
+// ReplayedEdit is one line of a replay: an edit of the session and when it
+// was made, in milliseconds since the session started
+type ReplayedEdit struct {
+	Seq      int             `json:"seq"`
+	OffsetMS int64           `json:"offset_ms"`
+	UserID   *string         `json:"user_id"`
+	Revision int64           `json:"revision"`
+	Edit     json.RawMessage `json:"edit"`
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Handler handles HTTP requests related to session recording
+type Handler struct {
+	db    DBInterface
+	audit AuditLogger
+}
+
+// NewHandler creates a new Handler with the provided database interface
+// and the audit log that records settings changes
+func NewHandler(db DBInterface, auditLog AuditLogger) *Handler {
+	return &Handler{db: db, audit: auditLog}
+}
+
+// GetSettings returns whether a workspace records sessions to any of its
+// members
+func (h *Handler) GetSettings(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	if _, err := workspaces.RequireRole(c, h.db, workspaceID); err != nil {
+		return err
+	}
+
+	settings, err := h.settings(c.UserContext(), workspaceID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(settings)
+}
+
+// UpdateSettings turns session recording on or off for a workspace. Only
+// its owner and admins may change it. Rooms already open keep going as
+// they started.
+func (h *Handler) UpdateSettings(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	var payload struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	if payload.Enabled == nil {
+		return apperr.Invalid(map[string]string{"enabled": "must be true or false"})
+	}
+	if _, err := workspaces.RequireRole(c, h.db, workspaceID, workspaces.RoleOwner, workspaces.RoleAdmin); err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	res, err := h.db.ExecContext(ctx,
+		"UPDATE workspace_recording SET enabled = ?, updated_at = ? WHERE workspace_id = ?",
+		*payload.Enabled, time.Now().UTC(), workspaceID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating recording settings: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("updating recording settings: %w", err)
+	} else if n == 0 {
+		_, err = h.db.ExecContext(ctx,
+			"INSERT INTO workspace_recording (workspace_id, enabled) VALUES (?, ?)",
+			workspaceID, *payload.Enabled,
+		)
+		if err != nil && !db.IsDuplicate(err) {
+			return fmt.Errorf("creating recording settings: %w", err)
+		}
+	}
+
+	userID, _ := auth.UserIDFromCtx(c)
+	entry := audit.FromRequest(c, audit.EventRecordingUpdated, userID)
+	entry.Details = map[string]string{
+		"workspace_id": workspaceID,
+		"enabled":      strconv.FormatBool(*payload.Enabled),
+	}
+	h.audit.Log(ctx, entry)
+
+	return c.JSON(Settings{Enabled: *payload.Enabled})
+}
+
+// ListSessions lists a note's recorded sessions, newest first
+func (h *Handler) ListSessions(c *fiber.Ctx) error {
+	noteID := c.Params("id")
+	if err := h.requireNote(c, noteID); err != nil {
+		return err
+	}
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT s.id, s.started_at, s.ended_at, COUNT(o.seq) FROM note_sessions s LEFT JOIN note_session_ops o ON o.session_id = s.id WHERE s.note_id = ? GROUP BY s.id, s.started_at, s.ended_at ORDER BY s.started_at DESC LIMIT ?",
+		noteID, SessionsLimit,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching recorded sessions: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	sessions := []Session{}
+	for rows.Next() {
+		s := Session{NoteID: noteID}
+		var ended sql.NullTime
+		if err := rows.Scan(&s.ID, &s.StartedAt, &ended, &s.Edits); err != nil {
+			return fmt.Errorf("scanning recorded sessions: %w", err)
+		}
+		if ended.Valid {
+			s.EndedAt = &ended.Time
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching recorded sessions: %w", err)
+	}
+	return c.JSON(sessions)
+}
+
+// ReplaySession streams a recorded session's edits in order as
+// newline-delimited JSON, one ReplayedEdit per line
+func (h *Handler) ReplaySession(c *fiber.Ctx) error {
+	noteID, sessionID := c.Params("id"), c.Params("sessionID")
+	if err := h.requireNote(c, noteID); err != nil {
+		return err
+	}
+
+	var exists bool
+	err := h.db.QueryRowContext(c.UserContext(),
+		"SELECT EXISTS(SELECT 1 FROM note_sessions WHERE id = ? AND note_id = ?)",
+		sessionID, noteID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("fetching recorded session: %w", err)
+	}
+	if !exists {
+		return apperr.New(fiber.StatusNotFound, "Session not found")
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	// The body is written after the handler returns, once the request's
+	// context is done, so the stream has its own
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+		defer cancel()
+		if err := h.replay(ctx, sessionID, w); err != nil {
+			log.Printf("Error replaying session %s: %v", sessionID, err)
+		}
+	})
+	return nil
+}
+
+// replay writes a session's edits to w, flushing as it goes
+func (h *Handler) replay(ctx context.Context, sessionID string, w *bufio.Writer) error {
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT seq, offset_ms, user_id, revision, edit FROM note_session_ops WHERE session_id = ? ORDER BY seq",
+		sessionID,
+	)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	enc := json.NewEncoder(w)
+	for rows.Next() {
+		var e ReplayedEdit
+		var userID sql.NullString
+		var edit string
+		if err := rows.Scan(&e.Seq, &e.OffsetMS, &userID, &e.Revision, &edit); err != nil {
+			return err
+		}
+		if userID.Valid {
+			e.UserID = &userID.String
+		}
+		e.Edit = json.RawMessage(edit)
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		if w.Buffered() > 32<<10 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// settings fetches whether a workspace records sessions
+func (h *Handler) settings(ctx context.Context, workspaceID string) (Settings, error) {
+	var s Settings
+	err := h.db.QueryRowContext(ctx,
+		"SELECT enabled FROM workspace_recording WHERE workspace_id = ?", workspaceID,
+	).Scan(&s.Enabled)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return s, fmt.Errorf("fetching recording settings: %w", err)
+	}
+	return s, nil
+}
+
+// requireNote checks the current user may open the note
+func (h *Handler) requireNote(c *fiber.Ctx, noteID string) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	allowed, err := access.CanOpen(c.UserContext(), h.db, noteID, userID)
+	if err != nil {
+		return fmt.Errorf("checking note access: %w", err)
+	}
+	if !allowed {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+	return nil
+}
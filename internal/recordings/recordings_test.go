@@ -0,0 +1,196 @@
+package recordings
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	roleQuery   = regexp.QuoteMeta("SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?")
+	accessQuery = regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)))")
+)
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+type testHelper struct {
+	mockDB sqlmock.Sqlmock
+	app    *fiber.App
+	audit  *fakeAudit
+}
+
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	auditLog := &fakeAudit{}
+	handler := NewHandler(db, auditLog)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/workspaces/:id/recording", handler.GetSettings)
+	app.Put("/workspaces/:id/recording", handler.UpdateSettings)
+	app.Get("/notes/:id/sessions", handler.ListSessions)
+	app.Get("/notes/:id/sessions/:sessionID/replay", handler.ReplaySession)
+
+	return &testHelper{mockDB: mockDB, app: app, audit: auditLog}
+}
+
+func (h *testHelper) expectAccess(allowed bool) {
+	h.mockDB.ExpectQuery(accessQuery).
+		WithArgs("note1", "user123", "user123").
+		WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(allowed))
+}
+
+func TestUpdateSettings(t *testing.T) {
+	update := regexp.QuoteMeta("UPDATE workspace_recording SET enabled = ?, updated_at = ? WHERE workspace_id = ?")
+	insert := regexp.QuoteMeta("INSERT INTO workspace_recording (workspace_id, enabled) VALUES (?, ?)")
+
+	testCases := []struct {
+		name           string
+		body           string
+		role           string
+		existing       bool
+		expectedStatus int
+	}{
+		{name: "first settings", body: `{"enabled":true}`, role: "owner", expectedStatus: fiber.StatusOK},
+		{name: "existing settings", body: `{"enabled":false}`, role: "admin", existing: true, expectedStatus: fiber.StatusOK},
+		{name: "member", body: `{"enabled":true}`, role: "member", expectedStatus: fiber.StatusForbidden},
+		{name: "missing enabled", body: `{}`, expectedStatus: fiber.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			enabled := strings.Contains(tc.body, "true")
+			if tc.role != "" {
+				h.mockDB.ExpectQuery(roleQuery).WithArgs("ws1", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(tc.role))
+			}
+			if tc.expectedStatus == fiber.StatusOK {
+				rows := int64(0)
+				if tc.existing {
+					rows = 1
+				}
+				h.mockDB.ExpectExec(update).WithArgs(enabled, sqlmock.AnyArg(), "ws1").
+					WillReturnResult(sqlmock.NewResult(0, rows))
+				if !tc.existing {
+					h.mockDB.ExpectExec(insert).WithArgs("ws1", enabled).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+			}
+
+			req := httptest.NewRequest("PUT", "/workspaces/ws1/recording", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := h.app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusOK {
+				var settings Settings
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&settings))
+				assert.Equal(t, enabled, settings.Enabled)
+				if assert.Len(t, h.audit.entries, 1) {
+					assert.Equal(t, audit.EventRecordingUpdated, h.audit.entries[0].Event)
+					assert.Equal(t, "ws1", h.audit.entries[0].Details["workspace_id"])
+				}
+			} else {
+				assert.Empty(t, h.audit.entries)
+			}
+			assert.NoError(t, h.mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	h := newTestHelper(t)
+	started := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	ended := started.Add(time.Hour)
+
+	h.expectAccess(true)
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT s.id, s.started_at, s.ended_at, COUNT(o.seq) FROM note_sessions s")).
+		WithArgs("note1", SessionsLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "started_at", "ended_at", "count"}).
+			AddRow("s2", ended, nil, 0).
+			AddRow("s1", started, ended, 42))
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/sessions", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var sessions []Session
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+	assert.Equal(t, []Session{
+		{ID: "s2", NoteID: "note1", StartedAt: ended},
+		{ID: "s1", NoteID: "note1", StartedAt: started, EndedAt: &ended, Edits: 42},
+	}, sessions)
+
+	h.expectAccess(false)
+	resp, err = h.app.Test(httptest.NewRequest("GET", "/notes/note1/sessions", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestReplaySession(t *testing.T) {
+	h := newTestHelper(t)
+	sessionQuery := regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM note_sessions WHERE id = ? AND note_id = ?)")
+
+	h.expectAccess(true)
+	h.mockDB.ExpectQuery(sessionQuery).WithArgs("s1", "note1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT seq, offset_ms, user_id, revision, edit FROM note_session_ops WHERE session_id = ? ORDER BY seq")).
+		WithArgs("s1").
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "offset_ms", "user_id", "revision", "edit"}).
+			AddRow(1, 0, "user123", 7, `{"type":"edit","content":"hi"}`).
+			AddRow(2, 1500, nil, 8, `{"type":"edit","ops":[{"at":2,"insert":"!"}]}`))
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/notes/note1/sessions/s1/replay", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	var edits []ReplayedEdit
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var e ReplayedEdit
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		edits = append(edits, e)
+	}
+	require.Len(t, edits, 2)
+	assert.Equal(t, "user123", *edits[0].UserID)
+	assert.JSONEq(t, `{"type":"edit","content":"hi"}`, string(edits[0].Edit))
+	assert.Nil(t, edits[1].UserID)
+	assert.Equal(t, int64(1500), edits[1].OffsetMS)
+	assert.Equal(t, int64(8), edits[1].Revision)
+
+	// Sessions of other notes aren't found through this one
+	h.expectAccess(true)
+	h.mockDB.ExpectQuery(sessionQuery).WithArgs("s9", "note1").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	resp, err = h.app.Test(httptest.NewRequest("GET", "/notes/note1/sessions/s9/replay", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
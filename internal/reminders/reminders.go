@@ -0,0 +1,209 @@
+// Package reminders lets users schedule reminders on notes and fires them
+// as notifications and emails when they fall due
+package reminders
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/access"
+	"quanta/internal/apperr"
+	"quanta/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// Recurrence is how often a reminder repeats
+type Recurrence string
+
+const (
+	// Once reminders fire a single time and are then removed
+	Once Recurrence = ""
+	// Daily reminders repeat every day at the same time
+	Daily Recurrence = "daily"
+	// Weekly reminders repeat every 7 days
+	Weekly Recurrence = "weekly"
+	// Monthly reminders repeat on the same day of every month
+	Monthly Recurrence = "monthly"
+	// Yearly reminders repeat on the same date every year
+	Yearly Recurrence = "yearly"
+)
+
+// MaxListLimit is the most reminders returned by a single list request
+const MaxListLimit = 100
+
+// Valid reports whether r is a known recurrence
+func (r Recurrence) Valid() bool {
+	switch r {
+	case Once, Daily, Weekly, Monthly, Yearly:
+		return true
+	}
+	return false
+}
+
+// next returns the first occurrence after now of a reminder repeating from
+// due, or the zero time for one-off reminders. Occurrences missed while the
+// scheduler was down are skipped rather than fired in a burst.
+func (r Recurrence) next(due, now time.Time) time.Time {
+	var years, months, days int
+	switch r {
+	case Daily:
+		days = 1
+	case Weekly:
+		days = 7
+	case Monthly:
+		months = 1
+	case Yearly:
+		years = 1
+	default:
+		return time.Time{}
+	}
+	for !due.After(now) {
+		due = due.AddDate(years, months, days)
+	}
+	return due
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Reminder is a point in time at which a user wants to be reminded of a note
+type Reminder struct {
+	ID         string     `json:"id"`
+	NoteID     string     `json:"note_id"`
+	UserID     string     `json:"user_id"`
+	DueAt      time.Time  `json:"due_at"`
+	Recurrence Recurrence `json:"recurrence"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ReminderPayload is the request body for CreateReminder
+type ReminderPayload struct {
+	DueAt time.Time `json:"due_at"`
+	// Recurrence is one of daily, weekly, monthly or yearly, or empty for a
+	// reminder that fires once
+	Recurrence Recurrence `json:"recurrence"`
+}
+
+// Handler handles HTTP requests related to reminders
+type Handler struct {
+	db DBInterface
+}
+
+// NewHandler creates a new Handler with the provided database interface
+func NewHandler(db DBInterface) *Handler {
+	return &Handler{db: db}
+}
+
+// CreateReminder schedules a reminder on a note the user can access
+func (h *Handler) CreateReminder(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+	noteID := c.Params("id")
+
+	var payload ReminderPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	errs := map[string]string{}
+	if payload.DueAt.IsZero() {
+		errs["due_at"] = "required"
+	} else if !payload.DueAt.After(time.Now()) {
+		errs["due_at"] = "must be in the future"
+	}
+	if !payload.Recurrence.Valid() {
+		errs["recurrence"] = "must be daily, weekly, monthly, yearly or empty"
+	}
+	if len(errs) > 0 {
+		return apperr.Invalid(errs)
+	}
+
+	allowed, err := access.CanOpen(c.UserContext(), h.db, noteID, userID)
+	if err != nil {
+		return fmt.Errorf("checking note access: %w", err)
+	}
+	if !allowed {
+		return apperr.New(fiber.StatusNotFound, "Note not found or unauthorized")
+	}
+
+	r := Reminder{
+		ID:         uuid.New().String(),
+		NoteID:     noteID,
+		UserID:     userID,
+		DueAt:      payload.DueAt.UTC(),
+		Recurrence: payload.Recurrence,
+		CreatedAt:  time.Now(),
+	}
+	_, err = h.db.ExecContext(c.UserContext(),
+		"INSERT INTO reminders (id, note_id, user_id, due_at, recurrence) VALUES (?, ?, ?, ?, ?)",
+		r.ID, r.NoteID, r.UserID, r.DueAt, string(r.Recurrence),
+	)
+	if err != nil {
+		return fmt.Errorf("creating reminder: %w", err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(r)
+}
+
+// ListReminders returns the user's upcoming reminders, soonest first
+func (h *Handler) ListReminders(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.db.QueryContext(c.UserContext(),
+		"SELECT id, note_id, user_id, due_at, recurrence, created_at FROM reminders WHERE user_id = ? ORDER BY due_at LIMIT ?",
+		userID, MaxListLimit,
+	)
+	if err != nil {
+		return fmt.Errorf("fetching reminders: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Println("Error closing rows:", err)
+		}
+	}()
+
+	reminders := []Reminder{}
+	for rows.Next() {
+		var r Reminder
+		if err := rows.Scan(&r.ID, &r.NoteID, &r.UserID, &r.DueAt, &r.Recurrence, &r.CreatedAt); err != nil {
+			return fmt.Errorf("scanning reminder: %w", err)
+		}
+		reminders = append(reminders, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("fetching reminders: %w", err)
+	}
+
+	return c.JSON(reminders)
+}
+
+// DeleteReminder cancels one of the user's reminders
+func (h *Handler) DeleteReminder(c *fiber.Ctx) error {
+	userID, err := auth.UserIDFromCtx(c)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.db.ExecContext(c.UserContext(), "DELETE FROM reminders WHERE id = ? AND user_id = ?", c.Params("id"), userID)
+	if err != nil {
+		return fmt.Errorf("deleting reminder: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return apperr.New(fiber.StatusNotFound, "Reminder not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,185 @@
+package reminders
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var accessQuery = regexp.QuoteMeta("SELECT EXISTS(SELECT 1 FROM notes WHERE id = ? AND ((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?)))")
+
+// testHelper contains common test setup and utilities
+type testHelper struct {
+	t      *testing.T
+	mockDB sqlmock.Sqlmock
+	app    *fiber.App
+}
+
+// newTestHelper creates a new test helper with the reminder routes mounted
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	handler := NewHandler(db)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Post("/notes/:id/reminders", handler.CreateReminder)
+	app.Get("/reminders", handler.ListReminders)
+	app.Delete("/reminders/:id", handler.DeleteReminder)
+
+	return &testHelper{t: t, mockDB: mockDB, app: app}
+}
+
+func TestCreateReminder(t *testing.T) {
+	due := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	testCases := []struct {
+		name           string
+		body           string
+		checkAccess    bool
+		allowed        bool
+		expectInsert   bool
+		expectedStatus int
+		expectedErrors map[string]string
+	}{
+		{
+			name:           "Success",
+			body:           `{"due_at":"` + due.Format(time.RFC3339) + `","recurrence":"weekly"}`,
+			checkAccess:    true,
+			allowed:        true,
+			expectInsert:   true,
+			expectedStatus: fiber.StatusCreated,
+		},
+		{
+			name:           "No Access",
+			body:           `{"due_at":"` + due.Format(time.RFC3339) + `"}`,
+			checkAccess:    true,
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "Past Due Date",
+			body:           `{"due_at":"2020-01-01T00:00:00Z","recurrence":"hourly"}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{
+				"due_at":     "must be in the future",
+				"recurrence": "must be daily, weekly, monthly, yearly or empty",
+			},
+		},
+		{
+			name:           "Missing Due Date",
+			body:           `{}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{"due_at": "required"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			helper := newTestHelper(t)
+			if tc.checkAccess {
+				helper.mockDB.ExpectQuery(accessQuery).WithArgs("note1", "user123", "user123").
+					WillReturnRows(sqlmock.NewRows([]string{"allowed"}).AddRow(tc.allowed))
+			}
+			if tc.expectInsert {
+				helper.mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO reminders (id, note_id, user_id, due_at, recurrence) VALUES (?, ?, ?, ?, ?)")).
+					WithArgs(sqlmock.AnyArg(), "note1", "user123", due, "weekly").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			req := httptest.NewRequest("POST", "/notes/note1/reminders", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := helper.app.Test(req)
+			if err != nil {
+				t.Fatalf("error performing request: %v", err)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == fiber.StatusCreated {
+				var r Reminder
+				if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, "note1", r.NoteID)
+				assert.Equal(t, Weekly, r.Recurrence)
+				assert.True(t, due.Equal(r.DueAt))
+			} else if tc.expectedErrors != nil {
+				var response apperr.Response
+				if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+					t.Fatalf("error decoding response: %v", err)
+				}
+				assert.Equal(t, tc.expectedErrors, response.Errors)
+			}
+
+			if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestListReminders(t *testing.T) {
+	helper := newTestHelper(t)
+	now := time.Now()
+
+	helper.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, note_id, user_id, due_at, recurrence, created_at FROM reminders WHERE user_id = ? ORDER BY due_at LIMIT ?")).
+		WithArgs("user123", MaxListLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "note_id", "user_id", "due_at", "recurrence", "created_at"}).
+			AddRow("r1", "note1", "user123", now.Add(time.Hour), "", now).
+			AddRow("r2", "note2", "user123", now.Add(24*time.Hour), "daily", now))
+
+	resp, err := helper.app.Test(httptest.NewRequest("GET", "/reminders", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var reminders []Reminder
+	if err := json.NewDecoder(resp.Body).Decode(&reminders); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if assert.Len(t, reminders, 2) {
+		assert.Equal(t, Once, reminders[0].Recurrence)
+		assert.Equal(t, Daily, reminders[1].Recurrence)
+	}
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeleteReminder(t *testing.T) {
+	helper := newTestHelper(t)
+	query := regexp.QuoteMeta("DELETE FROM reminders WHERE id = ? AND user_id = ?")
+
+	helper.mockDB.ExpectExec(query).WithArgs("r1", "user123").WillReturnResult(sqlmock.NewResult(0, 1))
+	resp, err := helper.app.Test(httptest.NewRequest("DELETE", "/reminders/r1", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNoContent, resp.StatusCode)
+
+	helper.mockDB.ExpectExec(query).WithArgs("other", "user123").WillReturnResult(sqlmock.NewResult(0, 0))
+	resp, err = helper.app.Test(httptest.NewRequest("DELETE", "/reminders/other", nil))
+	if err != nil {
+		t.Fatalf("error performing request: %v", err)
+	}
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	if err := helper.mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package reminders
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/access"
+	"quanta/internal/notifications"
+)
+
+const (
+	// DefaultPollInterval is how often the scheduler looks for due reminders
+	DefaultPollInterval = time.Minute
+	// MaxFireBatch is the most reminders fired in one run. The rest wait for
+	// the next run.
+	MaxFireBatch = 500
+)
+
+// Notifier delivers in-app notifications. It is implemented by
+// *notifications.Handler.
+type Notifier interface {
+	Notify(ctx context.Context, userID string, kind notifications.Kind, payload map[string]string) error
+	NotifyEmailed(ctx context.Context, userID string, kind notifications.Kind, payload map[string]string) error
+}
+
+type dueReminder struct {
+	id         string
+	noteID     string
+	userID     string
+	dueAt      time.Time
+	recurrence Recurrence
+	title      string
+	email      string
+}
+
+// FireDue fires every reminder due at now: it emails the user and sends them
+// a notification, which reaches their open notification sockets. One-off
+// reminders are then removed and recurring ones moved to their next
+// occurrence. Reminders on notes the user can no longer access are left
+// alone. It returns the number of reminders fired.
+func FireDue(ctx context.Context, db DBInterface, notifier Notifier, mailer notifications.Mailer, now time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT r.id, r.note_id, r.user_id, r.due_at, r.recurrence, n.title, u.email FROM reminders r "+
+			"JOIN notes n ON n.id = r.note_id JOIN users u ON u.id = r.user_id "+
+			"WHERE r.due_at <= ? AND u.deleted_at IS NULL "+
+			"AND EXISTS(SELECT 1 FROM notes WHERE id = r.note_id AND "+access.NotesOf("r.user_id")+") "+
+			"ORDER BY r.due_at LIMIT ?",
+		now, MaxFireBatch,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var due []dueReminder
+	for rows.Next() {
+		var d dueReminder
+		if err := rows.Scan(&d.id, &d.noteID, &d.userID, &d.dueAt, &d.recurrence, &d.title, &d.email); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		due = append(due, d)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	fired := 0
+	for _, d := range due {
+		// Claim the reminder before delivering it, so a reminder picked up
+		// by two servers at once only fires on the one that moved it
+		claimed, err := claim(ctx, db, d, now)
+		if err != nil {
+			return fired, err
+		}
+		if !claimed {
+			continue
+		}
+
+		payload := map[string]string{"reminder_id": d.id, "note_id": d.noteID, "title": d.title}
+		notify := notifier.NotifyEmailed
+		if err := mailer.Send(d.email, "Reminder: "+d.title, reminderBody(d)); err != nil {
+			// Leave the email to the next digest
+			log.Printf("Error emailing reminder %s: %v", d.id, err)
+			notify = notifier.Notify
+		}
+		if err := notify(ctx, d.userID, notifications.KindReminder, payload); err != nil {
+			log.Printf("Error notifying reminder %s: %v", d.id, err)
+		}
+		fired++
+	}
+
+	return fired, nil
+}
+
+// claim removes a one-off reminder or moves a recurring one past now,
+// reporting false if another run already did
+func claim(ctx context.Context, db DBInterface, d dueReminder, now time.Time) (bool, error) {
+	var query string
+	var args []any
+	if next := d.recurrence.next(d.dueAt, now); next.IsZero() {
+		query, args = "DELETE FROM reminders WHERE id = ?", []any{d.id}
+	} else {
+		query, args = "UPDATE reminders SET due_at = ? WHERE id = ? AND due_at <= ?", []any{next, d.id, now}
+	}
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func reminderBody(d dueReminder) string {
+	return fmt.Sprintf("This is your reminder for the note %q, due %s.\n", d.title, d.dueAt.Format(time.RFC1123))
+}
+
+// StartScheduler fires due reminders every interval until stop is closed.
+// Each run must finish within the interval.
+// Reminders due while paused reports true fire once it no longer does.
+func StartScheduler(db DBInterface, notifier Notifier, mailer notifications.Mailer, interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			fired, err := FireDue(ctx, db, notifier, mailer, time.Now().UTC())
+			cancel()
+			if err != nil {
+				log.Println("Error firing reminders:", err)
+				continue
+			}
+			if fired > 0 {
+				log.Printf("Fired %d reminders", fired)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"quanta/internal/notifications"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type sentNotification struct {
+	userID  string
+	payload map[string]string
+	emailed bool
+}
+
+// fakeNotifier records the notifications it is asked to send
+type fakeNotifier struct {
+	sent []sentNotification
+}
+
+func (f *fakeNotifier) Notify(_ context.Context, userID string, _ notifications.Kind, payload map[string]string) error {
+	f.sent = append(f.sent, sentNotification{userID, payload, false})
+	return nil
+}
+
+func (f *fakeNotifier) NotifyEmailed(_ context.Context, userID string, _ notifications.Kind, payload map[string]string) error {
+	f.sent = append(f.sent, sentNotification{userID, payload, true})
+	return nil
+}
+
+// fakeMailer records recipients and fails for those listed in fail
+type fakeMailer struct {
+	to   []string
+	fail map[string]bool
+}
+
+func (f *fakeMailer) Send(to, _, _ string) error {
+	if f.fail[to] {
+		return errors.New("relay down")
+	}
+	f.to = append(f.to, to)
+	return nil
+}
+
+func TestFireDue(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT r.id, r.note_id, r.user_id, r.due_at, r.recurrence, n.title, u.email FROM reminders r")).
+		WithArgs(now, MaxFireBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "note_id", "user_id", "due_at", "recurrence", "title", "email"}).
+			AddRow("r1", "note1", "alice", now.Add(-time.Minute), "", "Groceries", "alice@example.com").
+			AddRow("r2", "note2", "bob", now.Add(-49*time.Hour), "daily", "Standup", "bob@example.com").
+			AddRow("r3", "note3", "carol", now, "", "Taken", "carol@example.com"))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM reminders WHERE id = ?")).
+		WithArgs("r1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Missed occurrences are skipped, so the next one is tomorrow's
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE reminders SET due_at = ? WHERE id = ? AND due_at <= ?")).
+		WithArgs(now.Add(23*time.Hour), "r2", now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Another server already fired r3
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM reminders WHERE id = ?")).
+		WithArgs("r3").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	notifier := &fakeNotifier{}
+	mailer := &fakeMailer{fail: map[string]bool{"bob@example.com": true}}
+	fired, err := FireDue(context.Background(), db, notifier, mailer, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fired)
+
+	assert.Equal(t, []string{"alice@example.com"}, mailer.to)
+	if assert.Len(t, notifier.sent, 2) {
+		assert.Equal(t, sentNotification{"alice", map[string]string{"reminder_id": "r1", "note_id": "note1", "title": "Groceries"}, true}, notifier.sent[0])
+		// Bob's email failed, so his notification is left for the digest
+		assert.Equal(t, "bob", notifier.sent[1].userID)
+		assert.False(t, notifier.sent[1].emailed)
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	due := time.Date(2026, 1, 15, 8, 30, 0, 0, time.UTC)
+
+	assert.True(t, Once.next(due, due).IsZero())
+	assert.Equal(t, due.AddDate(0, 0, 1), Daily.next(due, due))
+	assert.Equal(t, due.AddDate(0, 0, 14), Weekly.next(due, due.AddDate(0, 0, 10)))
+	assert.Equal(t, time.Date(2026, 2, 15, 8, 30, 0, 0, time.UTC), Monthly.next(due, due))
+	assert.Equal(t, time.Date(2027, 1, 15, 8, 30, 0, 0, time.UTC), Yearly.next(due, due))
+}
@@ -0,0 +1,273 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/changelog"
+	"quanta/internal/db"
+)
+
+// DefaultInterval is how often retention policies are enforced
+const DefaultInterval = time.Hour
+
+// Result counts what enforcing policies changed
+type Result struct {
+	Archived  int
+	Purged    int
+	Revisions int
+}
+
+// The rules pick notes by how long ago they were last edited. Archiving
+// doesn't count as an edit, so a note archived by hand is purged as soon
+// as it has gone unedited for PurgeArchivedAfterDays.
+const (
+	archivable = "workspace_id = ? AND archived = ? AND pinned = ? AND updated_at < ?"
+	purgeable  = "workspace_id = ? AND archived = ? AND updated_at < ?"
+)
+
+func archiveArgs(workspaceID string, days int, now time.Time) []any {
+	return []any{workspaceID, false, false, cutoff(now, days)}
+}
+
+func purgeArgs(workspaceID string, days int, now time.Time) []any {
+	return []any{workspaceID, true, cutoff(now, days)}
+}
+
+func cutoff(now time.Time, days int) time.Time {
+	return now.UTC().AddDate(0, 0, -days)
+}
+
+// EnforceAll applies every workspace's retention policy as of now. A
+// workspace that fails is logged and skipped so the others still run.
+func EnforceAll(ctx context.Context, db DBInterface, now time.Time) (Result, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT workspace_id, archive_after_days, purge_archived_after_days, max_revisions FROM workspace_retention WHERE archive_after_days IS NOT NULL OR purge_archived_after_days IS NOT NULL OR max_revisions IS NOT NULL",
+	)
+	if err != nil {
+		return Result{}, fmt.Errorf("fetching retention policies: %w", err)
+	}
+	type workspacePolicy struct {
+		workspaceID string
+		policy      Policy
+	}
+	var policies []workspacePolicy
+	for rows.Next() {
+		var wp workspacePolicy
+		if err := rows.Scan(&wp.workspaceID, &wp.policy.ArchiveAfterDays, &wp.policy.PurgeArchivedAfterDays, &wp.policy.MaxRevisions); err != nil {
+			_ = rows.Close()
+			return Result{}, fmt.Errorf("scanning retention policies: %w", err)
+		}
+		policies = append(policies, wp)
+	}
+	if err := rows.Close(); err != nil {
+		return Result{}, err
+	}
+
+	var total Result
+	for _, wp := range policies {
+		res, err := Enforce(ctx, db, wp.workspaceID, wp.policy, now)
+		total.Archived += res.Archived
+		total.Purged += res.Purged
+		total.Revisions += res.Revisions
+		if err != nil {
+			log.Printf("Error enforcing retention policy of workspace %s: %v", wp.workspaceID, err)
+			continue
+		}
+		if _, err := db.ExecContext(ctx,
+			"UPDATE workspace_retention SET last_enforced_at = ? WHERE workspace_id = ?", now.UTC(), wp.workspaceID,
+		); err != nil {
+			log.Printf("Error recording retention run of workspace %s: %v", wp.workspaceID, err)
+		}
+	}
+	return total, nil
+}
+
+// Enforce applies one workspace's policy as of now. Archived notes are
+// purged before others are archived, so a note is never archived and
+// deleted in the same run.
+func Enforce(ctx context.Context, db DBInterface, workspaceID string, p Policy, now time.Time) (Result, error) {
+	var res Result
+	if p.PurgeArchivedAfterDays != nil {
+		n, err := purge(ctx, db, workspaceID, *p.PurgeArchivedAfterDays, now)
+		if err != nil {
+			return res, fmt.Errorf("purging archived notes: %w", err)
+		}
+		res.Purged = n
+	}
+	if p.ArchiveAfterDays != nil {
+		// Archiving isn't an edit, so updated_at is kept as it was
+		n, err := exec(ctx, db,
+			"UPDATE notes SET archived = ?, updated_at = updated_at WHERE "+archivable,
+			append([]any{true}, archiveArgs(workspaceID, *p.ArchiveAfterDays, now)...)...,
+		)
+		if err != nil {
+			return res, fmt.Errorf("archiving notes: %w", err)
+		}
+		res.Archived = n
+	}
+	if p.MaxRevisions != nil {
+		over, err := overCap(ctx, db, workspaceID, *p.MaxRevisions)
+		if err != nil {
+			return res, err
+		}
+		for _, note := range over {
+			// Revisions are numbered by note version, so everything at or
+			// below the oldest one over the cap goes
+			var oldest int64
+			err := db.QueryRowContext(ctx,
+				"SELECT version FROM note_revisions WHERE note_id = ? ORDER BY version DESC LIMIT 1 OFFSET ?",
+				note.id, *p.MaxRevisions,
+			).Scan(&oldest)
+			if err != nil {
+				return res, fmt.Errorf("trimming revisions: %w", err)
+			}
+			n, err := exec(ctx, db, "DELETE FROM note_revisions WHERE note_id = ? AND version <= ?", note.id, oldest)
+			if err != nil {
+				return res, fmt.Errorf("trimming revisions: %w", err)
+			}
+			res.Revisions += n
+		}
+	}
+	return res, nil
+}
+
+// purge deletes the workspace's archived notes that have gone unedited for
+// days, logging them as deleted in the same transaction
+func purge(ctx context.Context, conn DBInterface, workspaceID string, days int, now time.Time) (int, error) {
+	args := purgeArgs(workspaceID, days, now)
+	var n int
+	err := db.InTx(ctx, conn, func(tx *sql.Tx) error {
+		if err := changelog.Record(ctx, tx, changelog.Deleted, purgeable, args...); err != nil {
+			return err
+		}
+		var err error
+		n, err = exec(ctx, tx, "DELETE FROM notes WHERE "+purgeable, args...)
+		return err
+	})
+	return n, err
+}
+
+// noteRevisions is a note with more revisions than a policy keeps
+type noteRevisions struct {
+	id    string
+	count int
+}
+
+// overCap lists the workspace's notes with more than keep revisions
+func overCap(ctx context.Context, db DBInterface, workspaceID string, keep int) ([]noteRevisions, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT r.note_id, COUNT(*) FROM note_revisions r JOIN notes n ON n.id = r.note_id WHERE n.workspace_id = ? GROUP BY r.note_id HAVING COUNT(*) > ? ORDER BY r.note_id",
+		workspaceID, keep,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("counting revisions: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var notes []noteRevisions
+	for rows.Next() {
+		var n noteRevisions
+		if err := rows.Scan(&n.id, &n.count); err != nil {
+			return nil, fmt.Errorf("counting revisions: %w", err)
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// preview works out what Enforce would change without changing it
+func preview(ctx context.Context, db DBInterface, workspaceID string, p Policy, now time.Time) (Preview, error) {
+	pv := Preview{Archive: Affected{Notes: []NoteSummary{}}, Purge: Affected{Notes: []NoteSummary{}}}
+	var err error
+	if p.PurgeArchivedAfterDays != nil {
+		if pv.Purge, err = affected(ctx, db, purgeable, purgeArgs(workspaceID, *p.PurgeArchivedAfterDays, now)); err != nil {
+			return pv, err
+		}
+	}
+	if p.ArchiveAfterDays != nil {
+		if pv.Archive, err = affected(ctx, db, archivable, archiveArgs(workspaceID, *p.ArchiveAfterDays, now)); err != nil {
+			return pv, err
+		}
+	}
+	if p.MaxRevisions != nil {
+		over, err := overCap(ctx, db, workspaceID, *p.MaxRevisions)
+		if err != nil {
+			return pv, err
+		}
+		for _, note := range over {
+			pv.Revisions.Notes++
+			pv.Revisions.Revisions += note.count - *p.MaxRevisions
+		}
+	}
+	return pv, nil
+}
+
+// affected counts the notes matching where and lists the least recently
+// edited of them
+func affected(ctx context.Context, db DBInterface, where string, args []any) (Affected, error) {
+	a := Affected{Notes: []NoteSummary{}}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM notes WHERE "+where, args...).Scan(&a.Count); err != nil {
+		return a, fmt.Errorf("previewing retention policy: %w", err)
+	}
+	if a.Count == 0 {
+		return a, nil
+	}
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, title, updated_at FROM notes WHERE "+where+" ORDER BY updated_at, id LIMIT ?",
+		append(args, PreviewLimit)...,
+	)
+	if err != nil {
+		return a, fmt.Errorf("previewing retention policy: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var n NoteSummary
+		if err := rows.Scan(&n.ID, &n.Title, &n.UpdatedAt); err != nil {
+			return a, fmt.Errorf("previewing retention policy: %w", err)
+		}
+		a.Notes = append(a.Notes, n)
+	}
+	return a, rows.Err()
+}
+
+func exec(ctx context.Context, db changelog.Execer, query string, args ...any) (int, error) {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// StartWorker enforces every workspace's retention policy every interval
+// until stop is closed Runs are skipped while paused reports true.
+func StartWorker(db DBInterface, interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			res, err := EnforceAll(ctx, db, now)
+			cancel()
+			if err != nil {
+				log.Println("Error enforcing retention policies:", err)
+				continue
+			}
+			if res != (Result{}) {
+				log.Printf("Retention policies archived %d notes, deleted %d and trimmed %d revisions", res.Archived, res.Purged, res.Revisions)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logDeleted logs purged notes as deleted in the changelog
+const logDeleted = "INSERT INTO note_changes (note_id, user_id, workspace_id, op, version) SELECT id, user_id, workspace_id, ?, version FROM notes WHERE " + purgeable
+
+func TestEnforceAll(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	// ws1 has every rule on; ws2's purge fails and is skipped
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT workspace_id, archive_after_days, purge_archived_after_days, max_revisions FROM workspace_retention WHERE")).
+		WillReturnRows(sqlmock.NewRows([]string{"workspace_id", "archive_after_days", "purge_archived_after_days", "max_revisions"}).
+			AddRow("ws1", 30, 365, 2).
+			AddRow("ws2", nil, 90, nil))
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(regexp.QuoteMeta(logDeleted)).
+		WithArgs("deleted", "ws1", true, now.AddDate(-1, 0, 0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM notes WHERE "+purgeable)).
+		WithArgs("ws1", true, now.AddDate(-1, 0, 0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectCommit()
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE notes SET archived = ?, updated_at = updated_at WHERE "+archivable)).
+		WithArgs(true, "ws1", false, false, now.AddDate(0, 0, -30)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT r.note_id, COUNT(*) FROM note_revisions r")).
+		WithArgs("ws1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"note_id", "count"}).AddRow("note1", 5))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT version FROM note_revisions WHERE note_id = ? ORDER BY version DESC LIMIT 1 OFFSET ?")).
+		WithArgs("note1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(7))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM note_revisions WHERE note_id = ? AND version <= ?")).
+		WithArgs("note1", int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE workspace_retention SET last_enforced_at = ? WHERE workspace_id = ?")).
+		WithArgs(now, "ws1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(regexp.QuoteMeta(logDeleted)).
+		WithArgs("deleted", "ws2", true, now.AddDate(0, 0, -90)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM notes WHERE "+purgeable)).
+		WithArgs("ws2", true, now.AddDate(0, 0, -90)).
+		WillReturnError(errors.New("lock wait timeout"))
+	mockDB.ExpectRollback()
+
+	res, err := EnforceAll(context.Background(), db, now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Archived: 3, Purged: 1, Revisions: 3}, res)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
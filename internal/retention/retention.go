@@ -0,0 +1,260 @@
+// Package retention lets workspace owners and admins set rules that keep a
+// workspace tidy: archiving notes nobody has touched for a while, deleting
+// archived notes after longer still and capping each note's revision
+// history. StartWorker enforces the rules; PreviewPolicy shows what they
+// would affect without changing anything.
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+	"quanta/internal/auth"
+	"quanta/internal/db"
+	"quanta/internal/handlers/workspaces"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// MaxDays bounds the day counts of a policy, about a hundred years
+	MaxDays = 36500
+	// MaxRevisionsLimit bounds MaxRevisions
+	MaxRevisionsLimit = 10000
+	// PreviewLimit is the most notes a preview lists for each rule
+	PreviewLimit = 50
+)
+
+// Policy is a workspace's retention rules. A nil rule is off.
+type Policy struct {
+	// ArchiveAfterDays archives notes that are neither pinned nor edited
+	// for this many days
+	ArchiveAfterDays *int `json:"archive_after_days"`
+	// PurgeArchivedAfterDays deletes archived notes not edited for this
+	// many days. Archived notes are the workspace's trash: deleted notes
+	// can't be brought back, archived ones can.
+	PurgeArchivedAfterDays *int `json:"purge_archived_after_days"`
+	// MaxRevisions keeps only each note's newest revisions
+	MaxRevisions *int `json:"max_revisions"`
+	// LastEnforcedAt is when the rules were last applied
+	LastEnforcedAt *time.Time `json:"last_enforced_at,omitempty"`
+}
+
+// Preview is what a policy would change if it were enforced now
+type Preview struct {
+	Archive   Affected          `json:"archive"`
+	Purge     Affected          `json:"purge"`
+	Revisions AffectedRevisions `json:"revisions"`
+}
+
+// Affected is the notes a rule applies to: how many and the least recently
+// edited of them
+type Affected struct {
+	Count int           `json:"count"`
+	Notes []NoteSummary `json:"notes"`
+}
+
+// NoteSummary identifies a note in a preview
+type NoteSummary struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AffectedRevisions is how many revisions MaxRevisions would delete and
+// from how many notes
+type AffectedRevisions struct {
+	Notes     int `json:"notes"`
+	Revisions int `json:"revisions"`
+}
+
+// DBInterface defines the methods for database operations
+type DBInterface interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// AuditLogger records security events in the audit log
+type AuditLogger interface {
+	Log(ctx context.Context, entry audit.Entry)
+}
+
+// Handler handles HTTP requests related to retention policies
+type Handler struct {
+	db    DBInterface
+	audit AuditLogger
+}
+
+// NewHandler creates a new Handler with the provided database interface
+// and the audit log that records policy changes
+func NewHandler(db DBInterface, auditLog AuditLogger) *Handler {
+	return &Handler{db: db, audit: auditLog}
+}
+
+// GetPolicy returns a workspace's retention policy to any of its members.
+// Workspaces without one get a policy with every rule off.
+func (h *Handler) GetPolicy(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	if _, err := workspaces.RequireRole(c, h.db, workspaceID); err != nil {
+		return err
+	}
+
+	policy, err := h.policy(c.UserContext(), workspaceID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(policy)
+}
+
+// UpdatePolicy replaces a workspace's retention policy. Only its owner and
+// admins may change it.
+func (h *Handler) UpdatePolicy(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	payload, err := parsePolicy(c)
+	if err != nil {
+		return err
+	}
+	if _, err := workspaces.RequireRole(c, h.db, workspaceID, workspaces.RoleOwner, workspaces.RoleAdmin); err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	res, err := h.db.ExecContext(ctx,
+		"UPDATE workspace_retention SET archive_after_days = ?, purge_archived_after_days = ?, max_revisions = ?, updated_at = ? WHERE workspace_id = ?",
+		payload.ArchiveAfterDays, payload.PurgeArchivedAfterDays, payload.MaxRevisions, time.Now().UTC(), workspaceID,
+	)
+	if err != nil {
+		return fmt.Errorf("updating retention policy: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("updating retention policy: %w", err)
+	} else if n == 0 {
+		_, err = h.db.ExecContext(ctx,
+			"INSERT INTO workspace_retention (workspace_id, archive_after_days, purge_archived_after_days, max_revisions) VALUES (?, ?, ?, ?)",
+			workspaceID, payload.ArchiveAfterDays, payload.PurgeArchivedAfterDays, payload.MaxRevisions,
+		)
+		if err != nil && !db.IsDuplicate(err) {
+			return fmt.Errorf("creating retention policy: %w", err)
+		}
+	}
+
+	userID, _ := auth.UserIDFromCtx(c)
+	entry := audit.FromRequest(c, audit.EventRetentionUpdated, userID)
+	entry.Details = map[string]string{
+		"workspace_id":              workspaceID,
+		"archive_after_days":        describe(payload.ArchiveAfterDays),
+		"purge_archived_after_days": describe(payload.PurgeArchivedAfterDays),
+		"max_revisions":             describe(payload.MaxRevisions),
+	}
+	h.audit.Log(ctx, entry)
+
+	policy, err := h.policy(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(policy)
+}
+
+// PreviewPolicy shows what a policy would archive, delete and trim if it
+// were enforced now, without changing anything. The policy is the request
+// body, or the saved one when the body is empty. Only the workspace's
+// owner and admins may preview.
+func (h *Handler) PreviewPolicy(c *fiber.Ctx) error {
+	workspaceID := c.Params("id")
+	var policy Policy
+	if len(c.Body()) > 0 {
+		var err error
+		if policy, err = parsePolicy(c); err != nil {
+			return err
+		}
+	}
+	if _, err := workspaces.RequireRole(c, h.db, workspaceID, workspaces.RoleOwner, workspaces.RoleAdmin); err != nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	if len(c.Body()) == 0 {
+		var err error
+		if policy, err = h.policy(ctx, workspaceID); err != nil {
+			return err
+		}
+	}
+
+	preview, err := preview(ctx, h.db, workspaceID, policy, time.Now())
+	if err != nil {
+		return err
+	}
+	return c.JSON(preview)
+}
+
+// parsePolicy reads and validates a Policy from the request body
+func parsePolicy(c *fiber.Ctx) (Policy, error) {
+	var payload Policy
+	if err := c.BodyParser(&payload); err != nil {
+		return payload, apperr.New(fiber.StatusBadRequest, "Invalid request payload")
+	}
+	payload.LastEnforcedAt = nil
+
+	fields := map[string]string{}
+	if d := payload.ArchiveAfterDays; d != nil && (*d < 1 || *d > MaxDays) {
+		fields["archive_after_days"] = fmt.Sprintf("must be from 1 to %d, or null to keep notes active", MaxDays)
+	}
+	if d := payload.PurgeArchivedAfterDays; d != nil && (*d < 1 || *d > MaxDays) {
+		fields["purge_archived_after_days"] = fmt.Sprintf("must be from 1 to %d, or null to keep archived notes", MaxDays)
+	}
+	if n := payload.MaxRevisions; n != nil && (*n < 1 || *n > MaxRevisionsLimit) {
+		fields["max_revisions"] = fmt.Sprintf("must be from 1 to %d, or null to keep every revision", MaxRevisionsLimit)
+	}
+	if len(fields) > 0 {
+		return payload, apperr.Invalid(fields)
+	}
+	return payload, nil
+}
+
+// policy fetches a workspace's retention policy
+func (h *Handler) policy(ctx context.Context, workspaceID string) (Policy, error) {
+	var p Policy
+	var archive, purge, revisions sql.NullInt64
+	var enforcedAt sql.NullTime
+	err := h.db.QueryRowContext(ctx,
+		"SELECT archive_after_days, purge_archived_after_days, max_revisions, last_enforced_at FROM workspace_retention WHERE workspace_id = ?",
+		workspaceID,
+	).Scan(&archive, &purge, &revisions, &enforcedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, nil
+	}
+	if err != nil {
+		return p, fmt.Errorf("fetching retention policy: %w", err)
+	}
+	p.ArchiveAfterDays = intPtr(archive)
+	p.PurgeArchivedAfterDays = intPtr(purge)
+	p.MaxRevisions = intPtr(revisions)
+	if enforcedAt.Valid {
+		p.LastEnforcedAt = &enforcedAt.Time
+	}
+	return p, nil
+}
+
+func intPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
+
+// describe formats a rule for the audit log
+func describe(n *int) string {
+	if n == nil {
+		return "off"
+	}
+	return strconv.Itoa(*n)
+}
@@ -0,0 +1,213 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quanta/internal/apperr"
+	"quanta/internal/audit"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	roleQuery   = regexp.QuoteMeta("SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?")
+	policyQuery = regexp.QuoteMeta("SELECT archive_after_days, purge_archived_after_days, max_revisions, last_enforced_at FROM workspace_retention WHERE workspace_id = ?")
+	policyCols  = []string{"archive_after_days", "purge_archived_after_days", "max_revisions", "last_enforced_at"}
+)
+
+// fakeAudit records the audit entries logged
+type fakeAudit struct {
+	entries []audit.Entry
+}
+
+func (f *fakeAudit) Log(_ context.Context, entry audit.Entry) {
+	f.entries = append(f.entries, entry)
+}
+
+type testHelper struct {
+	mockDB sqlmock.Sqlmock
+	app    *fiber.App
+	audit  *fakeAudit
+}
+
+func newTestHelper(t *testing.T) *testHelper {
+	db, mockDB, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening stub database: %v", err)
+	}
+
+	auditLog := &fakeAudit{}
+	handler := NewHandler(db, auditLog)
+	app := fiber.New(fiber.Config{ErrorHandler: apperr.Handler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user-id", "user123")
+		return c.Next()
+	})
+	app.Get("/workspaces/:id/retention", handler.GetPolicy)
+	app.Put("/workspaces/:id/retention", handler.UpdatePolicy)
+	app.Post("/workspaces/:id/retention/preview", handler.PreviewPolicy)
+
+	return &testHelper{mockDB: mockDB, app: app, audit: auditLog}
+}
+
+func (h *testHelper) expectRole(role string) {
+	rows := sqlmock.NewRows([]string{"role"})
+	if role != "" {
+		rows.AddRow(role)
+	}
+	h.mockDB.ExpectQuery(roleQuery).WithArgs("ws1", "user123").WillReturnRows(rows)
+}
+
+func TestGetPolicy(t *testing.T) {
+	h := newTestHelper(t)
+
+	h.expectRole("member")
+	h.mockDB.ExpectQuery(policyQuery).WithArgs("ws1").WillReturnRows(sqlmock.NewRows(policyCols))
+	resp, err := h.app.Test(httptest.NewRequest("GET", "/workspaces/ws1/retention", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var policy Policy
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&policy))
+	assert.Equal(t, Policy{}, policy)
+
+	h.expectRole("")
+	resp, err = h.app.Test(httptest.NewRequest("GET", "/workspaces/ws1/retention", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
+
+func TestUpdatePolicy(t *testing.T) {
+	update := regexp.QuoteMeta("UPDATE workspace_retention SET archive_after_days = ?, purge_archived_after_days = ?, max_revisions = ?, updated_at = ? WHERE workspace_id = ?")
+	insert := regexp.QuoteMeta("INSERT INTO workspace_retention (workspace_id, archive_after_days, purge_archived_after_days, max_revisions) VALUES (?, ?, ?, ?)")
+
+	testCases := []struct {
+		name           string
+		body           string
+		role           string
+		existing       bool
+		expectedStatus int
+		expectedErrors map[string]string
+	}{
+		{name: "first policy", body: `{"archive_after_days":90,"max_revisions":20}`, role: "owner", expectedStatus: fiber.StatusOK},
+		{name: "existing policy", body: `{"purge_archived_after_days":365}`, role: "admin", existing: true, expectedStatus: fiber.StatusOK},
+		{name: "member", body: `{"archive_after_days":90}`, role: "member", expectedStatus: fiber.StatusForbidden},
+		{
+			name:           "out of range",
+			body:           `{"archive_after_days":0,"max_revisions":100000}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedErrors: map[string]string{
+				"archive_after_days": "must be from 1 to 36500, or null to keep notes active",
+				"max_revisions":      "must be from 1 to 10000, or null to keep every revision",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHelper(t)
+			var payload Policy
+			require.NoError(t, json.Unmarshal([]byte(tc.body), &payload))
+
+			if tc.role != "" {
+				h.expectRole(tc.role)
+			}
+			if tc.expectedStatus == fiber.StatusOK {
+				rows := int64(0)
+				if tc.existing {
+					rows = 1
+				}
+				h.mockDB.ExpectExec(update).
+					WithArgs(payload.ArchiveAfterDays, payload.PurgeArchivedAfterDays, payload.MaxRevisions, sqlmock.AnyArg(), "ws1").
+					WillReturnResult(sqlmock.NewResult(0, rows))
+				if !tc.existing {
+					h.mockDB.ExpectExec(insert).
+						WithArgs("ws1", payload.ArchiveAfterDays, payload.PurgeArchivedAfterDays, payload.MaxRevisions).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				h.mockDB.ExpectQuery(policyQuery).WithArgs("ws1").
+					WillReturnRows(sqlmock.NewRows(policyCols).AddRow(payload.ArchiveAfterDays, payload.PurgeArchivedAfterDays, payload.MaxRevisions, nil))
+			}
+
+			req := httptest.NewRequest("PUT", "/workspaces/ws1/retention", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := h.app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedErrors != nil {
+				var body apperr.Response
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tc.expectedErrors, body.Errors)
+			}
+			if tc.expectedStatus == fiber.StatusOK {
+				var policy Policy
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&policy))
+				assert.Equal(t, payload, policy)
+				if assert.Len(t, h.audit.entries, 1) {
+					assert.Equal(t, audit.EventRetentionUpdated, h.audit.entries[0].Event)
+					assert.Equal(t, "ws1", h.audit.entries[0].Details["workspace_id"])
+				}
+			} else {
+				assert.Empty(t, h.audit.entries)
+			}
+			assert.NoError(t, h.mockDB.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPreviewPolicy(t *testing.T) {
+	h := newTestHelper(t)
+	updated := time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC)
+
+	// The saved policy archives after 30 days and keeps 5 revisions
+	h.expectRole("owner")
+	h.mockDB.ExpectQuery(policyQuery).WithArgs("ws1").
+		WillReturnRows(sqlmock.NewRows(policyCols).AddRow(30, nil, 5, nil))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM notes WHERE "+archivable)).
+		WithArgs("ws1", false, false, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT id, title, updated_at FROM notes WHERE "+archivable+" ORDER BY updated_at, id LIMIT ?")).
+		WithArgs("ws1", false, false, sqlmock.AnyArg(), PreviewLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "updated_at"}).AddRow("note1", "Old plans", updated))
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT r.note_id, COUNT(*) FROM note_revisions r JOIN notes n ON n.id = r.note_id WHERE n.workspace_id = ? GROUP BY r.note_id HAVING COUNT(*) > ? ORDER BY r.note_id")).
+		WithArgs("ws1", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"note_id", "count"}).AddRow("note1", 8).AddRow("note2", 6))
+
+	resp, err := h.app.Test(httptest.NewRequest("POST", "/workspaces/ws1/retention/preview", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+	var pv Preview
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&pv))
+	assert.Equal(t, Preview{
+		Archive:   Affected{Count: 1, Notes: []NoteSummary{{ID: "note1", Title: "Old plans", UpdatedAt: updated}}},
+		Purge:     Affected{Notes: []NoteSummary{}},
+		Revisions: AffectedRevisions{Notes: 2, Revisions: 4},
+	}, pv)
+
+	// A policy in the body is previewed instead of the saved one
+	h.expectRole("admin")
+	h.mockDB.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM notes WHERE "+purgeable)).
+		WithArgs("ws1", true, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	req := httptest.NewRequest("POST", "/workspaces/ws1/retention/preview", strings.NewReader(`{"purge_archived_after_days":180}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = h.app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	h.expectRole("member")
+	resp, err = h.app.Test(httptest.NewRequest("POST", "/workspaces/ws1/retention/preview", nil))
+	require.NoError(t, err)
+	assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	assert.NoError(t, h.mockDB.ExpectationsWereMet())
+}
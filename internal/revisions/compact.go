@@ -0,0 +1,267 @@
+package revisions
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"quanta/internal/db"
+	"quanta/internal/storage"
+)
+
+const (
+	// DefaultMinNoteBytes is how large a note must be for its revisions to
+	// be archived
+	DefaultMinNoteBytes = 64 << 10
+	// DefaultChunkSize is how many revisions are archived together, one
+	// full snapshot per chunk
+	DefaultChunkSize = 20
+	// DefaultKeepInline is how many of a note's latest revisions stay in
+	// the database, where recent diffs are read from
+	DefaultKeepInline = 10
+	// DefaultInterval is how often revisions are compacted
+	DefaultInterval = time.Hour
+)
+
+// Options tunes which revisions are archived
+type Options struct {
+	MinNoteBytes int64
+	ChunkSize    int
+	KeepInline   int
+}
+
+// withDefaults fills unset or non-positive options with the defaults
+func (o Options) withDefaults() Options {
+	if o.MinNoteBytes <= 0 {
+		o.MinNoteBytes = DefaultMinNoteBytes
+	}
+	if o.ChunkSize <= 0 {
+		o.ChunkSize = DefaultChunkSize
+	}
+	if o.KeepInline <= 0 {
+		o.KeepInline = DefaultKeepInline
+	}
+	return o
+}
+
+// Result counts what compacting changed
+type Result struct {
+	// Archived is how many revisions moved to storage, in Chunks chunks
+	Archived int
+	Chunks   int
+	// Removed is how many chunks were deleted because every revision in
+	// them had been trimmed or its note deleted
+	Removed int
+}
+
+// revision is an inline revision being archived
+type revision struct {
+	version int64
+	content string
+}
+
+// Compact archives the older revisions of large notes in full chunks,
+// keeping each note's latest revisions inline, then deletes the chunks no
+// revision refers to any more. A note that fails is logged and skipped so
+// the others still run.
+func (a *Archive) Compact(ctx context.Context) (Result, error) {
+	var res Result
+	notes, err := a.candidates(ctx)
+	if err != nil {
+		return res, err
+	}
+	for _, noteID := range notes {
+		archived, chunks, err := a.compactNote(ctx, noteID)
+		res.Archived += archived
+		res.Chunks += chunks
+		if err != nil {
+			log.Printf("Error compacting revisions of note %s: %v", noteID, err)
+		}
+	}
+
+	res.Removed, err = a.removeOrphans(ctx)
+	return res, err
+}
+
+// candidates lists the large notes with enough inline revisions to
+// archive a chunk
+func (a *Archive) candidates(ctx context.Context) ([]string, error) {
+	rows, err := a.db.QueryContext(ctx,
+		"SELECT r.note_id FROM note_revisions r JOIN notes n ON n.id = r.note_id WHERE n.size >= ? AND r.content IS NOT NULL GROUP BY r.note_id HAVING COUNT(*) >= ? ORDER BY r.note_id",
+		a.opts.MinNoteBytes, a.opts.KeepInline+a.opts.ChunkSize,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("finding notes to compact: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var notes []string
+	for rows.Next() {
+		var noteID string
+		if err := rows.Scan(&noteID); err != nil {
+			return nil, fmt.Errorf("scanning notes to compact: %w", err)
+		}
+		notes = append(notes, noteID)
+	}
+	return notes, rows.Err()
+}
+
+// compactNote archives a note's inline revisions in full chunks, oldest
+// first, leaving the latest KeepInline and any partial chunk inline
+func (a *Archive) compactNote(ctx context.Context, noteID string) (archived, chunks int, err error) {
+	inline, err := a.inline(ctx, noteID)
+	if err != nil {
+		return 0, 0, err
+	}
+	size := a.opts.ChunkSize
+	for start := 0; start+size <= len(inline)-a.opts.KeepInline; start += size {
+		if err := a.archive(ctx, noteID, inline[start:start+size]); err != nil {
+			return archived, chunks, err
+		}
+		archived += size
+		chunks++
+	}
+	return archived, chunks, nil
+}
+
+// inline reads a note's revisions still held in the database
+func (a *Archive) inline(ctx context.Context, noteID string) ([]revision, error) {
+	rows, err := a.db.QueryContext(ctx,
+		"SELECT version, content FROM note_revisions WHERE note_id = ? AND content IS NOT NULL ORDER BY version",
+		noteID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching revisions: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var revs []revision
+	for rows.Next() {
+		var r revision
+		if err := rows.Scan(&r.version, &r.content); err != nil {
+			return nil, fmt.Errorf("scanning revisions: %w", err)
+		}
+		revs = append(revs, r)
+	}
+	return revs, rows.Err()
+}
+
+// archive stores revisions as one chunk and clears their inline content.
+// The chunk is written before the database refers to it, and deleted
+// again if the database can't be updated.
+func (a *Archive) archive(ctx context.Context, noteID string, revs []revision) error {
+	c := chunk{NoteID: noteID, First: revs[0].version, Last: revs[len(revs)-1].version, Snapshot: revs[0].content}
+	for i := 1; i < len(revs); i++ {
+		d := diff(revs[i-1].content, revs[i].content)
+		d.Version = revs[i].version
+		c.Revisions = append(c.Revisions, d)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("revisions/%s/%d-%d.json", noteID, c.First, c.Last)
+	if err := a.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "application/json"); err != nil {
+		return fmt.Errorf("storing revision chunk: %w", err)
+	}
+
+	err = db.InTx(ctx, a.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO note_revision_chunks (note_id, first_version, last_version, storage_key, size) VALUES (?, ?, ?, ?, ?)",
+			noteID, c.First, c.Last, key, len(data),
+		)
+		if err != nil {
+			return err
+		}
+		// Revisions are never rewritten, so the chunk holds what every
+		// one of them in its range contains
+		_, err = tx.ExecContext(ctx,
+			"UPDATE note_revisions SET content = NULL WHERE note_id = ? AND version BETWEEN ? AND ?",
+			noteID, c.First, c.Last,
+		)
+		return err
+	})
+	if err != nil {
+		if err := a.store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error deleting unused revision chunk %s: %v", key, err)
+		}
+		return fmt.Errorf("recording revision chunk: %w", err)
+	}
+	return nil
+}
+
+// removeOrphans deletes the chunks none of whose revisions remain, after
+// retention trimmed them or their note was deleted
+func (a *Archive) removeOrphans(ctx context.Context) (int, error) {
+	rows, err := a.db.QueryContext(ctx,
+		"SELECT c.note_id, c.first_version, c.storage_key FROM note_revision_chunks c WHERE NOT EXISTS (SELECT 1 FROM note_revisions r WHERE r.note_id = c.note_id AND r.version BETWEEN c.first_version AND c.last_version)",
+	)
+	if err != nil {
+		return 0, fmt.Errorf("finding unused revision chunks: %w", err)
+	}
+	type orphan struct {
+		noteID string
+		first  int64
+		key    string
+	}
+	var orphans []orphan
+	for rows.Next() {
+		var o orphan
+		if err := rows.Scan(&o.noteID, &o.first, &o.key); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("scanning unused revision chunks: %w", err)
+		}
+		orphans = append(orphans, o)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("finding unused revision chunks: %w", err)
+	}
+
+	removed := 0
+	for _, o := range orphans {
+		if err := a.store.Delete(ctx, o.key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error deleting revision chunk %s: %v", o.key, err)
+			continue
+		}
+		if _, err := a.db.ExecContext(ctx, "DELETE FROM note_revision_chunks WHERE note_id = ? AND first_version = ?", o.noteID, o.first); err != nil {
+			return removed, fmt.Errorf("deleting revision chunk: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// StartCompactor compacts revisions on every interval until stop is closed
+// Runs are skipped while paused reports true.
+func StartCompactor(a *Archive, interval time.Duration, paused func() bool, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if paused() {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			res, err := a.Compact(ctx)
+			cancel()
+			if err != nil {
+				log.Println("Error compacting revisions:", err)
+				continue
+			}
+			if res != (Result{}) {
+				log.Printf("Archived %d revisions in %d chunks and removed %d unused chunks", res.Archived, res.Chunks, res.Removed)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
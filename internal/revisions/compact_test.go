@@ -0,0 +1,74 @@
+package revisions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"quanta/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive_Compact(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	a := New(db, store, Options{ChunkSize: 3, KeepInline: 2})
+	ctx := context.Background()
+
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT r.note_id FROM note_revisions r JOIN notes n ON n.id = r.note_id WHERE n.size >= ? AND r.content IS NOT NULL GROUP BY r.note_id HAVING COUNT(*) >= ? ORDER BY r.note_id")).
+		WithArgs(int64(DefaultMinNoteBytes), 5).
+		WillReturnRows(sqlmock.NewRows([]string{"note_id"}).AddRow("note1"))
+
+	// Eight inline revisions: two full chunks would leave fewer than two
+	// inline, so only the first chunk is archived
+	rows := sqlmock.NewRows([]string{"version", "content"})
+	for v := 1; v <= 8; v++ {
+		rows.AddRow(v, fmt.Sprintf("draft %d", v))
+	}
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT version, content FROM note_revisions WHERE note_id = ? AND content IS NOT NULL ORDER BY version")).
+		WithArgs("note1").WillReturnRows(rows)
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec(regexp.QuoteMeta("INSERT INTO note_revision_chunks (note_id, first_version, last_version, storage_key, size) VALUES (?, ?, ?, ?, ?)")).
+		WithArgs("note1", int64(1), int64(3), "revisions/note1/1-3.json", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mockDB.ExpectExec(regexp.QuoteMeta("UPDATE note_revisions SET content = NULL WHERE note_id = ? AND version BETWEEN ? AND ?")).
+		WithArgs("note1", int64(1), int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mockDB.ExpectCommit()
+
+	// A chunk whose note was deleted is removed along with its blob
+	require.NoError(t, store.Put(ctx, "revisions/gone/1-3.json", strings.NewReader("{}"), 2, "application/json"))
+	mockDB.ExpectQuery(regexp.QuoteMeta("SELECT c.note_id, c.first_version, c.storage_key FROM note_revision_chunks c WHERE NOT EXISTS")).
+		WillReturnRows(sqlmock.NewRows([]string{"note_id", "first_version", "storage_key"}).AddRow("gone", 1, "revisions/gone/1-3.json"))
+	mockDB.ExpectExec(regexp.QuoteMeta("DELETE FROM note_revision_chunks WHERE note_id = ? AND first_version = ?")).
+		WithArgs("gone", int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	res, err := a.Compact(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Archived: 3, Chunks: 1, Removed: 1}, res)
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+
+	// The chunk rebuilds every revision in it
+	body, err := store.Get(ctx, "revisions/note1/1-3.json")
+	require.NoError(t, err)
+	var c chunk
+	require.NoError(t, json.NewDecoder(body).Decode(&c))
+	require.NoError(t, body.Close())
+	for v := int64(1); v <= 3; v++ {
+		content, err := c.content(v)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("draft %d", v), content)
+	}
+
+	_, err = store.Get(ctx, "revisions/gone/1-3.json")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
@@ -0,0 +1,168 @@
+// Package revisions moves the revision history of large notes out of the
+// database into blob storage, and reads any revision back wherever it is
+// kept
+package revisions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"quanta/internal/storage"
+)
+
+// ErrNotFound is returned for a revision a note doesn't have
+var ErrNotFound = errors.New("revision not found")
+
+// DBInterface is the database access the archive needs
+type DBInterface interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Archive keeps note revisions in blob storage. Each chunk of archived
+// revisions is stored as a snapshot of its first revision's content
+// followed by the edit turning each revision into the next.
+type Archive struct {
+	db    DBInterface
+	store storage.Storage
+	opts  Options
+}
+
+// New returns an Archive storing chunks in store. Zero options fall back
+// to the defaults.
+func New(db DBInterface, store storage.Storage, opts Options) *Archive {
+	return &Archive{db: db, store: store, opts: opts.withDefaults()}
+}
+
+// chunk is a stored run of a note's revisions, First to Last. Versions
+// missing from the run, such as those lost to racing writes, have no
+// entry.
+type chunk struct {
+	NoteID    string  `json:"note_id"`
+	First     int64   `json:"first"`
+	Last      int64   `json:"last"`
+	Snapshot  string  `json:"snapshot"`
+	Revisions []delta `json:"revisions"`
+}
+
+// delta turns the content of the revision before it in a chunk into that
+// of Version by replacing Delete bytes at At with Insert
+type delta struct {
+	Version int64  `json:"version"`
+	At      int    `json:"at"`
+	Delete  int    `json:"delete,omitempty"`
+	Insert  string `json:"insert,omitempty"`
+}
+
+// diff returns the delta from before to after: the bytes between their
+// common prefix and suffix, kept on UTF-8 boundaries so Insert is valid
+// text
+func diff(before, after string) delta {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	for prefix > 0 && prefix < len(after) && !utf8.RuneStart(after[prefix]) {
+		prefix--
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+	for suffix > 0 && !utf8.RuneStart(after[len(after)-suffix]) {
+		suffix--
+	}
+	return delta{At: prefix, Delete: len(before) - prefix - suffix, Insert: after[prefix : len(after)-suffix]}
+}
+
+// apply returns content with the delta applied
+func (d delta) apply(content string) (string, error) {
+	if d.At < 0 || d.Delete < 0 || d.At+d.Delete > len(content) {
+		return "", fmt.Errorf("revision %d: edit outside the content", d.Version)
+	}
+	return content[:d.At] + d.Insert + content[d.At+d.Delete:], nil
+}
+
+// content replays the chunk up to version. A version the chunk has no
+// entry for had no content when it was archived.
+func (c *chunk) content(version int64) (string, error) {
+	content := c.Snapshot
+	if version == c.First {
+		return content, nil
+	}
+	for _, d := range c.Revisions {
+		if d.Version > version {
+			break
+		}
+		var err error
+		if content, err = d.apply(content); err != nil {
+			return "", err
+		}
+		if d.Version == version {
+			return content, nil
+		}
+	}
+	return "", nil
+}
+
+// Content returns a note's content at a version, reading it from the
+// database or rebuilding it from the chunk it was archived in
+func (a *Archive) Content(ctx context.Context, noteID string, version int64) (string, error) {
+	var content sql.NullString
+	err := a.db.QueryRowContext(ctx, "SELECT content FROM note_revisions WHERE note_id = ? AND version = ?", noteID, version).
+		Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetching revision: %w", err)
+	}
+	if content.Valid {
+		return content.String, nil
+	}
+
+	var key string
+	err = a.db.QueryRowContext(ctx,
+		"SELECT storage_key FROM note_revision_chunks WHERE note_id = ? AND first_version <= ? AND last_version >= ?",
+		noteID, version, version,
+	).Scan(&key)
+	// A revision that was never archived had no content
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("fetching revision chunk: %w", err)
+	}
+
+	c, err := a.readChunk(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	text, err := c.content(version)
+	if err != nil {
+		return "", fmt.Errorf("rebuilding revision from %s: %w", key, err)
+	}
+	return text, nil
+}
+
+// readChunk loads a chunk from storage
+func (a *Archive) readChunk(ctx context.Context, key string) (*chunk, error) {
+	r, err := a.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("reading revision chunk %s: %w", key, err)
+	}
+	defer r.Close()
+
+	var c chunk
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("decoding revision chunk %s: %w", key, err)
+	}
+	return &c, nil
+}
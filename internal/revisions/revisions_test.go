@@ -0,0 +1,89 @@
+package revisions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"unicode/utf8"
+
+	"quanta/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	testCases := []struct {
+		name          string
+		before, after string
+	}{
+		{name: "insert", before: "hello world", after: "hello, world"},
+		{name: "delete", before: "hello, world", after: "hello"},
+		{name: "replace", before: "abc", after: "xyz"},
+		{name: "unchanged", before: "same", after: "same"},
+		{name: "empty", before: "", after: "new"},
+		{name: "repeated", before: "aaaa", after: "aaaaaa"},
+		{name: "shared lead byte", before: "café", after: "cafè"},
+		{name: "shared trailing bytes", before: "a😀", after: "b😁"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := diff(tc.before, tc.after)
+			assert.True(t, utf8.ValidString(d.Insert))
+			got, err := d.apply(tc.before)
+			require.NoError(t, err)
+			assert.Equal(t, tc.after, got)
+		})
+	}
+
+	_, err := delta{At: 2, Delete: 5}.apply("abc")
+	assert.Error(t, err)
+}
+
+func TestArchive_Content(t *testing.T) {
+	db, mockDB, err := sqlmock.New()
+	require.NoError(t, err)
+	store, err := storage.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	a := New(db, store, Options{})
+	ctx := context.Background()
+
+	c := chunk{NoteID: "note1", First: 1, Last: 4, Snapshot: "one", Revisions: []delta{
+		{Version: 2, At: 3, Insert: " two"},
+		{Version: 4, At: 0, Delete: 4},
+	}}
+	data, _ := json.Marshal(c)
+	require.NoError(t, store.Put(ctx, "revisions/note1/1-4.json", bytes.NewReader(data), int64(len(data)), "application/json"))
+
+	revisionQuery := regexp.QuoteMeta("SELECT content FROM note_revisions WHERE note_id = ? AND version = ?")
+	chunkQuery := regexp.QuoteMeta("SELECT storage_key FROM note_revision_chunks WHERE note_id = ? AND first_version <= ? AND last_version >= ?")
+
+	// Inline revisions are read from the database
+	mockDB.ExpectQuery(revisionQuery).WithArgs("note1", int64(5)).
+		WillReturnRows(sqlmock.NewRows([]string{"content"}).AddRow("inline"))
+	content, err := a.Content(ctx, "note1", 5)
+	require.NoError(t, err)
+	assert.Equal(t, "inline", content)
+
+	// Archived ones are rebuilt from their chunk
+	for version, expected := range map[int64]string{1: "one", 2: "one two", 4: "two"} {
+		mockDB.ExpectQuery(revisionQuery).WithArgs("note1", version).
+			WillReturnRows(sqlmock.NewRows([]string{"content"}).AddRow(nil))
+		mockDB.ExpectQuery(chunkQuery).WithArgs("note1", version, version).
+			WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("revisions/note1/1-4.json"))
+		content, err := a.Content(ctx, "note1", version)
+		require.NoError(t, err)
+		assert.Equal(t, expected, content)
+	}
+
+	// Version 3 was lost to a race and never existed
+	mockDB.ExpectQuery(revisionQuery).WithArgs("note1", int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"content"}))
+	_, err = a.Content(ctx, "note1", 3)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, mockDB.ExpectationsWereMet())
+}
@@ -0,0 +1,198 @@
+// Package sanitize strips markup that could run script or load unwanted
+// content from HTML pasted into notes, leaving formatting and plain text
+// alone
+package sanitize
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Policy decides what markup survives sanitizing
+type Policy string
+
+const (
+	// Off leaves content as written
+	Off Policy = "off"
+	// Basic keeps formatting, links and images. Images may be embedded as
+	// data: URIs of PNG, GIF, JPEG or WebP.
+	Basic Policy = "basic"
+	// Strict keeps formatting and links but drops images, so rendering a
+	// note loads nothing from elsewhere. It suits pages served to people
+	// who are not signed in.
+	Strict Policy = "strict"
+)
+
+// Policies lists every policy, for validating settings
+var Policies = []Policy{Off, Basic, Strict}
+
+// Valid reports whether p is one of Policies
+func (p Policy) Valid() bool {
+	return slices.Contains(Policies, p)
+}
+
+// elements maps the elements kept by Basic to the attributes they keep
+var elements = map[string][]string{
+	"a": {"href", "title"}, "abbr": {"title"}, "b": nil, "blockquote": nil, "br": nil,
+	"caption": nil, "code": nil, "dd": nil, "del": nil, "div": nil, "dl": nil, "dt": nil,
+	"em": nil, "figcaption": nil, "figure": nil, "h1": nil, "h2": nil, "h3": nil,
+	"h4": nil, "h5": nil, "h6": nil, "hr": nil, "i": nil, "img": {"src", "alt", "title", "width", "height"},
+	"ins": nil, "kbd": nil, "li": nil, "mark": nil, "ol": {"start"}, "p": nil, "pre": nil,
+	"s": nil, "span": nil, "strong": nil, "sub": nil, "sup": nil, "table": nil,
+	"tbody": nil, "td": {"colspan", "rowspan"}, "tfoot": nil, "th": {"colspan", "rowspan"},
+	"thead": nil, "tr": nil, "u": nil, "ul": nil,
+}
+
+// dropped are the elements removed along with everything inside them. It
+// includes every element whose content the tokenizer reads as raw text,
+// which must never be written back out unescaped.
+var dropped = map[string]bool{
+	"applet": true, "frameset": true, "iframe": true, "math": true, "noembed": true,
+	"noframes": true, "noscript": true, "object": true, "plaintext": true, "script": true,
+	"style": true, "svg": true, "template": true, "textarea": true, "title": true, "xmp": true,
+}
+
+// rawText are the dropped elements whose start tag switches the tokenizer
+// to raw text even when written self-closing
+var rawText = map[string]bool{
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true,
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true,
+}
+
+// inert matches tags with no attribute values, such as <Enter> or the
+// Markdown autolink <https://example.com>. When their name is no HTML
+// element they are kept as written: browsers make them elements that do
+// nothing.
+var inert = regexp.MustCompile(`^</?[^\s<>"'=]+>$`)
+
+// imageTypes are the image formats Basic allows as data: URIs
+var imageTypes = []string{"png", "gif", "jpeg", "webp"}
+
+// HTML sanitizes s under the policy. Elements not on the allowlist are
+// removed but their text kept, except for scripts, styles, frames and
+// embedded objects, which go with their content. Kept elements lose every
+// attribute not on their allowlist, event handlers included, and links and
+// images lose URLs other than http, https, mailto and relative ones. Text,
+// and tags that are no HTML element, are copied as written, so Markdown and
+// ciphertext come back unchanged.
+func (p Policy) HTML(s string) string {
+	if p == Off || !strings.Contains(s, "<") {
+		return s
+	}
+
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(s))
+	var skip string
+	depth := 0
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return b.String()
+		}
+		if skip != "" {
+			if tt == html.StartTagToken || tt == html.EndTagToken {
+				if name, _ := z.TagName(); string(name) == skip {
+					if tt == html.StartTagToken {
+						depth++
+					} else if depth--; depth == 0 {
+						skip = ""
+					}
+				}
+			}
+			continue
+		}
+
+		raw := string(z.Raw())
+		switch tt {
+		case html.TextToken:
+			b.WriteString(raw)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := string(name)
+			if atom.Lookup(name) == 0 && inert.MatchString(raw) {
+				b.WriteString(raw)
+				continue
+			}
+			if dropped[tag] {
+				if tt == html.StartTagToken || rawText[tag] {
+					skip, depth = tag, 1
+				}
+				continue
+			}
+			if !p.keeps(tag) {
+				continue
+			}
+			b.WriteString("<" + tag)
+			seen := map[string]bool{}
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				attr := string(key)
+				if seen[attr] || !slices.Contains(elements[tag], attr) {
+					continue
+				}
+				seen[attr] = true
+				if (attr == "href" || attr == "src") && !p.allowsURL(tag, string(val)) {
+					continue
+				}
+				b.WriteString(" " + attr + `="` + html.EscapeString(string(val)) + `"`)
+			}
+			if tt == html.SelfClosingTagToken {
+				b.WriteString("/")
+			}
+			b.WriteString(">")
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if tag := string(name); p.keeps(tag) {
+				b.WriteString("</" + tag + ">")
+			} else if atom.Lookup(name) == 0 && inert.MatchString(raw) {
+				b.WriteString(raw)
+			}
+		default:
+			// Comments and doctypes are dropped
+		}
+	}
+}
+
+// keeps reports whether the policy keeps elements named tag
+func (p Policy) keeps(tag string) bool {
+	if _, ok := elements[tag]; !ok {
+		return false
+	}
+	return p != Strict || tag != "img"
+}
+
+// allowsURL reports whether an href or src of tag may point at raw
+func (p Policy) allowsURL(tag, raw string) bool {
+	// Browsers ignore whitespace and control characters in a scheme, so
+	// "java\tscript:" has to be caught too
+	u := strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, raw))
+
+	scheme, _, ok := strings.Cut(u, ":")
+	if !ok || strings.ContainsAny(scheme, "/?#") {
+		return true
+	}
+	switch scheme {
+	case "http", "https", "mailto":
+		return true
+	case "data":
+		if p != Basic || tag != "img" {
+			return false
+		}
+		for _, t := range imageTypes {
+			if strings.HasPrefix(u, "data:image/"+t+";") || strings.HasPrefix(u, "data:image/"+t+",") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package sanitize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTML(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy Policy
+		input  string
+		output string
+	}{
+		{
+			name:   "Plain Text",
+			policy: Basic,
+			input:  "R&D costs < revenue, see <https://example.com> or press <Enter>",
+			output: "R&D costs < revenue, see <https://example.com> or press <Enter>",
+		},
+		{
+			name:   "Formatting",
+			policy: Basic,
+			input:  `<p class="lead">Hello <b>world</b><br/></p>`,
+			output: "<p>Hello <b>world</b><br/></p>",
+		},
+		{
+			name:   "Script",
+			policy: Basic,
+			input:  `before<script>alert("<b>hi</b>")</script>after<script/>gone`,
+			output: "beforeafter",
+		},
+		{
+			name:   "Nested Dropped Elements",
+			policy: Basic,
+			input:  "<svg><svg><circle/></svg>text</svg>kept",
+			output: "kept",
+		},
+		{
+			name:   "Unknown Elements Keep Their Text",
+			policy: Basic,
+			input:  `<form action="/x"><label>Name <input name="n"></label></form><!-- note -->`,
+			output: "Name ",
+		},
+		{
+			name:   "Event Handlers",
+			policy: Basic,
+			input:  `<img src="cat.png" onerror="alert(1)" alt="cat"><x/onclick=alert(1)>`,
+			output: `<img src="cat.png" alt="cat">`,
+		},
+		{
+			name:   "Script URLs",
+			policy: Basic,
+			input:  `<a href="java&#x09;script:alert(1)" title="t">a</a><a href=" JavaScript:alert(1)">b</a><a href="/notes?a=1&amp;b=2">c</a>`,
+			output: `<a title="t">a</a><a>b</a><a href="/notes?a=1&amp;b=2">c</a>`,
+		},
+		{
+			name:   "Data URIs",
+			policy: Basic,
+			input:  `<img src="data:image/png;base64,AAAA"><img src="data:image/svg+xml;base64,AAAA"><a href="data:text/html,x">x</a>`,
+			output: `<img src="data:image/png;base64,AAAA"><img><a>x</a>`,
+		},
+		{
+			name:   "Strict",
+			policy: Strict,
+			input:  `<p>See <img src="data:image/png;base64,AAAA"><a href="https://example.com">this</a></p>`,
+			output: `<p>See <a href="https://example.com">this</a></p>`,
+		},
+		{
+			name:   "Off",
+			policy: Off,
+			input:  `<script>alert(1)</script>`,
+			output: `<script>alert(1)</script>`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.output, tc.policy.HTML(tc.input))
+		})
+	}
+}
+
+func TestPolicy_Valid(t *testing.T) {
+	assert.True(t, Strict.Valid())
+	assert.False(t, Policy("lenient").Valid())
+}
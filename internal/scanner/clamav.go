@@ -0,0 +1,108 @@
+// Package scanner checks files for malware with a ClamAV daemon
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a single scan, sending the file included
+	DefaultTimeout = time.Minute
+	// chunkSize is how much of a file is sent to clamd at a time
+	chunkSize = 64 << 10
+)
+
+// Verdict is what scanning a file found
+type Verdict struct {
+	Infected bool
+	// Signature names the malware found in an infected file
+	Signature string
+}
+
+// ClamAV scans files by streaming them to clamd with its INSTREAM command
+type ClamAV struct {
+	network string
+	addr    string
+	timeout time.Duration
+}
+
+// NewClamAV creates a scanner for the clamd listening at addr, a host and
+// port or the path of a Unix socket
+func NewClamAV(addr string) *ClamAV {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	return &ClamAV{network: network, addr: addr, timeout: DefaultTimeout}
+}
+
+// Scan sends r to clamd and returns its verdict. Files larger than clamd's
+// StreamMaxLength are refused with an error rather than passed as clean.
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.network, c.addr)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return Verdict{}, err
+	}
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return Verdict{}, fmt.Errorf("sending file to clamd: %w", err)
+	}
+	// Each chunk is prefixed with its length; an empty one ends the file
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return Verdict{}, fmt.Errorf("sending file to clamd: %w", err)
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return Verdict{}, fmt.Errorf("reading file to scan: %w", err)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Verdict{}, fmt.Errorf("sending file to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("reading clamd reply: %w", err)
+	}
+	return parseReply(strings.TrimSuffix(reply, "\x00"))
+}
+
+// parseReply reads clamd's answer to INSTREAM, such as "stream: OK" or
+// "stream: Eicar-Signature FOUND"
+func parseReply(reply string) (Verdict, error) {
+	result, ok := strings.CutPrefix(reply, "stream: ")
+	switch {
+	case ok && result == "OK":
+		return Verdict{}, nil
+	case ok && strings.HasSuffix(result, " FOUND"):
+		return Verdict{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	default:
+		return Verdict{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
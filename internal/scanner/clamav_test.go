@@ -0,0 +1,84 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClamd answers INSTREAM on a local port with what reply says of each
+// file and returns its address
+func fakeClamd(t *testing.T, reply func(file string) string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			command, err := r.ReadString(0)
+			if err != nil || command != "zINSTREAM\x00" {
+				_ = conn.Close()
+				continue
+			}
+			var file strings.Builder
+			for {
+				var size uint32
+				if err := binary.Read(r, binary.BigEndian, &size); err != nil || size == 0 {
+					break
+				}
+				_, _ = io.CopyN(&file, r, int64(size))
+			}
+			_, _ = io.WriteString(conn, reply(file.String())+"\x00")
+			_ = conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestClamAV_Scan(t *testing.T) {
+	addr := fakeClamd(t, func(file string) string {
+		switch {
+		case strings.Contains(file, "EICAR"):
+			return "stream: Eicar-Signature FOUND"
+		case len(file) > 100<<10:
+			return "INSTREAM size limit exceeded. ERROR"
+		default:
+			return "stream: OK"
+		}
+	})
+	clam := NewClamAV(addr)
+
+	verdict, err := clam.Scan(context.Background(), strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, Verdict{}, verdict)
+
+	verdict, err = clam.Scan(context.Background(), strings.NewReader("X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"))
+	require.NoError(t, err)
+	assert.Equal(t, Verdict{Infected: true, Signature: "Eicar-Signature"}, verdict)
+
+	// Large files go in several chunks and are refused past the limit
+	_, err = clam.Scan(context.Background(), strings.NewReader(strings.Repeat("a", 200<<10)))
+	assert.EqualError(t, err, "clamd: INSTREAM size limit exceeded. ERROR")
+}
+
+func TestClamAV_Unavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	_, err = NewClamAV(addr).Scan(context.Background(), strings.NewReader("hello"))
+	assert.ErrorContains(t, err, "connecting to clamd")
+}
@@ -0,0 +1,189 @@
+// Package similar finds near-duplicate documents by comparing the runs of
+// words (shingles) they share
+package similar
+
+import (
+	"hash/fnv"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ShingleSize is how many consecutive words make up a shingle
+const ShingleSize = 3
+
+// Signatures are made of bands*rows min-hashes. Two documents become
+// candidates when all the rows of any band agree, which catches pairs at a
+// similarity of 0.3 about 95% of the time and at 0.5 almost always.
+const (
+	bands = 32
+	rows  = 2
+)
+
+// Set is the hashed shingles of a document
+type Set map[uint64]struct{}
+
+// Shingles hashes every run of ShingleSize consecutive words in text,
+// ignoring case and punctuation. Texts shorter than that are one shingle;
+// texts without words have none.
+func Shingles(text string) Set {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	set := make(Set)
+	n := max(len(words)-ShingleSize+1, min(len(words), 1))
+	for i := range n {
+		run := words[i:min(i+ShingleSize, len(words))]
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(run, " ")))
+		set[h.Sum64()] = struct{}{}
+	}
+	return set
+}
+
+// Jaccard returns the share of a and b's shingles that they have in common,
+// from 0 for nothing to 1 for the same shingles
+func Jaccard(a, b Set) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for s := range a {
+		if _, ok := b[s]; ok {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0
+	}
+	return float64(shared) / float64(union)
+}
+
+// Cluster is a group of similar documents, given by their indexes in
+// ascending order. Similarity is the lowest similarity of the pairs in it
+// that were found similar.
+type Cluster struct {
+	Members    []int
+	Similarity float64
+}
+
+// Clusters groups the documents whose shingles are at least threshold
+// similar, directly or through a chain of similar documents. Documents
+// like no other are left out, as are documents without shingles. Pairs are
+// found by locality-sensitive hashing, so a pair near the threshold may be
+// missed, but every pair reported is checked exactly.
+func Clusters(docs []Set, threshold float64) []Cluster {
+	// Bucket documents by each band of their signature
+	buckets := make(map[[rows + 1]uint64][]int)
+	for i, doc := range docs {
+		if len(doc) == 0 {
+			continue
+		}
+		sig := signature(doc)
+		for b := range bands {
+			var key [rows + 1]uint64
+			key[0] = uint64(b)
+			copy(key[1:], sig[b*rows:(b+1)*rows])
+			buckets[key] = append(buckets[key], i)
+		}
+	}
+
+	parent := make([]int, len(docs))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	var similar []pair
+	checked := make(map[[2]int]bool)
+	for _, members := range buckets {
+		for x, i := range members {
+			for _, j := range members[x+1:] {
+				key := [2]int{min(i, j), max(i, j)}
+				if checked[key] {
+					continue
+				}
+				checked[key] = true
+
+				if s := Jaccard(docs[i], docs[j]); s >= threshold {
+					similar = append(similar, pair{i, j, s})
+					parent[find(j)] = find(i)
+				}
+			}
+		}
+	}
+
+	weakest := make(map[int]float64)
+	for _, p := range similar {
+		r := find(p.i)
+		if w, ok := weakest[r]; !ok || p.similarity < w {
+			weakest[r] = p.similarity
+		}
+	}
+
+	groups := make(map[int][]int)
+	for i := range docs {
+		r := find(i)
+		groups[r] = append(groups[r], i)
+	}
+	var clusters []Cluster
+	for r, members := range groups {
+		if len(members) > 1 {
+			clusters = append(clusters, Cluster{Members: members, Similarity: weakest[r]})
+		}
+	}
+	slices.SortFunc(clusters, func(a, b Cluster) int {
+		return a.Members[0] - b.Members[0]
+	})
+	return clusters
+}
+
+// pair is two documents found similar
+type pair struct {
+	i, j       int
+	similarity float64
+}
+
+// signature returns the minimum of each of bands*rows hashes of a set's
+// shingles
+func signature(set Set) [bands * rows]uint64 {
+	var sig [bands * rows]uint64
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for s := range set {
+		for i := range sig {
+			sig[i] = min(sig[i], mix(s^seeds[i]))
+		}
+	}
+	return sig
+}
+
+// seeds varies the hash behind each min-hash
+var seeds = func() [bands * rows]uint64 {
+	var s [bands * rows]uint64
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range s {
+		x = mix(x + uint64(i))
+		s[i] = x
+	}
+	return s
+}()
+
+// mix scrambles the bits of x (the splitmix64 finalizer)
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,50 @@
+package similar
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShingles(t *testing.T) {
+	assert.Len(t, Shingles("The quick brown fox jumps"), 3)
+	assert.Equal(t, Shingles("the quick, BROWN fox"), Shingles("The quick brown fox!"))
+	assert.Len(t, Shingles("hello"), 1, "short texts are a single shingle")
+	assert.Empty(t, Shingles(" -- "))
+}
+
+func TestJaccard(t *testing.T) {
+	a := Shingles("one two three four")
+	assert.Equal(t, 1.0, Jaccard(a, a))
+	assert.Equal(t, 1.0/3, Jaccard(a, Shingles("one two three five")))
+	assert.Equal(t, 0.0, Jaccard(a, Shingles("something else entirely")))
+	assert.Equal(t, 0.0, Jaccard(Set{}, Set{}))
+}
+
+func TestClusters(t *testing.T) {
+	recipe := "Preheat the oven to 200 degrees. Mix the flour, sugar and butter, then bake for twenty minutes until golden."
+	meeting := "Agenda for Monday: review the quarterly numbers, plan the offsite and agree who owns the hiring pipeline."
+
+	docs := []Set{
+		Shingles(recipe),
+		Shingles(meeting),
+		Shingles("Shopping list: eggs, milk and bread"),
+		Shingles(strings.Replace(recipe, "twenty", "twenty five", 1)),
+		Shingles(meeting),
+		Shingles(""),
+		Shingles(recipe + " Serve warm."),
+		Shingles(""),
+	}
+
+	clusters := Clusters(docs, 0.5)
+	if assert.Len(t, clusters, 2) {
+		assert.Equal(t, []int{0, 3, 6}, clusters[0].Members)
+		assert.Less(t, clusters[0].Similarity, 1.0)
+		assert.GreaterOrEqual(t, clusters[0].Similarity, 0.5)
+		assert.Equal(t, []int{1, 4}, clusters[1].Members)
+		assert.Equal(t, 1.0, clusters[1].Similarity)
+	}
+
+	assert.Empty(t, Clusters(docs, 1.01))
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal_PutGetDelete(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	ctx := context.Background()
+
+	err = store.Put(ctx, "attachments/note1/file1", strings.NewReader("hello"), 5, "text/plain")
+	assert.NoError(t, err)
+
+	body, err := store.Get(ctx, "attachments/note1/file1")
+	if err != nil {
+		t.Fatalf("error reading blob: %v", err)
+	}
+	content, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	assert.NoError(t, body.Close())
+	assert.Equal(t, "hello", string(content))
+
+	assert.NoError(t, store.Delete(ctx, "attachments/note1/file1"))
+	_, err = store.Get(ctx, "attachments/note1/file1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Deleting twice is fine
+	assert.NoError(t, store.Delete(ctx, "attachments/note1/file1"))
+}
+
+func TestLocal_RejectsTraversal(t *testing.T) {
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+
+	err = store.Put(context.Background(), "../escape", strings.NewReader("x"), 1, "text/plain")
+	assert.Error(t, err)
+}
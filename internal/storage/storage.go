@@ -0,0 +1,45 @@
+// Package storage provides a blob storage abstraction for user uploads
+// with local-disk and S3 backends
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned when a key does not exist in the store
+var ErrNotFound = errors.New("object not found")
+
+// Storage defines the operations for storing and retrieving blobs by key
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Options selects and configures a storage backend
+type Options struct {
+	// Driver is "local" or "s3"
+	Driver string
+	// LocalDir is the root directory for the local driver
+	LocalDir string
+	// S3Bucket is the bucket for the s3 driver, which uses the standard AWS
+	// credential chain
+	S3Bucket string
+	// S3Endpoint optionally points the s3 driver at an S3-compatible service
+	S3Endpoint string
+}
+
+// Open builds the Storage selected by opts.Driver
+func Open(ctx context.Context, opts Options) (Storage, error) {
+	switch opts.Driver {
+	case "local":
+		return NewLocal(opts.LocalDir)
+	case "s3":
+		return NewS3(ctx, opts.S3Bucket, opts.S3Endpoint)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", opts.Driver)
+	}
+}
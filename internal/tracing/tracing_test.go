@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestSetup_WithoutEndpoint(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Options{ServiceName: "quanta"})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	// Incoming trace context is still propagated
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+	_, isSDK := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+	assert.False(t, isSDK)
+
+	carrier := propagation.HeaderCarrier(http.Header{})
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	assert.Equal(t, header.Get("traceparent"), carrier.Get("traceparent"))
+}
+
+func TestSetup_WithEndpoint(t *testing.T) {
+	// The exporter connects lazily, so no collector needs to be running
+	shutdown, err := Setup(context.Background(), Options{
+		Endpoint:    "http://localhost:4317",
+		ServiceName: "quanta",
+		SampleRatio: 1,
+	})
+	require.NoError(t, err)
+	_, isSDK := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+	assert.True(t, isSDK)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = shutdown(ctx)
+}
@@ -0,0 +1,117 @@
+// Package validate checks request bodies against rules declared in
+// `validate` struct tags and reports problems per field
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Errors maps a field's JSON name to what is wrong with it
+type Errors map[string]string
+
+// Error implements the error interface
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field, msg := range e {
+		fields = append(fields, field+": "+msg)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// Struct trims and validates the tagged string fields of the struct v points
+// to. Supported rules are required, email, min=N and max=N, where N counts
+// characters. A field with an empty tag is only trimmed. Nil pointer fields are treated as absent and skip every rule.
+// Struct returns nil when v is valid.
+func Struct(v any) Errors {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		panic("validate: Struct needs a pointer to a struct")
+	}
+	rv = rv.Elem()
+
+	errs := Errors{}
+	for i := range rv.NumField() {
+		field := rv.Type().Field(i)
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+
+		value := rv.Field(i)
+		if value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.String {
+			panic("validate: " + field.Name + " is not a string")
+		}
+
+		s := strings.TrimSpace(value.String())
+		value.SetString(s)
+
+		if msg := check(s, strings.Split(tag, ",")); msg != "" {
+			errs[jsonName(field)] = msg
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// check applies rules to s in order and returns the first failure
+func check(s string, rules []string) string {
+	for _, rule := range rules {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "":
+		case "required":
+			if s == "" {
+				return "required"
+			}
+		case "email":
+			if s == "" {
+				continue
+			}
+			if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+				return "invalid format"
+			}
+		case "min":
+			if n := mustAtoi(rule, arg); s != "" && utf8.RuneCountInString(s) < n {
+				return fmt.Sprintf("must be at least %d characters", n)
+			}
+		case "max":
+			if n := mustAtoi(rule, arg); utf8.RuneCountInString(s) > n {
+				return fmt.Sprintf("must be at most %d characters", n)
+			}
+		default:
+			panic("validate: unknown rule " + rule)
+		}
+	}
+	return ""
+}
+
+func mustAtoi(rule, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic("validate: bad rule " + rule)
+	}
+	return n
+}
+
+// jsonName returns the name a field is decoded from
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
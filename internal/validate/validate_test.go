@@ -0,0 +1,75 @@
+package validate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type signup struct {
+	Email    string  `json:"email" validate:"required,email"`
+	Password string  `json:"password" validate:"required,min=8"`
+	Name     *string `json:"name" validate:"max=5"`
+	Bio      string  `json:"bio" validate:""`
+	Ignored  string  `json:"ignored"`
+}
+
+func TestStruct(t *testing.T) {
+	long := "Longer than five"
+	short := " Ann "
+
+	testCases := []struct {
+		name     string
+		input    signup
+		expected Errors
+	}{
+		{
+			name:  "Valid",
+			input: signup{Email: "a@example.com", Password: "password123", Name: &short},
+		},
+		{
+			name:     "Missing Fields",
+			input:    signup{Email: "  ", Password: ""},
+			expected: Errors{"email": "required", "password": "required"},
+		},
+		{
+			name:     "Invalid Email",
+			input:    signup{Email: "not-an-email", Password: "password123"},
+			expected: Errors{"email": "invalid format"},
+		},
+		{
+			name:     "Display Name In Email",
+			input:    signup{Email: "Ann <a@example.com>", Password: "password123"},
+			expected: Errors{"email": "invalid format"},
+		},
+		{
+			name:     "Too Short",
+			input:    signup{Email: "a@example.com", Password: " short  "},
+			expected: Errors{"password": "must be at least 8 characters"},
+		},
+		{
+			name:     "Too Long",
+			input:    signup{Email: "a@example.com", Password: "password123", Name: &long},
+			expected: Errors{"name": "must be at most 5 characters"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := tc.input
+			assert.Equal(t, tc.expected, Struct(&input))
+		})
+	}
+}
+
+func TestStructTrims(t *testing.T) {
+	name := "  Ann\n"
+	input := signup{Email: " a@example.com ", Password: " password123 ", Name: &name, Bio: " hi ", Ignored: " kept "}
+
+	assert.Nil(t, Struct(&input))
+	assert.Equal(t, "a@example.com", input.Email)
+	assert.Equal(t, "password123", input.Password)
+	assert.Equal(t, "Ann", *input.Name)
+	assert.Equal(t, "hi", input.Bio)
+	assert.Equal(t, " kept ", input.Ignored)
+}
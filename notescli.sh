@@ -12,7 +12,7 @@ if [[ -z "$NOTE_ID" || -z "$JWT" || -z "$TYPE" ]]; then
   echo "Examples:"
   echo "  ./notescli.sh <note_id> <jwt> edit 'Hello world!'"
   echo "  ./notescli.sh <note_id> <jwt> typing '{\"is_typing\":true}'"
-  echo "  ./notescli.sh <note_id> <jwt> cursor '{\"position\":128}'"
+  echo "  ./notescli.sh <note_id> <jwt> cursor '{\"position\":{\"line\":3,\"offset\":12}}'"
   exit 1
 fi
 
@@ -35,10 +35,18 @@ if [[ -z "$CONTENT" ]]; then
   exit 1
 fi
 
-JSON_PAYLOAD=$(jq -nc \
-  --arg t "$TYPE" \
-  --arg c "$CONTENT" \
-  '{type: $t, content: $c}')
+# cursor messages carry a structured position instead of free-form content
+if [[ "$TYPE" == "cursor" ]]; then
+  JSON_PAYLOAD=$(jq -nc \
+    --arg t "$TYPE" \
+    --argjson c "$CONTENT" \
+    '{type: $t, cursor: $c}')
+else
+  JSON_PAYLOAD=$(jq -nc \
+    --arg t "$TYPE" \
+    --arg c "$CONTENT" \
+    '{type: $t, content: $c}')
+fi
 
 echo "Sending message to note: $NOTE_ID"
 echo "Payload: $JSON_PAYLOAD"
@@ -0,0 +1,265 @@
+// Package client is a Go SDK for the quanta REST API. It covers the
+// endpoints command line tools need: logging in, managing notes, sharing
+// workspaces, following a note's realtime edits and, for administrators,
+// taking and restoring backups.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the API at BaseURL, authenticating with Token when set
+type Client struct {
+	BaseURL string
+	// Token is a JWT from Login or an API key
+	Token      string
+	HTTPClient *http.Client
+}
+
+// New creates a Client for the API at baseURL
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is an error response from the API
+type Error struct {
+	Status    int               `json:"code"`
+	Message   string            `json:"message"`
+	RequestID string            `json:"request_id"`
+	Errors    map[string]string `json:"errors,omitempty"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("%s (%d)", e.Message, e.Status)
+	}
+	fields := make([]string, 0, len(e.Errors))
+	for field, problem := range e.Errors {
+		fields = append(fields, field+": "+problem)
+	}
+	return fmt.Sprintf("%s (%d): %s", e.Message, e.Status, strings.Join(fields, ", "))
+}
+
+// Note is a note as the API returns it
+type Note struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	WorkspaceID *string   `json:"workspace_id"`
+	Title       string    `json:"title"`
+	Content     string    `json:"content"`
+	Pinned      bool      `json:"pinned"`
+	Archived    bool      `json:"archived"`
+	Version     int64     `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ListOptions filters ListNotes
+type ListOptions struct {
+	Archived bool
+	// UpdatedSince, when set, lists only notes updated after it
+	UpdatedSince time.Time
+}
+
+// Invitation is a workspace invitation link
+type Invitation struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Emailed   bool      `json:"emailed"`
+}
+
+// Backup is an archive of the server's database
+type Backup struct {
+	ID string `json:"id"`
+	// Status is running, done or failed
+	Status     string     `json:"status"`
+	StorageKey string     `json:"storage_key"`
+	Size       int64      `json:"size"`
+	Rows       int        `json:"rows"`
+	Encrypted  bool       `json:"encrypted"`
+	Error      *string    `json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// Restore is the progress of restoring a backup. Restored counts the rows
+// put back and Skipped those that were already there.
+type Restore struct {
+	ID string `json:"id"`
+	// BackupID is unset when the archive was given by storage key
+	BackupID   *string `json:"backup_id"`
+	StorageKey string  `json:"storage_key"`
+	// Status is running, done or failed
+	Status     string     `json:"status"`
+	Restored   int        `json:"restored"`
+	Skipped    int        `json:"skipped"`
+	Error      *string    `json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// Login exchanges credentials for a token. It doesn't set c.Token.
+func (c *Client) Login(ctx context.Context, email, password string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	err := c.do(ctx, http.MethodPost, "/login", map[string]string{"email": email, "password": password}, &resp)
+	return resp.Token, err
+}
+
+// ListNotes returns the user's private notes
+func (c *Client) ListNotes(ctx context.Context, opts ListOptions) ([]Note, error) {
+	q := url.Values{}
+	if opts.Archived {
+		q.Set("state", "archived")
+	}
+	if !opts.UpdatedSince.IsZero() {
+		q.Set("updated_since", opts.UpdatedSince.UTC().Format(time.RFC3339))
+	}
+	path := "/notes"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var notes []Note
+	err := c.do(ctx, http.MethodGet, path, nil, &notes)
+	return notes, err
+}
+
+// GetNote returns a note by ID
+func (c *Client) GetNote(ctx context.Context, id string) (Note, error) {
+	var note Note
+	err := c.do(ctx, http.MethodGet, "/notes/"+url.PathEscape(id), nil, &note)
+	return note, err
+}
+
+// CreateNote creates a private note and returns its ID
+func (c *Client) CreateNote(ctx context.Context, title, content string) (string, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+	err := c.do(ctx, http.MethodPost, "/notes", map[string]string{"title": title, "content": content}, &resp)
+	return resp.ID, err
+}
+
+// UpdateNote replaces a note's title and content
+func (c *Client) UpdateNote(ctx context.Context, id, title, content string) error {
+	return c.do(ctx, http.MethodPut, "/notes/"+url.PathEscape(id), map[string]string{"title": title, "content": content}, nil)
+}
+
+// DeleteNote deletes a note
+func (c *Client) DeleteNote(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/notes/"+url.PathEscape(id), nil, nil)
+}
+
+// Invite creates an invitation link to a workspace. When email is set the
+// link is also mailed to that address and only it can redeem the link. An
+// empty role joins invitees as members.
+func (c *Client) Invite(ctx context.Context, workspaceID, email, role string) (Invitation, error) {
+	body := map[string]any{"role": role}
+	if email != "" {
+		body["email"] = email
+	}
+	var inv Invitation
+	err := c.do(ctx, http.MethodPost, "/workspaces/"+url.PathEscape(workspaceID)+"/invites", body, &inv)
+	return inv, err
+}
+
+// ListBackups returns the most recent backups, newest first. It requires
+// an administrator's token.
+func (c *Client) ListBackups(ctx context.Context) ([]Backup, error) {
+	var backups []Backup
+	err := c.do(ctx, http.MethodGet, "/admin/backups", nil, &backups)
+	return backups, err
+}
+
+// CreateBackup starts a backup. It is written in the background; poll
+// ListBackups until its Status is no longer running.
+func (c *Client) CreateBackup(ctx context.Context) (Backup, error) {
+	var b Backup
+	err := c.do(ctx, http.MethodPost, "/admin/backups", nil, &b)
+	return b, err
+}
+
+// RestoreBackup starts putting back the rows of a backup that are missing
+// from the database. Poll GetRestore until its Status is no longer running.
+func (c *Client) RestoreBackup(ctx context.Context, backupID string) (Restore, error) {
+	var r Restore
+	err := c.do(ctx, http.MethodPost, "/admin/restores", map[string]string{"backup_id": backupID}, &r)
+	return r, err
+}
+
+// RestoreArchive is RestoreBackup for an archive given by its key in
+// storage, for when the server lost its list of backups with its database
+func (c *Client) RestoreArchive(ctx context.Context, storageKey string) (Restore, error) {
+	var r Restore
+	err := c.do(ctx, http.MethodPost, "/admin/restores", map[string]string{"storage_key": storageKey}, &r)
+	return r, err
+}
+
+// GetRestore returns the progress of a restore started by RestoreBackup
+func (c *Client) GetRestore(ctx context.Context, id string) (Restore, error) {
+	var r Restore
+	err := c.do(ctx, http.MethodGet, "/admin/restores/"+url.PathEscape(id), nil, &r)
+	return r, err
+}
+
+// do sends a request with an optional JSON body and decodes a JSON response
+// into out, if set. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &Error{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		_ = json.NewDecoder(resp.Body).Decode(apiErr)
+		apiErr.Status = resp.StatusCode
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListNotes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/notes", r.URL.Path)
+		assert.Equal(t, "archived", r.URL.Query().Get("state"))
+		assert.Equal(t, "2026-01-05T09:00:00Z", r.URL.Query().Get("updated_since"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode([]Note{{ID: "note1", Title: "Groceries"}})
+	}))
+	defer srv.Close()
+
+	notes, err := New(srv.URL+"/", "token").ListNotes(context.Background(), ListOptions{
+		Archived:     true,
+		UpdatedSince: time.Date(2026, 1, 5, 10, 0, 0, 0, time.FixedZone("CET", 3600)),
+	})
+	if assert.NoError(t, err) && assert.Len(t, notes, 1) {
+		assert.Equal(t, "Groceries", notes[0].Title)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_, _ = w.Write([]byte(`{"code":422,"message":"Validation failed","errors":{"title":"required"}}`))
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, "token").CreateNote(context.Background(), "", "Milk")
+
+	var apiErr *Error
+	if assert.True(t, errors.As(err, &apiErr)) {
+		assert.Equal(t, http.StatusUnprocessableEntity, apiErr.Status)
+		assert.Equal(t, map[string]string{"title": "required"}, apiErr.Errors)
+		assert.Equal(t, "Validation failed (422): title: required", apiErr.Error())
+	}
+}
+
+func TestDeleteNote_NoContent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		assert.Equal(t, "/notes/note1", r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	assert.NoError(t, New(srv.URL, "token").DeleteNote(context.Background(), "note1"))
+}
+
+func TestRestoreBackup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/admin/restores", r.URL.Path)
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, map[string]string{"backup_id": "backup1"}, body)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(Restore{ID: "restore1", StorageKey: "backups/a.jsonl.gz", Status: "running"})
+	}))
+	defer srv.Close()
+
+	r, err := New(srv.URL, "token").RestoreBackup(context.Background(), "backup1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "restore1", r.ID)
+		assert.Equal(t, "running", r.Status)
+	}
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/fasthttp/websocket"
+)
+
+// Event is a message received on a note's realtime connection. Type is
+// edit, presence, typing, cursor, history and so on; Raw holds the whole
+// message for fields the SDK doesn't decode.
+type Event struct {
+	Type        string          `json:"type"`
+	Rev         int64           `json:"rev"`
+	Content     string          `json:"content"`
+	UserID      string          `json:"user-id"`
+	DisplayName string          `json:"display_name"`
+	Action      string          `json:"action"`
+	Raw         json.RawMessage `json:"-"`
+}
+
+// Dial opens a realtime connection to a note's room, exchanging the token
+// for the one-time ticket the WebSocket endpoint expects. since, when
+// positive, asks the server to replay only edits after that revision.
+func (c *Client) Dial(ctx context.Context, noteID string, since int64) (*websocket.Conn, error) {
+	var ticket struct {
+		Ticket string `json:"ticket"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/ws/ticket", nil, &ticket); err != nil {
+		return nil, fmt.Errorf("requesting websocket ticket: %w", err)
+	}
+
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	u.Path = strings.TrimRight(u.Path, "/") + "/ws/notes/" + url.PathEscape(noteID)
+	q := url.Values{"ticket": {ticket.Ticket}}
+	if since > 0 {
+		q.Set("since", strconv.FormatInt(since, 10))
+	}
+	u.RawQuery = q.Encode()
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		u.RawQuery = ""
+		return nil, fmt.Errorf("connecting to %s: %w", u, err)
+	}
+	return conn, nil
+}
+
+// Watch follows a note's realtime room, calling fn with every message until
+// ctx is done, the connection drops or fn returns an error
+func (c *Client) Watch(ctx context.Context, noteID string, fn func(Event) error) error {
+	conn, err := c.Dial(ctx, noteID, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	// Unblock ReadMessage once the caller is done
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		var ev Event
+		if err := json.Unmarshal(msg, &ev); err != nil {
+			continue
+		}
+		ev.Raw = msg
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package pkg
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MinRSAKeyBits is the smallest RSA key accepted for signing tokens
+const MinRSAKeyBits = 2048
+
+// JWTKey is a key tokens are signed with, named by the kid header. It is
+// either an HMAC secret, signing with HS256, or an RSA or Ed25519 private
+// key, signing with RS256 or EdDSA. Tokens signed with an asymmetric key
+// can be verified by other services from the public half, published as a
+// JSON Web Key Set.
+type JWTKey struct {
+	ID      string
+	Secret  []byte
+	Private crypto.Signer
+}
+
+// Method returns the only algorithm tokens signed with the key may use.
+// Pinning it stops a token from choosing how it is verified, e.g. with alg
+// "none" or an HMAC over a public key.
+func (k JWTKey) Method() jwt.SigningMethod {
+	switch k.Private.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// SigningKey returns the key to pass to jwt.Token.SignedString
+func (k JWTKey) SigningKey() any {
+	if k.Private != nil {
+		return k.Private
+	}
+	return k.Secret
+}
+
+// verificationKey returns the key signatures are checked with
+func (k JWTKey) verificationKey() any {
+	if k.Private != nil {
+		return k.Private.Public()
+	}
+	return k.Secret
+}
+
+// validate checks that the key is usable
+func (k JWTKey) validate() error {
+	switch {
+	case k.ID == "":
+		return errors.New("key IDs cannot be empty")
+	case k.Private == nil && len(k.Secret) == 0:
+		return fmt.Errorf("key %q has no secret or private key", k.ID)
+	case k.Private != nil && len(k.Secret) > 0:
+		return fmt.Errorf("key %q has both a secret and a private key", k.ID)
+	}
+	switch key := k.Private.(type) {
+	case nil, ed25519.PrivateKey:
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < MinRSAKeyBits {
+			return fmt.Errorf("key %q is a %d-bit RSA key, at least %d bits are required", k.ID, key.N.BitLen(), MinRSAKeyBits)
+		}
+	default:
+		return fmt.Errorf("key %q is a %T, only RSA and Ed25519 keys are supported", k.ID, key)
+	}
+	return nil
+}
+
+// JWTKeys are the active signing keys. New tokens are signed with the
+// current key; tokens signed with any active key are accepted, so a key
+// can be rotated by adding a new one, making it current, and removing the
+// old one once the tokens it signed have expired.
+type JWTKeys struct {
+	current JWTKey
+	keys    map[string]JWTKey
+	// order keeps the keys in configuration order
+	order []string
+}
+
+// NewJWTKeys returns the key set keys, signing with the key named current
+func NewJWTKeys(current string, keys ...JWTKey) (*JWTKeys, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+
+	k := &JWTKeys{keys: make(map[string]JWTKey, len(keys))}
+	for _, key := range keys {
+		if err := key.validate(); err != nil {
+			return nil, err
+		}
+		if _, exists := k.keys[key.ID]; exists {
+			return nil, fmt.Errorf("key %q is listed twice", key.ID)
+		}
+		k.keys[key.ID] = key
+		k.order = append(k.order, key.ID)
+		if key.ID == current {
+			k.current = key
+		}
+	}
+	if k.current.ID == "" {
+		return nil, fmt.Errorf("signing key %q is not among the keys", current)
+	}
+
+	return k, nil
+}
+
+// SingleJWTKey returns a key set holding only secret, under the ID
+// "default". secret must not be empty.
+func SingleJWTKey(secret string) *JWTKeys {
+	keys, err := NewJWTKeys("default", JWTKey{ID: "default", Secret: []byte(secret)})
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+// Current returns the key new tokens are signed with
+func (k *JWTKeys) Current() JWTKey {
+	return k.current
+}
+
+// Keyfunc is a jwt.Keyfunc that picks the verification key by the token's
+// kid header and rejects tokens whose algorithm isn't that key's. Tokens
+// issued before key IDs carry none and are checked against every HMAC key.
+func (k *JWTKeys) Keyfunc(token *jwt.Token) (any, error) {
+	kid, hasKid := token.Header["kid"]
+	if !hasKid {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		set := jwt.VerificationKeySet{}
+		for _, id := range k.order {
+			if key := k.keys[id]; key.Private == nil {
+				set.Keys = append(set.Keys, key.Secret)
+			}
+		}
+		return set, nil
+	}
+
+	id, _ := kid.(string)
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %v", kid)
+	}
+	if token.Method != key.Method() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %q", token.Header["alg"], id)
+	}
+	return key.verificationKey(), nil
+}
+
+// ParseJWT verifies a token against the active keys and returns it with
+// its claims
+func (k *JWTKeys) ParseJWT(tokenString string) (*jwt.Token, error) {
+	var methods []string
+	for _, key := range k.keys {
+		if alg := key.Method().Alg(); !slices.Contains(methods, alg) {
+			methods = append(methods, alg)
+		}
+	}
+	return jwt.Parse(tokenString, k.Keyfunc, jwt.WithValidMethods(methods))
+}
+
+// JWK is the public half of a signing key as a JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// N and E are the modulus and exponent of an RSA key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Crv and X are the curve and public key of an Ed25519 key (RFC 8037)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the asymmetric keys, for other services
+// to verify tokens with. HMAC secrets are never included.
+func (k *JWTKeys) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	b64 := base64.RawURLEncoding.EncodeToString
+	for _, id := range k.order {
+		key := k.keys[id]
+		jwk := JWK{Kid: id, Use: "sig", Alg: key.Method().Alg()}
+		switch public := key.verificationKey().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64(public.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = b64(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// ParsePrivateKeyPEM reads an RSA or Ed25519 private key from PEM, in
+// PKCS #8 or, for RSA, PKCS #1 form
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
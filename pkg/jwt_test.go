@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWTKeys(t *testing.T) {
+	keys, err := NewJWTKeys("new",
+		JWTKey{ID: "old", Secret: []byte("old-secret")},
+		JWTKey{ID: "new", Secret: []byte("new-secret")},
+	)
+	if err != nil {
+		t.Fatalf("error creating keys: %v", err)
+	}
+	claims := jwt.MapClaims{"user-id": "user123", "exp": time.Now().Add(time.Hour).Unix()}
+
+	sign := func(method jwt.SigningMethod, kid string, secret any) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(secret)
+		if err != nil {
+			t.Fatalf("error signing token: %v", err)
+		}
+		return signed
+	}
+
+	testCases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{name: "Current Key", token: sign(jwt.SigningMethodHS256, "new", []byte("new-secret")), valid: true},
+		{name: "Previous Key", token: sign(jwt.SigningMethodHS256, "old", []byte("old-secret")), valid: true},
+		{name: "No Key ID", token: sign(jwt.SigningMethodHS256, "", []byte("old-secret")), valid: true},
+		{name: "Wrong Key For ID", token: sign(jwt.SigningMethodHS256, "new", []byte("old-secret"))},
+		{name: "Unknown Key ID", token: sign(jwt.SigningMethodHS256, "retired", []byte("retired-secret"))},
+		{name: "Unknown Secret Without ID", token: sign(jwt.SigningMethodHS256, "", []byte("retired-secret"))},
+		{name: "Other HMAC Algorithm", token: sign(jwt.SigningMethodHS512, "new", []byte("new-secret"))},
+		{name: "Algorithm None", token: sign(jwt.SigningMethodNone, "new", jwt.UnsafeAllowNoneSignatureType)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := keys.ParseJWT(tc.token)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestNewJWTKeys_Invalid(t *testing.T) {
+	_, err := NewJWTKeys("a")
+	assert.EqualError(t, err, "at least one key is required")
+
+	_, err = NewJWTKeys("a", JWTKey{ID: "a"})
+	assert.EqualError(t, err, `key "a" has no secret or private key`)
+
+	_, err = NewJWTKeys("b", JWTKey{ID: "a", Secret: []byte("s")})
+	assert.EqualError(t, err, `signing key "b" is not among the keys`)
+}
+
+func TestJWTKeys_Asymmetric(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %v", err)
+	}
+	keys, err := NewJWTKeys("ed",
+		JWTKey{ID: "hmac", Secret: []byte("secret")},
+		JWTKey{ID: "rsa", Private: rsaKey},
+		JWTKey{ID: "ed", Private: edKey},
+	)
+	if err != nil {
+		t.Fatalf("error creating keys: %v", err)
+	}
+	claims := jwt.MapClaims{"user-id": "user123", "exp": time.Now().Add(time.Hour).Unix()}
+
+	sign := func(method jwt.SigningMethod, kid string, key any) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("error signing token: %v", err)
+		}
+		return signed
+	}
+	rsaPublic := x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)
+
+	testCases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{name: "EdDSA", token: sign(keys.Current().Method(), "ed", keys.Current().SigningKey()), valid: true},
+		{name: "RS256", token: sign(jwt.SigningMethodRS256, "rsa", rsaKey), valid: true},
+		{name: "HS256 Beside Asymmetric Keys", token: sign(jwt.SigningMethodHS256, "hmac", []byte("secret")), valid: true},
+		{name: "HMAC Over RSA Public Key", token: sign(jwt.SigningMethodHS256, "rsa", rsaPublic)},
+		{name: "RSA Key Under Ed25519 ID", token: sign(jwt.SigningMethodRS256, "ed", rsaKey)},
+		{name: "Asymmetric Without Key ID", token: sign(jwt.SigningMethodEdDSA, "", edKey)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := keys.ParseJWT(tc.token)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	jwks := keys.JWKS()
+	if assert.Len(t, jwks.Keys, 2, "HMAC keys must not be published") {
+		assert.Equal(t, JWK{Kty: "RSA", Kid: "rsa", Use: "sig", Alg: "RS256", N: jwks.Keys[0].N, E: "AQAB"}, jwks.Keys[0])
+		assert.Equal(t, "OKP", jwks.Keys[1].Kty)
+		assert.Equal(t, "Ed25519", jwks.Keys[1].Crv)
+		assert.Equal(t, "EdDSA", jwks.Keys[1].Alg)
+		assert.Len(t, jwks.Keys[1].X, 43)
+	}
+}
+
+func TestNewJWTKeys_WeakRSAKey(t *testing.T) {
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("error generating RSA key: %v", err)
+	}
+	_, err = NewJWTKeys("rsa", JWTKey{ID: "rsa", Private: weak})
+	assert.EqualError(t, err, `key "rsa" is a 1024-bit RSA key, at least 2048 bits are required`)
+}
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating Ed25519 key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatalf("error encoding key: %v", err)
+	}
+
+	parsed, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	assert.NoError(t, err)
+	assert.Equal(t, edKey, parsed)
+
+	_, err = ParsePrivateKeyPEM([]byte("not a key"))
+	assert.EqualError(t, err, "no PEM data found")
+}
@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// PasswordVersionBcrypt is the password_version of hashes made before the
+// switch to argon2id. Those are rehashed the next time their user logs in.
+const PasswordVersionBcrypt = 1
+
+// ErrPasswordMismatch is returned by CheckPasswordHash for a wrong password
+var ErrPasswordMismatch = errors.New("password does not match hash")
+
+// Argon2Params tunes argon2id hashing. Version is stored with every hash in
+// users.password_version; raise it whenever the other parameters change so
+// existing hashes are upgraded as their users log in.
+type Argon2Params struct {
+	Version   int
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id
+var DefaultArgon2Params = Argon2Params{
+	Version:   2,
+	Time:      2,
+	MemoryKiB: 19 * 1024,
+	Threads:   1,
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var argon2Params = DefaultArgon2Params
+
+// SetArgon2Params sets the parameters new hashes are made with. It is meant
+// to be called once at startup, before any password is hashed.
+func SetArgon2Params(p Argon2Params) {
+	argon2Params = p
+}
+
+// The startup self-test warns when hashing a password takes longer than
+// SlowHashThreshold, which makes logins sluggish and easy to overload, or
+// less than FastHashThreshold, which makes stolen hashes cheap to crack
+const (
+	SlowHashThreshold = 500 * time.Millisecond
+	FastHashThreshold = 50 * time.Millisecond
+)
+
+// TimeHash measures how long hashing a password takes with the current
+// parameters on this machine
+func TimeHash() (time.Duration, error) {
+	start := time.Now()
+	if _, err := hashArgon2("self-test password", argon2Params); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// HashCostWarning describes what is wrong with a hashing time measured by
+// TimeHash, or returns "" when it is between the thresholds
+func HashCostWarning(took time.Duration) string {
+	switch {
+	case took > SlowHashThreshold:
+		return fmt.Sprintf("password hashing took %s, over %s: lower ARGON2_TIME or ARGON2_MEMORY_KIB to speed up logins",
+			took.Round(time.Millisecond), SlowHashThreshold)
+	case took < FastHashThreshold:
+		return fmt.Sprintf("password hashing took %s, under %s: raise ARGON2_TIME or ARGON2_MEMORY_KIB to resist cracking",
+			took.Round(time.Millisecond), FastHashThreshold)
+	}
+	return ""
+}
+
+// PasswordVersion returns the password_version of hashes made now
+func PasswordVersion() int {
+	return argon2Params.Version
+}
+
+// NeedsRehash reports whether a hash that just verified should be replaced
+// with one made with the current parameters: bcrypt hashes, recognised by
+// their prefix, always are, as are argon2id hashes of an older version
+func NeedsRehash(hash string, version int) bool {
+	return isBcrypt(hash) || version < argon2Params.Version
+}
+
+// isBcrypt reports whether hash is in bcrypt's modular crypt format
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// hashArgon2 hashes password with p, encoding the result in the PHC string
+// format: $argon2id$v=19$m=<KiB>,t=<time>,p=<threads>$<salt>$<key>
+func hashArgon2(password string, p Argon2Params) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.MemoryKiB, p.Threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.MemoryKiB, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// checkArgon2 compares password with an encoded argon2id hash, using the
+// parameters recorded in the hash rather than the current ones
+func checkArgon2(password, hash string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	var p Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.MemoryKiB, &p.Time, &p.Threads); err != nil {
+		return fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return fmt.Errorf("malformed argon2id key: %w", err)
+	}
+
+	got := argon2.IDKey([]byte(password), salt, p.Time, p.MemoryKiB, p.Threads, uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
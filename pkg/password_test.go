@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$"))
+	assert.NoError(t, CheckPasswordHash("correct horse", hash))
+	assert.ErrorIs(t, CheckPasswordHash("battery staple", hash), ErrPasswordMismatch)
+	assert.False(t, NeedsRehash(hash, PasswordVersion()))
+
+	other, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+	assert.NotEqual(t, hash, other, "hashes should be salted")
+}
+
+func TestCheckPasswordHash_Bcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+
+	assert.NoError(t, CheckPasswordHash("correct horse", string(legacy)))
+	assert.ErrorIs(t, CheckPasswordHash("battery staple", string(legacy)), ErrPasswordMismatch)
+	assert.True(t, NeedsRehash(string(legacy), PasswordVersionBcrypt))
+}
+
+func TestCheckPasswordHash_ParameterChange(t *testing.T) {
+	old, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+
+	SetArgon2Params(Argon2Params{Version: 3, Time: 1, MemoryKiB: 8 * 1024, Threads: 2})
+	defer SetArgon2Params(DefaultArgon2Params)
+
+	// Hashes keep verifying with the parameters they were made with
+	assert.NoError(t, CheckPasswordHash("correct horse", old))
+	assert.True(t, NeedsRehash(old, DefaultArgon2Params.Version))
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("error hashing password: %v", err)
+	}
+	assert.Contains(t, hash, "$m=8192,t=1,p=2$")
+	assert.False(t, NeedsRehash(hash, PasswordVersion()))
+}
+
+func TestCheckPasswordHash_Malformed(t *testing.T) {
+	for _, hash := range []string{"", "plaintext", "$argon2id$v=19$m=x$salt$key", "$argon2i$v=19$m=1,t=1,p=1$c2FsdA$a2V5"} {
+		err := CheckPasswordHash("password", hash)
+		assert.Error(t, err, hash)
+		assert.NotErrorIs(t, err, ErrPasswordMismatch, hash)
+	}
+}
+
+func TestHashCostWarning(t *testing.T) {
+	assert.Empty(t, HashCostWarning(200*time.Millisecond))
+	assert.Contains(t, HashCostWarning(time.Second), "lower ARGON2_TIME")
+	assert.Contains(t, HashCostWarning(10*time.Millisecond), "raise ARGON2_TIME")
+
+	took, err := TimeHash()
+	assert.NoError(t, err)
+	assert.Positive(t, took)
+}
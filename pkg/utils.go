@@ -3,21 +3,39 @@
 package pkg
 
 import (
+	"errors"
+	"unicode/utf8"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword securely hashes a plaintext password using bcrypt
-// with the default cost factor for security
+// HashPassword securely hashes a plaintext password using argon2id with the
+// parameters set by SetArgon2Params. Store PasswordVersion alongside it.
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-
-	return string(bytes), nil
+	return hashArgon2(password, argon2Params)
 }
 
-// CheckPasswordHash compares a plaintext password with a hashed password
+// CheckPasswordHash compares a plaintext password with a hashed password,
+// which may be an argon2id hash or a bcrypt one from before the switch. A
+// wrong password gives ErrPasswordMismatch.
 func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if !isBcrypt(hash) {
+		return checkArgon2(password, hash)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrPasswordMismatch
+	}
+	return err
+}
+
+// Truncate shortens s to at most n bytes without splitting a character
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
 }
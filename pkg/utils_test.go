@@ -0,0 +1,14 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "abc", Truncate("abc", 5))
+	assert.Equal(t, "ab", Truncate("abc", 2))
+	// é is two bytes, so cutting inside it drops the whole character
+	assert.Equal(t, "a", Truncate("aé", 2))
+}